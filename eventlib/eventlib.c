@@ -4,6 +4,8 @@
 #include <string.h>
 #include <stdio.h>
 #include <stdarg.h>
+#include <time.h>
+#include <pthread.h>
 
 /*
  * NOTICE TO FLIGHT SOFTWARE ENGINEERS:
@@ -12,6 +14,7 @@
  * - Linked lists with unbounded growth
  * - Variable length string operations
  * - Non-deterministic queue processing times
+ * - A mutex around the queue (callbacks always run outside it)
  *
  * Please maintain a safe distance of at least 100,000km (geostationary orbit).
  * For a flight-certified version, please see eventlib_fixed_pool.c
@@ -21,8 +24,13 @@
 typedef struct event_node
 {
   event_t event;
-  char *source_copy; // Owned copy
-  void *data_copy;   // Owned copy
+  char *source_copy;         // Owned copy
+  char *content_type_copy;   // Owned copy
+  char *trace_parent_copy;   // Owned copy
+  char *event_id_copy;       // Owned copy
+  char *correlation_id_copy; // Owned copy
+  void *metadata_copy;       // Owned copy
+  void *data_copy;           // Owned copy
   struct event_node *next;
 } event_node_t;
 
@@ -37,16 +45,28 @@ typedef enum
 // Main processor structure (internal state)
 struct event_processor
 {
-  // Configuration (immutable after creation)
+  // Configuration (immutable after creation, except max_queue_size,
+  // enable_logging and the watermarks, which are read and written
+  // atomically)
   event_config_t config;
   char *name_copy;
 
-  // Mutable state
+  // Mutable state, guarded by lock
+  pthread_mutex_t lock;
   processor_state_t state;
   event_node_t *queue_head;
   event_node_t *queue_tail;
   size_t queue_size;
   size_t events_processed;
+  size_t events_expired;
+  bool pressured; // Past high_watermark and not yet back to low_watermark
+  size_t queue_high_water;
+  uint64_t events_submitted;
+  uint64_t events_filtered;
+  uint64_t events_rejected;
+  uint64_t events_cleared;
+  uint64_t processing_ns;
+  uint64_t processing_ns_max;
 };
 
 // Helper to get state string
@@ -68,7 +88,7 @@ static const char *state_to_string(processor_state_t state)
 // Helper to log messages
 static void log_message(event_processor_t *proc, const char *level, const char *format, ...)
 {
-  if (!proc->config.enable_logging || !proc->config.on_log)
+  if (!__atomic_load_n(&proc->config.enable_logging, __ATOMIC_RELAXED) || !proc->config.on_log)
   {
     return;
   }
@@ -82,25 +102,314 @@ static void log_message(event_processor_t *proc, const char *level, const char *
   proc->config.on_log(level, buffer, proc->config.user_data);
 }
 
+// Helper to read the wall clock in Unix milliseconds
+static int64_t now_ms(void)
+{
+  struct timespec ts;
+  clock_gettime(CLOCK_REALTIME, &ts);
+  return (int64_t)ts.tv_sec * 1000 + ts.tv_nsec / 1000000;
+}
+
+// Helper to read the wall clock in Unix nanoseconds
+static int64_t now_ns(void)
+{
+  struct timespec ts;
+  clock_gettime(CLOCK_REALTIME, &ts);
+  return (int64_t)ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+// Helper to read the monotonic clock in nanoseconds, for durations
+static int64_t mono_ns(void)
+{
+  struct timespec ts;
+  clock_gettime(CLOCK_MONOTONIC, &ts);
+  return (int64_t)ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+// Pressure transitions reported by pressure_change
+enum
+{
+  PRESSURE_NONE = -1,
+  PRESSURE_LOW = 0,
+  PRESSURE_HIGH = 1
+};
+
+// Helper to update proc->pressured for the current queue size, returning
+// the transition to report. The caller holds the lock.
+static int pressure_change(event_processor_t *proc)
+{
+  size_t high = __atomic_load_n(&proc->config.high_watermark, __ATOMIC_RELAXED);
+  size_t low = __atomic_load_n(&proc->config.low_watermark, __ATOMIC_RELAXED);
+
+  if (!proc->pressured && high > 0 && proc->queue_size >= high)
+  {
+    proc->pressured = true;
+    return PRESSURE_HIGH;
+  }
+  if (proc->pressured && (high == 0 || proc->queue_size <= low))
+  {
+    proc->pressured = false;
+    return PRESSURE_LOW;
+  }
+  return PRESSURE_NONE;
+}
+
+// Helper to report a transition, called without the lock
+static void notify_pressure(event_processor_t *proc, int change, size_t queue_size)
+{
+  if (change == PRESSURE_NONE)
+    return;
+
+  log_message(proc, change == PRESSURE_HIGH ? "WARN" : "INFO",
+              "Queue pressure %s (%zu items)",
+              change == PRESSURE_HIGH ? "high" : "relieved", queue_size);
+  if (proc->config.on_queue_pressure)
+    proc->config.on_queue_pressure(change == PRESSURE_HIGH, queue_size,
+                                   proc->config.user_data);
+}
+
+// Helpers to take the lock from const getters too
+static void lock(const event_processor_t *proc)
+{
+  pthread_mutex_lock((pthread_mutex_t *)&proc->lock);
+}
+
+static void unlock(const event_processor_t *proc)
+{
+  pthread_mutex_unlock((pthread_mutex_t *)&proc->lock);
+}
+
+// Helper to free a queue node
+static void free_node(event_node_t *node)
+{
+  free(node->source_copy);
+  free(node->content_type_copy);
+  free(node->trace_parent_copy);
+  free(node->event_id_copy);
+  free(node->correlation_id_copy);
+  free(node->metadata_copy);
+  free(node->data_copy);
+  free(node);
+}
+
+// Helper to build a queue node holding copies of the event's strings and
+// data. With owns_data the node takes event->data instead of copying it.
+// Returns NULL if an allocation fails, leaving event->data with the caller.
+static event_node_t *new_node(const event_t *event, bool owns_data)
+{
+  const void *data = event->data;
+  size_t data_len = event->data_len;
+
+  event_node_t *node = calloc(1, sizeof(event_node_t));
+  if (!node)
+    return NULL;
+
+  // Set up event
+  node->event = *event;
+  node->event.source = NULL;
+  node->event.data = NULL;
+  node->event.content_type = NULL;
+  node->event.trace_parent = NULL;
+  node->event.event_id = NULL;
+  node->event.correlation_id = NULL;
+  node->event.metadata = NULL;
+  node->event.metadata_len = 0;
+  if (node->event.enqueued_ns == 0)
+    node->event.enqueued_ns = now_ns();
+
+  // Copy source string
+  if (event->source)
+  {
+    node->source_copy = strdup(event->source);
+    if (!node->source_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    node->event.source = node->source_copy;
+  }
+
+  // Copy content type
+  if (event->content_type)
+  {
+    node->content_type_copy = strdup(event->content_type);
+    if (!node->content_type_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    node->event.content_type = node->content_type_copy;
+  }
+
+  // Copy trace parent
+  if (event->trace_parent)
+  {
+    node->trace_parent_copy = strdup(event->trace_parent);
+    if (!node->trace_parent_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    node->event.trace_parent = node->trace_parent_copy;
+  }
+
+  // Copy event ID
+  if (event->event_id)
+  {
+    node->event_id_copy = strdup(event->event_id);
+    if (!node->event_id_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    node->event.event_id = node->event_id_copy;
+  }
+
+  // Copy correlation ID
+  if (event->correlation_id)
+  {
+    node->correlation_id_copy = strdup(event->correlation_id);
+    if (!node->correlation_id_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    node->event.correlation_id = node->correlation_id_copy;
+  }
+
+  // Copy metadata
+  if (event->metadata && event->metadata_len > 0)
+  {
+    node->metadata_copy = malloc(event->metadata_len);
+    if (!node->metadata_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    memcpy(node->metadata_copy, event->metadata, event->metadata_len);
+    node->event.metadata = node->metadata_copy;
+    node->event.metadata_len = event->metadata_len;
+  }
+
+  // Take or copy data - malloc with UNBOUNDED SIZE from user input! hehehehe
+  if (owns_data && data && data_len > 0)
+  {
+    node->data_copy = (void *)data;
+    node->event.data = node->data_copy;
+  }
+  else if (data && data_len > 0)
+  {
+    node->data_copy = malloc(data_len);
+    if (!node->data_copy)
+    {
+      free_node(node);
+      return NULL;
+    }
+    memcpy(node->data_copy, data, data_len);
+    node->event.data = node->data_copy;
+  }
+
+  return node;
+}
+
+// Helper to link a node into the queue; caller holds the lock
+static void enqueue(event_processor_t *proc, event_node_t *node)
+{
+  // FIFO, or nothing queued ahead of this priority: append
+  if (!proc->queue_tail ||
+      proc->config.queue_mode != EVENT_QUEUE_PRIORITY ||
+      proc->queue_tail->event.priority >= node->event.priority)
+  {
+    if (proc->queue_tail)
+      proc->queue_tail->next = node;
+    else
+      proc->queue_head = node;
+    proc->queue_tail = node;
+    return;
+  }
+
+  // Insert after the last node of equal or higher priority
+  if (proc->queue_head->event.priority < node->event.priority)
+  {
+    node->next = proc->queue_head;
+    proc->queue_head = node;
+    return;
+  }
+
+  event_node_t *prev = proc->queue_head;
+  while (prev->next && prev->next->event.priority >= node->event.priority)
+  {
+    prev = prev->next;
+  }
+  node->next = prev->next;
+  prev->next = node;
+}
+
 // Helper to change state
 static void change_state(event_processor_t *proc, processor_state_t new_state)
 {
+  lock(proc);
   if (proc->state == new_state)
+  {
+    unlock(proc);
     return;
+  }
 
   const char *old = state_to_string(proc->state);
   const char *new = state_to_string(new_state);
+  proc->state = new_state;
+  unlock(proc);
 
   log_message(proc, "INFO", "State change: %s -> %s", old, new);
 
-  proc->state = new_state;
-
   if (proc->config.on_state_change)
   {
     proc->config.on_state_change(old, new, proc->config.user_data);
   }
 }
 
+// Library information
+const char *eventlib_version(void)
+{
+  return EVENTLIB_VERSION;
+}
+
+#if defined(__clang__)
+#define EVENTLIB_COMPILER "clang " __clang_version__
+#elif defined(__GNUC__)
+#define EVENTLIB_COMPILER "gcc " __VERSION__
+#else
+#define EVENTLIB_COMPILER "unknown"
+#endif
+
+#if defined(__OPTIMIZE__)
+#define EVENTLIB_OPTIMIZED "optimized"
+#else
+#define EVENTLIB_OPTIMIZED "unoptimized"
+#endif
+
+const char *eventlib_build_flags(void)
+{
+  return "compiler=" EVENTLIB_COMPILER ";build=" EVENTLIB_OPTIMIZED ";threads=pthread;alloc=malloc";
+}
+
+const char *eventlib_strerror(eventlib_error_t err)
+{
+  switch (err)
+  {
+  case EVENTLIB_OK:
+    return "ok";
+  case EVENTLIB_ERR_INVALID:
+    return "invalid argument";
+  case EVENTLIB_ERR_QUEUE_FULL:
+    return "queue full";
+  case EVENTLIB_ERR_NOMEM:
+    return "out of memory";
+  default:
+    return "unknown error";
+  }
+}
+
 // Create processor
 event_processor_t *event_processor_create(const event_config_t *config)
 {
@@ -120,11 +429,26 @@ event_processor_t *event_processor_create(const event_config_t *config)
   }
 
   // Initialize state
+  if (pthread_mutex_init(&proc->lock, NULL) != 0)
+  {
+    free(proc->name_copy);
+    free(proc);
+    return NULL;
+  }
   proc->state = STATE_IDLE;
   proc->queue_head = NULL;
   proc->queue_tail = NULL;
   proc->queue_size = 0;
   proc->events_processed = 0;
+  proc->events_expired = 0;
+  proc->pressured = false;
+  proc->queue_high_water = 0;
+  proc->events_submitted = 0;
+  proc->events_filtered = 0;
+  proc->events_rejected = 0;
+  proc->events_cleared = 0;
+  proc->processing_ns = 0;
+  proc->processing_ns_max = 0;
 
   log_message(proc, "INFO", "Event processor '%s' created",
               proc->config.name ? proc->config.name : "unnamed");
@@ -147,6 +471,7 @@ void event_processor_destroy(event_processor_t *proc)
   // Free name
   free(proc->name_copy);
 
+  pthread_mutex_destroy(&proc->lock);
   free(proc);
 }
 
@@ -157,85 +482,144 @@ bool event_processor_push(event_processor_t *proc,
                           const void *data,
                           size_t data_len)
 {
-  if (!proc)
-    return false;
+  event_t event = {
+      .type = type,
+      .source = source,
+      .data = data,
+      .data_len = data_len,
+  };
+  return event_processor_push_event(proc, &event);
+}
 
-  // Check queue size
-  if (proc->config.max_queue_size > 0 &&
-      proc->queue_size >= proc->config.max_queue_size)
-  {
-    log_message(proc, "WARN", "Queue full (%zu items)", proc->queue_size);
-    return false;
-  }
+// Push fully described event to queue
+bool event_processor_push_event(event_processor_t *proc, const event_t *event)
+{
+  return event_processor_submit(proc, event) == EVENTLIB_OK;
+}
 
-  // Create event node
-  event_node_t *node = calloc(1, sizeof(event_node_t));
-  if (!node)
-    return false;
+// Queue an event, copying its strings. The data is copied too, unless
+// owns_data is set, in which case the node takes the caller's malloc'd
+// buffer on success.
+static eventlib_error_t submit(event_processor_t *proc, const event_t *event, bool owns_data)
+{
+  if (!proc || !event)
+    return EVENTLIB_ERR_INVALID;
 
-  // Set up event
-  node->event.type = type;
-  node->event.data_len = data_len;
+  event_type_t type = event->type;
 
-  // Copy source string
-  if (source)
-  {
-    node->source_copy = strdup(source);
-    node->event.source = node->source_copy;
-  }
+  // Check queue size before doing any copying
+  size_t max_queue_size = __atomic_load_n(&proc->config.max_queue_size, __ATOMIC_RELAXED);
+  lock(proc);
+  size_t queue_size = proc->queue_size;
+  bool full = max_queue_size > 0 && queue_size >= max_queue_size;
+  if (full)
+    proc->events_rejected++;
+  unlock(proc);
 
-  // Copy data - malloc with UNBOUNDED SIZE from user input! hehehehe
-  if (data && data_len > 0)
+  if (full)
   {
-    node->data_copy = malloc(data_len);
-    if (node->data_copy)
-    {
-      memcpy(node->data_copy, data, data_len);
-      node->event.data = node->data_copy;
-    }
+    log_message(proc, "WARN", "Queue full (%zu items)", queue_size);
+    return EVENTLIB_ERR_QUEUE_FULL;
   }
 
+  event_node_t *node = new_node(event, owns_data);
+  if (!node)
+    return EVENTLIB_ERR_NOMEM;
+
   // Apply filter if configured
   if (proc->config.on_filter)
   {
     if (!proc->config.on_filter(&node->event, proc->config.user_data))
     {
       log_message(proc, "DEBUG", "Event filtered out");
-      free(node->source_copy);
-      free(node->data_copy);
-      free(node);
-      return true; // Successfully "processed" by filtering
+      lock(proc);
+      proc->events_filtered++;
+      unlock(proc);
+      free_node(node);
+      return EVENTLIB_OK; // Successfully "processed" by filtering
     }
   }
 
-  // Add to queue
-  if (proc->queue_tail)
+  // Add to queue, re-checking the limit now that we hold the lock
+  lock(proc);
+  max_queue_size = __atomic_load_n(&proc->config.max_queue_size, __ATOMIC_RELAXED);
+  if (max_queue_size > 0 && proc->queue_size >= max_queue_size)
   {
-    proc->queue_tail->next = node;
-    proc->queue_tail = node;
-  }
-  else
-  {
-    proc->queue_head = proc->queue_tail = node;
+    queue_size = proc->queue_size;
+    proc->events_rejected++;
+    unlock(proc);
+    log_message(proc, "WARN", "Queue full (%zu items)", queue_size);
+    // A failed submit leaves owned data with the caller
+    if (owns_data)
+      node->data_copy = NULL;
+    free_node(node);
+    return EVENTLIB_ERR_QUEUE_FULL;
   }
 
-  proc->queue_size++;
+  enqueue(proc, node);
+
+  queue_size = ++proc->queue_size;
+  proc->events_submitted++;
+  if (queue_size > proc->queue_high_water)
+    proc->queue_high_water = queue_size;
+  int change = pressure_change(proc);
+  unlock(proc);
+
+  notify_pressure(proc, change, queue_size);
+
   log_message(proc, "DEBUG", "Event queued (type=%d, queue_size=%zu)",
-              type, proc->queue_size);
+              type, queue_size);
 
-  return true;
+  return EVENTLIB_OK;
 }
 
-// Process single event
-void event_processor_process(event_processor_t *proc)
+// Push fully described event to queue, reporting the failure reason
+eventlib_error_t event_processor_submit(event_processor_t *proc, const event_t *event)
 {
-  if (!proc || !proc->queue_head)
-    return;
+  return submit(proc, event, false);
+}
+
+// Push an event whose malloc'd data the queue takes over on success
+eventlib_error_t event_processor_submit_owned(event_processor_t *proc, const event_t *event)
+{
+  return submit(proc, event, true);
+}
+
+// Push several events, reporting a result for each
+size_t event_processor_submit_batch(event_processor_t *proc,
+                                    const event_t *events, size_t count,
+                                    eventlib_error_t *results)
+{
+  size_t accepted = 0;
+
+  for (size_t i = 0; i < count; i++)
+  {
+    eventlib_error_t err = events ? event_processor_submit(proc, &events[i])
+                                  : EVENTLIB_ERR_INVALID;
+    if (err == EVENTLIB_OK)
+      accepted++;
+    if (results)
+      results[i] = err;
+  }
+
+  return accepted;
+}
+
+// Helper to process one event, returning false if nothing was processed
+static bool process_one(event_processor_t *proc)
+{
+  lock(proc);
+  if (!proc->queue_head)
+  {
+    unlock(proc);
+    return false;
+  }
 
   if (proc->state != STATE_RUNNING)
   {
+    unlock(proc);
     log_message(proc, "WARN", "Processor not running");
-    return;
+    return false;
   }
 
   // Remove from queue
@@ -246,33 +630,79 @@ void event_processor_process(event_processor_t *proc)
     proc->queue_tail = NULL;
   }
   proc->queue_size--;
+  size_t queue_size = proc->queue_size;
+  int change = pressure_change(proc);
+  unlock(proc);
 
-  // Process event (side effect)
-  log_message(proc, "DEBUG", "Processing event (type=%d)", node->event.type);
+  notify_pressure(proc, change, queue_size);
 
-  if (proc->config.on_event)
+  int64_t started = mono_ns();
+
+  // Expire events whose deadline has passed instead of handling them late
+  bool expired = node->event.deadline_ms > 0 && now_ms() > node->event.deadline_ms;
+  if (expired)
   {
-    proc->config.on_event(&node->event, proc->config.user_data);
+    log_message(proc, "DEBUG", "Event expired (type=%d)", node->event.type);
+
+    if (proc->config.on_expired)
+    {
+      proc->config.on_expired(&node->event, proc->config.user_data);
+    }
+  }
+  else
+  {
+    // Process event (side effect)
+    log_message(proc, "DEBUG", "Processing event (type=%d)", node->event.type);
+
+    if (proc->config.on_event)
+    {
+      proc->config.on_event(&node->event, proc->config.user_data);
+    }
   }
 
-  proc->events_processed++;
+  uint64_t elapsed = (uint64_t)(mono_ns() - started);
+
+  lock(proc);
+  if (expired)
+    proc->events_expired++;
+  else
+    proc->events_processed++;
+  proc->processing_ns += elapsed;
+  if (elapsed > proc->processing_ns_max)
+    proc->processing_ns_max = elapsed;
+  unlock(proc);
 
   // Cleanup
-  free(node->source_copy);
-  free(node->data_copy);
-  free(node);
+  free_node(node);
+  return true;
+}
+
+// Process single event
+void event_processor_process(event_processor_t *proc)
+{
+  if (!proc)
+    return;
+
+  process_one(proc);
 }
 
 // Process all events
 void event_processor_process_all(event_processor_t *proc)
+{
+  event_processor_process_all_until(proc, NULL);
+}
+
+// Process all events, stopping early once cancel is set
+size_t event_processor_process_all_until(event_processor_t *proc,
+                                         const volatile int *cancel)
 {
   if (!proc)
-    return;
+    return 0;
 
   size_t count = 0;
-  while (proc->queue_head)
+  while (!(cancel && __atomic_load_n(cancel, __ATOMIC_ACQUIRE)) &&
+         process_one(proc))
   {
-    event_processor_process(proc);
     count++;
   }
 
@@ -280,22 +710,104 @@ void event_processor_process_all(event_processor_t *proc)
   {
     log_message(proc, "INFO", "Processed %zu events", count);
   }
+  if (cancel && __atomic_load_n(cancel, __ATOMIC_ACQUIRE))
+  {
+    log_message(proc, "DEBUG", "Processing cancelled");
+  }
+
+  return count;
+}
+
+// Process up to max events, stopping early once cancel is set
+size_t event_processor_process_n(event_processor_t *proc, size_t max,
+                                 const volatile int *cancel)
+{
+  if (!proc)
+    return 0;
+
+  size_t count = 0;
+  while (count < max &&
+         !(cancel && __atomic_load_n(cancel, __ATOMIC_ACQUIRE)) &&
+         process_one(proc))
+  {
+    count++;
+  }
+  return count;
 }
 
 // State getters
 const char *event_processor_get_state(const event_processor_t *proc)
 {
-  return proc ? state_to_string(proc->state) : "INVALID";
+  if (!proc)
+    return "INVALID";
+
+  lock(proc);
+  const char *state = state_to_string(proc->state);
+  unlock(proc);
+  return state;
 }
 
 size_t event_processor_queue_size(const event_processor_t *proc)
 {
-  return proc ? proc->queue_size : 0;
+  if (!proc)
+    return 0;
+
+  lock(proc);
+  size_t size = proc->queue_size;
+  unlock(proc);
+  return size;
 }
 
 size_t event_processor_events_processed(const event_processor_t *proc)
 {
-  return proc ? proc->events_processed : 0;
+  if (!proc)
+    return 0;
+
+  lock(proc);
+  size_t count = proc->events_processed;
+  unlock(proc);
+  return count;
+}
+
+size_t event_processor_events_expired(const event_processor_t *proc)
+{
+  if (!proc)
+    return 0;
+
+  lock(proc);
+  size_t count = proc->events_expired;
+  unlock(proc);
+  return count;
+}
+
+// Snapshot every counter under one lock, so they agree with each other
+void event_processor_get_stats(const event_processor_t *proc, event_processor_stats_t *stats)
+{
+  if (!stats)
+    return;
+  memset(stats, 0, sizeof(*stats));
+  if (!proc)
+  {
+    stats->state = "INVALID";
+    return;
+  }
+
+  stats->max_queue_size = __atomic_load_n(&proc->config.max_queue_size, __ATOMIC_RELAXED);
+
+  lock(proc);
+  stats->state = state_to_string(proc->state);
+  stats->queue_size = proc->queue_size;
+  stats->queue_high_water = proc->queue_high_water;
+  stats->pressured = proc->pressured;
+  stats->events_submitted = proc->events_submitted;
+  stats->events_processed = proc->events_processed;
+  stats->events_expired = proc->events_expired;
+  stats->events_filtered = proc->events_filtered;
+  stats->events_rejected = proc->events_rejected;
+  stats->events_cleared = proc->events_cleared;
+  stats->processing_ns = proc->processing_ns;
+  stats->processing_ns_max = proc->processing_ns_max;
+  unlock(proc);
 }
 
 // Control functions
@@ -318,23 +830,198 @@ void event_processor_clear_queue(event_processor_t *proc)
   if (!proc)
     return;
 
+  // Detach the whole list under the lock, then free it outside
+  lock(proc);
+  event_node_t *head = proc->queue_head;
+  proc->queue_head = NULL;
+  proc->queue_tail = NULL;
+  proc->events_cleared += proc->queue_size;
+  proc->queue_size = 0;
+  int change = pressure_change(proc);
+  unlock(proc);
+
+  notify_pressure(proc, change, 0);
+
   size_t cleared = 0;
-  while (proc->queue_head)
+  while (head)
   {
-    event_node_t *node = proc->queue_head;
-    proc->queue_head = node->next;
+    event_node_t *node = head;
+    head = node->next;
 
-    free(node->source_copy);
-    free(node->data_copy);
-    free(node);
+    free_node(node);
     cleared++;
   }
 
-  proc->queue_tail = NULL;
-  proc->queue_size = 0;
-
   if (cleared > 0)
   {
     log_message(proc, "INFO", "Cleared %zu events from queue", cleared);
   }
-}
\ No newline at end of file
+}
+
+// Helper to visit copies of the first max queued events (0 = all). The
+// queue is copied under the lock and visited outside it.
+static eventlib_error_t visit_copies(const event_processor_t *proc, size_t max,
+                                     on_event_cb visit, void *user_data, size_t *visited_out)
+{
+  event_node_t *head = NULL;
+  event_node_t **tail = &head;
+  bool failed = false;
+  size_t copied = 0;
+
+  lock(proc);
+  for (event_node_t *node = proc->queue_head; node && (max == 0 || copied < max); node = node->next)
+  {
+    event_node_t *copy = new_node(&node->event, false);
+    if (!copy)
+    {
+      failed = true;
+      break;
+    }
+    *tail = copy;
+    tail = &copy->next;
+    copied++;
+  }
+  unlock(proc);
+
+  size_t visited = 0;
+  while (head)
+  {
+    event_node_t *node = head;
+    head = node->next;
+
+    if (!failed)
+    {
+      visit(&node->event, user_data);
+      visited++;
+    }
+    free_node(node);
+  }
+
+  if (failed)
+  {
+    log_message((event_processor_t *)proc, "ERROR", "Out of memory copying the queue");
+    return EVENTLIB_ERR_NOMEM;
+  }
+
+  *visited_out = visited;
+  return EVENTLIB_OK;
+}
+
+eventlib_error_t event_processor_snapshot(const event_processor_t *proc,
+                                          on_event_cb visit, void *user_data)
+{
+  if (!proc || !visit)
+    return EVENTLIB_ERR_INVALID;
+
+  size_t visited = 0;
+  eventlib_error_t err = visit_copies(proc, 0, visit, user_data, &visited);
+  if (err == EVENTLIB_OK)
+  {
+    log_message((event_processor_t *)proc, "DEBUG", "Snapshot visited %zu events", visited);
+  }
+  return err;
+}
+
+eventlib_error_t event_processor_peek(const event_processor_t *proc, size_t max,
+                                      on_event_cb visit, void *user_data)
+{
+  if (!proc || !visit)
+    return EVENTLIB_ERR_INVALID;
+
+  size_t visited = 0;
+  return visit_copies(proc, max, visit, user_data, &visited);
+}
+
+size_t event_processor_purge(event_processor_t *proc, on_filter_cb match,
+                             on_event_cb removed, void *user_data)
+{
+  if (!proc || !match)
+    return 0;
+
+  // Unlink matching nodes under the lock, then report and free them
+  // outside it
+  event_node_t *purged = NULL;
+  event_node_t **purged_tail = &purged;
+  event_node_t *last_kept = NULL;
+  size_t count = 0;
+
+  lock(proc);
+  event_node_t **link = &proc->queue_head;
+  while (*link)
+  {
+    event_node_t *node = *link;
+    if (match(&node->event, user_data))
+    {
+      *link = node->next;
+      node->next = NULL;
+      *purged_tail = node;
+      purged_tail = &node->next;
+      count++;
+      continue;
+    }
+    last_kept = node;
+    link = &node->next;
+  }
+  proc->queue_tail = last_kept;
+  proc->queue_size -= count;
+  proc->events_cleared += count;
+  size_t queue_size = proc->queue_size;
+  int change = pressure_change(proc);
+  unlock(proc);
+
+  notify_pressure(proc, change, queue_size);
+
+  while (purged)
+  {
+    event_node_t *node = purged;
+    purged = node->next;
+
+    if (removed)
+      removed(&node->event, user_data);
+    free_node(node);
+  }
+
+  if (count > 0)
+  {
+    log_message(proc, "INFO", "Purged %zu events from queue", count);
+  }
+  return count;
+}
+
+void event_processor_set_max_queue_size(event_processor_t *proc, size_t max_queue_size)
+{
+  if (!proc)
+    return;
+  __atomic_store_n(&proc->config.max_queue_size, max_queue_size, __ATOMIC_RELAXED);
+  log_message(proc, "INFO", "Queue limit set to %zu", max_queue_size);
+}
+
+size_t event_processor_max_queue_size(const event_processor_t *proc)
+{
+  if (!proc)
+    return 0;
+  return __atomic_load_n(&proc->config.max_queue_size, __ATOMIC_RELAXED);
+}
+
+void event_processor_set_logging(event_processor_t *proc, bool enabled)
+{
+  if (!proc)
+    return;
+  __atomic_store_n(&proc->config.enable_logging, enabled, __ATOMIC_RELAXED);
+}
+
+void event_processor_set_watermarks(event_processor_t *proc,
+                                    size_t high_watermark, size_t low_watermark)
+{
+  if (!proc)
+    return;
+  __atomic_store_n(&proc->config.high_watermark, high_watermark, __ATOMIC_RELAXED);
+  __atomic_store_n(&proc->config.low_watermark, low_watermark, __ATOMIC_RELAXED);
+
+  lock(proc);
+  size_t queue_size = proc->queue_size;
+  int change = pressure_change(proc);
+  unlock(proc);
+
+  notify_pressure(proc, change, queue_size);
+}
@@ -4,6 +4,10 @@
 
 #include <stdbool.h>
 #include <stddef.h>
+#include <stdint.h>
+
+// Library version
+#define EVENTLIB_VERSION "0.2.0"
 
 // Forward declarations
 typedef struct event_processor event_processor_t;
@@ -13,15 +17,46 @@ typedef enum {
   EVENT_TYPE_DATA,
   EVENT_TYPE_CONNECT,
   EVENT_TYPE_DISCONNECT,
-  EVENT_TYPE_ERROR
+  EVENT_TYPE_ERROR,
+  EVENT_TYPE_CUSTOM_BASE = 1024 // Application-defined types start here; carried through unchanged
 } event_type_t;
 
+// Queue ordering
+typedef enum {
+  EVENT_QUEUE_FIFO,    // Strict arrival order
+  EVENT_QUEUE_PRIORITY // Highest priority first, arrival order within a priority
+} event_queue_mode_t;
+
+// Error codes returned by functions that can fail for more than one reason
+typedef enum {
+  EVENTLIB_OK = 0,
+  EVENTLIB_ERR_INVALID = -1,    // NULL processor or event
+  EVENTLIB_ERR_QUEUE_FULL = -2, // max_queue_size reached
+  EVENTLIB_ERR_NOMEM = -3       // Allocation failed
+} eventlib_error_t;
+
+// Event flags (opaque to the library, carried through to callbacks)
+#define EVENT_FLAG_BACKFILL 0x1u // Historical event, not live traffic
+
 // Event structure
 typedef struct {
   event_type_t type;
   const char *source;
   const void *data;
   size_t data_len;
+  int64_t deadline_ms; // Unix time in ms after which the event expires (0 = never)
+  int64_t timestamp_ms; // Unix time in ms when the event originally occurred (0 = unset)
+  uint32_t flags;       // EVENT_FLAG_* bits
+  uint64_t id;          // Caller-assigned identifier, carried through to callbacks (0 = unset)
+  int32_t priority;     // Higher is processed first in EVENT_QUEUE_PRIORITY mode
+  const char *content_type; // MIME type of data (NULL = unspecified)
+  int64_t enqueued_ns;  // Unix time in ns when the event was pushed (0 = stamped on submit)
+  const char *trace_parent; // W3C traceparent of the pushing span (NULL = untraced)
+  uint32_t attempt;     // Earlier failed deliveries, carried through to callbacks
+  const char *event_id; // Producer-assigned identity, e.g. for deduplication (NULL = unset)
+  const char *correlation_id; // Shared by the events of one workflow (NULL = unset)
+  const void *metadata; // Opaque key/value blob, copied and carried through (NULL = none)
+  size_t metadata_len;
 } event_t;
 
 // Callback function types (these are your side effects)
@@ -31,6 +66,9 @@ typedef void (*on_log_cb)(const char *level, const char *message,
 typedef bool (*on_filter_cb)(const event_t *event, void *user_data);
 typedef void (*on_state_change_cb)(const char *old_state, const char *new_state,
                                    void *user_data);
+typedef void (*on_expired_cb)(const event_t *event, void *user_data);
+typedef void (*on_queue_pressure_cb)(bool high, size_t queue_size,
+                                     void *user_data);
 
 // Configuration structure
 typedef struct {
@@ -38,18 +76,51 @@ typedef struct {
   const char *name;
   size_t max_queue_size;
   bool enable_logging;
+  event_queue_mode_t queue_mode;
 
   // Callback functions
   on_event_cb on_event;
   on_log_cb on_log;
   on_filter_cb on_filter; // Return false to drop event
   on_state_change_cb on_state_change;
+  on_expired_cb on_expired; // Called instead of on_event past the deadline
+
+  // Called with high = true when the queue grows to high_watermark, then
+  // with high = false once it shrinks to low_watermark (0 = off)
+  on_queue_pressure_cb on_queue_pressure;
+  size_t high_watermark;
+  size_t low_watermark;
 
   // User data passed to callbacks
   void *user_data;
 } event_config_t;
 
+// Counters reported by event_processor_get_stats
+typedef struct {
+  const char *state; // Static string, as from event_processor_get_state
+  size_t queue_size;
+  size_t queue_high_water; // Largest queue_size seen
+  size_t max_queue_size;
+  bool pressured;            // Past high_watermark, not yet back to low
+  uint64_t events_submitted; // Queued, not counting filtered events
+  uint64_t events_processed;
+  uint64_t events_expired;
+  uint64_t events_filtered;  // Dropped by on_filter
+  uint64_t events_rejected;  // Refused because the queue was full
+  uint64_t events_cleared;   // Discarded by event_processor_clear_queue or _purge
+  uint64_t processing_ns;    // Total time spent in on_event and on_expired
+  uint64_t processing_ns_max; // Longest single callback
+} event_processor_stats_t;
+
 // API Functions
+//
+// All functions are safe to call from multiple threads. Callbacks run
+// without any internal lock held, so they may call back into the processor.
+
+// Library information
+const char *eventlib_version(void);
+const char *eventlib_build_flags(void); // Compiler and feature summary
+const char *eventlib_strerror(eventlib_error_t err);
 
 // Create and destroy processor
 event_processor_t *event_processor_create(const event_config_t *config);
@@ -59,17 +130,88 @@ bool event_processor_push(event_processor_t *processor, event_type_t type,
                           const char *source, const void *data,
                           size_t data_len);
 
+// Push a fully described event; source, data, content_type and trace_parent are copied
+bool event_processor_push_event(event_processor_t *processor,
+                                const event_t *event);
+
+// Like event_processor_push_event, but reports why a push failed. A
+// filtered event counts as accepted and returns EVENTLIB_OK.
+eventlib_error_t event_processor_submit(event_processor_t *processor,
+                                        const event_t *event);
+
+// Like event_processor_submit, but the data is not copied: event->data
+// must come from malloc, and on EVENTLIB_OK the processor owns and frees
+// it, even if the event is filtered. On any other result the caller still
+// owns it. Strings are copied as usual.
+eventlib_error_t event_processor_submit_owned(event_processor_t *processor,
+                                              const event_t *event);
+
+// Submit count events in one call. If results is not NULL it receives one
+// code per event. Returns the number of events accepted.
+size_t event_processor_submit_batch(event_processor_t *processor,
+                                    const event_t *events, size_t count,
+                                    eventlib_error_t *results);
+
 void event_processor_process(event_processor_t *processor);
 void event_processor_process_all(event_processor_t *processor);
 
+// Process events until the queue is empty or *cancel becomes non-zero.
+// The flag is checked between events, so the event in flight always
+// completes. Returns the number of events taken off the queue.
+size_t event_processor_process_all_until(event_processor_t *processor,
+                                         const volatile int *cancel);
+
+// Process up to max events, stopping early once the queue is empty or
+// *cancel, if cancel is not NULL, becomes non-zero. Returns the number of
+// events taken off the queue. One call stands in for max calls to
+// event_processor_process, for callers paying a cost per call.
+size_t event_processor_process_n(event_processor_t *processor, size_t max,
+                                 const volatile int *cancel);
+
 // State management
 const char *event_processor_get_state(const event_processor_t *processor);
 size_t event_processor_queue_size(const event_processor_t *processor);
 size_t event_processor_events_processed(const event_processor_t *processor);
+size_t event_processor_events_expired(const event_processor_t *processor);
+
+// Fill stats with a consistent snapshot of every counter
+void event_processor_get_stats(const event_processor_t *processor,
+                               event_processor_stats_t *stats);
 
 // Control functions
 void event_processor_start(event_processor_t *processor);
 void event_processor_stop(event_processor_t *processor);
 void event_processor_clear_queue(event_processor_t *processor);
 
+// Call visit with a copy of every queued event, in processing order. The
+// queue is copied under the lock and visited outside it, so visit may call
+// back into the processor. Nothing is visited if copying fails.
+eventlib_error_t event_processor_snapshot(const event_processor_t *processor,
+                                          on_event_cb visit, void *user_data);
+
+// Like event_processor_snapshot, but visits at most the first max events
+// (0 = all), for looking at the head of a long queue
+eventlib_error_t event_processor_peek(const event_processor_t *processor, size_t max,
+                                      on_event_cb visit, void *user_data);
+
+// Remove every queued event for which match returns true, then call
+// removed (if not NULL) with each one, in processing order, before freeing
+// it. match runs with the queue lock held, so unlike other callbacks it
+// must not call back into the processor; removed runs outside the lock.
+// Removed events are counted in events_cleared. Returns how many were
+// removed.
+size_t event_processor_purge(event_processor_t *processor, on_filter_cb match,
+                             on_event_cb removed, void *user_data);
+
+// Runtime tuning of settings from event_config_t. A smaller queue limit
+// does not drop events already queued; it only refuses new ones.
+void event_processor_set_max_queue_size(event_processor_t *processor, size_t max_queue_size);
+size_t event_processor_max_queue_size(const event_processor_t *processor);
+void event_processor_set_logging(event_processor_t *processor, bool enabled);
+
+// Change the watermarks; on_queue_pressure fires at once if the queue
+// size is already past the new ones
+void event_processor_set_watermarks(event_processor_t *processor,
+                                    size_t high_watermark, size_t low_watermark);
+
 #endif // EVENTLIB_H
\ No newline at end of file
@@ -0,0 +1,291 @@
+package eventlib
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// asyncBatchSize caps how many buffered events a worker hands to C at once
+const asyncBatchSize = 256
+
+// OverflowPolicy decides what an asynchronous Push does when the buffer is
+// full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the buffer
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest buffered event to make room
+	OverflowDropOldest
+
+	// OverflowReject fails the Push with ErrBufferFull
+	OverflowReject
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseOverflowPolicy parses "block", "drop-oldest" or "reject"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowReject} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// ErrBufferFull is returned by an asynchronous Push under OverflowReject.
+// It matches ErrQueueFull with errors.Is.
+var ErrBufferFull = fmt.Errorf("async buffer is full: %w", ErrQueueFull)
+
+// ErrDroppedOldest is passed to AsyncConfig.OnError for events discarded
+// under OverflowDropOldest
+var ErrDroppedOldest = fmt.Errorf("dropped to make room in async buffer: %w", ErrQueueFull)
+
+// AsyncConfig makes Push return as soon as an event is buffered in Go;
+// background workers move buffered events into the C queue
+type AsyncConfig struct {
+	BufferSize int // Default 1024
+	Workers    int // Default 1
+	Overflow   OverflowPolicy
+
+	// OnError, if set, receives events that were accepted by Push but could
+	// not be queued, since Push has already returned
+	OnError func(event Event, err error)
+}
+
+type asyncItem struct {
+	event Event
+	id    uint64
+	key   string // Dedup key, if any
+}
+
+// asyncPusher is the Go-side buffer and its workers
+type asyncPusher struct {
+	ep     *EventProcessor
+	config AsyncConfig
+	buffer chan asyncItem
+
+	// sendMu is held for reading around sends so close can wait them out
+	sendMu sync.RWMutex
+	closed bool
+
+	// pending counts buffered and in-flight events, for Flush
+	pendingMu sync.Mutex
+	pending   int
+	idle      *sync.Cond
+
+	workers sync.WaitGroup
+}
+
+func newAsyncPusher(ep *EventProcessor, config AsyncConfig) *asyncPusher {
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1024
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+
+	a := &asyncPusher{
+		ep:     ep,
+		config: config,
+		buffer: make(chan asyncItem, config.BufferSize),
+	}
+	a.idle = sync.NewCond(&a.pendingMu)
+
+	for i := 0; i < config.Workers; i++ {
+		a.workers.Add(1)
+		go a.run()
+	}
+	return a
+}
+
+// enqueue buffers an event according to the overflow policy. Under
+// OverflowBlock it gives up when ctx is done.
+func (a *asyncPusher) enqueue(ctx context.Context, item asyncItem) error {
+	a.sendMu.RLock()
+	defer a.sendMu.RUnlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+
+	a.addPending(1)
+
+	switch a.config.Overflow {
+	case OverflowReject:
+		select {
+		case a.buffer <- item:
+		default:
+			a.addPending(-1)
+			return ErrBufferFull
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case a.buffer <- item:
+				return nil
+			default:
+			}
+			select {
+			case oldest := <-a.buffer:
+				a.fail(oldest, ErrDroppedOldest)
+				a.addPending(-1)
+			default:
+			}
+		}
+	default:
+		select {
+		case a.buffer <- item:
+		case <-ctx.Done():
+			a.addPending(-1)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// run moves buffered events into C in batches until the buffer is closed
+func (a *asyncPusher) run() {
+	defer a.workers.Done()
+
+	items := make([]asyncItem, 0, asyncBatchSize)
+	for item := range a.buffer {
+		items = append(items[:0], item)
+	drain:
+		for len(items) < asyncBatchSize {
+			select {
+			case next, ok := <-a.buffer:
+				if !ok {
+					break drain
+				}
+				items = append(items, next)
+			default:
+				break drain
+			}
+		}
+
+		a.push(items)
+		a.addPending(-len(items))
+	}
+}
+
+// push hands a batch to the C queue, reporting failures to OnError
+func (a *asyncPusher) push(items []asyncItem) {
+	ep := a.ep
+
+	events := make([]Event, len(items))
+	ids := make([]uint64, len(items))
+	index := make([]int, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		events[i] = item.event
+		ids[i] = item.id
+		index[i] = i
+	}
+
+	ep.mu.RLock()
+	if ep.closed {
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+	} else {
+		ep.engine.submitBatch(events, ids, index, errs)
+	}
+	ep.mu.RUnlock()
+
+	for i, err := range errs {
+		if err != nil {
+			a.fail(items[i], err)
+			continue
+		}
+		ep.stats.pushed.Add(1)
+	}
+}
+
+// fail records an event that was accepted but never queued
+func (a *asyncPusher) fail(item asyncItem, err error) {
+	a.ep.ack(item.id)
+	a.ep.forget(item.key)
+	a.ep.stats.dropped.Add(1)
+	a.ep.waiters.settle(item.event.ID, Outcome{Status: OutcomeDropped, Err: err})
+
+	if a.config.OnError == nil {
+		a.ep.logger.Warn("Dropped buffered event",
+			slog.String("event_type", item.event.Type.String()),
+			slog.Any("error", err))
+		return
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				a.ep.logger.Error("Panic in async error handler",
+					slog.Any("panic", r))
+				a.ep.reportPanic(HandlerAsyncError, item.event, r)
+			}
+		}()
+		a.config.OnError(item.event, err)
+	}()
+}
+
+func (a *asyncPusher) addPending(delta int) {
+	a.pendingMu.Lock()
+	a.pending += delta
+	if a.pending == 0 {
+		a.idle.Broadcast()
+	}
+	a.pendingMu.Unlock()
+}
+
+// buffered returns the number of events not yet handed to C
+func (a *asyncPusher) buffered() int {
+	a.pendingMu.Lock()
+	defer a.pendingMu.Unlock()
+	return a.pending
+}
+
+// flush waits until every buffered event has been handed to C
+func (a *asyncPusher) flush() {
+	a.pendingMu.Lock()
+	for a.pending > 0 {
+		a.idle.Wait()
+	}
+	a.pendingMu.Unlock()
+}
+
+// close stops accepting events and waits for the buffer to drain
+func (a *asyncPusher) close() {
+	a.sendMu.Lock()
+	if a.closed {
+		a.sendMu.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.buffer)
+	a.sendMu.Unlock()
+
+	a.workers.Wait()
+}
+
+// Flush blocks until every event accepted by an asynchronous Push has been
+// handed to the C queue. It returns at once in synchronous mode.
+func (ep *EventProcessor) Flush() {
+	if ep.async != nil {
+		ep.async.flush()
+	}
+}
@@ -0,0 +1,123 @@
+package eventlib
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BatchPusher is implemented by processors that can queue many events at
+// once more cheaply than one Push per event
+type BatchPusher interface {
+	PushBatch(events []Event) (accepted int, errs []error)
+}
+
+var _ BatchPusher = (*EventProcessor)(nil)
+
+// PushBatch queues events with a single call into the queue. errs has one
+// entry per event, nil where the event was accepted, so partial failures
+// are visible; accepted counts the nils, including duplicates dropped by
+// the dedup window and events dropped by a Transformer. Events are queued
+// in order, and a failure does not stop the rest of the batch. Events with
+// a future DeliverAt are held back as Push would.
+func (ep *EventProcessor) PushBatch(events []Event) (accepted int, errs []error) {
+	errs = make([]error, len(events))
+	if len(events) == 0 {
+		return 0, errs
+	}
+	if slices.ContainsFunc(events, isScheduled) {
+		return ep.pushBatchScheduled(events)
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if err := ep.accepting(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return 0, errs
+	}
+
+	// Stamp a copy so the caller's events are left alone
+	now := time.Now()
+	events = append([]Event(nil), events...)
+	for i := range events {
+		events[i].EnqueuedAt = now
+	}
+
+	// Transform, check and journal first; dropped, duplicate and failed events
+	// are left out of the batch
+	ids := make([]uint64, len(events))
+	keys := make([]string, len(events))
+	skipped := make([]bool, len(events))
+	index := make([]int, 0, len(events))
+	for i := range events {
+		event, dropped, err := ep.prepare(events[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		events[i] = event
+		if dropped {
+			skipped[i] = true
+			continue
+		}
+		keys[i], skipped[i] = ep.admit(event)
+		if skipped[i] {
+			continue
+		}
+		if ep.wal != nil {
+			seq, err := ep.wal.append(event)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to journal event: %w", err)
+				continue
+			}
+			ids[i] = seq
+		}
+		index = append(index, i)
+	}
+
+	if len(index) > 0 {
+		ep.engine.submitBatch(events, ids, index, errs)
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			ep.ack(ids[i])
+			ep.forget(keys[i])
+			ep.stats.dropped.Add(1)
+			continue
+		}
+		accepted++
+		if !skipped[i] {
+			ep.stats.pushed.Add(1)
+		}
+	}
+
+	return accepted, errs
+}
+
+// pushBatchScheduled holds back the events with a future DeliverAt and
+// batches the rest
+func (ep *EventProcessor) pushBatchScheduled(events []Event) (accepted int, errs []error) {
+	errs = make([]error, len(events))
+	now := make([]Event, 0, len(events))
+	index := make([]int, 0, len(events))
+	for i, event := range events {
+		if isScheduled(event) {
+			if errs[i] = ep.schedule(event); errs[i] == nil {
+				accepted++
+			}
+			continue
+		}
+		now = append(now, event)
+		index = append(index, i)
+	}
+
+	n, nowErrs := ep.PushBatch(now)
+	for j, i := range index {
+		errs[i] = nowErrs[j]
+	}
+	return accepted + n, errs
+}
@@ -0,0 +1,339 @@
+package eventlib
+
+// Benchmarks for the push and processing paths, each crossing the cgo
+// boundary. Compare cgo calls per event with ProcessN against Process, and
+// reader latency while a drain holds the processor, with
+//
+//	go test -run='^$' -bench=. -benchmem
+//
+// The nocgo engine runs the same benchmarks with -tags nocgo, as a
+// baseline without cgo transitions.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var benchData = []byte("benchmark payload")
+
+// newBenchProcessor returns a started processor with an unlimited queue
+// and a handler that does nothing
+func newBenchProcessor(b *testing.B, workers int) *EventProcessor {
+	b.Helper()
+
+	ep, err := New(&Config{Name: "bench", ProcessWorkers: workers}, &Handlers{
+		OnEvent: func(Event) error { return nil },
+	})
+	if err != nil {
+		b.Fatalf("New: %v", err)
+	}
+	if err := ep.Start(); err != nil {
+		b.Fatalf("Start: %v", err)
+	}
+	b.Cleanup(func() { ep.Close() })
+	return ep
+}
+
+// fill queues n events without timing them
+func fill(b *testing.B, ep *EventProcessor, n int) {
+	b.Helper()
+
+	b.StopTimer()
+	defer b.StartTimer()
+	for range n {
+		if err := ep.Push(Event{Type: EventTypeData, Source: "bench", Data: benchData}); err != nil {
+			b.Fatalf("Push: %v", err)
+		}
+	}
+}
+
+// reportCgoCalls reports the cgo calls made per operation since before
+func reportCgoCalls(b *testing.B, ep *EventProcessor, before uint64) {
+	b.ReportMetric(float64(ep.stats.cgoCalls.Load()-before)/float64(b.N), "cgocalls/op")
+}
+
+func BenchmarkPush(b *testing.B) {
+	ep := newBenchProcessor(b, 1)
+	event := Event{Type: EventTypeData, Source: "bench", Data: benchData}
+
+	b.ReportAllocs()
+	for i := range b.N {
+		if err := ep.Push(event); err != nil {
+			b.Fatalf("Push: %v", err)
+		}
+		// Keep the queue from growing without bound
+		if i%4096 == 4095 {
+			b.StopTimer()
+			ep.ProcessAll()
+			b.StartTimer()
+		}
+	}
+}
+
+// BenchmarkPushParallel pushes from GOMAXPROCS goroutines into one queue
+func BenchmarkPushParallel(b *testing.B) {
+	ep := newBenchProcessor(b, 1)
+	event := Event{Type: EventTypeData, Source: "bench", Data: benchData}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				ep.ProcessAll()
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := ep.Push(event); err != nil {
+				b.Errorf("Push: %v", err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+// writePayload stands in for a producer serializing an event into p
+func writePayload(p []byte, seq int) {
+	for i := range p {
+		p[i] = byte(seq + i)
+	}
+}
+
+// BenchmarkPushNoCopy compares a producer writing each payload into a Go
+// slice for Push, which C copies, with one writing it straight into a
+// Buffer for PushNoCopy, which C takes over
+func BenchmarkPushNoCopy(b *testing.B) {
+	for _, size := range []int{256, 4096} {
+		b.Run("Push/"+strconv.Itoa(size), func(b *testing.B) {
+			ep := newBenchProcessor(b, 1)
+			data := make([]byte, size)
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := range b.N {
+				writePayload(data, i)
+				if err := ep.Push(Event{Type: EventTypeData, Source: "bench", Data: data}); err != nil {
+					b.Fatalf("Push: %v", err)
+				}
+				if i%4096 == 4095 {
+					b.StopTimer()
+					ep.ProcessAll()
+					b.StartTimer()
+				}
+			}
+		})
+
+		b.Run("PushNoCopy/"+strconv.Itoa(size), func(b *testing.B) {
+			ep := newBenchProcessor(b, 1)
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := range b.N {
+				buf := NewBuffer(size)
+				writePayload(buf.Bytes(), i)
+				if err := ep.PushNoCopy(Event{Type: EventTypeData, Source: "bench"}, buf); err != nil {
+					b.Fatalf("PushNoCopy: %v", err)
+				}
+				if i%4096 == 4095 {
+					b.StopTimer()
+					ep.ProcessAll()
+					b.StartTimer()
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProcess handles one event per call, a cgo transition each
+func BenchmarkProcess(b *testing.B) {
+	ep := newBenchProcessor(b, 1)
+	fill(b, ep, b.N)
+	before := ep.stats.cgoCalls.Load()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		ep.Process()
+	}
+	b.StopTimer()
+	reportCgoCalls(b, ep, before)
+}
+
+// BenchmarkProcessN handles the same events as BenchmarkProcess, n per
+// call; an op is still one event
+func BenchmarkProcessN(b *testing.B) {
+	for _, n := range []int{16, 256} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			ep := newBenchProcessor(b, 1)
+			fill(b, ep, b.N)
+			before := ep.stats.cgoCalls.Load()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for left := b.N; left > 0; {
+				processed := ep.ProcessN(min(n, left))
+				if processed == 0 {
+					b.Fatalf("ProcessN stopped with %d events left", left)
+				}
+				left -= processed
+			}
+			b.StopTimer()
+			reportCgoCalls(b, ep, before)
+		})
+	}
+}
+
+// BenchmarkProcessAll drains b.N events, on one worker and on several
+// sharing the queue; an op is one event
+func BenchmarkProcessAll(b *testing.B) {
+	for _, workers := range []int{1, 4} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			ep := newBenchProcessor(b, workers)
+			fill(b, ep, b.N)
+			before := ep.stats.cgoCalls.Load()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			ep.ProcessAll()
+			b.StopTimer()
+			reportCgoCalls(b, ep, before)
+
+			if size := ep.QueueSize(); size != 0 {
+				b.Fatalf("QueueSize = %d after ProcessAll", size)
+			}
+		})
+	}
+}
+
+// BenchmarkProcessWorkers compares a serial ProcessAll with parallel
+// workers on handlers that do some work: hashing the payload, which keeps
+// a CPU busy, and a short sleep, which stands in for a blocking call such
+// as a database write. An op is one event.
+func BenchmarkProcessWorkers(b *testing.B) {
+	payload := bytes.Repeat([]byte("benchmark payload "), 64)
+	handlers := []struct {
+		name string
+		fn   EventHandler
+	}{
+		{"hash", func(event Event) error {
+			sha256.Sum256(event.Data)
+			return nil
+		}},
+		{"sleep", func(Event) error {
+			time.Sleep(50 * time.Microsecond)
+			return nil
+		}},
+	}
+
+	for _, h := range handlers {
+		for _, workers := range []int{1, 4} {
+			b.Run(h.name+"/workers="+strconv.Itoa(workers), func(b *testing.B) {
+				ep, err := New(&Config{Name: "bench", ProcessWorkers: workers}, &Handlers{OnEvent: h.fn})
+				if err != nil {
+					b.Fatalf("New: %v", err)
+				}
+				defer ep.Close()
+				if err := ep.Start(); err != nil {
+					b.Fatalf("Start: %v", err)
+				}
+
+				for range b.N {
+					if err := ep.Push(Event{Type: EventTypeData, Source: "bench", Data: payload}); err != nil {
+						b.Fatalf("Push: %v", err)
+					}
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				ep.ProcessAll()
+				b.StopTimer()
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+
+				if size := ep.QueueSize(); size != 0 {
+					b.Fatalf("QueueSize = %d after ProcessAll", size)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkReadDuringDrain times QueueSize and State while another
+// goroutine keeps draining a full queue and a third keeps stopping and
+// starting the processor. Readers share the read lock with all three, so
+// the tail should stay near the median; it grew with the length of a
+// drain while Start and Stop took the write lock.
+func BenchmarkReadDuringDrain(b *testing.B) {
+	ep := newBenchProcessor(b, 1)
+	event := Event{Type: EventTypeData, Source: "bench", Data: benchData}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for range 10000 {
+				ep.Push(event)
+			}
+			ep.ProcessAll()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ep.Stop()
+				ep.Start()
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var all []time.Duration
+	b.RunParallel(func(pb *testing.PB) {
+		var mine []time.Duration
+		for pb.Next() {
+			start := time.Now()
+			ep.QueueSize()
+			ep.State()
+			mine = append(mine, time.Since(start))
+		}
+		mu.Lock()
+		all = append(all, mine...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+
+	slices.Sort(all)
+	b.ReportMetric(float64(all[len(all)*999/1000].Microseconds()), "p999-µs")
+	b.ReportMetric(float64(all[len(all)-1].Microseconds()), "max-µs")
+}
@@ -0,0 +1,273 @@
+package eventlib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerFailureRate    = 0.5
+	DefaultBreakerMinRequests    = 10
+	DefaultBreakerWindow         = 10 * time.Second
+	DefaultBreakerOpenTimeout    = 30 * time.Second
+	DefaultBreakerHalfOpenProbes = 1
+)
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through, counting failures
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen fails every call with ErrCircuitOpen
+	BreakerOpen
+
+	// BreakerHalfOpen lets a few probe calls through to test recovery
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerConfig configures a CircuitBreaker. Zero fields take their
+// defaults.
+type BreakerConfig struct {
+	// Name identifies the breaker in errors and state changes
+	Name string
+
+	// FailureRate is the fraction of failed calls in a window, from 0 to
+	// 1, at which the breaker opens
+	FailureRate float64
+
+	// MinRequests is how many calls a window needs before its failure
+	// rate counts, so a single failure cannot open the breaker
+	MinRequests int
+
+	// Window is how long calls are counted before the counts start over
+	Window time.Duration
+
+	// OpenTimeout is how long the breaker stays open before it half-opens
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is how many calls a half-open breaker lets through.
+	// The breaker closes once they all succeed, and opens again if any
+	// fails.
+	HalfOpenProbes int
+
+	// OnStateChange, if set, is called after each transition, outside
+	// the breaker's lock
+	OnStateChange func(name string, from, to BreakerState)
+}
+
+// validate reports a config that cannot be used
+func (c BreakerConfig) validate() error {
+	switch {
+	case c.FailureRate < 0 || c.FailureRate > 1:
+		return fmt.Errorf("%w: breaker failure rate %g is outside [0, 1]", ErrInvalidConfig, c.FailureRate)
+	case c.MinRequests < 0 || c.HalfOpenProbes < 0:
+		return fmt.Errorf("%w: negative breaker request count", ErrInvalidConfig)
+	case c.Window < 0 || c.OpenTimeout < 0:
+		return fmt.Errorf("%w: negative breaker duration", ErrInvalidConfig)
+	}
+	return nil
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureRate == 0 {
+		c.FailureRate = DefaultBreakerFailureRate
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = DefaultBreakerMinRequests
+	}
+	if c.Window == 0 {
+		c.Window = DefaultBreakerWindow
+	}
+	if c.OpenTimeout == 0 {
+		c.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if c.HalfOpenProbes == 0 {
+		c.HalfOpenProbes = DefaultBreakerHalfOpenProbes
+	}
+	return c
+}
+
+// CircuitBreaker stops calling a failing dependency, such as a webhook
+// endpoint or a handler's downstream service, so that it gets time to
+// recover and callers fail fast instead of waiting on it. It is safe for
+// concurrent use.
+//
+// A closed breaker counts the outcome of calls. Once the failure rate in a
+// window reaches FailureRate over at least MinRequests calls, it opens and
+// refuses calls with ErrCircuitOpen. After OpenTimeout it half-opens and
+// lets HalfOpenProbes calls through; it closes if they succeed and opens
+// again if any fails.
+type CircuitBreaker struct {
+	config BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int // calls let through while half-open
+	probesOK    int
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker
+func NewCircuitBreaker(config BreakerConfig) (*CircuitBreaker, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &CircuitBreaker{
+		config:      config.withDefaults(),
+		windowStart: time.Now(),
+	}, nil
+}
+
+// Name returns the breaker's name
+func (b *CircuitBreaker) Name() string {
+	return b.config.Name
+}
+
+// State returns the breaker's state, half-opening it if its open timeout
+// has passed
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	from := b.state
+	b.advance(time.Now())
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return to
+}
+
+// Allow reports whether a call may go ahead, returning an error wrapping
+// ErrCircuitOpen if not. Every allowed call must be followed by Record
+// with its outcome.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+	b.advance(time.Now())
+	to := b.state
+
+	var err error
+	switch {
+	case b.state == BreakerOpen:
+		err = fmt.Errorf("%w: %s", ErrCircuitOpen, b.config.Name)
+	case b.state == BreakerHalfOpen && b.probes >= b.config.HalfOpenProbes:
+		err = fmt.Errorf("%w: %s is probing", ErrCircuitOpen, b.config.Name)
+	case b.state == BreakerHalfOpen:
+		b.probes++
+	}
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	return err
+}
+
+// Record counts the outcome of a call that Allow let through; a nil err
+// is a success
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	now := time.Now()
+	from := b.state
+
+	switch b.state {
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+		b.calls++
+		if err != nil {
+			b.failures++
+		}
+		if b.calls >= b.config.MinRequests &&
+			float64(b.failures) >= b.config.FailureRate*float64(b.calls) && b.failures > 0 {
+			b.open(now)
+		}
+	case BreakerHalfOpen:
+		if err != nil {
+			b.open(now)
+			break
+		}
+		b.probesOK++
+		if b.probesOK >= b.config.HalfOpenProbes {
+			b.state = BreakerClosed
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// Do calls fn if the breaker allows it and records the outcome. It
+// returns an error wrapping ErrCircuitOpen without calling fn while the
+// breaker is open.
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.Record(err)
+	return err
+}
+
+// Middleware guards a handler with the breaker. While it is open, events
+// fail with ErrCircuitOpen without reaching the handler, and are retried
+// or dead-lettered as for any other failure. A panic counts as a failure.
+func (b *CircuitBreaker) Middleware() Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event) (err error) {
+			if err := b.Allow(); err != nil {
+				return err
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					b.Record(fmt.Errorf("%w: %v", ErrHandlerPanic, r))
+					panic(r)
+				}
+				b.Record(err)
+			}()
+			return next(event)
+		}
+	}
+}
+
+// open opens the breaker; b.mu must be held
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+}
+
+// advance half-opens an open breaker whose timeout has passed; b.mu must
+// be held
+func (b *CircuitBreaker) advance(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probes, b.probesOK = 0, 0
+	}
+}
+
+// notify calls OnStateChange for a transition
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(b.config.Name, from, to)
+	}
+}
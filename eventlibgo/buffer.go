@@ -0,0 +1,149 @@
+package eventlib
+
+import (
+	"errors"
+	"runtime"
+	"time"
+	"unsafe"
+)
+
+// NoCopyPusher is implemented by processors that can queue a Buffer's
+// memory without copying it
+type NoCopyPusher interface {
+	PushNoCopy(event Event, buf *Buffer) error
+}
+
+var _ NoCopyPusher = (*EventProcessor)(nil)
+
+// ErrBufferFreed is returned when pushing a Buffer that was freed or has
+// already been handed to a processor
+var ErrBufferFreed = errors.New("buffer already freed or pushed")
+
+// Buffer is event data allocated in C memory, so the C queue can take it
+// over without a copy; in nocgo builds it is Go memory the queue keeps a
+// reference to. A Buffer is owned by whoever created it until a
+// successful PushNoCopy, after which it belongs to the processor and must
+// not be touched again. It is not safe for concurrent use.
+type Buffer struct {
+	ptr  unsafe.Pointer
+	size int
+	done bool // freed or pushed
+}
+
+// NewBuffer allocates a Buffer of size bytes. The contents are not zeroed.
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		return &Buffer{}
+	}
+	b := &Buffer{ptr: allocBuffer(size), size: size}
+	if b.ptr == nil {
+		panic("eventlib: out of memory allocating buffer")
+	}
+	// A Buffer dropped without Free or a push would otherwise leak
+	runtime.SetFinalizer(b, (*Buffer).Free)
+	return b
+}
+
+// Bytes returns the buffer's memory for filling in. The slice is only
+// valid until the Buffer is freed or pushed.
+func (b *Buffer) Bytes() []byte {
+	if b.ptr == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(b.ptr), b.size)
+}
+
+// Len returns the buffer's size in bytes
+func (b *Buffer) Len() int {
+	return b.size
+}
+
+// Truncate shrinks the buffer to its first n bytes, after writing less
+// than was allocated
+func (b *Buffer) Truncate(n int) {
+	if n >= 0 && n < b.size {
+		b.size = n
+	}
+}
+
+// Free releases the buffer. It is a no-op once the buffer has been freed
+// or pushed.
+func (b *Buffer) Free() {
+	if b.ptr != nil {
+		freeBuffer(b.ptr)
+	}
+	b.release()
+}
+
+// release gives up the memory without freeing it, once C owns it
+func (b *Buffer) release() {
+	b.ptr = nil
+	b.size = 0
+	b.done = true
+	runtime.SetFinalizer(b, nil)
+}
+
+// PushNoCopy queues event with buf as its data, which C takes over instead
+// of copying; event.Data is ignored. If PushNoCopy returns nil, buf
+// belongs to the processor and is freed once the event is processed or
+// filtered. If it returns an error, the caller still owns buf and may
+// retry or Free it.
+//
+// With AsyncPush or Transformers, which need the data in Go memory, the
+// data is copied and buf freed as for Push.
+func (ep *EventProcessor) PushNoCopy(event Event, buf *Buffer) error {
+	if buf == nil || buf.done {
+		return ErrBufferFreed
+	}
+
+	// An empty buffer has no memory to hand over
+	if buf.ptr == nil || buf.size == 0 || ep.async != nil || len(ep.config.Transformers) > 0 || isScheduled(event) {
+		event.Data = append([]byte(nil), buf.Bytes()...)
+		if err := ep.Push(event); err != nil {
+			return err
+		}
+		buf.Free()
+		return nil
+	}
+
+	// Dedup keys and the journal read the data in place, before C owns it
+	event.Data = buf.Bytes()
+	event.EnqueuedAt = time.Now()
+
+	ep.observeSize(event)
+	if err := ep.checkSize(event); err != nil {
+		ep.stats.dropped.Add(1)
+		return err
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if err := ep.accepting(); err != nil {
+		return err
+	}
+
+	key, duplicate := ep.admit(event)
+	if duplicate {
+		buf.Free()
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomeDuplicate})
+		return nil
+	}
+
+	id, err := ep.journal(event)
+	if err != nil {
+		ep.forget(key)
+		return err
+	}
+
+	if err := ep.engine.submit(event, id, true); err != nil {
+		ep.ack(id)
+		ep.forget(key)
+		ep.stats.dropped.Add(1)
+		return err
+	}
+	buf.release()
+
+	ep.stats.pushed.Add(1)
+	return nil
+}
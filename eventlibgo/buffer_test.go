@@ -0,0 +1,147 @@
+package eventlib
+
+// Ownership tests for PushNoCopy. They check the Go side of the handoff;
+// run them under AddressSanitizer, whose leak checker covers the C side,
+// with
+//
+//	make -C ../eventlib asan && go test -asan -run=TestPushNoCopy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newBuffer returns a Buffer holding data
+func newBuffer(data []byte) *Buffer {
+	buf := NewBuffer(len(data))
+	copy(buf.Bytes(), data)
+	return buf
+}
+
+// checkGivenAway fails unless buf belongs to the processor: the caller can
+// no longer reach its memory or push it again
+func checkGivenAway(t *testing.T, ep *EventProcessor, buf *Buffer) {
+	t.Helper()
+
+	if buf.Bytes() != nil || buf.Len() != 0 {
+		t.Fatalf("buffer still holds %d bytes after a successful push", buf.Len())
+	}
+	buf.Free() // a no-op now
+	if err := ep.PushNoCopy(Event{Type: EventTypeData}, buf); !errors.Is(err, ErrBufferFreed) {
+		t.Fatalf("pushing the buffer again: %v, want ErrBufferFreed", err)
+	}
+}
+
+func TestPushNoCopyOwnership(t *testing.T) {
+	payload := []byte("no copy payload")
+
+	t.Run("processed", func(t *testing.T) {
+		ep, handled := newFuzzProcessor(t)
+		buf := newBuffer(payload)
+
+		if err := ep.PushNoCopy(Event{Type: EventTypeData, Source: "s"}, buf); err != nil {
+			t.Fatalf("PushNoCopy: %v", err)
+		}
+		checkGivenAway(t, ep, buf)
+
+		ep.ProcessAll()
+		if len(*handled) != 1 || !bytes.Equal((*handled)[0].Data, payload) {
+			t.Fatalf("handled %+v, want one event with the buffer's data", *handled)
+		}
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		ep, err := New(&Config{Name: "nocopy"}, &Handlers{
+			OnEvent:  func(Event) error { return nil },
+			OnFilter: func(Event) bool { return false },
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer ep.Close()
+		ep.Start()
+		buf := newBuffer(payload)
+
+		if err := ep.PushNoCopy(Event{Type: EventTypeData, Source: "s"}, buf); err != nil {
+			t.Fatalf("PushNoCopy: %v", err)
+		}
+		checkGivenAway(t, ep, buf)
+		if stats := ep.Stats(); stats.Filtered != 1 || ep.QueueSize() != 0 {
+			t.Fatalf("filtered %d, queued %d; want the event filtered", stats.Filtered, ep.QueueSize())
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		ep, err := New(&Config{Name: "nocopy", Dedup: &DedupConfig{Window: time.Minute}}, &Handlers{
+			OnEvent: func(Event) error { return nil },
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer ep.Close()
+		ep.Start()
+
+		event := Event{Type: EventTypeData, Source: "s", ID: "id-1"}
+		if err := ep.PushNoCopy(event, newBuffer(payload)); err != nil {
+			t.Fatalf("PushNoCopy: %v", err)
+		}
+		buf := newBuffer(payload)
+		if err := ep.PushNoCopy(event, buf); err != nil {
+			t.Fatalf("PushNoCopy duplicate: %v", err)
+		}
+		checkGivenAway(t, ep, buf)
+		if stats := ep.Stats(); stats.Duplicates != 1 || ep.QueueSize() != 1 {
+			t.Fatalf("duplicates %d, queued %d; want the second push dropped", stats.Duplicates, ep.QueueSize())
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		ep, err := New(&Config{Name: "nocopy", MaxQueueSize: 1}, &Handlers{
+			OnEvent: func(Event) error { return nil },
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer ep.Close()
+		ep.Start()
+		if err := ep.Push(Event{Type: EventTypeData, Source: "first"}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+
+		buf := newBuffer(payload)
+		if err := ep.PushNoCopy(Event{Type: EventTypeData, Source: "s"}, buf); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("PushNoCopy into a full queue: %v, want ErrQueueFull", err)
+		}
+		// The caller still owns the buffer and can retry once there is room
+		if !bytes.Equal(buf.Bytes(), payload) {
+			t.Fatalf("buffer holds %q after a failed push, want %q", buf.Bytes(), payload)
+		}
+		ep.ProcessAll()
+		if err := ep.PushNoCopy(Event{Type: EventTypeData, Source: "s"}, buf); err != nil {
+			t.Fatalf("PushNoCopy retry: %v", err)
+		}
+		checkGivenAway(t, ep, buf)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		ep, err := New(&Config{Name: "nocopy", MaxEventSize: 8}, &Handlers{
+			OnEvent: func(Event) error { return nil },
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer ep.Close()
+		ep.Start()
+
+		buf := newBuffer(payload)
+		if err := ep.PushNoCopy(Event{Type: EventTypeData}, buf); !errors.Is(err, ErrEventTooLarge) {
+			t.Fatalf("PushNoCopy over MaxEventSize: %v, want ErrEventTooLarge", err)
+		}
+		if !bytes.Equal(buf.Bytes(), payload) {
+			t.Fatalf("buffer holds %q after a failed push, want %q", buf.Bytes(), payload)
+		}
+		buf.Free()
+	})
+}
@@ -1,3 +1,5 @@
+//go:build !nocgo
+
 package eventlib
 
 /*
@@ -5,138 +7,181 @@ package eventlib
 */
 import "C"
 import (
-	"sync"
+	"bytes"
+	"runtime/cgo"
+	"time"
 	"unsafe"
-
-	"go.uber.org/zap"
+	"weak"
 )
 
-// Global map to store processor references for callbacks
-var (
-	callbackMap    = make(map[int]*EventProcessor)
-	callbackMu     sync.RWMutex
-	nextCallbackID int
-)
-
-// getProcessor retrieves processor from callback ID
-func getProcessor(userData unsafe.Pointer) *EventProcessor {
-	id := int(uintptr(userData))
-	callbackMu.RLock()
-	defer callbackMu.RUnlock()
-	return callbackMap[id]
-}
-
-//export goHandleEvent
-func goHandleEvent(eventPtr unsafe.Pointer, userData unsafe.Pointer) {
-	ep := getProcessor(userData)
-	if ep == nil || ep.handlers.OnEvent == nil {
-		return
+// getProcessor resolves the cgo.Handle passed to C as user_data
+func getProcessor(handle C.uintptr_t) *EventProcessor {
+	if handle == 0 {
+		return nil
 	}
+	ep, _ := cgo.Handle(handle).Value().(weak.Pointer[EventProcessor])
+	return ep.Value()
+}
 
-	// Convert C event to Go event
-	cEvent := (*C.event_t)(eventPtr)
+// eventFromC copies a C event into Go memory
+func eventFromC(cEvent *C.event_t) Event {
 	event := Event{
 		Type:   EventType(cEvent._type),
 		Source: C.GoString(cEvent.source),
 	}
 
+	// Copied from a slice rather than with C.GoBytes, whose C.int length
+	// would truncate payloads of 2 GiB and over
 	if cEvent.data != nil && cEvent.data_len > 0 {
-		event.Data = C.GoBytes(cEvent.data, C.int(cEvent.data_len))
-	}
-
-	// Call handler with recovery
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				ep.logger.Error("Panic in event handler",
-					zap.Any("panic", r),
-					zap.String("event_type", event.Type.String()))
-			}
-		}()
-		ep.handlers.OnEvent(event)
-	}()
+		event.Data = bytes.Clone(unsafe.Slice((*byte)(cEvent.data), cEvent.data_len))
+	}
+
+	if cEvent.deadline_ms > 0 {
+		event.Deadline = time.UnixMilli(int64(cEvent.deadline_ms))
+	}
+
+	if cEvent.timestamp_ms > 0 {
+		event.Timestamp = time.UnixMilli(int64(cEvent.timestamp_ms))
+	}
+
+	if cEvent.enqueued_ns > 0 {
+		event.EnqueuedAt = time.Unix(0, int64(cEvent.enqueued_ns))
+	}
+
+	if cEvent.trace_parent != nil {
+		event.TraceParent = C.GoString(cEvent.trace_parent)
+	}
+
+	if cEvent.event_id != nil {
+		event.ID = C.GoString(cEvent.event_id)
+	}
+
+	event.Backfill = cEvent.flags&C.EVENT_FLAG_BACKFILL != 0
+	event.Priority = int(cEvent.priority)
+	event.Attempt = int(cEvent.attempt)
+
+	if cEvent.content_type != nil {
+		event.ContentType = C.GoString(cEvent.content_type)
+	}
+
+	if cEvent.correlation_id != nil {
+		event.CorrelationID = C.GoString(cEvent.correlation_id)
+	}
+
+	if cEvent.metadata != nil && cEvent.metadata_len > 0 {
+		event.Metadata = decodeMetadata(unsafe.Slice((*byte)(cEvent.metadata), cEvent.metadata_len))
+	}
+
+	return event
 }
 
-//export goHandleLog
-func goHandleLog(levelPtr unsafe.Pointer, messagePtr unsafe.Pointer, userData unsafe.Pointer) {
-	ep := getProcessor(userData)
+//export goHandleEvent
+func goHandleEvent(eventPtr unsafe.Pointer, handle C.uintptr_t) {
+	ep := getProcessor(handle)
 	if ep == nil {
 		return
 	}
+	ep.stats.callbacks.Add(1)
 
-	level := C.GoString((*C.char)(levelPtr))
-	message := C.GoString((*C.char)(messagePtr))
+	cEvent := (*C.event_t)(eventPtr)
+	ep.handleEvent(eventFromC(cEvent), uint64(cEvent.id))
+}
 
-	// Map C log levels to zap
-	switch level {
-	case "DEBUG":
-		ep.logger.Debug(message)
-	case "INFO":
-		ep.logger.Info(message)
-	case "WARN":
-		ep.logger.Warn(message)
-	case "ERROR":
-		ep.logger.Error(message)
-	default:
-		ep.logger.Info(message, zap.String("level", level))
+//export goHandleLog
+func goHandleLog(levelPtr unsafe.Pointer, messagePtr unsafe.Pointer, handle C.uintptr_t) {
+	ep := getProcessor(handle)
+	if ep == nil {
+		return
 	}
+	ep.stats.callbacks.Add(1)
+
+	ep.handleLog(C.GoString((*C.char)(levelPtr)), C.GoString((*C.char)(messagePtr)))
 }
 
 //export goHandleFilter
-func goHandleFilter(eventPtr unsafe.Pointer, userData unsafe.Pointer) C.int {
-	ep := getProcessor(userData)
-	if ep == nil || ep.handlers.OnFilter == nil {
+func goHandleFilter(eventPtr unsafe.Pointer, handle C.uintptr_t) C.int {
+	ep := getProcessor(handle)
+	if ep == nil || ep.handlers.Load().OnFilter == nil {
 		return 1 // Default: don't filter
 	}
+	ep.stats.callbacks.Add(1)
 
-	// Convert C event to Go event
 	cEvent := (*C.event_t)(eventPtr)
-	event := Event{
-		Type:   EventType(cEvent._type),
-		Source: C.GoString(cEvent.source),
-	}
-
-	if cEvent.data != nil && cEvent.data_len > 0 {
-		event.Data = C.GoBytes(cEvent.data, C.int(cEvent.data_len))
-	}
-
-	// Call filter with recovery
-	allow := true
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				ep.logger.Error("Panic in filter handler",
-					zap.Any("panic", r))
-				allow = true // Default to allowing on error
-			}
-		}()
-		allow = ep.handlers.OnFilter(event)
-	}()
-
-	if allow {
+	if ep.handleFilter(eventFromC(cEvent), uint64(cEvent.id)) {
 		return 1
 	}
 	return 0
 }
 
 //export goHandleStateChange
-func goHandleStateChange(oldStatePtr unsafe.Pointer, newStatePtr unsafe.Pointer, userData unsafe.Pointer) {
-	ep := getProcessor(userData)
-	if ep == nil || ep.handlers.OnStateChange == nil {
+func goHandleStateChange(oldStatePtr unsafe.Pointer, newStatePtr unsafe.Pointer, handle C.uintptr_t) {
+	ep := getProcessor(handle)
+	if ep == nil || ep.handlers.Load().OnStateChange == nil {
+		return
+	}
+	ep.stats.callbacks.Add(1)
+
+	ep.handleStateChange(C.GoString((*C.char)(oldStatePtr)), C.GoString((*C.char)(newStatePtr)))
+}
+
+//export goHandleQueuePressure
+func goHandleQueuePressure(high C.int, queueSize C.size_t, handle C.uintptr_t) {
+	ep := getProcessor(handle)
+	if ep == nil || ep.handlers.Load().OnQueuePressure == nil {
+		return
+	}
+	ep.stats.callbacks.Add(1)
+
+	ep.handleQueuePressure(high != 0, int(queueSize))
+}
+
+//export goHandleExpired
+func goHandleExpired(eventPtr unsafe.Pointer, handle C.uintptr_t) {
+	ep := getProcessor(handle)
+	if ep == nil {
 		return
 	}
+	ep.stats.callbacks.Add(1)
 
-	oldState := C.GoString((*C.char)(oldStatePtr))
-	newState := C.GoString((*C.char)(newStatePtr))
+	// The event is only copied out when someone will look at it
+	cEvent := (*C.event_t)(eventPtr)
+	event := Event{Attempt: int(cEvent.attempt)}
+	if ep.handlers.Load().OnExpired != nil || ep.waiters.n.Load() > 0 {
+		event = eventFromC(cEvent)
+	}
+	ep.handleExpired(event, uint64(cEvent.id))
+}
 
-	// Call handler with recovery
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				ep.logger.Error("Panic in state change handler",
-					zap.Any("panic", r))
-			}
-		}()
-		ep.handlers.OnStateChange(oldState, newState)
-	}()
+// purgeVisitor is the cgo.Handle value behind a purge
+type purgeVisitor struct {
+	match   func(Event) bool
+	removed func(event Event, id uint64)
+}
+
+//export goPurgeMatch
+func goPurgeMatch(eventPtr unsafe.Pointer, handle C.uintptr_t) C.int {
+	v, ok := cgo.Handle(handle).Value().(*purgeVisitor)
+	if !ok || !v.match(eventFromC((*C.event_t)(eventPtr))) {
+		return 0
+	}
+	return 1
+}
+
+//export goPurgeEvent
+func goPurgeEvent(eventPtr unsafe.Pointer, handle C.uintptr_t) {
+	v, ok := cgo.Handle(handle).Value().(*purgeVisitor)
+	if !ok {
+		return
+	}
+	cEvent := (*C.event_t)(eventPtr)
+	v.removed(eventFromC(cEvent), uint64(cEvent.id))
+}
+
+//export goSnapshotEvent
+func goSnapshotEvent(eventPtr unsafe.Pointer, handle C.uintptr_t) {
+	events, ok := cgo.Handle(handle).Value().(*[]Event)
+	if !ok {
+		return
+	}
+	*events = append(*events, eventFromC((*C.event_t)(eventPtr)))
 }
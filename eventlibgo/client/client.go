@@ -0,0 +1,349 @@
+// Package client calls the eventlib server's HTTP API. It does not use
+// cgo, so tools built on it need neither the C library nor a C toolchain.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultServer is the server address used when Config.Server is empty
+const DefaultServer = "http://localhost:8080"
+
+// DefaultTimeout bounds requests other than Watch when Config.Timeout is
+// zero
+const DefaultTimeout = 30 * time.Second
+
+// Config configures a Client
+type Config struct {
+	// Server is the base URL, such as https://events.example.com, or
+	// unix:///var/run/eventlib.sock for a server on a Unix socket
+	Server string
+
+	// Token is sent as a bearer token: an API key or a JWT
+	Token string
+
+	// Timeout bounds each request other than Watch; defaults to
+	// DefaultTimeout
+	Timeout time.Duration
+
+	// HTTPClient makes the requests, such as with custom TLS settings;
+	// its Timeout is replaced by Timeout
+	HTTPClient *http.Client
+
+	// UserAgent identifies the caller in server logs
+	UserAgent string
+
+	// Compress gzips request bodies, which pays off for large batches
+	// over slow links. Responses are compressed whenever the server
+	// supports it, and decompressed transparently.
+	Compress bool
+}
+
+// Client calls the /api/v1 routes of one server. It is safe for concurrent
+// use.
+type Client struct {
+	base      *url.URL
+	token     string
+	userAgent string
+	compress  bool
+	http      *http.Client
+	stream    *http.Client
+}
+
+// New creates a Client for the server in config
+func New(config Config) (*Client, error) {
+	server := config.Server
+	if server == "" {
+		server = DefaultServer
+	}
+	base, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" && base.Scheme != "unix" {
+		return nil, fmt.Errorf("invalid server URL %q: scheme must be http, https or unix", server)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	if base.Scheme == "unix" {
+		if base.Path == "" {
+			return nil, fmt.Errorf("invalid server URL %q: no socket path", server)
+		}
+		if httpClient, err = unixClient(httpClient, base.Path); err != nil {
+			return nil, err
+		}
+		base = &url.URL{Scheme: "http", Host: "localhost"}
+	}
+
+	// Streams stay open, so Watch gets a copy without the timeout
+	requests, stream := *httpClient, *httpClient
+	requests.Timeout = timeout
+	stream.Timeout = 0
+
+	return &Client{
+		base:      base,
+		token:     config.Token,
+		userAgent: config.UserAgent,
+		compress:  config.Compress,
+		http:      &requests,
+		stream:    &stream,
+	}, nil
+}
+
+// unixClient returns a copy of httpClient that connects to the socket at
+// path whatever the request's host
+func unixClient(httpClient *http.Client, path string) (*http.Client, error) {
+	var transport *http.Transport
+	switch t := httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, fmt.Errorf("a unix server URL needs an *http.Transport, not %T", t)
+	}
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+
+	c := *httpClient
+	c.Transport = transport
+	return &c, nil
+}
+
+// APIError is an error response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsStatus reports whether err is an APIError with the given status
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// newRequest builds a request for path under /api/v1, encoding body as
+// JSON when it is not nil
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+	u := *c.base
+	u.Path += "/api/v1" + path
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		if c.compress {
+			data = gzipBytes(data)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		if c.compress {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return req, nil
+}
+
+// gzipBytes compresses data for a Content-Encoding: gzip body
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// do sends req and decodes a successful JSON response into out, if not nil
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// call is do for a request built from its arguments
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// checkResponse returns an APIError for a non-2xx response
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		apiErr.Message = body.Error
+	} else if msg := strings.TrimSpace(string(data)); msg != "" {
+		apiErr.Message = msg
+	} else {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// Push submits an event
+func (c *Client) Push(ctx context.Context, event Event) (*PushResult, error) {
+	var result PushResult
+	if err := c.call(ctx, http.MethodPost, "/events", nil, event, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PushBatch submits events in one request. Events the server rejects are
+// counted in the result rather than failing the call, and its Results
+// say which they were and why.
+func (c *Client) PushBatch(ctx context.Context, events []Event) (*BatchResult, error) {
+	var result BatchResult
+	if err := c.call(ctx, http.MethodPost, "/events/batch", nil, batchBody{events}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PushBatchAtomic is PushBatch, except that none of the events are queued
+// if any is invalid, fails its schema or is rate limited. The call then
+// fails with a 422 APIError, and the result says which events were at
+// fault. An event can still fail to queue for other reasons, such as a
+// full queue, and is reported as for PushBatch.
+func (c *Client) PushBatchAtomic(ctx context.Context, events []Event) (*BatchResult, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/events/batch", url.Values{"atomic": {"true"}}, batchBody{events})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result BatchResult
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		data, err := io.ReadAll(resp.Body)
+		if err == nil && json.Unmarshal(data, &result) == nil && result.Error != "" {
+			return &result, &APIError{StatusCode: resp.StatusCode, Message: result.Error}
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// batchBody is the request body of PushBatch
+type batchBody struct {
+	Events []Event `json:"events"`
+}
+
+// Status returns the processor status
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.call(ctx, http.MethodGet, "/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ProcessAll processes every queued event and reports how many were
+// handled
+func (c *Client) ProcessAll(ctx context.Context) (*ProcessResult, error) {
+	var result ProcessResult
+	if err := c.call(ctx, http.MethodPost, "/process/all", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Replay enqueues processed events from the server's history again
+func (c *Client) Replay(ctx context.Context, request ReplayRequest) (*ReplayResult, error) {
+	var result ReplayResult
+	if err := c.call(ctx, http.MethodPost, "/events/replay", nil, request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Filters returns the filter rules and their hit counts
+func (c *Client) Filters(ctx context.Context) (*Filters, error) {
+	var filters Filters
+	if err := c.call(ctx, http.MethodGet, "/filters", nil, nil, &filters); err != nil {
+		return nil, err
+	}
+	return &filters, nil
+}
+
+// SetFilters replaces the filter rules
+func (c *Client) SetFilters(ctx context.Context, config FilterConfig) (*Filters, error) {
+	var filters Filters
+	if err := c.call(ctx, http.MethodPut, "/filters", nil, config, &filters); err != nil {
+		return nil, err
+	}
+	return &filters, nil
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// EventType is an event type name, such as "DATA", or a decimal type
+// number. Numbers are sent as JSON numbers.
+type EventType string
+
+func (t EventType) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.Atoi(string(t)); err == nil {
+		return json.Marshal(n)
+	}
+	return json.Marshal(string(t))
+}
+
+// UnmarshalJSON accepts a name or a number
+func (t *EventType) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*t = EventType(strconv.Itoa(n))
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	*t = EventType(name)
+	return nil
+}
+
+// Event is an event to submit. Data is sent base64 encoded; DataJSON is
+// an alternative for structured payloads.
+type Event struct {
+	Type          EventType         `json:"type"`
+	Source        string            `json:"source"`
+	Data          []byte            `json:"data,omitempty"`
+	DataJSON      json.RawMessage   `json:"data_json,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	ID            string            `json:"id,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Priority      *int              `json:"priority,omitempty"`
+
+	// Timestamp is when the event occurred; required for backfill
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Deadline is when the event expires unhandled
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// DeliverAt or DelayMs holds the event back until then
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+	DelayMs   int64      `json:"delay_ms,omitempty"`
+}
+
+// PushResult is the server's answer to Push. EventID and DeliverAt are
+// set for scheduled events.
+type PushResult struct {
+	Status    string `json:"status"`
+	EventID   string `json:"event_id,omitempty"`
+	DeliverAt string `json:"deliver_at,omitempty"`
+}
+
+// BatchResult counts the outcome of PushBatch, with a result per event
+type BatchResult struct {
+	Queued        int `json:"queued"`
+	Failed        int `json:"failed"`
+	RateLimited   int `json:"rate_limited"`
+	SchemaInvalid int `json:"schema_invalid"`
+	TooLarge      int `json:"too_large"`
+
+	Results []BatchEventResult `json:"results"`
+
+	// Error says why an atomic batch was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// BatchEventResult is the outcome of one event of a batch. Status is
+// queued or scheduled, or otherwise why the event was not queued, such
+// as invalid, rate_limited or queue_full; the events of a rejected
+// atomic batch that were not at fault are skipped.
+type BatchEventResult struct {
+	Index   int    `json:"index"`
+	Status  string `json:"status"`
+	EventID string `json:"event_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Queued reports whether the event was accepted
+func (r BatchEventResult) Queued() bool {
+	return r.Status == "queued" || r.Status == "scheduled"
+}
+
+// Status is the processor status
+type Status struct {
+	State           string    `json:"state"`
+	QueueSize       int       `json:"queue_size"`
+	EventsProcessed int       `json:"events_processed"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// ProcessResult reports a ProcessAll call
+type ProcessResult struct {
+	Status    string `json:"status"`
+	Processed int    `json:"processed"`
+	Duration  string `json:"duration"`
+}
+
+// ReplayRequest selects processed events to enqueue again. From is
+// inclusive and To exclusive; empty fields match everything.
+type ReplayRequest struct {
+	From    *time.Time  `json:"from,omitempty"`
+	To      *time.Time  `json:"to,omitempty"`
+	Types   []EventType `json:"types,omitempty"`
+	Sources []string    `json:"sources,omitempty"`
+	Limit   int         `json:"limit,omitempty"`
+	DryRun  bool        `json:"dry_run,omitempty"`
+}
+
+// ReplayResult reports a replay. Oldest is the oldest event still in the
+// server's history.
+type ReplayResult struct {
+	Matched int        `json:"matched"`
+	Queued  int        `json:"queued"`
+	Failed  int        `json:"failed"`
+	DryRun  bool       `json:"dry_run"`
+	Oldest  *time.Time `json:"oldest,omitempty"`
+}
+
+// FilterConfig is a filter rule set. The first matching rule decides;
+// Default applies when none match.
+type FilterConfig struct {
+	Default string       `json:"default,omitempty"`
+	Rules   []FilterRule `json:"rules"`
+}
+
+// FilterRule drops or allows the events it matches
+type FilterRule struct {
+	Name        string      `json:"name"`
+	Action      string      `json:"action"`
+	Types       []EventType `json:"types,omitempty"`
+	Sources     []string    `json:"sources,omitempty"`
+	MinDataSize *int        `json:"min_data_size,omitempty"`
+	MaxDataSize *int        `json:"max_data_size,omitempty"`
+	Expr        string      `json:"expr,omitempty"`
+	Rate        *RateLimit  `json:"rate,omitempty"`
+}
+
+// RateLimit is a token bucket: Rate events per second up to Burst
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Filters is the rule set in effect, with each rule's hit count
+type Filters struct {
+	Default string             `json:"default"`
+	Rules   []FilterRuleStatus `json:"rules"`
+}
+
+// FilterRuleStatus is a filter rule and how many events it has matched
+type FilterRuleStatus struct {
+	FilterRule
+	Hits uint64 `json:"hits"`
+}
+
+// EventMessage is a processed event received from Watch
+type EventMessage struct {
+	ID            uint64            `json:"id"`
+	EventID       string            `json:"event_id,omitempty"`
+	Type          string            `json:"type"`
+	Source        string            `json:"source"`
+	Data          []byte            `json:"data,omitempty"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Timestamp     time.Time         `json:"timestamp"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
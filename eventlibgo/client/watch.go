@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WatchOptions selects the events Watch receives
+type WatchOptions struct {
+	// Types and Sources restrict the stream; empty receives everything
+	Types   []string
+	Sources []string
+
+	// LastEventID resumes after this event, receiving the missed events
+	// the server still retains
+	LastEventID uint64
+}
+
+// Watch streams processed events from the server's SSE endpoint, calling
+// fn for each until ctx is done, fn returns an error, or the stream ends.
+// It returns ctx's error once ctx is done. To resume after an error, call
+// Watch again with LastEventID set to the last event's ID.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions, fn func(EventMessage) error) error {
+	query := url.Values{}
+	if len(opts.Types) > 0 {
+		query.Set("type", strings.Join(opts.Types, ","))
+	}
+	if len(opts.Sources) > 0 {
+		query.Set("source", strings.Join(opts.Sources, ","))
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/events/sse", query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if opts.LastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(opts.LastEventID, 10))
+	}
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	// Events are "id:", "event:" and "data:" lines ended by a blank line;
+	// lines starting with ":" are keep-alives
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(value, " "))
+			}
+			continue
+		}
+		if data.Len() == 0 {
+			continue
+		}
+
+		var msg EventMessage
+		if err := json.Unmarshal([]byte(data.String()), &msg); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		data.Reset()
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream closed by server")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// bucketGrowth makes each histogram bucket 1% wider than the last, so
+// quantiles are within 1% whatever the range
+const bucketGrowth = 1.01
+
+// histogram counts latencies in logarithmic buckets, in constant memory
+// however long the run. It is not safe for concurrent use.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucket returns the index of the bucket holding d; bucket 0 holds
+// everything up to a microsecond
+func bucket(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	return int(math.Log(float64(d)/float64(time.Microsecond))/math.Log(bucketGrowth)) + 1
+}
+
+// upperBound is the largest latency counted in bucket i
+func upperBound(i int) time.Duration {
+	return time.Duration(float64(time.Microsecond) * math.Pow(bucketGrowth, float64(i)))
+}
+
+func (h *histogram) record(d time.Duration) {
+	i := bucket(d)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i-len(h.counts)+1)...)
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(other *histogram) {
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(other.counts)-len(h.counts))...)
+	}
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+	h.sum += other.sum
+	h.max = max(h.max, other.max)
+}
+
+// quantile returns the latency below which a fraction q of samples fall
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, n := range h.counts {
+		if seen += n; seen >= rank {
+			return min(upperBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// LatencySummary reports latencies in milliseconds
+type LatencySummary struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	P999  float64 `json:"p999_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func (h *histogram) summary() LatencySummary {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	s := LatencySummary{
+		Count: h.total,
+		P50:   ms(h.quantile(0.50)),
+		P90:   ms(h.quantile(0.90)),
+		P99:   ms(h.quantile(0.99)),
+		P999:  ms(h.quantile(0.999)),
+		Max:   ms(h.max),
+	}
+	if h.total > 0 {
+		s.Mean = ms(h.sum / time.Duration(h.total))
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// libraryTarget pushes into an in-process EventProcessor, measuring the
+// queue and handler path without HTTP: the C queue, or its pure-Go port
+// when built without cgo. Each payload starts with its push time, so the
+// handler can measure end-to-end latency.
+type libraryTarget struct {
+	processor *eventlib.EventProcessor
+	eventType eventlib.EventType
+	events    [][]eventlib.Event
+
+	mu        sync.Mutex
+	endToEnd  histogram
+	processed uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLibraryTarget() (*libraryTarget, error) {
+	et, err := eventlib.ParseEventType(*eventType)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &libraryTarget{
+		eventType: et,
+		events:    make([][]eventlib.Event, *concurrency),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	t.processor, err = eventlib.New(&eventlib.Config{
+		Name:           "eventlibbench",
+		MaxQueueSize:   *queueSize,
+		ProcessWorkers: *processWorkers,
+	}, &eventlib.Handlers{OnEvent: t.handle})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processor: %w", err)
+	}
+	if err := t.processor.Start(); err != nil {
+		return nil, err
+	}
+
+	// Push copies the data, so each worker reuses its payloads
+	for w := range t.events {
+		t.events[w] = make([]eventlib.Event, *batchSize)
+		for i := range t.events[w] {
+			t.events[w][i] = eventlib.Event{
+				Type:   et,
+				Source: fmt.Sprintf("bench-%d", w),
+				Data:   make([]byte, *payloadSize),
+			}
+		}
+	}
+
+	go t.processLoop()
+	return t, nil
+}
+
+// processLoop keeps the queue drained until finish
+func (t *libraryTarget) processLoop() {
+	defer close(t.done)
+	for {
+		select {
+		case <-t.stop:
+			t.processor.ProcessAll()
+			return
+		default:
+		}
+		if t.processor.QueueSize() == 0 {
+			time.Sleep(50 * time.Microsecond)
+			continue
+		}
+		t.processor.ProcessAll()
+	}
+}
+
+func (t *libraryTarget) handle(event eventlib.Event) error {
+	var latency time.Duration
+	if len(event.Data) >= 8 {
+		pushed := int64(binary.LittleEndian.Uint64(event.Data))
+		latency = time.Duration(time.Now().UnixNano() - pushed)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.processed++
+	if len(event.Data) >= 8 {
+		t.endToEnd.record(latency)
+	}
+	return nil
+}
+
+func (t *libraryTarget) push(ctx context.Context, worker, n int) error {
+	events := t.events[worker][:n]
+	now := uint64(time.Now().UnixNano())
+	for _, event := range events {
+		if len(event.Data) >= 8 {
+			binary.LittleEndian.PutUint64(event.Data, now)
+		}
+	}
+
+	if n == 1 {
+		return t.processor.Push(events[0])
+	}
+	accepted, errs := t.processor.PushBatch(events)
+	if accepted == n {
+		return nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return &partialError{failed: n - accepted, class: libraryErrorClass(err)}
+		}
+	}
+	return nil
+}
+
+func (t *libraryTarget) finish(r *Report) {
+	close(t.stop)
+	<-t.done
+	t.processor.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.Processed = t.processed
+	summary := t.endToEnd.summary()
+	r.EndToEnd = &summary
+}
+
+// libraryErrorClass names errors by the sentinel they wrap
+func libraryErrorClass(err error) string {
+	for _, sentinel := range []error{
+		eventlib.ErrQueueFull,
+		eventlib.ErrPaused,
+		eventlib.ErrDraining,
+		eventlib.ErrClosed,
+	} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return err.Error()
+}
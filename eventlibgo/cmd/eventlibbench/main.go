@@ -0,0 +1,301 @@
+// Command eventlibbench generates event load against a server or directly
+// against the Go bindings, and reports throughput, latency percentiles and
+// errors, for capacity planning.
+//
+// Load is open-loop: with -rate set, requests are sent on a fixed schedule
+// and latency is measured from when each was due, so a stalled target
+// shows up in the percentiles rather than slowing the load down.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sammyjroberts/eventlibgo/client"
+)
+
+var (
+	targetName  = flag.String("target", "server", "What to load: server, or library to push straight into an in-process processor")
+	rate        = flag.Float64("rate", 0, "Events per second across all workers (0 = as fast as possible)")
+	concurrency = flag.Int("concurrency", 4, "Workers sending at once")
+	duration    = flag.Duration("duration", 10*time.Second, "How long to generate load")
+	payloadSize = flag.Int("payload-size", 256, "Bytes of data per event")
+	batchSize   = flag.Int("batch-size", 1, "Events per request; above 1 uses batch pushes")
+	eventType   = flag.String("type", "DATA", "Event type of the generated events")
+	output      = flag.String("output", "table", "Report format: table or json")
+
+	server  = flag.String("server", client.DefaultServer, "Server base URL, for -target server")
+	token   = flag.String("token", os.Getenv("EVENTLIB_TOKEN"), "API key or JWT, for -target server (env EVENTLIB_TOKEN)")
+	timeout = flag.Duration("timeout", client.DefaultTimeout, "Request timeout, for -target server")
+
+	queueSize      = flag.Int("queue-size", 100000, "Queue limit, for -target library")
+	processWorkers = flag.Int("process-workers", 1, "Goroutines processing the queue, for -target library")
+)
+
+// target receives the generated load
+type target interface {
+	// push sends one request of n events. Worker identifies the calling
+	// worker, so targets can keep per-worker buffers.
+	push(ctx context.Context, worker, n int) error
+
+	// finish stops the target once the load has stopped, adding what it
+	// measured itself to the report
+	finish(r *Report)
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Target      string            `json:"target"`
+	Duration    float64           `json:"duration_seconds"`
+	Requests    uint64            `json:"requests"`
+	Events      uint64            `json:"events"`
+	Failed      uint64            `json:"failed_events"`
+	ErrorRate   float64           `json:"error_rate"`
+	Errors      map[string]uint64 `json:"errors,omitempty"`
+	Throughput  float64           `json:"events_per_second"`
+	RequestRate float64           `json:"requests_per_second"`
+	Latency     LatencySummary    `json:"request_latency"`
+
+	// Processed and EndToEnd are measured by the library target's handler
+	Processed uint64          `json:"processed,omitempty"`
+	EndToEnd  *LatencySummary `json:"end_to_end_latency,omitempty"`
+}
+
+// workerResult is what one worker measured
+type workerResult struct {
+	latency  histogram
+	requests uint64
+	events   uint64
+	failed   uint64
+	errors   map[string]uint64
+}
+
+func main() {
+	flag.Parse()
+
+	if err := validateFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "eventlibbench: %v\n", err)
+		os.Exit(2)
+	}
+
+	var t target
+	var err error
+	switch *targetName {
+	case "server":
+		t, err = newServerTarget()
+	case "library":
+		t, err = newLibraryTarget()
+	default:
+		err = fmt.Errorf("invalid -target %q: use server or library", *targetName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eventlibbench: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := run(ctx, t)
+	if err := printReport(report); err != nil {
+		fmt.Fprintf(os.Stderr, "eventlibbench: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func validateFlags() error {
+	switch {
+	case *concurrency <= 0:
+		return errors.New("-concurrency must be positive")
+	case *duration <= 0:
+		return errors.New("-duration must be positive")
+	case *batchSize <= 0:
+		return errors.New("-batch-size must be positive")
+	case *payloadSize < 0:
+		return errors.New("-payload-size cannot be negative")
+	case *rate < 0:
+		return errors.New("-rate cannot be negative")
+	case *output != "table" && *output != "json":
+		return fmt.Errorf("invalid -output %q: use table or json", *output)
+	}
+	return nil
+}
+
+// run generates load for -duration, or until ctx is done
+func run(ctx context.Context, t target) *Report {
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	// Each worker sends every interval, offset so the workers interleave
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(*concurrency**batchSize) / *rate)
+	}
+
+	start := time.Now()
+	results := make([]*workerResult, *concurrency)
+	var wg sync.WaitGroup
+	for w := range results {
+		results[w] = &workerResult{errors: make(map[string]uint64)}
+		offset := interval * time.Duration(w) / time.Duration(*concurrency)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(ctx, t, w, start.Add(offset), interval, results[w])
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &Report{
+		Target:   *targetName,
+		Duration: elapsed.Seconds(),
+		Errors:   make(map[string]uint64),
+	}
+	var latency histogram
+	for _, result := range results {
+		latency.merge(&result.latency)
+		report.Requests += result.requests
+		report.Events += result.events
+		report.Failed += result.failed
+		for class, n := range result.errors {
+			report.Errors[class] += n
+		}
+	}
+	report.Latency = latency.summary()
+	report.Throughput = float64(report.Events-report.Failed) / elapsed.Seconds()
+	report.RequestRate = float64(report.Requests) / elapsed.Seconds()
+	if report.Events > 0 {
+		report.ErrorRate = float64(report.Failed) / float64(report.Events)
+	}
+
+	t.finish(report)
+	return report
+}
+
+// work sends requests until ctx is done. With an interval, request k is
+// due at next + k*interval; a request sent late because the previous ones
+// were slow has its latency counted from when it was due.
+func work(ctx context.Context, t target, worker int, next time.Time, interval time.Duration, result *workerResult) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for ctx.Err() == nil {
+		due := time.Now()
+		if interval > 0 {
+			due = next
+			next = next.Add(interval)
+			if wait := time.Until(due); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+				// On schedule, so timer lateness is not the target's
+				due = time.Now()
+			}
+		}
+
+		err := t.push(ctx, worker, *batchSize)
+		if err != nil && ctx.Err() != nil {
+			return // Cut short by the end of the run
+		}
+
+		result.latency.record(time.Since(due))
+		result.requests++
+		result.events += uint64(*batchSize)
+		if err != nil {
+			var partial *partialError
+			if errors.As(err, &partial) {
+				result.failed += uint64(partial.failed)
+				result.errors[partial.class] += uint64(partial.failed)
+			} else {
+				result.failed += uint64(*batchSize)
+				result.errors[errorClass(err)] += uint64(*batchSize)
+			}
+		}
+	}
+}
+
+// partialError reports a batch in which only some events failed
+type partialError struct {
+	failed int
+	class  string
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d events failed: %s", e.failed, e.class)
+}
+
+// errorClass groups errors for the report: HTTP statuses by code, library
+// errors by message
+func errorClass(err error) string {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Sprintf("%d %s", apiErr.StatusCode, apiErr.Message)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return libraryErrorClass(err)
+}
+
+func printReport(r *Report) error {
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Target\t%s\n", r.Target)
+	fmt.Fprintf(w, "Duration\t%.2fs\n", r.Duration)
+	fmt.Fprintf(w, "Requests\t%d (%.1f/s)\n", r.Requests, r.RequestRate)
+	fmt.Fprintf(w, "Events\t%d\n", r.Events)
+	fmt.Fprintf(w, "Throughput\t%.1f events/s\n", r.Throughput)
+	fmt.Fprintf(w, "Failed\t%d (%.2f%%)\n", r.Failed, 100*r.ErrorRate)
+	if r.EndToEnd != nil {
+		fmt.Fprintf(w, "Processed\t%d\n", r.Processed)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Latency (ms)\tmean\tp50\tp90\tp99\tp99.9\tmax\t\n")
+	row := func(name string, s LatencySummary) {
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n", name, s.Mean, s.P50, s.P90, s.P99, s.P999, s.Max)
+	}
+	row("request", r.Latency)
+	if r.EndToEnd != nil {
+		row("end to end", *r.EndToEnd)
+	}
+	w.Flush()
+
+	if len(r.Errors) > 0 {
+		classes := make([]string, 0, len(r.Errors))
+		for class := range r.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "Error\tEvents\n")
+		for _, class := range classes {
+			fmt.Fprintf(w, "%s\t%d\n", class, r.Errors[class])
+		}
+		w.Flush()
+	}
+	return nil
+}
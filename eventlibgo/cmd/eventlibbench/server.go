@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/sammyjroberts/eventlibgo/client"
+)
+
+// serverTarget sends events to a server's API
+type serverTarget struct {
+	client *client.Client
+	events [][]client.Event
+}
+
+func newServerTarget() (*serverTarget, error) {
+	// Enough idle connections that workers do not dial for every request
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = *concurrency
+
+	c, err := client.New(client.Config{
+		Server:     *server,
+		Token:      *token,
+		Timeout:    *timeout,
+		HTTPClient: &http.Client{Transport: transport},
+		UserAgent:  "eventlibbench",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, *payloadSize)
+	rand.Read(payload)
+
+	// The same events are sent every time, so they are built once
+	t := &serverTarget{client: c, events: make([][]client.Event, *concurrency)}
+	for w := range t.events {
+		t.events[w] = make([]client.Event, *batchSize)
+		for i := range t.events[w] {
+			t.events[w][i] = client.Event{
+				Type:   client.EventType(*eventType),
+				Source: fmt.Sprintf("bench-%d", w),
+				Data:   payload,
+			}
+		}
+	}
+	return t, nil
+}
+
+func (t *serverTarget) push(ctx context.Context, worker, n int) error {
+	events := t.events[worker][:n]
+	if n == 1 {
+		_, err := t.client.Push(ctx, events[0])
+		return err
+	}
+
+	result, err := t.client.PushBatch(ctx, events)
+	if err != nil {
+		return err
+	}
+	if failed := n - result.Queued; failed > 0 {
+		class := "rejected in batch"
+		if result.RateLimited > 0 {
+			class = "rate limited in batch"
+		}
+		return &partialError{failed: failed, class: class}
+	}
+	return nil
+}
+
+func (t *serverTarget) finish(r *Report) {}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sammyjroberts/eventlibgo/client"
+)
+
+func runPush(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	eventType := fs.String("type", "DATA", "Event type name or number")
+	source := fs.String("source", "eventlibctl", "Event source")
+	data := fs.String("data", "", "Payload, sent as is")
+	dataFile := fs.String("data-file", "", "File to read the payload from; - for stdin")
+	dataJSON := fs.String("json", "", "Structured JSON payload, instead of -data")
+	contentType := fs.String("content-type", "", "Payload MIME type")
+	id := fs.String("id", "", "Event ID, for deduplication")
+	correlationID := fs.String("correlation-id", "", "Correlation ID")
+	priority := fs.String("priority", "", "Priority in priority queue mode")
+	delay := fs.Duration("delay", 0, "Hold the event back this long")
+	metadata := make(map[string]string)
+	fs.Func("meta", "Metadata as key=value; repeatable", func(s string) error {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("metadata must be key=value")
+		}
+		metadata[key] = value
+		return nil
+	})
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	event := client.Event{
+		Type:          client.EventType(*eventType),
+		Source:        *source,
+		ContentType:   *contentType,
+		ID:            *id,
+		CorrelationID: *correlationID,
+		DelayMs:       delay.Milliseconds(),
+	}
+	if len(metadata) > 0 {
+		event.Metadata = metadata
+	}
+	if *priority != "" {
+		p, err := strconv.Atoi(*priority)
+		if err != nil {
+			return fmt.Errorf("invalid -priority %q", *priority)
+		}
+		event.Priority = &p
+	}
+
+	switch {
+	case *dataJSON != "":
+		if !json.Valid([]byte(*dataJSON)) {
+			return fmt.Errorf("-json is not valid JSON")
+		}
+		event.DataJSON = json.RawMessage(*dataJSON)
+	case *dataFile != "":
+		payload, err := readInput(*dataFile)
+		if err != nil {
+			return err
+		}
+		event.Data = payload
+	default:
+		event.Data = []byte(*data)
+	}
+
+	result, err := c.Push(ctx, event)
+	if err != nil {
+		return err
+	}
+	return render(result, func(t *table) {
+		t.row("STATUS", "EVENT ID", "DELIVER AT")
+		t.row(result.Status, result.EventID, result.DeliverAt)
+	})
+}
+
+func runPushBatch(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	batchSize := fs.Int("batch-size", 100, "Events sent per request")
+	atomic := fs.Bool("atomic", false, "Queue none of a request's events if any is invalid or rate limited, and stop")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch-size must be positive")
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	in := os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	push := c.PushBatch
+	if *atomic {
+		push = c.PushBatchAtomic
+	}
+
+	var total client.BatchResult
+	var batch []client.Event
+	var lines []int // line of each event in batch
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := push(ctx, batch)
+		if result != nil {
+			for _, r := range result.Results {
+				if r.Queued() || r.Index >= len(lines) {
+					continue
+				}
+				if r.Error != "" {
+					fmt.Fprintf(os.Stderr, "line %d: %s: %s\n", lines[r.Index], r.Status, r.Error)
+				} else {
+					fmt.Fprintf(os.Stderr, "line %d: %s\n", lines[r.Index], r.Status)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		total.Queued += result.Queued
+		total.Failed += result.Failed
+		total.RateLimited += result.RateLimited
+		total.SchemaInvalid += result.SchemaInvalid
+		total.TooLarge += result.TooLarge
+		batch, lines = batch[:0], lines[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var event client.Event
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		batch = append(batch, event)
+		lines = append(lines, line)
+		if len(batch) == *batchSize {
+			if err := send(); err != nil {
+				return fmt.Errorf("batch ending at line %d: %w", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := send(); err != nil {
+		return err
+	}
+
+	err := render(total, func(t *table) {
+		t.row("QUEUED", "FAILED", "RATE LIMITED", "SCHEMA INVALID", "TOO LARGE")
+		t.row(total.Queued, total.Failed, total.RateLimited, total.SchemaInvalid, total.TooLarge)
+	})
+	if err == nil && total.Failed > 0 {
+		err = fmt.Errorf("%d events failed", total.Failed)
+	}
+	return err
+}
+
+func runStatus(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return render(status, func(t *table) {
+		t.row("STATE", "QUEUE SIZE", "PROCESSED")
+		t.row(status.State, status.QueueSize, status.EventsProcessed)
+	})
+}
+
+// errWatchDone stops watch once -count events are printed
+var errWatchDone = errors.New("count reached")
+
+func runWatch(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	types := fs.String("type", "", "Comma-separated event types to show")
+	sources := fs.String("source", "", "Comma-separated sources to show")
+	count := fs.Int("count", 0, "Exit after this many events (0 = until interrupted)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	opts := client.WatchOptions{
+		Types:   splitList(*types),
+		Sources: splitList(*sources),
+	}
+	out := newTable(os.Stdout)
+	if *output == "table" {
+		out.row("ID", "TYPE", "SOURCE", "TIMESTAMP", "DATA")
+		out.flush()
+	}
+	seen := 0
+	show := func(msg client.EventMessage) error {
+		opts.LastEventID = msg.ID
+		if *output == "json" {
+			if err := writeJSON(os.Stdout, msg, false); err != nil {
+				return err
+			}
+		} else {
+			out.row(msg.ID, msg.Type, msg.Source, msg.Timestamp.Format(time.RFC3339Nano), preview(msg.Data))
+			out.flush()
+		}
+		if seen++; *count > 0 && seen >= *count {
+			return errWatchDone
+		}
+		return nil
+	}
+
+	// Reconnect after the stream drops, resuming after the last event
+	for {
+		err := c.Watch(ctx, opts, show)
+		switch {
+		case errors.Is(err, errWatchDone), ctx.Err() != nil:
+			return nil
+		case errors.As(err, new(*client.APIError)):
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "eventlibctl watch: %v; reconnecting\n", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func runDrain(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	result, err := c.ProcessAll(ctx)
+	if err != nil {
+		return err
+	}
+	return render(result, func(t *table) {
+		t.row("PROCESSED", "DURATION")
+		t.row(result.Processed, result.Duration)
+	})
+}
+
+func runReplay(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	from := fs.String("from", "", "Replay events processed at or after this time: RFC 3339, or a duration ago such as 1h")
+	to := fs.String("to", "", "Replay events processed before this time")
+	types := fs.String("type", "", "Comma-separated event types to replay")
+	sources := fs.String("source", "", "Comma-separated source patterns to replay")
+	limit := fs.Int("limit", 0, "Replay at most this many events, oldest first (0 = all)")
+	dryRun := fs.Bool("dry-run", false, "Count the matching events without replaying them")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	request := client.ReplayRequest{
+		Sources: splitList(*sources),
+		Limit:   *limit,
+		DryRun:  *dryRun,
+	}
+	for _, t := range splitList(*types) {
+		request.Types = append(request.Types, client.EventType(t))
+	}
+	var err error
+	if request.From, err = parseTime(*from); err != nil {
+		return err
+	}
+	if request.To, err = parseTime(*to); err != nil {
+		return err
+	}
+
+	result, err := c.Replay(ctx, request)
+	if err != nil {
+		return err
+	}
+	return render(result, func(t *table) {
+		t.row("MATCHED", "QUEUED", "FAILED", "DRY RUN")
+		t.row(result.Matched, result.Queued, result.Failed, result.DryRun)
+	})
+}
+
+func runFilters(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	var filters *client.Filters
+	var err error
+	switch {
+	case fs.NArg() == 0:
+		filters, err = c.Filters(ctx)
+	case fs.NArg() == 2 && fs.Arg(0) == "set":
+		var data []byte
+		if data, err = readInput(fs.Arg(1)); err != nil {
+			return err
+		}
+		var config client.FilterConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("invalid filter config: %w", err)
+		}
+		filters, err = c.SetFilters(ctx, config)
+	default:
+		fs.Usage()
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	return render(filters, func(t *table) {
+		t.row("NAME", "ACTION", "TYPES", "SOURCES", "EXPR", "HITS")
+		for _, rule := range filters.Rules {
+			types := make([]string, len(rule.Types))
+			for i, et := range rule.Types {
+				types[i] = string(et)
+			}
+			t.row(rule.Name, rule.Action, strings.Join(types, ","), strings.Join(rule.Sources, ","), rule.Expr, rule.Hits)
+		}
+		t.row("(default)", filters.Default, "", "", "", "")
+	})
+}
+
+// readInput reads a file, or stdin for "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
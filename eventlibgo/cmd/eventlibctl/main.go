@@ -0,0 +1,164 @@
+// Command eventlibctl drives an eventlib server from the command line, for
+// operators and CI scripts. It is built on the client package and needs no
+// C toolchain.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sammyjroberts/eventlibgo/client"
+)
+
+var (
+	server   = flag.String("server", envOr("EVENTLIB_SERVER", client.DefaultServer), "Server base URL, or unix:///path for a Unix socket (env EVENTLIB_SERVER)")
+	token    = flag.String("token", os.Getenv("EVENTLIB_TOKEN"), "API key or JWT sent as a bearer token (env EVENTLIB_TOKEN)")
+	output   = flag.String("output", "table", "Output format: table or json")
+	timeout  = flag.Duration("timeout", client.DefaultTimeout, "Timeout for each request; watch is not limited")
+	compress = flag.Bool("compress", false, "Gzip request bodies, such as large batches")
+)
+
+// command is an eventlibctl subcommand. run defines its flags on fs and
+// parses the arguments after the command name.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, c *client.Client, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"push", "[flags]", "Submit an event", runPush},
+	{"push-batch", "[flags] [FILE|-]", "Submit events from NDJSON, one event per line", runPushBatch},
+	{"status", "", "Show the processor status", runStatus},
+	{"watch", "[flags]", "Print processed events as they are handled", runWatch},
+	{"drain", "", "Process every queued event", runDrain},
+	{"replay", "[flags]", "Enqueue processed events from the history again", runReplay},
+	{"filters", "[set FILE|-]", "Show the filter rules, or replace them", runFilters},
+}
+
+// errUsage reports bad arguments; the usage has already been printed
+var errUsage = errors.New("usage")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "eventlibctl: invalid -output %q: use table or json\n", *output)
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "eventlibctl: unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	c, err := client.New(client.Config{
+		Server:    *server,
+		Token:     *token,
+		Timeout:   *timeout,
+		UserAgent: "eventlibctl",
+		Compress:  *compress,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eventlibctl: %v\n", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = cmd.run(ctx, c, newFlagSet(cmd), flag.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "eventlibctl %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: eventlibctl [flags] <command> [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun eventlibctl <command> -h for a command's flags.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// newFlagSet returns the flag set for cmd, printing its usage on errors
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: eventlibctl %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses a command's flags, mapping -h and bad flags to errUsage
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		return errUsage
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTime accepts RFC 3339 or a duration before now, such as 1h
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q: use RFC 3339 or a duration ago such as 1h", s)
+	}
+	return &t, nil
+}
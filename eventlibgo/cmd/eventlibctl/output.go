@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// table writes aligned columns
+type table struct {
+	w *tabwriter.Writer
+}
+
+func newTable(w io.Writer) *table {
+	return &table{w: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)}
+}
+
+func (t *table) row(cells ...any) {
+	for i, cell := range cells {
+		if i > 0 {
+			fmt.Fprint(t.w, "\t")
+		}
+		fmt.Fprint(t.w, cell)
+	}
+	fmt.Fprintln(t.w)
+}
+
+func (t *table) flush() {
+	t.w.Flush()
+}
+
+// render prints v as indented JSON with -output json, or as the table
+// built by rows
+func render(v any, rows func(t *table)) error {
+	if *output == "json" {
+		return writeJSON(os.Stdout, v, true)
+	}
+	t := newTable(os.Stdout)
+	rows(t)
+	return t.w.Flush()
+}
+
+func writeJSON(w io.Writer, v any, indent bool) error {
+	enc := json.NewEncoder(w)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(v)
+}
+
+// preview shows a payload in a table: text up to 60 characters, or its
+// size if it is binary
+func preview(data []byte) string {
+	const limit = 60
+	if !utf8.Valid(data) || strings.ContainsFunc(string(data), func(r rune) bool {
+		return r < ' ' && r != '\t'
+	}) {
+		return fmt.Sprintf("<%d bytes>", len(data))
+	}
+	s := string(data)
+	if utf8.RuneCountInString(s) > limit {
+		s = string([]rune(s)[:limit]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,174 @@
+package eventlib
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Dedup window defaults
+const (
+	DefaultDedupWindow  = 5 * time.Minute
+	DefaultDedupMaxKeys = 100000
+)
+
+// DedupConfig suppresses events already pushed within a recent window.
+// Zero fields take their defaults.
+type DedupConfig struct {
+	// Window is how long a pushed event's key is remembered
+	Window time.Duration
+
+	// MaxKeys bounds the keys remembered; past it the oldest are forgotten
+	// early
+	MaxKeys int
+
+	// Key identifies duplicates; events with the same key are the same
+	// event. It defaults to DedupKey.
+	Key func(Event) string
+}
+
+// validate reports a config that cannot be used
+func (c DedupConfig) validate() error {
+	switch {
+	case c.Window < 0:
+		return fmt.Errorf("%w: negative dedup window %v", ErrInvalidConfig, c.Window)
+	case c.MaxKeys < 0:
+		return fmt.Errorf("%w: negative dedup MaxKeys %d", ErrInvalidConfig, c.MaxKeys)
+	}
+	return nil
+}
+
+// DedupKey is the default dedup key: the event's ID if it has one,
+// otherwise a hash of its type, source and data
+func DedupKey(event Event) string {
+	if event.ID != "" {
+		return "id:" + event.ID
+	}
+
+	h := fnv.New128a()
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(event.Type)))
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(event.Source))))
+	h.Write([]byte(event.Source))
+	h.Write(event.Data)
+	return "hash:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// dedupEntry is a remembered key and when it was first seen
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// dedupWindow remembers the keys of recently pushed events. Keys are
+// forgotten in the order they were seen, which is also the order they
+// expire in.
+type dedupWindow struct {
+	window  time.Duration
+	maxKeys int
+	keyFunc func(Event) string
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []dedupEntry
+	head  int
+}
+
+func newDedupWindow(config DedupConfig) *dedupWindow {
+	d := &dedupWindow{
+		window:  config.Window,
+		maxKeys: config.MaxKeys,
+		keyFunc: config.Key,
+		seen:    make(map[string]time.Time),
+	}
+	if d.window == 0 {
+		d.window = DefaultDedupWindow
+	}
+	if d.maxKeys == 0 {
+		d.maxKeys = DefaultDedupMaxKeys
+	}
+	if d.keyFunc == nil {
+		d.keyFunc = DedupKey
+	}
+	return d
+}
+
+// admit remembers event's key and returns it, or reports that the key was
+// seen within the window. An empty key is never a duplicate.
+func (d *dedupWindow) admit(event Event) (key string, duplicate bool) {
+	key = d.keyFunc(event)
+	if key == "" {
+		return "", false
+	}
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire(now)
+	if _, ok := d.seen[key]; ok {
+		return key, true
+	}
+
+	if len(d.seen) >= d.maxKeys {
+		d.evictOldest()
+	}
+	d.seen[key] = now
+	d.order = append(d.order, dedupEntry{key: key, seen: now})
+	return key, false
+}
+
+// forget drops a key admitted for an event that was then not queued, so a
+// retry is not mistaken for a duplicate
+func (d *dedupWindow) forget(key string) {
+	if key == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.seen, key)
+}
+
+// expire forgets keys older than the window
+func (d *dedupWindow) expire(now time.Time) {
+	cutoff := now.Add(-d.window)
+	for d.head < len(d.order) && !d.order[d.head].seen.After(cutoff) {
+		d.drop(d.order[d.head])
+		d.head++
+	}
+	d.compact()
+}
+
+// evictOldest forgets the oldest key still remembered
+func (d *dedupWindow) evictOldest() {
+	for d.head < len(d.order) {
+		entry := d.order[d.head]
+		d.head++
+		if d.drop(entry) {
+			break
+		}
+	}
+	d.compact()
+}
+
+// drop forgets entry's key unless it has since been forgotten and seen
+// again, reporting whether it did
+func (d *dedupWindow) drop(entry dedupEntry) bool {
+	if seen, ok := d.seen[entry.key]; ok && seen.Equal(entry.seen) {
+		delete(d.seen, entry.key)
+		return true
+	}
+	return false
+}
+
+// compact reclaims the consumed front of order once it is most of it
+func (d *dedupWindow) compact() {
+	if d.head > 0 && d.head >= len(d.order)/2 {
+		d.order = append(d.order[:0], d.order[d.head:]...)
+		d.head = 0
+	}
+}
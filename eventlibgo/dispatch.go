@@ -0,0 +1,193 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// The engine calls these as its callbacks, with events already copied into
+// Go memory. id is the event's journal sequence, 0 if it has none.
+
+// handleEvent runs the handlers for an event taken off the queue
+func (ep *EventProcessor) handleEvent(event Event, id uint64) {
+	// A retry keeps the journal entry until it is requeued
+	retrying := false
+	defer func() {
+		if !retrying {
+			ep.ack(id)
+		}
+	}()
+
+	event.ProcessedAt = time.Now()
+	queued := event.QueueLatency()
+
+	set := ep.acquireHandlers()
+	defer set.release()
+
+	// Routed handlers first, then the catch-all
+	handlers := ep.router.Match(event)
+	if set.OnEvent != nil {
+		handlers = append(handlers, ep.router.Wrap(set.OnEvent))
+	}
+
+	if len(handlers) == 0 {
+		ep.stats.recordHandled(event.Type, 0, queued)
+		ep.subs.publish(event)
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomeHandled, Attempts: event.Attempt + 1})
+		return
+	}
+
+	original := event
+	event, span := ep.startProcessSpan(event)
+
+	// Call each handler with recovery, so one failure doesn't skip the rest
+	start := event.ProcessedAt
+	var errs []error
+	for _, handler := range handlers {
+		if err := ep.watchHandler(handler, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	endProcessSpan(span, err)
+	ep.stats.recordHandled(event.Type, time.Since(start), queued)
+
+	if err != nil {
+		retrying = ep.handleFailure(original, id, err)
+		return
+	}
+	ep.subs.publish(event)
+	ep.waiters.settle(event.ID, Outcome{Status: OutcomeHandled, Attempts: event.Attempt + 1})
+}
+
+// callHandler runs an event handler, turning a panic into an error
+func (ep *EventProcessor) callHandler(handler EventHandler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ep.logger.Error("Panic in event handler",
+				slog.Any("panic", r),
+				slog.String("event_type", event.Type.String()))
+			ep.countPanic()
+			ep.reportPanic(HandlerEvent, event, r)
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+		}
+	}()
+	ep.config.Faults.handle()
+	return handler(event)
+}
+
+// handleLog forwards an engine log message to the processor's logger
+func (ep *EventProcessor) handleLog(level, message string) {
+	// Map C log levels to slog
+	switch level {
+	case "DEBUG":
+		ep.logger.Debug(message)
+	case "INFO":
+		ep.logger.Info(message)
+	case "WARN":
+		ep.logger.Warn(message)
+	case "ERROR":
+		ep.logger.Error(message)
+	default:
+		ep.logger.Info(message, slog.String("level", level))
+	}
+}
+
+// handleFilter runs OnFilter and reports whether the event should be
+// queued
+func (ep *EventProcessor) handleFilter(event Event, id uint64) bool {
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnFilter == nil {
+		return true
+	}
+
+	// Call filter with recovery
+	allow := true
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ep.logger.Error("Panic in filter handler",
+					slog.Any("panic", r))
+				ep.reportPanic(HandlerFilter, event, r)
+				allow = true // Default to allowing on error
+			}
+		}()
+		allow = handlers.OnFilter(event)
+	}()
+
+	if allow {
+		return true
+	}
+	ep.stats.filtered.Add(1)
+	ep.ack(id)
+	ep.waiters.settle(event.ID, Outcome{Status: OutcomeFiltered})
+	return false
+}
+
+// handleStateChange runs OnStateChange, if set
+func (ep *EventProcessor) handleStateChange(oldState, newState string) {
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnStateChange == nil {
+		return
+	}
+
+	// Call handler with recovery
+	defer func() {
+		if r := recover(); r != nil {
+			ep.logger.Error("Panic in state change handler",
+				slog.Any("panic", r))
+			ep.reportPanic(HandlerStateChange, Event{}, r)
+		}
+	}()
+	handlers.OnStateChange(oldState, newState)
+}
+
+// handleQueuePressure runs OnQueuePressure, if set
+func (ep *EventProcessor) handleQueuePressure(high bool, queueSize int) {
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnQueuePressure == nil {
+		return
+	}
+
+	// Call handler with recovery
+	defer func() {
+		if r := recover(); r != nil {
+			ep.logger.Error("Panic in queue pressure handler",
+				slog.Any("panic", r))
+			ep.reportPanic(HandlerQueuePressure, Event{}, r)
+		}
+	}()
+	handlers.OnQueuePressure(high, queueSize)
+}
+
+// handleExpired settles an event that missed its deadline and passes it to
+// OnExpired, if set. Without OnExpired or a waiter, only Attempt need be
+// filled in.
+func (ep *EventProcessor) handleExpired(event Event, id uint64) {
+	defer ep.ack(id)
+	defer ep.waiters.settle(event.ID, Outcome{Status: OutcomeExpired, Attempts: event.Attempt})
+
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnExpired == nil {
+		return
+	}
+
+	// Call handler with recovery
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ep.logger.Error("Panic in expired handler",
+					slog.Any("panic", r),
+					slog.String("event_type", event.Type.String()))
+				ep.reportPanic(HandlerExpired, event, r)
+			}
+		}()
+		handlers.OnExpired(event)
+	}()
+}
@@ -0,0 +1,73 @@
+//go:build eventlib_dlopen && eventlib_embed && cgo && !nocgo
+
+package eventlib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+// Prebuilt shared libraries, laid out as prebuilt/<GOOS>_<GOARCH>/<lib>.
+// Populate with `make -C ../eventlib prebuilt` before building.
+//
+//go:embed prebuilt
+var prebuilt embed.FS
+
+func init() {
+	embeddedLibrary = extractLibrary
+}
+
+// extractLibrary writes the library for this platform to a content-addressed
+// cache directory, reusing an earlier extraction when it is intact
+func extractLibrary() (string, error) {
+	name := path.Join("prebuilt", runtime.GOOS+"_"+runtime.GOARCH, libraryName())
+
+	data, err := prebuilt.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("no prebuilt eventlib for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	sum := sha256.Sum256(data)
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	dir := filepath.Join(cacheDir, "eventlib", hex.EncodeToString(sum[:8]))
+	target := filepath.Join(dir, libraryName())
+
+	if existing, err := os.ReadFile(target); err == nil && bytes.Equal(existing, data) {
+		return target, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// Write then rename so concurrent processes never load a partial file
+	tmp, err := os.CreateTemp(dir, "lib-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to extract eventlib: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to extract eventlib: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to extract eventlib: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", fmt.Errorf("failed to extract eventlib: %w", err)
+	}
+
+	return target, nil
+}
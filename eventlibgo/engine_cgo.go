@@ -0,0 +1,617 @@
+//go:build !nocgo
+
+package eventlib
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../eventlib
+#include "eventlib.h"
+#include <stdlib.h>
+
+// Forward declarations for Go callbacks
+// user_data carries a runtime/cgo.Handle, passed back to Go as an integer
+extern void goHandleEvent(void* event, uintptr_t handle);
+extern void goHandleLog(void* level, void* message, uintptr_t handle);
+extern int goHandleFilter(void* event, uintptr_t handle);
+extern void goHandleStateChange(void* old_state, void* new_state, uintptr_t handle);
+extern void goHandleExpired(void* event, uintptr_t handle);
+extern void goHandleQueuePressure(int high, size_t queue_size, uintptr_t handle);
+extern void goSnapshotEvent(void* event, uintptr_t handle);
+extern int goPurgeMatch(void* event, uintptr_t handle);
+extern void goPurgeEvent(void* event, uintptr_t handle);
+
+// C wrapper functions that call Go
+static void c_handle_event(const event_t* event, void* user_data) {
+    goHandleEvent((void*)event, (uintptr_t)user_data);
+}
+
+static void c_handle_log(const char* level, const char* message, void* user_data) {
+    goHandleLog((void*)level, (void*)message, (uintptr_t)user_data);
+}
+
+static bool c_handle_filter(const event_t* event, void* user_data) {
+    return goHandleFilter((void*)event, (uintptr_t)user_data) != 0;
+}
+
+static void c_handle_state_change(const char* old_state, const char* new_state, void* user_data) {
+    goHandleStateChange((void*)old_state, (void*)new_state, (uintptr_t)user_data);
+}
+
+static void c_handle_expired(const event_t* event, void* user_data) {
+    goHandleExpired((void*)event, (uintptr_t)user_data);
+}
+
+static void c_handle_queue_pressure(bool high, size_t queue_size, void* user_data) {
+    goHandleQueuePressure(high ? 1 : 0, queue_size, (uintptr_t)user_data);
+}
+
+static void c_snapshot_event(const event_t* event, void* user_data) {
+    goSnapshotEvent((void*)event, (uintptr_t)user_data);
+}
+
+static bool c_purge_match(const event_t* event, void* user_data) {
+    return goPurgeMatch((void*)event, (uintptr_t)user_data) != 0;
+}
+
+static void c_purge_event(const event_t* event, void* user_data) {
+    goPurgeEvent((void*)event, (uintptr_t)user_data);
+}
+
+// Helper to push an event without building event_t in Go memory
+static eventlib_error_t push_event_go(event_processor_t* proc, event_type_t type,
+                                      const char* source, const void* data, size_t data_len,
+                                      int64_t deadline_ms, int64_t timestamp_ms, uint32_t flags,
+                                      uint64_t id, int32_t priority, const char* content_type,
+                                      int64_t enqueued_ns, const char* trace_parent,
+                                      uint32_t attempt, const char* event_id,
+                                      const char* correlation_id, const void* metadata,
+                                      size_t metadata_len, bool owned) {
+    event_t event = {
+        .type = type,
+        .source = source,
+        .data = data,
+        .data_len = data_len,
+        .deadline_ms = deadline_ms,
+        .timestamp_ms = timestamp_ms,
+        .flags = flags,
+        .id = id,
+        .priority = priority,
+        .content_type = content_type,
+        .enqueued_ns = enqueued_ns,
+        .trace_parent = trace_parent,
+        .attempt = attempt,
+        .event_id = event_id,
+        .correlation_id = correlation_id,
+        .metadata = metadata,
+        .metadata_len = metadata_len
+    };
+    if (owned)
+        return event_processor_submit_owned(proc, &event);
+    return event_processor_submit(proc, &event);
+}
+
+// Sets a cancellation flag read by event_processor_process_n
+static void cancel_flag_set(int* flag) {
+    __atomic_store_n(flag, 1, __ATOMIC_RELEASE);
+}
+
+// Helper to create processor with Go callbacks
+static event_processor_t* create_processor_go(const char* name, size_t max_queue_size,
+                                              bool enable_logging, event_queue_mode_t queue_mode,
+                                              uintptr_t handle) {
+    event_config_t config = {
+        .name = name,
+        .max_queue_size = max_queue_size,
+        .enable_logging = enable_logging,
+        .queue_mode = queue_mode,
+        .on_event = c_handle_event,
+        .on_log = c_handle_log,
+        .on_filter = c_handle_filter,
+        .on_state_change = c_handle_state_change,
+        .on_expired = c_handle_expired,
+        .on_queue_pressure = c_handle_queue_pressure,
+        .user_data = (void*)handle
+    };
+    return event_processor_create(&config);
+}
+
+// Helper to snapshot the queue into the Go slice behind handle
+static eventlib_error_t snapshot_queue_go(event_processor_t* proc, uintptr_t handle) {
+    return event_processor_snapshot(proc, c_snapshot_event, (void*)handle);
+}
+
+// Helper to copy the head of the queue into the Go slice behind handle
+static eventlib_error_t peek_queue_go(event_processor_t* proc, size_t max, uintptr_t handle) {
+    return event_processor_peek(proc, max, c_snapshot_event, (void*)handle);
+}
+
+// Helper to purge the queue with the purgeVisitor behind handle
+static size_t purge_queue_go(event_processor_t* proc, uintptr_t handle) {
+    return event_processor_purge(proc, c_purge_match, c_purge_event, (void*)handle);
+}
+*/
+import "C"
+import (
+	"fmt"
+	"runtime/cgo"
+	"sync"
+	"time"
+	"unsafe"
+	"weak"
+)
+
+// engine is the queue behind an EventProcessor: the C library, calling
+// back into the processor through a cgo.Handle. The processor holds its
+// read lock around every call, so Close cannot destroy it mid-call.
+type engine struct {
+	ep     *EventProcessor
+	cptr   *C.event_processor_t
+	handle cgo.Handle
+	mode   QueueMode
+}
+
+// newEngine creates the C processor for ep with config. ep's name,
+// handlers and logger must already be set since C logs through them
+// straight away.
+func newEngine(ep *EventProcessor, config EngineConfig) (*engine, error) {
+	// The handle lets C callbacks find ep without passing a Go pointer. It
+	// holds ep weakly, so that a processor dropped without Close can still
+	// be collected and its leak reported.
+	e := &engine{ep: ep, handle: cgo.NewHandle(weak.Make(ep)), mode: config.QueueMode}
+
+	cName := C.CString(ep.config.Name)
+	defer C.free(unsafe.Pointer(cName))
+
+	e.cptr = C.create_processor_go(
+		cName,
+		C.size_t(config.MaxQueueSize),
+		C.bool(config.EnableLogging),
+		C.event_queue_mode_t(config.QueueMode),
+		C.uintptr_t(e.handle),
+	)
+	if e.cptr == nil {
+		e.handle.Delete()
+		return nil, fmt.Errorf("failed to create processor")
+	}
+	liveHandles.Add(1)
+	return e, nil
+}
+
+// destroy frees the C processor and anything still queued in it
+func (e *engine) destroy() {
+	C.event_processor_destroy(e.cptr)
+	e.cptr = nil
+
+	// Destroy logs through the callbacks, so the handle must outlive it
+	e.handle.Delete()
+	liveHandles.Add(-1)
+}
+
+// release returns what destroy does, without holding on to e or its
+// processor, for the cleanup of a processor dropped without Close
+func (e *engine) release() func() {
+	cptr, handle := e.cptr, e.handle
+	return func() {
+		C.event_processor_destroy(cptr)
+		handle.Delete()
+		liveHandles.Add(-1)
+	}
+}
+
+// newCError wraps a C error code, mapping it to a sentinel where one fits
+func newCError(op string, code C.eventlib_error_t) *CError {
+	err := &CError{
+		Op:      op,
+		Code:    int(code),
+		Message: C.GoString(C.eventlib_strerror(code)),
+	}
+	if code == C.EVENTLIB_ERR_QUEUE_FULL {
+		err.kind = ErrQueueFull
+	}
+	return err
+}
+
+func (e *engine) start() {
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_start(e.cptr)
+}
+
+func (e *engine) stop() {
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_stop(e.cptr)
+}
+
+// submit hands an event to the C queue, tagged with its journal sequence.
+// If owned is set, event.Data is C memory from a Buffer that the queue
+// takes over on success; otherwise C copies it.
+func (e *engine) submit(event Event, id uint64, owned bool) error {
+	// The strings go to C in one pooled Go buffer rather than a C.CString
+	// each; C copies what it keeps before returning
+	cs := getCStrings()
+	defer putCStrings(cs)
+	source := cs.add(event.Source)
+	contentType := cs.addOptional(event.ContentType)
+	traceParent := cs.addOptional(event.TraceParent)
+	eventID := cs.addOptional(event.ID)
+	correlationID := cs.addOptional(event.CorrelationID)
+	metadata, metadataLen := cs.addMetadata(event.Metadata)
+
+	var dataPtr unsafe.Pointer
+	if len(event.Data) > 0 {
+		dataPtr = unsafe.Pointer(&event.Data[0])
+	}
+
+	var deadline, timestamp, enqueued int64
+	if !event.Deadline.IsZero() {
+		deadline = event.Deadline.UnixMilli()
+	}
+	if !event.Timestamp.IsZero() {
+		timestamp = event.Timestamp.UnixMilli()
+	}
+	if !event.EnqueuedAt.IsZero() {
+		enqueued = event.EnqueuedAt.UnixNano()
+	}
+
+	var flags C.uint32_t
+	if event.Backfill {
+		flags |= C.EVENT_FLAG_BACKFILL
+	}
+
+	e.ep.stats.cgoCalls.Add(1)
+	defer e.ep.observeCgo("push", time.Now())
+	code := C.push_event_go(
+		e.cptr,
+		C.event_type_t(event.Type),
+		cs.ptr(source),
+		dataPtr,
+		C.size_t(len(event.Data)),
+		C.int64_t(deadline),
+		C.int64_t(timestamp),
+		flags,
+		C.uint64_t(id),
+		C.int32_t(event.Priority),
+		cs.ptr(contentType),
+		C.int64_t(enqueued),
+		cs.ptr(traceParent),
+		C.uint32_t(event.Attempt),
+		cs.ptr(eventID),
+		cs.ptr(correlationID),
+		cs.bytes(metadata),
+		C.size_t(metadataLen),
+		C.bool(owned),
+	)
+
+	if code != C.EVENTLIB_OK {
+		return newCError("push", code)
+	}
+	return nil
+}
+
+// submitBatch copies the events at index into C memory and submits them
+// in one call, setting errs for those refused. C may not hold Go pointers,
+// so strings and data go into one C buffer.
+func (e *engine) submitBatch(events []Event, ids []uint64, index []int, errs []error) {
+	n := len(index)
+
+	size := 0
+	for _, i := range index {
+		size += len(events[i].Source) + 1 + len(events[i].Data)
+		if events[i].ContentType != "" {
+			size += len(events[i].ContentType) + 1
+		}
+		if events[i].TraceParent != "" {
+			size += len(events[i].TraceParent) + 1
+		}
+		if events[i].ID != "" {
+			size += len(events[i].ID) + 1
+		}
+		if events[i].CorrelationID != "" {
+			size += len(events[i].CorrelationID) + 1
+		}
+		size += metadataSize(events[i].Metadata)
+	}
+
+	cEventsPtr := C.calloc(C.size_t(n), C.sizeof_event_t)
+	cResultsPtr := C.calloc(C.size_t(n), C.sizeof_eventlib_error_t)
+	bufPtr := C.malloc(C.size_t(max(size, 1)))
+	defer C.free(cEventsPtr)
+	defer C.free(cResultsPtr)
+	defer C.free(bufPtr)
+
+	cEvents := unsafe.Slice((*C.event_t)(cEventsPtr), n)
+	cResults := unsafe.Slice((*C.eventlib_error_t)(cResultsPtr), n)
+	buf := unsafe.Slice((*byte)(bufPtr), max(size, 1))
+
+	// cstr copies s into buf as a C string
+	off := 0
+	cstr := func(s string) *C.char {
+		p := (*C.char)(unsafe.Pointer(&buf[off]))
+		off += copy(buf[off:], s)
+		buf[off] = 0
+		off++
+		return p
+	}
+
+	for j, i := range index {
+		event := events[i]
+		cEvent := &cEvents[j]
+
+		cEvent._type = C.event_type_t(event.Type)
+		cEvent.source = cstr(event.Source)
+		if event.ContentType != "" {
+			cEvent.content_type = cstr(event.ContentType)
+		}
+		if event.TraceParent != "" {
+			cEvent.trace_parent = cstr(event.TraceParent)
+		}
+		if event.ID != "" {
+			cEvent.event_id = cstr(event.ID)
+		}
+		if event.CorrelationID != "" {
+			cEvent.correlation_id = cstr(event.CorrelationID)
+		}
+		if len(event.Metadata) > 0 {
+			// Encoded in place; buf was sized for it
+			blob := appendMetadata(buf[off:off], event.Metadata)
+			cEvent.metadata = unsafe.Pointer(&buf[off])
+			cEvent.metadata_len = C.size_t(len(blob))
+			off += len(blob)
+		}
+		if len(event.Data) > 0 {
+			cEvent.data = unsafe.Pointer(&buf[off])
+			cEvent.data_len = C.size_t(copy(buf[off:], event.Data))
+			off += len(event.Data)
+		}
+		if !event.Deadline.IsZero() {
+			cEvent.deadline_ms = C.int64_t(event.Deadline.UnixMilli())
+		}
+		if !event.Timestamp.IsZero() {
+			cEvent.timestamp_ms = C.int64_t(event.Timestamp.UnixMilli())
+		}
+		if !event.EnqueuedAt.IsZero() {
+			cEvent.enqueued_ns = C.int64_t(event.EnqueuedAt.UnixNano())
+		}
+		if event.Backfill {
+			cEvent.flags |= C.EVENT_FLAG_BACKFILL
+		}
+		cEvent.id = C.uint64_t(ids[i])
+		cEvent.priority = C.int32_t(event.Priority)
+		cEvent.attempt = C.uint32_t(event.Attempt)
+	}
+
+	e.ep.stats.cgoCalls.Add(1)
+	start := time.Now()
+	C.event_processor_submit_batch(e.cptr, &cEvents[0], C.size_t(n), &cResults[0])
+	e.ep.observeCgo("push_batch", start)
+
+	for j, i := range index {
+		if code := cResults[j]; code != C.EVENTLIB_OK {
+			errs[i] = newCError("push", code)
+		}
+	}
+}
+
+func (e *engine) process() {
+	e.ep.stats.cgoCalls.Add(1)
+	defer e.ep.observeCgo("process", time.Now())
+	C.event_processor_process(e.cptr)
+}
+
+// cancelFlag stops processN between events once set. It lives in C
+// memory so the processing loop can poll it without holding a Go pointer.
+type cancelFlag struct {
+	p *C.int
+}
+
+func newCancelFlag() *cancelFlag {
+	return &cancelFlag{p: (*C.int)(C.calloc(1, C.sizeof_int))}
+}
+
+func (f *cancelFlag) set() {
+	C.cancel_flag_set(f.p)
+}
+
+func (f *cancelFlag) free() {
+	C.free(unsafe.Pointer(f.p))
+}
+
+// processN processes up to n events in one C call, stopping early once
+// the queue is empty or cancel, if not nil, is set. C takes its own lock
+// per event and runs handlers outside it, so several goroutines can share
+// the queue.
+func (e *engine) processN(n int, cancel *cancelFlag) int {
+	var p *C.int
+	if cancel != nil {
+		p = cancel.p
+	}
+	e.ep.stats.cgoCalls.Add(1)
+	return int(C.event_processor_process_n(e.cptr, C.size_t(n), p))
+}
+
+func (e *engine) queueSize() int {
+	e.ep.stats.cgoCalls.Add(1)
+	return int(C.event_processor_queue_size(e.cptr))
+}
+
+func (e *engine) eventsProcessed() uint64 {
+	e.ep.stats.cgoCalls.Add(1)
+	return uint64(C.event_processor_events_processed(e.cptr))
+}
+
+func (e *engine) eventsExpired() uint64 {
+	e.ep.stats.cgoCalls.Add(1)
+	return uint64(C.event_processor_events_expired(e.cptr))
+}
+
+func (e *engine) state() string {
+	e.ep.stats.cgoCalls.Add(1)
+	return C.GoString(C.event_processor_get_state(e.cptr))
+}
+
+// stats reads every C counter in one call
+func (e *engine) stats() engineStats {
+	var cs C.event_processor_stats_t
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_get_stats(e.cptr, &cs)
+
+	return engineStats{
+		state:     C.GoString(cs.state),
+		queueSize: int(cs.queue_size),
+		processed: uint64(cs.events_processed),
+		expired:   uint64(cs.events_expired),
+		library: LibraryStats{
+			Submitted:         uint64(cs.events_submitted),
+			Filtered:          uint64(cs.events_filtered),
+			Rejected:          uint64(cs.events_rejected),
+			Cleared:           uint64(cs.events_cleared),
+			QueueHighWater:    int(cs.queue_high_water),
+			QueuePressure:     bool(cs.pressured),
+			MaxQueueSize:      int(cs.max_queue_size),
+			ProcessingTime:    time.Duration(cs.processing_ns),
+			MaxProcessingTime: time.Duration(cs.processing_ns_max),
+		},
+	}
+}
+
+func (e *engine) setMaxQueueSize(size int) {
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_set_max_queue_size(e.cptr, C.size_t(size))
+}
+
+func (e *engine) maxQueueSize() int {
+	e.ep.stats.cgoCalls.Add(1)
+	return int(C.event_processor_max_queue_size(e.cptr))
+}
+
+// setWatermarks sets the queue sizes at which OnQueuePressure fires; 0
+// turns it off
+func (e *engine) setWatermarks(high, low int) {
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_set_watermarks(e.cptr, C.size_t(high), C.size_t(low))
+}
+
+func (e *engine) setLogging(enabled bool) {
+	e.ep.stats.cgoCalls.Add(1)
+	C.event_processor_set_logging(e.cptr, C.bool(enabled))
+}
+
+// snapshot copies every queued event, in processing order
+func (e *engine) snapshot() ([]Event, error) {
+	var queued []Event
+	handle := cgo.NewHandle(&queued)
+	defer handle.Delete()
+
+	e.ep.stats.cgoCalls.Add(1)
+	if code := C.snapshot_queue_go(e.cptr, C.uintptr_t(handle)); code != C.EVENTLIB_OK {
+		return nil, newCError("snapshot", code)
+	}
+	return queued, nil
+}
+
+// peek copies the first n queued events, or all of them if n is 0
+func (e *engine) peek(n int) ([]Event, error) {
+	var queued []Event
+	handle := cgo.NewHandle(&queued)
+	defer handle.Delete()
+
+	e.ep.stats.cgoCalls.Add(1)
+	if code := C.peek_queue_go(e.cptr, C.size_t(n), C.uintptr_t(handle)); code != C.EVENTLIB_OK {
+		return nil, newCError("peek", code)
+	}
+	return queued, nil
+}
+
+// purge removes the queued events match accepts, passing each to removed
+// with its journal sequence. match runs under the C queue lock.
+func (e *engine) purge(match func(Event) bool, removed func(event Event, id uint64)) int {
+	handle := cgo.NewHandle(&purgeVisitor{match: match, removed: removed})
+	defer handle.Delete()
+
+	e.ep.stats.cgoCalls.Add(1)
+	return int(C.purge_queue_go(e.cptr, C.uintptr_t(handle)))
+}
+
+// libraryVersion reports the linked C library's version and build flags
+func libraryVersion() (version, buildFlags string) {
+	return C.GoString(C.eventlib_version()), C.GoString(C.eventlib_build_flags())
+}
+
+// allocBuffer returns size bytes of C memory for a Buffer, which the queue
+// can take over with event_processor_submit_owned
+func allocBuffer(size int) unsafe.Pointer {
+	return C.malloc(C.size_t(size))
+}
+
+func freeBuffer(p unsafe.Pointer) {
+	C.free(p)
+}
+
+// maxPooledCStrings caps the buffers kept in cStringsPool, so one huge
+// source or ID does not pin its memory
+const maxPooledCStrings = 64 << 10
+
+var cStringsPool = sync.Pool{
+	New: func() any { return &cStrings{buf: make([]byte, 0, 256)} },
+}
+
+// cStrings packs the NUL-terminated strings for one push into a single
+// reusable buffer, replacing a C.CString allocation per string. The
+// pointers are only valid during the cgo call, which copies what it keeps.
+type cStrings struct {
+	buf []byte
+}
+
+func getCStrings() *cStrings {
+	cs := cStringsPool.Get().(*cStrings)
+	cs.buf = cs.buf[:0]
+	return cs
+}
+
+func putCStrings(cs *cStrings) {
+	if cap(cs.buf) <= maxPooledCStrings {
+		cStringsPool.Put(cs)
+	}
+}
+
+// add appends s and returns its offset, for ptr once every string is in;
+// pointers taken earlier would dangle if the buffer grew
+func (cs *cStrings) add(s string) int {
+	off := len(cs.buf)
+	cs.buf = append(cs.buf, s...)
+	cs.buf = append(cs.buf, 0)
+	return off
+}
+
+// addOptional is add, but an empty s becomes a NULL pointer
+func (cs *cStrings) addOptional(s string) int {
+	if s == "" {
+		return -1
+	}
+	return cs.add(s)
+}
+
+// addMetadata appends m's encoding and returns its offset and length, or
+// -1 and 0 if m is empty
+func (cs *cStrings) addMetadata(m map[string]string) (off, n int) {
+	if len(m) == 0 {
+		return -1, 0
+	}
+	off = len(cs.buf)
+	cs.buf = appendMetadata(cs.buf, m)
+	return off, len(cs.buf) - off
+}
+
+// ptr returns the C string at off, or NULL for -1
+func (cs *cStrings) ptr(off int) *C.char {
+	if off < 0 {
+		return nil
+	}
+	return (*C.char)(unsafe.Pointer(&cs.buf[off]))
+}
+
+// bytes returns a pointer to the bytes at off, or NULL for -1
+func (cs *cStrings) bytes(off int) unsafe.Pointer {
+	if off < 0 {
+		return nil
+	}
+	return unsafe.Pointer(&cs.buf[off])
+}
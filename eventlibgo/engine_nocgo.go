@@ -0,0 +1,595 @@
+//go:build !cgo || nocgo
+
+package eventlib
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// engineVersion is the EVENTLIB_VERSION of the eventlib.c this file ports
+const engineVersion = "0.2.0"
+
+// Processor states, as named by the C library
+const (
+	stateIdle    = "IDLE"
+	stateRunning = "RUNNING"
+	stateStopped = "STOPPED"
+)
+
+// Pressure transitions reported by pressureChange
+const (
+	pressureNone = iota
+	pressureHigh
+	pressureLow
+)
+
+// engine is the queue behind an EventProcessor. Without cgo there is no C
+// library, so this is a port of eventlib.c: the same states, ordering,
+// limits, watermarks, counters and log messages, with the callbacks
+// called directly. Like the C processor it takes its own lock per
+// operation and runs callbacks outside it.
+type engine struct {
+	ep   *EventProcessor
+	name string
+	mode QueueMode
+
+	// Runtime settings, read without the lock
+	maxSize atomic.Int64
+	logging atomic.Bool
+	high    atomic.Int64
+	low     atomic.Int64
+
+	mu        sync.Mutex
+	status    string
+	queue     []queuedEvent // queue[head:] is waiting, in processing order
+	head      int
+	pressured bool
+	highWater int
+	submitted uint64
+	processed uint64
+	expired   uint64
+	filtered  uint64
+	rejected  uint64
+	cleared   uint64
+	busy      time.Duration // Time in OnEvent and OnExpired
+	maxBusy   time.Duration
+}
+
+// queuedEvent is an event waiting in the queue with its journal sequence
+type queuedEvent struct {
+	event Event
+	id    uint64
+}
+
+// newEngine creates the queue for ep with config. ep's name, handlers
+// and logger must already be set since it logs through them straight
+// away.
+func newEngine(ep *EventProcessor, config EngineConfig) (*engine, error) {
+	e := &engine{
+		ep:     ep,
+		name:   ep.config.Name,
+		mode:   config.QueueMode,
+		status: stateIdle,
+	}
+	e.maxSize.Store(int64(config.MaxQueueSize))
+	e.logging.Store(config.EnableLogging)
+
+	e.log("INFO", "Event processor '%s' created", e.name)
+	liveHandles.Add(1)
+	return e, nil
+}
+
+// destroy empties the queue
+func (e *engine) destroy() {
+	e.log("INFO", "Destroying event processor '%s'", e.name)
+	e.clearQueue()
+	liveHandles.Add(-1)
+}
+
+// release returns what destroy does, without holding on to e or its
+// processor, for the cleanup of a processor dropped without Close. The
+// queue itself is garbage collected.
+func (e *engine) release() func() {
+	return func() {
+		liveHandles.Add(-1)
+	}
+}
+
+// log passes a message to the processor's logger when logging is on
+func (e *engine) log(level, format string, args ...any) {
+	if e.logging.Load() {
+		e.ep.handleLog(level, fmt.Sprintf(format, args...))
+	}
+}
+
+// newQueueFullError is the error the C library reports for a full queue,
+// with the same code and message
+func newQueueFullError() *CError {
+	return &CError{Op: "push", Code: -2, Message: "queue full", kind: ErrQueueFull}
+}
+
+func (e *engine) start() {
+	e.changeState(stateRunning)
+}
+
+func (e *engine) stop() {
+	e.changeState(stateStopped)
+}
+
+func (e *engine) changeState(state string) {
+	e.mu.Lock()
+	if e.status == state {
+		e.mu.Unlock()
+		return
+	}
+	old := e.status
+	e.status = state
+	e.mu.Unlock()
+
+	e.log("INFO", "State change: %s -> %s", old, state)
+	if e.ep.handlers.Load().OnStateChange != nil {
+		e.ep.handleStateChange(old, state)
+	}
+}
+
+// len returns the number of queued events. The caller holds the lock.
+func (e *engine) len() int {
+	return len(e.queue) - e.head
+}
+
+// full reports whether the queue limit has been reached, counting the
+// event refused if so. The caller holds the lock.
+func (e *engine) full() bool {
+	limit := e.maxSize.Load()
+	if limit > 0 && int64(e.len()) >= limit {
+		e.rejected++
+		return true
+	}
+	return false
+}
+
+// submit queues an event, tagged with its journal sequence. The event is
+// copied, as C would, unless owned is set, in which case the queue keeps
+// event.Data as it is.
+func (e *engine) submit(event Event, id uint64, owned bool) error {
+	// Check the limit before doing any copying
+	e.mu.Lock()
+	full, size := e.full(), e.len()
+	e.mu.Unlock()
+	if full {
+		e.log("WARN", "Queue full (%d items)", size)
+		return newQueueFullError()
+	}
+
+	item := queuedEvent{event: queueCopy(event, owned), id: id}
+
+	if e.ep.handlers.Load().OnFilter != nil && !e.ep.handleFilter(item.event, id) {
+		e.log("DEBUG", "Event filtered out")
+		e.mu.Lock()
+		e.filtered++
+		e.mu.Unlock()
+		return nil
+	}
+
+	// Add to the queue, checking the limit again now the lock is held
+	e.mu.Lock()
+	if e.full() {
+		size = e.len()
+		e.mu.Unlock()
+		e.log("WARN", "Queue full (%d items)", size)
+		return newQueueFullError()
+	}
+	e.enqueue(item)
+	size = e.len()
+	e.submitted++
+	e.highWater = max(e.highWater, size)
+	change := e.pressureChange()
+	e.mu.Unlock()
+
+	e.notifyPressure(change, size)
+	e.log("DEBUG", "Event queued (type=%d, queue_size=%d)", item.event.Type, size)
+	return nil
+}
+
+// submitBatch submits the events at index in turn, setting errs for those
+// refused
+func (e *engine) submitBatch(events []Event, ids []uint64, index []int, errs []error) {
+	for _, i := range index {
+		if err := e.submit(events[i], ids[i], false); err != nil {
+			errs[i] = err
+		}
+	}
+}
+
+// enqueue adds item in processing order. The caller holds the lock.
+func (e *engine) enqueue(item queuedEvent) {
+	// FIFO, or nothing queued ahead of this priority: append
+	n := e.len()
+	if n == 0 || e.mode != QueuePriority || e.queue[len(e.queue)-1].event.Priority >= item.event.Priority {
+		e.queue = append(e.queue, item)
+		return
+	}
+
+	// Insert after the last event of equal or higher priority
+	waiting := e.queue[e.head:]
+	i := sort.Search(n, func(i int) bool {
+		return waiting[i].event.Priority < item.event.Priority
+	})
+	e.queue = slices.Insert(e.queue, e.head+i, item)
+}
+
+// dequeue removes the event at the head of the queue, which must not be
+// empty. The caller holds the lock.
+func (e *engine) dequeue() queuedEvent {
+	item := e.queue[e.head]
+	e.queue[e.head] = queuedEvent{}
+	e.head++
+
+	// Reuse the slice once it empties, and compact it once it is mostly
+	// taken events, so it does not grow without bound
+	switch n := e.len(); {
+	case n == 0:
+		e.queue, e.head = e.queue[:0], 0
+	case e.head >= 64 && e.head > n:
+		copy(e.queue, e.queue[e.head:])
+		clear(e.queue[n:])
+		e.queue, e.head = e.queue[:n], 0
+	}
+	return item
+}
+
+// queueCopy returns event as the C queue would give it back: strings cut
+// at their first NUL, times at the precision event_t holds, numbers at its
+// widths, DeliverAt and ProcessedAt unset, and data and metadata copied
+// unless owned. Handlers see the same events in either build.
+func queueCopy(event Event, owned bool) Event {
+	copied := Event{
+		Type:          EventType(uint32(event.Type)),
+		Source:        truncateNUL(event.Source),
+		ID:            truncateNUL(event.ID),
+		Deadline:      unixMilli(event.Deadline),
+		Timestamp:     unixMilli(event.Timestamp),
+		Backfill:      event.Backfill,
+		Priority:      int(int32(event.Priority)),
+		ContentType:   truncateNUL(event.ContentType),
+		TraceParent:   truncateNUL(event.TraceParent),
+		Attempt:       int(uint32(event.Attempt)),
+		CorrelationID: truncateNUL(event.CorrelationID),
+	}
+
+	// An unset enqueue time is stamped now, as C does
+	var enqueued int64
+	if !event.EnqueuedAt.IsZero() {
+		enqueued = event.EnqueuedAt.UnixNano()
+	}
+	if enqueued == 0 {
+		enqueued = time.Now().UnixNano()
+	}
+	if enqueued > 0 {
+		copied.EnqueuedAt = time.Unix(0, enqueued)
+	}
+
+	if len(event.Data) > 0 {
+		copied.Data = event.Data
+		if !owned {
+			copied.Data = bytes.Clone(event.Data)
+		}
+	}
+	if len(event.Metadata) > 0 {
+		copied.Metadata = maps.Clone(event.Metadata)
+	}
+	return copied
+}
+
+// truncateNUL returns s up to its first NUL, as it reads as a C string
+func truncateNUL(s string) string {
+	s, _, _ = strings.Cut(s, "\x00")
+	return s
+}
+
+// unixMilli returns t truncated to milliseconds, or the zero time if t is
+// not after the epoch, which C cannot tell from unset
+func unixMilli(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	if ms := t.UnixMilli(); ms > 0 {
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
+
+// pressureChange updates pressured for the current queue size, returning
+// the transition to report. The caller holds the lock.
+func (e *engine) pressureChange() int {
+	high, low := int(e.high.Load()), int(e.low.Load())
+	size := e.len()
+
+	if !e.pressured && high > 0 && size >= high {
+		e.pressured = true
+		return pressureHigh
+	}
+	if e.pressured && (high == 0 || size <= low) {
+		e.pressured = false
+		return pressureLow
+	}
+	return pressureNone
+}
+
+// notifyPressure reports a transition, called without the lock
+func (e *engine) notifyPressure(change, size int) {
+	if change == pressureNone {
+		return
+	}
+
+	if change == pressureHigh {
+		e.log("WARN", "Queue pressure high (%d items)", size)
+	} else {
+		e.log("INFO", "Queue pressure relieved (%d items)", size)
+	}
+	if e.ep.handlers.Load().OnQueuePressure != nil {
+		e.ep.handleQueuePressure(change == pressureHigh, size)
+	}
+}
+
+// processOne handles the event at the head of the queue, reporting false
+// if there was none or the processor is not running
+func (e *engine) processOne() bool {
+	e.mu.Lock()
+	if e.len() == 0 {
+		e.mu.Unlock()
+		return false
+	}
+	if e.status != stateRunning {
+		e.mu.Unlock()
+		e.log("WARN", "Processor not running")
+		return false
+	}
+	item := e.dequeue()
+	size := e.len()
+	change := e.pressureChange()
+	e.mu.Unlock()
+
+	e.notifyPressure(change, size)
+
+	started := time.Now()
+
+	// Expire events whose deadline has passed instead of handling them late
+	event := item.event
+	expired := !event.Deadline.IsZero() && time.Now().UnixMilli() > event.Deadline.UnixMilli()
+	if expired {
+		e.log("DEBUG", "Event expired (type=%d)", event.Type)
+		e.ep.handleExpired(event, item.id)
+	} else {
+		e.log("DEBUG", "Processing event (type=%d)", event.Type)
+		e.ep.handleEvent(event, item.id)
+	}
+
+	elapsed := time.Since(started)
+
+	e.mu.Lock()
+	if expired {
+		e.expired++
+	} else {
+		e.processed++
+	}
+	e.busy += elapsed
+	e.maxBusy = max(e.maxBusy, elapsed)
+	e.mu.Unlock()
+	return true
+}
+
+func (e *engine) process() {
+	e.processOne()
+}
+
+// cancelFlag stops processN between events once set
+type cancelFlag struct {
+	atomic.Bool
+}
+
+func newCancelFlag() *cancelFlag {
+	return &cancelFlag{}
+}
+
+func (f *cancelFlag) set() {
+	f.Store(true)
+}
+
+func (f *cancelFlag) free() {}
+
+// isSet reports whether a flag, which may be nil, has been set
+func (f *cancelFlag) isSet() bool {
+	return f != nil && f.Load()
+}
+
+// processN processes up to n events, stopping early once the queue is
+// empty or cancel, if not nil, is set. Several goroutines can share the
+// queue.
+func (e *engine) processN(n int, cancel *cancelFlag) int {
+	count := 0
+	for count < n && !cancel.isSet() && e.processOne() {
+		count++
+	}
+	return count
+}
+
+func (e *engine) queueSize() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.len()
+}
+
+func (e *engine) eventsProcessed() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.processed
+}
+
+func (e *engine) eventsExpired() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.expired
+}
+
+func (e *engine) state() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// stats reads every counter under one lock
+func (e *engine) stats() engineStats {
+	maxSize := int(e.maxSize.Load())
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return engineStats{
+		state:     e.status,
+		queueSize: e.len(),
+		processed: e.processed,
+		expired:   e.expired,
+		library: LibraryStats{
+			Submitted:         e.submitted,
+			Filtered:          e.filtered,
+			Rejected:          e.rejected,
+			Cleared:           e.cleared,
+			QueueHighWater:    e.highWater,
+			QueuePressure:     e.pressured,
+			MaxQueueSize:      maxSize,
+			ProcessingTime:    e.busy,
+			MaxProcessingTime: e.maxBusy,
+		},
+	}
+}
+
+// clearQueue drops every queued event
+func (e *engine) clearQueue() {
+	e.mu.Lock()
+	cleared := e.len()
+	e.cleared += uint64(cleared)
+	clear(e.queue)
+	e.queue, e.head = nil, 0
+	change := e.pressureChange()
+	e.mu.Unlock()
+
+	e.notifyPressure(change, 0)
+	if cleared > 0 {
+		e.log("INFO", "Cleared %d events from queue", cleared)
+	}
+}
+
+func (e *engine) setMaxQueueSize(size int) {
+	e.maxSize.Store(int64(size))
+	e.log("INFO", "Queue limit set to %d", size)
+}
+
+func (e *engine) maxQueueSize() int {
+	return int(e.maxSize.Load())
+}
+
+// setWatermarks sets the queue sizes at which OnQueuePressure fires; 0
+// turns it off
+func (e *engine) setWatermarks(high, low int) {
+	e.high.Store(int64(high))
+	e.low.Store(int64(low))
+
+	e.mu.Lock()
+	size := e.len()
+	change := e.pressureChange()
+	e.mu.Unlock()
+
+	e.notifyPressure(change, size)
+}
+
+func (e *engine) setLogging(enabled bool) {
+	e.logging.Store(enabled)
+}
+
+// snapshot copies every queued event, in processing order
+func (e *engine) snapshot() ([]Event, error) {
+	e.mu.Lock()
+	queued := make([]Event, 0, e.len())
+	for _, item := range e.queue[e.head:] {
+		event := item.event
+		event.Data = bytes.Clone(event.Data)
+		event.Metadata = maps.Clone(event.Metadata)
+		queued = append(queued, event)
+	}
+	e.mu.Unlock()
+
+	e.log("DEBUG", "Snapshot visited %d events", len(queued))
+	return queued, nil
+}
+
+// peek copies the first n queued events, or all of them if n is 0
+func (e *engine) peek(n int) ([]Event, error) {
+	e.mu.Lock()
+	items := e.queue[e.head:]
+	if n > 0 && n < len(items) {
+		items = items[:n]
+	}
+	queued := make([]Event, 0, len(items))
+	for _, item := range items {
+		event := item.event
+		event.Data = bytes.Clone(event.Data)
+		event.Metadata = maps.Clone(event.Metadata)
+		queued = append(queued, event)
+	}
+	e.mu.Unlock()
+	return queued, nil
+}
+
+// purge removes the queued events match accepts, passing each to removed
+// with its journal sequence. As in C, match runs under the queue lock.
+func (e *engine) purge(match func(Event) bool, removed func(event Event, id uint64)) int {
+	e.mu.Lock()
+	var purged []queuedEvent
+	kept := make([]queuedEvent, 0, e.len())
+	for _, item := range e.queue[e.head:] {
+		if match(item.event) {
+			purged = append(purged, item)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	e.queue, e.head = kept, 0
+	e.cleared += uint64(len(purged))
+	size := e.len()
+	change := e.pressureChange()
+	e.mu.Unlock()
+
+	e.notifyPressure(change, size)
+	for _, item := range purged {
+		removed(item.event, item.id)
+	}
+	if len(purged) > 0 {
+		e.log("INFO", "Purged %d events from queue", len(purged))
+	}
+	return len(purged)
+}
+
+// libraryVersion reports the C library version the engine follows, with
+// the Go toolchain in place of a C compiler
+func libraryVersion() (version, buildFlags string) {
+	return engineVersion, "compiler=" + runtime.Compiler + " " + runtime.Version() + ";build=nocgo;threads=goroutines;alloc=gc"
+}
+
+// allocBuffer returns Go memory for a Buffer; the queue keeps a reference
+// to it rather than copying
+func allocBuffer(size int) unsafe.Pointer {
+	return unsafe.Pointer(unsafe.SliceData(make([]byte, size)))
+}
+
+func freeBuffer(unsafe.Pointer) {}
@@ -0,0 +1,69 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors, for use with errors.Is
+var (
+	// ErrClosed is returned by any call made after Close
+	ErrClosed = errors.New("processor is closed")
+
+	// ErrDraining is returned by pushes made after Drain has begun
+	ErrDraining = errors.New("processor is draining")
+
+	// ErrPaused is returned by pushes made while intake is paused; the
+	// push may succeed after Resume
+	ErrPaused = errors.New("processor is paused")
+
+	// ErrQueueFull means MaxQueueSize was reached; the push may succeed
+	// once the queue has been processed
+	ErrQueueFull = errors.New("queue is full")
+
+	// ErrEventTooLarge is returned for an event over Config.MaxEventSize
+	// or with a Source longer than Config.MaxSourceLength; unlike
+	// ErrQueueFull, retrying will not help
+	ErrEventTooLarge = errors.New("event too large")
+
+	// ErrInvalidConfig is wrapped by errors for unusable Config values
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// ErrTransform is wrapped by the error from a Transformer that
+	// failed, such as one given malformed data
+	ErrTransform = errors.New("transform failed")
+
+	// ErrHandlerPanic is wrapped by the error recorded for a panicking
+	// event handler
+	ErrHandlerPanic = errors.New("event handler panicked")
+
+	// ErrHandlerTimeout is wrapped by the error recorded for an event
+	// handler abandoned after Config.HandlerTimeout
+	ErrHandlerTimeout = errors.New("event handler timed out")
+
+	// ErrCircuitOpen is returned for calls refused by an open
+	// CircuitBreaker
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrPersistenceLocked is returned by New when another processor still
+	// has the write-ahead log at Config.PersistencePath open
+	ErrPersistenceLocked = errors.New("write-ahead log is in use")
+)
+
+// CError reports a failure code returned by the C library. It unwraps to
+// the matching sentinel error, if there is one.
+type CError struct {
+	Op      string // Bindings operation, e.g. "push"
+	Code    int    // eventlib_error_t value
+	Message string // eventlib_strerror text
+
+	kind error
+}
+
+func (e *CError) Error() string {
+	return fmt.Sprintf("%s: %s (code %d)", e.Op, e.Message, e.Code)
+}
+
+func (e *CError) Unwrap() error {
+	return e.kind
+}
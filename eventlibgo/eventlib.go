@@ -1,68 +1,47 @@
 package eventlib
 
-/*
-#cgo CFLAGS: -I${SRCDIR}/../eventlib
-#cgo LDFLAGS: ${SRCDIR}/../eventlib/libeventlib.a
-#include "eventlib.h"
-#include <stdlib.h>
-
-// Forward declarations for Go callbacks
-extern void goHandleEvent(void* event, void* user_data);
-extern void goHandleLog(void* level, void* message, void* user_data);
-extern int goHandleFilter(void* event, void* user_data);
-extern void goHandleStateChange(void* old_state, void* new_state, void* user_data);
-
-// C wrapper functions that call Go
-static void c_handle_event(const event_t* event, void* user_data) {
-    goHandleEvent((void*)event, user_data);
-}
-
-static void c_handle_log(const char* level, const char* message, void* user_data) {
-    goHandleLog((void*)level, (void*)message, user_data);
-}
-
-static bool c_handle_filter(const event_t* event, void* user_data) {
-    return goHandleFilter((void*)event, user_data) != 0;
-}
-
-static void c_handle_state_change(const char* old_state, const char* new_state, void* user_data) {
-    goHandleStateChange((void*)old_state, (void*)new_state, user_data);
-}
-
-// Helper to create processor with Go callbacks
-static event_processor_t* create_processor_go(const char* name, size_t max_queue_size,
-                                              bool enable_logging, void* user_data) {
-    event_config_t config = {
-        .name = name,
-        .max_queue_size = max_queue_size,
-        .enable_logging = enable_logging,
-        .on_event = c_handle_event,
-        .on_log = c_handle_log,
-        .on_filter = c_handle_filter,
-        .on_state_change = c_handle_state_change,
-        .user_data = user_data
-    };
-    return event_processor_create(&config);
-}
-*/
-import "C"
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"runtime"
 	"sync"
-	"unsafe"
+	"sync/atomic"
+	"time"
 
-	"go.uber.org/zap"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// EventProcessor wraps the C event processor
+// EventProcessor wraps the C event processor, or its pure-Go port in
+// nocgo builds
 type EventProcessor struct {
-	cptr     *C.event_processor_t
-	config   *Config
-	handlers *Handlers
-	logger   *zap.Logger
-	mu       sync.RWMutex
-	closed   bool
+	engine    *engine
+	config    *Config
+	handlers  atomic.Pointer[handlerSet]
+	swapMu    sync.Mutex // Serializes SetHandlers and UpdateHandler
+	logger    *slog.Logger
+	stats     *statsCollector
+	wal       *wal
+	async     *asyncPusher
+	router    *Router
+	retries   *retryQueue
+	dedup     *dedupWindow
+	subs      subscriberSet
+	scheduled *timingWheel
+	waiters   waiterSet
+	panics    *panicReporter
+	metrics   *processorMetrics
+	mu        sync.RWMutex
+	closed    bool
+	closing   atomic.Bool // Set as Close begins, before closed
+	closeOnce sync.Once
+	cleanup   runtime.Cleanup
+	draining  atomic.Bool
+	paused    atomic.Bool
+	logging   atomic.Bool
+
+	deadLetters *deadLetterLog
 }
 
 // Config holds processor configuration
@@ -70,7 +49,108 @@ type Config struct {
 	Name          string
 	MaxQueueSize  int
 	EnableLogging bool
-	Logger        *zap.Logger
+
+	// Logger receives the processor's logs, including those forwarded from
+	// the C library. zaplog.New adapts a zap logger.
+	Logger *slog.Logger
+
+	// QueueMode selects FIFO (default) or priority ordering
+	QueueMode QueueMode
+
+	// PersistencePath, if set, is a directory for a write-ahead log that
+	// journals pushed events; events not yet processed when the processor
+	// goes away are replayed by the next New with the same path
+	PersistencePath string
+
+	// PersistenceSync chooses when the log is fsynced
+	PersistenceSync SyncPolicy
+
+	// PersistenceSyncInterval is the flush period for SyncInterval
+	// (default 1s)
+	PersistenceSyncInterval time.Duration
+
+	// PersistenceSegmentSize is the size at which the log rolls over to a
+	// new segment file (default 64 MiB)
+	PersistenceSegmentSize int64
+
+	// PersistenceLockTimeout is how long New waits for another processor,
+	// in this process or another, to close the log at PersistencePath.
+	// Only one may have it open at a time; zero fails at once with
+	// ErrPersistenceLocked.
+	PersistenceLockTimeout time.Duration
+
+	// AsyncPush, if set, makes Push buffer events in Go and return without
+	// waiting on the C queue. Call Flush to wait for the buffer to drain.
+	// Order across events is only kept with a single worker.
+	AsyncPush *AsyncConfig
+
+	// Retry, if set, requeues events whose handlers return an error after
+	// a backoff. Without it a failed event is dead-lettered straight away.
+	Retry *RetryPolicy
+
+	// Dedup, if set, drops events pushed again within a window of the
+	// first. Duplicates are counted in Stats but Push reports success, so
+	// a producer retrying an event it has already delivered moves on.
+	Dedup *DedupConfig
+
+	// MaxEventSize, if set, is the largest event Push accepts, counting
+	// its data and its metadata keys and values, in bytes. The limit is
+	// checked after Transformers run, so a payload they expand is caught.
+	MaxEventSize int
+
+	// MaxSourceLength, if set, is the longest Source Push accepts
+	MaxSourceLength int
+
+	// Transformers rewrite each pushed event, in order, before it is
+	// deduplicated, journaled and queued. A ProcessorPool picks the shard
+	// from the event as pushed, before any transformer runs.
+	Transformers []Transformer
+
+	// DeadLetterSize is how many dead letters DeadLetters keeps (default
+	// 1000)
+	DeadLetterSize int
+
+	// ProcessWorkers, if above 1, makes ProcessAll, ProcessAllContext and
+	// Drain process on that many goroutines at once, each taking events
+	// until the queue is empty. Handlers must then be safe for concurrent
+	// use, and events may finish out of order.
+	ProcessWorkers int
+
+	// HandlerTimeout, if set, is how long an event handler may run before
+	// the watchdog logs it as stuck, with a dump of every goroutine, and
+	// counts it in eventlibgo_processor_handler_timeouts_total
+	HandlerTimeout time.Duration
+
+	// AbandonStuckHandlers runs each event handler on a goroutine of its
+	// own, so that one still running after HandlerTimeout is left behind
+	// and the event fails with ErrHandlerTimeout, to be retried or
+	// dead-lettered. The handler is not stopped, so it must be safe to
+	// run alongside the next events.
+	AbandonStuckHandlers bool
+
+	// Faults, if set, injects push failures, slow handlers and handler
+	// panics for resilience testing
+	Faults *Faults
+
+	// HighWatermark and LowWatermark are fractions of MaxQueueSize.
+	// Handlers.OnQueuePressure fires once the queue grows to
+	// HighWatermark (default 0.9), and again once it falls back to
+	// LowWatermark (default 0.7). Without MaxQueueSize they are unused.
+	HighWatermark float64
+	LowWatermark  float64
+
+	// Registerer, if set, receives the processor's Prometheus metrics,
+	// labelled with Name. They are unregistered on Close.
+	Registerer prometheus.Registerer
+
+	// ErrorBufferSize is how many handler panics Errors holds for a slow
+	// reader before dropping them (default DefaultErrorBufferSize)
+	ErrorBufferSize int
+
+	// LeakDetection records where New was called, so that a processor
+	// garbage collected without Close is logged with the stack that
+	// created it. Leaks are counted by LeakedProcessors either way.
+	LeakDetection bool
 }
 
 // Handlers contains all callback functions
@@ -78,125 +158,471 @@ type Handlers struct {
 	OnEvent       EventHandler
 	OnFilter      FilterHandler
 	OnStateChange StateChangeHandler
+	OnExpired     ExpiredHandler
+	OnDeadLetter  DeadLetterHandler
+
+	// OnQueuePressure runs on the goroutine whose push or processing
+	// crossed the watermark, so it should return quickly
+	OnQueuePressure QueuePressureHandler
+
+	// OnPanic is told about every panic recovered from the other handlers
+	OnPanic PanicHandler
 }
 
 // New creates a new event processor
 func New(config *Config, handlers *Handlers) (*EventProcessor, error) {
 	if config == nil {
-		return nil, fmt.Errorf("config cannot be nil")
+		return nil, fmt.Errorf("%w: config cannot be nil", ErrInvalidConfig)
+	}
+	if config.QueueMode != QueueFIFO && config.QueueMode != QueuePriority {
+		return nil, fmt.Errorf("%w: unknown QueueMode %d", ErrInvalidConfig, config.QueueMode)
+	}
+	if config.MaxQueueSize < 0 {
+		return nil, fmt.Errorf("%w: negative MaxQueueSize %d", ErrInvalidConfig, config.MaxQueueSize)
+	}
+	if config.MaxEventSize < 0 {
+		return nil, fmt.Errorf("%w: negative MaxEventSize %d", ErrInvalidConfig, config.MaxEventSize)
+	}
+	if config.MaxSourceLength < 0 {
+		return nil, fmt.Errorf("%w: negative MaxSourceLength %d", ErrInvalidConfig, config.MaxSourceLength)
+	}
+	if config.ProcessWorkers < 0 {
+		return nil, fmt.Errorf("%w: negative ProcessWorkers %d", ErrInvalidConfig, config.ProcessWorkers)
+	}
+	if config.HandlerTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative HandlerTimeout %v", ErrInvalidConfig, config.HandlerTimeout)
+	}
+	if config.AbandonStuckHandlers && config.HandlerTimeout == 0 {
+		return nil, fmt.Errorf("%w: AbandonStuckHandlers needs a HandlerTimeout", ErrInvalidConfig)
+	}
+	if async := config.AsyncPush; async != nil {
+		switch async.Overflow {
+		case OverflowBlock, OverflowDropOldest, OverflowReject:
+		default:
+			return nil, fmt.Errorf("%w: unknown overflow policy %d", ErrInvalidConfig, async.Overflow)
+		}
+	}
+	if config.Retry != nil {
+		if err := config.Retry.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.Dedup != nil {
+		if err := config.Dedup.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if high, low := config.watermarks(); low < 0 || low >= high || high > 1 {
+		return nil, fmt.Errorf("%w: watermarks must satisfy 0 <= low < high <= 1, got %g and %g", ErrInvalidConfig, low, high)
 	}
 	if handlers == nil {
 		handlers = &Handlers{}
 	}
 
+	if err := loadLibrary(); err != nil {
+		return nil, fmt.Errorf("failed to load eventlib: %w", err)
+	}
+
 	// Default logger if not provided
 	logger := config.Logger
 	if logger == nil {
-		logger = zap.NewNop()
+		logger = slog.New(slog.DiscardHandler)
 	}
 
 	ep := &EventProcessor{
-		config:   config,
-		handlers: handlers,
-		logger:   logger,
-	}
-
-	// Store in global map for callback access
-	callbackMu.Lock()
-	callbackID := nextCallbackID
-	nextCallbackID++
-	callbackMap[callbackID] = ep
-	callbackMu.Unlock()
-
-	// Create C processor
-	cName := C.CString(config.Name)
-	defer C.free(unsafe.Pointer(cName))
-
-	ep.cptr = C.create_processor_go(
-		cName,
-		C.size_t(config.MaxQueueSize),
-		C.bool(config.EnableLogging),
-		// WARNING: uintptr cast used only as opaque ID, not dereferenced in C.
-		// This is safe because we use it only for Go-side map lookup.
-		// would use cgo.Handle in production code.
-		unsafe.Pointer(uintptr(callbackID)),
-	)
-
-	if ep.cptr == nil {
-		callbackMu.Lock()
-		delete(callbackMap, callbackID)
-		callbackMu.Unlock()
-		return nil, fmt.Errorf("failed to create processor")
-	}
-
-	// Set finalizer to ensure cleanup
-	runtime.SetFinalizer(ep, (*EventProcessor).finalize)
+		config: config,
+		logger: logger,
+		stats:  newStatsCollector(),
+		router: NewRouter(),
+		panics: newPanicReporter(config.ErrorBufferSize),
+
+		deadLetters: newDeadLetterLog(config.DeadLetterSize),
+	}
+	ep.handlers.Store(newHandlerSet(*handlers))
+	ep.scheduled = newTimingWheel(ep.deliverScheduled)
+	if config.Retry != nil {
+		ep.retries = newRetryQueue()
+	}
+	if config.Dedup != nil {
+		ep.dedup = newDedupWindow(*config.Dedup)
+	}
+	ep.logging.Store(config.EnableLogging)
+
+	var err error
+	if ep.engine, err = newEngine(ep, config.engineConfig()); err != nil {
+		return nil, err
+	}
+	ep.setWatermarks(config.MaxQueueSize)
+
+	if config.PersistencePath != "" {
+		if err := ep.openWAL(); err != nil {
+			ep.engine.destroy()
+			return nil, err
+		}
+	}
+
+	if config.Registerer != nil {
+		metrics, err := newProcessorMetrics(ep, config.Registerer)
+		if err != nil {
+			if ep.wal != nil {
+				ep.wal.close()
+			}
+			ep.engine.destroy()
+			return nil, err
+		}
+		ep.metrics = metrics
+	}
+
+	if config.AsyncPush != nil {
+		ep.async = newAsyncPusher(ep, *config.AsyncPush)
+	}
+
+	ep.watchForLeak()
 
 	ep.logger.Info("Event processor created",
-		zap.String("name", config.Name),
-		zap.Int("maxQueueSize", config.MaxQueueSize))
+		slog.String("name", config.Name),
+		slog.Int("maxQueueSize", config.MaxQueueSize))
 
 	return ep, nil
 }
 
-// Start starts the processor
+// Start starts the processor. The engine locks itself, so Start, like
+// Stop, only needs the read lock and does not wait for a drain.
 func (ep *EventProcessor) Start() error {
-	ep.mu.Lock()
-	defer ep.mu.Unlock()
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
 
 	if ep.closed {
-		return fmt.Errorf("processor is closed")
+		return ErrClosed
 	}
 
-	C.event_processor_start(ep.cptr)
+	ep.engine.start()
 	return nil
 }
 
-// Stop stops the processor
+// Stop stops the processor. A drain in progress ends after the event it
+// is handling, which may still be running when Stop returns.
 func (ep *EventProcessor) Stop() error {
-	ep.mu.Lock()
-	defer ep.mu.Unlock()
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
 
 	if ep.closed {
-		return fmt.Errorf("processor is closed")
+		return ErrClosed
 	}
 
-	C.event_processor_stop(ep.cptr)
+	ep.engine.stop()
 	return nil
 }
 
-// Push adds an event to the queue
-func (ep *EventProcessor) Push(event Event) error {
+// SetMaxQueueSize changes the queue limit; 0 removes it. Events already
+// queued past a lower limit stay queued, but new ones are refused until
+// the queue shrinks below it.
+func (ep *EventProcessor) SetMaxQueueSize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("%w: negative MaxQueueSize %d", ErrInvalidConfig, size)
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return ErrClosed
+	}
+
+	ep.engine.setMaxQueueSize(size)
+	ep.setWatermarks(size)
+	return nil
+}
+
+// QueuePressure reports whether the queue has reached HighWatermark and
+// not yet fallen back to LowWatermark
+func (ep *EventProcessor) QueuePressure() bool {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return false
+	}
+	return ep.engine.stats().library.QueuePressure
+}
+
+// watermarks returns the configured watermarks with defaults applied
+func (c *Config) watermarks() (high, low float64) {
+	high, low = c.HighWatermark, c.LowWatermark
+	if high == 0 {
+		high = 0.9
+	}
+	if low == 0 {
+		low = 0.7
+	}
+	return high, low
+}
+
+// setWatermarks points the queue's watermarks at fractions of
+// maxQueueSize, turning them off for an unbounded queue
+func (ep *EventProcessor) setWatermarks(maxQueueSize int) {
+	var high, low int
+	if maxQueueSize > 0 {
+		highFrac, lowFrac := ep.config.watermarks()
+		high = max(1, int(highFrac*float64(maxQueueSize)))
+		low = int(lowFrac * float64(maxQueueSize))
+	}
+	ep.engine.setWatermarks(high, low)
+}
+
+// MaxQueueSize returns the queue limit, 0 if there is none
+func (ep *EventProcessor) MaxQueueSize() int {
 	ep.mu.RLock()
 	defer ep.mu.RUnlock()
 
 	if ep.closed {
-		return fmt.Errorf("processor is closed")
+		return 0
 	}
 
-	cSource := C.CString(event.Source)
-	defer C.free(unsafe.Pointer(cSource))
+	return ep.engine.maxQueueSize()
+}
 
-	var dataPtr unsafe.Pointer
-	if len(event.Data) > 0 {
-		dataPtr = unsafe.Pointer(&event.Data[0])
+// SetLogging turns the C library's log messages on or off
+func (ep *EventProcessor) SetLogging(enabled bool) error {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return ErrClosed
 	}
 
-	success := C.event_processor_push(
-		ep.cptr,
-		C.event_type_t(event.Type),
-		cSource,
-		dataPtr,
-		C.size_t(len(event.Data)),
-	)
+	ep.engine.setLogging(enabled)
+	ep.logging.Store(enabled)
+	return nil
+}
+
+// Logging reports whether the C library's log messages are on
+func (ep *EventProcessor) Logging() bool {
+	return ep.logging.Load()
+}
+
+// Push adds an event to the queue. With Config.AsyncPush it only buffers
+// the event; later failures go to AsyncConfig.OnError. An event with a
+// future DeliverAt is held until then.
+func (ep *EventProcessor) Push(event Event) error {
+	if isScheduled(event) {
+		return ep.schedule(event)
+	}
+	event.EnqueuedAt = time.Now()
+	event, dropped, err := ep.prepare(event)
+	if err != nil {
+		ep.stats.dropped.Add(1)
+		return err
+	}
+	if dropped {
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomeFiltered})
+		return nil
+	}
+	if ep.async != nil {
+		return ep.pushAsync(context.Background(), event)
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if err := ep.accepting(); err != nil {
+		return err
+	}
+
+	key, duplicate := ep.admit(event)
+	if duplicate {
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomeDuplicate})
+		return nil
+	}
 
-	if !success {
-		return fmt.Errorf("failed to push event")
+	id, err := ep.journal(event)
+	if err != nil {
+		ep.forget(key)
+		return err
 	}
 
+	if err := ep.push(event, id); err != nil {
+		ep.ack(id)
+		ep.forget(key)
+		ep.stats.dropped.Add(1)
+		return err
+	}
+
+	ep.stats.pushed.Add(1)
+	return nil
+}
+
+// pushAsync journals an event and buffers it for the async workers. The
+// lock is not held while buffering, since a blocked send waits on workers
+// that need it.
+func (ep *EventProcessor) pushAsync(ctx context.Context, event Event) error {
+	ep.mu.RLock()
+	if err := ep.accepting(); err != nil {
+		ep.mu.RUnlock()
+		return err
+	}
+	key, duplicate := ep.admit(event)
+	if duplicate {
+		ep.mu.RUnlock()
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomeDuplicate})
+		return nil
+	}
+	id, err := ep.journal(event)
+	ep.mu.RUnlock()
+	if err != nil {
+		ep.forget(key)
+		return err
+	}
+
+	if err := ep.async.enqueue(ctx, asyncItem{event: event, id: id, key: key}); err != nil {
+		ep.ack(id)
+		ep.forget(key)
+		ep.stats.dropped.Add(1)
+		return err
+	}
+	return nil
+}
+
+// prepare transforms a pushed event and checks the result against the
+// size limits
+func (ep *EventProcessor) prepare(event Event) (_ Event, dropped bool, err error) {
+	if err := ep.config.Faults.push(); err != nil {
+		return event, false, err
+	}
+	event, dropped, err = ep.transform(event)
+	if err != nil || dropped {
+		return event, dropped, err
+	}
+	ep.observeSize(event)
+	return event, false, ep.checkSize(event)
+}
+
+// checkSize returns ErrEventTooLarge if event is over MaxEventSize or its
+// Source is over MaxSourceLength
+func (ep *EventProcessor) checkSize(event Event) error {
+	if limit := ep.config.MaxSourceLength; limit > 0 && len(event.Source) > limit {
+		return fmt.Errorf("%w: source is %d bytes, over the limit of %d", ErrEventTooLarge, len(event.Source), limit)
+	}
+	if limit := ep.config.MaxEventSize; limit > 0 {
+		if size := eventSize(event); size > limit {
+			return fmt.Errorf("%w: %d bytes, over the limit of %d", ErrEventTooLarge, size, limit)
+		}
+	}
 	return nil
 }
 
+// eventSize is the size MaxEventSize limits: the data plus the metadata
+// keys and values
+func eventSize(event Event) int {
+	size := len(event.Data)
+	for k, v := range event.Metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// transform runs the configured transformers over event, counting it as
+// filtered if one drops it
+func (ep *EventProcessor) transform(event Event) (_ Event, dropped bool, err error) {
+	if len(ep.config.Transformers) == 0 {
+		return event, false, nil
+	}
+	event, err = applyTransformers(ep.config.Transformers, event)
+	switch {
+	case errors.Is(err, ErrDropEvent):
+		ep.stats.filtered.Add(1)
+		return event, true, nil
+	case err != nil:
+		return event, false, fmt.Errorf("%w: %w", ErrTransform, err)
+	}
+	return event, false, nil
+}
+
+// admit checks event against the dedup window, if any, counting it if it
+// is a duplicate. The key is for forget should the event not be queued.
+func (ep *EventProcessor) admit(event Event) (key string, duplicate bool) {
+	if ep.dedup == nil {
+		return "", false
+	}
+	key, duplicate = ep.dedup.admit(event)
+	if duplicate {
+		ep.stats.duplicates.Add(1)
+	}
+	return key, duplicate
+}
+
+// forget removes an admitted event's key from the dedup window
+func (ep *EventProcessor) forget(key string) {
+	if ep.dedup != nil {
+		ep.dedup.forget(key)
+	}
+}
+
+// accepting reports why a push must be refused, if it must. The caller
+// holds the read lock.
+func (ep *EventProcessor) accepting() error {
+	if ep.closed || ep.closing.Load() {
+		return ErrClosed
+	}
+	if ep.draining.Load() {
+		return ErrDraining
+	}
+	if ep.paused.Load() {
+		return ErrPaused
+	}
+	return nil
+}
+
+// Pause makes Push refuse new events with ErrPaused. Events already
+// accepted are still processed, and retries are still requeued.
+func (ep *EventProcessor) Pause() {
+	if !ep.paused.Swap(true) {
+		ep.logger.Info("Intake paused", slog.String("name", ep.config.Name))
+	}
+}
+
+// Resume accepts events again after Pause
+func (ep *EventProcessor) Resume() {
+	if ep.paused.Swap(false) {
+		ep.logger.Info("Intake resumed", slog.String("name", ep.config.Name))
+	}
+}
+
+// Paused reports whether intake is paused
+func (ep *EventProcessor) Paused() bool {
+	return ep.paused.Load()
+}
+
+// journal appends an event to the write-ahead log, if there is one, and
+// returns its sequence
+func (ep *EventProcessor) journal(event Event) (uint64, error) {
+	if ep.wal == nil {
+		return 0, nil
+	}
+	seq, err := ep.wal.append(event)
+	if err != nil {
+		ep.stats.dropped.Add(1)
+		return 0, fmt.Errorf("failed to journal event: %w", err)
+	}
+	return seq, nil
+}
+
+// push hands an event to the queue, tagged with its journal sequence
+func (ep *EventProcessor) push(event Event, id uint64) error {
+	return ep.engine.submit(event, id, false)
+}
+
+// ack marks a journaled event as done so it is not replayed
+func (ep *EventProcessor) ack(id uint64) {
+	if ep.wal == nil || id == 0 {
+		return
+	}
+	if err := ep.wal.ack(id); err != nil {
+		ep.logger.Error("Failed to journal event completion",
+			slog.Uint64("seq", id), slog.Any("error", err))
+	}
+}
+
 // Process processes a single event
 func (ep *EventProcessor) Process() {
 	ep.mu.RLock()
@@ -206,19 +632,227 @@ func (ep *EventProcessor) Process() {
 		return
 	}
 
-	C.event_processor_process(ep.cptr)
+	ep.engine.process()
+}
+
+// ProcessN processes up to n events in a single call into the queue and
+// returns how many it took, fewer once the queue is empty or the
+// processor is not running. It does the work of n calls to Process with
+// one cgo transition; Close waits for all n.
+func (ep *EventProcessor) ProcessN(n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return 0
+	}
+
+	return ep.engine.processN(n, nil)
 }
 
 // ProcessAll processes all queued events
 func (ep *EventProcessor) ProcessAll() {
+	defer ep.observeCgo("process_all", time.Now())
+	ep.processAll(nil)
+}
+
+// processBatch is how many events the processing loop takes per call into
+// the engine. The read lock is dropped between batches, so Close and
+// Restart, and the readers queued behind them, wait for one batch rather
+// than for a whole drain.
+const processBatch = 256
+
+// processAll runs the processing loop until the queue is empty, cancel is
+// set or the processor is closed, on ProcessWorkers goroutines when
+// configured. The engine takes its own lock per event and runs handlers
+// outside it, so the loops share the queue safely.
+func (ep *EventProcessor) processAll(cancel *cancelFlag) {
+	var count atomic.Int64
+	workers := ep.config.ProcessWorkers
+	if workers <= 1 {
+		count.Add(int64(ep.processBatches(cancel)))
+	} else {
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				count.Add(int64(ep.processBatches(cancel)))
+			}()
+		}
+		wg.Wait()
+	}
+
+	if n := count.Load(); n > 0 && ep.logging.Load() {
+		ep.handleLog("INFO", fmt.Sprintf("Processed %d events", n))
+	}
+}
+
+// processBatches processes events processBatch at a time, holding the
+// read lock for each batch so Close cannot free the queue under it, until
+// a batch comes back short
+func (ep *EventProcessor) processBatches(cancel *cancelFlag) int {
+	total := 0
+	for {
+		ep.mu.RLock()
+		n := 0
+		if !ep.closed {
+			n = ep.engine.processN(processBatch, cancel)
+		}
+		ep.mu.RUnlock()
+
+		total += n
+		if n < processBatch {
+			return total
+		}
+	}
+}
+
+// PushContext is Push, but fails fast once ctx is done. The span in ctx,
+// if any, is recorded as the event's TraceParent.
+func (ep *EventProcessor) PushContext(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	event = WithTrace(ctx, event)
+	if isScheduled(event) {
+		return ep.schedule(event)
+	}
+	if ep.async != nil {
+		event.EnqueuedAt = time.Now()
+		event, dropped, err := ep.prepare(event)
+		if err != nil {
+			ep.stats.dropped.Add(1)
+			return err
+		}
+		if dropped {
+			ep.waiters.settle(event.ID, Outcome{Status: OutcomeFiltered})
+			return nil
+		}
+		return ep.pushAsync(ctx, event)
+	}
+	return ep.Push(event)
+}
+
+// ProcessContext is Process, skipped entirely once ctx is done. It
+// returns ErrClosed after Close.
+func (ep *EventProcessor) ProcessContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	ep.mu.RLock()
 	defer ep.mu.RUnlock()
 
 	if ep.closed {
-		return
+		return ErrClosed
 	}
 
-	C.event_processor_process_all(ep.cptr)
+	ep.engine.process()
+	return nil
+}
+
+// ProcessAllContext processes queued events until the queue is empty or
+// ctx is done. Cancellation is checked between events, so a handler that
+// is already running finishes first. It returns ctx.Err() if cancelled.
+func (ep *EventProcessor) ProcessAllContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ep.mu.RLock()
+	closed := ep.closed
+	ep.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	cancel := newCancelFlag()
+	defer cancel.free()
+
+	finished := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			cancel.set()
+		case <-finished:
+		}
+	}()
+
+	start := time.Now()
+	ep.processAll(cancel)
+	ep.observeCgo("process_all", start)
+
+	close(finished)
+	<-watcherDone
+
+	if ctx.Err() != nil && ep.logging.Load() {
+		ep.handleLog("DEBUG", "Processing cancelled")
+	}
+	return ctx.Err()
+}
+
+// Drain stops accepting events and processes everything already accepted,
+// including buffered async pushes, until the queue is empty or ctx is
+// done. The processor must be running. Events left behind are abandoned;
+// with persistence they are replayed on the next start. The processor
+// stays open, so Close it afterwards.
+func (ep *EventProcessor) Drain(ctx context.Context) (drained, abandoned int, err error) {
+	ep.draining.Store(true)
+	pending := ep.pending()
+
+	// Buffered events have to reach C before they can be processed
+	if ep.async != nil {
+		flushed := make(chan struct{})
+		go func() {
+			ep.async.flush()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+		}
+	}
+
+	// Retries skip their backoff, so failing events go through their
+	// remaining attempts before Drain returns
+	for {
+		err = ep.ProcessAllContext(ctx)
+		if err != nil || ep.retries == nil || ep.retries.len() == 0 {
+			break
+		}
+		for _, item := range ep.retries.takeAll() {
+			ep.requeue(item)
+		}
+	}
+
+	abandoned = ep.pending()
+	drained = max(pending-abandoned, 0)
+
+	ep.logger.Info("Event processor drained",
+		slog.String("name", ep.config.Name),
+		slog.Int("drained", drained),
+		slog.Int("abandoned", abandoned))
+
+	return drained, abandoned, err
+}
+
+// pending counts events accepted but not yet processed
+func (ep *EventProcessor) pending() int {
+	n := ep.QueueSize()
+	if ep.async != nil {
+		n += ep.async.buffered()
+	}
+	if ep.retries != nil {
+		n += ep.retries.len()
+	}
+	return n
 }
 
 // QueueSize returns the current queue size
@@ -230,7 +864,7 @@ func (ep *EventProcessor) QueueSize() int {
 		return 0
 	}
 
-	return int(C.event_processor_queue_size(ep.cptr))
+	return ep.engine.queueSize()
 }
 
 // EventsProcessed returns total events processed
@@ -242,7 +876,19 @@ func (ep *EventProcessor) EventsProcessed() int {
 		return 0
 	}
 
-	return int(C.event_processor_events_processed(ep.cptr))
+	return int(ep.engine.eventsProcessed() + ep.stats.restoredProcessed.Load())
+}
+
+// EventsExpired returns total events dropped for missing their deadline
+func (ep *EventProcessor) EventsExpired() int {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return 0
+	}
+
+	return int(ep.engine.eventsExpired() + ep.stats.restoredExpired.Load())
 }
 
 // State returns the current processor state
@@ -254,45 +900,112 @@ func (ep *EventProcessor) State() string {
 		return "CLOSED"
 	}
 
-	return C.GoString(C.event_processor_get_state(ep.cptr))
+	return ep.engine.state()
+}
+
+// Stats returns a snapshot of processor counters and handler latencies
+func (ep *EventProcessor) Stats() Stats {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	stats := Stats{
+		Name:  ep.config.Name,
+		State: "CLOSED",
+	}
+
+	if !ep.closed {
+		es := ep.engine.stats()
+		stats.State = es.state
+		stats.QueueSize = es.queueSize
+		stats.Processed = es.processed + ep.stats.restoredProcessed.Load()
+		stats.Expired = es.expired + ep.stats.restoredExpired.Load()
+		stats.Library = es.library
+	}
+
+	if ep.async != nil {
+		stats.Buffered = ep.async.buffered()
+	}
+	if ep.retries != nil {
+		stats.RetryPending = ep.retries.len()
+	}
+	stats.Scheduled = ep.scheduled.len()
+	stats.Subscribers = ep.subs.len()
+	stats.SubscriberDrops = ep.subs.dropped.Load()
+
+	ep.stats.snapshot(&stats)
+	return stats
 }
 
-// Close closes the processor and frees resources
+// Close closes the processor and frees resources. It may be called more
+// than once and from several goroutines at a time: the first call does the
+// work, and the others wait for it and return nil. Pushes fail with
+// ErrClosed from the moment Close begins. A handler must not call Close,
+// since processing holds the processor until the handler returns.
 func (ep *EventProcessor) Close() error {
-	ep.mu.Lock()
-	defer ep.mu.Unlock()
+	ep.closeOnce.Do(ep.close)
+	return nil
+}
 
-	if ep.closed {
-		return nil
+// close does the work of Close, once
+func (ep *EventProcessor) close() {
+	ep.closing.Store(true)
+
+	// Scheduled events will not fall due now; without a journal they are
+	// dead-lettered like retries
+	for _, event := range ep.scheduled.close() {
+		ep.deadLetter(event, fmt.Errorf("scheduled for %s: %w", event.DeliverAt.Format(time.RFC3339), ErrClosed), 0)
+	}
+
+	// Drain the async buffer first; its workers need the read lock
+	if ep.async != nil {
+		ep.async.close()
 	}
 
+	// Release any Block subscriber holding up processing, which also holds
+	// the read lock
+	ep.subs.closeAll()
+
+	// Retries still backing off stay in the log if there is one
+	if ep.retries != nil {
+		for _, item := range ep.retries.close() {
+			if ep.wal == nil {
+				ep.deadLetter(item.event, errors.Join(item.err, ErrClosed), item.event.Attempt)
+			}
+		}
+	}
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
 	ep.closed = true
+	ep.cleanup.Stop()
 
-	// Clean up C resources
-	if ep.cptr != nil {
-		C.event_processor_destroy(ep.cptr)
-		ep.cptr = nil
+	if ep.metrics != nil {
+		ep.metrics.unregister()
 	}
 
-	// Remove from callback map
-	callbackMu.Lock()
-	for id, proc := range callbackMap {
-		if proc == ep {
-			delete(callbackMap, id)
-			break
+	// Free the queue and anything left in it
+	ep.engine.destroy()
+	ep.panics.close()
+
+	// Anything still queued stays in the log for the next run
+	if ep.wal != nil {
+		if err := ep.wal.close(); err != nil {
+			ep.logger.Error("Failed to close write-ahead log", slog.Any("error", err))
 		}
 	}
-	callbackMu.Unlock()
 
 	ep.logger.Info("Event processor closed",
-		zap.String("name", ep.config.Name))
-
-	return nil
+		slog.String("name", ep.config.Name))
 }
 
-// finalize is called by GC if Close wasn't called
-func (ep *EventProcessor) finalize() {
-	if !ep.closed {
-		ep.Close()
-	}
+// liveHandles counts processor handles held by C, so leaked processors
+// show up in diagnostics. The engine keeps it: nocgo builds have no
+// handles, but count their processors the same way.
+var liveHandles atomic.Int64
+
+// LiveHandles returns the number of processors whose cgo.Handle is still
+// registered, that is, processors created and not yet closed
+func LiveHandles() int {
+	return int(liveHandles.Load())
 }
@@ -0,0 +1,155 @@
+package eventlib
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Injected failures use the C library's codes, so callers see the errors
+// a real failure would give
+const (
+	faultCodeQueueFull = -2 // EVENTLIB_ERR_QUEUE_FULL
+	faultCodeNoMem     = -3 // EVENTLIB_ERR_NOMEM
+)
+
+// FaultConfig sets how often each fault is injected, as a probability
+// from 0 to 1
+type FaultConfig struct {
+	// QueueFull fails pushes with ErrQueueFull
+	QueueFull float64
+
+	// CallFailure fails pushes as the C library does when it cannot
+	// allocate, with a CError of code EVENTLIB_ERR_NOMEM
+	CallFailure float64
+
+	// SlowHandler delays event handlers by SlowHandlerDelay (default
+	// 1s) before they run
+	SlowHandler      float64
+	SlowHandlerDelay time.Duration
+
+	// HandlerPanic makes event handlers panic instead of running, so the
+	// event is retried or dead-lettered
+	HandlerPanic float64
+}
+
+func (c FaultConfig) validate() error {
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{
+		{"QueueFull", c.QueueFull},
+		{"CallFailure", c.CallFailure},
+		{"SlowHandler", c.SlowHandler},
+		{"HandlerPanic", c.HandlerPanic},
+	} {
+		if p.value < 0 || p.value > 1 {
+			return fmt.Errorf("%w: %s probability %v is not between 0 and 1", ErrInvalidConfig, p.name, p.value)
+		}
+	}
+	if c.SlowHandlerDelay < 0 {
+		return fmt.Errorf("%w: negative SlowHandlerDelay %v", ErrInvalidConfig, c.SlowHandlerDelay)
+	}
+	return nil
+}
+
+// FaultCounts are how many faults of each kind have been injected
+type FaultCounts struct {
+	QueueFull    uint64
+	CallFailure  uint64
+	SlowHandler  uint64
+	HandlerPanic uint64
+}
+
+// Faults injects failures into the processors given it as Config.Faults,
+// so that producers' retries and the processors' own recovery can be
+// tested against a live service. Faults can be changed while the
+// processors run. Never set it in production.
+type Faults struct {
+	mu     sync.RWMutex
+	config FaultConfig
+
+	queueFull, callFailure, slowHandler, handlerPanic atomic.Uint64
+}
+
+// NewFaults returns Faults injecting at the rates in config
+func NewFaults(config FaultConfig) (*Faults, error) {
+	f := &Faults{}
+	if err := f.Set(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Set replaces the fault rates; a zero FaultConfig stops injecting
+func (f *Faults) Set(config FaultConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.SlowHandlerDelay == 0 {
+		config.SlowHandlerDelay = time.Second
+	}
+	f.mu.Lock()
+	f.config = config
+	f.mu.Unlock()
+	return nil
+}
+
+// Config returns the current fault rates
+func (f *Faults) Config() FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config
+}
+
+// Counts returns how many faults have been injected
+func (f *Faults) Counts() FaultCounts {
+	return FaultCounts{
+		QueueFull:    f.queueFull.Load(),
+		CallFailure:  f.callFailure.Load(),
+		SlowHandler:  f.slowHandler.Load(),
+		HandlerPanic: f.handlerPanic.Load(),
+	}
+}
+
+// hit reports whether a fault with probability p happens this time
+func hit(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// push returns the error to fail a push with, or nil. It is safe on nil
+// Faults.
+func (f *Faults) push() error {
+	if f == nil {
+		return nil
+	}
+	config := f.Config()
+	switch {
+	case hit(config.QueueFull):
+		f.queueFull.Add(1)
+		return &CError{Op: "push", Code: faultCodeQueueFull, Message: "queue full (injected)", kind: ErrQueueFull}
+	case hit(config.CallFailure):
+		f.callFailure.Add(1)
+		return &CError{Op: "push", Code: faultCodeNoMem, Message: "out of memory (injected)"}
+	}
+	return nil
+}
+
+// handle delays or panics before an event handler runs. It is safe on nil
+// Faults.
+func (f *Faults) handle() {
+	if f == nil {
+		return
+	}
+	config := f.Config()
+	if hit(config.SlowHandler) {
+		f.slowHandler.Add(1)
+		time.Sleep(config.SlowHandlerDelay)
+	}
+	if hit(config.HandlerPanic) {
+		f.handlerPanic.Add(1)
+		panic("injected handler panic")
+	}
+}
@@ -0,0 +1,195 @@
+package eventlib
+
+// Fuzz targets for the cgo boundary: events go through Push or PushBatch
+// into the C queue and come back through the callback conversion, and
+// must arrive intact. Run one with
+//
+//	go test -run='^$' -fuzz=FuzzPush -fuzztime=1m
+//
+// and under AddressSanitizer, which also checks the C side, with
+//
+//	make -C ../eventlib asan && go test -asan -run='^$' -fuzz=FuzzPush
+
+import (
+	"bytes"
+	"maps"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cString is s as it comes back from C: cut at the first NUL
+func cString(s string) string {
+	s, _, _ = strings.Cut(s, "\x00")
+	return s
+}
+
+// newFuzzProcessor returns a processor that appends every handled event
+// to the returned slice. ProcessAll runs handlers on the calling goroutine,
+// so the slice can be read once it returns.
+func newFuzzProcessor(tb testing.TB) (*EventProcessor, *[]Event) {
+	tb.Helper()
+
+	var handled []Event
+	ep, err := New(&Config{Name: "fuzz"}, &Handlers{
+		OnEvent: func(event Event) error {
+			handled = append(handled, event)
+			return nil
+		},
+	})
+	if err != nil {
+		tb.Fatalf("New: %v", err)
+	}
+	if err := ep.Start(); err != nil {
+		tb.Fatalf("Start: %v", err)
+	}
+	tb.Cleanup(func() { ep.Close() })
+	return ep, &handled
+}
+
+// checkEvent compares a handled event with the one pushed
+func checkEvent(t *testing.T, got, pushed Event) {
+	t.Helper()
+
+	if got.Type != pushed.Type {
+		t.Errorf("type = %d, pushed %d", got.Type, pushed.Type)
+	}
+	if want := cString(pushed.Source); got.Source != want {
+		t.Errorf("source = %q, want %q", got.Source, want)
+	}
+	if !bytes.Equal(got.Data, pushed.Data) {
+		t.Errorf("data differs: got %d bytes, pushed %d", len(got.Data), len(pushed.Data))
+	}
+	if want := cString(pushed.ID); got.ID != want {
+		t.Errorf("id = %q, want %q", got.ID, want)
+	}
+	if want := cString(pushed.ContentType); got.ContentType != want {
+		t.Errorf("content type = %q, want %q", got.ContentType, want)
+	}
+	if want := cString(pushed.CorrelationID); got.CorrelationID != want {
+		t.Errorf("correlation id = %q, want %q", got.CorrelationID, want)
+	}
+	// Metadata is length-prefixed, so NULs survive
+	if !maps.Equal(got.Metadata, pushed.Metadata) {
+		t.Errorf("metadata = %q, want %q", got.Metadata, pushed.Metadata)
+	}
+}
+
+func FuzzPush(f *testing.F) {
+	f.Add(uint16(0), "sensor-1", []byte("hello"), "", "", "key", "value")
+	f.Add(uint16(3), "", []byte{}, "id-1", "application/json", "", "")
+	f.Add(uint16(65535), "a\x00b", []byte("a\x00b"), "\x00id", "text/plain\x00x", "k\x00", "\x00v")
+	f.Add(uint16(1), "\xff\xfe\xfd", []byte{0xff, 0x00, 0xfe}, "\xc3\x28", "\xe2\x82", "\xf0\x28\x8c\x28", "\x80")
+	f.Add(uint16(2), strings.Repeat("s", 64<<10), bytes.Repeat([]byte{0xab}, 1<<20), strings.Repeat("i", 4096), "", "", strings.Repeat("v", 64<<10))
+
+	ep, handled := newFuzzProcessor(f)
+
+	f.Fuzz(func(t *testing.T, typ uint16, source string, data []byte, id, contentType, key, value string) {
+		*handled = (*handled)[:0]
+		pushed := Event{
+			Type:          EventType(typ),
+			Source:        source,
+			Data:          data,
+			ID:            id,
+			ContentType:   contentType,
+			CorrelationID: id + source,
+			Metadata:      map[string]string{key: value},
+		}
+		if err := ep.Push(pushed); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+		ep.ProcessAll()
+
+		if len(*handled) != 1 {
+			t.Fatalf("handled %d events, want 1", len(*handled))
+		}
+		checkEvent(t, (*handled)[0], pushed)
+	})
+}
+
+func FuzzPushBatch(f *testing.F) {
+	f.Add("batch", []byte("0123456789"), uint8(4))
+	f.Add("", []byte{}, uint8(0))
+	f.Add("\x00", []byte{0}, uint8(255))
+	f.Add("\xff", bytes.Repeat([]byte{0}, 1<<20), uint8(16))
+
+	ep, handled := newFuzzProcessor(f)
+
+	f.Fuzz(func(t *testing.T, source string, data []byte, n uint8) {
+		*handled = (*handled)[:0]
+
+		// Events with growing prefixes of data, including none
+		events := make([]Event, int(n)%32+1)
+		for i := range events {
+			events[i] = Event{
+				Type:   EventType(i),
+				Source: source + strconv.Itoa(i),
+				Data:   data[:len(data)*i/len(events)],
+			}
+		}
+		accepted, errs := ep.PushBatch(events)
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("event %d: %v", i, err)
+			}
+		}
+		if accepted != len(events) {
+			t.Fatalf("accepted %d of %d events", accepted, len(events))
+		}
+		ep.ProcessAll()
+
+		if len(*handled) != len(events) {
+			t.Fatalf("handled %d events, want %d", len(*handled), len(events))
+		}
+		for i, got := range *handled {
+			checkEvent(t, got, events[i])
+		}
+	})
+}
+
+func FuzzMetadata(f *testing.F) {
+	f.Add(appendMetadata(nil, map[string]string{"a": "1", "b": ""}))
+	f.Add(appendMetadata(nil, map[string]string{"\x00": "\xff"}))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{1, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		m := decodeMetadata(blob)
+		if m == nil {
+			return
+		}
+		encoded := appendMetadata(nil, m)
+		if len(encoded) != metadataSize(m) {
+			t.Errorf("metadataSize = %d, encoded %d bytes", metadataSize(m), len(encoded))
+		}
+		if again := decodeMetadata(encoded); !maps.Equal(again, m) {
+			t.Errorf("round trip = %q, want %q", again, m)
+		}
+	})
+}
+
+func FuzzRestore(f *testing.F) {
+	ep, _ := newFuzzProcessor(f)
+	for i := range 3 {
+		ep.Push(Event{Type: EventType(i), Source: "snap", Data: []byte{byte(i)}, ID: strconv.Itoa(i)})
+	}
+	var snapshot bytes.Buffer
+	if err := ep.Snapshot(&snapshot); err != nil {
+		f.Fatalf("Snapshot: %v", err)
+	}
+	f.Add(snapshot.Bytes())
+	f.Add(snapshot.Bytes()[:snapshot.Len()/2])
+	f.Add([]byte(snapshotMagic))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		restored, err := Restore(bytes.NewReader(data), &Config{Name: "fuzz-restore"}, &Handlers{
+			OnEvent: func(Event) error { return nil },
+		})
+		if err != nil {
+			return
+		}
+		defer restored.Close()
+		restored.ProcessAll()
+	})
+}
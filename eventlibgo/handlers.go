@@ -0,0 +1,122 @@
+package eventlib
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// handlerSet is the Handlers in use by a processor. Each callback counts
+// itself in while it runs, so a swap can wait for the callbacks still
+// using the old set.
+type handlerSet struct {
+	Handlers
+
+	inflight atomic.Int64
+	retired  atomic.Bool
+	done     chan struct{} // closed once retired with nothing in flight
+	doneOnce sync.Once
+}
+
+func newHandlerSet(handlers Handlers) *handlerSet {
+	return &handlerSet{Handlers: handlers, done: make(chan struct{})}
+}
+
+// release ends a callback started by acquireHandlers
+func (s *handlerSet) release() {
+	if s.inflight.Add(-1) == 0 && s.retired.Load() {
+		s.doneOnce.Do(func() { close(s.done) })
+	}
+}
+
+// retire waits for the callbacks still using s, which acquireHandlers no
+// longer returns
+func (s *handlerSet) retire() {
+	s.retired.Store(true)
+	if s.inflight.Load() == 0 {
+		return
+	}
+	<-s.done
+}
+
+// acquireHandlers returns the current handlers for one callback, which
+// must release them when it returns
+func (ep *EventProcessor) acquireHandlers() *handlerSet {
+	for {
+		s := ep.handlers.Load()
+		s.inflight.Add(1)
+		if ep.handlers.Load() == s {
+			return s
+		}
+		// Swapped in between; the new set is the one to use
+		s.release()
+	}
+}
+
+// SetHandlers replaces all of the processor's handlers while it runs,
+// keeping the events it has queued. A nil handlers removes them all.
+//
+// Callbacks that have started finish with the handlers they started with,
+// and SetHandlers returns once they have, so the old handlers are not
+// called after it returns. It must therefore not be called from a
+// handler, which would wait for itself.
+func (ep *EventProcessor) SetHandlers(handlers *Handlers) {
+	var next Handlers
+	if handlers != nil {
+		next = *handlers
+	}
+	ep.swapHandlers(func(Handlers) Handlers { return next })
+}
+
+// UpdateHandler replaces one of the processor's handlers while it runs, as
+// SetHandlers does for all of them. fn must have the type of the Handlers
+// field for kind, such as an EventHandler for HandlerEvent, or be nil to
+// remove the handler.
+func (ep *EventProcessor) UpdateHandler(kind HandlerKind, fn any) error {
+	field, ok := handlerFields[kind]
+	if !ok {
+		return fmt.Errorf("%w: unknown handler kind %q", ErrInvalidConfig, kind)
+	}
+
+	target := reflect.ValueOf(&Handlers{}).Elem().FieldByName(field)
+	value := reflect.Zero(target.Type())
+	if fn != nil {
+		v := reflect.ValueOf(fn)
+		if !v.Type().ConvertibleTo(target.Type()) {
+			return fmt.Errorf("%w: %s handler must be of type %s, not %T",
+				ErrInvalidConfig, kind, target.Type(), fn)
+		}
+		value = v.Convert(target.Type())
+	}
+
+	ep.swapHandlers(func(h Handlers) Handlers {
+		reflect.ValueOf(&h).Elem().FieldByName(field).Set(value)
+		return h
+	})
+	return nil
+}
+
+// handlerFields maps each kind of handler to its Handlers field
+var handlerFields = map[HandlerKind]string{
+	HandlerEvent:         "OnEvent",
+	HandlerFilter:        "OnFilter",
+	HandlerStateChange:   "OnStateChange",
+	HandlerExpired:       "OnExpired",
+	HandlerQueuePressure: "OnQueuePressure",
+	HandlerDeadLetter:    "OnDeadLetter",
+	HandlerPanic:         "OnPanic",
+}
+
+// swapHandlers installs the handlers update makes from the current ones
+// and waits for the callbacks still using the old set
+func (ep *EventProcessor) swapHandlers(update func(Handlers) Handlers) {
+	ep.swapMu.Lock()
+	defer ep.swapMu.Unlock()
+
+	old := ep.handlers.Load()
+	ep.handlers.Store(newHandlerSet(update(old.Handlers)))
+	old.retire()
+	ep.logger.Info("Handlers replaced", slog.String("name", ep.config.Name))
+}
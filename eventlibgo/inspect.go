@@ -0,0 +1,52 @@
+package eventlib
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// Peek returns copies of the first n events waiting in the queue, in the
+// order they will be processed, or all of them if n is 0 or less. Events
+// buffered by AsyncPush are flushed to the queue first; retries backing
+// off and scheduled events are not included.
+func (ep *EventProcessor) Peek(n int) ([]Event, error) {
+	ep.Flush()
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.closed {
+		return nil, ErrClosed
+	}
+	return ep.engine.peek(max(n, 0))
+}
+
+// Purge removes every queued event that match accepts and returns how many
+// were removed. They are not processed: they are acknowledged in the
+// journal and PushAndWait callers see OutcomePurged. Retries backing off
+// and scheduled events are left alone.
+//
+// match runs with the queue locked, so it must be quick and must not call
+// back into the processor.
+func (ep *EventProcessor) Purge(match func(Event) bool) (int, error) {
+	if match == nil {
+		return 0, errors.New("purge needs a match function")
+	}
+	ep.Flush()
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.closed {
+		return 0, ErrClosed
+	}
+
+	purged := ep.engine.purge(match, func(event Event, id uint64) {
+		ep.ack(id)
+		ep.waiters.settle(event.ID, Outcome{Status: OutcomePurged})
+	})
+	if purged > 0 {
+		ep.logger.Info("Queue purged",
+			slog.String("name", ep.config.Name),
+			slog.Int("purged", purged))
+	}
+	return purged, nil
+}
@@ -0,0 +1,63 @@
+package eventlib
+
+import (
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// leakedProcessors counts processors garbage collected without Close
+var leakedProcessors atomic.Int64
+
+// LeakedProcessors returns the number of processors garbage collected
+// without being closed. Their C queues were freed when they were
+// collected, and any events left in them were lost.
+func LeakedProcessors() int {
+	return int(leakedProcessors.Load())
+}
+
+// leakReport is what the cleanup of a leaked processor needs. It must not
+// refer to the processor, or the processor could never be collected.
+type leakReport struct {
+	name    string
+	logger  *slog.Logger
+	stack   []byte // Where New was called, with Config.LeakDetection
+	metrics *processorMetrics
+	release func()
+}
+
+// watchForLeak arranges for ep's C queue to be freed and the leak reported
+// if ep is garbage collected without Close. Close cancels it.
+//
+// A processor with AsyncPush workers or a pending scheduled event is
+// referenced by their goroutines, so it is never collected and its leak
+// shows only in LiveHandles.
+func (ep *EventProcessor) watchForLeak() {
+	report := leakReport{
+		name:    ep.config.Name,
+		logger:  ep.logger,
+		metrics: ep.metrics,
+		release: ep.engine.release(),
+	}
+	if ep.config.LeakDetection {
+		report.stack = debug.Stack()
+	}
+	ep.cleanup = runtime.AddCleanup(ep, leaked, report)
+}
+
+// leaked frees and reports a processor collected without Close
+func leaked(report leakReport) {
+	leakedProcessors.Add(1)
+
+	fields := []any{slog.String("name", report.name)}
+	if report.stack != nil {
+		fields = append(fields, slog.String("created", string(report.stack)))
+	}
+	report.logger.Error("Event processor garbage collected without Close", fields...)
+
+	if report.metrics != nil {
+		report.metrics.unregister()
+	}
+	report.release()
+}
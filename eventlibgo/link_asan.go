@@ -0,0 +1,19 @@
+//go:build asan && !eventlib_dlopen && !nocgo
+
+package eventlib
+
+// go test -asan sets the asan tag and instruments the Go side; the C
+// library must be rebuilt with `make -C ../eventlib asan` to match
+
+/*
+#cgo LDFLAGS: ${SRCDIR}/../eventlib/libeventlib_asan.a -lpthread
+*/
+import "C"
+
+// linkage describes how the C library is bound into the binary
+const linkage = "static (asan)"
+
+// loadLibrary is a no-op when the C library is linked statically
+func loadLibrary() error {
+	return nil
+}
@@ -0,0 +1,172 @@
+//go:build eventlib_dlopen && !nocgo
+
+package eventlib
+
+/*
+#cgo CFLAGS: -I${SRCDIR}/../eventlib
+#cgo LDFLAGS: -ldl -lpthread
+#include "eventlib.h"
+#include <dlfcn.h>
+#include <stdlib.h>
+
+// Every C API function the bindings use. R(ret, name, params, args) lists
+// functions with a return value, V(name, params, args) void ones.
+#define EVENTLIB_FUNCS(R, V)                                                         \
+  R(const char *, eventlib_version, (void), ())                                      \
+  R(const char *, eventlib_build_flags, (void), ())                                  \
+  R(const char *, eventlib_strerror, (eventlib_error_t err), (err))                  \
+  R(event_processor_t *, event_processor_create, (const event_config_t *config),     \
+    (config))                                                                        \
+  V(event_processor_destroy, (event_processor_t *processor), (processor))            \
+  R(bool, event_processor_push,                                                      \
+    (event_processor_t *processor, event_type_t type, const char *source,            \
+     const void *data, size_t data_len),                                             \
+    (processor, type, source, data, data_len))                                       \
+  R(bool, event_processor_push_event,                                                \
+    (event_processor_t *processor, const event_t *event), (processor, event))        \
+  R(eventlib_error_t, event_processor_submit,                                        \
+    (event_processor_t *processor, const event_t *event), (processor, event))        \
+  R(eventlib_error_t, event_processor_submit_owned,                                  \
+    (event_processor_t *processor, const event_t *event), (processor, event))        \
+  R(size_t, event_processor_submit_batch,                                            \
+    (event_processor_t *processor, const event_t *events, size_t count,              \
+     eventlib_error_t *results),                                                     \
+    (processor, events, count, results))                                             \
+  V(event_processor_process, (event_processor_t *processor), (processor))            \
+  V(event_processor_process_all, (event_processor_t *processor), (processor))        \
+  R(size_t, event_processor_process_all_until,                                       \
+    (event_processor_t *processor, const volatile int *cancel), (processor, cancel)) \
+  R(size_t, event_processor_process_n,                                               \
+    (event_processor_t *processor, size_t max, const volatile int *cancel),          \
+    (processor, max, cancel))                                                        \
+  R(const char *, event_processor_get_state,                                         \
+    (const event_processor_t *processor), (processor))                               \
+  R(size_t, event_processor_queue_size, (const event_processor_t *processor),        \
+    (processor))                                                                     \
+  R(size_t, event_processor_events_processed,                                        \
+    (const event_processor_t *processor), (processor))                               \
+  R(size_t, event_processor_events_expired,                                          \
+    (const event_processor_t *processor), (processor))                               \
+  V(event_processor_get_stats,                                                       \
+    (const event_processor_t *processor, event_processor_stats_t *stats),            \
+    (processor, stats))                                                              \
+  V(event_processor_start, (event_processor_t *processor), (processor))              \
+  V(event_processor_stop, (event_processor_t *processor), (processor))               \
+  V(event_processor_clear_queue, (event_processor_t *processor), (processor))        \
+  R(eventlib_error_t, event_processor_snapshot,                                      \
+    (const event_processor_t *processor, on_event_cb visit, void *user_data),        \
+    (processor, visit, user_data))                                                   \
+  R(eventlib_error_t, event_processor_peek,                                          \
+    (const event_processor_t *processor, size_t max, on_event_cb visit,              \
+     void *user_data),                                                               \
+    (processor, max, visit, user_data))                                              \
+  R(size_t, event_processor_purge,                                                   \
+    (event_processor_t *processor, on_filter_cb match, on_event_cb removed,          \
+     void *user_data),                                                               \
+    (processor, match, removed, user_data))                                          \
+  V(event_processor_set_max_queue_size,                                              \
+    (event_processor_t *processor, size_t max_queue_size),                           \
+    (processor, max_queue_size))                                                     \
+  R(size_t, event_processor_max_queue_size, (const event_processor_t *processor),    \
+    (processor))                                                                     \
+  V(event_processor_set_logging, (event_processor_t *processor, bool enabled),       \
+    (processor, enabled))                                                            \
+  V(event_processor_set_watermarks,                                                  \
+    (event_processor_t *processor, size_t high_watermark, size_t low_watermark),     \
+    (processor, high_watermark, low_watermark))
+
+// Function pointers resolved from the shared library
+#define DECLARE_R(ret, name, params, args) static ret(*name##_ptr) params;
+#define DECLARE_V(name, params, args) static void(*name##_ptr) params;
+EVENTLIB_FUNCS(DECLARE_R, DECLARE_V)
+
+// Forwarders that stand in for the statically linked API
+#define FORWARD_R(ret, name, params, args) \
+  ret name params { return name##_ptr args; }
+#define FORWARD_V(name, params, args) \
+  void name params { name##_ptr args; }
+EVENTLIB_FUNCS(FORWARD_R, FORWARD_V)
+
+// Opens the library and resolves every symbol. Returns NULL on success,
+// otherwise the dlerror text or the name of the missing symbol.
+static const char *eventlib_dl_open(const char *path) {
+  void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+  if (!handle)
+    return dlerror();
+
+#define LOAD_R(ret, name, params, args)                       \
+  if (!(*(void **)(&name##_ptr) = dlsym(handle, #name))) {    \
+    dlclose(handle);                                          \
+    return #name;                                             \
+  }
+#define LOAD_V(name, params, args) LOAD_R(void, name, params, args)
+  EVENTLIB_FUNCS(LOAD_R, LOAD_V)
+
+  return NULL;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// envLibraryPath overrides where the shared library is loaded from
+const envLibraryPath = "EVENTLIB_LIBRARY"
+
+var (
+	loadOnce sync.Once
+	loadErr  error
+
+	// embeddedLibrary extracts a bundled library, when built with one
+	embeddedLibrary func() (string, error)
+)
+
+// linkage describes how the C library is bound into the binary
+const linkage = "dlopen"
+
+// loadLibrary opens the C library on first use. The path comes from
+// $EVENTLIB_LIBRARY, then the embedded copy if any, then the system search
+// path.
+func loadLibrary() error {
+	loadOnce.Do(func() {
+		path, err := libraryPath()
+		if err != nil {
+			loadErr = err
+			return
+		}
+		loadErr = openLibrary(path)
+	})
+	return loadErr
+}
+
+func libraryPath() (string, error) {
+	if path := os.Getenv(envLibraryPath); path != "" {
+		return path, nil
+	}
+	if embeddedLibrary != nil {
+		return embeddedLibrary()
+	}
+	return libraryName(), nil
+}
+
+// libraryName is the platform's file name for the shared library
+func libraryName() string {
+	if runtime.GOOS == "darwin" {
+		return "libeventlib.dylib"
+	}
+	return "libeventlib.so"
+}
+
+func openLibrary(path string) error {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	if msg := C.eventlib_dl_open(cPath); msg != nil {
+		return fmt.Errorf("dlopen %s: %s", path, C.GoString(msg))
+	}
+	return nil
+}
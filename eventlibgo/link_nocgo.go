@@ -0,0 +1,12 @@
+//go:build !cgo || nocgo
+
+package eventlib
+
+// linkage describes how the C library is bound into the binary: not at
+// all, with the pure-Go queue in engine_nocgo.go standing in for it
+const linkage = "nocgo"
+
+// loadLibrary is a no-op without the C library
+func loadLibrary() error {
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !eventlib_dlopen && !asan && !nocgo
+
+package eventlib
+
+/*
+#cgo LDFLAGS: ${SRCDIR}/../eventlib/libeventlib.a -lpthread
+*/
+import "C"
+
+// linkage describes how the C library is bound into the binary
+const linkage = "static"
+
+// loadLibrary is a no-op when the C library is linked statically
+func loadLibrary() error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package eventlib
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// appendMetadata packs m into the blob C carries: for each entry, in key
+// order, the key and then the value, each as a little-endian u32 length
+// and the bytes
+func appendMetadata(b []byte, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(k)))
+		b = append(b, k...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(m[k])))
+		b = append(b, m[k]...)
+	}
+	return b
+}
+
+// metadataSize returns the length of m's encoding
+func metadataSize(m map[string]string) int {
+	n := 0
+	for k, v := range m {
+		n += 8 + len(k) + len(v)
+	}
+	return n
+}
+
+// decodeMetadata unpacks a blob from appendMetadata, or nil if it is
+// empty or malformed
+func decodeMetadata(b []byte) map[string]string {
+	if len(b) == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	field := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(len(b)-4) < uint64(n) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	for len(b) > 0 {
+		k, ok := field()
+		if !ok {
+			return nil
+		}
+		v, ok := field()
+		if !ok {
+			return nil
+		}
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,214 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"weak"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// processorMetrics exports a processor's counters to Prometheus. Every
+// series carries a "processor" label with Config.Name, so processors can
+// share a registry as long as their names differ.
+type processorMetrics struct {
+	reg prometheus.Registerer
+
+	stats       *statsExporter
+	cgoDuration *prometheus.HistogramVec
+	eventSize   prometheus.Histogram
+	panics      prometheus.Counter
+	timeouts    prometheus.Counter
+}
+
+// newProcessorMetrics registers ep's metrics in reg
+func newProcessorMetrics(ep *EventProcessor, reg prometheus.Registerer) (*processorMetrics, error) {
+	labels := prometheus.Labels{"processor": ep.config.Name}
+	m := &processorMetrics{
+		reg:   reg,
+		stats: newStatsExporter(ep, labels),
+		cgoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "eventlibgo_processor_cgo_call_duration_seconds",
+			Help:        "Duration of calls into the C library, including any handlers they run",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.000001, 4, 12),
+		}, []string{"call"}),
+		eventSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "eventlibgo_processor_event_size_bytes",
+			Help:        "Size of pushed events, counting data and metadata, including those refused as too large",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(64, 4, 10),
+		}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "eventlibgo_processor_handler_panics_total",
+			Help:        "Total number of event handler panics",
+			ConstLabels: labels,
+		}),
+		timeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "eventlibgo_processor_handler_timeouts_total",
+			Help:        "Total number of event handlers still running after HandlerTimeout",
+			ConstLabels: labels,
+		}),
+	}
+
+	for i, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			for _, registered := range m.collectors()[:i] {
+				reg.Unregister(registered)
+			}
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				return nil, fmt.Errorf("%w: metrics for processor %q are already registered", ErrInvalidConfig, ep.config.Name)
+			}
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *processorMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.stats, m.cgoDuration, m.eventSize, m.panics, m.timeouts}
+}
+
+// unregister removes the metrics, so a new processor can reuse the name
+func (m *processorMetrics) unregister() {
+	for _, c := range m.collectors() {
+		m.reg.Unregister(c)
+	}
+}
+
+// observeCgo records how long a C call that began at start took
+func (ep *EventProcessor) observeCgo(call string, start time.Time) {
+	if ep.metrics != nil {
+		ep.metrics.cgoDuration.WithLabelValues(call).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeSize records the size of a pushed event
+func (ep *EventProcessor) observeSize(event Event) {
+	if ep.metrics != nil {
+		ep.metrics.eventSize.Observe(float64(eventSize(event)))
+	}
+}
+
+// countPanic records a handler panic
+func (ep *EventProcessor) countPanic() {
+	if ep.metrics != nil {
+		ep.metrics.panics.Inc()
+	}
+}
+
+// countTimeout records a handler that overran HandlerTimeout
+func (ep *EventProcessor) countTimeout() {
+	if ep.metrics != nil {
+		ep.metrics.timeouts.Inc()
+	}
+}
+
+// statsExporter reads Stats at scrape time, so the counters cost nothing
+// between scrapes
+type statsExporter struct {
+	// Held weakly, so a registry does not keep a processor dropped
+	// without Close from being collected
+	ep weak.Pointer[EventProcessor]
+
+	queueSize    *prometheus.Desc
+	pushed       *prometheus.Desc
+	processed    *prometheus.Desc
+	dropped      *prometheus.Desc
+	filtered     *prometheus.Desc
+	duplicates   *prometheus.Desc
+	expired      *prometheus.Desc
+	retried      *prometheus.Desc
+	deadLettered *prometheus.Desc
+	cgoCalls     *prometheus.Desc
+
+	// The C library's own counters
+	submitted      *prometheus.Desc
+	cFiltered      *prometheus.Desc
+	rejected       *prometheus.Desc
+	cleared        *prometheus.Desc
+	highWater      *prometheus.Desc
+	pressure       *prometheus.Desc
+	processingTime *prometheus.Desc
+	processingMax  *prometheus.Desc
+}
+
+func newStatsExporter(ep *EventProcessor, labels prometheus.Labels) *statsExporter {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("eventlibgo_processor_"+name, help, nil, labels)
+	}
+	return &statsExporter{
+		ep:           weak.Make(ep),
+		queueSize:    desc("queue_size", "Events waiting in the queue"),
+		pushed:       desc("events_pushed_total", "Total number of events accepted by Push"),
+		processed:    desc("events_processed_total", "Total number of events processed"),
+		dropped:      desc("events_dropped_total", "Total number of events rejected by Push"),
+		filtered:     desc("events_filtered_total", "Total number of events rejected by OnFilter or dropped by a transformer"),
+		duplicates:   desc("events_duplicate_total", "Total number of events dropped by the dedup window"),
+		expired:      desc("events_expired_total", "Total number of events that expired before processing"),
+		retried:      desc("events_retried_total", "Total number of failed deliveries scheduled for retry"),
+		deadLettered: desc("events_dead_lettered_total", "Total number of events given up on after their last attempt"),
+		cgoCalls:     desc("cgo_calls_total", "Total number of calls from Go into the C library"),
+
+		submitted:      desc("c_events_submitted_total", "Total number of events queued by the C library"),
+		cFiltered:      desc("c_events_filtered_total", "Total number of events the C library dropped on OnFilter"),
+		rejected:       desc("c_events_rejected_total", "Total number of events the C library refused because the queue was full"),
+		cleared:        desc("c_events_cleared_total", "Total number of queued events removed by Purge or discarded on close"),
+		highWater:      desc("c_queue_high_water", "Largest queue size seen by the C library"),
+		pressure:       desc("c_queue_pressure", "1 while the queue is past its high watermark"),
+		processingTime: desc("c_processing_seconds_total", "Total time the C library spent in event callbacks"),
+		processingMax:  desc("c_processing_max_seconds", "Longest single event callback"),
+	}
+}
+
+func (e *statsExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range e.descs() {
+		ch <- d
+	}
+}
+
+func (e *statsExporter) descs() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		e.queueSize, e.pushed, e.processed, e.dropped, e.filtered,
+		e.duplicates, e.expired, e.retried, e.deadLettered, e.cgoCalls,
+		e.submitted, e.cFiltered, e.rejected, e.cleared, e.highWater, e.pressure, e.processingTime, e.processingMax,
+	}
+}
+
+func (e *statsExporter) Collect(ch chan<- prometheus.Metric) {
+	ep := e.ep.Value()
+	if ep == nil {
+		return
+	}
+	stats := ep.Stats()
+
+	ch <- prometheus.MustNewConstMetric(e.queueSize, prometheus.GaugeValue, float64(stats.QueueSize))
+	counter := func(desc *prometheus.Desc, value uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value))
+	}
+	counter(e.pushed, stats.Pushed)
+	counter(e.processed, stats.Processed)
+	counter(e.dropped, stats.Dropped)
+	counter(e.filtered, stats.Filtered)
+	counter(e.duplicates, stats.Duplicates)
+	counter(e.expired, stats.Expired)
+	counter(e.retried, stats.Retried)
+	counter(e.deadLettered, stats.DeadLettered)
+	counter(e.cgoCalls, stats.CgoCalls)
+
+	lib := stats.Library
+	counter(e.submitted, lib.Submitted)
+	counter(e.cFiltered, lib.Filtered)
+	counter(e.rejected, lib.Rejected)
+	counter(e.cleared, lib.Cleared)
+	ch <- prometheus.MustNewConstMetric(e.highWater, prometheus.GaugeValue, float64(lib.QueueHighWater))
+	pressure := 0.0
+	if lib.QueuePressure {
+		pressure = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.pressure, prometheus.GaugeValue, pressure)
+	ch <- prometheus.MustNewConstMetric(e.processingTime, prometheus.CounterValue, lib.ProcessingTime.Seconds())
+	ch <- prometheus.MustNewConstMetric(e.processingMax, prometheus.GaugeValue, lib.MaxProcessingTime.Seconds())
+}
@@ -0,0 +1,129 @@
+// Package middleware provides ready-made eventlib.Middleware for logging
+// and metrics around event handlers
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// Logging logs every handled event at debug level with its duration. A
+// failed handler is logged at warn level, and a panicking one at error
+// level before the panic continues.
+func Logging(logger *slog.Logger) eventlib.Middleware {
+	return func(next eventlib.EventHandler) eventlib.EventHandler {
+		return func(event eventlib.Event) (err error) {
+			start := time.Now()
+			defer func() {
+				fields := []any{
+					slog.String("event_type", event.Type.String()),
+					slog.String("source", event.Source),
+					slog.Int("attempt", event.Attempt),
+					slog.Duration("duration", time.Since(start)),
+				}
+				if r := recover(); r != nil {
+					logger.Error("Event handler panicked", append(fields, slog.Any("panic", r))...)
+					panic(r)
+				}
+				if err != nil {
+					logger.Warn("Event handler failed", append(fields, slog.Any("error", err))...)
+					return
+				}
+				logger.Debug("Handled event", fields...)
+			}()
+			return next(event)
+		}
+	}
+}
+
+// Metrics records handler duration, errors and panics per event type in
+// reg. It
+// can be called more than once with the same registerer; later calls share
+// the collectors registered by the first.
+func Metrics(reg prometheus.Registerer) (eventlib.Middleware, error) {
+	duration, err := register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eventlibgo_handler_duration_seconds",
+		Help:    "Time spent in event handlers",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"type"}))
+	if err != nil {
+		return nil, err
+	}
+	failures, err := register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_handler_errors_total",
+		Help: "Total number of errors returned by event handlers",
+	}, []string{"type"}))
+	if err != nil {
+		return nil, err
+	}
+	panics, err := register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_handler_panics_total",
+		Help: "Total number of event handler panics",
+	}, []string{"type"}))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next eventlib.EventHandler) eventlib.EventHandler {
+		return func(event eventlib.Event) (err error) {
+			start := time.Now()
+			defer func() {
+				eventType := event.Type.String()
+				duration.WithLabelValues(eventType).Observe(time.Since(start).Seconds())
+				if r := recover(); r != nil {
+					panics.WithLabelValues(eventType).Inc()
+					panic(r)
+				}
+				if err != nil {
+					failures.WithLabelValues(eventType).Inc()
+				}
+			}()
+			return next(event)
+		}
+	}, nil
+}
+
+// BreakerMetrics returns an OnStateChange callback for BreakerConfig that
+// records each breaker's state and transitions in reg, labelled by the
+// breaker's name. As for Metrics, later calls share the collectors
+// registered by the first.
+func BreakerMetrics(reg prometheus.Registerer) (func(name string, from, to eventlib.BreakerState), error) {
+	state, err := register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_circuit_breaker_state",
+		Help: "Circuit breaker state: 0 closed, 1 open, 2 half-open",
+	}, []string{"name"}))
+	if err != nil {
+		return nil, err
+	}
+	transitions, err := register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_circuit_breaker_transitions_total",
+		Help: "Total number of circuit breaker state changes, by the state entered",
+	}, []string{"name", "state"}))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string, from, to eventlib.BreakerState) {
+		state.WithLabelValues(name).Set(float64(to))
+		transitions.WithLabelValues(name, to.String()).Inc()
+	}, nil
+}
+
+// register registers c, or returns the collector already registered in its
+// place
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
@@ -0,0 +1,135 @@
+package eventlib
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultErrorBufferSize is how many handler errors Errors buffers
+const DefaultErrorBufferSize = 100
+
+// HandlerKind names a kind of handler, such as the one that panicked
+type HandlerKind string
+
+const (
+	HandlerEvent         HandlerKind = "event"
+	HandlerFilter        HandlerKind = "filter"
+	HandlerStateChange   HandlerKind = "state_change"
+	HandlerExpired       HandlerKind = "expired"
+	HandlerQueuePressure HandlerKind = "queue_pressure"
+	HandlerDeadLetter    HandlerKind = "dead_letter"
+	HandlerAsyncError    HandlerKind = "async_error"
+	HandlerPanic         HandlerKind = "panic"
+)
+
+// HandlerError reports a panic recovered from a handler. Event is the
+// event being handled, or the zero Event for handlers not given one.
+type HandlerError struct {
+	Kind  HandlerKind
+	Event Event
+	Value any    // What the handler panicked with
+	Stack []byte // The panicking goroutine's stack
+	Time  time.Time
+}
+
+func (e HandlerError) Error() string {
+	return fmt.Sprintf("panic in %s handler: %v", e.Kind, e.Value)
+}
+
+// Unwrap lets errors.Is match ErrHandlerPanic
+func (e HandlerError) Unwrap() error {
+	return ErrHandlerPanic
+}
+
+// ErrorReporter is implemented by processors that report handler panics
+type ErrorReporter interface {
+	Errors() <-chan HandlerError
+}
+
+var _ ErrorReporter = (*EventProcessor)(nil)
+
+// PanicHandler is told about each handler panic, on the goroutine that
+// recovered it
+type PanicHandler func(herr HandlerError)
+
+// panicReporter hands recovered panics to OnPanic and the Errors channel
+type panicReporter struct {
+	mu     sync.Mutex
+	ch     chan HandlerError
+	closed bool
+}
+
+func newPanicReporter(size int) *panicReporter {
+	if size <= 0 {
+		size = DefaultErrorBufferSize
+	}
+	return &panicReporter{ch: make(chan HandlerError, size)}
+}
+
+// send queues herr for Errors, dropping it if the buffer is full
+func (p *panicReporter) send(herr HandlerError) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	select {
+	case p.ch <- herr:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *panicReporter) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		p.closed = true
+		close(p.ch)
+	}
+}
+
+// reportPanic tells OnPanic and Errors about a value recovered from a
+// handler of kind. Call it from the deferred function that recovered, so
+// the stack still shows where the panic happened.
+func (ep *EventProcessor) reportPanic(kind HandlerKind, event Event, r any) {
+	herr := HandlerError{
+		Kind:  kind,
+		Event: event,
+		Value: r,
+		Stack: debug.Stack(),
+		Time:  time.Now(),
+	}
+
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnPanic != nil {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					ep.logger.Error("Panic in panic handler",
+						slog.Any("panic", r))
+				}
+			}()
+			handlers.OnPanic(herr)
+		}()
+	}
+
+	if !ep.panics.send(herr) {
+		ep.logger.Debug("Handler error not delivered to Errors",
+			slog.String("kind", string(kind)))
+	}
+}
+
+// Errors returns a channel of the panics recovered from handlers, for
+// alerting on handler failures. A panic is dropped from the channel if
+// ErrorBufferSize are already waiting, and the channel is closed by Close.
+func (ep *EventProcessor) Errors() <-chan HandlerError {
+	return ep.panics.ch
+}
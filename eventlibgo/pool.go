@@ -0,0 +1,580 @@
+package eventlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardKeyFunc picks the value an event is sharded by. Events with the same
+// key always land on the same shard, so their order is kept.
+type ShardKeyFunc func(event Event) string
+
+// SourceShardKey shards events by their source
+func SourceShardKey(event Event) string {
+	return event.Source
+}
+
+// PoolConfig configures a ProcessorPool
+type PoolConfig struct {
+	Shards int // Default 1
+
+	// ShardKey defaults to SourceShardKey
+	ShardKey ShardKeyFunc
+}
+
+// ProcessorPool spreads events over several EventProcessors, each with its
+// own C queue and lock, so pushes to different shards do not contend.
+// Shards are processed concurrently, so handlers must be safe for
+// concurrent use.
+type ProcessorPool struct {
+	name   string
+	shards []*EventProcessor
+	key    ShardKeyFunc
+	errs   chan HandlerError
+
+	// next is where Process starts looking for a non-empty shard
+	next atomic.Uint64
+}
+
+var (
+	_ Processor          = (*ProcessorPool)(nil)
+	_ ContextProcessor   = (*ProcessorPool)(nil)
+	_ BatchPusher        = (*ProcessorPool)(nil)
+	_ NoCopyPusher       = (*ProcessorPool)(nil)
+	_ Waiter             = (*ProcessorPool)(nil)
+	_ Subscriber         = (*ProcessorPool)(nil)
+	_ Pausable           = (*ProcessorPool)(nil)
+	_ PressureReporter   = (*ProcessorPool)(nil)
+	_ StatsProvider      = (*ProcessorPool)(nil)
+	_ Drainer            = (*ProcessorPool)(nil)
+	_ Routable           = (*ProcessorPool)(nil)
+	_ DeadLetterProvider = (*ProcessorPool)(nil)
+	_ Tunable            = (*ProcessorPool)(nil)
+	_ Scheduler          = (*ProcessorPool)(nil)
+	_ ErrorReporter      = (*ProcessorPool)(nil)
+	_ QueueInspector     = (*ProcessorPool)(nil)
+	_ HandlerSwapper     = (*ProcessorPool)(nil)
+	_ Restarter          = (*ProcessorPool)(nil)
+)
+
+// NewPool creates poolConfig.Shards processors from config. Shard i is
+// named "<name>-<i>" and, with persistence, journals to "shard-<i>" under
+// config.PersistencePath. MaxQueueSize applies to each shard, and each
+// shard keeps its own dedup window, so duplicates are only caught when
+// they have the same shard key.
+func NewPool(poolConfig PoolConfig, config *Config, handlers *Handlers) (*ProcessorPool, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: config cannot be nil", ErrInvalidConfig)
+	}
+	if poolConfig.Shards < 0 {
+		return nil, fmt.Errorf("%w: negative Shards %d", ErrInvalidConfig, poolConfig.Shards)
+	}
+	if poolConfig.Shards == 0 {
+		poolConfig.Shards = 1
+	}
+	if poolConfig.ShardKey == nil {
+		poolConfig.ShardKey = SourceShardKey
+	}
+
+	pool := &ProcessorPool{
+		name: config.Name,
+		key:  poolConfig.ShardKey,
+	}
+
+	for i := 0; i < poolConfig.Shards; i++ {
+		shardConfig := *config
+		shardConfig.Name = fmt.Sprintf("%s-%d", config.Name, i)
+		if config.PersistencePath != "" {
+			shardConfig.PersistencePath = filepath.Join(config.PersistencePath, fmt.Sprintf("shard-%d", i))
+		}
+
+		ep, err := New(&shardConfig, handlers)
+		if err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("failed to create shard %d: %w", i, err)
+		}
+		pool.shards = append(pool.shards, ep)
+	}
+
+	// Merge the shards' handler panics; the channel closes with the last
+	// shard
+	size := config.ErrorBufferSize
+	if size <= 0 {
+		size = DefaultErrorBufferSize
+	}
+	pool.errs = make(chan HandlerError, size)
+	var wg sync.WaitGroup
+	for _, ep := range pool.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for herr := range ep.Errors() {
+				select {
+				case pool.errs <- herr:
+				default:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(pool.errs)
+	}()
+
+	return pool, nil
+}
+
+// Errors returns a channel of the panics recovered from every shard's
+// handlers. It is closed once every shard is.
+func (p *ProcessorPool) Errors() <-chan HandlerError {
+	return p.errs
+}
+
+// Shards returns the number of shards
+func (p *ProcessorPool) Shards() int {
+	return len(p.shards)
+}
+
+// shardFor returns the index of the shard that owns event
+func (p *ProcessorPool) shardFor(event Event) int {
+	if len(p.shards) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.key(event)))
+	return int(h.Sum32() % uint32(len(p.shards)))
+}
+
+// each runs fn on every shard concurrently and joins the errors
+func (p *ProcessorPool) each(fn func(ep *EventProcessor) error) error {
+	errs := make([]error, len(p.shards))
+
+	var wg sync.WaitGroup
+	for i, ep := range p.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ep); err != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Start starts every shard
+func (p *ProcessorPool) Start() error {
+	return p.each((*EventProcessor).Start)
+}
+
+// Stop stops every shard
+func (p *ProcessorPool) Stop() error {
+	return p.each((*EventProcessor).Stop)
+}
+
+// SetMaxQueueSize changes every shard's queue limit
+func (p *ProcessorPool) SetMaxQueueSize(size int) error {
+	return p.each(func(ep *EventProcessor) error {
+		return ep.SetMaxQueueSize(size)
+	})
+}
+
+// MaxQueueSize returns the queue limit of each shard
+func (p *ProcessorPool) MaxQueueSize() int {
+	return p.shards[0].MaxQueueSize()
+}
+
+// SetLogging turns every shard's C log messages on or off
+func (p *ProcessorPool) SetLogging(enabled bool) error {
+	return p.each(func(ep *EventProcessor) error {
+		return ep.SetLogging(enabled)
+	})
+}
+
+// Logging reports whether the shards' C log messages are on
+func (p *ProcessorPool) Logging() bool {
+	return p.shards[0].Logging()
+}
+
+// Push adds an event to its shard's queue
+func (p *ProcessorPool) Push(event Event) error {
+	return p.shards[p.shardFor(event)].Push(event)
+}
+
+// PushContext is Push, but fails fast once ctx is done
+func (p *ProcessorPool) PushContext(ctx context.Context, event Event) error {
+	return p.shards[p.shardFor(event)].PushContext(ctx, event)
+}
+
+// PushNoCopy hands buf to the event's shard, as EventProcessor.PushNoCopy
+func (p *ProcessorPool) PushNoCopy(event Event, buf *Buffer) error {
+	if buf != nil {
+		// The shard key may look at the data
+		event.Data = buf.Bytes()
+	}
+	return p.shards[p.shardFor(event)].PushNoCopy(event, buf)
+}
+
+// PushAndWait pushes event to its shard and processes that shard until
+// the event is done, as EventProcessor.PushAndWait
+func (p *ProcessorPool) PushAndWait(ctx context.Context, event Event) (Outcome, error) {
+	return p.shards[p.shardFor(event)].PushAndWait(ctx, event)
+}
+
+// QueuePressure reports whether any shard's queue is past its high
+// watermark
+func (p *ProcessorPool) QueuePressure() bool {
+	for _, ep := range p.shards {
+		if ep.QueuePressure() {
+			return true
+		}
+	}
+	return false
+}
+
+// Pause pauses intake on every shard
+func (p *ProcessorPool) Pause() {
+	for _, ep := range p.shards {
+		ep.Pause()
+	}
+}
+
+// Resume resumes intake on every shard
+func (p *ProcessorPool) Resume() {
+	for _, ep := range p.shards {
+		ep.Resume()
+	}
+}
+
+// Paused reports whether intake is paused
+func (p *ProcessorPool) Paused() bool {
+	return p.shards[0].Paused()
+}
+
+// Subscribe returns one channel fed by every shard. Shards process
+// concurrently, so events from different shards arrive interleaved.
+func (p *ProcessorPool) Subscribe(opts ...SubscribeOption) (<-chan Event, func()) {
+	s := newSubscription(opts)
+	for _, ep := range p.shards {
+		if !ep.subs.add(s) {
+			s.close()
+		}
+	}
+	return s.ch, func() {
+		for _, ep := range p.shards {
+			ep.subs.remove(s)
+		}
+		s.close()
+	}
+}
+
+// PushBatch splits events by shard and queues each part with one cgo call.
+// errs lines up with events as for EventProcessor.PushBatch.
+func (p *ProcessorPool) PushBatch(events []Event) (accepted int, errs []error) {
+	errs = make([]error, len(events))
+
+	parts := make([][]int, len(p.shards))
+	for i, event := range events {
+		shard := p.shardFor(event)
+		parts[shard] = append(parts[shard], i)
+	}
+
+	for shard, index := range parts {
+		if len(index) == 0 {
+			continue
+		}
+		batch := make([]Event, len(index))
+		for j, i := range index {
+			batch[j] = events[i]
+		}
+
+		n, batchErrs := p.shards[shard].PushBatch(batch)
+		accepted += n
+		for j, i := range index {
+			errs[i] = batchErrs[j]
+		}
+	}
+
+	return accepted, errs
+}
+
+// Process handles one event from the next shard that has any
+func (p *ProcessorPool) Process() {
+	start := int(p.next.Add(1) % uint64(len(p.shards)))
+	for i := range p.shards {
+		ep := p.shards[(start+i)%len(p.shards)]
+		if ep.QueueSize() > 0 {
+			ep.Process()
+			return
+		}
+	}
+}
+
+// ProcessN processes up to n events, taking shards in turn from the next
+// one until n are done or every shard is empty
+func (p *ProcessorPool) ProcessN(n int) int {
+	count := 0
+	start := int(p.next.Add(1) % uint64(len(p.shards)))
+	for i := range p.shards {
+		if count >= n {
+			break
+		}
+		count += p.shards[(start+i)%len(p.shards)].ProcessN(n - count)
+	}
+	return count
+}
+
+// ProcessContext is Process, skipped entirely once ctx is done
+func (p *ProcessorPool) ProcessContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.Process()
+	return nil
+}
+
+// ProcessAll drains every shard, all shards at once
+func (p *ProcessorPool) ProcessAll() {
+	p.each(func(ep *EventProcessor) error {
+		ep.ProcessAll()
+		return nil
+	})
+}
+
+// ProcessAllContext drains every shard concurrently until they are empty
+// or ctx is done
+func (p *ProcessorPool) ProcessAllContext(ctx context.Context) error {
+	p.each(func(ep *EventProcessor) error {
+		return ep.ProcessAllContext(ctx)
+	})
+	return ctx.Err()
+}
+
+// Drain drains every shard concurrently and sums the counts
+func (p *ProcessorPool) Drain(ctx context.Context) (drained, abandoned int, err error) {
+	var mu sync.Mutex
+	err = p.each(func(ep *EventProcessor) error {
+		d, a, err := ep.Drain(ctx)
+		mu.Lock()
+		drained += d
+		abandoned += a
+		mu.Unlock()
+		return err
+	})
+	return drained, abandoned, err
+}
+
+// Handle routes events of type et to handler on every shard
+func (p *ProcessorPool) Handle(et EventType, handler EventHandler) {
+	for _, ep := range p.shards {
+		ep.Handle(et, handler)
+	}
+}
+
+// HandleSource routes events whose source matches the glob pattern to
+// handler on every shard
+func (p *ProcessorPool) HandleSource(pattern string, handler EventHandler) {
+	for _, ep := range p.shards {
+		ep.HandleSource(pattern, handler)
+	}
+}
+
+// Use wraps every shard's handlers in middleware
+func (p *ProcessorPool) Use(middleware ...Middleware) {
+	for _, ep := range p.shards {
+		ep.Use(middleware...)
+	}
+}
+
+// Flush waits for every shard's async buffer to drain
+func (p *ProcessorPool) Flush() {
+	for _, ep := range p.shards {
+		ep.Flush()
+	}
+}
+
+// QueueSize returns the number of events queued across all shards
+func (p *ProcessorPool) QueueSize() int {
+	total := 0
+	for _, ep := range p.shards {
+		total += ep.QueueSize()
+	}
+	return total
+}
+
+// EventsProcessed returns the number of events processed by all shards
+func (p *ProcessorPool) EventsProcessed() int {
+	total := 0
+	for _, ep := range p.shards {
+		total += ep.EventsProcessed()
+	}
+	return total
+}
+
+// State returns the shards' common state, or "MIXED" if they disagree
+func (p *ProcessorPool) State() string {
+	state := p.shards[0].State()
+	for _, ep := range p.shards[1:] {
+		if ep.State() != state {
+			return "MIXED"
+		}
+	}
+	return state
+}
+
+// Stats sums the shards' counters. Latency percentiles are the worst
+// across shards, since exact ones cannot be recovered from summaries.
+func (p *ProcessorPool) Stats() Stats {
+	stats := Stats{
+		Name:            p.name,
+		State:           p.State(),
+		ProcessedByType: make(map[string]uint64),
+	}
+
+	for _, ep := range p.shards {
+		shard := ep.Stats()
+		stats.Shards = append(stats.Shards, shard)
+
+		stats.Uptime = max(stats.Uptime, shard.Uptime)
+		stats.QueueSize += shard.QueueSize
+		stats.Buffered += shard.Buffered
+		stats.RetryPending += shard.RetryPending
+		stats.Scheduled += shard.Scheduled
+		stats.Pushed += shard.Pushed
+		stats.Processed += shard.Processed
+		stats.Dropped += shard.Dropped
+		stats.Filtered += shard.Filtered
+		stats.Duplicates += shard.Duplicates
+		stats.Expired += shard.Expired
+		stats.Retried += shard.Retried
+		stats.DeadLettered += shard.DeadLettered
+		stats.CgoCalls += shard.CgoCalls
+		stats.Callbacks += shard.Callbacks
+		stats.Subscribers = max(stats.Subscribers, shard.Subscribers)
+		stats.SubscriberDrops += shard.SubscriberDrops
+		for k, v := range shard.ProcessedByType {
+			stats.ProcessedByType[k] += v
+		}
+		stats.HandlerLatency = stats.HandlerLatency.merge(shard.HandlerLatency)
+		stats.QueueLatency = stats.QueueLatency.merge(shard.QueueLatency)
+		stats.Library = stats.Library.add(shard.Library)
+	}
+
+	return stats
+}
+
+// DeadLetters returns every shard's dead letters, oldest first
+func (p *ProcessorPool) DeadLetters() []DeadLetter {
+	var letters []DeadLetter
+	for _, ep := range p.shards {
+		letters = append(letters, ep.DeadLetters()...)
+	}
+	slices.SortStableFunc(letters, func(a, b DeadLetter) int {
+		return a.Time.Compare(b.Time)
+	})
+	return letters
+}
+
+// Scheduled returns every shard's scheduled events, soonest first
+func (p *ProcessorPool) Scheduled() []Event {
+	var events []Event
+	for _, ep := range p.shards {
+		events = append(events, ep.Scheduled()...)
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.DeliverAt.Compare(b.DeliverAt)
+	})
+	return events
+}
+
+// CancelScheduled cancels the scheduled event with id on whichever shard
+// holds it
+func (p *ProcessorPool) CancelScheduled(id string) bool {
+	for _, ep := range p.shards {
+		if ep.CancelScheduled(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Peek returns the first n queued events of each shard in turn, or all of
+// them if n is 0 or less. Shards process independently, so there is no
+// single order across them.
+func (p *ProcessorPool) Peek(n int) ([]Event, error) {
+	var events []Event
+	for _, ep := range p.shards {
+		queued, err := ep.Peek(n)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, queued...)
+		if n > 0 && len(events) >= n {
+			return events[:n], nil
+		}
+	}
+	return events, nil
+}
+
+// Purge removes the events match accepts from every shard's queue
+func (p *ProcessorPool) Purge(match func(Event) bool) (int, error) {
+	total := 0
+	for _, ep := range p.shards {
+		purged, err := ep.Purge(match)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// SetHandlers replaces every shard's handlers, one shard at a time
+func (p *ProcessorPool) SetHandlers(handlers *Handlers) {
+	for _, ep := range p.shards {
+		ep.SetHandlers(handlers)
+	}
+}
+
+// UpdateHandler replaces one of every shard's handlers, one shard at a
+// time. An invalid fn is refused before any shard is changed.
+func (p *ProcessorPool) UpdateHandler(kind HandlerKind, fn any) error {
+	for _, ep := range p.shards {
+		if err := ep.UpdateHandler(kind, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EngineConfig returns the settings of each shard's C processor
+func (p *ProcessorPool) EngineConfig() EngineConfig {
+	return p.shards[0].EngineConfig()
+}
+
+// Restart restarts every shard with config, one shard at a time, and
+// returns how many queued events were moved across in all
+func (p *ProcessorPool) Restart(config EngineConfig) (int, error) {
+	total := 0
+	for _, ep := range p.shards {
+		requeued, err := ep.Restart(config)
+		total += requeued
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Close closes every shard
+func (p *ProcessorPool) Close() error {
+	return p.each((*EventProcessor).Close)
+}
@@ -0,0 +1,114 @@
+package eventlib
+
+import "context"
+
+// Processor is the queue-and-dispatch surface shared by all backends. The
+// cgo-backed EventProcessor is the default; alternative implementations
+// (such as the Redis-backed one in the redisqueue package) trade raw
+// throughput for durability or horizontal scaling.
+type Processor interface {
+	Start() error
+	Stop() error
+	Push(event Event) error
+	Process()
+	ProcessAll()
+	QueueSize() int
+	EventsProcessed() int
+	State() string
+	Close() error
+}
+
+var _ Processor = (*EventProcessor)(nil)
+
+// ContextProcessor is implemented by processors whose blocking calls can be
+// bounded by a context
+type ContextProcessor interface {
+	PushContext(ctx context.Context, event Event) error
+	ProcessContext(ctx context.Context) error
+	ProcessAllContext(ctx context.Context) error
+}
+
+var _ ContextProcessor = (*EventProcessor)(nil)
+
+// BatchProcessor is implemented by processors that can handle many events
+// in one call more cheaply than one Process per event
+type BatchProcessor interface {
+	ProcessN(n int) int
+}
+
+var _ BatchProcessor = (*EventProcessor)(nil)
+
+// Drainer is implemented by processors that can finish their queued work
+// before shutting down
+type Drainer interface {
+	Drain(ctx context.Context) (drained, abandoned int, err error)
+}
+
+var _ Drainer = (*EventProcessor)(nil)
+
+// Pausable is implemented by processors whose intake can be paused while
+// queued events are still processed, to shed load
+type Pausable interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+var _ Pausable = (*EventProcessor)(nil)
+
+// PressureReporter is implemented by processors that track when their
+// queue is past its high watermark
+type PressureReporter interface {
+	QueuePressure() bool
+}
+
+var _ PressureReporter = (*EventProcessor)(nil)
+
+// Routable is implemented by processors that can send events to handlers
+// registered per type or per source, on top of Handlers.OnEvent, and wrap
+// all of them in middleware
+type Routable interface {
+	Handle(et EventType, handler EventHandler)
+	HandleSource(pattern string, handler EventHandler)
+	Use(middleware ...Middleware)
+}
+
+var _ Routable = (*EventProcessor)(nil)
+
+// Tunable is implemented by processors whose queue limit and logging can
+// be changed while they run
+type Tunable interface {
+	MaxQueueSize() int
+	SetMaxQueueSize(size int) error
+	Logging() bool
+	SetLogging(enabled bool) error
+}
+
+var _ Tunable = (*EventProcessor)(nil)
+
+// QueueInspector is implemented by processors whose queue can be looked at
+// and selectively emptied while they run
+type QueueInspector interface {
+	Peek(n int) ([]Event, error)
+	Purge(match func(Event) bool) (int, error)
+}
+
+var _ QueueInspector = (*EventProcessor)(nil)
+
+// HandlerSwapper is implemented by processors whose handlers can be
+// replaced while they run, without losing queued events
+type HandlerSwapper interface {
+	SetHandlers(handlers *Handlers)
+	UpdateHandler(kind HandlerKind, fn any) error
+}
+
+var _ HandlerSwapper = (*EventProcessor)(nil)
+
+// Restarter is implemented by processors whose C processor can be
+// recreated with new settings without losing queued events
+type Restarter interface {
+	EngineConfig() EngineConfig
+	Restart(config EngineConfig) (int, error)
+}
+
+var _ Restarter = (*EventProcessor)(nil)
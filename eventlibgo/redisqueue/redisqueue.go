@@ -0,0 +1,900 @@
+// Package redisqueue provides an eventlib.Processor backed by a Redis list,
+// so that several stateless server replicas can share one durable queue.
+//
+// Processing follows the reliable queue pattern: a replica moves each event
+// from the queue into its own processing list and removes it from there
+// once it has been handled, retried or dead-lettered. Replicas record a
+// heartbeat, and the processing list of one that stops, as when it crashes
+// mid-event, is moved back onto the queue. Retries wait out their backoff in
+// a sorted set, so they survive a crash too. Delivery is at-least-once.
+package redisqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// Processor states, matching the C library's state names
+const (
+	stateIdle    = "IDLE"
+	stateRunning = "RUNNING"
+	stateStopped = "STOPPED"
+	stateClosed  = "CLOSED"
+)
+
+// Config holds Redis processor configuration
+type Config struct {
+	Name         string
+	MaxQueueSize int
+	Logger       *slog.Logger
+
+	// Addr, Password and DB select the Redis server
+	Addr     string
+	Password string
+	DB       int
+
+	// Key is the list holding queued events. "<Key>:processed" holds the
+	// shared processed counter, "<Key>:retry" the retries waiting out their
+	// backoff, "<Key>:processing:<Consumer>" the events a replica is
+	// handling and "<Key>:consumers" the replicas' heartbeats.
+	Key string
+
+	// Consumer names this replica's processing list (default
+	// "<hostname>-<pid>"). Replicas sharing Key need distinct names; a
+	// replica restarted under the same name puts back what it left
+	// unfinished straight away.
+	Consumer string
+
+	// ReclaimAfter is how long a replica may go without a heartbeat before
+	// another moves the events it was handling back onto the queue
+	// (default DefaultClaimIdle). Make it longer than a stall in the
+	// process can last, or events it is still handling run twice.
+	ReclaimAfter time.Duration
+
+	// Timeout bounds each Redis round trip (default 5s)
+	Timeout time.Duration
+
+	// Retry, if set, pushes events whose handlers return an error back
+	// onto the shared queue after a backoff
+	Retry *eventlib.RetryPolicy
+
+	// DeadLetterSize is how many dead letters "<Key>:dead" keeps (default
+	// 1000)
+	DeadLetterSize int
+}
+
+// wireEvent is the JSON encoding of an event stored in Redis
+type wireEvent struct {
+	// Type is the type's name, since custom type numbers depend on
+	// registration order and may differ between processes. Unnamed types
+	// are written as their number.
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Data   []byte `json:"data,omitempty"`
+
+	// DeadlineMs is the expiry as Unix milliseconds (0 = never)
+	DeadlineMs int64 `json:"deadline_ms,omitempty"`
+
+	// TimestampMs is the original occurrence time as Unix milliseconds
+	TimestampMs int64 `json:"timestamp_ms,omitempty"`
+	Backfill    bool  `json:"backfill,omitempty"`
+
+	// Priority is carried through but does not affect ordering; the Redis
+	// list is always FIFO
+	Priority int `json:"priority,omitempty"`
+
+	ContentType string `json:"content_type,omitempty"`
+
+	// EnqueuedNs is when Push accepted the event, as Unix nanoseconds
+	EnqueuedNs int64 `json:"enqueued_ns,omitempty"`
+
+	TraceParent string `json:"trace_parent,omitempty"`
+
+	// Attempt counts earlier failed deliveries
+	Attempt int `json:"attempt,omitempty"`
+
+	ID string `json:"id,omitempty"`
+
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// wireDeadLetter is the JSON encoding of a dead letter stored in Redis
+type wireDeadLetter struct {
+	Event    wireEvent `json:"event"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	TimeMs   int64     `json:"time_ms"`
+}
+
+// Processor is an eventlib.Processor that keeps its queue in Redis
+type Processor struct {
+	client   *redis.Client
+	config   *Config
+	handlers *eventlib.Handlers
+	router   *eventlib.Router
+	logger   *slog.Logger
+
+	mu     sync.RWMutex
+	state  string
+	closed bool
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// heartbeatsPerReclaim is how many heartbeats a replica records within
+// ReclaimAfter, so a late one or two do not get its events reclaimed
+const heartbeatsPerReclaim = 3
+
+var (
+	// pushScript appends ARGV[1] to the queue unless it already holds
+	// ARGV[2] events, checking and pushing in one step so that replicas
+	// cannot overshoot MaxQueueSize between them
+	pushScript = redis.NewScript(`
+local limit = tonumber(ARGV[2])
+if limit > 0 and redis.call('LLEN', KEYS[1]) >= limit then
+	return -1
+end
+return redis.call('LPUSH', KEYS[1], ARGV[1])
+`)
+
+	// promoteScript moves the retries in KEYS[2] that are due by ARGV[1]
+	// onto the queue in KEYS[1]
+	promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, payload in ipairs(due) do
+	redis.call('LPUSH', KEYS[1], payload)
+	redis.call('ZREM', KEYS[2], payload)
+end
+return #due
+`)
+
+	// reclaimScript moves the events in the processing list KEYS[2] back
+	// to the head of the queue in KEYS[1], the oldest to be popped first
+	reclaimScript = redis.NewScript(`
+local n = 0
+while redis.call('LMOVE', KEYS[2], KEYS[1], 'LEFT', 'RIGHT') do
+	n = n + 1
+end
+return n
+`)
+)
+
+var (
+	_ eventlib.Processor          = (*Processor)(nil)
+	_ eventlib.Routable           = (*Processor)(nil)
+	_ eventlib.DeadLetterProvider = (*Processor)(nil)
+)
+
+// New connects to Redis and creates a processor
+func New(config *Config, handlers *eventlib.Handlers) (*Processor, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: config cannot be nil", eventlib.ErrInvalidConfig)
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("%w: redis key cannot be empty", eventlib.ErrInvalidConfig)
+	}
+	if handlers == nil {
+		handlers = &eventlib.Handlers{}
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.DeadLetterSize <= 0 {
+		config.DeadLetterSize = eventlib.DefaultDeadLetterSize
+	}
+	if config.Consumer == "" {
+		host, _ := os.Hostname()
+		config.Consumer = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if config.ReclaimAfter <= 0 {
+		config.ReclaimAfter = DefaultClaimIdle
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	p := &Processor{
+		client:        client,
+		config:        config,
+		handlers:      handlers,
+		router:        eventlib.NewRouter(),
+		logger:        logger,
+		state:         stateIdle,
+		stopHeartbeat: make(chan struct{}),
+		heartbeatDone: make(chan struct{}),
+	}
+
+	// Nothing is in flight yet, so whatever this consumer's list holds was
+	// left by an earlier run under the same name
+	p.reclaim(p.config.Consumer)
+	p.beat()
+	p.reclaimStale()
+	go p.heartbeat()
+
+	logger.Info("Redis event processor created",
+		slog.String("name", config.Name),
+		slog.String("addr", config.Addr),
+		slog.String("key", config.Key),
+		slog.String("consumer", config.Consumer))
+
+	return p, nil
+}
+
+func (p *Processor) processedKey() string {
+	return p.config.Key + ":processed"
+}
+
+func (p *Processor) deadLetterKey() string {
+	return p.config.Key + ":dead"
+}
+
+func (p *Processor) retryKey() string {
+	return p.config.Key + ":retry"
+}
+
+func (p *Processor) consumersKey() string {
+	return p.config.Key + ":consumers"
+}
+
+func (p *Processor) processingKey(consumer string) string {
+	return p.config.Key + ":processing:" + consumer
+}
+
+// heartbeat records that this replica is alive, and reclaims the events of
+// those that are not, until Close
+func (p *Processor) heartbeat() {
+	defer close(p.heartbeatDone)
+
+	ticker := time.NewTicker(p.config.ReclaimAfter / heartbeatsPerReclaim)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.beat()
+			p.reclaimStale()
+		case <-p.stopHeartbeat:
+			return
+		}
+	}
+}
+
+func (p *Processor) beat() {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if err := p.client.HSet(ctx, p.consumersKey(), p.config.Consumer, time.Now().UnixMilli()).Err(); err != nil {
+		p.logger.Warn("Failed to record heartbeat", slog.Any("error", err))
+	}
+}
+
+// reclaimStale puts back the events of replicas whose last heartbeat is
+// older than ReclaimAfter, and forgets those replicas
+func (p *Processor) reclaimStale() {
+	ctx, cancel := p.context()
+	beats, err := p.client.HGetAll(ctx, p.consumersKey()).Result()
+	cancel()
+	if err != nil {
+		p.logger.Warn("Failed to read heartbeats", slog.Any("error", err))
+		return
+	}
+
+	cutoff := time.Now().Add(-p.config.ReclaimAfter).UnixMilli()
+	for consumer, beat := range beats {
+		if consumer == p.config.Consumer {
+			continue
+		}
+		if ms, err := strconv.ParseInt(beat, 10, 64); err == nil && ms >= cutoff {
+			continue
+		}
+		if !p.reclaim(consumer) {
+			continue
+		}
+		ctx, cancel := p.context()
+		p.client.HDel(ctx, p.consumersKey(), consumer)
+		cancel()
+	}
+}
+
+// reclaim moves the events in consumer's processing list back onto the
+// queue, reporting whether that succeeded
+func (p *Processor) reclaim(consumer string) bool {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	n, err := reclaimScript.Run(ctx, p.client,
+		[]string{p.config.Key, p.processingKey(consumer)}).Int()
+	if err != nil {
+		p.logger.Error("Failed to reclaim events",
+			slog.String("consumer", consumer), slog.Any("error", err))
+		return false
+	}
+	if n > 0 {
+		p.logger.Warn("Reclaimed events left unfinished by a replica",
+			slog.String("consumer", consumer), slog.Int("events", n))
+	}
+	return true
+}
+
+func (p *Processor) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), p.config.Timeout)
+}
+
+// changeState updates the state and notifies the state change handler
+func (p *Processor) changeState(newState string) {
+	if p.state == newState {
+		return
+	}
+
+	oldState := p.state
+	p.state = newState
+
+	if p.handlers.OnStateChange == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic in state change handler",
+				slog.Any("panic", r))
+		}
+	}()
+	p.handlers.OnStateChange(oldState, newState)
+}
+
+// Start starts the processor
+func (p *Processor) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return eventlib.ErrClosed
+	}
+
+	p.changeState(stateRunning)
+	return nil
+}
+
+// Stop stops the processor
+func (p *Processor) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return eventlib.ErrClosed
+	}
+
+	p.changeState(stateStopped)
+	return nil
+}
+
+// Push filters the event and appends it to the shared queue
+func (p *Processor) Push(event eventlib.Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return eventlib.ErrClosed
+	}
+
+	if !p.filter(event) {
+		p.logger.Debug("Event filtered out")
+		return nil
+	}
+
+	return p.enqueue(event)
+}
+
+// toWire encodes an event for Redis
+func toWire(event eventlib.Event) wireEvent {
+	wire := wireEvent{
+		Type:        strconv.Itoa(int(event.Type)),
+		Source:      event.Source,
+		Data:        event.Data,
+		Backfill:    event.Backfill,
+		Priority:    event.Priority,
+		ContentType: event.ContentType,
+		TraceParent: event.TraceParent,
+		Attempt:     event.Attempt,
+		ID:          event.ID,
+
+		CorrelationID: event.CorrelationID,
+		Metadata:      event.Metadata,
+	}
+	if name, ok := eventlib.DefaultEventTypes.Name(event.Type); ok {
+		wire.Type = name
+	}
+	if !event.Deadline.IsZero() {
+		wire.DeadlineMs = event.Deadline.UnixMilli()
+	}
+	if !event.Timestamp.IsZero() {
+		wire.TimestampMs = event.Timestamp.UnixMilli()
+	}
+	if !event.EnqueuedAt.IsZero() {
+		wire.EnqueuedNs = event.EnqueuedAt.UnixNano()
+	}
+	return wire
+}
+
+// fromWire decodes an event read from Redis
+func fromWire(wire wireEvent) eventlib.Event {
+	event := eventlib.Event{
+		Type:        wireType(wire.Type),
+		Source:      wire.Source,
+		Data:        wire.Data,
+		Backfill:    wire.Backfill,
+		Priority:    wire.Priority,
+		ContentType: wire.ContentType,
+		TraceParent: wire.TraceParent,
+		Attempt:     wire.Attempt,
+		ID:          wire.ID,
+
+		CorrelationID: wire.CorrelationID,
+		Metadata:      wire.Metadata,
+	}
+	if wire.DeadlineMs > 0 {
+		event.Deadline = time.UnixMilli(wire.DeadlineMs)
+	}
+	if wire.TimestampMs > 0 {
+		event.Timestamp = time.UnixMilli(wire.TimestampMs)
+	}
+	if wire.EnqueuedNs > 0 {
+		event.EnqueuedAt = time.Unix(0, wire.EnqueuedNs)
+	}
+	return event
+}
+
+// wireType resolves a type written by toWire. A custom type this process
+// has not registered yet is registered under its name, so the name
+// survives being handled here.
+func wireType(name string) eventlib.EventType {
+	if et, err := eventlib.ParseEventType(name); err == nil {
+		return et
+	}
+	et, err := eventlib.DefaultEventTypes.Register(name)
+	if err != nil {
+		return eventlib.EventTypeData
+	}
+	return et
+}
+
+// enqueue appends an event to the shared queue, stamping its enqueue time
+func (p *Processor) enqueue(event eventlib.Event) error {
+	event.EnqueuedAt = time.Now()
+	payload, err := json.Marshal(toWire(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	size, err := pushScript.Run(ctx, p.client, []string{p.config.Key},
+		payload, p.config.MaxQueueSize).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to push event: %w", err)
+	}
+	if size < 0 {
+		p.logger.Warn("Queue full", slog.Int("max_queue_size", p.config.MaxQueueSize))
+		return eventlib.ErrQueueFull
+	}
+	return nil
+}
+
+// filter runs the filter handler with recovery
+func (p *Processor) filter(event eventlib.Event) (allow bool) {
+	if p.handlers.OnFilter == nil {
+		return true
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic in filter handler",
+				slog.Any("panic", r))
+			allow = true // Default to allowing on error
+		}
+	}()
+	return p.handlers.OnFilter(event)
+}
+
+// Process pops and handles a single event
+func (p *Processor) Process() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	p.promoteRetries()
+	p.processOne()
+}
+
+// ProcessAll handles events until the shared queue is empty
+func (p *Processor) ProcessAll() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return
+	}
+
+	p.promoteRetries()
+
+	count := 0
+	for p.processOne() {
+		count++
+	}
+
+	if count > 0 {
+		p.logger.Info("Processed events", slog.Int("count", count))
+	}
+}
+
+// promoteRetries moves retries whose backoff is over back onto the queue
+func (p *Processor) promoteRetries() {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	err := promoteScript.Run(ctx, p.client, []string{p.config.Key, p.retryKey()},
+		time.Now().UnixMilli()).Err()
+	if err != nil {
+		p.logger.Warn("Failed to requeue retries", slog.Any("error", err))
+	}
+}
+
+// processOne handles the oldest queued event, reporting whether one was
+// found. The event moves to this replica's processing list first, and
+// leaves it only once it has been dealt with, so a crash in between hands
+// it to another replica instead of losing it.
+func (p *Processor) processOne() bool {
+	if p.state != stateRunning {
+		p.logger.Warn("Processor not running")
+		return false
+	}
+
+	ctx, cancel := p.context()
+	payload, err := p.client.LMove(ctx, p.config.Key, p.processingKey(p.config.Consumer), "RIGHT", "LEFT").Bytes()
+	cancel()
+	if errors.Is(err, redis.Nil) {
+		return false
+	}
+	if err != nil {
+		p.logger.Error("Failed to pop event", slog.Any("error", err))
+		return false
+	}
+
+	var wire wireEvent
+	if err := json.Unmarshal(payload, &wire); err != nil {
+		p.logger.Error("Dropping undecodable event", slog.Any("error", err))
+		p.ack(payload)
+		return true
+	}
+	event := fromWire(wire)
+
+	// Expire events whose deadline has passed instead of handling them late
+	if !event.Deadline.IsZero() && time.Now().After(event.Deadline) {
+		p.expire(event)
+		p.ack(payload)
+		return true
+	}
+
+	event.ProcessedAt = time.Now()
+	if err := p.dispatch(event); err != nil {
+		p.handleFailure(event, err, payload)
+	} else {
+		p.ack(payload)
+	}
+
+	ctx, cancel = p.context()
+	defer cancel()
+	if err := p.client.Incr(ctx, p.processedKey()).Err(); err != nil {
+		p.logger.Warn("Failed to update processed counter", slog.Any("error", err))
+	}
+
+	return true
+}
+
+// ack removes a finished event from this replica's processing list. If
+// that fails the event is handled again once the list is reclaimed.
+func (p *Processor) ack(payload []byte) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if err := p.client.LRem(ctx, p.processingKey(p.config.Consumer), 1, payload).Err(); err != nil {
+		p.logger.Warn("Failed to acknowledge event", slog.Any("error", err))
+	}
+}
+
+// dispatch runs the routed handlers, then OnEvent, each with recovery,
+// and joins their errors
+func (p *Processor) dispatch(event eventlib.Event) error {
+	handlers := p.router.Match(event)
+	if p.handlers.OnEvent != nil {
+		handlers = append(handlers, p.router.Wrap(p.handlers.OnEvent))
+	}
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := p.callHandler(handler, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// callHandler runs an event handler, turning a panic into an error
+func (p *Processor) callHandler(handler eventlib.EventHandler, event eventlib.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic in event handler",
+				slog.Any("panic", r),
+				slog.String("event_type", event.Type.String()))
+			err = fmt.Errorf("%w: %v", eventlib.ErrHandlerPanic, r)
+		}
+	}()
+	return handler(event)
+}
+
+// handleFailure moves a failed event from the processing list to the
+// retry set, due back on the queue after its backoff, or dead-letters it
+// once its attempts are used up
+func (p *Processor) handleFailure(event eventlib.Event, err error, payload []byte) {
+	attempts := event.Attempt + 1
+	if p.config.Retry == nil || attempts >= p.config.Retry.Attempts() {
+		p.deadLetter(event, err, attempts)
+		p.ack(payload)
+		return
+	}
+
+	event.Attempt = attempts
+	event.ProcessedAt = time.Time{}
+	due := time.Now().Add(p.config.Retry.Backoff(attempts))
+	event.EnqueuedAt = due
+	retry, encodeErr := json.Marshal(toWire(event))
+	if encodeErr != nil {
+		p.deadLetter(event, errors.Join(err, fmt.Errorf("failed to encode retry: %w", encodeErr)), attempts)
+		p.ack(payload)
+		return
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	_, txErr := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, p.retryKey(), redis.Z{Score: float64(due.UnixMilli()), Member: retry})
+		pipe.LRem(ctx, p.processingKey(p.config.Consumer), 1, payload)
+		return nil
+	})
+	if txErr != nil {
+		// Still in the processing list, so it is retried once reclaimed
+		p.logger.Error("Failed to schedule retry", slog.Any("error", txErr))
+	}
+}
+
+// deadLetter records an event that will not be retried again in the
+// shared dead letter list
+func (p *Processor) deadLetter(event eventlib.Event, err error, attempts int) {
+	letter := eventlib.DeadLetter{
+		Event:    event,
+		Err:      err,
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+
+	p.logger.Warn("Event dead-lettered",
+		slog.String("event_type", event.Type.String()),
+		slog.String("source", event.Source),
+		slog.Int("attempts", attempts),
+		slog.Any("error", err))
+
+	payload, encodeErr := json.Marshal(wireDeadLetter{
+		Event:    toWire(event),
+		Error:    err.Error(),
+		Attempts: attempts,
+		TimeMs:   letter.Time.UnixMilli(),
+	})
+	if encodeErr == nil {
+		ctx, cancel := p.context()
+		_, encodeErr = p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, p.deadLetterKey(), payload)
+			pipe.LTrim(ctx, p.deadLetterKey(), 0, int64(p.config.DeadLetterSize-1))
+			return nil
+		})
+		cancel()
+	}
+	if encodeErr != nil {
+		p.logger.Error("Failed to store dead letter", slog.Any("error", encodeErr))
+	}
+
+	if p.handlers.OnDeadLetter == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic in dead letter handler",
+				slog.Any("panic", r))
+		}
+	}()
+	p.handlers.OnDeadLetter(letter)
+}
+
+// DeadLetters returns the dead letters recorded by all replicas, oldest
+// first
+func (p *Processor) DeadLetters() []eventlib.DeadLetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return nil
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	payloads, err := p.client.LRange(ctx, p.deadLetterKey(), 0, -1).Result()
+	if err != nil {
+		p.logger.Warn("Failed to read dead letters", slog.Any("error", err))
+		return nil
+	}
+
+	letters := make([]eventlib.DeadLetter, 0, len(payloads))
+	for i := len(payloads) - 1; i >= 0; i-- {
+		var wire wireDeadLetter
+		if err := json.Unmarshal([]byte(payloads[i]), &wire); err != nil {
+			continue
+		}
+		letters = append(letters, eventlib.DeadLetter{
+			Event:    fromWire(wire.Event),
+			Err:      errors.New(wire.Error),
+			Attempts: wire.Attempts,
+			Time:     time.UnixMilli(wire.TimeMs),
+		})
+	}
+	return letters
+}
+
+// Handle routes events of type et to handler, alongside Handlers.OnEvent
+func (p *Processor) Handle(et eventlib.EventType, handler eventlib.EventHandler) {
+	p.router.Handle(et, handler)
+}
+
+// HandleSource routes events whose source matches the glob pattern to
+// handler, alongside Handlers.OnEvent
+func (p *Processor) HandleSource(pattern string, handler eventlib.EventHandler) {
+	p.router.HandleSource(pattern, handler)
+}
+
+// Use wraps OnEvent and every routed handler in middleware
+func (p *Processor) Use(middleware ...eventlib.Middleware) {
+	p.router.Use(middleware...)
+}
+
+// expire runs the expired handler with recovery
+func (p *Processor) expire(event eventlib.Event) {
+	p.logger.Debug("Event expired",
+		slog.String("event_type", event.Type.String()))
+
+	if p.handlers.OnExpired == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Panic in expired handler",
+				slog.Any("panic", r),
+				slog.String("event_type", event.Type.String()))
+		}
+	}()
+	p.handlers.OnExpired(event)
+}
+
+// QueueSize returns the length of the shared queue, without the events
+// being handled or waiting to be retried
+func (p *Processor) QueueSize() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return 0
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	size, err := p.client.LLen(ctx, p.config.Key).Result()
+	if err != nil {
+		p.logger.Warn("Failed to read queue size", slog.Any("error", err))
+		return 0
+	}
+	return int(size)
+}
+
+// EventsProcessed returns the number of events processed by all replicas
+func (p *Processor) EventsProcessed() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return 0
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	count, err := p.client.Get(ctx, p.processedKey()).Int()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		p.logger.Warn("Failed to read processed counter", slog.Any("error", err))
+	}
+	return count
+}
+
+// State returns the current processor state
+func (p *Processor) State() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return stateClosed
+	}
+	return p.state
+}
+
+// Close disconnects from Redis; queued events and retries remain there
+// for the other replicas. Its heartbeat is removed, so none of them waits
+// for it.
+func (p *Processor) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	close(p.stopHeartbeat)
+	<-p.heartbeatDone
+
+	// Processing holds the read lock, so nothing is in flight; put back
+	// anything an ack failed to remove
+	if p.reclaim(p.config.Consumer) {
+		ctx, cancel := p.context()
+		p.client.HDel(ctx, p.consumersKey(), p.config.Consumer)
+		cancel()
+	}
+
+	p.logger.Info("Redis event processor closed",
+		slog.String("name", p.config.Name))
+
+	return p.client.Close()
+}
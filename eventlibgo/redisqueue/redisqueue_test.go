@@ -0,0 +1,206 @@
+package redisqueue
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+const testKey = "q"
+
+// newTestProcessor returns a started processor on mr that appends the
+// sources of the events it handles to handled
+func newTestProcessor(t *testing.T, mr *miniredis.Miniredis, config Config, handled *[]string) *Processor {
+	t.Helper()
+
+	config.Addr = mr.Addr()
+	config.Key = testKey
+	p, err := New(&config, &eventlib.Handlers{
+		OnEvent: func(event eventlib.Event) error {
+			*handled = append(*handled, event.Source)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	return p
+}
+
+func push(t *testing.T, p *Processor, sources ...string) {
+	t.Helper()
+
+	for _, source := range sources {
+		if err := p.Push(eventlib.Event{Type: eventlib.EventTypeData, Source: source}); err != nil {
+			t.Fatalf("Push %s: %v", source, err)
+		}
+	}
+}
+
+// takeWithoutFinishing moves the oldest queued event into consumer's
+// processing list, as a replica does before it crashes mid-event
+func takeWithoutFinishing(t *testing.T, mr *miniredis.Miniredis, consumer string) {
+	t.Helper()
+
+	payload, err := mr.Pop(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Lpush(testKey+":processing:"+consumer, payload)
+}
+
+func TestRestartReclaimsOwnEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var handled []string
+	p := newTestProcessor(t, mr, Config{Consumer: "a"}, &handled)
+	push(t, p, "e1", "e2", "e3")
+	takeWithoutFinishing(t, mr, "a")
+	// The replica dies here, without Close
+
+	p = newTestProcessor(t, mr, Config{Consumer: "a"}, &handled)
+	defer p.Close()
+	p.ProcessAll()
+
+	if want := []string{"e1", "e2", "e3"}; !slices.Equal(handled, want) {
+		t.Fatalf("handled %q, want %q", handled, want)
+	}
+	if mr.Exists(testKey + ":processing:a") {
+		t.Fatal("processing list left behind")
+	}
+}
+
+func TestStaleReplicaReclaimed(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var handled []string
+	p := newTestProcessor(t, mr, Config{Consumer: "a"}, &handled)
+	defer p.Close()
+	push(t, p, "e1", "e2")
+
+	// Replica b took e1 and has not been heard from for a while
+	takeWithoutFinishing(t, mr, "b")
+	stale := time.Now().Add(-2 * DefaultClaimIdle).UnixMilli()
+	mr.HSet(testKey+":consumers", "b", strconv.FormatInt(stale, 10))
+
+	p.reclaimStale()
+	p.ProcessAll()
+
+	if want := []string{"e1", "e2"}; !slices.Equal(handled, want) {
+		t.Fatalf("handled %q, want %q", handled, want)
+	}
+	if mr.Exists(testKey+":processing:b") || mr.HGet(testKey+":consumers", "b") != "" {
+		t.Fatal("stale replica not forgotten")
+	}
+}
+
+func TestLiveReplicaNotReclaimed(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var handled []string
+	p := newTestProcessor(t, mr, Config{Consumer: "a"}, &handled)
+	defer p.Close()
+	push(t, p, "e1", "e2")
+
+	takeWithoutFinishing(t, mr, "b")
+	mr.HSet(testKey+":consumers", "b", strconv.FormatInt(time.Now().UnixMilli(), 10))
+
+	p.reclaimStale()
+	p.ProcessAll()
+
+	if want := []string{"e2"}; !slices.Equal(handled, want) {
+		t.Fatalf("handled %q, want %q", handled, want)
+	}
+}
+
+func TestRetriesSurviveRestart(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	config := Config{
+		Consumer: "a",
+		Retry:    &eventlib.RetryPolicy{MaxAttempts: 3, InitialBackoff: 20 * time.Millisecond},
+	}
+	config.Addr = mr.Addr()
+	config.Key = testKey
+	p, err := New(&config, &eventlib.Handlers{
+		OnEvent: func(eventlib.Event) error { return errors.New("unavailable") },
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p.Start()
+	push(t, p, "e1")
+	p.ProcessAll()
+	p.Close()
+
+	members, err := mr.ZMembers(testKey + ":retry")
+	if err != nil || len(members) != 1 {
+		t.Fatalf("retry set holds %d events (%v), want 1", len(members), err)
+	}
+
+	var attempts []int
+	config = Config{Consumer: "b", Addr: mr.Addr(), Key: testKey}
+	p, err = New(&config, &eventlib.Handlers{
+		OnEvent: func(event eventlib.Event) error {
+			attempts = append(attempts, event.Attempt)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+	p.Start()
+
+	time.Sleep(30 * time.Millisecond)
+	p.ProcessAll()
+	if want := []int{1}; !slices.Equal(attempts, want) {
+		t.Fatalf("retried with attempts %v, want %v", attempts, want)
+	}
+}
+
+func TestMaxQueueSize(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var handled []string
+	p := newTestProcessor(t, mr, Config{Consumer: "a", MaxQueueSize: 2}, &handled)
+	defer p.Close()
+	push(t, p, "e1", "e2")
+
+	err := p.Push(eventlib.Event{Type: eventlib.EventTypeData, Source: "e3"})
+	if !errors.Is(err, eventlib.ErrQueueFull) {
+		t.Fatalf("Push past MaxQueueSize: %v, want ErrQueueFull", err)
+	}
+}
+
+func TestWireTypeByName(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var handled []string
+	p := newTestProcessor(t, mr, Config{Consumer: "a"}, &handled)
+	defer p.Close()
+
+	custom := eventlib.RegisterEventType("redisqueue.test")
+	if err := p.Push(eventlib.Event{Type: custom, Source: "e1"}); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	list, _ := mr.List(testKey)
+	if len(list) != 1 || !strings.Contains(list[0], `"type":"redisqueue.test"`) {
+		t.Fatalf("queued %q, want the type by name", list)
+	}
+	if et := wireType("redisqueue.test"); et != custom {
+		t.Fatalf("wireType = %d, want %d", et, custom)
+	}
+	if et := wireType("CONNECT"); et != eventlib.EventTypeConnect {
+		t.Fatalf("wireType(CONNECT) = %d", et)
+	}
+}
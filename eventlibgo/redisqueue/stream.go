@@ -0,0 +1,778 @@
+package redisqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// Stream defaults
+const (
+	DefaultStreamGroup     = "eventlib"
+	DefaultStreamBatchSize = 100
+	DefaultStreamBlock     = time.Second
+	DefaultClaimIdle       = time.Minute
+	DefaultMaxDeliveries   = 5
+)
+
+// StreamIDKey is the Event.Metadata key carrying the stream entry ID of an
+// event consumed by a Stream
+const StreamIDKey = "eventlib.stream_id"
+
+// streamMetricsInterval is how often stream lag is read from Redis
+const streamMetricsInterval = 5 * time.Second
+
+// StreamConfig configures a Stream
+type StreamConfig struct {
+	Logger *slog.Logger
+
+	// Addr, Password and DB select the Redis server
+	Addr     string
+	Password string
+	DB       int
+
+	// Key is the Redis stream events are added to
+	Key string
+
+	// Group is the consumer group shared by every instance (default
+	// "eventlib"); Consumer names this instance within it (default
+	// "<hostname>-<pid>") and must be stable across restarts for its
+	// pending entries to be picked up again straight away
+	Group    string
+	Consumer string
+
+	// MaxLen, if set, trims the stream to about this many entries on each
+	// add. Entries trimmed before they are read are lost.
+	MaxLen int64
+
+	// BatchSize is how many entries are read at once, and Block how long
+	// a read waits for new ones
+	BatchSize int
+	Block     time.Duration
+
+	// ClaimIdle is how long an entry can stay unacknowledged before
+	// another instance claims it, as when its consumer crashed. Make it
+	// longer than local retries can take.
+	ClaimIdle time.Duration
+
+	// MaxDeliveries is how many times an entry is delivered before it is
+	// acknowledged and abandoned, so a poison entry cannot circulate
+	// forever
+	MaxDeliveries int
+
+	// Timeout bounds each Redis round trip (default 5s)
+	Timeout time.Duration
+
+	// Registerer, if set, receives the stream's Prometheus metrics,
+	// labelled with Key and Group
+	Registerer prometheus.Registerer
+}
+
+func (c *StreamConfig) validate() error {
+	switch {
+	case c.Key == "":
+		return fmt.Errorf("%w: redis stream key cannot be empty", eventlib.ErrInvalidConfig)
+	case c.BatchSize < 0 || c.MaxDeliveries < 0 || c.MaxLen < 0:
+		return fmt.Errorf("%w: negative redis stream setting", eventlib.ErrInvalidConfig)
+	case c.Block < 0 || c.ClaimIdle < 0:
+		return fmt.Errorf("%w: negative redis stream duration", eventlib.ErrInvalidConfig)
+	}
+	return nil
+}
+
+// LocalFactory builds the in-process processor a Stream feeds
+type LocalFactory func(handlers *eventlib.Handlers) (eventlib.Processor, error)
+
+// Stream is a processor whose Push adds events to a Redis stream. Every
+// instance sharing the stream reads from it in one consumer group and
+// pushes what it reads into its own local processor, usually the C-backed
+// EventProcessor, so events are spread across instances and each one is
+// handled once.
+//
+// An entry is acknowledged once its event is settled locally: handled,
+// filtered by OnFilter, expired or dead-lettered. Entries left pending by
+// an instance that stopped are claimed by another after ClaimIdle, so
+// delivery is at least once. Events dropped before the local queue, by a
+// Transformer or the dedup window, are never acknowledged and are
+// abandoned after MaxDeliveries.
+type Stream struct {
+	client *redis.Client
+	config StreamConfig
+	logger *slog.Logger
+	local  eventlib.Processor
+
+	// handlers and router run on the local processor's OnEvent, so the
+	// entry is only acknowledged once all of them have succeeded
+	handlers *eventlib.Handlers
+	router   *eventlib.Router
+	metrics  *streamMetrics
+
+	mu      sync.Mutex
+	closed  bool
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+var (
+	_ eventlib.Processor          = (*Stream)(nil)
+	_ eventlib.Routable           = (*Stream)(nil)
+	_ eventlib.DeadLetterProvider = (*Stream)(nil)
+	_ eventlib.StatsProvider      = (*Stream)(nil)
+)
+
+// NewStream connects to Redis, creates the consumer group if needed and
+// builds the local processor with newLocal. Consumption starts with Start.
+func NewStream(config *StreamConfig, newLocal LocalFactory, handlers *eventlib.Handlers) (*Stream, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: config cannot be nil", eventlib.ErrInvalidConfig)
+	}
+	cfg := *config
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if handlers == nil {
+		handlers = &eventlib.Handlers{}
+	}
+	if cfg.Group == "" {
+		cfg.Group = DefaultStreamGroup
+	}
+	if cfg.Consumer == "" {
+		host, _ := os.Hostname()
+		cfg.Consumer = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultStreamBatchSize
+	}
+	if cfg.Block == 0 {
+		cfg.Block = DefaultStreamBlock
+	}
+	if cfg.ClaimIdle == 0 {
+		cfg.ClaimIdle = DefaultClaimIdle
+	}
+	if cfg.MaxDeliveries == 0 {
+		cfg.MaxDeliveries = DefaultMaxDeliveries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	err := client.XGroupCreateMkStream(ctx, cfg.Key, cfg.Group, "0").Err()
+	if err != nil && !isBusyGroup(err) {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	s := &Stream{
+		client:   client,
+		config:   cfg,
+		logger:   logger,
+		handlers: handlers,
+		router:   eventlib.NewRouter(),
+	}
+
+	if cfg.Registerer != nil {
+		s.metrics, err = newStreamMetrics(cfg.Registerer, cfg.Key, cfg.Group)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	s.local, err = newLocal(s.localHandlers())
+	if err != nil {
+		s.metrics.unregister()
+		client.Close()
+		return nil, err
+	}
+
+	logger.Info("Redis stream processor created",
+		slog.String("addr", cfg.Addr),
+		slog.String("stream", cfg.Key),
+		slog.String("group", cfg.Group),
+		slog.String("consumer", cfg.Consumer))
+
+	return s, nil
+}
+
+// isBusyGroup reports the error for a consumer group that already exists
+func isBusyGroup(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP")
+}
+
+// localHandlers wraps the caller's handlers so settled events are
+// acknowledged on the stream
+func (s *Stream) localHandlers() *eventlib.Handlers {
+	h := s.handlers
+	local := &eventlib.Handlers{
+		OnEvent: func(event eventlib.Event) error {
+			err := s.dispatch(event)
+			if err == nil {
+				s.ack(event)
+			}
+			return err
+		},
+		OnExpired: func(event eventlib.Event) {
+			if h.OnExpired != nil {
+				h.OnExpired(event)
+			}
+			s.ack(event)
+		},
+		OnDeadLetter: func(letter eventlib.DeadLetter) {
+			if h.OnDeadLetter != nil {
+				h.OnDeadLetter(letter)
+			}
+			s.ack(letter.Event)
+		},
+		OnStateChange:   h.OnStateChange,
+		OnQueuePressure: h.OnQueuePressure,
+	}
+
+	// Without a filter the callback returns before decoding the event, so
+	// only wrap one that exists
+	if h.OnFilter != nil {
+		local.OnFilter = func(event eventlib.Event) bool {
+			allow := h.OnFilter(event)
+			if !allow {
+				s.ack(event)
+			}
+			return allow
+		}
+	}
+	return local
+}
+
+// dispatch runs the routed handlers, then OnEvent, and joins their
+// errors; the local processor recovers panics
+func (s *Stream) dispatch(event eventlib.Event) error {
+	handlers := s.router.Match(event)
+	if s.handlers.OnEvent != nil {
+		handlers = append(handlers, s.router.Wrap(s.handlers.OnEvent))
+	}
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Stream) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.config.Timeout)
+}
+
+// ack acknowledges the entry event was read from, if any
+func (s *Stream) ack(event eventlib.Event) {
+	id := event.Metadata[StreamIDKey]
+	if id == "" {
+		return
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := s.client.XAck(ctx, s.config.Key, s.config.Group, id).Err(); err != nil {
+		s.logger.Warn("Failed to acknowledge stream entry",
+			slog.String("id", id), slog.Any("error", err))
+		return
+	}
+	s.metrics.acked()
+}
+
+// Push adds event to the stream for whichever instance reads it first
+func (s *Stream) Push(event eventlib.Event) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return eventlib.ErrClosed
+	}
+
+	// A stream ID from an earlier read would ack the wrong entry
+	if _, ok := event.Metadata[StreamIDKey]; ok {
+		event.Metadata = maps.Clone(event.Metadata)
+		delete(event.Metadata, StreamIDKey)
+	}
+
+	event.EnqueuedAt = time.Now()
+	payload, err := json.Marshal(toWire(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	args := &redis.XAddArgs{
+		Stream: s.config.Key,
+		Values: map[string]any{"event": payload},
+	}
+	if s.config.MaxLen > 0 {
+		args.MaxLen = s.config.MaxLen
+		args.Approx = true
+	}
+	if err := s.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to add event to stream: %w", err)
+	}
+	return nil
+}
+
+// Start starts the local processor and begins consuming the stream
+func (s *Stream) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return eventlib.ErrClosed
+	}
+	if err := s.local.Start(); err != nil {
+		return err
+	}
+	if s.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.cancel = cancel
+		s.running.Add(2)
+		go s.consume(ctx)
+		go s.watchLag(ctx)
+	}
+	return nil
+}
+
+// Stop stops consuming and stops the local processor; unread entries stay
+// in the stream for the other instances
+func (s *Stream) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return eventlib.ErrClosed
+	}
+	s.stopConsuming()
+	return s.local.Stop()
+}
+
+// stopConsuming ends the consumer goroutines; s.mu must be held
+func (s *Stream) stopConsuming() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.cancel = nil
+	s.running.Wait()
+}
+
+// consume reads new entries and reclaims stale pending ones until ctx is
+// done, pushing their events into the local processor
+func (s *Stream) consume(ctx context.Context) {
+	defer s.running.Done()
+
+	// Entries this consumer read before a restart come first
+	s.readPending(ctx)
+
+	nextClaim := time.Now().Add(s.config.ClaimIdle / 2)
+	for ctx.Err() == nil {
+		if time.Now().After(nextClaim) {
+			s.claim(ctx)
+			nextClaim = time.Now().Add(s.config.ClaimIdle / 2)
+		}
+
+		streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.config.Group,
+			Consumer: s.config.Consumer,
+			Streams:  []string{s.config.Key, ">"},
+			Count:    int64(s.config.BatchSize),
+			Block:    s.config.Block,
+		}).Result()
+		if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			s.logger.Warn("Failed to read stream", slog.Any("error", err))
+			sleepContext(ctx, s.config.Block)
+			continue
+		}
+
+		for _, stream := range streams {
+			if !s.deliver(stream.Messages) {
+				// The local queue is full; what was not pushed stays
+				// pending and is reclaimed later
+				sleepContext(ctx, s.config.Block)
+			}
+		}
+	}
+}
+
+// readPending redelivers entries already assigned to this consumer, left
+// over from before a restart
+func (s *Stream) readPending(ctx context.Context) {
+	streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.config.Group,
+		Consumer: s.config.Consumer,
+		Streams:  []string{s.config.Key, "0"},
+		Count:    int64(s.config.BatchSize),
+	}).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+			s.logger.Warn("Failed to read pending entries", slog.Any("error", err))
+		}
+		return
+	}
+	for _, stream := range streams {
+		s.claimed(ctx, stream.Messages)
+	}
+}
+
+// claim takes over entries that have been pending longer than ClaimIdle,
+// whichever consumer they were delivered to
+func (s *Stream) claim(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.config.Key,
+			Group:    s.config.Group,
+			Consumer: s.config.Consumer,
+			MinIdle:  s.config.ClaimIdle,
+			Start:    start,
+			Count:    int64(s.config.BatchSize),
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Warn("Failed to claim pending entries", slog.Any("error", err))
+			}
+			return
+		}
+		if len(messages) > 0 {
+			s.metrics.claimed(len(messages))
+			s.logger.Info("Claimed pending stream entries", slog.Int("count", len(messages)))
+			s.claimed(ctx, messages)
+		}
+		if next == "0-0" || len(messages) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// claimed redelivers messages read before, abandoning those that have
+// used up MaxDeliveries
+func (s *Stream) claimed(ctx context.Context, messages []redis.XMessage) {
+	if len(messages) == 0 {
+		return
+	}
+
+	pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.config.Key,
+		Group:  s.config.Group,
+		Start:  messages[0].ID,
+		End:    messages[len(messages)-1].ID,
+		Count:  int64(len(messages)),
+	}).Result()
+	if err != nil {
+		s.logger.Warn("Failed to read delivery counts", slog.Any("error", err))
+	}
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		deliveries[p.ID] = p.RetryCount
+	}
+
+	live := messages[:0]
+	for _, msg := range messages {
+		if deliveries[msg.ID] > int64(s.config.MaxDeliveries) {
+			s.abandon(msg, deliveries[msg.ID])
+			continue
+		}
+		live = append(live, msg)
+	}
+	s.deliver(live)
+}
+
+// abandon acknowledges an entry that keeps coming back without settling
+func (s *Stream) abandon(msg redis.XMessage, deliveries int64) {
+	s.logger.Warn("Abandoning stream entry after too many deliveries",
+		slog.String("id", msg.ID),
+		slog.Int64("deliveries", deliveries))
+
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := s.client.XAck(ctx, s.config.Key, s.config.Group, msg.ID).Err(); err != nil {
+		s.logger.Warn("Failed to acknowledge stream entry",
+			slog.String("id", msg.ID), slog.Any("error", err))
+		return
+	}
+	s.metrics.abandoned()
+}
+
+// deliver pushes messages into the local processor, reporting false if it
+// stopped early because the local queue is full
+func (s *Stream) deliver(messages []redis.XMessage) bool {
+	for _, msg := range messages {
+		event, err := decodeStreamMessage(msg)
+		if err != nil {
+			s.logger.Error("Dropping undecodable stream entry",
+				slog.String("id", msg.ID), slog.Any("error", err))
+			s.ack(eventlib.Event{Metadata: map[string]string{StreamIDKey: msg.ID}})
+			continue
+		}
+
+		s.metrics.consumed()
+		if err := s.local.Push(event); err != nil {
+			if errors.Is(err, eventlib.ErrQueueFull) {
+				return false
+			}
+			s.logger.Warn("Failed to push stream entry locally",
+				slog.String("id", msg.ID), slog.Any("error", err))
+		}
+	}
+	return true
+}
+
+// decodeStreamMessage decodes an entry's event, recording its ID
+func decodeStreamMessage(msg redis.XMessage) (eventlib.Event, error) {
+	payload, ok := msg.Values["event"].(string)
+	if !ok {
+		return eventlib.Event{}, errors.New("entry has no event field")
+	}
+
+	var wire wireEvent
+	if err := json.Unmarshal([]byte(payload), &wire); err != nil {
+		return eventlib.Event{}, err
+	}
+	event := fromWire(wire)
+
+	metadata := make(map[string]string, len(event.Metadata)+1)
+	maps.Copy(metadata, event.Metadata)
+	metadata[StreamIDKey] = msg.ID
+	event.Metadata = metadata
+	return event, nil
+}
+
+// watchLag refreshes the lag and pending gauges until ctx is done
+func (s *Stream) watchLag(ctx context.Context) {
+	defer s.running.Done()
+	if s.metrics == nil {
+		return
+	}
+
+	ticker := time.NewTicker(streamMetricsInterval)
+	defer ticker.Stop()
+	for {
+		s.updateLag(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Stream) updateLag(ctx context.Context) {
+	groups, err := s.client.XInfoGroups(ctx, s.config.Key).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Debug("Failed to read stream groups", slog.Any("error", err))
+		}
+		return
+	}
+	for _, group := range groups {
+		if group.Name == s.config.Group {
+			s.metrics.lag.Set(float64(group.Lag))
+			s.metrics.pending.Set(float64(group.Pending))
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Process processes one event from the local queue
+func (s *Stream) Process() {
+	s.local.Process()
+}
+
+// ProcessAll drains the local queue
+func (s *Stream) ProcessAll() {
+	s.local.ProcessAll()
+}
+
+// QueueSize returns the local queue size; stream lag is exported as a
+// metric
+func (s *Stream) QueueSize() int {
+	return s.local.QueueSize()
+}
+
+// EventsProcessed returns the number of events this instance processed
+func (s *Stream) EventsProcessed() int {
+	return s.local.EventsProcessed()
+}
+
+// State returns the local processor's state
+func (s *Stream) State() string {
+	return s.local.State()
+}
+
+// Handle routes events of type et to handler, alongside Handlers.OnEvent
+func (s *Stream) Handle(et eventlib.EventType, handler eventlib.EventHandler) {
+	s.router.Handle(et, handler)
+}
+
+// HandleSource routes events whose source matches the glob pattern to
+// handler, alongside Handlers.OnEvent
+func (s *Stream) HandleSource(pattern string, handler eventlib.EventHandler) {
+	s.router.HandleSource(pattern, handler)
+}
+
+// Use wraps OnEvent and every routed handler in middleware
+func (s *Stream) Use(middleware ...eventlib.Middleware) {
+	s.router.Use(middleware...)
+}
+
+// DeadLetters returns the local processor's dead letters, if it keeps any
+func (s *Stream) DeadLetters() []eventlib.DeadLetter {
+	if provider, ok := s.local.(eventlib.DeadLetterProvider); ok {
+		return provider.DeadLetters()
+	}
+	return nil
+}
+
+// Stats returns the local processor's stats, if it reports any
+func (s *Stream) Stats() eventlib.Stats {
+	if provider, ok := s.local.(eventlib.StatsProvider); ok {
+		return provider.Stats()
+	}
+	return eventlib.Stats{State: s.State(), QueueSize: s.QueueSize()}
+}
+
+// Close stops consuming and closes the local processor, then disconnects.
+// Events still in the local queue were not acknowledged, so another
+// instance claims them after ClaimIdle.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.stopConsuming()
+	s.mu.Unlock()
+
+	// Handlers run while closing can still acknowledge entries
+	err := s.local.Close()
+	s.metrics.unregister()
+
+	s.logger.Info("Redis stream processor closed",
+		slog.String("stream", s.config.Key))
+
+	if closeErr := s.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// streamMetrics exports a Stream's consumption to Prometheus. A nil
+// *streamMetrics records nothing.
+type streamMetrics struct {
+	reg prometheus.Registerer
+
+	lag, pending                 prometheus.Gauge
+	consumedTotal, ackedTotal    prometheus.Counter
+	claimedTotal, abandonedTotal prometheus.Counter
+}
+
+func newStreamMetrics(reg prometheus.Registerer, key, group string) (*streamMetrics, error) {
+	labels := prometheus.Labels{"stream": key, "group": group}
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help, ConstLabels: labels})
+	}
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: labels})
+	}
+
+	m := &streamMetrics{
+		reg:            reg,
+		lag:            gauge("eventlibgo_redis_stream_lag", "Entries in the stream not yet delivered to the consumer group"),
+		pending:        gauge("eventlibgo_redis_stream_pending", "Entries delivered to the consumer group but not acknowledged"),
+		consumedTotal:  counter("eventlibgo_redis_stream_consumed_total", "Total number of stream entries pushed into the local processor"),
+		ackedTotal:     counter("eventlibgo_redis_stream_acked_total", "Total number of stream entries acknowledged after settling locally"),
+		claimedTotal:   counter("eventlibgo_redis_stream_claimed_total", "Total number of idle pending entries claimed from other consumers"),
+		abandonedTotal: counter("eventlibgo_redis_stream_abandoned_total", "Total number of stream entries acknowledged unsettled after too many deliveries"),
+	}
+	for i, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			for _, registered := range m.collectors()[:i] {
+				reg.Unregister(registered)
+			}
+			return nil, fmt.Errorf("failed to register stream metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *streamMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.lag, m.pending, m.consumedTotal, m.ackedTotal, m.claimedTotal, m.abandonedTotal}
+}
+
+func (m *streamMetrics) unregister() {
+	if m == nil {
+		return
+	}
+	for _, c := range m.collectors() {
+		m.reg.Unregister(c)
+	}
+}
+
+func (m *streamMetrics) consumed() {
+	if m != nil {
+		m.consumedTotal.Inc()
+	}
+}
+
+func (m *streamMetrics) acked() {
+	if m != nil {
+		m.ackedTotal.Inc()
+	}
+}
+
+func (m *streamMetrics) claimed(n int) {
+	if m != nil {
+		m.claimedTotal.Add(float64(n))
+	}
+}
+
+func (m *streamMetrics) abandoned() {
+	if m != nil {
+		m.abandonedTotal.Inc()
+	}
+}
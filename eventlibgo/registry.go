@@ -0,0 +1,163 @@
+package eventlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// EventTypeCustomBase is the first value handed out to registered types,
+// matching EVENT_TYPE_CUSTOM_BASE in the C library
+const EventTypeCustomBase EventType = 1024
+
+// builtinEventTypes are preloaded into every registry
+var builtinEventTypes = []EventType{
+	EventTypeData,
+	EventTypeConnect,
+	EventTypeDisconnect,
+	EventTypeError,
+}
+
+// EventTypeRegistry maps names to application-defined event types. The C
+// library carries any type value through unchanged, so custom types need
+// only a name and a number.
+type EventTypeRegistry struct {
+	mu     sync.RWMutex
+	byName map[string]EventType
+	names  map[EventType]string
+	next   EventType
+}
+
+// NewEventTypeRegistry returns a registry holding only the built-in types
+func NewEventTypeRegistry() *EventTypeRegistry {
+	r := &EventTypeRegistry{
+		byName: make(map[string]EventType),
+		names:  make(map[EventType]string),
+		next:   EventTypeCustomBase,
+	}
+	for _, et := range builtinEventTypes {
+		name := et.builtinName()
+		r.byName[name] = et
+		r.names[et] = name
+	}
+	return r
+}
+
+// DefaultEventTypes is the registry behind RegisterEventType, EventType
+// names and JSON encoding
+var DefaultEventTypes = NewEventTypeRegistry()
+
+// Register returns the type for name, assigning the next free value if name
+// is new. Registering a name again returns the same type.
+func (r *EventTypeRegistry) Register(name string) (EventType, error) {
+	if name == "" {
+		return 0, fmt.Errorf("event type name cannot be empty")
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return 0, fmt.Errorf("event type name %q cannot be a number", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if et, ok := r.byName[name]; ok {
+		return et, nil
+	}
+
+	et := r.next
+	r.next++
+	r.byName[name] = et
+	r.names[et] = name
+	return et, nil
+}
+
+// Lookup returns the type registered under name
+func (r *EventTypeRegistry) Lookup(name string) (EventType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	et, ok := r.byName[name]
+	return et, ok
+}
+
+// Name returns the name et was registered under
+func (r *EventTypeRegistry) Name(et EventType) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name, ok := r.names[et]
+	return name, ok
+}
+
+// Parse resolves a registered name or a decimal type value
+func (r *EventTypeRegistry) Parse(s string) (EventType, error) {
+	if et, ok := r.Lookup(s); ok {
+		return et, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return EventType(n), nil
+	}
+	return 0, fmt.Errorf("unknown event type %q", s)
+}
+
+// Names returns every registered name, built-ins included
+func (r *EventTypeRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.byName))
+	for et := range r.next {
+		if name, ok := r.names[et]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// RegisterEventType registers name in DefaultEventTypes. It panics on an
+// invalid name, so it suits package-level variables:
+//
+//	var EventTypeOrderPlaced = eventlib.RegisterEventType("order.placed")
+func RegisterEventType(name string) EventType {
+	et, err := DefaultEventTypes.Register(name)
+	if err != nil {
+		panic(err)
+	}
+	return et
+}
+
+// ParseEventType resolves a name registered in DefaultEventTypes or a
+// decimal type value
+func ParseEventType(s string) (EventType, error) {
+	return DefaultEventTypes.Parse(s)
+}
+
+// MarshalJSON encodes a named type as its name, and anything else as a
+// number
+func (et EventType) MarshalJSON() ([]byte, error) {
+	if name, ok := DefaultEventTypes.Name(et); ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(int(et))
+}
+
+// UnmarshalJSON accepts a registered name or a number
+func (et *EventType) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*et = EventType(n)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("event type must be a name or a number")
+	}
+	parsed, ok := DefaultEventTypes.Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown event type %q", name)
+	}
+	*et = parsed
+	return nil
+}
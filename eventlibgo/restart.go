@@ -0,0 +1,120 @@
+package eventlib
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// EngineConfig holds the settings the C processor is created with.
+// MaxQueueSize and EnableLogging can also be changed in place, with
+// SetMaxQueueSize and SetLogging; QueueMode only by Restart.
+type EngineConfig struct {
+	MaxQueueSize  int
+	EnableLogging bool
+	QueueMode     QueueMode
+}
+
+// engineConfig returns the part of c the engine is created with
+func (c *Config) engineConfig() EngineConfig {
+	return EngineConfig{
+		MaxQueueSize:  c.MaxQueueSize,
+		EnableLogging: c.EnableLogging,
+		QueueMode:     c.QueueMode,
+	}
+}
+
+// EngineConfig returns the settings of the processor's current C
+// processor, or the zero EngineConfig once it is closed
+func (ep *EventProcessor) EngineConfig() EngineConfig {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if ep.closed {
+		return EngineConfig{}
+	}
+	return EngineConfig{
+		MaxQueueSize:  ep.engine.maxQueueSize(),
+		EnableLogging: ep.logging.Load(),
+		QueueMode:     ep.engine.mode,
+	}
+}
+
+// requeued is an event drained from an engine being replaced
+type requeued struct {
+	event Event
+	id    uint64
+}
+
+// Restart replaces the C processor with a new one created with config,
+// and returns how many queued events were moved across. The queued events
+// are drained into Go and pushed to the new queue in processing order,
+// keeping their journal entries, so nothing is dropped or replayed. They
+// go through OnFilter again.
+//
+// A running processor is started again; an idle or stopped one is left
+// idle. Processed and expired counts carry over, but the C library's own
+// counters in LibraryStats start again from zero.
+//
+// Restart fails, changing nothing, if more events are queued than
+// config.MaxQueueSize allows. Pushes and processing wait while it runs,
+// so it must not be called from a handler, and the OnFilter,
+// OnQueuePressure and OnStateChange calls it makes must not call back
+// into the processor.
+func (ep *EventProcessor) Restart(config EngineConfig) (int, error) {
+	if config.QueueMode != QueueFIFO && config.QueueMode != QueuePriority {
+		return 0, fmt.Errorf("%w: unknown QueueMode %d", ErrInvalidConfig, config.QueueMode)
+	}
+	if config.MaxQueueSize < 0 {
+		return 0, fmt.Errorf("%w: negative MaxQueueSize %d", ErrInvalidConfig, config.MaxQueueSize)
+	}
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ep.closed {
+		return 0, ErrClosed
+	}
+
+	old := ep.engine
+	if size := old.queueSize(); config.MaxQueueSize > 0 && size > config.MaxQueueSize {
+		return 0, fmt.Errorf("%w: %d events are queued, more than MaxQueueSize %d",
+			ErrQueueFull, size, config.MaxQueueSize)
+	}
+
+	next, err := newEngine(ep, config)
+	if err != nil {
+		return 0, err
+	}
+
+	var drained []requeued
+	old.purge(func(Event) bool { return true }, func(event Event, id uint64) {
+		drained = append(drained, requeued{event: event, id: id})
+	})
+	running := old.state() == "RUNNING"
+	ep.stats.restoredProcessed.Add(old.eventsProcessed())
+	ep.stats.restoredExpired.Add(old.eventsExpired())
+
+	ep.cleanup.Stop()
+	old.destroy()
+	ep.engine = next
+	ep.watchForLeak()
+	ep.setWatermarks(config.MaxQueueSize)
+	ep.logging.Store(config.EnableLogging)
+
+	for _, item := range drained {
+		if err := next.submit(item.event, item.id, false); err != nil {
+			ep.deadLetter(item.event, fmt.Errorf("requeue after restart: %w", err), item.event.Attempt)
+			ep.ack(item.id)
+		}
+	}
+	if running {
+		next.start()
+	}
+
+	ep.logger.Info("Event processor restarted",
+		slog.String("name", ep.config.Name),
+		slog.Int("maxQueueSize", config.MaxQueueSize),
+		slog.Bool("logging", config.EnableLogging),
+		slog.Int("requeued", len(drained)))
+	return len(drained), nil
+}
@@ -0,0 +1,326 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Retry policy defaults
+const (
+	DefaultRetryAttempts       = 3
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff     = 30 * time.Second
+	DefaultRetryMultiplier     = 2.0
+
+	// DefaultDeadLetterSize is how many dead letters a processor keeps
+	DefaultDeadLetterSize = 1000
+)
+
+// RetryPolicy controls how events whose handlers return an error are
+// retried. Zero fields take their defaults.
+type RetryPolicy struct {
+	// MaxAttempts is how many times an event is handled before it is
+	// dead-lettered, counting the first
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+
+	// Multiplier grows the wait after each retry
+	Multiplier float64
+
+	// Jitter randomizes each wait by up to this fraction of it, from 0
+	// (none) to 1
+	Jitter float64
+}
+
+// validate reports a policy that cannot be used
+func (p RetryPolicy) validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("%w: negative MaxAttempts %d", ErrInvalidConfig, p.MaxAttempts)
+	case p.InitialBackoff < 0 || p.MaxBackoff < 0:
+		return fmt.Errorf("%w: negative retry backoff", ErrInvalidConfig)
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return fmt.Errorf("%w: retry multiplier %g is below 1", ErrInvalidConfig, p.Multiplier)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("%w: retry jitter %g is outside [0, 1]", ErrInvalidConfig, p.Jitter)
+	}
+	return nil
+}
+
+// Attempts returns MaxAttempts, or its default
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts <= 0 {
+		return DefaultRetryAttempts
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns the wait before retry n, counting from 1
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = DefaultRetryInitialBackoff
+	}
+	limit := p.MaxBackoff
+	if limit <= 0 {
+		limit = DefaultRetryMaxBackoff
+	}
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = DefaultRetryMultiplier
+	}
+
+	backoff := float64(initial) * math.Pow(multiplier, float64(max(n-1, 0)))
+	backoff = min(backoff, float64(limit))
+	if p.Jitter > 0 {
+		backoff -= backoff * p.Jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// DeadLetter is an event whose handlers failed on every attempt
+type DeadLetter struct {
+	Event    Event
+	Err      error
+	Attempts int
+	Time     time.Time
+}
+
+// DeadLetterProvider is implemented by processors that keep dead letters
+type DeadLetterProvider interface {
+	DeadLetters() []DeadLetter
+}
+
+var _ DeadLetterProvider = (*EventProcessor)(nil)
+
+// deadLetterLog keeps the most recent dead letters
+type deadLetterLog struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+	next    int
+	size    int
+}
+
+func newDeadLetterLog(size int) *deadLetterLog {
+	if size <= 0 {
+		size = DefaultDeadLetterSize
+	}
+	return &deadLetterLog{size: size}
+}
+
+func (l *deadLetterLog) add(letter DeadLetter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.letters) < l.size {
+		l.letters = append(l.letters, letter)
+		return
+	}
+	l.letters[l.next] = letter
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns the dead letters, oldest first
+func (l *deadLetterLog) list() []DeadLetter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(l.letters))
+	letters = append(letters, l.letters[l.next:]...)
+	return append(letters, l.letters[:l.next]...)
+}
+
+// retryItem is a failed event waiting out its backoff. id is the journal
+// sequence of the failed delivery, acked once the retry is requeued.
+type retryItem struct {
+	event Event
+	id    uint64
+	err   error
+	timer *time.Timer
+}
+
+// retryQueue holds events waiting to be requeued
+type retryQueue struct {
+	mu      sync.Mutex
+	pending map[*retryItem]struct{}
+	closed  bool
+}
+
+func newRetryQueue() *retryQueue {
+	return &retryQueue{pending: make(map[*retryItem]struct{})}
+}
+
+// schedule calls fire with item after delay, unless the queue is closed
+func (q *retryQueue) schedule(item *retryItem, delay time.Duration, fire func(*retryItem)) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	q.pending[item] = struct{}{}
+	item.timer = time.AfterFunc(delay, func() {
+		if q.take(item) {
+			fire(item)
+		}
+	})
+	return true
+}
+
+// take claims item for firing, reporting false if someone else has
+func (q *retryQueue) take(item *retryItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[item]; !ok {
+		return false
+	}
+	delete(q.pending, item)
+	return true
+}
+
+// takeAll claims every pending item, cancelling their timers
+func (q *retryQueue) takeAll() []*retryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]*retryItem, 0, len(q.pending))
+	for item := range q.pending {
+		item.timer.Stop()
+		items = append(items, item)
+	}
+	clear(q.pending)
+	return items
+}
+
+// close stops scheduling and returns the items that never fired
+func (q *retryQueue) close() []*retryItem {
+	items := q.takeAll()
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	return items
+}
+
+// events returns the events of the pending items
+func (q *retryQueue) events() []Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events := make([]Event, 0, len(q.pending))
+	for item := range q.pending {
+		events = append(events, item.event)
+	}
+	return events
+}
+
+func (q *retryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// handleFailure retries an event whose handlers failed, or dead-letters it
+// once its attempts are used up. It reports whether it kept the journal
+// entry id for the retry.
+func (ep *EventProcessor) handleFailure(event Event, id uint64, err error) bool {
+	attempts := event.Attempt + 1
+	policy := ep.config.Retry
+	if ep.retries == nil || attempts >= policy.Attempts() {
+		ep.deadLetter(event, err, attempts)
+		return false
+	}
+
+	event.Attempt = attempts
+	item := &retryItem{event: event, id: id, err: err}
+	if !ep.retries.schedule(item, policy.Backoff(attempts), ep.requeue) {
+		ep.deadLetter(event, errors.Join(err, ErrClosed), attempts)
+		return false
+	}
+
+	ep.stats.retried.Add(1)
+	ep.logger.Debug("Retrying event",
+		slog.String("event_type", event.Type.String()),
+		slog.Int("attempt", attempts),
+		slog.Any("error", err))
+	return true
+}
+
+// requeue pushes a retry back onto the queue. Retries are accepted while
+// draining, since Drain waits for them.
+func (ep *EventProcessor) requeue(item *retryItem) {
+	event := item.event
+	event.EnqueuedAt = time.Now()
+	event.ProcessedAt = time.Time{}
+
+	ep.mu.RLock()
+	err := ErrClosed
+	if !ep.closed {
+		var id uint64
+		if id, err = ep.journal(event); err == nil {
+			if err = ep.push(event, id); err != nil {
+				ep.ack(id)
+			}
+		}
+	}
+	ep.mu.RUnlock()
+
+	if errors.Is(err, ErrClosed) && ep.wal != nil {
+		return // The failed delivery is replayed on the next start
+	}
+	if err != nil {
+		ep.deadLetter(item.event, errors.Join(item.err, fmt.Errorf("failed to requeue: %w", err)), item.event.Attempt)
+	}
+	ep.ack(item.id)
+}
+
+// deadLetter records an event that will not be retried again
+func (ep *EventProcessor) deadLetter(event Event, err error, attempts int) {
+	letter := DeadLetter{
+		Event:    event,
+		Err:      err,
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+	ep.deadLetters.add(letter)
+	ep.stats.deadLettered.Add(1)
+	ep.waiters.settle(event.ID, Outcome{Status: OutcomeFailed, Err: err, Attempts: attempts})
+
+	ep.logger.Warn("Event dead-lettered",
+		slog.String("event_type", event.Type.String()),
+		slog.String("source", event.Source),
+		slog.String("correlation_id", event.CorrelationID),
+		slog.Int("attempts", attempts),
+		slog.Any("error", err))
+
+	handlers := ep.acquireHandlers()
+	defer handlers.release()
+	if handlers.OnDeadLetter == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ep.logger.Error("Panic in dead letter handler",
+				slog.Any("panic", r))
+			ep.reportPanic(HandlerDeadLetter, letter.Event, r)
+		}
+	}()
+	handlers.OnDeadLetter(letter)
+}
+
+// DeadLetters returns the most recent events whose handlers failed on
+// every attempt, oldest first
+func (ep *EventProcessor) DeadLetters() []DeadLetter {
+	return ep.deadLetters.list()
+}
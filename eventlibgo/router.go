@@ -0,0 +1,148 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// Router sends events to the handlers registered for their type or source.
+// Every matching handler runs, in registration order, wrapped in the
+// router's middleware.
+type Router struct {
+	mu         sync.Mutex // Serializes writers
+	routes     atomic.Pointer[[]route]
+	middleware atomic.Pointer[[]Middleware]
+}
+
+// Middleware wraps an EventHandler to add behaviour around it, such as
+// logging, metrics or retries
+type Middleware func(next EventHandler) EventHandler
+
+// Chain wraps handler in middleware; the first middleware is outermost
+func Chain(handler EventHandler, middleware ...Middleware) EventHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+type route struct {
+	match   func(event Event) bool
+	handler EventHandler
+}
+
+// NewRouter returns a router with no routes
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle routes events of type et to handler
+func (r *Router) Handle(et EventType, handler EventHandler) {
+	r.add(func(event Event) bool { return event.Type == et }, handler)
+}
+
+// HandleSource routes events whose source matches pattern to handler.
+// Patterns use path.Match syntax, so "sensor-*" matches "sensor-1". It
+// panics on a malformed pattern.
+func (r *Router) HandleSource(pattern string, handler EventHandler) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("eventlib: invalid source pattern %q: %v", pattern, err))
+	}
+	r.add(func(event Event) bool {
+		ok, _ := path.Match(pattern, event.Source)
+		return ok
+	}, handler)
+}
+
+// HandleFunc routes events for which match returns true to handler
+func (r *Router) HandleFunc(match func(event Event) bool, handler EventHandler) {
+	r.add(match, handler)
+}
+
+// add appends a route without disturbing dispatches in flight
+func (r *Router) add(match func(event Event) bool, handler EventHandler) {
+	if handler == nil {
+		panic("eventlib: nil route handler")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var routes []route
+	if current := r.routes.Load(); current != nil {
+		routes = append(routes, *current...)
+	}
+	routes = append(routes, route{match: match, handler: handler})
+	r.routes.Store(&routes)
+}
+
+// Use adds middleware around every handler the router dispatches to,
+// including ones registered earlier. Earlier middleware is outermost.
+func (r *Router) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var all []Middleware
+	if current := r.middleware.Load(); current != nil {
+		all = append(all, *current...)
+	}
+	all = append(all, middleware...)
+	r.middleware.Store(&all)
+}
+
+// Wrap applies the router's middleware to handler
+func (r *Router) Wrap(handler EventHandler) EventHandler {
+	middleware := r.middleware.Load()
+	if middleware == nil {
+		return handler
+	}
+	return Chain(handler, *middleware...)
+}
+
+// Match returns the handlers for event, in registration order and wrapped
+// in middleware
+func (r *Router) Match(event Event) []EventHandler {
+	routes := r.routes.Load()
+	if routes == nil {
+		return nil
+	}
+
+	var handlers []EventHandler
+	for _, rt := range *routes {
+		if rt.match(event) {
+			handlers = append(handlers, r.Wrap(rt.handler))
+		}
+	}
+	return handlers
+}
+
+// Dispatch calls every handler matching event and joins their errors. It
+// can be used directly as Handlers.OnEvent.
+func (r *Router) Dispatch(event Event) error {
+	var errs []error
+	for _, handler := range r.Match(event) {
+		if err := handler(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handle routes events of type et to handler, alongside Handlers.OnEvent
+func (ep *EventProcessor) Handle(et EventType, handler EventHandler) {
+	ep.router.Handle(et, handler)
+}
+
+// HandleSource routes events whose source matches the glob pattern to
+// handler, alongside Handlers.OnEvent
+func (ep *EventProcessor) HandleSource(pattern string, handler EventHandler) {
+	ep.router.HandleSource(pattern, handler)
+}
+
+// Use wraps OnEvent and every routed handler in middleware
+func (ep *EventProcessor) Use(middleware ...Middleware) {
+	ep.router.Use(middleware...)
+}
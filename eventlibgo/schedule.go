@@ -0,0 +1,303 @@
+package eventlib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrAlreadyScheduled is returned when an event is scheduled with the ID
+// of another event still waiting for its DeliverAt
+var ErrAlreadyScheduled = errors.New("an event with this ID is already scheduled")
+
+const (
+	// scheduleTick is the timing wheel's resolution; events are delivered
+	// up to one tick after their DeliverAt
+	scheduleTick = 10 * time.Millisecond
+
+	// scheduleSlots is the number of ticks in one turn of the wheel.
+	// Events further out than a turn wait in their slot for later turns.
+	scheduleSlots = 512
+)
+
+// Scheduler is implemented by processors that can hold events until their
+// DeliverAt
+type Scheduler interface {
+	// Scheduled returns the events waiting to be delivered, soonest first
+	Scheduled() []Event
+
+	// CancelScheduled drops the scheduled event with id, reporting whether
+	// there was one
+	CancelScheduled(id string) bool
+}
+
+var _ Scheduler = (*EventProcessor)(nil)
+
+// isScheduled reports whether event is to be held rather than queued now
+func isScheduled(event Event) bool {
+	return !event.DeliverAt.IsZero() && event.DeliverAt.After(time.Now())
+}
+
+// scheduledEvent is an event waiting in a wheel slot. due is the absolute
+// tick it fires on, so the slot holds it across turns until then.
+type scheduledEvent struct {
+	event Event
+	due   int64
+}
+
+// timingWheel holds events until their DeliverAt and then hands them to
+// fire. Its goroutine only runs while something is scheduled.
+type timingWheel struct {
+	mu      sync.Mutex
+	slots   [scheduleSlots]map[*scheduledEvent]struct{}
+	byID    map[string]*scheduledEvent
+	n       int
+	epoch   time.Time
+	tick    int64 // Last tick handled
+	running bool
+	closed  bool
+	wake    chan struct{}
+
+	fire func(Event)
+}
+
+func newTimingWheel(fire func(Event)) *timingWheel {
+	w := &timingWheel{
+		byID:  make(map[string]*scheduledEvent),
+		epoch: time.Now(),
+		wake:  make(chan struct{}),
+		fire:  fire,
+	}
+	for i := range w.slots {
+		w.slots[i] = make(map[*scheduledEvent]struct{})
+	}
+	return w
+}
+
+// tickAt returns the first tick at or after t
+func (w *timingWheel) tickAt(t time.Time) int64 {
+	d := t.Sub(w.epoch)
+	return int64((d + scheduleTick - 1) / scheduleTick)
+}
+
+// now returns the last tick that has fully begun
+func (w *timingWheel) now() int64 {
+	return int64(time.Since(w.epoch) / scheduleTick)
+}
+
+// add schedules event for its DeliverAt
+func (w *timingWheel) add(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if event.ID != "" {
+		if _, ok := w.byID[event.ID]; ok {
+			return ErrAlreadyScheduled
+		}
+	}
+
+	if !w.running {
+		// Nothing ticked while idle, so catch the wheel up to now
+		w.tick = w.now()
+		w.running = true
+		go w.run()
+	}
+
+	item := &scheduledEvent{event: event, due: max(w.tickAt(event.DeliverAt), w.tick+1)}
+	w.slots[item.due%scheduleSlots][item] = struct{}{}
+	if event.ID != "" {
+		w.byID[event.ID] = item
+	}
+	w.n++
+	return nil
+}
+
+// run advances the wheel every tick, delivering what falls due, until the
+// wheel is empty or closed
+func (w *timingWheel) run() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+			return
+		}
+
+		due, idle := w.advance(w.now())
+		for _, event := range due {
+			w.fire(event)
+		}
+		if idle {
+			return
+		}
+	}
+}
+
+// advance handles every tick up to now and returns the events due, in
+// DeliverAt order. idle reports that the wheel is empty and run has
+// stopped.
+func (w *timingWheel) advance(now int64) (due []Event, idle bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil, true
+	}
+
+	// After a long stall, a full turn covers every slot
+	from := max(w.tick+1, now-scheduleSlots+1)
+	for t := from; t <= now; t++ {
+		slot := w.slots[t%scheduleSlots]
+		for item := range slot {
+			if item.due <= now {
+				delete(slot, item)
+				w.forget(item)
+				due = append(due, item.event)
+			}
+		}
+	}
+	w.tick = now
+
+	slices.SortStableFunc(due, func(a, b Event) int {
+		return a.DeliverAt.Compare(b.DeliverAt)
+	})
+	if w.n == 0 {
+		w.running = false
+		return due, true
+	}
+	return due, false
+}
+
+// forget drops item from the ID index and the count
+func (w *timingWheel) forget(item *scheduledEvent) {
+	if id := item.event.ID; id != "" && w.byID[id] == item {
+		delete(w.byID, id)
+	}
+	w.n--
+}
+
+// cancel removes the event scheduled with id
+func (w *timingWheel) cancel(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	item, ok := w.byID[id]
+	if !ok {
+		return false
+	}
+	delete(w.slots[item.due%scheduleSlots], item)
+	w.forget(item)
+	return true
+}
+
+// list returns the scheduled events, soonest first
+func (w *timingWheel) list() []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := make([]Event, 0, w.n)
+	for _, slot := range w.slots {
+		for item := range slot {
+			events = append(events, item.event)
+		}
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.DeliverAt.Compare(b.DeliverAt)
+	})
+	return events
+}
+
+func (w *timingWheel) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.n
+}
+
+// close stops the wheel and returns the events that never fell due
+func (w *timingWheel) close() []Event {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	running := w.running
+	w.running = false
+	w.mu.Unlock()
+
+	if running {
+		// run may be delivering; it returns at its next select
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	events := w.list()
+	w.mu.Lock()
+	for i := range w.slots {
+		clear(w.slots[i])
+	}
+	clear(w.byID)
+	w.n = 0
+	w.mu.Unlock()
+	return events
+}
+
+// schedule holds event until its DeliverAt. Transformers, dedup and the
+// journal see it then, when it is pushed like any other event.
+func (ep *EventProcessor) schedule(event Event) error {
+	// Refuse an oversized event now rather than dead-letter it when due
+	if err := ep.checkSize(event); err != nil {
+		ep.stats.dropped.Add(1)
+		return err
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	if err := ep.accepting(); err != nil {
+		return err
+	}
+	if err := ep.scheduled.add(event); err != nil {
+		return err
+	}
+
+	ep.logger.Debug("Event scheduled",
+		slog.String("event_type", event.Type.String()),
+		slog.String("event_id", event.ID),
+		slog.Time("deliver_at", event.DeliverAt))
+	return nil
+}
+
+// deliverScheduled pushes an event whose DeliverAt has come, dead-lettering
+// it if the push fails
+func (ep *EventProcessor) deliverScheduled(event Event) {
+	if err := ep.Push(event); err != nil {
+		ep.deadLetter(event, fmt.Errorf("failed to deliver scheduled event: %w", err), 0)
+	}
+}
+
+// Scheduled returns the events pushed with a future DeliverAt that have
+// not been delivered yet, soonest first
+func (ep *EventProcessor) Scheduled() []Event {
+	return ep.scheduled.list()
+}
+
+// CancelScheduled drops the scheduled event with id before it is
+// delivered, reporting whether there was one
+func (ep *EventProcessor) CancelScheduled(id string) bool {
+	if !ep.scheduled.cancel(id) {
+		return false
+	}
+	ep.waiters.settle(id, Outcome{Status: OutcomeCancelled})
+	return true
+}
@@ -0,0 +1,309 @@
+package eventlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// ErrBadSnapshot is returned by Restore for input that is not a snapshot,
+// or one that is truncated or corrupt
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+// snapshotMagic starts every snapshot; its last byte is the format version
+const snapshotMagic = "EVSNAP\x00\x01"
+
+// Snapshot entry kinds, by where the event was waiting
+const (
+	snapshotQueued byte = iota + 1
+	snapshotRetry
+	snapshotScheduled
+)
+
+// Snapshotter is implemented by processors that can serialize their
+// pending events for Restore
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+}
+
+var _ Snapshotter = (*EventProcessor)(nil)
+
+// snapshotEvent is one pending event and where it was waiting
+type snapshotEvent struct {
+	kind  byte
+	event Event
+}
+
+// snapshotCounters are the Stats counters carried across a restore
+type snapshotCounters struct {
+	pushed, processed, dropped, filtered       uint64
+	duplicates, expired, retried, deadLettered uint64
+	byType                                     map[string]uint64
+}
+
+// Snapshot writes the events waiting in the processor, and its counters,
+// for Restore. Queued events, retries backing off and scheduled events are
+// all included; events buffered by AsyncPush are flushed to the queue
+// first. Processing is not stopped, so call Stop first for a copy that
+// nothing is processed behind.
+func (ep *EventProcessor) Snapshot(w io.Writer) error {
+	ep.Flush()
+
+	ep.mu.RLock()
+	if ep.closed {
+		ep.mu.RUnlock()
+		return ErrClosed
+	}
+
+	// Copy the queue before the stats, so an event processed in between
+	// is at worst counted and restored, not lost
+	queued, err := ep.engine.snapshot()
+	ep.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var events []snapshotEvent
+	for _, event := range queued {
+		events = append(events, snapshotEvent{kind: snapshotQueued, event: event})
+	}
+	if ep.retries != nil {
+		for _, event := range ep.retries.events() {
+			events = append(events, snapshotEvent{kind: snapshotRetry, event: event})
+		}
+	}
+	for _, event := range ep.scheduled.list() {
+		events = append(events, snapshotEvent{kind: snapshotScheduled, event: event})
+	}
+
+	stats := ep.Stats()
+	counters := snapshotCounters{
+		pushed:       stats.Pushed,
+		processed:    stats.Processed,
+		dropped:      stats.Dropped,
+		filtered:     stats.Filtered,
+		duplicates:   stats.Duplicates,
+		expired:      stats.Expired,
+		retried:      stats.Retried,
+		deadLettered: stats.DeadLettered,
+		byType:       stats.ProcessedByType,
+	}
+
+	if _, err := w.Write(encodeSnapshot(time.Now(), counters, events)); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	ep.logger.Info("Snapshot taken",
+		slog.String("name", ep.config.Name),
+		slog.Int("queued", len(queued)),
+		slog.Int("events", len(events)))
+	return nil
+}
+
+// Restore creates a processor like New and loads a snapshot written by
+// Snapshot into it: queued events and retries are queued again in their
+// order, scheduled events wait for their DeliverAt, and the Stats counters
+// carry on from the snapshot. Restored events skip Transformers and the
+// dedup window, which they already went through. The processor is not
+// started.
+func Restore(r io.Reader, config *Config, handlers *Handlers) (*EventProcessor, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	taken, counters, events, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := New(config, handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	ep.stats.restore(counters)
+
+	for i, entry := range events {
+		if err := ep.restoreEvent(entry); err != nil {
+			ep.Close()
+			return nil, fmt.Errorf("failed to restore event %d of %d: %w", i+1, len(events), err)
+		}
+	}
+
+	ep.logger.Info("Processor restored from snapshot",
+		slog.String("name", config.Name),
+		slog.Time("taken", taken),
+		slog.Int("events", len(events)))
+	return ep, nil
+}
+
+// restoreEvent queues or schedules one snapshotted event
+func (ep *EventProcessor) restoreEvent(entry snapshotEvent) error {
+	event := entry.event
+	if entry.kind == snapshotScheduled && isScheduled(event) {
+		return ep.scheduled.add(event)
+	}
+
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	id, err := ep.journal(event)
+	if err != nil {
+		return err
+	}
+	if err := ep.push(event, id); err != nil {
+		ep.ack(id)
+		return err
+	}
+	return nil
+}
+
+// encodeSnapshot lays a snapshot out as
+//
+//	magic | taken at ns i64 | pushed, processed, dropped, filtered,
+//	duplicates, expired, retried, dead-lettered u64 |
+//	type count u32, then per type: name len u32 + bytes | count u64 |
+//	event count u32, then per event: kind u8 | record len u32 + WAL record |
+//	CRC-32 of everything before it u32
+//
+// Events are WAL records, which carry every field but DeliverAt; a
+// scheduled event's record is preceded by its DeliverAt in ns as i64.
+func encodeSnapshot(taken time.Time, counters snapshotCounters, events []snapshotEvent) []byte {
+	body := []byte(snapshotMagic)
+	body = binary.LittleEndian.AppendUint64(body, uint64(taken.UnixNano()))
+	for _, n := range []uint64{
+		counters.pushed, counters.processed, counters.dropped, counters.filtered,
+		counters.duplicates, counters.expired, counters.retried, counters.deadLettered,
+	} {
+		body = binary.LittleEndian.AppendUint64(body, n)
+	}
+
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(counters.byType)))
+	for name, n := range counters.byType {
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(name)))
+		body = append(body, name...)
+		body = binary.LittleEndian.AppendUint64(body, n)
+	}
+
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(events)))
+	for _, entry := range events {
+		body = append(body, entry.kind)
+		if entry.kind == snapshotScheduled {
+			body = binary.LittleEndian.AppendUint64(body, uint64(entry.event.DeliverAt.UnixNano()))
+		}
+		record := encodeWALRecord(walPush, 0, &entry.event)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(record)))
+		body = append(body, record...)
+	}
+
+	return binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+}
+
+func decodeSnapshot(data []byte) (taken time.Time, counters snapshotCounters, events []snapshotEvent, err error) {
+	bad := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrBadSnapshot, fmt.Sprintf(format, args...))
+	}
+
+	if !bytes.HasPrefix(data, []byte(snapshotMagic[:len(snapshotMagic)-1])) {
+		return taken, counters, nil, bad("missing header")
+	}
+	if len(data) < len(snapshotMagic)+4 {
+		return taken, counters, nil, bad("truncated")
+	}
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return taken, counters, nil, bad("unsupported version %d", data[len(snapshotMagic)-1])
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return taken, counters, nil, bad("checksum mismatch")
+	}
+	rest := body[len(snapshotMagic):]
+
+	short := false
+	u8 := func() byte {
+		if len(rest) < 1 {
+			short = true
+			return 0
+		}
+		b := rest[0]
+		rest = rest[1:]
+		return b
+	}
+	u32 := func() uint32 {
+		if len(rest) < 4 {
+			short = true
+			return 0
+		}
+		n := binary.LittleEndian.Uint32(rest)
+		rest = rest[4:]
+		return n
+	}
+	u64 := func() uint64 {
+		if len(rest) < 8 {
+			short = true
+			return 0
+		}
+		n := binary.LittleEndian.Uint64(rest)
+		rest = rest[8:]
+		return n
+	}
+	field := func(n uint32) []byte {
+		if uint64(len(rest)) < uint64(n) {
+			short = true
+			return nil
+		}
+		value := rest[:n]
+		rest = rest[n:]
+		return value
+	}
+
+	taken = time.Unix(0, int64(u64()))
+	for _, n := range []*uint64{
+		&counters.pushed, &counters.processed, &counters.dropped, &counters.filtered,
+		&counters.duplicates, &counters.expired, &counters.retried, &counters.deadLettered,
+	} {
+		*n = u64()
+	}
+
+	types := u32()
+	counters.byType = make(map[string]uint64)
+	for i := uint32(0); i < types && !short; i++ {
+		name := string(field(u32()))
+		counters.byType[name] = u64()
+	}
+
+	count := u32()
+	for i := uint32(0); i < count && !short; i++ {
+		entry := snapshotEvent{kind: u8()}
+		var deliverAt int64
+		switch {
+		case short:
+		case entry.kind == snapshotQueued || entry.kind == snapshotRetry:
+		case entry.kind == snapshotScheduled:
+			deliverAt = int64(u64())
+		default:
+			return taken, counters, nil, bad("unknown event kind %d", entry.kind)
+		}
+		record := field(u32())
+		if short {
+			break
+		}
+		if _, _, entry.event, err = decodeWALRecord(record); err != nil {
+			return taken, counters, nil, bad("event %d: %v", i+1, err)
+		}
+		if deliverAt > 0 {
+			entry.event.DeliverAt = time.Unix(0, deliverAt)
+		}
+		events = append(events, entry)
+	}
+
+	if short {
+		return taken, counters, nil, bad("truncated")
+	}
+	return taken, counters, events, nil
+}
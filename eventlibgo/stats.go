@@ -0,0 +1,256 @@
+package eventlib
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples is how many recent handler durations feed the percentiles
+const latencySamples = 1024
+
+// Stats is a point-in-time snapshot of processor internals
+type Stats struct {
+	Name      string
+	State     string
+	Uptime    time.Duration
+	QueueSize int
+	Buffered  int // Held by AsyncPush, not yet in the C queue
+
+	// RetryPending counts failed events waiting out their backoff
+	RetryPending int
+
+	// Scheduled counts events held until their DeliverAt
+	Scheduled int
+
+	// Event counters
+	Pushed          uint64 // Accepted by Push, including filtered events
+	Processed       uint64
+	Dropped         uint64 // Rejected by Push (queue full, push failure)
+	Filtered        uint64 // Rejected by OnFilter or dropped by a Transformer
+	Duplicates      uint64 // Dropped by the dedup window
+	Expired         uint64
+	Retried         uint64 // Failed deliveries scheduled for another attempt
+	DeadLettered    uint64 // Events given up on after their last attempt
+	ProcessedByType map[string]uint64
+
+	// Subscribers counts active Subscribe channels; SubscriberDrops counts
+	// events their drop policies discarded
+	Subscribers     int
+	SubscriberDrops uint64
+
+	// cgo boundary crossings, zero in nocgo builds
+	CgoCalls  uint64 // Go -> C
+	Callbacks uint64 // C -> Go
+
+	// OnEvent handler durations over the most recent samples
+	HandlerLatency LatencySummary
+
+	// Time from Push to OnEvent over the most recent samples
+	QueueLatency LatencySummary
+
+	// Library holds the C library's own counters
+	Library LibraryStats
+
+	// Shards holds each shard's own Stats for a ProcessorPool
+	Shards []Stats
+}
+
+// LibraryStats are the counters kept by the C library, or the pure-Go
+// queue in nocgo builds, read in one call so they agree with each other
+type LibraryStats struct {
+	Submitted uint64 // Queued, not counting filtered events
+	Filtered  uint64 // Dropped by OnFilter
+	Rejected  uint64 // Refused because the queue was full
+	Cleared   uint64 // Removed by Purge or when the processor closed
+
+	QueueHighWater int  // Largest queue size seen
+	QueuePressure  bool // Past HighWatermark, not yet back to LowWatermark
+	MaxQueueSize   int  // 0 for unbounded
+
+	// Time spent in OnEvent and OnExpired callbacks, including the cgo
+	// transition
+	ProcessingTime    time.Duration
+	MaxProcessingTime time.Duration
+}
+
+// engineStats is one consistent read of the engine's counters
+type engineStats struct {
+	state     string
+	queueSize int
+	processed uint64
+	expired   uint64
+	library   LibraryStats
+}
+
+// add sums two processors' library counters
+func (s LibraryStats) add(other LibraryStats) LibraryStats {
+	return LibraryStats{
+		Submitted:         s.Submitted + other.Submitted,
+		Filtered:          s.Filtered + other.Filtered,
+		Rejected:          s.Rejected + other.Rejected,
+		Cleared:           s.Cleared + other.Cleared,
+		QueueHighWater:    s.QueueHighWater + other.QueueHighWater,
+		QueuePressure:     s.QueuePressure || other.QueuePressure,
+		MaxQueueSize:      s.MaxQueueSize + other.MaxQueueSize,
+		ProcessingTime:    s.ProcessingTime + other.ProcessingTime,
+		MaxProcessingTime: max(s.MaxProcessingTime, other.MaxProcessingTime),
+	}
+}
+
+// LatencySummary summarizes a window of durations
+type LatencySummary struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// merge combines two summaries, keeping the worse of each percentile
+func (s LatencySummary) merge(other LatencySummary) LatencySummary {
+	return LatencySummary{
+		Count: s.Count + other.Count,
+		P50:   max(s.P50, other.P50),
+		P90:   max(s.P90, other.P90),
+		P99:   max(s.P99, other.P99),
+		Max:   max(s.Max, other.Max),
+	}
+}
+
+// StatsProvider is implemented by processors that can report Stats
+type StatsProvider interface {
+	Stats() Stats
+}
+
+var _ StatsProvider = (*EventProcessor)(nil)
+
+// statsCollector accumulates the Go-side counters behind Stats
+type statsCollector struct {
+	created time.Time
+
+	pushed       atomic.Uint64
+	dropped      atomic.Uint64
+	filtered     atomic.Uint64
+	duplicates   atomic.Uint64
+	retried      atomic.Uint64
+	deadLettered atomic.Uint64
+	cgoCalls     atomic.Uint64
+	callbacks    atomic.Uint64
+
+	// Processed and expired events counted before a Restore or by the
+	// engine a Restart replaced, added to the C library's counters
+	restoredProcessed atomic.Uint64
+	restoredExpired   atomic.Uint64
+
+	mu        sync.Mutex
+	byType    map[string]uint64
+	latencies *latencyWindow
+	queued    *latencyWindow
+}
+
+// latencyWindow keeps the most recent latencySamples durations
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, latencySamples)}
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		created:   time.Now(),
+		byType:    make(map[string]uint64),
+		latencies: newLatencyWindow(),
+		queued:    newLatencyWindow(),
+	}
+}
+
+// recordHandled counts a handled event, its handler duration and how long
+// it was queued; a zero queued time is left out
+func (sc *statsCollector) recordHandled(eventType EventType, d, queued time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.byType[eventType.String()]++
+	sc.latencies.add(d)
+	if queued > 0 {
+		sc.queued.add(queued)
+	}
+}
+
+// restore carries on the counters of a snapshotted processor
+func (sc *statsCollector) restore(counters snapshotCounters) {
+	sc.pushed.Add(counters.pushed)
+	sc.dropped.Add(counters.dropped)
+	sc.filtered.Add(counters.filtered)
+	sc.duplicates.Add(counters.duplicates)
+	sc.retried.Add(counters.retried)
+	sc.deadLettered.Add(counters.deadLettered)
+	sc.restoredProcessed.Add(counters.processed)
+	sc.restoredExpired.Add(counters.expired)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for name, n := range counters.byType {
+		sc.byType[name] += n
+	}
+}
+
+// snapshot fills the Go-side fields of a Stats
+func (sc *statsCollector) snapshot(stats *Stats) {
+	stats.Uptime = time.Since(sc.created)
+	stats.Pushed = sc.pushed.Load()
+	stats.Dropped = sc.dropped.Load()
+	stats.Filtered = sc.filtered.Load()
+	stats.Duplicates = sc.duplicates.Load()
+	stats.Retried = sc.retried.Load()
+	stats.DeadLettered = sc.deadLettered.Load()
+	stats.CgoCalls = sc.cgoCalls.Load()
+	stats.Callbacks = sc.callbacks.Load()
+
+	sc.mu.Lock()
+	stats.ProcessedByType = make(map[string]uint64, len(sc.byType))
+	for k, v := range sc.byType {
+		stats.ProcessedByType[k] = v
+	}
+	samples := append([]time.Duration(nil), sc.latencies.samples...)
+	queued := append([]time.Duration(nil), sc.queued.samples...)
+	sc.mu.Unlock()
+
+	stats.HandlerLatency = summarize(samples)
+	stats.QueueLatency = summarize(queued)
+}
+
+// summarize computes percentiles over samples, sorting them in place
+func summarize(samples []time.Duration) LatencySummary {
+	if len(samples) == 0 {
+		return LatencySummary{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	at := func(q float64) time.Duration {
+		return samples[int(q*float64(len(samples)-1))]
+	}
+
+	return LatencySummary{
+		Count: len(samples),
+		P50:   at(0.50),
+		P90:   at(0.90),
+		P99:   at(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
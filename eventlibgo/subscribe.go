@@ -0,0 +1,257 @@
+package eventlib
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscribeBuffer is a subscription's channel capacity when
+// WithBuffer is not given
+const DefaultSubscribeBuffer = 256
+
+// DropPolicy decides what a subscription does when its channel is full
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being delivered
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room
+	DropOldest
+	// Block waits for the subscriber, stalling processing until it reads
+	// or unsubscribes
+	Block
+)
+
+// Subscriber is implemented by processors that deliver processed events
+// to channels
+type Subscriber interface {
+	Subscribe(opts ...SubscribeOption) (<-chan Event, func())
+}
+
+var _ Subscriber = (*EventProcessor)(nil)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscription)
+
+// WithBuffer sets the channel capacity
+func WithBuffer(size int) SubscribeOption {
+	return func(s *subscription) {
+		if size >= 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithDropPolicy sets what happens when the channel is full. The default
+// is DropNewest.
+func WithDropPolicy(policy DropPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.policy = policy
+	}
+}
+
+// WithTypes delivers only events of the given types
+func WithTypes(types ...EventType) SubscribeOption {
+	return WithMatch(func(event Event) bool {
+		return slices.Contains(types, event.Type)
+	})
+}
+
+// WithSource delivers only events whose source matches pattern, in
+// path.Match syntax as for HandleSource. It panics on a malformed pattern.
+func WithSource(pattern string) SubscribeOption {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("eventlib: invalid source pattern %q: %v", pattern, err))
+	}
+	return WithMatch(func(event Event) bool {
+		ok, _ := path.Match(pattern, event.Source)
+		return ok
+	})
+}
+
+// WithMatch delivers only events for which match returns true. Given more
+// than once, every match must hold.
+func WithMatch(match func(event Event) bool) SubscribeOption {
+	return func(s *subscription) {
+		s.matches = append(s.matches, match)
+	}
+}
+
+// subscription is one Subscribe call's channel and settings
+type subscription struct {
+	size    int
+	policy  DropPolicy
+	matches []func(Event) bool
+
+	ch   chan Event
+	done chan struct{} // Closed first on cancel, to release a Block send
+	once sync.Once
+
+	// mu serializes sends with closing ch
+	mu     sync.Mutex
+	closed bool
+}
+
+func newSubscription(opts []SubscribeOption) *subscription {
+	s := &subscription{size: DefaultSubscribeBuffer}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ch = make(chan Event, s.size)
+	s.done = make(chan struct{})
+	return s
+}
+
+func (s *subscription) wants(event Event) bool {
+	for _, match := range s.matches {
+		if !match(event) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver sends event according to the drop policy, returning how many
+// events were dropped
+func (s *subscription) deliver(event Event) (dropped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.ch <- event:
+		case <-s.done:
+		}
+		return 0
+	case DropOldest:
+		if cap(s.ch) == 0 {
+			// Nothing buffered to drop
+			select {
+			case s.ch <- event:
+				return 0
+			default:
+				return 1
+			}
+		}
+		for {
+			select {
+			case s.ch <- event:
+				return dropped
+			default:
+			}
+			// Full; the subscriber may have read in between, in which
+			// case the next send succeeds without dropping
+			select {
+			case <-s.ch:
+				dropped++
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- event:
+			return 0
+		default:
+			return 1
+		}
+	}
+}
+
+// close ends the subscription, closing its channel once
+func (s *subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// subscriberSet holds a processor's subscriptions
+type subscriberSet struct {
+	mu     sync.RWMutex
+	subs   []*subscription
+	closed bool
+
+	dropped atomic.Uint64
+}
+
+// add registers s, reporting false once the set is closed
+func (ss *subscriberSet) add(s *subscription) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		return false
+	}
+	ss.subs = append(ss.subs, s)
+	return true
+}
+
+func (ss *subscriberSet) remove(s *subscription) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	// Copied, as publish may be ranging over the old slice
+	ss.subs = slices.DeleteFunc(slices.Clone(ss.subs), func(other *subscription) bool { return other == s })
+}
+
+func (ss *subscriberSet) len() int {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return len(ss.subs)
+}
+
+// publish delivers event to every subscription that wants it
+func (ss *subscriberSet) publish(event Event) {
+	ss.mu.RLock()
+	subs := ss.subs
+	ss.mu.RUnlock()
+
+	for _, s := range subs {
+		if s.wants(event) {
+			if n := s.deliver(event); n > 0 {
+				ss.dropped.Add(uint64(n))
+			}
+		}
+	}
+}
+
+// closeAll ends every subscription and refuses new ones, for Close
+func (ss *subscriberSet) closeAll() {
+	ss.mu.Lock()
+	subs := ss.subs
+	ss.subs = nil
+	ss.closed = true
+	ss.mu.Unlock()
+
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// Subscribe returns a channel of processed events, delivered after the
+// handlers run without error, and a function that cancels the
+// subscription and closes the channel. It may be called at any time
+// after New. The channel is also closed by Close.
+//
+// Delivery happens on the processing goroutine, so with the Block policy
+// a slow subscriber slows processing; the other policies drop events
+// instead, counted in Stats.SubscriberDrops.
+func (ep *EventProcessor) Subscribe(opts ...SubscribeOption) (<-chan Event, func()) {
+	s := newSubscription(opts)
+	if !ep.subs.add(s) {
+		s.close()
+	}
+	return s.ch, func() {
+		ep.subs.remove(s)
+		s.close()
+	}
+}
@@ -0,0 +1,293 @@
+package eventlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// TopicMetadataKey is the Event.Metadata key under which a TopicProcessor
+// records the topic an event was pushed to, so shared handlers can tell
+// topics apart
+const TopicMetadataKey = "topic"
+
+// ErrUnknownTopic is returned for calls naming a topic that was never
+// added, or has been removed
+var ErrUnknownTopic = errors.New("unknown topic")
+
+var topicNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// TopicConfig configures one topic of a TopicProcessor
+type TopicConfig struct {
+	// MaxQueueSize limits the topic's queue; 0 takes the processor
+	// Config's
+	MaxQueueSize int
+}
+
+// TopicProcessor keeps a separate queue for each named topic, so a burst
+// on one topic cannot fill the queue another depends on, and each can be
+// limited and drained on its own. Every topic is an EventProcessor with
+// its own C queue, built from the same Config and handing events to the
+// same Handlers.
+type TopicProcessor struct {
+	config   Config
+	handlers *Handlers
+
+	mu      sync.RWMutex
+	topics  map[string]*EventProcessor
+	running bool
+	closed  bool
+}
+
+// NewTopicProcessor creates a processor with the given topics. Topic t is
+// named "<name>.<t>" and, with persistence, journals to "topic-<t>" under
+// config.PersistencePath.
+func NewTopicProcessor(config *Config, handlers *Handlers, topics map[string]TopicConfig) (*TopicProcessor, error) {
+	if config == nil {
+		return nil, fmt.Errorf("%w: config cannot be nil", ErrInvalidConfig)
+	}
+
+	tp := &TopicProcessor{
+		config:   *config,
+		handlers: handlers,
+		topics:   make(map[string]*EventProcessor),
+	}
+	for _, name := range slices.Sorted(maps.Keys(topics)) {
+		if err := tp.AddTopic(name, topics[name]); err != nil {
+			tp.Close()
+			return nil, err
+		}
+	}
+	return tp, nil
+}
+
+// AddTopic creates a topic, started if the processor is
+func (tp *TopicProcessor) AddTopic(name string, config TopicConfig) error {
+	if !topicNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: topic name %q must be letters, digits, '.', '-' or '_'", ErrInvalidConfig, name)
+	}
+	if config.MaxQueueSize < 0 {
+		return fmt.Errorf("%w: negative MaxQueueSize %d for topic %s", ErrInvalidConfig, config.MaxQueueSize, name)
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.closed {
+		return ErrClosed
+	}
+	if _, ok := tp.topics[name]; ok {
+		return fmt.Errorf("topic %s already exists", name)
+	}
+
+	topicConfig := tp.config
+	topicConfig.Name = tp.config.Name + "." + name
+	if config.MaxQueueSize > 0 {
+		topicConfig.MaxQueueSize = config.MaxQueueSize
+	}
+	if tp.config.PersistencePath != "" {
+		topicConfig.PersistencePath = filepath.Join(tp.config.PersistencePath, "topic-"+name)
+	}
+
+	ep, err := New(&topicConfig, tp.handlers)
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", name, err)
+	}
+	if tp.running {
+		if err := ep.Start(); err != nil {
+			ep.Close()
+			return fmt.Errorf("failed to start topic %s: %w", name, err)
+		}
+	}
+	tp.topics[name] = ep
+	return nil
+}
+
+// RemoveTopic closes a topic, dropping whatever it still has queued
+func (tp *TopicProcessor) RemoveTopic(name string) error {
+	tp.mu.Lock()
+	ep, ok := tp.topics[name]
+	delete(tp.topics, name)
+	tp.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	return ep.Close()
+}
+
+// Topic returns the processor behind a topic, for anything not offered
+// here such as stats or dead letters
+func (tp *TopicProcessor) Topic(name string) (*EventProcessor, error) {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	if tp.closed {
+		return nil, ErrClosed
+	}
+	ep, ok := tp.topics[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, name)
+	}
+	return ep, nil
+}
+
+// Topics returns the topic names, sorted
+func (tp *TopicProcessor) Topics() []string {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return slices.Sorted(maps.Keys(tp.topics))
+}
+
+// each runs fn on every topic in name order and joins the errors. The
+// lock is not held while fn runs, so handlers may push to other topics.
+func (tp *TopicProcessor) each(fn func(ep *EventProcessor) error) error {
+	tp.mu.RLock()
+	topics := maps.Clone(tp.topics)
+	tp.mu.RUnlock()
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(topics)) {
+		if err := fn(topics[name]); err != nil {
+			errs = append(errs, fmt.Errorf("topic %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start starts every topic, and those added later
+func (tp *TopicProcessor) Start() error {
+	tp.mu.Lock()
+	tp.running = true
+	tp.mu.Unlock()
+	return tp.each((*EventProcessor).Start)
+}
+
+// Stop stops every topic
+func (tp *TopicProcessor) Stop() error {
+	tp.mu.Lock()
+	tp.running = false
+	tp.mu.Unlock()
+	return tp.each((*EventProcessor).Stop)
+}
+
+// Push adds an event to a topic's queue, recording the topic under
+// TopicMetadataKey
+func (tp *TopicProcessor) Push(topic string, event Event) error {
+	return tp.PushContext(context.Background(), topic, event)
+}
+
+// PushContext is Push, but fails fast once ctx is done
+func (tp *TopicProcessor) PushContext(ctx context.Context, topic string, event Event) error {
+	ep, err := tp.Topic(topic)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(event.Metadata)+1)
+	maps.Copy(metadata, event.Metadata)
+	metadata[TopicMetadataKey] = topic
+	event.Metadata = metadata
+	return ep.PushContext(ctx, event)
+}
+
+// SetMaxQueueSize changes a topic's queue limit
+func (tp *TopicProcessor) SetMaxQueueSize(topic string, size int) error {
+	ep, err := tp.Topic(topic)
+	if err != nil {
+		return err
+	}
+	return ep.SetMaxQueueSize(size)
+}
+
+// ProcessAll drains a topic's queue
+func (tp *TopicProcessor) ProcessAll(topic string) error {
+	ep, err := tp.Topic(topic)
+	if err != nil {
+		return err
+	}
+	ep.ProcessAll()
+	return nil
+}
+
+// ProcessAllContext drains a topic's queue until it is empty or ctx is
+// done
+func (tp *TopicProcessor) ProcessAllContext(ctx context.Context, topic string) error {
+	ep, err := tp.Topic(topic)
+	if err != nil {
+		return err
+	}
+	return ep.ProcessAllContext(ctx)
+}
+
+// ProcessAllTopics drains every topic, one after another in name order
+func (tp *TopicProcessor) ProcessAllTopics() {
+	tp.each(func(ep *EventProcessor) error {
+		ep.ProcessAll()
+		return nil
+	})
+}
+
+// Subscribe returns a channel of the events processed on one topic, as
+// EventProcessor.Subscribe
+func (tp *TopicProcessor) Subscribe(topic string, opts ...SubscribeOption) (<-chan Event, func(), error) {
+	ep, err := tp.Topic(topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, cancel := ep.Subscribe(opts...)
+	return ch, cancel, nil
+}
+
+// Drain drains every topic and sums the counts
+func (tp *TopicProcessor) Drain(ctx context.Context) (drained, abandoned int, err error) {
+	err = tp.each(func(ep *EventProcessor) error {
+		d, a, err := ep.Drain(ctx)
+		drained += d
+		abandoned += a
+		return err
+	})
+	return drained, abandoned, err
+}
+
+// QueueSize returns the number of events queued across all topics
+func (tp *TopicProcessor) QueueSize() int {
+	total := 0
+	tp.each(func(ep *EventProcessor) error {
+		total += ep.QueueSize()
+		return nil
+	})
+	return total
+}
+
+// EventsProcessed returns the number of events processed on all topics
+func (tp *TopicProcessor) EventsProcessed() int {
+	total := 0
+	tp.each(func(ep *EventProcessor) error {
+		total += ep.EventsProcessed()
+		return nil
+	})
+	return total
+}
+
+// Close closes every topic. Later calls do nothing.
+func (tp *TopicProcessor) Close() error {
+	tp.mu.Lock()
+	if tp.closed {
+		tp.mu.Unlock()
+		return nil
+	}
+	tp.closed = true
+	topics := tp.topics
+	tp.topics = make(map[string]*EventProcessor)
+	tp.mu.Unlock()
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(topics)) {
+		if err := topics[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("topic %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package eventlib
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans started by this package
+const tracerName = "github.com/sammyjroberts/eventlibgo"
+
+// traceContext encodes Event.TraceParent. It is always W3C, whatever
+// propagator the application installs.
+var traceContext = propagation.TraceContext{}
+
+// WithTrace returns event with the span in ctx recorded as its TraceParent.
+// An event that already has a TraceParent is returned unchanged.
+func WithTrace(ctx context.Context, event Event) Event {
+	if event.TraceParent != "" {
+		return event
+	}
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	event.TraceParent = carrier.Get("traceparent")
+	return event
+}
+
+// ContextWithTrace returns ctx with event's TraceParent as the remote parent
+// span, so work done for the event continues its trace
+func ContextWithTrace(ctx context.Context, event Event) context.Context {
+	if event.TraceParent == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": event.TraceParent})
+}
+
+// startProcessSpan starts the span around an OnEvent call, continuing the
+// trace recorded at Push. The returned event carries the new span as its
+// TraceParent, so the handler's own spans nest under it.
+func (ep *EventProcessor) startProcessSpan(event Event) (Event, trace.Span) {
+	ctx := ContextWithTrace(context.Background(), event)
+
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "eventlib"),
+		attribute.String("messaging.operation.type", "process"),
+		attribute.String("messaging.destination.name", ep.config.Name),
+		attribute.String("eventlib.event.type", event.Type.String()),
+		attribute.String("eventlib.event.source", event.Source),
+	}
+	if event.CorrelationID != "" {
+		attrs = append(attrs, attribute.String("eventlib.correlation_id", event.CorrelationID))
+	}
+	if latency := event.QueueLatency(); latency > 0 {
+		attrs = append(attrs, attribute.Float64("eventlib.queue_latency_seconds", latency.Seconds()))
+	}
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "process "+ep.config.Name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+
+	event.TraceParent = ""
+	return WithTrace(ctx, event), span
+}
+
+// endProcessSpan ends a process span, marking it failed if a handler
+// returned an error or panicked
+func endProcessSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,152 @@
+package eventlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Transformer rewrites an event before it is queued. Returning
+// ErrDropEvent discards the event; any other error fails the push.
+type Transformer func(Event) (Event, error)
+
+// ErrDropEvent is returned by a Transformer to discard an event. The push
+// succeeds and the event is counted as filtered.
+var ErrDropEvent = errors.New("event dropped by transformer")
+
+// DefaultGzipMaxSize caps the output of GzipDecompress when no limit is
+// given
+const DefaultGzipMaxSize = 16 << 20
+
+// applyTransformers runs event through transformers in order
+func applyTransformers(transformers []Transformer, event Event) (Event, error) {
+	for _, t := range transformers {
+		var err error
+		if event, err = t(event); err != nil {
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+// PrefixSource prepends prefix to every event's source
+func PrefixSource(prefix string) Transformer {
+	return func(event Event) (Event, error) {
+		event.Source = prefix + event.Source
+		return event, nil
+	}
+}
+
+// GzipDecompress inflates gzip-compressed Data, recognised by the gzip
+// magic number; other events pass unchanged. Output beyond maxSize bytes
+// (0 for DefaultGzipMaxSize) fails the push, guarding against
+// decompression bombs.
+func GzipDecompress(maxSize int) Transformer {
+	if maxSize <= 0 {
+		maxSize = DefaultGzipMaxSize
+	}
+	return func(event Event) (Event, error) {
+		if len(event.Data) < 2 || event.Data[0] != 0x1f || event.Data[1] != 0x8b {
+			return event, nil
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(event.Data))
+		if err != nil {
+			return event, fmt.Errorf("gzip: %w", err)
+		}
+		defer zr.Close()
+
+		data, err := io.ReadAll(io.LimitReader(zr, int64(maxSize)+1))
+		if err != nil {
+			return event, fmt.Errorf("gzip: %w", err)
+		}
+		if len(data) > maxSize {
+			return event, fmt.Errorf("gzip: decompressed data exceeds %d bytes", maxSize)
+		}
+		event.Data = data
+		return event, nil
+	}
+}
+
+// StripJSONFields removes fields from JSON object Data. A dotted path such
+// as "user.password" reaches into nested objects. Data that is not a JSON
+// object passes unchanged.
+func StripJSONFields(fields ...string) Transformer {
+	paths := make([][]string, len(fields))
+	for i, field := range fields {
+		paths[i] = strings.Split(field, ".")
+	}
+	return jsonObjectTransformer(func(obj map[string]any, _ Event) bool {
+		changed := false
+		for _, path := range paths {
+			if deleteJSONPath(obj, path) {
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// InjectJSONTimestamp sets field in JSON object Data to the event's
+// Timestamp, or the current time if it has none, in RFC 3339 format.
+// Data that is not a JSON object passes unchanged.
+func InjectJSONTimestamp(field string) Transformer {
+	return jsonObjectTransformer(func(obj map[string]any, event Event) bool {
+		ts := event.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		obj[field] = ts.UTC().Format(time.RFC3339Nano)
+		return true
+	})
+}
+
+// jsonObjectTransformer decodes JSON object Data, lets edit change it and
+// re-encodes it if edit reports a change
+func jsonObjectTransformer(edit func(obj map[string]any, event Event) bool) Transformer {
+	return func(event Event) (Event, error) {
+		data := bytes.TrimSpace(event.Data)
+		if len(data) == 0 || data[0] != '{' {
+			return event, nil
+		}
+
+		var obj map[string]any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return event, nil
+		}
+		if !edit(obj, event) {
+			return event, nil
+		}
+
+		out, err := json.Marshal(obj)
+		if err != nil {
+			return event, fmt.Errorf("json: %w", err)
+		}
+		event.Data = out
+		return event, nil
+	}
+}
+
+// deleteJSONPath removes the value at path, reporting whether there was
+// one
+func deleteJSONPath(obj map[string]any, path []string) bool {
+	for len(path) > 1 {
+		next, ok := obj[path[0]].(map[string]any)
+		if !ok {
+			return false
+		}
+		obj, path = next, path[1:]
+	}
+	if _, ok := obj[path[0]]; !ok {
+		return false
+	}
+	delete(obj, path[0])
+	return true
+}
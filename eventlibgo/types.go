@@ -1,5 +1,7 @@
 package eventlib
 
+import "time"
+
 // EventType represents the type of event
 type EventType int
 
@@ -10,7 +12,18 @@ const (
 	EventTypeError      EventType = 3
 )
 
+// String returns the type's name, or UNKNOWN for an unregistered value
 func (et EventType) String() string {
+	if name := et.builtinName(); name != "UNKNOWN" {
+		return name
+	}
+	if name, ok := DefaultEventTypes.Name(et); ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+func (et EventType) builtinName() string {
 	switch et {
 	case EventTypeData:
 		return "DATA"
@@ -25,16 +38,117 @@ func (et EventType) String() string {
 	}
 }
 
+// DefaultPriority ranks failures and disconnects ahead of routine traffic,
+// for use when a producer has no priority of its own
+func (et EventType) DefaultPriority() int {
+	switch et {
+	case EventTypeError:
+		return 20
+	case EventTypeDisconnect:
+		return 10
+	default:
+		return 0
+	}
+}
+
+// QueueMode selects how queued events are ordered
+type QueueMode int
+
+const (
+	// QueueFIFO processes events in arrival order
+	QueueFIFO QueueMode = iota
+
+	// QueuePriority processes higher Priority events first, in arrival
+	// order within a priority
+	QueuePriority
+)
+
+func (m QueueMode) String() string {
+	switch m {
+	case QueueFIFO:
+		return "fifo"
+	case QueuePriority:
+		return "priority"
+	default:
+		return "unknown"
+	}
+}
+
 // Event represents an event in the system
 type Event struct {
 	Type   EventType
 	Source string
 	Data   []byte
+
+	// ID is an optional producer-assigned identity. Events with the same
+	// ID are duplicates to the dedup window; see DedupConfig.
+	ID string
+
+	// Deadline, if set, is when the event expires; expired events are
+	// passed to OnExpired instead of OnEvent
+	Deadline time.Time
+
+	// DeliverAt, if in the future, holds the event in Go until then
+	// instead of queueing it straight away
+	DeliverAt time.Time
+
+	// Timestamp is when the event originally occurred, if known
+	Timestamp time.Time
+
+	// Backfill marks historical events being re-ingested; consumers should
+	// record them but keep them away from live-only outputs
+	Backfill bool
+
+	// Priority orders events in QueuePriority mode; higher goes first
+	Priority int
+
+	// ContentType is the MIME type of Data, if known
+	ContentType string
+
+	// EnqueuedAt is when Push accepted the event; set by Push
+	EnqueuedAt time.Time
+
+	// ProcessedAt is when the event was handed to OnEvent
+	ProcessedAt time.Time
+
+	// TraceParent is the W3C traceparent of the span that pushed the event.
+	// PushContext records it; OnEvent sees the processing span instead. Use
+	// ContextWithTrace to continue the trace.
+	TraceParent string
+
+	// Attempt counts earlier deliveries whose handlers failed; it is 0 the
+	// first time an event is handled
+	Attempt int
+
+	// CorrelationID is shared by the events of one workflow, such as all
+	// those caused by one request, so they can be followed together in
+	// logs and traces
+	CorrelationID string
+
+	// Metadata is free-form context carried with the event through the
+	// queue, the journal and every handler
+	Metadata map[string]string
+}
+
+// QueueLatency is how long the event waited between Push and OnEvent, or
+// zero if either time is unknown
+func (e Event) QueueLatency() time.Duration {
+	if e.EnqueuedAt.IsZero() || e.ProcessedAt.IsZero() {
+		return 0
+	}
+	return e.ProcessedAt.Sub(e.EnqueuedAt)
 }
 
 // Handler function types
 type (
-	EventHandler       func(event Event)
+	EventHandler       func(event Event) error
 	FilterHandler      func(event Event) bool
 	StateChangeHandler func(oldState, newState string)
+	ExpiredHandler     func(event Event)
+	DeadLetterHandler  func(letter DeadLetter)
+
+	// QueuePressureHandler is told when the queue crosses a watermark:
+	// high is true on reaching HighWatermark and false on falling back to
+	// LowWatermark
+	QueuePressureHandler func(high bool, queueSize int)
 )
@@ -0,0 +1,22 @@
+package eventlib
+
+// LibraryInfo describes the C library the bindings are using
+type LibraryInfo struct {
+	Version    string
+	BuildFlags string
+	Linkage    string // "static", "dlopen" or "nocgo"
+}
+
+// Library reports the version and build flags of the linked C library,
+// loading it first when it is bound at runtime. Nocgo builds report the
+// library version their pure-Go queue follows, and the Go toolchain.
+func Library() (LibraryInfo, error) {
+	info := LibraryInfo{Linkage: linkage}
+
+	if err := loadLibrary(); err != nil {
+		return info, err
+	}
+
+	info.Version, info.BuildFlags = libraryVersion()
+	return info, nil
+}
@@ -0,0 +1,202 @@
+package eventlib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoEventID is returned by PushAndWait for an event without an ID
+var ErrNoEventID = errors.New("event has no ID")
+
+// ErrAlreadyWaiting is returned by PushAndWait when another call is
+// already waiting on an event with the same ID
+var ErrAlreadyWaiting = errors.New("already waiting on an event with this ID")
+
+// syncPollInterval is how often PushAndWait looks at the queue again while
+// its event is out of reach: buffered, in another goroutine's hands or
+// backing off before a retry
+const syncPollInterval = 10 * time.Millisecond
+
+// Waiter is implemented by processors that can push an event and wait for
+// it to be processed
+type Waiter interface {
+	PushAndWait(ctx context.Context, event Event) (Outcome, error)
+}
+
+var _ Waiter = (*EventProcessor)(nil)
+
+// OutcomeStatus is how an event's processing ended
+type OutcomeStatus int
+
+const (
+	// OutcomeHandled means every handler returned nil
+	OutcomeHandled OutcomeStatus = iota
+	// OutcomeFailed means the handlers failed on every attempt and the
+	// event was dead-lettered
+	OutcomeFailed
+	// OutcomeExpired means the deadline passed before processing
+	OutcomeExpired
+	// OutcomeFiltered means OnFilter or a transformer dropped the event
+	OutcomeFiltered
+	// OutcomeDuplicate means the event was dropped by Dedup
+	OutcomeDuplicate
+	// OutcomeDropped means an async push accepted the event but could not
+	// queue it
+	OutcomeDropped
+	// OutcomeCancelled means the event was scheduled and then cancelled
+	// before its DeliverAt
+	OutcomeCancelled
+	// OutcomePurged means the event was removed from the queue by Purge
+	OutcomePurged
+)
+
+func (s OutcomeStatus) String() string {
+	switch s {
+	case OutcomeHandled:
+		return "handled"
+	case OutcomeFailed:
+		return "failed"
+	case OutcomeExpired:
+		return "expired"
+	case OutcomeFiltered:
+		return "filtered"
+	case OutcomeDuplicate:
+		return "duplicate"
+	case OutcomeDropped:
+		return "dropped"
+	case OutcomeCancelled:
+		return "cancelled"
+	case OutcomePurged:
+		return "purged"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome reports what happened to an event pushed with PushAndWait
+type Outcome struct {
+	Status OutcomeStatus
+
+	// Err is the handlers' error for OutcomeFailed, or the reason for
+	// OutcomeDropped
+	Err error
+
+	// Attempts is how many times the handlers ran
+	Attempts int
+
+	// Duration is the time from the push to the outcome
+	Duration time.Duration
+}
+
+// waiter is one PushAndWait call's pending outcome
+type waiter struct {
+	done    chan struct{}
+	outcome Outcome
+}
+
+// waiterSet maps event IDs to the calls waiting on them
+type waiterSet struct {
+	// n lets settle skip the lock while nobody is waiting, the usual case
+	n  atomic.Int64
+	mu sync.Mutex
+	m  map[string]*waiter
+}
+
+func (ws *waiterSet) add(id string) (*waiter, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.m[id]; ok {
+		return nil, ErrAlreadyWaiting
+	}
+	if ws.m == nil {
+		ws.m = make(map[string]*waiter)
+	}
+	w := &waiter{done: make(chan struct{})}
+	ws.m[id] = w
+	ws.n.Add(1)
+	return w, nil
+}
+
+// remove gives up on id, if w is still waiting on it
+func (ws *waiterSet) remove(id string, w *waiter) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.m[id] == w {
+		delete(ws.m, id)
+		ws.n.Add(-1)
+	}
+}
+
+// settle reports the outcome for the event with id to its waiter, if any
+func (ws *waiterSet) settle(id string, outcome Outcome) {
+	if id == "" || ws.n.Load() == 0 {
+		return
+	}
+	ws.mu.Lock()
+	w, ok := ws.m[id]
+	if ok {
+		delete(ws.m, id)
+		ws.n.Add(-1)
+	}
+	ws.mu.Unlock()
+
+	if ok {
+		w.outcome = outcome
+		close(w.done)
+	}
+}
+
+// PushAndWait pushes event and processes the queue until that event has
+// been dealt with, returning its outcome. Events queued ahead of it are
+// processed too. Waiting spans retries, so with a RetryPolicy it can take
+// as long as the backoffs add up to.
+//
+// event.ID must be set and not shared with another event in flight, since
+// the outcome is matched by ID. If ctx is done first, PushAndWait returns
+// its error; the event stays queued and is processed as usual.
+func (ep *EventProcessor) PushAndWait(ctx context.Context, event Event) (Outcome, error) {
+	if event.ID == "" {
+		return Outcome{}, ErrNoEventID
+	}
+	w, err := ep.waiters.add(event.ID)
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	start := time.Now()
+	if err := ep.PushContext(ctx, event); err != nil {
+		ep.waiters.remove(event.ID, w)
+		return Outcome{}, err
+	}
+
+	for {
+		select {
+		case <-w.done:
+			outcome := w.outcome
+			outcome.Duration = time.Since(start)
+			return outcome, nil
+		case <-ctx.Done():
+			ep.waiters.remove(event.ID, w)
+			return Outcome{}, ctx.Err()
+		default:
+		}
+
+		switch state := ep.State(); {
+		case state == "CLOSED":
+			ep.waiters.remove(event.ID, w)
+			return Outcome{}, ErrClosed
+		case state == "RUNNING" && ep.QueueSize() > 0:
+			ep.Process()
+			continue
+		}
+
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+		case <-time.After(syncPollInterval):
+		}
+	}
+}
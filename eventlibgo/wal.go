@@ -0,0 +1,592 @@
+package eventlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+// Every record is written to the file immediately, so a crash of the process
+// alone never loses events; the policy only matters if the machine goes down.
+type SyncPolicy int
+
+const (
+	// SyncInterval fsyncs on a timer, bounding loss to one interval
+	SyncInterval SyncPolicy = iota
+
+	// SyncAlways fsyncs before Push returns
+	SyncAlways
+
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncInterval:
+		return "interval"
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSyncPolicy parses "interval", "always" or "never"
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for _, p := range []SyncPolicy{SyncInterval, SyncAlways, SyncNever} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy %q", s)
+}
+
+const (
+	defaultSyncInterval = time.Second
+	defaultSegmentSize  = 64 << 20
+
+	walSuffix = ".wal"
+
+	// walLockName is the file locked by the processor that has the log
+	// open, so that two never replay or compact the same segments
+	walLockName = "LOCK"
+	walLockPoll = 50 * time.Millisecond
+
+	// Record header: body length and CRC-32 of the body
+	walHeaderSize = 8
+
+	walPush = 1
+	walAck  = 2
+)
+
+// walSegment is a log file; segments are named after the first sequence
+// number they may contain, so lexical order is log order
+type walSegment struct {
+	path   string
+	maxSeq uint64 // Highest pushed sequence in the segment
+}
+
+// walEntry is an event recovered from the log
+type walEntry struct {
+	seq   uint64
+	event Event
+}
+
+// wal journals pushed events and their completion. Each push gets a
+// sequence number that travels through C as event_t.id and is acknowledged
+// once the event has been handled, expired or filtered. Acks are never
+// fsynced: losing one only means the event is replayed (at-least-once).
+type wal struct {
+	dir         string
+	policy      SyncPolicy
+	segmentSize int64
+	logger      *slog.Logger
+	lock        *os.File
+
+	mu       sync.Mutex
+	file     *os.File
+	current  walSegment
+	size     int64
+	dirty    bool
+	closed   []walSegment
+	nextSeq  uint64
+	pending  map[uint64]struct{}
+	stopSync chan struct{}
+	synced   chan struct{}
+}
+
+// openWAL opens the log under config.PersistencePath and replays whatever
+// the previous run left unprocessed into the queue
+func (ep *EventProcessor) openWAL() error {
+	w, entries, err := openWALDir(ep.config, ep.logger)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	ep.wal = w
+
+	replayed := 0
+	for _, entry := range entries {
+		if err := ep.push(entry.event, entry.seq); err != nil {
+			// Left pending, so the next run tries again
+			ep.logger.Warn("Failed to replay journaled event",
+				slog.Uint64("seq", entry.seq), slog.Any("error", err))
+			continue
+		}
+		replayed++
+	}
+
+	if len(entries) > 0 {
+		ep.logger.Info("Replayed events from write-ahead log",
+			slog.String("path", w.dir),
+			slog.Int("replayed", replayed),
+			slog.Int("failed", len(entries)-replayed))
+	}
+	return nil
+}
+
+func openWALDir(config *Config, logger *slog.Logger) (*wal, []walEntry, error) {
+	w := &wal{
+		dir:         config.PersistencePath,
+		policy:      config.PersistenceSync,
+		segmentSize: config.PersistenceSegmentSize,
+		logger:      logger,
+		nextSeq:     1,
+		pending:     make(map[uint64]struct{}),
+	}
+	if w.segmentSize <= 0 {
+		w.segmentSize = defaultSegmentSize
+	}
+
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	lock, err := lockDir(w.dir, config.PersistenceLockTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.lock = lock
+	opened := false
+	defer func() {
+		if !opened {
+			unlockDir(lock)
+		}
+	}()
+
+	paths, err := filepath.Glob(filepath.Join(w.dir, "*"+walSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+
+	events := make(map[uint64]Event)
+	for _, path := range paths {
+		segment, err := w.readSegment(path, events)
+		if err != nil {
+			return nil, nil, err
+		}
+		w.closed = append(w.closed, segment)
+	}
+
+	entries := make([]walEntry, 0, len(events))
+	for seq, event := range events {
+		w.pending[seq] = struct{}{}
+		entries = append(entries, walEntry{seq: seq, event: event})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	// Never append to a recovered segment; its tail may be torn
+	if err := w.rotate(); err != nil {
+		return nil, nil, err
+	}
+
+	if w.policy == SyncInterval {
+		interval := config.PersistenceSyncInterval
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		w.stopSync = make(chan struct{})
+		w.synced = make(chan struct{})
+		go w.syncLoop(interval)
+	}
+
+	opened = true
+	return w, entries, nil
+}
+
+// readSegment applies a segment's records to events, stopping quietly at a
+// torn or corrupt tail
+func (w *wal) readSegment(path string, events map[uint64]Event) (walSegment, error) {
+	segment := walSegment{path: path}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return segment, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return segment, err
+	}
+	remaining := info.Size()
+
+	var header [walHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			if err != io.EOF {
+				w.logger.Warn("Truncated write-ahead log record", slog.String("segment", path))
+			}
+			return segment, nil
+		}
+		remaining -= walHeaderSize
+
+		// Check the length against the file before trusting it with an
+		// allocation; a corrupt header could ask for gigabytes
+		n := int64(binary.LittleEndian.Uint32(header[0:4]))
+		if n > remaining {
+			w.logger.Warn("Truncated write-ahead log record", slog.String("segment", path))
+			return segment, nil
+		}
+		remaining -= n
+
+		body := make([]byte, n)
+		if _, err := io.ReadFull(f, body); err != nil ||
+			crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:8]) {
+			w.logger.Warn("Corrupt write-ahead log record", slog.String("segment", path))
+			return segment, nil
+		}
+
+		kind, seq, event, err := decodeWALRecord(body)
+		if err != nil {
+			w.logger.Warn("Invalid write-ahead log record",
+				slog.String("segment", path), slog.Any("error", err))
+			return segment, nil
+		}
+
+		switch kind {
+		case walPush:
+			events[seq] = event
+			segment.maxSeq = max(segment.maxSeq, seq)
+		case walAck:
+			delete(events, seq)
+		}
+		w.nextSeq = max(w.nextSeq, seq+1)
+	}
+}
+
+// append journals a pushed event and returns its sequence number
+func (w *wal) append(event Event) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := w.nextSeq
+	if err := w.write(encodeWALRecord(walPush, seq, &event)); err != nil {
+		return 0, err
+	}
+	w.nextSeq++
+	w.pending[seq] = struct{}{}
+	w.current.maxSeq = seq
+
+	if w.policy == SyncAlways {
+		if err := w.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.size >= w.segmentSize {
+		if err := w.rotate(); err != nil {
+			w.logger.Error("Failed to rotate write-ahead log", slog.Any("error", err))
+		}
+	}
+	return seq, nil
+}
+
+// ack records that an event no longer needs replaying
+func (w *wal) ack(seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.pending[seq]; !ok || w.file == nil {
+		return nil
+	}
+	delete(w.pending, seq)
+	return w.write(encodeWALRecord(walAck, seq, nil))
+}
+
+// write appends one framed record to the current segment
+func (w *wal) write(body []byte) error {
+	record := make([]byte, walHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body))
+	copy(record[walHeaderSize:], body)
+
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	w.dirty = true
+	return err
+}
+
+// rotate starts a new segment and deletes closed ones with nothing pending
+func (w *wal) rotate() error {
+	// A recovered segment may already carry this name; sequence gaps are
+	// harmless, so skip ahead rather than append to it
+	var f *os.File
+	for {
+		path := filepath.Join(w.dir, fmt.Sprintf("%016x%s", w.nextSeq, walSuffix))
+		var err error
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		w.nextSeq++
+	}
+
+	if w.file != nil {
+		if err := w.file.Sync(); err != nil {
+			w.logger.Warn("Failed to sync write-ahead log segment", slog.Any("error", err))
+		}
+		w.file.Close()
+		w.closed = append(w.closed, w.current)
+	}
+
+	w.file = f
+	w.current = walSegment{path: f.Name()}
+	w.size = 0
+	w.dirty = false
+
+	w.compact()
+	return nil
+}
+
+// compact removes the oldest closed segments once all their events have
+// been acknowledged. Only a prefix is ever removed: a later segment may
+// hold acks for an earlier one, which must not be dropped first.
+func (w *wal) compact() {
+	oldest := w.nextSeq
+	for seq := range w.pending {
+		oldest = min(oldest, seq)
+	}
+
+	removed := 0
+	for _, segment := range w.closed {
+		if segment.maxSeq >= oldest {
+			break
+		}
+		if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.logger.Warn("Failed to remove write-ahead log segment",
+				slog.String("segment", segment.path), slog.Any("error", err))
+			break
+		}
+		removed++
+	}
+	w.closed = w.closed[removed:]
+}
+
+func (w *wal) syncLoop(interval time.Duration) {
+	defer close(w.synced)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty && w.file != nil {
+				if err := w.file.Sync(); err != nil {
+					w.logger.Warn("Failed to sync write-ahead log", slog.Any("error", err))
+				}
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		case <-w.stopSync:
+			return
+		}
+	}
+}
+
+// close flushes and closes the log, then lets the next processor open it;
+// pending events stay on disk
+func (w *wal) close() error {
+	defer func() {
+		unlockDir(w.lock)
+		w.lock = nil
+	}()
+
+	if w.stopSync != nil {
+		close(w.stopSync)
+		<-w.synced
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+
+	// Tidy up if everything was processed
+	w.closed = append(w.closed, w.current)
+	w.compact()
+	return err
+}
+
+// encodeWALRecord lays out a record body:
+//
+//	kind u8 | seq u64 | push only: type i32 | flags u32 | deadline i64 |
+//	timestamp i64 | source len u32 + bytes | data len u32 + bytes |
+//	priority i32 | content type len u32 + bytes | enqueued ns i64 |
+//	trace parent len u32 + bytes | custom type name len u32 + bytes |
+//	attempt u32 | ID len u32 + bytes | correlation ID len u32 + bytes |
+//	metadata len u32 + bytes
+//
+// Fields from priority on were added later and are optional when reading
+func encodeWALRecord(kind byte, seq uint64, event *Event) []byte {
+	body := []byte{kind}
+	body = binary.LittleEndian.AppendUint64(body, seq)
+	if event == nil {
+		return body
+	}
+
+	var flags uint32
+	if event.Backfill {
+		flags |= 1
+	}
+	var deadline, timestamp int64
+	if !event.Deadline.IsZero() {
+		deadline = event.Deadline.UnixMilli()
+	}
+	if !event.Timestamp.IsZero() {
+		timestamp = event.Timestamp.UnixMilli()
+	}
+
+	body = binary.LittleEndian.AppendUint32(body, uint32(event.Type))
+	body = binary.LittleEndian.AppendUint32(body, flags)
+	body = binary.LittleEndian.AppendUint64(body, uint64(deadline))
+	body = binary.LittleEndian.AppendUint64(body, uint64(timestamp))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.Source)))
+	body = append(body, event.Source...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.Data)))
+	body = append(body, event.Data...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(int32(event.Priority)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.ContentType)))
+	body = append(body, event.ContentType...)
+	var enqueued int64
+	if !event.EnqueuedAt.IsZero() {
+		enqueued = event.EnqueuedAt.UnixNano()
+	}
+	body = binary.LittleEndian.AppendUint64(body, uint64(enqueued))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.TraceParent)))
+	body = append(body, event.TraceParent...)
+
+	// Custom type numbers depend on registration order, so keep the name
+	var typeName string
+	if event.Type >= EventTypeCustomBase {
+		typeName, _ = DefaultEventTypes.Name(event.Type)
+	}
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(typeName)))
+	body = append(body, typeName...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(event.Attempt))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.ID)))
+	body = append(body, event.ID...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(event.CorrelationID)))
+	body = append(body, event.CorrelationID...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(metadataSize(event.Metadata)))
+	body = appendMetadata(body, event.Metadata)
+	return body
+}
+
+var errShortRecord = errors.New("short record")
+
+func decodeWALRecord(body []byte) (kind byte, seq uint64, event Event, err error) {
+	if len(body) < 9 {
+		return 0, 0, event, errShortRecord
+	}
+	kind = body[0]
+	seq = binary.LittleEndian.Uint64(body[1:9])
+	rest := body[9:]
+
+	switch kind {
+	case walAck:
+		return kind, seq, event, nil
+	case walPush:
+	default:
+		return 0, 0, event, fmt.Errorf("unknown record kind %d", kind)
+	}
+
+	if len(rest) < 28 {
+		return 0, 0, event, errShortRecord
+	}
+	event.Type = EventType(binary.LittleEndian.Uint32(rest[0:4]))
+	event.Backfill = binary.LittleEndian.Uint32(rest[4:8])&1 != 0
+	if deadline := int64(binary.LittleEndian.Uint64(rest[8:16])); deadline > 0 {
+		event.Deadline = time.UnixMilli(deadline)
+	}
+	if timestamp := int64(binary.LittleEndian.Uint64(rest[16:24])); timestamp > 0 {
+		event.Timestamp = time.UnixMilli(timestamp)
+	}
+	rest = rest[24:]
+
+	field := func() ([]byte, bool) {
+		if len(rest) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(rest[0:4])
+		if uint64(len(rest)-4) < uint64(n) {
+			return nil, false
+		}
+		value := rest[4 : 4+n]
+		rest = rest[4+n:]
+		return value, true
+	}
+
+	source, ok := field()
+	if !ok {
+		return 0, 0, event, errShortRecord
+	}
+	data, ok := field()
+	if !ok {
+		return 0, 0, event, errShortRecord
+	}
+
+	event.Source = string(source)
+	if len(data) > 0 {
+		event.Data = append([]byte(nil), data...)
+	}
+	if len(rest) >= 4 {
+		event.Priority = int(int32(binary.LittleEndian.Uint32(rest[0:4])))
+		rest = rest[4:]
+	}
+	if contentType, ok := field(); ok {
+		event.ContentType = string(contentType)
+	}
+	if len(rest) >= 8 {
+		if enqueued := int64(binary.LittleEndian.Uint64(rest[0:8])); enqueued > 0 {
+			event.EnqueuedAt = time.Unix(0, enqueued)
+		}
+		rest = rest[8:]
+	}
+	if traceParent, ok := field(); ok {
+		event.TraceParent = string(traceParent)
+	}
+	if typeName, ok := field(); ok && len(typeName) > 0 {
+		if et, ok := DefaultEventTypes.Lookup(string(typeName)); ok {
+			event.Type = et
+		}
+	}
+	if len(rest) >= 4 {
+		event.Attempt = int(binary.LittleEndian.Uint32(rest[0:4]))
+		rest = rest[4:]
+	}
+	if id, ok := field(); ok {
+		event.ID = string(id)
+	}
+	if correlationID, ok := field(); ok {
+		event.CorrelationID = string(correlationID)
+	}
+	if metadata, ok := field(); ok {
+		event.Metadata = decodeMetadata(metadata)
+	}
+	return kind, seq, event, nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package eventlib
+
+import (
+	"os"
+	"time"
+)
+
+// lockDir does nothing where flock is unavailable; only one processor
+// should be given a PersistencePath at a time
+func lockDir(dir string, timeout time.Duration) (*os.File, error) {
+	return nil, nil
+}
+
+// unlockDir releases a lock taken by lockDir
+func unlockDir(f *os.File) {}
@@ -0,0 +1,211 @@
+package eventlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openPersistent returns a started processor journaling to dir and the
+// sources of the events it handles, in order
+func openPersistent(t *testing.T, dir string) (*EventProcessor, *[]string) {
+	t.Helper()
+
+	var handled []string
+	ep, err := newPersistent(dir, 0, &handled)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return ep, &handled
+}
+
+// newPersistent returns a started processor journaling to dir that
+// appends the sources of the events it handles to handled
+func newPersistent(dir string, lockTimeout time.Duration, handled *[]string) (*EventProcessor, error) {
+	ep, err := New(&Config{
+		Name:                   "wal",
+		PersistencePath:        dir,
+		PersistenceSync:        SyncNever,
+		PersistenceLockTimeout: lockTimeout,
+	}, &Handlers{
+		OnEvent: func(event Event) error {
+			*handled = append(*handled, event.Source)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ep, ep.Start()
+}
+
+// pushSources pushes one event per source
+func pushSources(t *testing.T, ep *EventProcessor, sources ...string) {
+	t.Helper()
+
+	for _, source := range sources {
+		if err := ep.Push(Event{Type: EventTypeData, Source: source, Data: []byte(source)}); err != nil {
+			t.Fatalf("Push %s: %v", source, err)
+		}
+	}
+}
+
+// largestSegment returns the path of the biggest segment in dir, the one
+// holding the events; reopening leaves an empty one behind
+func largestSegment(t *testing.T, dir string) (string, int64) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+walSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path string
+	var size int64 = -1
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > size {
+			path, size = p, info.Size()
+		}
+	}
+	if path == "" {
+		t.Fatalf("no segments in %s", dir)
+	}
+	return path, size
+}
+
+func TestWALReplaysUnprocessed(t *testing.T) {
+	dir := t.TempDir()
+
+	ep, handled := openPersistent(t, dir)
+	pushSources(t, ep, "e1", "e2", "e3", "e4", "e5")
+	if n := ep.ProcessN(2); n != 2 {
+		t.Fatalf("ProcessN(2) = %d", n)
+	}
+	ep.Close()
+	if want := []string{"e1", "e2"}; !slices.Equal(*handled, want) {
+		t.Fatalf("handled %q before closing, want %q", *handled, want)
+	}
+
+	ep, handled = openPersistent(t, dir)
+	defer ep.Close()
+	if size := ep.QueueSize(); size != 3 {
+		t.Fatalf("QueueSize = %d after reopening, want 3", size)
+	}
+	ep.ProcessAll()
+	if want := []string{"e3", "e4", "e5"}; !slices.Equal(*handled, want) {
+		t.Fatalf("replayed %q, want %q", *handled, want)
+	}
+}
+
+func TestWALTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	ep, _ := openPersistent(t, dir)
+	pushSources(t, ep, "e1", "e2", "e3")
+	ep.Close()
+
+	// Tear the last record, as a crash in the middle of a write would
+	path, size := largestSegment(t, dir)
+	if err := os.Truncate(path, size-3); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, handled := openPersistent(t, dir)
+	defer ep.Close()
+	ep.ProcessAll()
+	if want := []string{"e1", "e2"}; !slices.Equal(*handled, want) {
+		t.Fatalf("replayed %q, want %q", *handled, want)
+	}
+
+	// The log carries on in a new segment
+	pushSources(t, ep, "e4")
+	ep.ProcessAll()
+	if want := []string{"e1", "e2", "e4"}; !slices.Equal(*handled, want) {
+		t.Fatalf("handled %q, want %q", *handled, want)
+	}
+}
+
+func TestWALCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+
+	ep, _ := openPersistent(t, dir)
+	pushSources(t, ep, "e1")
+	ep.Close()
+
+	// A header claiming a 4 GiB record must not be allocated for
+	path, _ := largestSegment(t, dir)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], 1<<32-1)
+	if _, err := f.Write(header[:]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	ep, handled := openPersistent(t, dir)
+	defer ep.Close()
+	ep.ProcessAll()
+	if want := []string{"e1"}; !slices.Equal(*handled, want) {
+		t.Fatalf("replayed %q, want %q", *handled, want)
+	}
+}
+
+// TestWALHandoff hands the log from one processor to the next, as a binary
+// upgrade does: the second waits for the first to finish and close, and
+// every event is handled exactly once between them
+func TestWALHandoff(t *testing.T) {
+	dir := t.TempDir()
+
+	var handled []string
+	old, err := newPersistent(dir, 0, &handled)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	pushSources(t, old, "e1", "e2", "e3", "e4", "e5", "e6")
+	old.ProcessN(2)
+
+	if _, err := newPersistent(dir, 0, &handled); !errors.Is(err, ErrPersistenceLocked) {
+		t.Fatalf("second New while the log is open: %v, want ErrPersistenceLocked", err)
+	}
+
+	opened := make(chan *EventProcessor, 1)
+	go func() {
+		ep, err := newPersistent(dir, 10*time.Second, &handled)
+		if err != nil {
+			t.Errorf("New waiting for the log: %v", err)
+		}
+		opened <- ep
+	}()
+
+	// The old processor carries on draining while the new one waits
+	time.Sleep(4 * walLockPoll)
+	select {
+	case <-opened:
+		t.Fatal("New opened the log while another processor had it")
+	default:
+	}
+	old.ProcessN(2)
+	old.Close()
+
+	next := <-opened
+	if next == nil {
+		t.FailNow()
+	}
+	defer next.Close()
+	next.ProcessAll()
+
+	want := []string{"e1", "e2", "e3", "e4", "e5", "e6"}
+	if !slices.Equal(handled, want) {
+		t.Fatalf("handled %q across the handoff, want %q", handled, want)
+	}
+}
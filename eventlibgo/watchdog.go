@@ -0,0 +1,73 @@
+package eventlib
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// maxGoroutineDump bounds the goroutine dump logged for a stuck handler
+const maxGoroutineDump = 1 << 20
+
+// watchHandler runs an event handler under the watchdog. Without a
+// HandlerTimeout it is callHandler. With one, a handler that overruns it
+// is logged and counted; with AbandonStuckHandlers, it is also left to
+// finish on its own and the event fails with ErrHandlerTimeout.
+func (ep *EventProcessor) watchHandler(handler EventHandler, event Event) error {
+	timeout := ep.config.HandlerTimeout
+	if timeout <= 0 {
+		return ep.callHandler(handler, event)
+	}
+
+	if !ep.config.AbandonStuckHandlers {
+		start := time.Now()
+		watchdog := time.AfterFunc(timeout, func() {
+			ep.handlerStuck(event, start)
+		})
+		defer watchdog.Stop()
+		return ep.callHandler(handler, event)
+	}
+
+	// Buffered, so an abandoned handler can still finish and exit
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- ep.callHandler(handler, event)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		ep.handlerStuck(event, start)
+		return fmt.Errorf("%w after %v", ErrHandlerTimeout, timeout)
+	}
+}
+
+// handlerStuck reports a handler still running HandlerTimeout after start
+func (ep *EventProcessor) handlerStuck(event Event, start time.Time) {
+	ep.countTimeout()
+	ep.logger.Error("Event handler exceeded its timeout",
+		slog.String("event_type", event.Type.String()),
+		slog.String("source", event.Source),
+		slog.String("event_id", event.ID),
+		slog.Duration("running", time.Since(start)),
+		slog.Bool("abandoned", ep.config.AbandonStuckHandlers),
+		slog.String("goroutines", goroutineDump()))
+}
+
+// goroutineDump returns the stacks of every goroutine, cut short at
+// maxGoroutineDump bytes
+func goroutineDump() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineDump {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
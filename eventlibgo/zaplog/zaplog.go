@@ -0,0 +1,140 @@
+// Package zaplog adapts a zap logger to the *slog.Logger that eventlib,
+// redisqueue and middleware log through, so zap users keep their encoder,
+// sinks and level while callers who use log/slog never import zap
+package zaplog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a *slog.Logger that writes to l. Its level is l's, including
+// changes to a zap.AtomicLevel, and the caller is the line that logged.
+func New(l *zap.Logger) *slog.Logger {
+	return slog.New(&handler{core: l.Core()})
+}
+
+// handler is a slog.Handler that hands records to a zapcore.Core
+type handler struct {
+	core zapcore.Core
+}
+
+var _ slog.Handler = (*handler)(nil)
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.core.Enabled(zapLevel(level))
+}
+
+func (h *handler) Handle(_ context.Context, record slog.Record) error {
+	entry := zapcore.Entry{
+		Level:   zapLevel(record.Level),
+		Time:    record.Time,
+		Message: record.Message,
+	}
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		entry.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+	}
+
+	checked := h.core.Check(entry, nil)
+	if checked == nil {
+		return nil
+	}
+	fields := make([]zapcore.Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendField(fields, attr)
+		return true
+	})
+	checked.Write(fields...)
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []zapcore.Field
+	for _, attr := range attrs {
+		fields = appendField(fields, attr)
+	}
+	return &handler{core: h.core.With(fields)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &handler{core: h.core.With([]zapcore.Field{zap.Namespace(name)})}
+}
+
+// zapLevel maps a slog level to zap; levels between slog's own round down
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case level < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case level < slog.LevelError:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+// appendField appends attr as a zap field, keeping its type. An error is
+// logged as zap.Error logs it, and a group with no key is inlined.
+func appendField(fields []zapcore.Field, attr slog.Attr) []zapcore.Field {
+	value := attr.Value.Resolve()
+	if attr.Key == "" && value.Kind() != slog.KindGroup {
+		return fields
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return append(fields, zap.String(attr.Key, value.String()))
+	case slog.KindInt64:
+		return append(fields, zap.Int64(attr.Key, value.Int64()))
+	case slog.KindUint64:
+		return append(fields, zap.Uint64(attr.Key, value.Uint64()))
+	case slog.KindFloat64:
+		return append(fields, zap.Float64(attr.Key, value.Float64()))
+	case slog.KindBool:
+		return append(fields, zap.Bool(attr.Key, value.Bool()))
+	case slog.KindDuration:
+		return append(fields, zap.Duration(attr.Key, value.Duration()))
+	case slog.KindTime:
+		return append(fields, zap.Time(attr.Key, value.Time()))
+	case slog.KindGroup:
+		group := value.Group()
+		if attr.Key == "" {
+			for _, a := range group {
+				fields = appendField(fields, a)
+			}
+			return fields
+		}
+		if len(group) == 0 {
+			return fields
+		}
+		return append(fields, zap.Object(attr.Key, attrs(group)))
+	}
+
+	if err, ok := value.Any().(error); ok {
+		return append(fields, zap.NamedError(attr.Key, err))
+	}
+	return append(fields, zap.Any(attr.Key, value.Any()))
+}
+
+// attrs encodes a slog group as a zap object
+type attrs []slog.Attr
+
+func (a attrs) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var fields []zapcore.Field
+	for _, attr := range a {
+		fields = appendField(fields, attr)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package zaplog
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := New(zap.New(core)).With(slog.String("name", "ingest"))
+
+	logger.Debug("dropped")
+	logger.Warn("Event handler failed",
+		slog.Any("error", errors.New("unavailable")),
+		slog.Int("attempt", 2),
+		slog.Duration("duration", 3*time.Millisecond),
+		slog.Group("event", slog.String("source", "sensor-1")))
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want the warning only", len(entries))
+	}
+	entry := entries[0]
+	if entry.Level != zapcore.WarnLevel || entry.Message != "Event handler failed" {
+		t.Fatalf("logged %v %q", entry.Level, entry.Message)
+	}
+	if !entry.Caller.Defined {
+		t.Error("caller not recorded")
+	}
+
+	fields := entry.ContextMap()
+	want := map[string]any{
+		"name":     "ingest",
+		"error":    "unavailable",
+		"attempt":  int64(2),
+		"duration": 3 * time.Millisecond,
+		"event":    map[string]any{"source": "sensor-1"},
+	}
+	for key, value := range want {
+		got, ok := fields[key]
+		if !ok {
+			t.Errorf("field %s missing from %v", key, fields)
+			continue
+		}
+		if m, ok := value.(map[string]any); ok {
+			if g, _ := got.(map[string]any); len(g) != len(m) || g["source"] != m["source"] {
+				t.Errorf("%s = %v, want %v", key, got, value)
+			}
+			continue
+		}
+		if got != value {
+			t.Errorf("%s = %#v, want %#v", key, got, value)
+		}
+	}
+}
+
+func TestLevelFollowsZap(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.WarnLevel)
+	core, logs := observer.New(level)
+	logger := New(zap.New(core))
+
+	logger.Info("before")
+	level.SetLevel(zapcore.DebugLevel)
+	logger.Debug("after")
+
+	if entries := logs.AllUntimed(); len(entries) != 1 || entries[0].Message != "after" {
+		t.Fatalf("logged %v, want only the message after the level change", entries)
+	}
+}
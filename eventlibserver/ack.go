@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// ackCheckInterval is how often unacknowledged events are checked for
+	// redelivery
+	ackCheckInterval = time.Second
+
+	// ackIdleRetention is how long a disconnected subscriber's unacked
+	// events are kept for it to reconnect
+	ackIdleRetention = 10 * time.Minute
+
+	// maxSubscriberName bounds subscriber names, which label metrics
+	maxSubscriberName = 64
+
+	// ackReadLimit bounds the frames a subscriber sends, which carry acks
+	ackReadLimit = 64 << 10
+)
+
+var (
+	streamUnacked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_stream_unacked",
+		Help: "Events sent to each acknowledging subscriber and not yet acked",
+	}, []string{"subscriber"})
+
+	streamAcked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_stream_acked_total",
+		Help: "Total number of events acked by each subscriber",
+	}, []string{"subscriber"})
+
+	streamRedelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_stream_redelivered_total",
+		Help: "Total number of events sent again to each subscriber for want of an ack",
+	}, []string{"subscriber"})
+
+	streamAckExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_stream_ack_expired_total",
+		Help: "Total number of events given up on after every delivery attempt went unacked",
+	}, []string{"subscriber"})
+
+	streamLost = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_http_stream_lost_total",
+		Help: "Total number of events an acknowledging subscriber missed after they left the replay buffer",
+	})
+)
+
+func init() {
+	registerFeature("stream:ack")
+}
+
+// errSubscriberAttached is returned by attach for a subscriber that is
+// already connected
+var errSubscriberAttached = errors.New("subscriber is already connected")
+
+// errTooManySubscribers is returned by attach for a new subscriber once
+// StreamAckConfig.MaxSubscribers are known
+var errTooManySubscribers = errors.New("too many acknowledging subscribers")
+
+// Defaults for StreamAckConfig
+const (
+	ackDefaultTimeout    = 30 * time.Second
+	ackDefaultAttempts   = 5
+	ackDefaultMaxUnacked = 1000
+
+	ackDefaultMaxSubscribers = 100
+)
+
+// StreamAckConfig sets how events sent to acknowledging subscribers are
+// redelivered. Zero fields take the defaults.
+type StreamAckConfig struct {
+	// Timeout is how long an event may go unacked before it is sent again;
+	// default 30s
+	Timeout time.Duration
+
+	// MaxAttempts is how many times an event is sent before it is given
+	// up; default 5
+	MaxAttempts int
+
+	// MaxUnacked is how many events may be awaiting an ack; no more are
+	// sent until some are acked. Default 1000.
+	MaxUnacked int
+
+	// MaxSubscribers bounds the subscriber names kept, connected or
+	// within ackIdleRetention of leaving, since each labels metrics;
+	// default 100
+	MaxSubscribers int
+}
+
+// withDefaults checks c and fills in its zero fields
+func (c StreamAckConfig) withDefaults() (StreamAckConfig, error) {
+	if c.Timeout < 0 || c.MaxAttempts < 0 || c.MaxUnacked < 0 || c.MaxSubscribers < 0 {
+		return c, fmt.Errorf("stream ack settings must not be negative")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = ackDefaultTimeout
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = ackDefaultAttempts
+	}
+	if c.MaxUnacked == 0 {
+		c.MaxUnacked = ackDefaultMaxUnacked
+	}
+	if c.MaxSubscribers == 0 {
+		c.MaxSubscribers = ackDefaultMaxSubscribers
+	}
+	return c, nil
+}
+
+// AckMessage is a frame a subscriber sends to acknowledge events by their
+// stream IDs
+type AckMessage struct {
+	Ack []uint64 `json:"ack"`
+}
+
+// pendingAck is an event sent and not yet acked
+type pendingAck struct {
+	msg      EventMessage
+	attempts int
+	due      time.Time
+}
+
+// ackSubscriber tracks the events sent to one named subscriber until it
+// acks them. It outlives the subscriber's connection, so a subscriber that
+// reconnects gets its unacked events again.
+type ackSubscriber struct {
+	name   string
+	config StreamAckConfig
+
+	// acked is signalled when an ack makes room for more events
+	acked chan struct{}
+
+	mu         sync.Mutex
+	pending    map[uint64]*pendingAck
+	attached   bool
+	detachedAt time.Time
+
+	// delivered is the highest ID the subscriber has been sent or has had
+	// filtered out; its stream resumes after it
+	delivered uint64
+}
+
+// sent records msg as sent for the first time
+func (a *ackSubscriber) sent(msg EventMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending[msg.ID] = &pendingAck{
+		msg:      msg,
+		attempts: 1,
+		due:      time.Now().Add(a.config.Timeout),
+	}
+	a.delivered = max(a.delivered, msg.ID)
+	streamUnacked.WithLabelValues(a.name).Set(float64(len(a.pending)))
+}
+
+// ack forgets the events with the given IDs, ignoring unknown ones
+func (a *ackSubscriber) ack(ids []uint64) {
+	a.mu.Lock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := a.pending[id]; ok {
+			delete(a.pending, id)
+			n++
+		}
+	}
+	streamUnacked.WithLabelValues(a.name).Set(float64(len(a.pending)))
+	a.mu.Unlock()
+
+	if n == 0 {
+		return
+	}
+	streamAcked.WithLabelValues(a.name).Add(float64(n))
+	select {
+	case a.acked <- struct{}{}:
+	default:
+	}
+}
+
+// full reports whether no more events may be sent until some are acked
+func (a *ackSubscriber) full() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.pending) >= a.config.MaxUnacked
+}
+
+// advance records the events up to id as delivered without sending them,
+// as for events the subscriber's filter leaves out
+func (a *ackSubscriber) advance(id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delivered = max(a.delivered, id)
+}
+
+// deliveredID returns the highest ID delivered to the subscriber
+func (a *ackSubscriber) deliveredID() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.delivered
+}
+
+// due returns the events to send again, oldest first: those past their
+// timeout, or all of them when the subscriber has just reconnected. Events
+// that have used up their attempts are given up and counted in expired.
+func (a *ackSubscriber) due(all bool) (resend []EventMessage, expired int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for id, p := range a.pending {
+		if !all && now.Before(p.due) {
+			continue
+		}
+		if p.attempts >= a.config.MaxAttempts {
+			delete(a.pending, id)
+			expired++
+			continue
+		}
+		p.attempts++
+		p.due = now.Add(a.config.Timeout)
+		resend = append(resend, p.msg)
+	}
+	slices.SortFunc(resend, func(x, y EventMessage) int {
+		return cmp.Compare(x.ID, y.ID)
+	})
+
+	streamUnacked.WithLabelValues(a.name).Set(float64(len(a.pending)))
+	streamRedelivered.WithLabelValues(a.name).Add(float64(len(resend)))
+	streamAckExpired.WithLabelValues(a.name).Add(float64(expired))
+	return resend, expired
+}
+
+// ackRegistry holds the state of every acknowledging subscriber by name
+type ackRegistry struct {
+	config StreamAckConfig
+
+	mu   sync.Mutex
+	subs map[string]*ackSubscriber
+}
+
+func newAckRegistry(config StreamAckConfig) *ackRegistry {
+	return &ackRegistry{config: config, subs: make(map[string]*ackSubscriber)}
+}
+
+// attach returns the state of the named subscriber, creating it if need
+// be, and marks it connected. A new subscriber's stream starts after the
+// ID start. Subscribers gone for longer than ackIdleRetention are
+// forgotten along the way, and a new one is refused with
+// errTooManySubscribers while MaxSubscribers remain.
+func (r *ackRegistry) attach(name string, start uint64) (*ackSubscriber, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for other, sub := range r.subs {
+		sub.mu.Lock()
+		idle := !sub.attached && now.Sub(sub.detachedAt) > ackIdleRetention
+		sub.mu.Unlock()
+		if idle && other != name {
+			delete(r.subs, other)
+			streamUnacked.DeleteLabelValues(other)
+			streamAcked.DeleteLabelValues(other)
+			streamRedelivered.DeleteLabelValues(other)
+			streamAckExpired.DeleteLabelValues(other)
+		}
+	}
+
+	sub, ok := r.subs[name]
+	if !ok {
+		if len(r.subs) >= r.config.MaxSubscribers {
+			return nil, errTooManySubscribers
+		}
+		sub = &ackSubscriber{
+			name:      name,
+			config:    r.config,
+			acked:     make(chan struct{}, 1),
+			pending:   make(map[uint64]*pendingAck),
+			delivered: start,
+		}
+		r.subs[name] = sub
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.attached {
+		return nil, errSubscriberAttached
+	}
+	sub.attached = true
+	return sub, nil
+}
+
+// detach marks the subscriber disconnected, keeping its unacked events
+func (r *ackRegistry) detach(sub *ackSubscriber) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.attached = false
+	sub.detachedAt = time.Now()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// ingestPausedRetry is how long broker sources wait, and API clients are
+// told to wait, while ingestion is paused
+const ingestPausedRetry = time.Second
+
+var adminActions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_admin_actions_total",
+	Help: "Total number of runtime changes made through the admin API",
+}, []string{"action"})
+
+// ingest refuses new events while ingestion is paused, and caps the
+// request body at -max-request-size. The cap counts decompressed bytes,
+// since decompressMiddleware has already run.
+func (s *Server) ingest(next http.HandlerFunc) http.HandlerFunc {
+	return s.ingestStream(func(w http.ResponseWriter, r *http.Request) {
+		if s.maxRequestSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, s.maxRequestSize)
+		}
+		next(w, r)
+	})
+}
+
+// ingestStream refuses new events while ingestion is paused, leaving the
+// body uncapped for handlers that read it a line at a time
+func (s *Server) ingestStream(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.ingestPaused.Load() {
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, http.StatusServiceUnavailable, "Ingestion is paused")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writePaused answers a push refused because the processor's intake is
+// paused, which is usually shedding load while handlers are degraded
+func (s *Server) writePaused(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(ingestPausedRetry/time.Second)))
+	s.writeError(w, http.StatusServiceUnavailable, "Processor intake is paused")
+}
+
+// adminStatus reports the settings the admin API controls
+func (s *Server) adminStatus() AdminStatusResponse {
+	mode, interval := s.processingMode()
+	resp := AdminStatusResponse{
+		State:            s.processor.State(),
+		IngestPaused:     s.ingestPaused.Load(),
+		ProcessingMode:   mode,
+		ProcessThreshold: int(s.processThreshold.Load()),
+		QueueSize:        s.processor.QueueSize(),
+		Timestamp:        time.Now(),
+	}
+	if interval > 0 {
+		resp.ProcessInterval = interval.String()
+	}
+	if t, ok := s.processor.(eventlib.Tunable); ok {
+		maxSize := t.MaxQueueSize()
+		logging := t.Logging()
+		resp.MaxQueueSize = &maxSize
+		resp.Logging = &logging
+	}
+	if p, ok := s.processor.(eventlib.Pausable); ok {
+		paused := p.Paused()
+		resp.IntakePaused = &paused
+	}
+	return resp
+}
+
+// auditAdmin logs a runtime change and who made it
+func (s *Server) auditAdmin(r *http.Request, action string, fields ...zap.Field) {
+	adminActions.WithLabelValues(action).Inc()
+	if p := principalFrom(r.Context()); p != nil {
+		fields = append(fields, zap.String("principal", p.Name))
+	}
+	s.logger.Info("Admin "+action, fields...)
+}
+
+func (s *Server) handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminStart resumes processing and ingestion
+func (s *Server) handleAdminStart(w http.ResponseWriter, r *http.Request) {
+	if err := s.processor.Start(); err != nil {
+		s.writeError(w, http.StatusConflict, "Failed to start processor: "+err.Error())
+		return
+	}
+	s.ingestPaused.Store(false)
+	s.auditAdmin(r, "start")
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminPause stops processing; events are still accepted and wait
+// in the queue
+func (s *Server) handleAdminPause(w http.ResponseWriter, r *http.Request) {
+	if err := s.processor.Stop(); err != nil {
+		s.writeError(w, http.StatusConflict, "Failed to pause processor: "+err.Error())
+		return
+	}
+	s.auditAdmin(r, "pause")
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminStop stops processing and ingestion. The API answers 503 and
+// the broker sources hold their messages until started again.
+func (s *Server) handleAdminStop(w http.ResponseWriter, r *http.Request) {
+	if err := s.processor.Stop(); err != nil {
+		s.writeError(w, http.StatusConflict, "Failed to stop processor: "+err.Error())
+		return
+	}
+	s.ingestPaused.Store(true)
+	s.auditAdmin(r, "stop")
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminQueue changes the queue limit
+func (s *Server) handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.processor.(eventlib.Tunable)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support resizing the queue")
+		return
+	}
+
+	var req AdminQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxSize == nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if *req.MaxSize < 0 {
+		s.writeError(w, http.StatusBadRequest, "Max size cannot be negative")
+		return
+	}
+
+	old := t.MaxQueueSize()
+	if err := t.SetMaxQueueSize(*req.MaxSize); err != nil {
+		s.writeError(w, http.StatusConflict, "Failed to resize queue: "+err.Error())
+		return
+	}
+	s.auditAdmin(r, "resize_queue",
+		zap.Int("old_max_size", old),
+		zap.Int("max_size", *req.MaxSize))
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminLogging turns the processor's logging on or off
+func (s *Server) handleAdminLogging(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.processor.(eventlib.Tunable)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support toggling logging")
+		return
+	}
+
+	var req AdminLoggingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := t.SetLogging(*req.Enabled); err != nil {
+		s.writeError(w, http.StatusConflict, "Failed to change logging: "+err.Error())
+		return
+	}
+	s.auditAdmin(r, "logging", zap.Bool("enabled", *req.Enabled))
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminIntake pauses or resumes the processor's intake. Unlike
+// stop, queued events keep being processed, so this sheds load while
+// handlers are degraded.
+func (s *Server) handleAdminIntake(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.processor.(eventlib.Pausable)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support pausing intake")
+		return
+	}
+
+	var req AdminIntakeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Paused == nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if *req.Paused {
+		p.Pause()
+	} else {
+		p.Resume()
+	}
+	s.auditAdmin(r, "intake", zap.Bool("paused", *req.Paused))
+	s.writeJSON(w, http.StatusOK, s.adminStatus())
+}
+
+// handleAdminProcessing switches between manual, automatic and auto-tuned
+// processing, optionally changing the automatic interval and threshold
+func (s *Server) handleAdminProcessing(w http.ResponseWriter, r *http.Request) {
+	var req AdminProcessingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Interval != nil && *req.Interval < 0 {
+		s.writeError(w, http.StatusBadRequest, "Interval cannot be negative")
+		return
+	}
+	if req.Threshold != nil && *req.Threshold < 0 {
+		s.writeError(w, http.StatusBadRequest, "Threshold cannot be negative")
+		return
+	}
+
+	// Keep the old settings to restore if the new ones are refused
+	s.processing.mu.Lock()
+	oldInterval := s.processing.interval
+	if req.Interval != nil {
+		s.processing.interval = time.Duration(*req.Interval)
+	}
+	s.processing.mu.Unlock()
+	oldThreshold := s.processThreshold.Load()
+	if req.Threshold != nil {
+		s.processThreshold.Store(int64(*req.Threshold))
+	}
+
+	if err := s.setProcessing(req.Mode); err != nil {
+		s.processing.mu.Lock()
+		s.processing.interval = oldInterval
+		s.processing.mu.Unlock()
+		s.processThreshold.Store(oldThreshold)
+		s.writeError(w, http.StatusBadRequest, "Invalid processing mode: "+err.Error())
+		return
+	}
+
+	status := s.adminStatus()
+	s.auditAdmin(r, "processing",
+		zap.String("mode", status.ProcessingMode),
+		zap.String("interval", status.ProcessInterval),
+		zap.Int("threshold", status.ProcessThreshold))
+	s.writeJSON(w, http.StatusOK, status)
+}
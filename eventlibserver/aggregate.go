@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// Aggregation operations
+const (
+	AggregateCount           = "count"
+	AggregateSum             = "sum"
+	AggregateDistinctSources = "distinct_sources"
+)
+
+const (
+	// aggregateMetadataKey marks summary events with the rule that emitted
+	// them, so aggregates never count their own output
+	aggregateMetadataKey = "aggregate"
+
+	// aggregateSourcePrefix starts the source of summary events
+	aggregateSourcePrefix = "aggregate:"
+
+	// aggregateAllGroup is the group key of a rule without group_by
+	aggregateAllGroup = "*"
+)
+
+var aggregateEmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_aggregate_events_emitted_total",
+	Help: "Total number of summary events pushed by each aggregation rule, by result: ok or error",
+}, []string{"rule", "result"})
+
+func init() {
+	registerFeature("aggregates")
+}
+
+// AggregateConfig is the JSON file given with -aggregate-config
+type AggregateConfig struct {
+	Rules []AggregateRule `json:"rules"`
+}
+
+// AggregateRule summarizes the processed events it matches over a window
+type AggregateRule struct {
+	// Name identifies the rule in summary events, metrics and
+	// /api/v1/aggregates
+	Name string `json:"name"`
+
+	Types []eventlib.EventType `json:"types,omitempty"`
+
+	// Sources are glob patterns, as for HandleSource
+	Sources []string `json:"sources,omitempty"`
+
+	// Op is count, sum or distinct_sources
+	Op string `json:"op"`
+
+	// Field is the numeric JSON field summed by sum, with nested fields
+	// separated by dots, such as "reading.value"
+	Field string `json:"field,omitempty"`
+
+	// GroupBy splits the totals by "type", "source" or both
+	GroupBy []string `json:"group_by,omitempty"`
+
+	// Window is the span summarized
+	Window duration `json:"window"`
+
+	// Slide makes the window sliding, moving on by Slide, which must
+	// divide Window; by default the window is tumbling
+	Slide duration `json:"slide,omitempty"`
+
+	// Emit pushes a summary event per group as each window closes;
+	// default true
+	Emit *bool `json:"emit,omitempty"`
+
+	// EmitType is the type of summary events; default DATA
+	EmitType *eventlib.EventType `json:"emit_type,omitempty"`
+}
+
+// slide returns how far the window moves at a time
+func (r *AggregateRule) slide() time.Duration {
+	if r.Slide == 0 {
+		return time.Duration(r.Window)
+	}
+	return time.Duration(r.Slide)
+}
+
+// emits reports whether the rule pushes summary events
+func (r *AggregateRule) emits() bool {
+	return r.Emit == nil || *r.Emit
+}
+
+// loadAggregateConfig reads and checks an aggregation file
+func loadAggregateConfig(file string) (AggregateConfig, error) {
+	var config AggregateConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			return config, fmt.Errorf("%s: rule %d has no name", file, i)
+		}
+		if names[rule.Name] {
+			return config, fmt.Errorf("%s: duplicate rule %q", file, rule.Name)
+		}
+		names[rule.Name] = true
+		if err := rule.validate(); err != nil {
+			return config, fmt.Errorf("%s: rule %q: %w", file, rule.Name, err)
+		}
+	}
+	return config, nil
+}
+
+func (r *AggregateRule) validate() error {
+	switch r.Op {
+	case AggregateSum:
+		if r.Field == "" {
+			return fmt.Errorf("sum needs a field")
+		}
+	case AggregateCount, AggregateDistinctSources:
+	default:
+		return fmt.Errorf("unknown op %q", r.Op)
+	}
+	for _, pattern := range r.Sources {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad source pattern %q", pattern)
+		}
+	}
+	for _, g := range r.GroupBy {
+		if g != "type" && g != "source" {
+			return fmt.Errorf("unknown group_by %q", g)
+		}
+	}
+	switch {
+	case r.Window <= 0:
+		return fmt.Errorf("window must be positive")
+	case r.Slide < 0 || r.Slide > r.Window:
+		return fmt.Errorf("slide must be positive and at most the window")
+	case r.Window%duration(r.slide()) != 0:
+		return fmt.Errorf("slide must divide the window")
+	}
+	return nil
+}
+
+// aggregateBucket is one slide's worth of a group's events
+type aggregateBucket struct {
+	events  int
+	value   float64
+	sources map[string]struct{} // For distinct_sources
+}
+
+// aggregateGroup is a ring of buckets covering one window
+type aggregateGroup struct {
+	buckets []aggregateBucket
+}
+
+// value combines the group's buckets
+func (g *aggregateGroup) value(op string) float64 {
+	if op == AggregateDistinctSources {
+		seen := make(map[string]struct{})
+		for _, b := range g.buckets {
+			for source := range b.sources {
+				seen[source] = struct{}{}
+			}
+		}
+		return float64(len(seen))
+	}
+	var total float64
+	for _, b := range g.buckets {
+		total += b.value
+	}
+	return total
+}
+
+// empty reports whether the group has seen nothing for a whole window
+func (g *aggregateGroup) empty() bool {
+	for _, b := range g.buckets {
+		if b.events > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregator keeps one rule's window. The window is split into buckets a
+// slide long; each slide closes the current bucket, reports the window,
+// and reuses the oldest bucket.
+type aggregator struct {
+	rule    AggregateRule
+	buckets int // Slides per window
+
+	mu          sync.Mutex
+	groups      map[string]*aggregateGroup
+	current     int       // Bucket receiving events
+	windowStart time.Time // Start of the oldest bucket
+	windowEnd   time.Time // End of the current bucket
+}
+
+func newAggregator(rule AggregateRule, now time.Time) *aggregator {
+	slide := rule.slide()
+	buckets := int(time.Duration(rule.Window) / slide)
+	return &aggregator{
+		rule:        rule,
+		buckets:     buckets,
+		groups:      make(map[string]*aggregateGroup),
+		current:     buckets - 1,
+		windowStart: now.Add(slide - time.Duration(rule.Window)),
+		windowEnd:   now.Add(slide),
+	}
+}
+
+func (a *aggregator) matches(event eventlib.Event) bool {
+	r := &a.rule
+	if len(r.Types) > 0 && !slices.Contains(r.Types, event.Type) {
+		return false
+	}
+	if len(r.Sources) == 0 {
+		return true
+	}
+	for _, pattern := range r.Sources {
+		if ok, _ := path.Match(pattern, event.Source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// groupKey names the group event is counted in
+func (a *aggregator) groupKey(event eventlib.Event) string {
+	if len(a.rule.GroupBy) == 0 {
+		return aggregateAllGroup
+	}
+	parts := make([]string, len(a.rule.GroupBy))
+	for i, g := range a.rule.GroupBy {
+		if g == "type" {
+			parts[i] = event.Type.String()
+		} else {
+			parts[i] = event.Source
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// record adds event to the current bucket of its group
+func (a *aggregator) record(event eventlib.Event) {
+	if !a.matches(event) {
+		return
+	}
+	var amount float64
+	if a.rule.Op == AggregateSum {
+		var ok bool
+		if amount, ok = jsonNumber(event.Data, a.rule.Field); !ok {
+			return
+		}
+	}
+	key := a.groupKey(event)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	g, ok := a.groups[key]
+	if !ok {
+		g = &aggregateGroup{buckets: make([]aggregateBucket, a.buckets)}
+		a.groups[key] = g
+	}
+	b := &g.buckets[a.current]
+	b.events++
+	switch a.rule.Op {
+	case AggregateCount:
+		b.value++
+	case AggregateSum:
+		b.value += amount
+	case AggregateDistinctSources:
+		if b.sources == nil {
+			b.sources = make(map[string]struct{})
+		}
+		b.sources[event.Source] = struct{}{}
+	}
+}
+
+// aggregateValue is one group's value over a window
+type aggregateValue struct {
+	group string
+	value float64
+}
+
+// advance closes the current bucket and returns the window it ended, then
+// moves on to the next one. Groups idle for a whole window are forgotten.
+func (a *aggregator) advance() (start, end time.Time, values []aggregateValue) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start, end = a.windowStart, a.windowEnd
+	values = a.values()
+
+	slide := a.rule.slide()
+	a.windowStart = a.windowStart.Add(slide)
+	a.windowEnd = a.windowEnd.Add(slide)
+	a.current = (a.current + 1) % a.buckets
+	for key, g := range a.groups {
+		g.buckets[a.current] = aggregateBucket{}
+		if g.empty() {
+			delete(a.groups, key)
+		}
+	}
+	return start, end, values
+}
+
+// values returns each group's value so far, sorted by group. The caller
+// holds a.mu.
+func (a *aggregator) values() []aggregateValue {
+	values := make([]aggregateValue, 0, len(a.groups))
+	for key, g := range a.groups {
+		values = append(values, aggregateValue{group: key, value: g.value(a.rule.Op)})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].group < values[j].group })
+	return values
+}
+
+// status reports the window in progress
+func (a *aggregator) status() AggregateStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	resp := AggregateStatus{
+		Name:        a.rule.Name,
+		Op:          a.rule.Op,
+		Field:       a.rule.Field,
+		Window:      time.Duration(a.rule.Window).String(),
+		Slide:       a.rule.slide().String(),
+		WindowStart: a.windowStart,
+		WindowEnd:   a.windowEnd,
+		Groups:      []AggregateGroupValue{},
+	}
+	for _, v := range a.values() {
+		resp.Groups = append(resp.Groups, AggregateGroupValue{Group: v.group, Value: v.value})
+	}
+	return resp
+}
+
+// jsonNumber reads the numeric field at a dotted path in a JSON object
+func jsonNumber(data []byte, field string) (float64, bool) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return 0, false
+	}
+	for _, name := range strings.Split(field, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return 0, false
+		}
+		if doc, ok = obj[name]; !ok {
+			return 0, false
+		}
+	}
+	n, ok := doc.(float64)
+	return n, ok
+}
+
+// aggregates runs the aggregation rules over processed events
+type aggregates struct {
+	s     *Server
+	rules []*aggregator
+}
+
+func newAggregates(config AggregateConfig, s *Server) *aggregates {
+	now := time.Now()
+	a := &aggregates{s: s}
+	for _, rule := range config.Rules {
+		a.rules = append(a.rules, newAggregator(rule, now))
+	}
+	return a
+}
+
+// record counts a processed event in every rule it matches. Backfilled
+// events are history, outside any live window, and summary events are
+// never counted again.
+func (a *aggregates) record(event eventlib.Event) {
+	if event.Backfill {
+		return
+	}
+	if _, ok := event.Metadata[aggregateMetadataKey]; ok {
+		return
+	}
+	for _, rule := range a.rules {
+		rule.record(event)
+	}
+}
+
+// run closes each rule's windows on time until done is closed
+func (a *aggregates) run(done <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, rule := range a.rules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(rule.rule.slide())
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					start, end, values := rule.advance()
+					if rule.rule.emits() {
+						a.emit(rule.rule, start, end, values)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// emit pushes a summary event for each group of a closed window
+func (a *aggregates) emit(rule AggregateRule, start, end time.Time, values []aggregateValue) {
+	eventType := eventlib.EventTypeData
+	if rule.EmitType != nil {
+		eventType = *rule.EmitType
+	}
+	for _, v := range values {
+		data, _ := json.Marshal(AggregateSummary{
+			Rule:        rule.Name,
+			Op:          rule.Op,
+			Field:       rule.Field,
+			Group:       v.group,
+			Value:       v.value,
+			WindowStart: start,
+			WindowEnd:   end,
+		})
+		event := eventlib.Event{
+			Type:        eventType,
+			Source:      aggregateSourcePrefix + rule.Name,
+			Data:        data,
+			ContentType: "application/json",
+			Timestamp:   end,
+			Metadata: map[string]string{
+				aggregateMetadataKey: rule.Name,
+				"group":              v.group,
+			},
+		}
+		if err := a.s.processor.Push(event); err != nil {
+			aggregateEmitted.WithLabelValues(rule.Name, "error").Inc()
+			a.s.logger.Warn("Failed to push summary event",
+				zap.String("rule", rule.Name),
+				zap.String("group", v.group),
+				zap.Error(err))
+			continue
+		}
+		aggregateEmitted.WithLabelValues(rule.Name, "ok").Inc()
+	}
+}
+
+// handleAggregates reports the window in progress for each rule
+func (s *Server) handleAggregates(w http.ResponseWriter, r *http.Request) {
+	resp := AggregatesResponse{Aggregates: []AggregateStatus{}}
+	if s.aggregates != nil {
+		for _, rule := range s.aggregates.rules {
+			resp.Aggregates = append(resp.Aggregates, rule.status())
+		}
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// Kinds of alert target, by the payload they are sent
+const (
+	AlertTargetWebhook   = "webhook"
+	AlertTargetSlack     = "slack"
+	AlertTargetPagerDuty = "pagerduty"
+)
+
+const (
+	// pagerDutyEventsURL is the Events API v2 endpoint, the default URL
+	// of pagerduty targets
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+	alertQueueSize      = 100
+	alertRequestTimeout = 10 * time.Second
+	alertSendAttempts   = 3
+	alertRetryBackoff   = time.Second
+	alertCloseTimeout   = 10 * time.Second
+
+	// alertSweepInterval is how often counts for quiet sources are
+	// forgotten
+	alertSweepInterval = time.Minute
+)
+
+var (
+	alertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_alerts_fired_total",
+		Help: "Total number of alerts fired by each rule",
+	}, []string{"rule"})
+
+	alertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_alert_notifications_total",
+		Help: "Total number of alert notifications by target and result: sent, failed or dropped",
+	}, []string{"target", "result"})
+)
+
+func init() {
+	registerFeature("alerting")
+}
+
+// AlertConfig is the JSON file given with -alert-config. Targets are only
+// set in the file; rules can also be changed with the admin API.
+type AlertConfig struct {
+	Targets []AlertTarget `json:"targets"`
+	Rules   []AlertRule   `json:"rules"`
+}
+
+// AlertTarget is somewhere alerts are sent
+type AlertTarget struct {
+	// Name is how rules refer to the target
+	Name string `json:"name"`
+
+	// Kind is webhook, slack or pagerduty, choosing the payload; default
+	// webhook
+	Kind string `json:"kind,omitempty"`
+
+	// URL is the webhook or Slack incoming webhook URL; pagerduty targets
+	// default to the Events API v2
+	URL string `json:"url,omitempty"`
+
+	// RoutingKey is the PagerDuty integration key
+	RoutingKey string `json:"routing_key,omitempty"`
+
+	// Headers are added to every request, e.g. for authorization
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// AlertRule fires when more than Threshold matching events come from one
+// source within Window. Each source is counted separately.
+type AlertRule struct {
+	Name string `json:"name"`
+
+	// Types default to ERROR
+	Types []eventlib.EventType `json:"types,omitempty"`
+
+	// Sources are glob patterns, as for HandleSource; empty matches every
+	// source
+	Sources []string `json:"sources,omitempty"`
+
+	Threshold int      `json:"threshold"`
+	Window    duration `json:"window"`
+
+	// Cooldown is the least time between alerts for the same source;
+	// default Window
+	Cooldown duration `json:"cooldown,omitempty"`
+
+	// Notify names the targets alerts are sent to
+	Notify []string `json:"notify"`
+}
+
+func (r *AlertRule) validate(targets map[string]*AlertTarget) error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+	if r.Threshold < 0 {
+		return fmt.Errorf("threshold must not be negative")
+	}
+	if r.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if r.Cooldown < 0 {
+		return fmt.Errorf("cooldown must not be negative")
+	}
+	for _, pattern := range r.Sources {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad source pattern %q", pattern)
+		}
+	}
+	if len(r.Notify) == 0 {
+		return fmt.Errorf("notify names no targets")
+	}
+	for _, name := range r.Notify {
+		if targets[name] == nil {
+			return fmt.Errorf("unknown target %q", name)
+		}
+	}
+	return nil
+}
+
+func (t *AlertTarget) validate() error {
+	switch t.Kind {
+	case "":
+		t.Kind = AlertTargetWebhook
+	case AlertTargetWebhook, AlertTargetSlack:
+	case AlertTargetPagerDuty:
+		if t.RoutingKey == "" {
+			return fmt.Errorf("pagerduty target needs a routing_key")
+		}
+		if t.URL == "" {
+			t.URL = pagerDutyEventsURL
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", t.Kind)
+	}
+	if t.URL == "" {
+		return fmt.Errorf("target has no url")
+	}
+	return nil
+}
+
+// loadAlertConfig reads and checks an alerting file
+func loadAlertConfig(file string) (AlertConfig, error) {
+	var config AlertConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	targets := make(map[string]*AlertTarget)
+	for i := range config.Targets {
+		t := &config.Targets[i]
+		if t.Name == "" {
+			return config, fmt.Errorf("%s: target %d has no name", file, i)
+		}
+		if targets[t.Name] != nil {
+			return config, fmt.Errorf("%s: duplicate target %q", file, t.Name)
+		}
+		if err := t.validate(); err != nil {
+			return config, fmt.Errorf("%s: target %q: %w", file, t.Name, err)
+		}
+		targets[t.Name] = t
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range config.Rules {
+		if err := rule.validate(targets); err != nil {
+			return config, fmt.Errorf("%s: rule %d: %w", file, i, err)
+		}
+		if names[rule.Name] {
+			return config, fmt.Errorf("%s: duplicate rule %q", file, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return config, nil
+}
+
+// alertCount tracks one source for a rule
+type alertCount struct {
+	// recent holds the times of the last Threshold+1 matching events,
+	// oldest first; that is all it takes to tell whether more than
+	// Threshold fell within the window
+	recent    []time.Time
+	lastSeen  time.Time
+	lastFired time.Time
+}
+
+// alertState is a rule with its counts
+type alertState struct {
+	rule AlertRule
+
+	mu     sync.Mutex
+	counts map[string]*alertCount
+	fired  atomic.Uint64
+}
+
+func newAlertState(rule AlertRule) *alertState {
+	return &alertState{rule: rule, counts: make(map[string]*alertCount)}
+}
+
+func (as *alertState) matches(event eventlib.Event) bool {
+	types := as.rule.Types
+	if len(types) == 0 {
+		types = []eventlib.EventType{eventlib.EventTypeError}
+	}
+	if !slices.Contains(types, event.Type) {
+		return false
+	}
+	if len(as.rule.Sources) == 0 {
+		return true
+	}
+	for _, pattern := range as.rule.Sources {
+		if ok, _ := path.Match(pattern, event.Source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (as *alertState) cooldown() time.Duration {
+	if as.rule.Cooldown == 0 {
+		return time.Duration(as.rule.Window)
+	}
+	return time.Duration(as.rule.Cooldown)
+}
+
+// observe counts event, returning the alert to send if the rule fires
+func (as *alertState) observe(event eventlib.Event, now time.Time) *Alert {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	c := as.counts[event.Source]
+	if c == nil {
+		c = &alertCount{}
+		as.counts[event.Source] = c
+	}
+	c.lastSeen = now
+	c.recent = append(c.recent, now)
+	if len(c.recent) > as.rule.Threshold+1 {
+		c.recent = c.recent[1:]
+	}
+
+	window := time.Duration(as.rule.Window)
+	if len(c.recent) <= as.rule.Threshold || now.Sub(c.recent[0]) > window {
+		return nil
+	}
+	if !c.lastFired.IsZero() && now.Sub(c.lastFired) < as.cooldown() {
+		return nil
+	}
+	c.lastFired = now
+	as.fired.Add(1)
+
+	return &Alert{
+		Rule:      as.rule.Name,
+		Source:    event.Source,
+		Count:     len(c.recent),
+		Threshold: as.rule.Threshold,
+		Window:    window.String(),
+		FirstAt:   c.recent[0],
+		FiredAt:   now,
+		Event:     newEventMessage(event),
+		Summary: fmt.Sprintf("%s: more than %d %s events from %s within %s",
+			as.rule.Name, as.rule.Threshold, event.Type, event.Source, window),
+	}
+}
+
+// sweep forgets sources quiet for longer than the window and cooldown,
+// whose counts can no longer make a difference
+func (as *alertState) sweep(now time.Time) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	idle := max(time.Duration(as.rule.Window), as.cooldown())
+	for source, c := range as.counts {
+		if now.Sub(c.lastSeen) > idle {
+			delete(as.counts, source)
+		}
+	}
+}
+
+// status reports the rule and the sources it has fired for
+func (as *alertState) status() AlertRuleStatus {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	resp := AlertRuleStatus{
+		Name:      as.rule.Name,
+		Types:     as.rule.Types,
+		Sources:   as.rule.Sources,
+		Threshold: as.rule.Threshold,
+		Window:    time.Duration(as.rule.Window).String(),
+		Cooldown:  as.cooldown().String(),
+		Notify:    as.rule.Notify,
+		Fired:     as.fired.Load(),
+		Counts:    []AlertSourceStatus{},
+	}
+	window := time.Duration(as.rule.Window)
+	now := time.Now()
+	for source, c := range as.counts {
+		recent := 0
+		for _, t := range c.recent {
+			if now.Sub(t) <= window {
+				recent++
+			}
+		}
+		status := AlertSourceStatus{Source: source, Recent: recent}
+		if !c.lastFired.IsZero() {
+			lastFired := c.lastFired
+			status.LastFired = &lastFired
+		}
+		resp.Counts = append(resp.Counts, status)
+	}
+	slices.SortFunc(resp.Counts, func(a, b AlertSourceStatus) int {
+		return strings.Compare(a.Source, b.Source)
+	})
+	return resp
+}
+
+// alertNotice is an alert on its way to one target
+type alertNotice struct {
+	alert  Alert
+	target *AlertTarget
+}
+
+// alerter runs the alert rules over processed events and sends the alerts
+// they fire
+type alerter struct {
+	logger  *zap.Logger
+	client  *http.Client
+	targets map[string]*AlertTarget
+
+	mu    sync.RWMutex
+	rules []*alertState
+
+	notices chan alertNotice
+	closed  atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{} // Closed once the sender has finished
+}
+
+func newAlerter(config AlertConfig, logger *zap.Logger) *alerter {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &alerter{
+		logger:  logger,
+		client:  &http.Client{Timeout: alertRequestTimeout},
+		targets: make(map[string]*AlertTarget),
+		notices: make(chan alertNotice, alertQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	for i := range config.Targets {
+		a.targets[config.Targets[i].Name] = &config.Targets[i]
+	}
+	for _, rule := range config.Rules {
+		a.rules = append(a.rules, newAlertState(rule))
+	}
+	return a
+}
+
+// record counts a processed event in every rule it matches, queueing the
+// alerts that fire. A full queue drops alerts rather than hold up
+// processing.
+func (a *alerter) record(event eventlib.Event) {
+	now := time.Now()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed.Load() {
+		return
+	}
+	for _, rule := range a.rules {
+		if !rule.matches(event) {
+			continue
+		}
+		alert := rule.observe(event, now)
+		if alert == nil {
+			continue
+		}
+		alertsFired.WithLabelValues(rule.rule.Name).Inc()
+		a.logger.Warn("Alert fired",
+			zap.String("rule", alert.Rule),
+			zap.String("source", alert.Source),
+			zap.Int("count", alert.Count))
+		for _, name := range rule.rule.Notify {
+			select {
+			case a.notices <- alertNotice{alert: *alert, target: a.targets[name]}:
+			default:
+				alertNotifications.WithLabelValues(name, "dropped").Inc()
+			}
+		}
+	}
+}
+
+// run sends queued alerts and forgets quiet sources until done is closed
+func (a *alerter) run(done <-chan struct{}) {
+	go a.send()
+
+	ticker := time.NewTicker(alertSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.mu.RLock()
+			for _, rule := range a.rules {
+				rule.sweep(now)
+			}
+			a.mu.RUnlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// send delivers alerts until the queue is closed
+func (a *alerter) send() {
+	defer close(a.done)
+	for notice := range a.notices {
+		name := notice.target.Name
+		if err := a.notify(notice); err != nil {
+			alertNotifications.WithLabelValues(name, "failed").Inc()
+			a.logger.Error("Failed to send alert",
+				zap.String("rule", notice.alert.Rule),
+				zap.String("target", name),
+				zap.Error(err))
+			continue
+		}
+		alertNotifications.WithLabelValues(name, "sent").Inc()
+	}
+}
+
+// notify sends one alert, retrying network errors, 429s and 5xxs
+func (a *alerter) notify(notice alertNotice) error {
+	body, err := alertPayload(notice.alert, notice.target)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := a.post(notice.target, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= alertSendAttempts {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-time.After(alertRetryBackoff * time.Duration(attempt)):
+		case <-a.ctx.Done():
+			return fmt.Errorf("gave up retrying at shutdown: %w", err)
+		}
+	}
+}
+
+// post makes one request, reporting whether a failure is worth retrying
+func (a *alerter) post(target *AlertTarget, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	for k, v := range target.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return a.ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+// alertPayload renders alert in the shape target's kind expects
+func alertPayload(alert Alert, target *AlertTarget) ([]byte, error) {
+	switch target.Kind {
+	case AlertTargetSlack:
+		return json.Marshal(map[string]string{"text": ":rotating_light: " + alert.Summary})
+	case AlertTargetPagerDuty:
+		// Repeat alerts for a rule and source update one incident
+		return json.Marshal(map[string]any{
+			"routing_key":  target.RoutingKey,
+			"event_action": "trigger",
+			"dedup_key":    "eventlib:" + alert.Rule + ":" + alert.Source,
+			"payload": map[string]any{
+				"summary":        alert.Summary,
+				"source":         alert.Source,
+				"severity":       "error",
+				"timestamp":      alert.FiredAt.Format(time.RFC3339Nano),
+				"component":      "eventlib",
+				"class":          alert.Rule,
+				"custom_details": alert,
+			},
+		})
+	default:
+		return json.Marshal(alert)
+	}
+}
+
+// close stops recording and sends the queued alerts. Once the close
+// timeout passes, requests in flight are cancelled.
+func (a *alerter) close() {
+	a.mu.Lock()
+	if a.closed.Swap(true) {
+		a.mu.Unlock()
+		return
+	}
+	close(a.notices)
+	a.mu.Unlock()
+
+	timeout := time.AfterFunc(alertCloseTimeout, a.cancel)
+	defer timeout.Stop()
+	defer a.cancel()
+	<-a.done
+}
+
+// status lists the rules with their counts, in order
+func (a *alerter) status() AlertsResponse {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	resp := AlertsResponse{Rules: []AlertRuleStatus{}}
+	for _, rule := range a.rules {
+		resp.Rules = append(resp.Rules, rule.status())
+	}
+	for name := range a.targets {
+		resp.Targets = append(resp.Targets, name)
+	}
+	slices.Sort(resp.Targets)
+	return resp
+}
+
+func (s *Server) handleGetAlerts(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		s.writeError(w, http.StatusNotImplemented, "Alerting is not configured")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.alerts.status())
+}
+
+// handlePutAlert adds a rule or replaces the one of the same name, until
+// the next restart. A replaced rule's counts start again from zero.
+func (s *Server) handlePutAlert(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		s.writeError(w, http.StatusNotImplemented, "Alerting is not configured")
+		return
+	}
+
+	name := mux.Vars(r)["rule"]
+	var rule AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if rule.Name == "" {
+		rule.Name = name
+	}
+	if rule.Name != name {
+		s.writeError(w, http.StatusBadRequest, "Rule name does not match the URL")
+		return
+	}
+	if err := rule.validate(s.alerts.targets); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid alert rule: "+err.Error())
+		return
+	}
+
+	state := newAlertState(rule)
+	a := s.alerts
+	a.mu.Lock()
+	i := slices.IndexFunc(a.rules, func(as *alertState) bool { return as.rule.Name == name })
+	status := http.StatusOK
+	if i >= 0 {
+		a.rules[i] = state
+	} else {
+		a.rules = append(a.rules, state)
+		status = http.StatusCreated
+	}
+	a.mu.Unlock()
+
+	s.auditAdmin(r, "alert_rule",
+		zap.String("rule", name),
+		zap.Int("threshold", rule.Threshold),
+		zap.Duration("window", time.Duration(rule.Window)))
+	s.writeJSON(w, status, state.status())
+}
+
+func (s *Server) handleDeleteAlert(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		s.writeError(w, http.StatusNotImplemented, "Alerting is not configured")
+		return
+	}
+
+	name := mux.Vars(r)["rule"]
+	a := s.alerts
+	a.mu.Lock()
+	i := slices.IndexFunc(a.rules, func(as *alertState) bool { return as.rule.Name == name })
+	if i >= 0 {
+		a.rules = slices.Delete(a.rules, i, i+1)
+	}
+	a.mu.Unlock()
+
+	if i < 0 {
+		s.writeError(w, http.StatusNotFound, "Alert rule not found")
+		return
+	}
+	s.auditAdmin(r, "alert_rule_delete", zap.String("rule", name))
+	s.writeJSON(w, http.StatusOK, map[string]string{"status": "deleted", "rule": name})
+}
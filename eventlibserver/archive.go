@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Archive file formats
+const (
+	ArchiveNDJSON  = "ndjson"
+	ArchiveParquet = "parquet"
+)
+
+const (
+	archiveDefaultMaxSize = 64 << 20
+	archiveDefaultMaxAge  = 5 * time.Minute
+
+	// archiveUploadQueue is how many finished files may wait for upload
+	// before the archive holds up its broadcast subscription
+	archiveUploadQueue = 4
+
+	archiveUploadAttempts = 3
+	archiveRetryBackoff   = time.Second
+	archiveCloseTimeout   = 30 * time.Second
+
+	// archiveRecordOverhead approximates the JSON framing of an event, for
+	// the size trigger
+	archiveRecordOverhead = 128
+
+	// Endpoints for s3:// and gs:// URLs without -archive-endpoint
+	archiveS3Endpoint  = "s3.amazonaws.com"
+	archiveGCSEndpoint = "storage.googleapis.com"
+)
+
+var (
+	archiveFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_archive_files_total",
+		Help: "Total number of archive files by result: uploaded or failed",
+	}, []string{"result"})
+
+	archiveEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_archive_events_total",
+		Help: "Total number of events in archive files by result: uploaded or failed",
+	}, []string{"result"})
+
+	archiveBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_archive_bytes_total",
+		Help: "Total number of compressed bytes uploaded to the archive",
+	})
+
+	archiveUploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "eventlibgo_archive_upload_duration_seconds",
+		Help:    "Time taken by each archive upload, including retries",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	archivePending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "eventlibgo_archive_pending_events",
+		Help: "Processed events buffered for the next archive files",
+	})
+)
+
+func init() {
+	registerFeature("archive")
+}
+
+// ArchiveConfig sets up the archive of processed events, from the
+// -archive-* flags
+type ArchiveConfig struct {
+	// URL is s3://bucket/prefix, gs://bucket/prefix, or a local directory
+	// as a path or file:// URL
+	URL string
+
+	// Format is ndjson, gzipped, or parquet, zstd-compressed
+	Format string
+
+	// MaxSize is the approximate uncompressed size at which a partition's
+	// file is finished; default 64 MiB
+	MaxSize int64
+
+	// MaxAge is the longest a file collects events before it is finished;
+	// default 5m
+	MaxAge time.Duration
+
+	// Endpoint overrides the S3 endpoint, for S3-compatible stores such as
+	// MinIO; an http:// endpoint turns TLS off
+	Endpoint string
+
+	// Region is the bucket's region, if the store needs it
+	Region string
+}
+
+func (c *ArchiveConfig) validate() error {
+	switch c.Format {
+	case "":
+		c.Format = ArchiveNDJSON
+	case ArchiveNDJSON, ArchiveParquet:
+	default:
+		return fmt.Errorf("unknown archive format %q", c.Format)
+	}
+	if c.MaxSize < 0 || c.MaxAge < 0 {
+		return fmt.Errorf("archive max size and age must not be negative")
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = archiveDefaultMaxSize
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = archiveDefaultMaxAge
+	}
+	return nil
+}
+
+// archiveStore is where finished files go
+type archiveStore interface {
+	put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// newArchiveStore opens the store config.URL names
+func newArchiveStore(config ArchiveConfig) (archiveStore, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := config.URL
+		if u.Scheme == "file" {
+			dir = u.Path
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create archive directory: %w", err)
+		}
+		return localArchive{dir: dir}, nil
+	case "s3", "gs":
+	default:
+		return nil, fmt.Errorf("archive URL must be s3://, gs://, file:// or a path, not %s://", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("archive URL %s has no bucket", config.URL)
+	}
+
+	endpoint, secure := archiveS3Endpoint, true
+	if u.Scheme == "gs" {
+		endpoint = archiveGCSEndpoint
+	}
+	if config.Endpoint != "" {
+		endpoint = config.Endpoint
+		if e, err := url.Parse(config.Endpoint); err == nil && e.Host != "" {
+			endpoint, secure = e.Host, e.Scheme != "http"
+		}
+	}
+
+	// GCS takes HMAC keys through its S3-compatible API, so both read the
+	// usual AWS variables
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive client: %w", err)
+	}
+	return &objectArchive{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+// localArchive writes files under a directory
+type localArchive struct {
+	dir string
+}
+
+// put writes the file under a temporary name first, so readers never see
+// a partial file
+func (l localArchive) put(_ context.Context, key string, data []byte, _ string) error {
+	file := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// objectArchive uploads files to an S3-compatible bucket
+type objectArchive struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (o *objectArchive) put(ctx context.Context, key string, data []byte, contentType string) error {
+	if o.prefix != "" {
+		key = o.prefix + "/" + key
+	}
+	_, err := o.client.PutObject(ctx, o.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// archiveRecord is an event as a Parquet row
+type archiveRecord struct {
+	ID            uint64            `parquet:"id"`
+	EventID       string            `parquet:"event_id,optional"`
+	Type          string            `parquet:"type,dict"`
+	Source        string            `parquet:"source,dict"`
+	Data          []byte            `parquet:"data,optional"`
+	ContentType   string            `parquet:"content_type,optional"`
+	Timestamp     int64             `parquet:"timestamp,timestamp(millisecond)"`
+	CorrelationID string            `parquet:"correlation_id,optional"`
+	Metadata      map[string]string `parquet:"metadata,optional"`
+}
+
+// archivePartition collects the events of one dt=/type= partition
+type archivePartition struct {
+	key     string // dt=YYYY-MM-DD/type=X
+	events  []EventMessage
+	size    int64
+	started time.Time
+}
+
+// archiveFile is a finished partition waiting for upload
+type archiveFile struct {
+	key    string
+	events []EventMessage
+}
+
+// archiver batches processed events into files per partition and uploads
+// them when they reach MaxSize or MaxAge
+type archiver struct {
+	config ArchiveConfig
+	store  archiveStore
+	logger *zap.Logger
+
+	mu         sync.Mutex
+	partitions map[string]*archivePartition
+	seq        atomic.Uint64
+
+	// publish holds closeMu for reading until its file is queued, so
+	// close cannot shut uploads under it
+	closeMu sync.RWMutex
+	closed  bool
+
+	uploads chan archiveFile
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{} // Closed once the uploader has finished
+	stop    chan struct{} // Stops the age trigger
+	stopped chan struct{} // Closed once the age trigger has stopped
+}
+
+func newArchiver(config ArchiveConfig, logger *zap.Logger) (*archiver, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	store, err := newArchiveStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &archiver{
+		config:     config,
+		store:      store,
+		logger:     logger,
+		partitions: make(map[string]*archivePartition),
+		uploads:    make(chan archiveFile, archiveUploadQueue),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	return a, nil
+}
+
+// start runs the uploader and the age trigger
+func (a *archiver) start() {
+	go a.upload()
+	go a.expire()
+}
+
+// partitionKey is the Hive-style partition of msg: the UTC date of its
+// timestamp and its type
+func partitionKey(msg EventMessage) string {
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return "dt=" + ts.UTC().Format(time.DateOnly) + "/type=" + url.PathEscape(msg.Type)
+}
+
+// archiveSize approximates the size msg adds to a file
+func archiveSize(msg EventMessage) int64 {
+	size := len(msg.Data) + len(msg.Source) + len(msg.Type) + len(msg.EventID) +
+		len(msg.ContentType) + len(msg.CorrelationID) + archiveRecordOverhead
+	for k, v := range msg.Metadata {
+		size += len(k) + len(v)
+	}
+	return int64(size)
+}
+
+// publish adds msg to its partition, finishing the partition's file once
+// it reaches MaxSize. It waits while the upload queue is full. Events
+// published after close are counted as failed.
+func (a *archiver) publish(msg EventMessage) {
+	a.closeMu.RLock()
+	defer a.closeMu.RUnlock()
+	if a.closed {
+		archiveEvents.WithLabelValues("failed").Inc()
+		return
+	}
+
+	key := partitionKey(msg)
+
+	a.mu.Lock()
+	p, ok := a.partitions[key]
+	if !ok {
+		p = &archivePartition{key: key, started: time.Now()}
+		a.partitions[key] = p
+	}
+	p.events = append(p.events, msg)
+	p.size += archiveSize(msg)
+	var file *archiveFile
+	if p.size >= a.config.MaxSize {
+		file = a.finish(p)
+	}
+	a.mu.Unlock()
+
+	archivePending.Inc()
+	if file != nil {
+		a.uploads <- *file
+	}
+}
+
+// finish takes p's events as a file. The caller holds a.mu.
+func (a *archiver) finish(p *archivePartition) *archiveFile {
+	delete(a.partitions, p.key)
+	return &archiveFile{key: p.key, events: p.events}
+}
+
+// expire finishes partitions older than MaxAge
+func (a *archiver) expire() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(min(a.config.MaxAge, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, file := range a.take(func(p *archivePartition) bool {
+				return time.Since(p.started) >= a.config.MaxAge
+			}) {
+				a.uploads <- file
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// take finishes the partitions ready accepts
+func (a *archiver) take(ready func(*archivePartition) bool) []archiveFile {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var files []archiveFile
+	for _, p := range a.partitions {
+		if ready(p) {
+			files = append(files, *a.finish(p))
+		}
+	}
+	return files
+}
+
+// upload encodes and stores finished files until uploads is closed
+func (a *archiver) upload() {
+	defer close(a.done)
+	for file := range a.uploads {
+		archivePending.Sub(float64(len(file.events)))
+
+		err := a.storeFile(file)
+		result := "uploaded"
+		if err != nil {
+			result = "failed"
+			a.logger.Error("Failed to archive events",
+				zap.String("partition", file.key),
+				zap.Int("events", len(file.events)),
+				zap.Error(err))
+		}
+		archiveFiles.WithLabelValues(result).Inc()
+		archiveEvents.WithLabelValues(result).Add(float64(len(file.events)))
+	}
+}
+
+// storeFile encodes one file and stores it, retrying failed uploads
+func (a *archiver) storeFile(file archiveFile) error {
+	data, ext, contentType, err := a.encode(file.events)
+	if err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+	key := fmt.Sprintf("%s/part-%s-%06d.%s", file.key,
+		time.Now().UTC().Format("20060102T150405Z"), a.seq.Add(1), ext)
+
+	start := time.Now()
+	defer func() { archiveUploadDuration.Observe(time.Since(start).Seconds()) }()
+
+	for attempt := 1; ; attempt++ {
+		err = a.store.put(a.ctx, key, data, contentType)
+		if err == nil {
+			archiveBytes.Add(float64(len(data)))
+			a.logger.Debug("Archived events",
+				zap.String("key", key),
+				zap.Int("events", len(file.events)),
+				zap.Int("bytes", len(data)))
+			return nil
+		}
+		if attempt == archiveUploadAttempts || a.ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(archiveRetryBackoff * time.Duration(attempt)):
+		case <-a.ctx.Done():
+			return errors.Join(err, a.ctx.Err())
+		}
+	}
+}
+
+// encode writes events in the configured format, returning the file, its
+// extension and content type
+func (a *archiver) encode(events []EventMessage) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	if a.config.Format == ArchiveParquet {
+		rows := make([]archiveRecord, len(events))
+		for i, msg := range events {
+			rows[i] = archiveRecord{
+				ID:            msg.ID,
+				EventID:       msg.EventID,
+				Type:          msg.Type,
+				Source:        msg.Source,
+				Data:          msg.Data,
+				ContentType:   msg.ContentType,
+				Timestamp:     msg.Timestamp.UnixMilli(),
+				CorrelationID: msg.CorrelationID,
+				Metadata:      msg.Metadata,
+			}
+		}
+		w := parquet.NewGenericWriter[archiveRecord](&buf, parquet.Compression(&parquet.Zstd))
+		if _, err := w.Write(rows); err != nil {
+			return nil, "", "", err
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "parquet", "application/vnd.apache.parquet", nil
+	}
+
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, msg := range events {
+		if err := enc.Encode(msg); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "ndjson.gz", "application/gzip", nil
+}
+
+// close finishes every partition and waits for the uploads. Once the
+// close timeout passes, uploads in flight are cancelled and the rest fail.
+func (a *archiver) close() {
+	a.closeMu.Lock()
+	a.closed = true
+	a.closeMu.Unlock()
+
+	close(a.stop)
+	<-a.stopped
+	for _, file := range a.take(func(*archivePartition) bool { return true }) {
+		a.uploads <- file
+	}
+	close(a.uploads)
+
+	timeout := time.AfterFunc(archiveCloseTimeout, a.cancel)
+	defer timeout.Stop()
+	defer a.cancel()
+	<-a.done
+}
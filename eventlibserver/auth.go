@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Scopes checked by the API routes
+const (
+	scopeEventsWrite      = "events:write"
+	scopeEventsRead       = "events:read"
+	scopeStatusRead       = "status:read"
+	scopeAdminProcess     = "admin:process"
+	scopeAdminDiagnostics = "admin:diagnostics"
+	scopeAdminControl     = "admin:control"
+)
+
+var authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_auth_failures_total",
+	Help: "Total number of requests rejected by authentication or authorization",
+}, []string{"reason"})
+
+// AuthConfig is the -auth-config file. Credentials are granted roles, and
+// roles map to scopes.
+type AuthConfig struct {
+	// Roles maps a role name to the scopes it grants
+	Roles map[string][]string `json:"roles"`
+
+	APIKeys []APIKeyConfig `json:"api_keys"`
+	JWT     *JWTConfig     `json:"jwt,omitempty"`
+}
+
+// APIKeyConfig is one accepted API key. Prefer KeySHA256 so the file does
+// not hold the key itself.
+type APIKeyConfig struct {
+	Name      string   `json:"name"`
+	Key       string   `json:"key,omitempty"`
+	KeySHA256 string   `json:"key_sha256,omitempty"`
+	Roles     []string `json:"roles"`
+}
+
+// JWTConfig validates bearer tokens. Tokens name their roles in the
+// "roles" claim; scopes in a space-separated "scope" claim are granted too.
+type JWTConfig struct {
+	// HMACSecret verifies HS256/384/512 tokens
+	HMACSecret string `json:"hmac_secret,omitempty"`
+
+	// PublicKeyFile is a PEM RSA or ECDSA key for RS*/ES* tokens
+	PublicKeyFile string `json:"public_key_file,omitempty"`
+
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+// principal is an authenticated caller
+type principal struct {
+	Name   string
+	Scopes map[string]bool
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller authenticated by requireScope, or nil
+// when auth is disabled
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// authState is an immutable, loaded AuthConfig
+type authState struct {
+	roles   map[string][]string
+	keys    map[string]APIKeyConfig // By hex SHA-256 of the key
+	jwtKey  interface{}
+	jwtOpts []jwt.ParserOption
+}
+
+// authenticator checks credentials against the current auth config, which
+// can be swapped at runtime
+type authenticator struct {
+	path   string
+	logger *zap.Logger
+	state  atomic.Pointer[authState]
+}
+
+func newAuthenticator(path string, logger *zap.Logger) (*authenticator, error) {
+	a := &authenticator{path: path, logger: logger}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload re-reads the config file; on error the previous config stays
+func (a *authenticator) reload() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", a.path, err)
+	}
+
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", a.path, err)
+	}
+
+	state, err := newAuthState(config)
+	if err != nil {
+		return fmt.Errorf("invalid auth config %s: %w", a.path, err)
+	}
+
+	a.state.Store(state)
+	a.logger.Info("Auth config loaded",
+		zap.String("path", a.path),
+		zap.Int("api_keys", len(state.keys)),
+		zap.Bool("jwt", state.jwtKey != nil))
+	return nil
+}
+
+func newAuthState(config AuthConfig) (*authState, error) {
+	state := &authState{
+		roles: config.Roles,
+		keys:  make(map[string]APIKeyConfig),
+	}
+
+	for _, key := range config.APIKeys {
+		hash := strings.ToLower(key.KeySHA256)
+		if key.Key != "" {
+			sum := sha256.Sum256([]byte(key.Key))
+			hash = hex.EncodeToString(sum[:])
+		}
+		if hash == "" {
+			return nil, fmt.Errorf("api key %q has neither key nor key_sha256", key.Name)
+		}
+		if err := state.checkRoles(key.Roles); err != nil {
+			return nil, fmt.Errorf("api key %q: %w", key.Name, err)
+		}
+		state.keys[hash] = key
+	}
+
+	if config.JWT != nil {
+		if err := state.configureJWT(*config.JWT); err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+func (st *authState) checkRoles(roles []string) error {
+	for _, role := range roles {
+		if _, ok := st.roles[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+func (st *authState) configureJWT(config JWTConfig) error {
+	switch {
+	case config.HMACSecret != "" && config.PublicKeyFile != "":
+		return errors.New("jwt: set hmac_secret or public_key_file, not both")
+	case config.HMACSecret != "":
+		st.jwtKey = []byte(config.HMACSecret)
+		st.jwtOpts = append(st.jwtOpts, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	case config.PublicKeyFile != "":
+		pem, err := os.ReadFile(config.PublicKeyFile)
+		if err != nil {
+			return fmt.Errorf("jwt: %w", err)
+		}
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			st.jwtKey = key
+			st.jwtOpts = append(st.jwtOpts, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			st.jwtKey = key
+			st.jwtOpts = append(st.jwtOpts, jwt.WithValidMethods([]string{"ES256", "ES384", "ES512"}))
+		} else {
+			return fmt.Errorf("jwt: %s is not an RSA or ECDSA public key", config.PublicKeyFile)
+		}
+	default:
+		return errors.New("jwt: hmac_secret or public_key_file is required")
+	}
+
+	st.jwtOpts = append(st.jwtOpts, jwt.WithExpirationRequired())
+	if config.Issuer != "" {
+		st.jwtOpts = append(st.jwtOpts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		st.jwtOpts = append(st.jwtOpts, jwt.WithAudience(config.Audience))
+	}
+	return nil
+}
+
+// scopesFor expands roles into a scope set
+func (st *authState) scopesFor(roles []string) map[string]bool {
+	scopes := make(map[string]bool)
+	for _, role := range roles {
+		for _, scope := range st.roles[role] {
+			scopes[scope] = true
+		}
+	}
+	return scopes
+}
+
+// tokenClaims are the JWT claims the server understands
+type tokenClaims struct {
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// authenticate identifies the caller from an API key or bearer token
+func (a *authenticator) authenticate(r *http.Request) (*principal, error) {
+	state := a.state.Load()
+
+	credential := r.Header.Get("X-API-Key")
+	if credential == "" {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return nil, errors.New("missing credentials")
+		}
+		credential = strings.TrimSpace(bearer)
+	}
+
+	// JWTs are three dot-separated segments; anything else is an API key
+	if strings.Count(credential, ".") == 2 && state.jwtKey != nil {
+		return state.authenticateJWT(credential)
+	}
+
+	sum := sha256.Sum256([]byte(credential))
+	key, ok := state.keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, errors.New("unknown api key")
+	}
+	return &principal{Name: key.Name, Scopes: state.scopesFor(key.Roles)}, nil
+}
+
+func (st *authState) authenticateJWT(raw string) (*principal, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return st.jwtKey, nil
+	}, st.jwtOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	p := &principal{Name: claims.Subject, Scopes: st.scopesFor(claims.Roles)}
+	for _, scope := range strings.Fields(claims.Scope) {
+		p.Scopes[scope] = true
+	}
+	return p, nil
+}
+
+// requireScope wraps a handler so only callers holding scope reach it.
+// Without -auth-config every request is let through.
+func (s *Server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			next(w, r)
+			return
+		}
+
+		p, err := s.auth.authenticate(r)
+		if err != nil {
+			authFailures.WithLabelValues("unauthenticated").Inc()
+			s.logger.Debug("Authentication failed",
+				zap.String("path", r.URL.Path), zap.Error(err))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="eventlib"`)
+			s.writeError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		if !p.Scopes[scope] {
+			authFailures.WithLabelValues("forbidden").Inc()
+			s.logger.Info("Request denied",
+				zap.String("principal", p.Name),
+				zap.String("path", r.URL.Path),
+				zap.String("scope", scope))
+			s.writeError(w, http.StatusForbidden, fmt.Sprintf("Missing scope %s", scope))
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Processing modes
+const (
+	// ProcessingManual processes events only when /process is called
+	ProcessingManual = "manual"
+
+	// ProcessingAuto drains the queue on an interval, a threshold or both
+	ProcessingAuto = "auto"
+
+	// ProcessingAutotune drains the queue at a rate chosen by the
+	// auto-tuner
+	ProcessingAutotune = "autotune"
+)
+
+// processLoop is a running automatic processing loop
+type processLoop struct {
+	mode string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// processingControl runs at most one automatic processing loop, so the
+// mode can be switched while the server runs
+type processingControl struct {
+	mu       sync.Mutex
+	loop     *processLoop
+	interval time.Duration
+	autotune *AutotuneConfig
+}
+
+// setProcessing stops any running loop and starts the one for mode
+func (s *Server) setProcessing(mode string) error {
+	pc := &s.processing
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	var run func(stop <-chan struct{})
+	switch mode {
+	case ProcessingManual:
+	case ProcessingAuto:
+		if pc.interval <= 0 && s.processThreshold.Load() <= 0 {
+			return errors.New("automatic processing needs an interval or a threshold")
+		}
+		interval := pc.interval
+		run = func(stop <-chan struct{}) { s.runProcessLoop(interval, stop) }
+	case ProcessingAutotune:
+		if pc.autotune == nil {
+			return errors.New("auto-tuning was not configured at startup")
+		}
+		run = newAutotuner(*pc.autotune, s).run
+	default:
+		return fmt.Errorf("unknown processing mode %q", mode)
+	}
+
+	if pc.loop != nil {
+		close(pc.loop.stop)
+		<-pc.loop.done
+		pc.loop = nil
+	}
+	if run != nil {
+		loop := &processLoop{
+			mode: mode,
+			stop: make(chan struct{}),
+			done: make(chan struct{}),
+		}
+		go func() {
+			defer close(loop.done)
+			run(loop.stop)
+		}()
+		pc.loop = loop
+	}
+	return nil
+}
+
+// processingMode returns the current processing mode and loop interval
+func (s *Server) processingMode() (mode string, interval time.Duration) {
+	pc := &s.processing
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.loop == nil {
+		return ProcessingManual, pc.interval
+	}
+	return pc.loop.mode, pc.interval
+}
+
+// runProcessLoop drains the queue every interval, and early whenever a push
+// leaves the queue at or above the threshold, until stopped or the server
+// is closed
+func (s *Server) runProcessLoop(interval time.Duration, stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	s.logger.Info("Automatic processing enabled",
+		zap.Duration("interval", interval),
+		zap.Int64("threshold", s.processThreshold.Load()))
+
+	for {
+		select {
+		case <-tick:
+		case <-s.wake:
+		case <-stop:
+			return
+		case <-s.done:
+			return
+		}
+
+		if s.processor.QueueSize() == 0 {
+			continue
+		}
+
+		start := time.Now()
+		s.processor.ProcessAll()
+		processingDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+// notifyPushed wakes the processing loop once the queue reaches the
+// threshold; it never blocks the caller
+func (s *Server) notifyPushed() {
+	threshold := int(s.processThreshold.Load())
+	if threshold <= 0 || s.processor.QueueSize() < threshold {
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
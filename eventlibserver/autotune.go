@@ -0,0 +1,190 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+var (
+	autotuneWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_autotune_workers",
+		Help: "Processing concurrency chosen by the auto-tuner",
+	})
+
+	autotuneBatchSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_autotune_batch_size",
+		Help: "Events per worker per tick chosen by the auto-tuner",
+	})
+
+	autotuneAdjustments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_autotune_adjustments_total",
+		Help: "Total number of auto-tuner capacity adjustments",
+	}, []string{"direction"})
+)
+
+// AutotuneConfig bounds the processing-rate auto-tuner
+type AutotuneConfig struct {
+	// TargetDepth is the queue depth the tuner tries to stay under
+	TargetDepth int
+
+	// Interval between control decisions and processing rounds
+	Interval time.Duration
+
+	MinWorkers int
+	MaxWorkers int
+	MinBatch   int
+	MaxBatch   int
+
+	// Hysteresis is the fraction of TargetDepth around the target within
+	// which capacity is left unchanged, to avoid oscillating
+	Hysteresis float64
+}
+
+// autotuner scales processing capacity (workers x batch size) so that queue
+// depth stays near the target: capacity grows while the queue is above the
+// band and shrinks while it is below, and every tick drains up to capacity.
+type autotuner struct {
+	config  AutotuneConfig
+	server  *Server
+	workers int
+	batch   int
+}
+
+func newAutotuner(config AutotuneConfig, server *Server) *autotuner {
+	if config.MinWorkers < 1 {
+		config.MinWorkers = 1
+	}
+	if config.MaxWorkers < config.MinWorkers {
+		config.MaxWorkers = config.MinWorkers
+	}
+	if config.MinBatch < 1 {
+		config.MinBatch = 1
+	}
+	if config.MaxBatch < config.MinBatch {
+		config.MaxBatch = config.MinBatch
+	}
+	if config.Interval <= 0 {
+		config.Interval = 100 * time.Millisecond
+	}
+
+	return &autotuner{
+		config:  config,
+		server:  server,
+		workers: config.MinWorkers,
+		batch:   config.MinBatch,
+	}
+}
+
+// run drives the control loop until stopped or the server is closed
+func (a *autotuner) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	a.publish()
+
+	for {
+		select {
+		case <-ticker.C:
+			depth := a.server.processor.QueueSize()
+			a.adjust(depth)
+			if depth > 0 {
+				a.drain()
+			}
+		case <-stop:
+			return
+		case <-a.server.done:
+			return
+		}
+	}
+}
+
+// adjust updates capacity based on the observed queue depth
+func (a *autotuner) adjust(depth int) {
+	target := float64(a.config.TargetDepth)
+	band := target * a.config.Hysteresis
+
+	switch {
+	case float64(depth) > target+band:
+		if !a.scaleUp() {
+			return
+		}
+		autotuneAdjustments.WithLabelValues("up").Inc()
+	case float64(depth) < target-band:
+		if !a.scaleDown() {
+			return
+		}
+		autotuneAdjustments.WithLabelValues("down").Inc()
+	default:
+		return
+	}
+
+	a.publish()
+	a.server.logger.Debug("Auto-tuner adjusted capacity",
+		zap.Int("queue_depth", depth),
+		zap.Int("workers", a.workers),
+		zap.Int("batch", a.batch))
+}
+
+// scaleUp grows the batch first, then adds workers once batches are maxed
+func (a *autotuner) scaleUp() bool {
+	if a.batch < a.config.MaxBatch {
+		a.batch = min(a.batch*2, a.config.MaxBatch)
+		return true
+	}
+	if a.workers < a.config.MaxWorkers {
+		a.workers++
+		return true
+	}
+	return false
+}
+
+// scaleDown sheds workers first, then shrinks the batch
+func (a *autotuner) scaleDown() bool {
+	if a.workers > a.config.MinWorkers {
+		a.workers--
+		return true
+	}
+	if a.batch > a.config.MinBatch {
+		a.batch = max(a.batch/2, a.config.MinBatch)
+		return true
+	}
+	return false
+}
+
+// drain processes up to workers x batch events concurrently. Backends
+// that can take a batch in one call do, rather than one call per event.
+func (a *autotuner) drain() {
+	start := time.Now()
+	bp, batched := a.server.processor.(eventlib.BatchProcessor)
+
+	var wg sync.WaitGroup
+	for i := 0; i < a.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if batched {
+				bp.ProcessN(a.batch)
+				return
+			}
+			for j := 0; j < a.batch; j++ {
+				if a.server.processor.QueueSize() == 0 {
+					return
+				}
+				a.server.processor.Process()
+			}
+		}()
+	}
+	wg.Wait()
+
+	processingDuration.Observe(time.Since(start).Seconds())
+}
+
+func (a *autotuner) publish() {
+	autotuneWorkers.Set(float64(a.workers))
+	autotuneBatchSize.Set(float64(a.batch))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Kinds of broadcast subscriber, labelling their metrics
+const (
+	subscriberWebSocket = "websocket"
+	subscriberSSE       = "sse"
+	subscriberKafka     = "kafka"
+	subscriberWebhooks  = "webhooks"
+)
+
+const (
+	broadcastReplaySize = 1024
+
+	// broadcastSinkBuffer is the queue for the Kafka output and webhooks,
+	// which absorb bursts in their own queues as well
+	broadcastSinkBuffer = 1024
+)
+
+var (
+	broadcastSubscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_broadcast_subscribers",
+		Help: "Current number of subscribers to processed events, by kind",
+	}, []string{"kind"})
+
+	broadcastDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_broadcast_dropped_total",
+		Help: "Total number of processed events dropped for subscribers that fell behind, by kind",
+	}, []string{"kind"})
+)
+
+// broadcastSubscriber is one registered output, reading from its own
+// bounded queue
+type broadcastSubscriber struct {
+	kind string
+	ch   chan EventMessage
+}
+
+// broadcaster fans processed events out to the live outputs: stream
+// clients, the Kafka output and webhooks. Publishing never blocks; each
+// subscriber has a bounded queue, and events that do not fit are dropped
+// for that subscriber alone. Each message gets a sequence ID, and the most
+// recent ones are kept so that reconnecting clients can resume where they
+// left off.
+type broadcaster struct {
+	mu          sync.RWMutex
+	subscribers map[*broadcastSubscriber]struct{}
+	closed      bool
+
+	nextID uint64
+	replay []EventMessage
+	start  int
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		subscribers: make(map[*broadcastSubscriber]struct{}),
+		replay:      make([]EventMessage, 0, broadcastReplaySize),
+	}
+}
+
+// subscribe registers a subscriber of the given kind with a queue of size
+// events. Once the broadcaster is closed, the subscriber's channel is
+// returned already closed.
+func (b *broadcaster) subscribe(kind string, size int) *broadcastSubscriber {
+	sub := &broadcastSubscriber{kind: kind, ch: make(chan EventMessage, size)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub
+	}
+	b.subscribers[sub] = struct{}{}
+
+	broadcastSubscribers.WithLabelValues(kind).Inc()
+	if isStreamSubscriber(kind) {
+		streamClients.Inc()
+	}
+	return sub
+}
+
+// unsubscribe removes sub; its channel is left for the garbage collector
+func (b *broadcaster) unsubscribe(sub *broadcastSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+
+	broadcastSubscribers.WithLabelValues(sub.kind).Dec()
+	if isStreamSubscriber(sub.kind) {
+		streamClients.Dec()
+	}
+}
+
+// size returns the number of subscribers of the given kinds
+func (b *broadcaster) size(kinds ...string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := 0
+	for sub := range b.subscribers {
+		for _, kind := range kinds {
+			if sub.kind == kind {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// publish numbers msg and delivers it to every subscriber without
+// blocking the caller. It returns msg with its ID.
+func (b *broadcaster) publish(msg EventMessage) EventMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return msg
+	}
+
+	b.nextID++
+	msg.ID = b.nextID
+
+	if len(b.replay) < broadcastReplaySize {
+		b.replay = append(b.replay, msg)
+	} else {
+		b.replay[b.start] = msg
+		b.start = (b.start + 1) % broadcastReplaySize
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- msg:
+		default:
+			broadcastDropped.WithLabelValues(sub.kind).Inc()
+			if isStreamSubscriber(sub.kind) {
+				streamDropped.Inc()
+			}
+		}
+	}
+	return msg
+}
+
+// lastID returns the ID of the most recent message
+func (b *broadcaster) lastID() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nextID
+}
+
+// since returns the retained messages with IDs after lastID, oldest first
+func (b *broadcaster) since(lastID uint64) []EventMessage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var out []EventMessage
+	for i := range b.replay {
+		msg := b.replay[(b.start+i)%len(b.replay)]
+		if msg.ID > lastID {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+// close closes every subscriber's channel, once what is queued has been
+// read, and refuses further events and subscribers
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	for sub := range b.subscribers {
+		close(sub.ch)
+		delete(b.subscribers, sub)
+
+		broadcastSubscribers.WithLabelValues(sub.kind).Dec()
+		if isStreamSubscriber(sub.kind) {
+			streamClients.Dec()
+		}
+	}
+}
+
+// isStreamSubscriber reports whether kind is a streaming client, counted
+// in the older eventlibgo_http_stream_* metrics too
+func isStreamSubscriber(kind string) bool {
+	return kind == subscriberWebSocket || kind == subscriberSSE
+}
+
+// addSink subscribes an output such as the Kafka output, calling publish
+// for each event on its own goroutine so a slow sink cannot hold up the
+// processor. Close waits for sinks to finish what is queued.
+func (s *Server) addSink(kind string, publish func(msg EventMessage)) {
+	sub := s.broadcast.subscribe(kind, broadcastSinkBuffer)
+	s.sinks.Add(1)
+	go func() {
+		defer s.sinks.Done()
+		for msg := range sub.ch {
+			publish(msg)
+		}
+	}()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+func init() {
+	registerFeature("chaos")
+}
+
+// ChaosConfig sets how often each fault is injected, as a probability
+// from 0 to 1
+type ChaosConfig struct {
+	// QueueFull answers pushes 503 as if the queue were full
+	QueueFull float64 `json:"queue_full"`
+
+	// CallFailure fails pushes as if the C library could not allocate
+	CallFailure float64 `json:"call_failure"`
+
+	// SlowHandler delays processing each event by SlowHandlerDelay
+	// (default 1s)
+	SlowHandler      float64  `json:"slow_handler"`
+	SlowHandlerDelay duration `json:"slow_handler_delay,omitempty"`
+
+	// HandlerPanic makes handlers panic, so events are retried or
+	// dead-lettered
+	HandlerPanic float64 `json:"handler_panic"`
+}
+
+func (c ChaosConfig) faults() eventlib.FaultConfig {
+	return eventlib.FaultConfig{
+		QueueFull:        c.QueueFull,
+		CallFailure:      c.CallFailure,
+		SlowHandler:      c.SlowHandler,
+		SlowHandlerDelay: time.Duration(c.SlowHandlerDelay),
+		HandlerPanic:     c.HandlerPanic,
+	}
+}
+
+func (s *Server) chaosStatus() ChaosResponse {
+	config := s.faults.Config()
+	counts := s.faults.Counts()
+	return ChaosResponse{
+		QueueFull:        config.QueueFull,
+		CallFailure:      config.CallFailure,
+		SlowHandler:      config.SlowHandler,
+		SlowHandlerDelay: config.SlowHandlerDelay.String(),
+		HandlerPanic:     config.HandlerPanic,
+		Injected: ChaosCounts{
+			QueueFull:    counts.QueueFull,
+			CallFailure:  counts.CallFailure,
+			SlowHandler:  counts.SlowHandler,
+			HandlerPanic: counts.HandlerPanic,
+		},
+	}
+}
+
+// requireChaos answers 501 unless the server was started with -chaos
+func (s *Server) requireChaos(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.faults == nil {
+			s.writeError(w, http.StatusNotImplemented, "Fault injection is disabled; start the server with -chaos")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleGetChaos(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.chaosStatus())
+}
+
+// handlePutChaos replaces the fault rates; omitted faults stop
+func (s *Server) handlePutChaos(w http.ResponseWriter, r *http.Request) {
+	var config ChaosConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := s.faults.Set(config.faults()); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid chaos config: "+err.Error())
+		return
+	}
+
+	s.auditAdmin(r, "chaos",
+		zap.Float64("queue_full", config.QueueFull),
+		zap.Float64("call_failure", config.CallFailure),
+		zap.Float64("slow_handler", config.SlowHandler),
+		zap.Float64("handler_panic", config.HandlerPanic))
+	s.writeJSON(w, http.StatusOK, s.chaosStatus())
+}
+
+// handleDeleteChaos stops injecting faults
+func (s *Server) handleDeleteChaos(w http.ResponseWriter, r *http.Request) {
+	s.faults.Set(eventlib.FaultConfig{})
+	s.auditAdmin(r, "stop chaos")
+	s.writeJSON(w, http.StatusOK, s.chaosStatus())
+}
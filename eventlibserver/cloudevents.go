@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// CloudEvents 1.0 HTTP binding
+const (
+	cloudEventsSpecVersion     = "1.0"
+	contentTypeCloudEvent      = "application/cloudevents+json"
+	contentTypeCloudEventBatch = "application/cloudevents-batch+json"
+	cloudEventsHeaderPrefix    = "Ce-"
+
+	// formatCloudEvents selects CloudEvents output on streams and webhooks
+	formatCloudEvents = "cloudevents"
+)
+
+func init() {
+	registerFeature("cloudevents")
+}
+
+// CloudEvent is the structured JSON form of a CloudEvents 1.0 event
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+
+	// CorrelationID is the correlationid extension attribute
+	CorrelationID string `json:"correlationid,omitempty"`
+
+	// Sequence is the sequence extension attribute, carrying the stream
+	// ID that acknowledging subscribers ack
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// isCloudEventRequest reports whether a request carries a CloudEvent in
+// the structured or the binary binding
+func isCloudEventRequest(r *http.Request) bool {
+	return mediaTypeOf(r) == contentTypeCloudEvent || r.Header.Get(cloudEventsHeaderPrefix+"Specversion") != ""
+}
+
+// readCloudEvent reads a CloudEvent request. In the structured binding the
+// body is the event; in the binary binding the attributes are ce- headers
+// and the body is the data.
+func readCloudEvent(r *http.Request) (EventRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return EventRequest{}, err
+	}
+
+	var ce CloudEvent
+	if mediaTypeOf(r) == contentTypeCloudEvent {
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return EventRequest{}, err
+		}
+	} else {
+		header := func(name string) string {
+			return r.Header.Get(cloudEventsHeaderPrefix + name)
+		}
+		ce = CloudEvent{
+			SpecVersion:     header("Specversion"),
+			ID:              header("Id"),
+			Source:          header("Source"),
+			Type:            header("Type"),
+			DataContentType: r.Header.Get("Content-Type"),
+			DataBase64:      body,
+			CorrelationID:   header("Correlationid"),
+		}
+		if v := header("Time"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return EventRequest{}, fmt.Errorf("invalid ce-time %q", v)
+			}
+			ce.Time = &t
+		}
+	}
+	return ce.toEventRequest()
+}
+
+// readCloudEventBatch reads an application/cloudevents-batch+json body
+func readCloudEventBatch(r *http.Request) (BatchEventRequest, error) {
+	var req BatchEventRequest
+
+	var batch []CloudEvent
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		return req, err
+	}
+	for i, ce := range batch {
+		e, err := ce.toEventRequest()
+		if err != nil {
+			return req, fmt.Errorf("event %d: %w", i, err)
+		}
+		req.Events = append(req.Events, e)
+	}
+	return req, nil
+}
+
+// toEventRequest maps a CloudEvent onto an event. The type becomes the
+// event type if it names one, and DATA otherwise; the CloudEvent id is the
+// event's ID, so retried deliveries are caught by -dedup-window.
+func (ce CloudEvent) toEventRequest() (EventRequest, error) {
+	switch {
+	case ce.SpecVersion != cloudEventsSpecVersion:
+		return EventRequest{}, fmt.Errorf("unsupported specversion %q", ce.SpecVersion)
+	case ce.ID == "":
+		return EventRequest{}, errors.New("missing id")
+	case ce.Source == "":
+		return EventRequest{}, errors.New("missing source")
+	case ce.Type == "":
+		return EventRequest{}, errors.New("missing type")
+	case len(ce.Data) > 0 && len(ce.DataBase64) > 0:
+		return EventRequest{}, errors.New("data and data_base64 are mutually exclusive")
+	}
+
+	eventType, err := eventlib.ParseEventType(ce.Type)
+	if err != nil {
+		eventType = eventlib.EventTypeData
+	}
+
+	req := EventRequest{
+		Type:        eventType,
+		Source:      ce.Source,
+		ID:          ce.ID,
+		ContentType: ce.DataContentType,
+		Timestamp:   ce.Time,
+		Data:        ce.DataBase64,
+
+		CorrelationID: ce.CorrelationID,
+	}
+
+	// Structured data is JSON unless datacontenttype says otherwise, in
+	// which case it must be a JSON string holding the payload
+	if len(ce.Data) > 0 && !bytes.Equal(ce.Data, []byte("null")) {
+		if ce.DataContentType == "" || isJSONContentType(ce.DataContentType) {
+			req.DataJSON = ce.Data
+		} else {
+			var text string
+			if err := json.Unmarshal(ce.Data, &text); err != nil {
+				return EventRequest{}, fmt.Errorf("data for %s must be a string or data_base64", ce.DataContentType)
+			}
+			req.Data = []byte(text)
+		}
+	}
+	return req, req.validate()
+}
+
+// newCloudEvent renders a processed event as a structured CloudEvent. The
+// id is the producer's event ID if it had one, otherwise the stream
+// sequence, otherwise random.
+func newCloudEvent(msg EventMessage) CloudEvent {
+	ce := CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              msg.EventID,
+		Source:          msg.Source,
+		Type:            msg.Type,
+		DataContentType: msg.ContentType,
+		Time:            &msg.Timestamp,
+		CorrelationID:   msg.CorrelationID,
+	}
+	if ce.ID == "" && msg.ID > 0 {
+		ce.ID = strconv.FormatUint(msg.ID, 10)
+	}
+	if ce.ID == "" {
+		ce.ID = randomEventID()
+	}
+
+	if len(msg.Data) > 0 {
+		if isJSONContentType(msg.ContentType) && json.Valid(msg.Data) {
+			ce.Data = msg.Data
+		} else {
+			ce.DataBase64 = msg.Data
+		}
+	}
+	return ce
+}
+
+func randomEventID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// wantsCloudEvents reports whether a stream subscriber asked for
+// CloudEvents with ?format=cloudevents
+func wantsCloudEvents(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("format"), formatCloudEvents)
+}
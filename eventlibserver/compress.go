@@ -0,0 +1,276 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest response worth compressing; shorter
+// ones are sent as they are
+const compressMinSize = 1024
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// decompressBody replaces the body of a request sent with a gzip or
+// deflate Content-Encoding by its decompressed stream. Limits applied to
+// r.Body afterwards count decompressed bytes, so a small compressed body
+// cannot expand past them.
+func decompressBody(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return fmt.Errorf("invalid gzip body: %w", err)
+		}
+		body = gz
+	case "deflate":
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return fmt.Errorf("invalid deflate body: %w", err)
+		}
+		body = zr
+	default:
+		return errUnsupportedEncoding
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, r.Body}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// errUnsupportedEncoding is returned by decompressBody for a
+// Content-Encoding other than gzip or deflate
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decompressMiddleware decodes gzip and deflate request bodies, refusing
+// other encodings with 415
+func (s *Server) decompressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := decompressBody(r)
+		switch {
+		case errors.Is(err, errUnsupportedEncoding):
+			s.writeError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding; use gzip or deflate")
+			return
+		case err != nil:
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if the client accepts neither
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch name {
+		case "*":
+			name = "gzip"
+		case "gzip", "deflate":
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressMiddleware compresses responses for clients that send a
+// matching Accept-Encoding. WebSocket upgrades, which compress with
+// permessage-deflate instead, are left alone.
+func (s *Server) compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds back a response until it is long enough to be
+// worth compressing, then compresses it if its type allows. A Flush
+// before then commits to compression, so streams stay compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	pending []byte
+
+	// Set once the choice is made: enc when compressing, plain when not
+	enc   io.WriteCloser
+	plain bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	switch {
+	case cw.enc != nil:
+		return cw.enc.Write(p)
+	case cw.plain:
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.pending = append(cw.pending, p...)
+	if len(cw.pending) >= compressMinSize {
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start chooses whether to compress and writes the header and anything
+// held back
+func (cw *compressWriter) start() error {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.pending) > 0 {
+		// Sniff now, as net/http would otherwise sniff the compressed bytes
+		header.Set("Content-Type", http.DetectContentType(cw.pending))
+	}
+	if header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) && bodyAllowed(cw.status) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.enc = gz
+		} else {
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.enc = zw
+		}
+	} else {
+		cw.plain = true
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	pending := cw.pending
+	cw.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	_, err := cw.Write(pending)
+	return err
+}
+
+// close sends a short response as it is, or finishes the compressed one
+func (cw *compressWriter) close() {
+	switch {
+	case cw.enc == nil && !cw.plain:
+		if cw.status == 0 && len(cw.pending) == 0 {
+			return
+		}
+		cw.plain = true
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.ResponseWriter.WriteHeader(cw.status)
+		if len(cw.pending) > 0 {
+			cw.ResponseWriter.Write(cw.pending)
+		}
+	case cw.enc != nil:
+		cw.enc.Close()
+		switch enc := cw.enc.(type) {
+		case *gzip.Writer:
+			gzipWriters.Put(enc)
+		case *zlib.Writer:
+			zlibWriters.Put(enc)
+		}
+		cw.enc = nil
+		cw.plain = true
+	}
+}
+
+// Flush sends what has been written so far, compressed if the response
+// is; server-sent events rely on it
+func (cw *compressWriter) Flush() {
+	if cw.enc == nil && !cw.plain {
+		if err := cw.start(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// compressible reports whether a response of this type gains from
+// compression; archives, images and the like are already compressed
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"),
+		strings.HasSuffix(mediaType, "xml"),
+		mediaType == "application/javascript",
+		mediaType == "application/x-protobuf",
+		mediaType == "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// bodyAllowed reports whether a response with this status has a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix marks environment overrides: EVENTLIB_QUEUE_SIZE sets
+// -queue-size
+const configEnvPrefix = "EVENTLIB_"
+
+// reloadableFlags take effect on SIGHUP; the rest need a restart
+var reloadableFlags = map[string]bool{
+	"rate-limit":        true,
+	"rate-burst":        true,
+	"ip-rate-limit":     true,
+	"ip-rate-burst":     true,
+	"rate-limit-config": true,
+	"process-threshold": true,
+	"tls-cert":          true,
+	"tls-key":           true,
+	"client-ca":         true,
+	"log-level":         true,
+	"queue-size":        true,
+	"queue-mode":        true,
+}
+
+// fileConfig layers a YAML or TOML file and EVENTLIB_* environment
+// variables under the command line. Keys are flag names; nested tables are
+// joined with "-", so tls: {cert: ...} sets -tls-cert. Precedence, highest
+// first: command line, environment, file, flag default.
+type fileConfig struct {
+	path string
+
+	// explicit holds flags given on the command line, which always win
+	explicit map[string]bool
+}
+
+// newFileConfig records which flags were set on the command line. Call it
+// after flag.Parse.
+func newFileConfig(path string) *fileConfig {
+	c := &fileConfig{path: path, explicit: make(map[string]bool)}
+	flag.Visit(func(f *flag.Flag) {
+		c.explicit[f.Name] = true
+	})
+	return c
+}
+
+// envName returns the environment variable overriding a flag
+func envName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// values reads the file and environment, keyed by flag name. Flags given
+// on the command line are left out.
+func (c *fileConfig) values() (map[string]string, error) {
+	values := make(map[string]string)
+
+	if c.path != "" {
+		data, err := os.ReadFile(c.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", c.path, err)
+		}
+
+		var raw map[string]any
+		switch ext := strings.ToLower(filepath.Ext(c.path)); ext {
+		case ".toml":
+			err = toml.Unmarshal(data, &raw)
+		case ".yaml", ".yml", ".json":
+			err = yaml.Unmarshal(data, &raw)
+		default:
+			return nil, fmt.Errorf("unsupported config format %q: use .yaml, .yml, .toml or .json", ext)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", c.path, err)
+		}
+
+		if err := flattenConfig("", raw, values); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.path, err)
+		}
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			values[f.Name] = value
+		}
+	})
+
+	for name := range values {
+		if name == "config" {
+			return nil, fmt.Errorf("config cannot be set from a config file")
+		}
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting %q", name)
+		}
+		if c.explicit[name] {
+			delete(values, name)
+		}
+	}
+	return values, nil
+}
+
+// flattenConfig turns nested tables into flag names and values into flag
+// syntax; lists become comma-separated
+func flattenConfig(prefix string, raw map[string]any, values map[string]string) error {
+	for key, value := range raw {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flattenConfig(name, v, values); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case nil:
+			return fmt.Errorf("setting %q has no value", name)
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// validateConfig parses values into a scratch copy of the flags, returning each
+// in the flag's own canonical form
+func validateConfig(values map[string]string) (map[string]string, error) {
+	scratch := flag.NewFlagSet("config", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		getter, ok := f.Value.(flag.Getter)
+		if !ok {
+			return
+		}
+		switch v := getter.Get().(type) {
+		case bool:
+			scratch.Bool(f.Name, v, "")
+		case int:
+			scratch.Int(f.Name, v, "")
+		case int64:
+			scratch.Int64(f.Name, v, "")
+		case uint:
+			scratch.Uint(f.Name, v, "")
+		case uint64:
+			scratch.Uint64(f.Name, v, "")
+		case float64:
+			scratch.Float64(f.Name, v, "")
+		case string:
+			scratch.String(f.Name, v, "")
+		case time.Duration:
+			scratch.Duration(f.Name, v, "")
+		}
+	})
+
+	canonical := make(map[string]string, len(values))
+	for name, value := range values {
+		if err := scratch.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		canonical[name] = scratch.Lookup(name).Value.String()
+	}
+	return canonical, nil
+}
+
+// apply sets flags from the file and environment at startup
+func (c *fileConfig) apply() error {
+	values, err := c.values()
+	if err != nil {
+		return err
+	}
+	values, err = validateConfig(values)
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// reload re-reads the file and environment. Reloadable flags that changed
+// are set and returned; other changes are only reported, since they need a
+// restart. Settings removed from the file revert to their defaults. Nothing
+// is changed if any value is invalid.
+func (c *fileConfig) reload() (changed, restart []string, err error) {
+	values, err := c.values()
+	if err != nil {
+		return nil, nil, err
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := values[f.Name]; !ok && !c.explicit[f.Name] && f.Name != "config" {
+			values[f.Name] = f.DefValue
+		}
+	})
+	values, err = validateConfig(values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f.Value.String() == value {
+			continue
+		}
+		if !reloadableFlags[name] {
+			restart = append(restart, name)
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	sort.Strings(restart)
+	return changed, restart, nil
+}
+
+// reloadConfig returns the SIGHUP reloader for the config file, applying
+// changed settings to the running server
+func (s *Server) reloadConfig(config *fileConfig, certs *certReloader) func() error {
+	return func() error {
+		changed, restart, err := config.reload()
+		if err != nil {
+			return err
+		}
+		if len(restart) > 0 {
+			s.logger.Warn("Changed settings need a restart to take effect",
+				zap.Strings("settings", restart))
+		}
+
+		restartQueue := false
+		for _, name := range changed {
+			switch name {
+			case "rate-limit", "rate-burst", "ip-rate-limit", "ip-rate-burst", "rate-limit-config":
+				limits, err := rateLimitsFromFlags()
+				if err != nil {
+					return err
+				}
+				s.limits.update(limits)
+			case "process-threshold":
+				s.processThreshold.Store(int64(*processThreshold))
+			case "log-level":
+				if s.logLevel != nil {
+					if err := s.logLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+						return fmt.Errorf("invalid log-level: %w", err)
+					}
+				}
+			case "tls-cert", "tls-key", "client-ca":
+				// The tls reloader picks up the new files
+				if certs == nil {
+					s.logger.Warn("TLS can only be enabled at startup")
+				}
+			case "queue-size", "queue-mode":
+				restartQueue = true
+			}
+		}
+
+		// The queue is recreated once for both, keeping its events
+		if restartQueue {
+			mode, err := parseQueueMode(*queueMode)
+			if err != nil {
+				return fmt.Errorf("invalid queue-mode: %w", err)
+			}
+			requeued, _, took, err := s.restartProcessor(func(c *eventlib.EngineConfig) {
+				c.MaxQueueSize = *queueSize
+				c.QueueMode = mode
+			})
+			if err != nil {
+				return fmt.Errorf("failed to restart processor: %w", err)
+			}
+			s.logger.Info("Processor restarted",
+				zap.Int("max_queue_size", *queueSize),
+				zap.Stringer("queue_mode", mode),
+				zap.Int("requeued", requeued),
+				zap.Duration("duration", took))
+		}
+
+		if len(changed) > 0 {
+			s.logger.Info("Applied config changes", zap.Strings("settings", changed))
+		}
+		return nil
+	}
+}
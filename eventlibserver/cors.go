@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// routeMethods are the methods checked against the routes when answering
+// OPTIONS
+var routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+func init() {
+	registerFeature("cors")
+}
+
+// CORSConfig lets browser pages on other origins call the API
+type CORSConfig struct {
+	// AllowedOrigins are origins such as https://dash.example.com, with
+	// * standing for any run of characters, as in https://*.example.com.
+	// A lone * allows every origin.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are what preflight requests may
+	// ask for; "*" in AllowedHeaders allows any header
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers pages may read beyond the
+	// CORS-safelisted ones
+	ExposedHeaders []string
+
+	// AllowCredentials lets pages send cookies and Authorization headers.
+	// The origin is then echoed instead of answering *.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight answer
+	MaxAge time.Duration
+}
+
+// corsPolicy is a checked CORSConfig
+type corsPolicy struct {
+	config    CORSConfig
+	anyOrigin bool
+	anyHeader bool
+	origins   []string // Lowercased patterns
+	methods   []string
+	headers   map[string]bool // Canonical names
+}
+
+func newCORSPolicy(config CORSConfig) (*corsPolicy, error) {
+	if len(config.AllowedOrigins) == 0 {
+		return nil, fmt.Errorf("CORS needs at least one allowed origin")
+	}
+	if config.MaxAge < 0 {
+		return nil, fmt.Errorf("CORS max age must not be negative")
+	}
+
+	p := &corsPolicy{config: config, headers: make(map[string]bool)}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("bad CORS origin %q", origin)
+		}
+		if u, err := url.Parse(strings.ReplaceAll(origin, "*", "x")); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("CORS origin %q must be scheme://host[:port]", origin)
+		}
+		p.origins = append(p.origins, origin)
+	}
+	for _, method := range config.AllowedMethods {
+		p.methods = append(p.methods, strings.ToUpper(method))
+	}
+	for _, header := range config.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return p, nil
+}
+
+// allowOrigin reports whether pages from origin may call the API
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.origins {
+		// * does not match "/", so it cannot reach back into the scheme
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOriginHeader is the Access-Control-Allow-Origin answer for origin
+func (p *corsPolicy) allowOriginHeader(origin string) string {
+	if p.anyOrigin && !p.config.AllowCredentials {
+		return "*"
+	}
+	return origin
+}
+
+// corsMiddleware adds CORS headers to the answers to allowed origins.
+// It runs before rate limiting, so pages can read 429s too.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if s.cors == nil || origin == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if s.cors.allowOrigin(origin) {
+			h.Set("Access-Control-Allow-Origin", s.cors.allowOriginHeader(origin))
+			if s.cors.config.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(s.cors.config.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(s.cors.config.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleOptions answers OPTIONS for every API path with the methods its
+// routes take, and CORS preflight requests from allowed origins. Paths
+// without routes are 404.
+func (s *Server) handleOptions(api *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var methods []string
+		for _, method := range routeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if api.Match(probe, &match) {
+				methods = append(methods, method)
+			}
+		}
+		if len(methods) == 0 {
+			s.writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		h := w.Header()
+		h.Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+
+		origin := r.Header.Get("Origin")
+		requested := r.Header.Get("Access-Control-Request-Method")
+		if origin == "" || requested == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// A preflight
+		h.Add("Vary", "Origin")
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if s.cors == nil || !s.cors.allowOrigin(origin) {
+			s.writeError(w, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+		var allowed []string
+		for _, method := range methods {
+			if slices.Contains(s.cors.methods, method) {
+				allowed = append(allowed, method)
+			}
+		}
+		if !slices.Contains(allowed, strings.ToUpper(requested)) {
+			s.writeError(w, http.StatusForbidden, "Method not allowed for cross-origin requests")
+			return
+		}
+		var headers []string
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if header = strings.TrimSpace(header); header == "" {
+				continue
+			}
+			if !s.cors.anyHeader && !s.cors.headers[http.CanonicalHeaderKey(header)] {
+				s.writeError(w, http.StatusForbidden, "Header not allowed for cross-origin requests: "+header)
+				return
+			}
+			headers = append(headers, header)
+		}
+
+		h.Set("Access-Control-Allow-Origin", s.cors.allowOriginHeader(origin))
+		h.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		if len(headers) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if s.cors.config.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if s.cors.config.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.config.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
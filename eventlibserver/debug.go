@@ -0,0 +1,63 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// registerDebugHandlers adds pprof, expvar and /debug/processor to the
+// metrics listener, which is expected to be reachable only internally
+func (s *Server) registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.Handle("/debug/vars", expvar.Handler())
+	expvar.Publish("eventlib", expvar.Func(func() any {
+		return s.debugState()
+	}))
+
+	mux.HandleFunc("/debug/processor", func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, http.StatusOK, s.debugState())
+	})
+}
+
+// debugState snapshots the internals most useful for chasing leaks in the
+// cgo layer
+func (s *Server) debugState() DebugProcessorResponse {
+	state := DebugProcessorResponse{
+		State:           s.processor.State(),
+		QueueSize:       s.processor.QueueSize(),
+		EventsProcessed: s.processor.EventsProcessed(),
+		LiveHandles:     eventlib.LiveHandles(),
+		Leaked:          eventlib.LeakedProcessors(),
+		Goroutines:      runtime.NumGoroutine(),
+		RuntimeCgoCalls: runtime.NumCgoCall(),
+		StreamClients:   s.broadcast.size(subscriberWebSocket, subscriberSSE),
+		Timestamp:       time.Now(),
+	}
+
+	if provider, ok := s.processor.(eventlib.StatsProvider); ok {
+		stats := provider.Stats()
+		state.Name = stats.Name
+		state.Buffered = stats.Buffered
+		state.RetryPending = stats.RetryPending
+		state.CgoCalls = stats.CgoCalls
+		state.Callbacks = stats.Callbacks
+		state.Shards = len(stats.Shards)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	state.HeapAlloc = mem.HeapAlloc
+	state.HeapObjects = mem.HeapObjects
+
+	return state
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// recentDropsSize is how many dropped events diagnostics remember
+const recentDropsSize = 100
+
+// secretFlag matches flag names whose values are kept out of dumps
+var secretFlag = regexp.MustCompile(`(?i)password|secret|token`)
+
+// DroppedEvent records an event that was rejected or expired
+type DroppedEvent struct {
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Type     string    `json:"type"`
+	Source   string    `json:"source"`
+	DataSize int       `json:"data_size"`
+}
+
+// dropLog keeps the most recent dropped events
+type dropLog struct {
+	mu     sync.Mutex
+	events []DroppedEvent
+	next   int
+}
+
+func (d *dropLog) record(event eventlib.Event, reason string) {
+	entry := DroppedEvent{
+		Time:     time.Now(),
+		Reason:   reason,
+		Type:     event.Type.String(),
+		Source:   event.Source,
+		DataSize: len(event.Data),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.events) < recentDropsSize {
+		d.events = append(d.events, entry)
+		return
+	}
+	d.events[d.next] = entry
+	d.next = (d.next + 1) % recentDropsSize
+}
+
+// snapshot returns the recorded drops, oldest first
+func (d *dropLog) snapshot() []DroppedEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make([]DroppedEvent, 0, len(d.events))
+	out = append(out, d.events[d.next:]...)
+	out = append(out, d.events[:d.next]...)
+	return out
+}
+
+// writeDiagnostics writes a gzipped tarball describing the server's state
+func (s *Server) writeDiagnostics(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	var stacks bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+
+	status := StatusResponse{
+		State:           s.processor.State(),
+		QueueSize:       s.processor.QueueSize(),
+		EventsProcessed: s.processor.EventsProcessed(),
+		Timestamp:       now,
+	}
+
+	var stats interface{} = "not supported by this backend"
+	if provider, ok := s.processor.(eventlib.StatsProvider); ok {
+		stats = provider.Stats()
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	steps := []func() error{
+		func() error { return add("goroutines.txt", stacks.Bytes()) },
+		func() error { return addJSON("status.json", status) },
+		func() error { return addJSON("stats.json", stats) },
+		func() error { return addJSON("config.json", configSnapshot()) },
+		func() error { return addJSON("dropped.json", s.drops.snapshot()) },
+		func() error { return addJSON("build.json", buildInfo()) },
+		func() error { return addJSON("version.json", versionInfo()) },
+		func() error {
+			return addJSON("runtime.json", map[string]interface{}{
+				"goroutines": runtime.NumGoroutine(),
+				"cgo_calls":  runtime.NumCgoCall(),
+				"heap_alloc": mem.HeapAlloc,
+				"heap_sys":   mem.HeapSys,
+				"num_gc":     mem.NumGC,
+			})
+		},
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// configSnapshot captures the effective flag values, masking secrets
+func configSnapshot() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlag.MatchString(f.Name) && value != "" {
+			value = "<redacted>"
+		}
+		config[f.Name] = value
+	})
+	return config
+}
+
+// dumpDiagnostics writes a bundle into dir and returns its path
+func (s *Server) dumpDiagnostics(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	name := fmt.Sprintf("eventlib-diagnostics-%s.tar.gz", time.Now().Format("20060102T150405"))
+	path := filepath.Join(dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := s.writeDiagnostics(f); err != nil {
+		return "", fmt.Errorf("failed to write diagnostics: %w", err)
+	}
+
+	return path, nil
+}
+
+// handleDiagnostics streams a diagnostics bundle, or writes it to the
+// diagnostics directory when called with ?to=file
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("to") == "file" {
+		path, err := s.dumpDiagnostics(s.diagnosticsDir)
+		if err != nil {
+			s.logger.Error("Diagnostics dump failed", zap.Error(err))
+			s.writeError(w, http.StatusInternalServerError, "Failed to write diagnostics")
+			return
+		}
+
+		s.writeJSON(w, http.StatusOK, map[string]string{
+			"path": path,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=eventlib-diagnostics-%s.tar.gz", time.Now().Format("20060102T150405")))
+	w.WriteHeader(http.StatusOK)
+
+	if err := s.writeDiagnostics(w); err != nil {
+		s.logger.Error("Diagnostics stream failed", zap.Error(err))
+	}
+}
+
+// watchDiagnosticsSignal dumps diagnostics to disk on SIGQUIT instead of
+// the runtime's default stack dump and exit
+func (s *Server) watchDiagnosticsSignal() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-sigChan:
+			path, err := s.dumpDiagnostics(s.diagnosticsDir)
+			if err != nil {
+				s.logger.Error("Diagnostics dump failed", zap.Error(err))
+				continue
+			}
+			s.logger.Info("Diagnostics written", zap.String("path", path))
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// eventStoreBuffer is how many processed events may wait to be written
+	eventStoreBuffer = 1024
+
+	// eventStoreBatch is the most events written in one transaction
+	eventStoreBatch = 500
+
+	defaultStoreLimit = 100
+	maxStoreLimit     = 1000
+
+	// eventStorePruneInterval is how often events older than the
+	// retention are deleted
+	eventStorePruneInterval = time.Minute
+)
+
+var (
+	eventsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_event_store_events_total",
+		Help: "Total number of processed events written to the event store, by result: stored or failed",
+	}, []string{"result"})
+
+	eventStoreStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_http_event_store_stalls_total",
+		Help: "Total number of processed events that waited for room in the event store's write queue, holding up processing",
+	})
+)
+
+func init() {
+	registerFeature("event-store")
+}
+
+// EventStoreConfig keeps every processed event in a SQLite or Postgres
+// database, searchable with GET /api/v1/events
+type EventStoreConfig struct {
+	// DSN is sqlite:<file> or a postgres:// URL
+	DSN string
+
+	// Retention, if set, deletes events processed longer ago
+	Retention time.Duration
+}
+
+// storeDialect holds what differs between the databases
+type storeDialect struct {
+	driver string
+	schema []string
+
+	// placeholder returns the nth query parameter, from 1
+	placeholder func(n int) string
+}
+
+var (
+	sqliteDialect = storeDialect{
+		driver: "sqlite",
+		schema: []string{`CREATE TABLE IF NOT EXISTS events (
+			seq            INTEGER PRIMARY KEY AUTOINCREMENT,
+			id             INTEGER NOT NULL,
+			event_id       TEXT NOT NULL,
+			type           TEXT NOT NULL,
+			source         TEXT NOT NULL,
+			data           BLOB,
+			content_type   TEXT NOT NULL,
+			ts             INTEGER NOT NULL,
+			correlation_id TEXT NOT NULL,
+			metadata       TEXT,
+			processed_at   INTEGER NOT NULL
+		)`},
+		placeholder: func(int) string { return "?" },
+	}
+
+	postgresDialect = storeDialect{
+		driver: "pgx",
+		schema: []string{`CREATE TABLE IF NOT EXISTS events (
+			seq            BIGSERIAL PRIMARY KEY,
+			id             BIGINT NOT NULL,
+			event_id       TEXT NOT NULL,
+			type           TEXT NOT NULL,
+			source         TEXT NOT NULL,
+			data           BYTEA,
+			content_type   TEXT NOT NULL,
+			ts             BIGINT NOT NULL,
+			correlation_id TEXT NOT NULL,
+			metadata       TEXT,
+			processed_at   BIGINT NOT NULL
+		)`},
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+
+	// Both databases take the same indexes. Timestamps are stored as Unix
+	// nanoseconds, which sort and compare the same everywhere.
+	eventStoreIndexes = []string{
+		`CREATE INDEX IF NOT EXISTS events_ts ON events (ts)`,
+		`CREATE INDEX IF NOT EXISTS events_type_ts ON events (type, ts)`,
+		`CREATE INDEX IF NOT EXISTS events_source_ts ON events (source, ts)`,
+		`CREATE INDEX IF NOT EXISTS events_processed_at ON events (processed_at)`,
+	}
+)
+
+// parseStoreDSN picks the dialect for dsn and returns the DSN its driver
+// takes
+func parseStoreDSN(dsn string) (storeDialect, string, error) {
+	if file, ok := strings.CutPrefix(dsn, "sqlite:"); ok {
+		file = strings.TrimPrefix(file, "//")
+		if file == "" {
+			return storeDialect{}, "", fmt.Errorf("event store DSN %q has no file", dsn)
+		}
+		// WAL lets queries run while events are written
+		return sqliteDialect, "file:" + file + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect, dsn, nil
+	}
+	return storeDialect{}, "", fmt.Errorf("event store DSN must start with sqlite: or postgres://")
+}
+
+// storedEvent is a processed event waiting to be written
+type storedEvent struct {
+	msg         EventMessage
+	processedAt time.Time
+}
+
+// eventStore writes processed events to the database in batches, on its
+// own goroutine
+type eventStore struct {
+	db        *sql.DB
+	dialect   storeDialect
+	retention time.Duration
+	logger    *zap.Logger
+
+	pending chan storedEvent
+	done    chan struct{} // Closed once the writer has finished
+	stop    chan struct{} // Stops pruning
+
+	// publish holds closeMu for reading until its event is queued, so
+	// close cannot shut pending under it
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newEventStore(config EventStoreConfig, logger *zap.Logger) (*eventStore, error) {
+	if config.Retention < 0 {
+		return nil, fmt.Errorf("event store retention must not be negative")
+	}
+	dialect, dsn, err := parseStoreDSN(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event store: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, stmt := range slices.Concat(dialect.schema, eventStoreIndexes) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create event store schema: %w", err)
+		}
+	}
+
+	return &eventStore{
+		db:        db,
+		dialect:   dialect,
+		retention: config.Retention,
+		logger:    logger,
+		pending:   make(chan storedEvent, eventStoreBuffer),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// start runs the writer, and pruning if there is a retention
+func (es *eventStore) start() {
+	go es.write()
+	if es.retention > 0 {
+		go es.prune()
+	}
+}
+
+// publish queues msg to be written. While the queue is full it waits,
+// holding up processing rather than losing the event, and counts the
+// stall. Events published after close are counted as failed.
+func (es *eventStore) publish(msg EventMessage) {
+	es.closeMu.RLock()
+	defer es.closeMu.RUnlock()
+	if es.closed {
+		eventsStored.WithLabelValues("failed").Inc()
+		return
+	}
+
+	event := storedEvent{msg: msg, processedAt: time.Now()}
+	select {
+	case es.pending <- event:
+	default:
+		eventStoreStalls.Inc()
+		es.pending <- event
+	}
+}
+
+// write stores pending events until close, taking whatever has queued up
+// as one batch
+func (es *eventStore) write() {
+	defer close(es.done)
+
+	batch := make([]storedEvent, 0, eventStoreBatch)
+	for event := range es.pending {
+		batch = append(batch[:0], event)
+	drain:
+		for len(batch) < eventStoreBatch {
+			select {
+			case event, ok := <-es.pending:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		if err := es.insert(batch); err != nil {
+			eventsStored.WithLabelValues("failed").Add(float64(len(batch)))
+			es.logger.Error("Failed to write events to the event store",
+				zap.Int("events", len(batch)),
+				zap.Error(err))
+			continue
+		}
+		eventsStored.WithLabelValues("stored").Add(float64(len(batch)))
+	}
+}
+
+// insert writes batch in one transaction
+func (es *eventStore) insert(batch []storedEvent) error {
+	p := es.dialect.placeholder
+	query := "INSERT INTO events (id, event_id, type, source, data, content_type, ts, correlation_id, metadata, processed_at) VALUES (" +
+		p(1) + ", " + p(2) + ", " + p(3) + ", " + p(4) + ", " + p(5) + ", " +
+		p(6) + ", " + p(7) + ", " + p(8) + ", " + p(9) + ", " + p(10) + ")"
+
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, event := range batch {
+		msg := event.msg
+		var metadata sql.NullString
+		if len(msg.Metadata) > 0 {
+			data, err := json.Marshal(msg.Metadata)
+			if err != nil {
+				return err
+			}
+			metadata = sql.NullString{String: string(data), Valid: true}
+		}
+		_, err := stmt.Exec(int64(msg.ID), msg.EventID, msg.Type, msg.Source, msg.Data,
+			msg.ContentType, msg.Timestamp.UnixNano(), msg.CorrelationID, metadata,
+			event.processedAt.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune deletes events older than the retention
+func (es *eventStore) prune() {
+	ticker := time.NewTicker(eventStorePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cutoff := time.Now().Add(-es.retention).UnixNano()
+			result, err := es.db.Exec("DELETE FROM events WHERE processed_at < "+es.dialect.placeholder(1), cutoff)
+			if err != nil {
+				es.logger.Warn("Failed to prune the event store", zap.Error(err))
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				es.logger.Debug("Pruned the event store", zap.Int64("events", n))
+			}
+		case <-es.stop:
+			return
+		}
+	}
+}
+
+// storeQuery selects events from the store. Zero fields match everything.
+type storeQuery struct {
+	types   []string
+	sources []string
+	since   time.Time // Inclusive
+	until   time.Time // Exclusive
+	before  int64     // Only events stored before this cursor
+	limit   int
+}
+
+// find returns events matching q, newest first, with the cursor of the
+// next page if there may be one
+func (es *eventStore) find(ctx context.Context, q storeQuery) ([]RecentEvent, string, error) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return es.dialect.placeholder(len(args))
+	}
+	in := func(column string, values []string) {
+		params := make([]string, len(values))
+		for i, v := range values {
+			params[i] = arg(v)
+		}
+		where = append(where, column+" IN ("+strings.Join(params, ", ")+")")
+	}
+
+	if len(q.types) > 0 {
+		in("type", q.types)
+	}
+	if len(q.sources) > 0 {
+		in("source", q.sources)
+	}
+	if !q.since.IsZero() {
+		where = append(where, "ts >= "+arg(q.since.UnixNano()))
+	}
+	if !q.until.IsZero() {
+		where = append(where, "ts < "+arg(q.until.UnixNano()))
+	}
+	if q.before > 0 {
+		where = append(where, "seq < "+arg(q.before))
+	}
+
+	query := "SELECT seq, id, event_id, type, source, data, content_type, ts, correlation_id, metadata, processed_at FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY seq DESC LIMIT " + arg(q.limit)
+
+	rows, err := es.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	events := []RecentEvent{}
+	var seq int64
+	for rows.Next() {
+		var (
+			msg         EventMessage
+			id          int64
+			ts          int64
+			processedAt int64
+			metadata    sql.NullString
+		)
+		err := rows.Scan(&seq, &id, &msg.EventID, &msg.Type, &msg.Source, &msg.Data,
+			&msg.ContentType, &ts, &msg.CorrelationID, &metadata, &processedAt)
+		if err != nil {
+			return nil, "", err
+		}
+		msg.ID = uint64(id)
+		msg.Timestamp = time.Unix(0, ts).UTC()
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &msg.Metadata); err != nil {
+				return nil, "", err
+			}
+		}
+		events = append(events, RecentEvent{Event: msg, ProcessedAt: time.Unix(0, processedAt).UTC()})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(events) == q.limit {
+		next = strconv.FormatInt(seq, 10)
+	}
+	return events, next, nil
+}
+
+// ping reports whether the database can be reached, for /readyz
+func (es *eventStore) ping(ctx context.Context) error {
+	return es.db.PingContext(ctx)
+}
+
+// close writes what is queued and closes the database. Call it once
+// nothing more is published.
+func (es *eventStore) close() error {
+	es.closeMu.Lock()
+	es.closed = true
+	es.closeMu.Unlock()
+
+	close(es.stop)
+	close(es.pending)
+	<-es.done
+	return es.db.Close()
+}
+
+// handleQueryEvents searches the event store. ?type= and ?source= take
+// comma-separated values as for the streaming endpoints, and ?since= and
+// ?until= an RFC 3339 time or a duration relative to now, such as -1h.
+// Pages run newest first; pass next_cursor back as ?cursor= for the next.
+func (s *Server) handleQueryEvents(w http.ResponseWriter, r *http.Request) {
+	if s.eventStore == nil {
+		s.writeError(w, http.StatusNotImplemented, "Event store is disabled")
+		return
+	}
+
+	values := r.URL.Query()
+	filter := parseStreamFilter(r)
+	q := storeQuery{limit: defaultStoreLimit}
+	q.types = slices.Sorted(maps.Keys(filter.types))
+	q.sources = slices.Sorted(maps.Keys(filter.sources))
+
+	var err error
+	if q.since, err = parseDeadline(values.Get("since")); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid since")
+		return
+	}
+	if q.until, err = parseDeadline(values.Get("until")); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid until")
+		return
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		q.limit = min(n, maxStoreLimit)
+	}
+	if v := values.Get("cursor"); v != "" {
+		if q.before, err = strconv.ParseInt(v, 10, 64); err != nil || q.before < 1 {
+			s.writeError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	events, next, err := s.eventStore.find(r.Context(), q)
+	if err != nil {
+		s.logger.Error("Failed to query the event store", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, "Failed to query events")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, StoredEventsResponse{
+		Events:     events,
+		Count:      len(events),
+		NextCursor: next,
+	})
+}
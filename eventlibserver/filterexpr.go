@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// filterExprCostLimit bounds the work one expression may do per event, so
+// a rule cannot stall the push path
+const filterExprCostLimit = 100000
+
+// filterExprEnv declares what expressions can see: a single event map
+var filterExprEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("event", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("filter expression environment: %v", err))
+	}
+	return env
+}()
+
+// compileFilterExpr checks a CEL expression and prepares it for
+// evaluation. It must yield a bool.
+func compileFilterExpr(expr string) (cel.Program, error) {
+	ast, issues := filterExprEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression yields %v, not bool", ast.OutputType())
+	}
+	return filterExprEnv.Program(ast, cel.CostLimit(filterExprCostLimit))
+}
+
+// filterExprInput is the event as expressions see it
+func filterExprInput(event eventlib.Event) map[string]any {
+	return map[string]any{
+		"event": map[string]any{
+			"type":         event.Type.String(),
+			"type_id":      int64(event.Type),
+			"source":       event.Source,
+			"data":         event.Data,
+			"id":           event.ID,
+			"content_type": event.ContentType,
+			"priority":     int64(event.Priority),
+		},
+	}
+}
+
+// evalFilterExpr reports whether prg holds for input. An expression that
+// fails, for example on a missing key, does not match.
+func evalFilterExpr(prg cel.Program, input map[string]any) bool {
+	out, _, err := prg.Eval(input)
+	if err != nil {
+		return false
+	}
+	matched, ok := out.Value().(bool)
+	return ok && matched
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// Filter rule actions
+const (
+	FilterAllow = "allow"
+	FilterDeny  = "deny"
+)
+
+var filterHits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_filter_rule_hits_total",
+	Help: "Total number of events matched by each filter rule",
+}, []string{"rule", "action"})
+
+// FilterRule allows or denies the events it matches. A rule matches when
+// every condition it sets holds; a rule with none matches everything.
+type FilterRule struct {
+	// Name identifies the rule in hit counts and logs
+	Name   string `json:"name"`
+	Action string `json:"action"`
+
+	Types []eventlib.EventType `json:"types,omitempty"`
+
+	// Sources are glob patterns, as for HandleSource
+	Sources []string `json:"sources,omitempty"`
+
+	// MinDataSize and MaxDataSize bound the payload size in bytes
+	MinDataSize *int `json:"min_data_size,omitempty"`
+	MaxDataSize *int `json:"max_data_size,omitempty"`
+
+	// Expr is a CEL expression over event, such as
+	// event.source.startsWith("sensor-") && size(event.data) < 4096
+	Expr string `json:"expr,omitempty"`
+
+	// Rate, if set, makes the rule match only the events from each source
+	// beyond this rate, so a deny rule sheds a source's excess
+	Rate *RateLimit `json:"rate,omitempty"`
+}
+
+// FilterConfig is the rule set evaluated for every pushed event. The first
+// matching rule decides; Default applies when none match.
+type FilterConfig struct {
+	Default string       `json:"default,omitempty"`
+	Rules   []FilterRule `json:"rules"`
+}
+
+// defaultFilterConfig keeps the behaviour from before rules were
+// configurable: events from the "blocked" source are dropped
+func defaultFilterConfig() FilterConfig {
+	return FilterConfig{
+		Default: FilterAllow,
+		Rules: []FilterRule{
+			{Name: "blocked", Action: FilterDeny, Sources: []string{"blocked"}},
+		},
+	}
+}
+
+func (c *FilterConfig) validate() error {
+	switch c.Default {
+	case "":
+		c.Default = FilterAllow
+	case FilterAllow, FilterDeny:
+	default:
+		return fmt.Errorf("unknown default action %q", c.Default)
+	}
+
+	names := make(map[string]bool, len(c.Rules))
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Action != FilterAllow && rule.Action != FilterDeny {
+			return fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+		for _, pattern := range rule.Sources {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %q: bad source pattern %q", rule.Name, pattern)
+			}
+		}
+		if (rule.MinDataSize != nil && *rule.MinDataSize < 0) || (rule.MaxDataSize != nil && *rule.MaxDataSize < 0) {
+			return fmt.Errorf("rule %q: negative data size", rule.Name)
+		}
+		if rule.Expr != "" {
+			if _, err := compileFilterExpr(rule.Expr); err != nil {
+				return fmt.Errorf("rule %q: bad expression: %w", rule.Name, err)
+			}
+		}
+		if rule.Rate != nil && !rule.Rate.enabled() {
+			return fmt.Errorf("rule %q: rate must be positive", rule.Name)
+		}
+	}
+	return nil
+}
+
+// loadFilterConfig reads a rule file
+func loadFilterConfig(file string) (FilterConfig, error) {
+	var config FilterConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("%s: %w", file, err)
+	}
+	return config, nil
+}
+
+// saveFilterConfig writes a rule file, replacing the old one atomically
+func saveFilterConfig(file string, config FilterConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// filterSet is a loaded FilterConfig with its compiled expressions and
+// hit counts
+type filterSet struct {
+	config FilterConfig
+	exprs  []cel.Program
+	hits   []atomic.Uint64
+	rates  *keyedLimiter
+}
+
+// newFilterSet prepares a validated config for evaluation
+func newFilterSet(config FilterConfig) *filterSet {
+	fs := &filterSet{
+		config: config,
+		exprs:  make([]cel.Program, len(config.Rules)),
+		hits:   make([]atomic.Uint64, len(config.Rules)),
+		rates:  newKeyedLimiter(),
+	}
+	for i, rule := range config.Rules {
+		if rule.Expr != "" {
+			// Already compiled once by validate, so this cannot fail
+			fs.exprs[i], _ = compileFilterExpr(rule.Expr)
+		}
+	}
+	return fs
+}
+
+// allow reports whether event passes the rules, counting the rule that
+// decided
+func (fs *filterSet) allow(event eventlib.Event) (allowed bool, rule string) {
+	// Built on first use, as most events never reach an expression
+	var input map[string]any
+	for i := range fs.config.Rules {
+		r := &fs.config.Rules[i]
+		if !fs.matches(i, r, event, &input) {
+			continue
+		}
+		fs.hits[i].Add(1)
+		filterHits.WithLabelValues(r.Name, r.Action).Inc()
+		return r.Action == FilterAllow, r.Name
+	}
+	return fs.config.Default == FilterAllow, ""
+}
+
+func (fs *filterSet) matches(i int, r *FilterRule, event eventlib.Event, input *map[string]any) bool {
+	if len(r.Types) > 0 && !slices.Contains(r.Types, event.Type) {
+		return false
+	}
+	if len(r.Sources) > 0 {
+		matched := false
+		for _, pattern := range r.Sources {
+			if ok, _ := path.Match(pattern, event.Source); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.MinDataSize != nil && len(event.Data) < *r.MinDataSize {
+		return false
+	}
+	if r.MaxDataSize != nil && len(event.Data) > *r.MaxDataSize {
+		return false
+	}
+	if fs.exprs[i] != nil {
+		if *input == nil {
+			*input = filterExprInput(event)
+		}
+		if !evalFilterExpr(fs.exprs[i], *input) {
+			return false
+		}
+	}
+	if r.Rate != nil {
+		// Checked last, so only events meeting the other conditions use
+		// up the rule's tokens
+		if within, _ := fs.rates.allow(strconv.Itoa(i)+"\x00"+event.Source, *r.Rate); within {
+			return false
+		}
+	}
+	return true
+}
+
+// status returns the rules with their hit counts
+func (fs *filterSet) status() FiltersResponse {
+	resp := FiltersResponse{
+		Default: fs.config.Default,
+		Rules:   make([]FilterRuleStatus, len(fs.config.Rules)),
+	}
+	for i, rule := range fs.config.Rules {
+		resp.Rules[i] = FilterRuleStatus{FilterRule: rule, Hits: fs.hits[i].Load()}
+	}
+	return resp
+}
+
+// filterEngine holds the current filterSet, which can be replaced while
+// events are being pushed
+type filterEngine struct {
+	current atomic.Pointer[filterSet]
+	file    string
+
+	// mu serializes replacements, so the file and the rules in use agree
+	mu sync.Mutex
+}
+
+// newFilterEngine loads file if it exists, or starts from the default
+// rules
+func newFilterEngine(file string) (*filterEngine, error) {
+	engine := &filterEngine{file: file}
+
+	config := defaultFilterConfig()
+	if file != "" {
+		loaded, err := loadFilterConfig(file)
+		switch {
+		case err == nil:
+			config = loaded
+		case errors.Is(err, os.ErrNotExist):
+		default:
+			return nil, err
+		}
+	}
+	engine.current.Store(newFilterSet(config))
+	return engine, nil
+}
+
+// replace swaps in new rules, saving them first if there is a file
+func (fe *filterEngine) replace(config FilterConfig) error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	if fe.file != "" {
+		if err := saveFilterConfig(fe.file, config); err != nil {
+			return fmt.Errorf("failed to save filters: %w", err)
+		}
+	}
+	fe.current.Store(newFilterSet(config))
+	return nil
+}
+
+// reload rereads the file, for SIGHUP
+func (fe *filterEngine) reload() error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	config, err := loadFilterConfig(fe.file)
+	if err != nil {
+		return err
+	}
+	fe.current.Store(newFilterSet(config))
+	return nil
+}
+
+// run prunes idle rate buckets until done is closed
+func (fe *filterEngine) run(done <-chan struct{}) {
+	ticker := time.NewTicker(idleLimiterTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fe.current.Load().rates.prune()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (s *Server) handleGetFilters(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.filters.current.Load().status())
+}
+
+// handlePutFilters replaces the filter rules. Hit counts start again from
+// zero.
+func (s *Server) handlePutFilters(w http.ResponseWriter, r *http.Request) {
+	var config FilterConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := config.validate(); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid filters: "+err.Error())
+		return
+	}
+
+	if err := s.filters.replace(config); err != nil {
+		s.logger.Error("Failed to update filters", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, "Failed to save filters")
+		return
+	}
+	s.auditAdmin(r, "filters", zap.Int("rules", len(config.Rules)))
+	s.writeJSON(w, http.StatusOK, s.filters.current.Load().status())
+}
@@ -0,0 +1,46 @@
+package main
+
+// Fuzz targets for the request decoders, which read untrusted bytes. Run
+// one with
+//
+//	go test -run='^$' -fuzz=FuzzDecodeProtobuf -fuzztime=1m
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+func FuzzDecodeProtobuf(f *testing.F) {
+	timestamp := time.UnixMilli(1700000000123)
+	priority := 5
+	f.Add(marshalProtoEvent(EventRequest{Type: eventlib.EventTypeData, Source: "sensor-1", Data: []byte("hello")}))
+	f.Add(marshalProtoEvent(EventRequest{ID: "id-1", ContentType: "text/plain", Timestamp: &timestamp, Priority: &priority}))
+	f.Add([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	f.Add([]byte{0x08})
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req, err := unmarshalProtoEvent(body)
+		if err != nil {
+			return
+		}
+
+		// Whatever decodes must survive being encoded again
+		again, err := unmarshalProtoEvent(marshalProtoEvent(req))
+		if err != nil {
+			t.Fatalf("re-encoded event does not decode: %v", err)
+		}
+		if len(again.Data) == 0 && len(req.Data) == 0 {
+			again.Data, req.Data = nil, nil
+		}
+		if !reflect.DeepEqual(again, req) {
+			t.Fatalf("decoded %+v, then %+v", req, again)
+		}
+
+		if _, err := readBatchRequest(newProtoRequest(marshalProtoBatch(req))); err != nil {
+			t.Fatalf("batch of one decoded event: %v", err)
+		}
+	})
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/sammyjroberts/eventlibgo/middleware"
+	"github.com/sammyjroberts/eventlibgo/zaplog"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -28,88 +38,782 @@ var (
 		Help: "Current event queue size",
 	})
 
-	processingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "eventlibgo_processors_leaked_total",
+		Help: "Total number of processors garbage collected without Close",
+	}, func() float64 {
+		return float64(eventlib.LeakedProcessors())
+	})
+
+	eventsBackfilled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_events_backfilled_total",
+		Help: "Total number of historical events received via the backfill API",
+	}, []string{"type", "source"})
+
+	eventsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_events_expired_total",
+		Help: "Total number of events expired before processing",
+	}, []string{"type", "source"})
+
+	asyncPushFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_async_push_failures_total",
+		Help: "Total number of accepted events dropped before reaching the queue",
+	}, []string{"type", "source"})
+
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_dead_letters_total",
+		Help: "Total number of events given up on after their handlers failed",
+	}, []string{"type", "source"})
+
+	queueLatency = promauto.NewHistogram(nativeHistogram(prometheus.HistogramOpts{
+		Name:    "eventlibgo_queue_latency_seconds",
+		Help:    "Time events spent between push and processing",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}))
+
+	processingDuration = promauto.NewHistogram(nativeHistogram(prometheus.HistogramOpts{
 		Name:    "eventlibgo_http_processing_duration_seconds",
 		Help:    "Event processing duration",
 		Buckets: prometheus.DefBuckets,
-	})
+	}))
 )
 
+// ProcessorFactory builds the processor backend for a Server
+type ProcessorFactory func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error)
+
+// Options configures a Server
+type Options struct {
+	Name      string
+	QueueSize int
+
+	// MaxEventSize and MaxSourceLength, if set, refuse larger events with
+	// 413 (cgo backend only)
+	MaxEventSize    int
+	MaxSourceLength int
+
+	// MaxRequestSize, if set, caps the request bodies of the ingest
+	// endpoints; larger bodies are refused with 413
+	MaxRequestSize int64
+
+	// HighWatermark and LowWatermark are fractions of QueueSize. The
+	// /readyz queue check fails from when the queue reaches HighWatermark
+	// until it falls back to LowWatermark.
+	HighWatermark float64
+	LowWatermark  float64
+
+	// NewProcessor selects the backend; defaults to the cgo EventProcessor
+	NewProcessor ProcessorFactory
+
+	// Autotune, if set, processes events automatically at a rate chosen to
+	// hold queue depth near a target
+	Autotune *AutotuneConfig
+
+	// ProcessInterval, if set, drains the queue on a ticker
+	ProcessInterval time.Duration
+
+	// ProcessThreshold, if set, drains the queue as soon as it holds this
+	// many events
+	ProcessThreshold int
+
+	// DiagnosticsDir is where diagnostics bundles are written
+	DiagnosticsDir string
+
+	// AuthConfig, if set, is the path of an AuthConfig file; requests then
+	// need an API key or bearer token carrying the route's scope
+	AuthConfig string
+
+	// RateLimit, if set, caps event rates per source and request rates per
+	// client IP
+	RateLimit *RateLimitConfig
+
+	// QueueMode selects FIFO or priority ordering (cgo backend only)
+	QueueMode eventlib.QueueMode
+
+	// PersistencePath, if set, journals queued events to disk so they
+	// survive a restart (cgo backend only)
+	PersistencePath string
+	PersistenceSync eventlib.SyncPolicy
+
+	// PersistenceLockTimeout is how long to wait for another process to
+	// close the write-ahead log, as the old one does during an upgrade
+	PersistenceLockTimeout time.Duration
+
+	// AsyncPush, if set, buffers pushes in Go so handlers do not wait on
+	// the C queue (cgo backend only)
+	AsyncPush *eventlib.AsyncConfig
+
+	// ProcessWorkers is how many goroutines drain the queue at once (cgo
+	// backend only)
+	ProcessWorkers int
+
+	// HandlerTimeout, if set, logs handlers that run longer; with
+	// AbandonStuckHandlers their events fail instead of waiting (cgo
+	// backend only)
+	HandlerTimeout       time.Duration
+	AbandonStuckHandlers bool
+
+	// Retry, if set, retries events whose handlers fail with backoff
+	// before dead-lettering them
+	Retry *eventlib.RetryPolicy
+
+	// Dedup, if set, drops events pushed again within a window, matched
+	// by their id or content (cgo backend only)
+	Dedup *eventlib.DedupConfig
+
+	// Transformers rewrite events before they are queued (cgo backend
+	// only)
+	Transformers []eventlib.Transformer
+
+	// Sampling, if set, drops a share of events before they are queued,
+	// with rules the admin API can replace (cgo backend only)
+	Sampling *SamplingConfig
+
+	// Aggregates, if set, summarizes processed events over time windows,
+	// pushing a summary event as each window closes
+	Aggregates *AggregateConfig
+
+	// Kafka, if set, consumes events from Kafka topics and publishes
+	// processed events to an output topic
+	Kafka *KafkaConfig
+
+	// NATS, if set, consumes events from a JetStream stream
+	NATS *NATSConfig
+
+	// MQTT, if set, bridges messages from MQTT topics into the processor
+	MQTT *MQTTConfig
+
+	// Webhooks, if set, POSTs processed events to HTTP endpoints
+	Webhooks *WebhookConfig
+
+	// Archive, if set, writes processed events to compressed files in
+	// object storage or a local directory
+	Archive *ArchiveConfig
+
+	// EventStore, if set, writes processed events to SQLite or Postgres
+	// for GET /api/v1/events
+	EventStore *EventStoreConfig
+
+	// Alerts, if set, notifies webhook, Slack or PagerDuty targets when
+	// a source sends too many ERROR events
+	Alerts *AlertConfig
+
+	// CORS, if set, lets browser pages on other origins call the API
+	CORS *CORSConfig
+
+	// Topics are named queues created at startup, each with its own
+	// limit; more can be added through the admin API
+	Topics map[string]eventlib.TopicConfig
+
+	// Chaos enables fault injection through /admin/chaos, for testing
+	// clients against a failing server (cgo backend only)
+	Chaos bool
+
+	// Plugins, if set, loads Go plugins and WASM modules as handlers for
+	// the event types they are configured for
+	Plugins *PluginConfig
+
+	// HistorySize is how many processed events are kept for replay; 0
+	// disables the history
+	HistorySize int
+
+	// StatsMaxSources bounds the sources /stats counts separately; the
+	// rest are counted together. Defaults to 1000.
+	StatsMaxSources int
+
+	// StreamAck sets how events are redelivered to WebSocket subscribers
+	// that ack them
+	StreamAck StreamAckConfig
+
+	// Idempotency, if set, honours the Idempotency-Key header on POST
+	// /events and /events/batch
+	Idempotency *IdempotencyConfig
+
+	// SchemaDir, if set, holds a JSON Schema per event type, named
+	// <type>.json; payloads that do not match are refused
+	SchemaDir string
+
+	// FilterFile, if set, is where filter rules are loaded from and saved
+	// to; without it rules changed over the API last until restart
+	FilterFile string
+
+	// RestoreSnapshot, if set, is a snapshot file whose events and
+	// counters are loaded into the processor at startup
+	RestoreSnapshot string
+
+	// LogLevel, if set, is the logger's level, changed when -log-level is
+	// reloaded
+	LogLevel *zap.AtomicLevel
+}
+
 // Server wraps the event processor with HTTP handlers
 type Server struct {
-	processor *eventlib.EventProcessor
+	processor eventlib.Processor
 	logger    *zap.Logger
+	logLevel  *zap.AtomicLevel
+
+	// openAPI is the document served at /api/v1/openapi.json
+	openAPI []byte
+
+	// Fan-out of processed events to streams, Kafka and webhooks, and
+	// the goroutines feeding the sinks
+	broadcast *broadcaster
+	sinks     sync.WaitGroup
+
+	// SSE and WebSocket streams, told to finish on shutdown
+	streams *streamDrain
+
+	// Unacked events of acknowledging stream subscribers
+	acks *ackRegistry
+
+	// Automatic processing
+	processThreshold atomic.Int64
+	wake             chan struct{}
+	processing       processingControl
+
+	// ingestPaused refuses new events from the API and holds back the
+	// broker sources
+	ingestPaused atomic.Bool
+
+	// maxRequestSize caps ingest request bodies; 0 means no limit
+	maxRequestSize int64
+
+	// highWatermark is the queue size at which /readyz fails, for
+	// backends that do not report queue pressure themselves
+	highWatermark int
+
+	// Rate limiting; a zero config allows everything
+	limits *rateLimiter
+
+	// Authentication, nil when disabled
+	auth *authenticator
+
+	// Configuration reloaded on SIGHUP
+	reloadMu  sync.Mutex
+	reloaders []reloader
+
+	// Kafka source and sink, nil when disabled
+	kafkaSource *kafkaSource
+	kafkaSink   *kafkaSink
+
+	// JetStream source, nil when disabled
+	natsSource *natsSource
+
+	// MQTT bridge, nil when disabled
+	mqtt *mqttBridge
+
+	// Webhook delivery, nil when disabled
+	webhooks *webhookSink
+
+	// Archive of processed events, nil when disabled
+	archive *archiver
+
+	// Database of processed events, nil when disabled
+	eventStore *eventStore
+
+	// Cross-origin access, nil when only same-origin pages may call
+	cors *corsPolicy
+
+	// Alert rules over processed events, nil when not configured
+	alerts *alerter
+
+	// Plugin handlers, nil when none are configured
+	plugins *pluginHandlers
+
+	// Recently processed events for replay, nil when disabled
+	history *eventHistory
+
+	// Windowed aggregation of processed events, nil when disabled
+	aggregates *aggregates
+
+	// Counts by event type and source for /stats
+	traffic *trafficStats
+
+	// Responses to requests with an Idempotency-Key, nil when disabled
+	idempotency idempotencyStore
+
+	// Rules deciding which pushed events are kept; sampler is nil
+	// without Options.Sampling
+	filters *filterEngine
+	sampler *sampler
+
+	// JSON Schemas for payloads by event type, nil when disabled
+	schemas *schemaRegistry
 
-	// Event broadcasting
-	eventBroadcast chan eventlib.Event
+	// Diagnostics
+	drops          dropLog
+	diagnosticsDir string
+
+	// Health checks for the probe endpoints, and whether startup is done
+	health  healthChecks
+	started atomic.Bool
+
+	// Named processors hosted for tenants
+	tenants *tenantRegistry
+
+	// Named queues whose events share the server's handlers
+	topics *eventlib.TopicProcessor
+
+	// Injected faults, nil unless started with Options.Chaos
+	faults *eventlib.Faults
+
+	// done is closed when the server shuts down, stopping background tasks
+	done chan struct{}
 }
 
 // NewServer creates a new HTTP server wrapping the event processor
-func NewServer(name string, queueSize int, logger *zap.Logger) (*Server, error) {
+func NewServer(opts Options, logger *zap.Logger) (*Server, error) {
 	s := &Server{
-		logger: logger,
+		logger:         logger,
+		logLevel:       opts.LogLevel,
+		broadcast:      newBroadcaster(),
+		streams:        newStreamDrain(),
+		diagnosticsDir: opts.DiagnosticsDir,
+		maxRequestSize: opts.MaxRequestSize,
+		wake:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+	s.processThreshold.Store(int64(opts.ProcessThreshold))
+
+	newProcessor := opts.NewProcessor
+	if newProcessor == nil {
+		newProcessor = newCgoProcessor
+	}
+
+	// The limiter always exists so limits can be turned on by a reload
+	var limits RateLimitConfig
+	if opts.RateLimit != nil {
+		limits = *opts.RateLimit
+	}
+	s.limits = newRateLimiter(limits)
+
+	if opts.AuthConfig != "" {
+		auth, err := newAuthenticator(opts.AuthConfig, logger)
+		if err != nil {
+			return nil, err
+		}
+		s.auth = auth
+		s.onReload("auth", auth.reload)
+	}
+
+	filters, err := newFilterEngine(opts.FilterFile)
+	if err != nil {
+		return nil, err
+	}
+	s.filters = filters
+	if opts.FilterFile != "" {
+		s.onReload("filters", filters.reload)
+	}
+
+	if opts.SchemaDir != "" {
+		schemas, err := newSchemaRegistry(opts.SchemaDir)
+		if err != nil {
+			return nil, err
+		}
+		s.schemas = schemas
+		s.onReload("schemas", schemas.reload)
+	}
+
+	if s.diagnosticsDir == "" {
+		s.diagnosticsDir = os.TempDir()
+	}
+
+	var kafkaConfig KafkaConfig
+	if opts.Kafka != nil {
+		kafkaConfig = *opts.Kafka
+		if err := kafkaConfig.validate(); err != nil {
+			return nil, err
+		}
+		if kafkaConfig.OutputTopic != "" {
+			s.kafkaSink = newKafkaSink(kafkaConfig, logger)
+		}
+	}
+
+	if opts.HistorySize > 0 {
+		s.history = newEventHistory(opts.HistorySize)
+	}
+	if opts.Aggregates != nil {
+		s.aggregates = newAggregates(*opts.Aggregates, s)
+	}
+	if opts.Alerts != nil {
+		s.alerts = newAlerter(*opts.Alerts, logger)
+	}
+	if opts.CORS != nil {
+		if s.cors, err = newCORSPolicy(*opts.CORS); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.StatsMaxSources < 0 {
+		return nil, fmt.Errorf("stats max sources must not be negative, got %d", opts.StatsMaxSources)
+	}
+	maxSources := opts.StatsMaxSources
+	if maxSources == 0 {
+		maxSources = defaultStatsMaxSources
+	}
+	s.traffic = newTrafficStats(maxSources)
+
+	streamAck, err := opts.StreamAck.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	s.acks = newAckRegistry(streamAck)
+
+	if opts.Idempotency != nil {
+		idempotencyConfig := *opts.Idempotency
+		if err := idempotencyConfig.validate(); err != nil {
+			return nil, err
+		}
+		s.idempotency = newIdempotencyStore(idempotencyConfig)
+	}
+
+	if opts.Webhooks != nil && len(opts.Webhooks.Destinations) > 0 {
+		s.webhooks = newWebhookSink(*opts.Webhooks, logger)
+	}
+	if opts.Archive != nil {
+		if s.archive, err = newArchiver(*opts.Archive, logger); err != nil {
+			return nil, err
+		}
+	}
+	if opts.EventStore != nil {
+		if s.eventStore, err = newEventStore(*opts.EventStore, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.MQTT != nil {
+		mqttConfig := *opts.MQTT
+		if err := mqttConfig.validate(); err != nil {
+			return nil, err
+		}
+		s.mqtt = newMQTTBridge(mqttConfig, s)
+	}
+
+	// Connect before creating the processor, so a bad stream fails fast
+	if opts.NATS != nil {
+		natsConfig := *opts.NATS
+		err := natsConfig.validate()
+		if err == nil {
+			s.natsSource, err = newNATSSource(natsConfig, s)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Configure processor
 	config := &eventlib.Config{
-		Name:          name,
-		MaxQueueSize:  queueSize,
+		Name:          opts.Name,
+		MaxQueueSize:  opts.QueueSize,
 		EnableLogging: true,
-		Logger:        logger,
+		Logger:        zaplog.New(logger),
+		QueueMode:     opts.QueueMode,
+
+		MaxEventSize:    opts.MaxEventSize,
+		MaxSourceLength: opts.MaxSourceLength,
+
+		PersistencePath:        opts.PersistencePath,
+		PersistenceSync:        opts.PersistenceSync,
+		PersistenceLockTimeout: opts.PersistenceLockTimeout,
+
+		HighWatermark: opts.HighWatermark,
+		LowWatermark:  opts.LowWatermark,
+
+		ProcessWorkers: opts.ProcessWorkers,
+
+		HandlerTimeout:       opts.HandlerTimeout,
+		AbandonStuckHandlers: opts.AbandonStuckHandlers,
+
+		Registerer: prometheus.DefaultRegisterer,
+	}
+	if opts.AsyncPush != nil {
+		async := *opts.AsyncPush
+		if async.OnError == nil {
+			async.OnError = s.onAsyncError
+		}
+		config.AsyncPush = &async
+	}
+	config.Retry = opts.Retry
+	config.Dedup = opts.Dedup
+	config.Transformers = opts.Transformers
+	if opts.Sampling != nil {
+		// Sampling goes first, so dropped events skip the other work
+		s.sampler = newSampler(*opts.Sampling, logger)
+		config.Transformers = append([]eventlib.Transformer{s.sampler.transform}, opts.Transformers...)
+	}
+
+	if opts.Chaos {
+		s.faults, _ = eventlib.NewFaults(eventlib.FaultConfig{})
+		config.Faults = s.faults
+		logger.Warn("Fault injection is enabled; do not run this server in production")
 	}
 
 	handlers := &eventlib.Handlers{
 		OnEvent:       s.onEvent,
 		OnFilter:      s.onFilter,
 		OnStateChange: s.onStateChange,
+		OnExpired:     s.onExpired,
+		OnDeadLetter:  s.onDeadLetter,
+
+		OnQueuePressure: s.onQueuePressure,
 	}
 
-	processor, err := eventlib.New(config, handlers)
+	create := newProcessor
+	if opts.RestoreSnapshot != "" {
+		create = restoreCgoProcessor(opts.RestoreSnapshot)
+	}
+	processor, err := create(config, handlers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create processor: %w", err)
 	}
+	s.tenants = newTenantRegistry(*config, newProcessor, opts.ProcessInterval)
 
 	s.processor = processor
+	if high := opts.HighWatermark; opts.QueueSize > 0 {
+		if high == 0 {
+			high = 0.9
+		}
+		s.highWatermark = max(1, int(high*float64(opts.QueueSize)))
+	}
+
+	// Time handlers per event type when the backend supports middleware
+	if routable, ok := processor.(eventlib.Routable); ok {
+		metrics, err := middleware.Metrics(prometheus.DefaultRegisterer)
+		if err != nil {
+			processor.Close()
+			return nil, fmt.Errorf("failed to register handler metrics: %w", err)
+		}
+		routable.Use(metrics)
+	}
+
+	if opts.Plugins != nil {
+		routable, ok := processor.(eventlib.Routable)
+		if !ok {
+			processor.Close()
+			return nil, fmt.Errorf("plugins need a backend that routes events by type")
+		}
+		s.plugins, err = loadPlugins(*opts.Plugins, routable, logger)
+		if err != nil {
+			processor.Close()
+			return nil, err
+		}
+	}
+
+	// Topics are plain in-process queues whatever the backend. The
+	// server's own queue reports state changes and pressure.
+	topicConfig := *config
+	topicConfig.AsyncPush = nil
+	topicHandlers := *handlers
+	topicHandlers.OnStateChange = nil
+	topicHandlers.OnQueuePressure = nil
+	s.topics, err = eventlib.NewTopicProcessor(&topicConfig, &topicHandlers, opts.Topics)
+	if err != nil {
+		processor.Close()
+		return nil, fmt.Errorf("failed to create topics: %w", err)
+	}
 
 	// Start processor
 	if err := processor.Start(); err != nil {
 		processor.Close()
+		s.topics.Close()
 		return nil, fmt.Errorf("failed to start processor: %w", err)
 	}
+	if err := s.topics.Start(); err != nil {
+		processor.Close()
+		s.topics.Close()
+		return nil, fmt.Errorf("failed to start topics: %w", err)
+	}
+
+	if s.kafkaSink != nil {
+		s.addSink(subscriberKafka, s.kafkaSink.publish)
+	}
+	if s.webhooks != nil {
+		s.addSink(subscriberWebhooks, s.webhooks.publish)
+	}
+	if s.archive != nil {
+		s.archive.start()
+	}
+	if s.eventStore != nil {
+		s.eventStore.start()
+	}
+
+	s.registerHealthChecks(opts)
 
 	// Start background tasks
 	go s.updateMetrics()
+	go s.watchDiagnosticsSignal()
+	go s.watchReloadSignal()
+	go s.limits.run(s.done)
+	go s.runTopics(opts.ProcessInterval)
+	go s.filters.run(s.done)
+	if s.aggregates != nil {
+		go s.aggregates.run(s.done)
+	}
+	if s.alerts != nil {
+		go s.alerts.run(s.done)
+	}
+
+	if len(kafkaConfig.Topics) > 0 {
+		s.kafkaSource = newKafkaSource(kafkaConfig, s)
+	}
+	if s.natsSource != nil {
+		s.natsSource.start()
+	}
+	if s.mqtt != nil {
+		s.mqtt.start()
+	}
+
+	s.processing.interval = opts.ProcessInterval
+	s.processing.autotune = opts.Autotune
+	switch {
+	case opts.Autotune != nil:
+		s.setProcessing(ProcessingAutotune)
+	case opts.ProcessInterval > 0 || opts.ProcessThreshold > 0:
+		s.setProcessing(ProcessingAuto)
+	}
 
 	return s, nil
 }
 
+// newCgoProcessor builds the default in-process C-backed processor
+func newCgoProcessor(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+	return eventlib.New(config, handlers)
+}
+
+// newPoolProcessor builds a ProcessorPool of cgo processors sharded by
+// event source
+func newPoolProcessor(shards int) ProcessorFactory {
+	return func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+		return eventlib.NewPool(eventlib.PoolConfig{Shards: shards}, config, handlers)
+	}
+}
+
 // Close shuts down the server
 func (s *Server) Close() error {
-	close(s.eventBroadcast)
-	return s.processor.Close()
+	s.stopConsumers()
+	close(s.done)
+	err := s.processor.Close()
+	s.topics.Close()
+	s.tenants.close()
+
+	// Nothing more is processed; let the sinks take what is queued
+	s.broadcast.close()
+	s.sinks.Wait()
+	if s.kafkaSink != nil {
+		if sinkErr := s.kafkaSink.close(); sinkErr != nil {
+			s.logger.Warn("Failed to flush Kafka output", zap.Error(sinkErr))
+		}
+	}
+	if s.webhooks != nil {
+		s.webhooks.close()
+	}
+	if s.archive != nil {
+		s.archive.close()
+	}
+	if s.alerts != nil {
+		s.alerts.close()
+	}
+	if s.eventStore != nil {
+		if storeErr := s.eventStore.close(); storeErr != nil {
+			s.logger.Warn("Failed to close event store", zap.Error(storeErr))
+		}
+	}
+	if s.plugins != nil {
+		s.plugins.close()
+	}
+	if s.idempotency != nil {
+		if storeErr := s.idempotency.close(); storeErr != nil {
+			s.logger.Warn("Failed to close idempotency store", zap.Error(storeErr))
+		}
+	}
+	return err
+}
+
+// stopConsumers stops ingestion from Kafka, NATS and MQTT
+func (s *Server) stopConsumers() {
+	if s.mqtt != nil {
+		s.mqtt.stop()
+	}
+	if s.kafkaSource != nil {
+		s.kafkaSource.stop()
+	}
+	if s.natsSource != nil {
+		s.natsSource.stop()
+	}
+}
+
+// onAsyncError records an event that was accepted by an async push but
+// never made it into the queue
+func (s *Server) onAsyncError(event eventlib.Event, err error) {
+	asyncPushFailures.WithLabelValues(event.Type.String(), event.Source).Inc()
+	s.logger.Warn("Dropped accepted event",
+		zap.String("type", event.Type.String()),
+		zap.String("source", event.Source),
+		zap.Error(err))
 }
 
 // Event handlers
-func (s *Server) onEvent(event eventlib.Event) {
+func (s *Server) onEvent(event eventlib.Event) error {
 	eventsProcessed.WithLabelValues(
 		event.Type.String(),
 		event.Source,
 	).Inc()
+	ctx := eventlib.ContextWithTrace(context.Background(), event)
+	if latency := event.QueueLatency(); latency > 0 {
+		observeTrace(ctx, queueLatency, latency.Seconds())
+	}
+	s.traffic.record(event, trafficProcessed)
 
-	s.logger.Info("Event processed",
+	fields := []zap.Field{
 		zap.String("type", event.Type.String()),
 		zap.String("source", event.Source),
-		zap.Int("data_len", len(event.Data)))
+		zap.Int("data_len", len(event.Data)),
+	}
+	if event.CorrelationID != "" {
+		fields = append(fields, zap.String("correlation_id", event.CorrelationID))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.Stringer("trace_id", sc.TraceID()))
+	}
+	s.logger.Info("Event processed", fields...)
+
+	if s.history != nil {
+		s.history.record(event)
+	}
+	if s.aggregates != nil {
+		s.aggregates.record(event)
+	}
+
+	// Backfilled history must not show up on live outputs
+	msg := newEventMessage(event)
+	if !event.Backfill {
+		if s.alerts != nil {
+			s.alerts.record(event)
+		}
+		msg = s.broadcast.publish(msg)
+	}
+
+	// The archive and the event store keep every event, backfill
+	// included. They are fed here rather than from the broadcaster, which
+	// drops events for outputs that fall behind; when their queues are
+	// full they hold up processing instead.
+	if s.archive != nil {
+		s.archive.publish(msg)
+	}
+	if s.eventStore != nil {
+		s.eventStore.publish(msg)
+	}
+	return nil
 }
 
 func (s *Server) onFilter(event eventlib.Event) bool {
-	// Example: filter out events from "blocked" sources
-	if event.Source == "blocked" {
+	allowed, rule := s.filters.current.Load().allow(event)
+	if !allowed {
+		s.traffic.record(event, trafficFiltered)
 		s.logger.Debug("Event filtered",
-			zap.String("source", event.Source))
-		return false
+			zap.String("source", event.Source),
+			zap.String("rule", rule))
 	}
-	return true
+	return allowed
 }
 
 func (s *Server) onStateChange(oldState, newState string) {
@@ -118,22 +822,100 @@ func (s *Server) onStateChange(oldState, newState string) {
 		zap.String("to", newState))
 }
 
+func (s *Server) onQueuePressure(high bool, queueSize int) {
+	if high {
+		s.logger.Warn("Queue reached its high watermark",
+			zap.Int("queue_size", queueSize))
+		return
+	}
+	s.logger.Info("Queue fell back to its low watermark",
+		zap.Int("queue_size", queueSize))
+}
+
+// queuePressured reports whether the queue is past its high watermark
+func (s *Server) queuePressured() bool {
+	if p, ok := s.processor.(eventlib.PressureReporter); ok {
+		return p.QueuePressure()
+	}
+	return s.highWatermark > 0 && s.processor.QueueSize() >= s.highWatermark
+}
+
+func (s *Server) onExpired(event eventlib.Event) {
+	s.drops.record(event, "expired")
+	s.traffic.record(event, trafficExpired)
+
+	eventsExpired.WithLabelValues(
+		event.Type.String(),
+		event.Source,
+	).Inc()
+
+	s.logger.Warn("Event expired before processing",
+		zap.String("type", event.Type.String()),
+		zap.String("source", event.Source),
+		zap.Time("deadline", event.Deadline))
+}
+
+// onDeadLetter records an event whose handlers failed on every attempt
+func (s *Server) onDeadLetter(letter eventlib.DeadLetter) {
+	s.drops.record(letter.Event, "dead-lettered: "+letter.Err.Error())
+	s.traffic.record(letter.Event, trafficFailed)
+
+	deadLetters.WithLabelValues(
+		letter.Event.Type.String(),
+		letter.Event.Source,
+	).Inc()
+}
+
 // HTTP handlers
 func (s *Server) handlePostEvent(w http.ResponseWriter, r *http.Request) {
-	var req EventRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+	deadline, err := parseDeadline(r.Header.Get("X-Deadline"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid X-Deadline header")
 		return
 	}
 
-	event := eventlib.Event{
-		Type:   eventlib.EventType(req.Type),
-		Source: req.Source,
-		Data:   req.Data,
+	req, err := readEventRequest(r)
+	if err != nil {
+		s.writeBodyError(w, err, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+
+	event := req.toEvent(deadline)
+
+	if err := s.schemas.check(event); err != nil {
+		s.drops.record(event, err.Error())
+		s.writeSchemaError(w, err)
+		return
+	}
+
+	if allowed, retryAfter := s.allowSource(event.Source); !allowed {
+		s.drops.record(event, "rate limited")
+		s.writeRateLimited(w, retryAfter)
+		return
+	}
+
+	// Record the request's span so processing joins its trace
+	event = eventlib.WithTrace(r.Context(), event)
+
+	scheduled := isScheduled(event)
+	if scheduled {
+		if event, err = s.prepareScheduled(event); err != nil {
+			s.writeError(w, http.StatusNotImplemented, "Scheduled delivery not supported by this backend")
+			return
+		}
+	}
+
+	if sync, _ := strconv.ParseBool(r.URL.Query().Get("sync")); sync {
+		s.pushAndWait(w, r, event)
+		return
 	}
 
 	if err := s.processor.Push(event); err != nil {
-		s.writeError(w, http.StatusServiceUnavailable, "Failed to queue event")
+		s.drops.record(event, err.Error())
+		s.writePushError(w, err)
 		return
 	}
 
@@ -141,53 +923,469 @@ func (s *Server) handlePostEvent(w http.ResponseWriter, r *http.Request) {
 		event.Type.String(),
 		event.Source,
 	).Inc()
+	if scheduled {
+		s.writeJSON(w, http.StatusAccepted, map[string]string{
+			"status":     "scheduled",
+			"event_id":   event.ID,
+			"deliver_at": event.DeliverAt.Format(time.RFC3339Nano),
+		})
+		return
+	}
+	s.notifyPushed()
 
 	s.writeJSON(w, http.StatusAccepted, map[string]string{
 		"status": "queued",
 	})
 }
 
+// defaultSyncTimeout bounds how long POST /events?sync=true waits, unless
+// the request sets ?timeout=
+const defaultSyncTimeout = 10 * time.Second
+
+// pushAndWait pushes event and processes until it is done, answering with
+// its outcome. Events without an ID get a random one, since the outcome is
+// matched by ID.
+func (s *Server) pushAndWait(w http.ResponseWriter, r *http.Request, event eventlib.Event) {
+	waiter, ok := s.processor.(eventlib.Waiter)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Synchronous processing requires the cgo backend")
+		return
+	}
+
+	timeout := defaultSyncTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			s.writeError(w, http.StatusBadRequest, "Invalid timeout")
+			return
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if event.ID == "" {
+		event.ID = randomEventID()
+	}
+
+	outcome, err := waiter.PushAndWait(ctx, event)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		s.writeError(w, http.StatusGatewayTimeout, "Timed out waiting for the event; it may still be processed")
+		return
+	case errors.Is(err, eventlib.ErrAlreadyWaiting):
+		s.writeError(w, http.StatusConflict, "A request is already waiting on an event with this ID")
+		return
+	case err != nil:
+		s.drops.record(event, err.Error())
+		s.writePushError(w, err)
+		return
+	}
+
+	eventsReceived.WithLabelValues(
+		event.Type.String(),
+		event.Source,
+	).Inc()
+
+	resp := SyncEventResponse{
+		Status:   outcome.Status.String(),
+		EventID:  event.ID,
+		Attempts: outcome.Attempts,
+		Duration: outcome.Duration.String(),
+	}
+	if outcome.Err != nil {
+		resp.Error = outcome.Err.Error()
+	}
+
+	status := http.StatusOK
+	switch outcome.Status {
+	case eventlib.OutcomeFailed:
+		status = http.StatusInternalServerError
+	case eventlib.OutcomeExpired:
+		status = http.StatusGatewayTimeout
+	case eventlib.OutcomeDropped:
+		status = http.StatusServiceUnavailable
+	case eventlib.OutcomePurged:
+		status = http.StatusGone
+	}
+	s.writeJSON(w, status, resp)
+}
+
+// writePushError answers a request whose event the processor refused
+func (s *Server) writePushError(w http.ResponseWriter, err error) {
+	recordPushFailure(err)
+	switch {
+	case errors.Is(err, eventlib.ErrQueueFull):
+		// Transient: the client should retry once the queue drains
+		w.Header().Set("Retry-After", "1")
+		s.writeError(w, http.StatusServiceUnavailable, "Queue is full")
+	case errors.Is(err, eventlib.ErrPaused):
+		s.writePaused(w)
+	case errors.Is(err, eventlib.ErrClosed):
+		s.writeError(w, http.StatusServiceUnavailable, "Processor is closed")
+	case errors.Is(err, eventlib.ErrDraining):
+		s.writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+	case errors.Is(err, eventlib.ErrTransform):
+		s.writeError(w, http.StatusUnprocessableEntity, "Failed to transform event: "+err.Error())
+	case errors.Is(err, eventlib.ErrEventTooLarge):
+		s.writeError(w, http.StatusRequestEntityTooLarge, "Event is too large: "+err.Error())
+	default:
+		s.logger.Error("Failed to queue event", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, "Failed to queue event")
+	}
+}
+
+// writeBodyError answers a request whose body could not be read or parsed,
+// with 413 if it was over -max-request-size
+func (s *Server) writeBodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body is over the limit of %d bytes", tooLarge.Limit))
+		return
+	}
+	s.writeError(w, http.StatusBadRequest, message)
+}
+
 func (s *Server) handleBatchEvents(w http.ResponseWriter, r *http.Request) {
-	var req BatchEventRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+	deadline, err := parseDeadline(r.Header.Get("X-Deadline"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid X-Deadline header")
+		return
+	}
+
+	req, err := readBatchRequest(r)
+	if err != nil {
+		s.writeBodyError(w, err, "Invalid request body")
+		return
+	}
+
+	allOrNothing, _ := strconv.ParseBool(r.URL.Query().Get("atomic"))
+
+	resp := BatchEventResponse{Results: make([]BatchEventResult, len(req.Events))}
+	refused := 0
+	var retryAfter time.Duration
+
+	// Events that passed validation and rate limiting, with their
+	// positions in the request
+	events := make([]eventlib.Event, 0, len(req.Events))
+	indexes := make([]int, 0, len(req.Events))
+
+	for i, e := range req.Events {
+		if e.Priority == nil {
+			e.Priority = req.Priority
+		}
+		event, reason, wait, err := s.batchEvent(r, e, deadline, !allOrNothing)
+		resp.Results[i] = BatchEventResult{Index: i, Status: reason, EventID: event.ID}
+		switch {
+		case reason == "":
+			events = append(events, event)
+			indexes = append(indexes, i)
+			continue
+		case reason == refusedRateLimit:
+			retryAfter = max(retryAfter, wait)
+			resp.Results[i].Error = "rate limited"
+		case err != nil:
+			resp.Results[i].Error = err.Error()
+			s.logger.Warn("Invalid event in batch",
+				zap.Error(err),
+				zap.Int("index", i))
+		}
+		refused++
+	}
+
+	// An atomic batch takes its rate limit tokens once every event has
+	// passed the other checks, all at once or none, so a rejected batch
+	// does not use up its sources' limits
+	if allOrNothing && refused == 0 {
+		sources := make(map[string]int)
+		for _, event := range events {
+			sources[event.Source]++
+		}
+		if short, wait := s.allowSources(sources); len(short) > 0 {
+			retryAfter = wait
+			for j, event := range events {
+				if !slices.Contains(short, event.Source) {
+					continue
+				}
+				result := &resp.Results[indexes[j]]
+				result.Status = refusedRateLimit
+				result.Error = "rate limited"
+				s.drops.record(event, "rate limited")
+				refused++
+			}
+		}
+	}
+
+	// An atomic batch is all or nothing as far as the checks go
+	if allOrNothing && refused > 0 {
+		for _, i := range indexes {
+			if resp.Results[i].Status != "" {
+				continue
+			}
+			// An ID assigned for scheduling was never used
+			resp.Results[i].Status = refusedSkipped
+			resp.Results[i].EventID = req.Events[i].ID
+		}
+		resp.count()
+		if resp.RateLimited == refused {
+			s.writeRateLimited(w, retryAfter)
+			return
+		}
+		resp.Error = fmt.Sprintf("Batch rejected: %d of %d events refused", refused, len(req.Events))
+		s.writeJSON(w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	paused := 0
+	for j, err := range s.pushBatch(events) {
+		event := events[j]
+		result := &resp.Results[indexes[j]]
+		if err != nil {
+			result.Status = pushRefusal(err)
+			result.Error = err.Error()
+			if result.Status == refusedPaused {
+				paused++
+			}
+			s.drops.record(event, err.Error())
+			s.logger.Warn("Failed to queue event in batch",
+				zap.Error(err),
+				zap.Int("index", indexes[j]))
+			continue
+		}
+		result.Status = pushedStatus(event)
+		eventsReceived.WithLabelValues(
+			event.Type.String(),
+			event.Source,
+		).Inc()
+	}
+
+	s.notifyPushed()
+	resp.count()
+
+	// Only reject the request outright if nothing got through
+	if resp.RateLimited > 0 && resp.Queued == 0 && resp.RateLimited == resp.Failed {
+		s.writeRateLimited(w, retryAfter)
+		return
+	}
+	if paused > 0 && resp.Queued == 0 && paused == resp.Failed {
+		s.writePaused(w)
+		return
+	}
+	if resp.TooLarge > 0 && resp.Queued == 0 && resp.TooLarge == resp.Failed {
+		s.writeError(w, http.StatusRequestEntityTooLarge, "Events are too large")
+		return
+	}
+
+	s.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// Statuses of the events of a batch. The refused ones say why the event
+// was not queued: batchEvent checks for the first three, and pushRefusal
+// maps push errors to the rest.
+const (
+	statusQueued    = "queued"
+	statusScheduled = "scheduled"
+
+	refusedInvalid   = "invalid"
+	refusedSchema    = "schema_invalid"
+	refusedRateLimit = "rate_limited"
+	refusedTooLarge  = "too_large"
+	refusedPaused    = "paused"
+	refusedQueueFull = "queue_full"
+	refusedFailed    = "failed"
+
+	// refusedSkipped marks the valid events of a rejected atomic batch
+	refusedSkipped = "skipped"
+)
+
+// pushRefusal is the status of an event whose push failed with err
+func pushRefusal(err error) string {
+	switch {
+	case errors.Is(err, eventlib.ErrEventTooLarge):
+		return refusedTooLarge
+	case errors.Is(err, eventlib.ErrPaused):
+		return refusedPaused
+	case errors.Is(err, eventlib.ErrQueueFull):
+		return refusedQueueFull
+	}
+	return refusedFailed
+}
+
+// pushedStatus is the status of a pushed event
+func pushedStatus(event eventlib.Event) string {
+	if isScheduled(event) {
+		return statusScheduled
+	}
+	return statusQueued
+}
+
+// add counts one result
+func (c *BatchCounts) add(status string) {
+	switch status {
+	case statusQueued, statusScheduled:
+		c.Queued++
+		return
+	case refusedRateLimit:
+		c.RateLimited++
+	case refusedSchema:
+		c.SchemaInvalid++
+	case refusedTooLarge:
+		c.TooLarge++
+	}
+	c.Failed++
+}
+
+// count fills in the counts from the results
+func (resp *BatchEventResponse) count() {
+	resp.BatchCounts = BatchCounts{}
+	for _, result := range resp.Results {
+		resp.add(result.Status)
+	}
+}
+
+// batchEvent turns e, one event of a batch, into the event to push. If it
+// is refused, refused says why, with err for an invalid event or retryAfter
+// for a rate-limited one. Refused events other than invalid ones are
+// recorded as drops. Without limit, the caller applies the rate limit.
+func (s *Server) batchEvent(r *http.Request, e EventRequest, deadline time.Time, limit bool) (event eventlib.Event, refused string, retryAfter time.Duration, err error) {
+	if err := e.validate(); err != nil {
+		return event, refusedInvalid, 0, err
+	}
+	if e.CorrelationID == "" {
+		e.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+	event = e.toEvent(deadline)
+
+	if err := s.schemas.check(event); err != nil {
+		s.drops.record(event, err.Error())
+		return event, refusedSchema, 0, err
+	}
+
+	if limit {
+		if allowed, wait := s.allowSource(event.Source); !allowed {
+			s.drops.record(event, "rate limited")
+			return event, refusedRateLimit, wait, nil
+		}
+	}
+
+	if isScheduled(event) {
+		if event, err = s.prepareScheduled(event); err != nil {
+			return event, refusedInvalid, 0, err
+		}
+	}
+	return eventlib.WithTrace(r.Context(), event), "", 0, nil
+}
+
+// pushBatch queues events in one call when the backend supports it,
+// returning one error per event
+func (s *Server) pushBatch(events []eventlib.Event) []error {
+	batchSize.Observe(float64(len(events)))
+
+	var errs []error
+	if bp, ok := s.processor.(eventlib.BatchPusher); ok {
+		_, errs = bp.PushBatch(events)
+	} else {
+		errs = make([]error, len(events))
+		for i, event := range events {
+			errs[i] = s.processor.Push(event)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			recordPushFailure(err)
+		}
+	}
+	return errs
+}
+
+// handleBackfill ingests historical events with their original timestamps.
+// They are processed like any other event but flagged so live-only outputs
+// (streaming subscribers, alerting) skip them. They fill the same queue as
+// live events, so they count against their source's rate limit too.
+func (s *Server) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	req, err := readBatchRequest(r)
+	if err != nil {
+		s.writeBodyError(w, err, "Invalid request body")
 		return
 	}
 
 	queued := 0
 	failed := 0
+	limited := 0
+	var retryAfter time.Duration
 
-	for _, e := range req.Events {
-		event := eventlib.Event{
-			Type:   eventlib.EventType(e.Type),
-			Source: e.Source,
-			Data:   e.Data,
+	for i, e := range req.Events {
+		if e.Timestamp == nil {
+			failed++
+			s.logger.Warn("Backfill event missing timestamp",
+				zap.Int("index", i))
+			continue
+		}
+		if err := e.validate(); err != nil {
+			failed++
+			s.logger.Warn("Invalid backfill event",
+				zap.Error(err),
+				zap.Int("index", i))
+			continue
+		}
+
+		event := eventlib.WithTrace(r.Context(), e.toEvent(time.Time{}))
+		event.Backfill = true
+
+		if err := s.schemas.check(event); err != nil {
+			failed++
+			s.drops.record(event, err.Error())
+			s.logger.Warn("Invalid backfill event",
+				zap.Error(err),
+				zap.Int("index", i))
+			continue
+		}
+
+		if allowed, wait := s.allowSource(event.Source); !allowed {
+			failed++
+			limited++
+			retryAfter = max(retryAfter, wait)
+			s.drops.record(event, "rate limited")
+			continue
 		}
 
 		if err := s.processor.Push(event); err != nil {
 			failed++
-			s.logger.Warn("Failed to queue event in batch",
+			s.drops.record(event, err.Error())
+			s.logger.Warn("Failed to queue backfill event",
 				zap.Error(err),
-				zap.Int("index", queued+failed))
-		} else {
-			queued++
-			eventsReceived.WithLabelValues(
-				event.Type.String(),
-				event.Source,
-			).Inc()
+				zap.Int("index", i))
+			continue
 		}
+
+		queued++
+		eventsBackfilled.WithLabelValues(
+			event.Type.String(),
+			event.Source,
+		).Inc()
+	}
+
+	s.notifyPushed()
+
+	// As for a batch, only reject the request outright if nothing got
+	// through
+	if limited > 0 && queued == 0 && limited == failed {
+		s.writeRateLimited(w, retryAfter)
+		return
 	}
 
 	s.writeJSON(w, http.StatusAccepted, map[string]int{
-		"queued": queued,
-		"failed": failed,
+		"queued":       queued,
+		"failed":       failed,
+		"rate_limited": limited,
 	})
 }
 
 func (s *Server) handleProcess(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	s.processor.Process()
-	processingDuration.Observe(time.Since(start).Seconds())
+	observeTrace(r.Context(), processingDuration, time.Since(start).Seconds())
 
 	s.writeJSON(w, http.StatusOK, map[string]string{
 		"status": "processed",
@@ -198,10 +1396,12 @@ func (s *Server) handleProcessAll(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	before := s.processor.EventsProcessed()
 
-	s.processor.ProcessAll()
+	if err := s.processAll(r.Context()); err != nil {
+		s.logger.Warn("Processing cancelled", zap.Error(err))
+	}
 
 	after := s.processor.EventsProcessed()
-	processingDuration.Observe(time.Since(start).Seconds())
+	observeTrace(r.Context(), processingDuration, time.Since(start).Seconds())
 
 	s.writeJSON(w, http.StatusOK, map[string]interface{}{
 		"status":    "processed",
@@ -210,6 +1410,57 @@ func (s *Server) handleProcessAll(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// processAll drains the queue, stopping early when ctx is done if the
+// backend supports cancellation
+func (s *Server) processAll(ctx context.Context) error {
+	// Buffered async pushes count as queued
+	if f, ok := s.processor.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+	if cp, ok := s.processor.(eventlib.ContextProcessor); ok {
+		return cp.ProcessAllContext(ctx)
+	}
+	s.processor.ProcessAll()
+	return ctx.Err()
+}
+
+// drain finishes queued work before shutdown. Backends that cannot drain
+// keep their queue, except during an upgrade, when the old process still
+// works off what it accepted.
+func (s *Server) drain(ctx context.Context, upgraded bool) {
+	if drained, abandoned, err := s.topics.Drain(ctx); err != nil {
+		s.logger.Warn("Topic drain cut short", zap.Error(err),
+			zap.Int("drained", drained),
+			zap.Int("abandoned", abandoned))
+	} else if drained > 0 {
+		s.logger.Info("Drained topics", zap.Int("drained", drained))
+	}
+
+	if d, ok := s.processor.(eventlib.Drainer); ok {
+		drained, abandoned, err := d.Drain(ctx)
+		if err != nil {
+			s.logger.Warn("Queue drain cut short", zap.Error(err),
+				zap.Int("drained", drained),
+				zap.Int("abandoned", abandoned))
+			return
+		}
+		s.logger.Info("Drained queue",
+			zap.Int("drained", drained),
+			zap.Int("abandoned", abandoned))
+		return
+	}
+
+	if !upgraded {
+		return
+	}
+	pending := s.processor.QueueSize()
+	if err := s.processAll(ctx); err != nil {
+		s.logger.Warn("Queue drain cut short", zap.Error(err),
+			zap.Int("remaining", s.processor.QueueSize()))
+	}
+	s.logger.Info("Drained queue before handoff", zap.Int("events", pending))
+}
+
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	status := StatusResponse{
 		State:           s.processor.State(),
@@ -217,29 +1468,151 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		EventsProcessed: s.processor.EventsProcessed(),
 		Timestamp:       time.Now(),
 	}
+	if provider, ok := s.processor.(eventlib.StatsProvider); ok {
+		library := newLibraryStatsResponse(provider.Stats().Library)
+		status.Library = &library
+	}
 
 	s.writeJSON(w, http.StatusOK, status)
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	health := HealthResponse{
-		Status: "healthy",
-		Checks: map[string]bool{
-			"processor": s.processor.State() == "RUNNING",
-			"queue":     s.processor.QueueSize() < 9000, // 90% threshold
-		},
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.processor.(eventlib.StatsProvider)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Stats not supported by this backend")
+		return
 	}
 
-	// Determine overall health
-	for _, check := range health.Checks {
-		if !check {
-			health.Status = "unhealthy"
-			w.WriteHeader(http.StatusServiceUnavailable)
-			break
+	top := 0
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.writeError(w, http.StatusBadRequest, "Invalid top parameter")
+			return
 		}
+		top = n
+	}
+
+	stats := provider.Stats()
+	byType, bySource := s.traffic.snapshot(top)
+	s.writeJSON(w, http.StatusOK, StatsResponse{
+		Name:            stats.Name,
+		State:           stats.State,
+		UptimeSeconds:   stats.Uptime.Seconds(),
+		QueueSize:       stats.QueueSize,
+		Pushed:          stats.Pushed,
+		Processed:       stats.Processed,
+		Dropped:         stats.Dropped,
+		Filtered:        stats.Filtered,
+		Duplicates:      stats.Duplicates,
+		Expired:         stats.Expired,
+		Retried:         stats.Retried,
+		DeadLettered:    stats.DeadLettered,
+		RetryPending:    stats.RetryPending,
+		Scheduled:       stats.Scheduled,
+		ProcessedByType: stats.ProcessedByType,
+		CgoCalls:        stats.CgoCalls,
+		Callbacks:       stats.Callbacks,
+		HandlerLatency:  newLatencyResponse(stats.HandlerLatency),
+		QueueLatency:    newLatencyResponse(stats.QueueLatency),
+		Library:         newLibraryStatsResponse(stats.Library),
+		Timestamp:       time.Now(),
+		ByType:          byType,
+		BySource:        bySource,
+	})
+}
+
+// handleDeadLetters lists the most recent events whose handlers failed on
+// every attempt, oldest first
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.processor.(eventlib.DeadLetterProvider)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Dead letters not supported by this backend")
+		return
+	}
+
+	letters := provider.DeadLetters()
+	response := make([]DeadLetterResponse, 0, len(letters))
+	for _, letter := range letters {
+		response = append(response, DeadLetterResponse{
+			Event:    newEventMessage(letter.Event),
+			Error:    letter.Err.Error(),
+			Attempts: letter.Attempts,
+			Time:     letter.Time,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func newLibraryStatsResponse(lib eventlib.LibraryStats) LibraryStatsResponse {
+	return LibraryStatsResponse{
+		Submitted:         lib.Submitted,
+		Filtered:          lib.Filtered,
+		Rejected:          lib.Rejected,
+		Cleared:           lib.Cleared,
+		QueueHighWater:    lib.QueueHighWater,
+		MaxQueueSize:      lib.MaxQueueSize,
+		ProcessingTime:    lib.ProcessingTime.Seconds(),
+		MaxProcessingTime: lib.MaxProcessingTime.Seconds(),
 	}
+}
 
-	s.writeJSON(w, http.StatusOK, health)
+func newLatencyResponse(summary eventlib.LatencySummary) LatencyResponse {
+	return LatencyResponse{
+		Count: summary.Count,
+		P50:   summary.P50.Seconds(),
+		P90:   summary.P90.Seconds(),
+		P99:   summary.P99.Seconds(),
+		Max:   summary.Max.Seconds(),
+	}
+}
+
+// toEvent converts a request into an event, applying the request-wide
+// deadline unless the event carries its own
+func (req EventRequest) toEvent(deadline time.Time) eventlib.Event {
+	data, contentType := req.payload()
+	event := eventlib.Event{
+		ID:          req.ID,
+		Type:        req.Type,
+		Source:      req.Source,
+		Data:        data,
+		ContentType: contentType,
+		Deadline:    deadline,
+
+		CorrelationID: req.CorrelationID,
+		Metadata:      req.Metadata,
+	}
+	if req.Deadline != nil {
+		event.Deadline = *req.Deadline
+	}
+	if req.Timestamp != nil {
+		event.Timestamp = *req.Timestamp
+	}
+	if req.DeliverAt != nil {
+		event.DeliverAt = *req.DeliverAt
+	} else if req.DelayMs > 0 {
+		event.DeliverAt = time.Now().Add(time.Duration(req.DelayMs) * time.Millisecond)
+	}
+	event.Priority = event.Type.DefaultPriority()
+	if req.Priority != nil {
+		event.Priority = *req.Priority
+	}
+	return event
+}
+
+// parseDeadline accepts an RFC 3339 timestamp or a duration relative to now
+func parseDeadline(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(d), nil
 }
 
 // Helper methods
@@ -259,7 +1632,14 @@ func (s *Server) updateMetrics() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		queueSizeGauge.Set(float64(s.processor.QueueSize()))
+	for {
+		select {
+		case <-ticker.C:
+			queueSizeGauge.Set(float64(s.processor.QueueSize()))
+			s.tenants.updateMetrics()
+			s.updateTopicMetrics()
+		case <-s.done:
+			return
+		}
 	}
 }
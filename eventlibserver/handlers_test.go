@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// newTestServer returns a server built from opts, closed when the test
+// ends. Tests call its handlers directly rather than through the router
+// main builds.
+func newTestServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+
+	if opts.Name == "" {
+		opts.Name = "test"
+	}
+	s, err := NewServer(opts, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// serve runs handler on a request with body, which is sent as JSON unless
+// empty
+func serve(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", contentTypeJSON)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decodeBody decodes a JSON response into v
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+// checkStatus fails the test unless the response has status code want
+func checkStatus(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if w.Code != want {
+		t.Fatalf("status %d, want %d: %s", w.Code, want, w.Body.String())
+	}
+}
+
+// limitedServer allows each source a burst of two events and then one
+// an hour, so the tokens a test spends are not refilled while it runs
+func limitedServer(t *testing.T) *Server {
+	return newTestServer(t, Options{
+		RateLimit: &RateLimitConfig{PerSource: RateLimit{Rate: 1.0 / 3600, Burst: 2}},
+	})
+}
+
+func TestAtomicBatchKeepsTokens(t *testing.T) {
+	s := limitedServer(t)
+
+	// The invalid event sinks the batch before the valid one takes a token
+	w := serve(s.handleBatchEvents, http.MethodPost, "/api/v1/events/batch?atomic=true",
+		`{"events": [{"source": "s"}, {"source": "s", "delay_ms": -1}]}`)
+	checkStatus(t, w, http.StatusUnprocessableEntity)
+
+	// Over the limit too: the batch is refused with 429 and keeps nothing
+	w = serve(s.handleBatchEvents, http.MethodPost, "/api/v1/events/batch?atomic=true",
+		`{"events": [{"source": "s"}, {"source": "s"}, {"source": "s"}]}`)
+	checkStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("429 without Retry-After")
+	}
+
+	// Neither rejected batch spent the burst
+	w = serve(s.handleBatchEvents, http.MethodPost, "/api/v1/events/batch?atomic=true",
+		`{"events": [{"source": "s"}, {"source": "s"}]}`)
+	checkStatus(t, w, http.StatusAccepted)
+	var resp BatchEventResponse
+	decodeBody(t, w, &resp)
+	if resp.Queued != 2 {
+		t.Fatalf("queued %d of the burst of 2: %+v", resp.Queued, resp)
+	}
+}
+
+func TestBackfillRateLimited(t *testing.T) {
+	s := limitedServer(t)
+	const backfill = `{"events": [
+		{"source": "s", "timestamp": "2024-01-01T00:00:00Z"},
+		{"source": "s", "timestamp": "2024-01-01T00:00:01Z"},
+		{"source": "s", "timestamp": "2024-01-01T00:00:02Z"}
+	]}`
+
+	w := serve(s.handleBackfill, http.MethodPost, "/api/v1/events/backfill", backfill)
+	checkStatus(t, w, http.StatusAccepted)
+	var counts map[string]int
+	decodeBody(t, w, &counts)
+	if counts["queued"] != 2 || counts["failed"] != 1 || counts["rate_limited"] != 1 {
+		t.Fatalf("backfill counts %v, want 2 queued and 1 rate limited", counts)
+	}
+
+	// Nothing gets through now, so the whole request is refused
+	w = serve(s.handleBackfill, http.MethodPost, "/api/v1/events/backfill", backfill)
+	checkStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("429 without Retry-After")
+	}
+}
+
+// processEvents pushes events straight into s's processor and processes
+// them all
+func processEvents(t *testing.T, s *Server, events ...eventlib.Event) {
+	t.Helper()
+
+	for _, event := range events {
+		if err := s.processor.Push(event); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	s.processor.ProcessAll()
+}
+
+// bareProcessor hides every optional interface of the processor it wraps,
+// as a minimal backend would
+type bareProcessor struct {
+	eventlib.Processor
+}
+
+func TestStats(t *testing.T) {
+	s := newTestServer(t, Options{})
+	processEvents(t, s,
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "a"},
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "b"},
+		eventlib.Event{Type: eventlib.EventTypeConnect, Source: "a"})
+
+	w := serve(s.handleStats, http.MethodGet, "/api/v1/stats", "")
+	checkStatus(t, w, http.StatusOK)
+	var stats StatsResponse
+	decodeBody(t, w, &stats)
+	if stats.Name != "test" || stats.Pushed != 3 || stats.Processed != 3 || stats.QueueSize != 0 {
+		t.Fatalf("stats %+v, want 3 pushed and processed", stats)
+	}
+	if stats.ProcessedByType["DATA"] != 2 || stats.ProcessedByType["CONNECT"] != 1 {
+		t.Fatalf("processed by type %v", stats.ProcessedByType)
+	}
+	if stats.HandlerLatency.Count != 3 || stats.UptimeSeconds <= 0 {
+		t.Fatalf("handler latency %+v over %gs uptime", stats.HandlerLatency, stats.UptimeSeconds)
+	}
+}
+
+func TestStatsUnsupported(t *testing.T) {
+	s := newTestServer(t, Options{
+		NewProcessor: func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+			p, err := newCgoProcessor(config, handlers)
+			return bareProcessor{p}, err
+		},
+	})
+
+	w := serve(s.handleStats, http.MethodGet, "/api/v1/stats", "")
+	checkStatus(t, w, http.StatusNotImplemented)
+}
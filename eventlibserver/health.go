@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+var (
+	healthCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eventlibgo_http_health_check_duration_seconds",
+		Help:    "Time taken by each health check",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"check"})
+
+	healthCheckFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_health_check_failures_total",
+		Help: "Total number of failed health checks",
+	}, []string{"check"})
+)
+
+// probe is a set of the probe endpoints a health check runs under
+type probe uint8
+
+const (
+	probeLive probe = 1 << iota
+	probeReady
+	probeStartup
+)
+
+// healthCheckTimeout bounds each check, so a hung dependency fails its
+// probe instead of hanging it
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck is one named check; check returns nil when healthy
+type healthCheck struct {
+	name   string
+	probes probe
+	check  func(ctx context.Context) error
+}
+
+// healthChecks is the server's check registry
+type healthChecks struct {
+	mu     sync.RWMutex
+	checks []healthCheck
+}
+
+// registerCheck adds a check to every probe in probes. Checks run
+// concurrently on each request, so they must be safe for concurrent use.
+func (s *Server) registerCheck(name string, probes probe, check func(ctx context.Context) error) {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	s.health.checks = append(s.health.checks, healthCheck{name: name, probes: probes, check: check})
+}
+
+// run runs the checks registered for p, in registration order
+func (hc *healthChecks) run(ctx context.Context, p probe) []HealthCheckResult {
+	hc.mu.RLock()
+	var checks []healthCheck
+	for _, c := range hc.checks {
+		if c.probes&p != 0 {
+			checks = append(checks, c)
+		}
+	}
+	hc.mu.RUnlock()
+
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, c)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runCheck runs c under healthCheckTimeout. A check that overruns is
+// reported as failed and left to finish in the background.
+func runCheck(ctx context.Context, c healthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- c.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out: %w", ctx.Err())
+	}
+
+	latency := time.Since(start)
+	healthCheckDuration.WithLabelValues(c.name).Observe(latency.Seconds())
+
+	result := HealthCheckResult{
+		Name:    c.name,
+		OK:      err == nil,
+		Latency: latency.String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		healthCheckFailures.WithLabelValues(c.name).Inc()
+	}
+	return result
+}
+
+// registerHealthChecks registers the built-in checks for the configured
+// components. Liveness only covers the process itself, so a broken
+// dependency takes the server out of rotation without restarting it.
+func (s *Server) registerHealthChecks(opts Options) {
+	s.registerCheck("started", probeReady|probeStartup, func(context.Context) error {
+		if !s.started.Load() {
+			return errors.New("server is still starting")
+		}
+		return nil
+	})
+
+	s.registerCheck("processor", probeLive, func(context.Context) error {
+		switch state := s.processor.State(); state {
+		case "ERROR", "CLOSED":
+			return fmt.Errorf("processor is %s", state)
+		}
+		return nil
+	})
+
+	s.registerCheck("processing", probeReady, func(context.Context) error {
+		if state := s.processor.State(); state != "RUNNING" {
+			return fmt.Errorf("processor is %s", state)
+		}
+		return nil
+	})
+
+	s.registerCheck("queue", probeReady, func(context.Context) error {
+		if s.queuePressured() {
+			return fmt.Errorf("queue is above its high watermark (%d events)", s.processor.QueueSize())
+		}
+		return nil
+	})
+
+	s.registerCheck("intake", probeReady, func(context.Context) error {
+		if s.ingestPaused.Load() {
+			return errors.New("ingest is paused")
+		}
+		if p, ok := s.processor.(eventlib.Pausable); ok && p.Paused() {
+			return errors.New("processor intake is paused")
+		}
+		return nil
+	})
+
+	if dir := opts.PersistencePath; dir != "" {
+		s.registerCheck("persistence", probeReady, func(context.Context) error {
+			return checkWritable(dir)
+		})
+	}
+
+	if s.mqtt != nil {
+		s.registerCheck("mqtt", probeReady, func(context.Context) error {
+			if !s.mqtt.isConnected() {
+				return errors.New("not connected")
+			}
+			return nil
+		})
+	}
+
+	if s.natsSource != nil {
+		s.registerCheck("nats", probeReady, func(context.Context) error {
+			if !s.natsSource.conn.IsConnected() {
+				return fmt.Errorf("connection is %s", s.natsSource.conn.Status())
+			}
+			return nil
+		})
+	}
+
+	if s.kafkaSink != nil {
+		s.registerCheck("kafka-sink", probeReady, func(context.Context) error {
+			return s.kafkaSink.lastError()
+		})
+	}
+
+	if s.eventStore != nil {
+		s.registerCheck("event-store", probeReady, s.eventStore.ping)
+	}
+
+	if s.webhooks != nil {
+		s.registerCheck("webhooks", probeReady, func(context.Context) error {
+			return s.webhooks.backlogged()
+		})
+	}
+}
+
+// checkWritable reports whether a file can be created in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".healthz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// markStarted passes the startup probe, once the server is serving
+func (s *Server) markStarted() {
+	s.started.Store(true)
+}
+
+// serveProbe runs the checks for p, answering 200 if they all pass and
+// 503 otherwise. With ?verbose=1 each check's result and latency is
+// included.
+func (s *Server) serveProbe(w http.ResponseWriter, r *http.Request, p probe) {
+	results := s.health.run(r.Context(), p)
+
+	resp := ProbeResponse{Status: "ok"}
+	status := http.StatusOK
+	for _, result := range results {
+		if !result.OK {
+			resp.Status = "failed"
+			status = http.StatusServiceUnavailable
+			resp.Failed = append(resp.Failed, result.Name)
+		}
+	}
+	if verbose(r) {
+		resp.Checks = results
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, status, resp)
+}
+
+// verbose reports whether the request asked for per-check output
+func verbose(r *http.Request) bool {
+	query := r.URL.Query()
+	if !query.Has("verbose") {
+		return false
+	}
+	v := query.Get("verbose")
+	return v != "0" && v != "false"
+}
+
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	s.serveProbe(w, r, probeLive)
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.serveProbe(w, r, probeReady)
+}
+
+func (s *Server) handleStartupz(w http.ResponseWriter, r *http.Request) {
+	s.serveProbe(w, r, probeStartup)
+}
+
+// handleHealth is the original health endpoint, kept for existing
+// clients; it reports the readiness checks
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := HealthResponse{
+		Status: "healthy",
+		Checks: make(map[string]bool),
+	}
+	status := http.StatusOK
+	for _, result := range s.health.run(r.Context(), probeReady) {
+		health.Checks[result.Name] = result.OK
+		if !result.OK {
+			health.Status = "unhealthy"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	s.writeJSON(w, status, health)
+}
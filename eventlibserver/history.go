@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// defaultRecentLimit is how many events /events/recent returns without
+// ?limit=
+const defaultRecentLimit = 100
+
+var eventsReplayed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_events_replayed_total",
+	Help: "Total number of historical events re-enqueued via the replay API",
+}, []string{"type", "source"})
+
+// historyEntry is a processed event and when it was handled
+type historyEntry struct {
+	event       eventlib.Event
+	processedAt time.Time
+}
+
+// time is when the event occurred, or when it was processed if the
+// producer did not say
+func (e historyEntry) time() time.Time {
+	if !e.event.Timestamp.IsZero() {
+		return e.event.Timestamp
+	}
+	return e.processedAt
+}
+
+// eventHistory keeps the most recently processed events, oldest
+// overwritten first, so they can be inspected and replayed
+type eventHistory struct {
+	mu      sync.Mutex
+	entries []historyEntry
+	next    int
+	size    int
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{size: size}
+}
+
+func (h *eventHistory) record(event eventlib.Event) {
+	entry := historyEntry{event: event, processedAt: time.Now()}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < h.size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % h.size
+}
+
+// historyFilter selects events from the history; zero fields match
+// everything
+type historyFilter struct {
+	from, to time.Time
+	types    []eventlib.EventType
+	sources  []string
+}
+
+func (f historyFilter) match(entry historyEntry) bool {
+	t := entry.time()
+	if !f.from.IsZero() && t.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && !t.Before(f.to) {
+		return false
+	}
+	if len(f.types) > 0 && !slices.Contains(f.types, entry.event.Type) {
+		return false
+	}
+	if len(f.sources) == 0 {
+		return true
+	}
+	for _, pattern := range f.sources {
+		if ok, _ := path.Match(pattern, entry.event.Source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns up to limit matching events, oldest first, and the time of
+// the oldest event still held. A limit of 0 means no limit.
+func (h *eventHistory) find(filter historyFilter, limit int) (events []eventlib.Event, oldest time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.entries {
+		entry := h.entries[(h.next+i)%len(h.entries)]
+		if t := entry.time(); oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+		if limit > 0 && len(events) >= limit {
+			continue
+		}
+		if filter.match(entry) {
+			events = append(events, entry.event)
+		}
+	}
+	return events, oldest
+}
+
+// recent returns up to limit entries accepted by match, newest first
+func (h *eventHistory) recent(match func(event eventlib.Event) bool, limit int) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var entries []historyEntry
+	for i := len(h.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := h.entries[(h.next+i)%len(h.entries)]
+		if match(entry.event) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// handleRecent lists the most recently processed events, newest first,
+// optionally filtered by comma-separated ?type= and ?source= as for the
+// streaming endpoints
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.writeError(w, http.StatusNotImplemented, "Event history is disabled")
+		return
+	}
+
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, s.history.size)
+	}
+
+	filter := parseStreamFilter(r)
+	entries := s.history.recent(func(event eventlib.Event) bool {
+		return filter.match(newEventMessage(event))
+	}, limit)
+
+	resp := RecentEventsResponse{
+		Events: make([]RecentEvent, len(entries)),
+		Count:  len(entries),
+	}
+	for i, entry := range entries {
+		msg := newEventMessage(entry.event)
+		msg.Timestamp = entry.time()
+		resp.Events[i] = RecentEvent{Event: msg, ProcessedAt: entry.processedAt}
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleReplay re-enqueues processed events matching a time range and
+// type and source filters. With dry_run it only counts them.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.writeError(w, http.StatusNotImplemented, "Event history is disabled")
+		return
+	}
+
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Limit < 0 {
+		s.writeError(w, http.StatusBadRequest, "Limit cannot be negative")
+		return
+	}
+	for _, pattern := range req.Sources {
+		if _, err := path.Match(pattern, ""); err != nil {
+			s.writeError(w, http.StatusBadRequest, "Invalid source pattern: "+pattern)
+			return
+		}
+	}
+
+	filter := historyFilter{types: req.Types, sources: req.Sources}
+	if req.From != nil {
+		filter.from = *req.From
+	}
+	if req.To != nil {
+		filter.to = *req.To
+	}
+	if !filter.from.IsZero() && !filter.to.IsZero() && !filter.from.Before(filter.to) {
+		s.writeError(w, http.StatusBadRequest, "From must be before to")
+		return
+	}
+
+	events, oldest := s.history.find(filter, req.Limit)
+	resp := ReplayResponse{
+		Matched: len(events),
+		DryRun:  req.DryRun,
+	}
+	if !oldest.IsZero() {
+		resp.Oldest = &oldest
+	}
+	if req.DryRun {
+		s.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	for i := range events {
+		// Replays are new deliveries under this request's trace; the
+		// original deadline has most likely passed
+		events[i].Deadline = time.Time{}
+		events[i].Attempt = 0
+		events[i].EnqueuedAt = time.Time{}
+		events[i].ProcessedAt = time.Time{}
+		events[i] = eventlib.WithTrace(r.Context(), events[i])
+	}
+
+	for i, err := range s.pushBatch(events) {
+		event := events[i]
+		if err != nil {
+			resp.Failed++
+			s.drops.record(event, err.Error())
+			continue
+		}
+		resp.Queued++
+		eventsReplayed.WithLabelValues(event.Type.String(), event.Source).Inc()
+	}
+	s.notifyPushed()
+
+	s.logger.Info("Replayed events",
+		zap.Int("matched", resp.Matched),
+		zap.Int("queued", resp.Queued),
+		zap.Int("failed", resp.Failed))
+
+	s.writeJSON(w, http.StatusAccepted, resp)
+}
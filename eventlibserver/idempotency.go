@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// idempotencyHeader carries the client's key; idempotentReplayHeader marks
+// a response repeated from the store
+const (
+	idempotencyHeader      = "Idempotency-Key"
+	idempotentReplayHeader = "Idempotent-Replayed"
+)
+
+// Idempotency stores
+const (
+	IdempotencyStoreMemory = "memory"
+	IdempotencyStoreRedis  = "redis"
+)
+
+const (
+	idempotencyDefaultTTL     = 24 * time.Hour
+	idempotencyDefaultMaxKeys = 10000
+	idempotencyMaxKeyLength   = 255
+	idempotencyStoreTimeout   = 2 * time.Second
+)
+
+var (
+	idempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_http_idempotent_replays_total",
+		Help: "Total number of requests answered from the idempotency store",
+	})
+
+	idempotencyConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_idempotency_conflicts_total",
+		Help: "Total number of requests rejected for reusing an idempotency key, by reason: in_progress or mismatch",
+	}, []string{"reason"})
+)
+
+// IdempotencyConfig controls the Idempotency-Key header on the ingest
+// endpoints
+type IdempotencyConfig struct {
+	// Store is IdempotencyStoreMemory (the default) or
+	// IdempotencyStoreRedis, which shares keys between servers
+	Store string
+
+	// TTL is how long a response is remembered; default 24h
+	TTL time.Duration
+
+	// MaxKeys bounds the memory store; the oldest keys are forgotten first
+	MaxKeys int
+
+	// RedisAddr and RedisPrefix locate keys in the Redis store
+	RedisAddr   string
+	RedisPrefix string
+}
+
+func (c *IdempotencyConfig) validate() error {
+	switch c.Store {
+	case "":
+		c.Store = IdempotencyStoreMemory
+	case IdempotencyStoreMemory:
+	case IdempotencyStoreRedis:
+		if c.RedisAddr == "" {
+			return errors.New("idempotency: no redis address")
+		}
+	default:
+		return fmt.Errorf("idempotency: unknown store %q", c.Store)
+	}
+	if c.TTL < 0 || c.MaxKeys < 0 {
+		return errors.New("idempotency: negative setting")
+	}
+	if c.TTL == 0 {
+		c.TTL = idempotencyDefaultTTL
+	}
+	if c.MaxKeys == 0 {
+		c.MaxKeys = idempotencyDefaultMaxKeys
+	}
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "eventlib:idempotency"
+	}
+	return nil
+}
+
+// idempotentResponse is a stored reply. A zero Status marks a request that
+// is still being handled.
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// idempotencyStore remembers responses by key
+type idempotencyStore interface {
+	// claim reserves key for a request. If the key is already held, the
+	// holder's response is returned instead, pending or not.
+	claim(ctx context.Context, key, fingerprint string) (*idempotentResponse, error)
+
+	// save stores the response to a claimed key
+	save(ctx context.Context, key string, resp idempotentResponse) error
+
+	// release drops a claim without a response, so the request can be
+	// retried
+	release(ctx context.Context, key string) error
+
+	close() error
+}
+
+func newIdempotencyStore(config IdempotencyConfig) idempotencyStore {
+	if config.Store == IdempotencyStoreRedis {
+		return &redisIdempotencyStore{
+			client: redis.NewClient(&redis.Options{Addr: config.RedisAddr}),
+			prefix: config.RedisPrefix,
+			ttl:    config.TTL,
+		}
+	}
+	return newMemoryIdempotencyStore(config.TTL, config.MaxKeys)
+}
+
+// memoryIdempotencyStore keeps responses in this process, oldest first
+type memoryIdempotencyStore struct {
+	ttl     time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type memoryIdempotencyEntry struct {
+	key     string
+	resp    idempotentResponse
+	expires time.Time
+}
+
+func newMemoryIdempotencyStore(ttl time.Duration, maxKeys int) *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (m *memoryIdempotencyStore) claim(_ context.Context, key, fingerprint string) (*idempotentResponse, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Entries share a TTL, so the front of the list expires first
+	for e := m.order.Front(); e != nil; e = m.order.Front() {
+		entry := e.Value.(*memoryIdempotencyEntry)
+		if entry.expires.After(now) {
+			break
+		}
+		m.remove(e)
+	}
+
+	if e, ok := m.entries[key]; ok {
+		resp := e.Value.(*memoryIdempotencyEntry).resp
+		return &resp, nil
+	}
+
+	if m.order.Len() >= m.maxKeys {
+		m.remove(m.order.Front())
+	}
+	m.entries[key] = m.order.PushBack(&memoryIdempotencyEntry{
+		key:     key,
+		resp:    idempotentResponse{Fingerprint: fingerprint},
+		expires: now.Add(m.ttl),
+	})
+	return nil, nil
+}
+
+func (m *memoryIdempotencyStore) save(_ context.Context, key string, resp idempotentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The claim may have been evicted to make room; then there is nothing
+	// to answer repeats with
+	if e, ok := m.entries[key]; ok {
+		e.Value.(*memoryIdempotencyEntry).resp = resp
+	}
+	return nil
+}
+
+func (m *memoryIdempotencyStore) release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		m.remove(e)
+	}
+	return nil
+}
+
+func (m *memoryIdempotencyStore) remove(e *list.Element) {
+	delete(m.entries, e.Value.(*memoryIdempotencyEntry).key)
+	m.order.Remove(e)
+}
+
+func (m *memoryIdempotencyStore) close() error {
+	return nil
+}
+
+// redisIdempotencyStore keeps responses in Redis, so every server behind
+// a load balancer sees them
+type redisIdempotencyStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func (rs *redisIdempotencyStore) key(key string) string {
+	return rs.prefix + ":" + key
+}
+
+func (rs *redisIdempotencyStore) claim(ctx context.Context, key, fingerprint string) (*idempotentResponse, error) {
+	pending, err := json.Marshal(idempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		claimed, err := rs.client.SetNX(ctx, rs.key(key), pending, rs.ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+
+		value, err := rs.client.Get(ctx, rs.key(key)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// Released or expired since SETNX; try again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var resp idempotentResponse
+		if err := json.Unmarshal(value, &resp); err != nil {
+			return nil, fmt.Errorf("corrupt idempotency record %s: %w", key, err)
+		}
+		return &resp, nil
+	}
+}
+
+func (rs *redisIdempotencyStore) save(ctx context.Context, key string, resp idempotentResponse) error {
+	value, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return rs.client.Set(ctx, rs.key(key), value, rs.ttl).Err()
+}
+
+func (rs *redisIdempotencyStore) release(ctx context.Context, key string) error {
+	return rs.client.Del(ctx, rs.key(key)).Err()
+}
+
+func (rs *redisIdempotencyStore) close() error {
+	return rs.client.Close()
+}
+
+// idempotencyRecorder copies a response as it is written
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// idempotent answers a request that repeats an earlier Idempotency-Key
+// with the earlier response, without running next again. Keys are scoped
+// to the authenticated caller. Responses that ask the client to retry,
+// such as a full queue or a rate limit, are not kept.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || s.idempotency == nil {
+			next(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			s.writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeBodyError(w, err, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if p := principalFrom(r.Context()); p != nil {
+			key = p.Name + ":" + key
+		}
+		fingerprint := requestFingerprint(r, body)
+
+		ctx, cancel := context.WithTimeout(r.Context(), idempotencyStoreTimeout)
+		prev, err := s.idempotency.claim(ctx, key, fingerprint)
+		cancel()
+		if err != nil {
+			s.logger.Error("Idempotency store unavailable", zap.Error(err))
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, http.StatusServiceUnavailable, "Idempotency store unavailable")
+			return
+		}
+
+		switch {
+		case prev == nil:
+		case prev.Fingerprint != fingerprint:
+			idempotencyConflicts.WithLabelValues("mismatch").Inc()
+			s.writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was used for a different request")
+			return
+		case prev.Status == 0:
+			idempotencyConflicts.WithLabelValues("in_progress").Inc()
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, http.StatusConflict, "A request with this Idempotency-Key is in progress")
+			return
+		default:
+			idempotentReplays.Inc()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(prev.Status)
+			w.Write(prev.Body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		// The request has been handled; keep its outcome even if the
+		// client has gone away
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(r.Context()), idempotencyStoreTimeout)
+		defer cancel()
+		if rec.status == 0 || rec.status == http.StatusTooManyRequests || rec.status >= 500 {
+			err = s.idempotency.release(ctx, key)
+		} else {
+			err = s.idempotency.save(ctx, key, idempotentResponse{
+				Fingerprint: fingerprint,
+				Status:      rec.status,
+				Body:        rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			s.logger.Warn("Failed to update idempotency store",
+				zap.String("key", key),
+				zap.Error(err))
+		}
+	}
+}
+
+// requestFingerprint identifies what a request asks for, so a key reused
+// for a different request can be refused
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
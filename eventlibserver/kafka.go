@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Kafka commit strategies
+const (
+	// KafkaCommitSync commits each message once it is queued
+	KafkaCommitSync = "sync"
+
+	// KafkaCommitInterval commits queued messages in the background every
+	// CommitInterval; faster, but a crash may redeliver the last interval
+	KafkaCommitInterval = "interval"
+)
+
+const (
+	kafkaDefaultCommitInterval = time.Second
+	kafkaMaxBackoff            = time.Second
+)
+
+var (
+	kafkaConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_kafka_messages_consumed_total",
+		Help: "Total number of Kafka messages queued as events",
+	}, []string{"topic"})
+
+	kafkaInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_kafka_messages_invalid_total",
+		Help: "Total number of Kafka messages skipped because they are not valid events",
+	}, []string{"topic"})
+
+	kafkaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_kafka_consumer_lag",
+		Help: "Messages behind the end of each partition, as of the last message read",
+	}, []string{"topic", "partition"})
+
+	kafkaPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_kafka_messages_published_total",
+		Help: "Total number of processed events published to the output topic",
+	})
+
+	kafkaPublishErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_kafka_publish_errors_total",
+		Help: "Total number of processed events that failed to publish",
+	})
+)
+
+// KafkaConfig connects the server to Kafka. Topics enables the source and
+// OutputTopic the sink; either may be used alone.
+type KafkaConfig struct {
+	Brokers []string
+
+	// Topics are consumed as events by the consumer group Group
+	Topics []string
+	Group  string
+
+	// Commit is KafkaCommitSync or KafkaCommitInterval (the default)
+	Commit         string
+	CommitInterval time.Duration
+
+	// OutputTopic, if set, receives every processed live event
+	OutputTopic string
+}
+
+func (c *KafkaConfig) validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("kafka: no brokers")
+	}
+	if len(c.Topics) == 0 && c.OutputTopic == "" {
+		return errors.New("kafka: no input topics or output topic")
+	}
+	if len(c.Topics) > 0 && c.Group == "" {
+		return errors.New("kafka: consumer group required")
+	}
+	switch c.Commit {
+	case "":
+		c.Commit = KafkaCommitInterval
+	case KafkaCommitSync, KafkaCommitInterval:
+	default:
+		return fmt.Errorf("kafka: unknown commit strategy %q", c.Commit)
+	}
+	if c.CommitInterval <= 0 {
+		c.CommitInterval = kafkaDefaultCommitInterval
+	}
+	return nil
+}
+
+// kafkaSource consumes events from Kafka topics into the processor.
+// Offsets are committed once an event is queued, so a message that cannot
+// be queued is read again.
+type kafkaSource struct {
+	s      *Server
+	reader *kafka.Reader
+
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newKafkaSource(config KafkaConfig, s *Server) *kafkaSource {
+	readerConfig := kafka.ReaderConfig{
+		Brokers:     config.Brokers,
+		GroupID:     config.Group,
+		GroupTopics: config.Topics,
+		ErrorLogger: kafka.LoggerFunc(s.logger.Sugar().Warnf),
+	}
+	// A zero CommitInterval makes CommitMessages synchronous
+	if config.Commit == KafkaCommitInterval {
+		readerConfig.CommitInterval = config.CommitInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &kafkaSource{
+		s:      s,
+		reader: kafka.NewReader(readerConfig),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go src.run(ctx)
+	return src
+}
+
+func (src *kafkaSource) run(ctx context.Context) {
+	defer close(src.done)
+
+	for {
+		msg, err := src.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				src.s.logger.Error("Kafka consumer stopped", zap.Error(err))
+			}
+			return
+		}
+		kafkaLag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).
+			Set(float64(max(msg.HighWaterMark-msg.Offset-1, 0)))
+
+		if err := src.queue(ctx, msg); err != nil {
+			// Not committed; the group resumes from here
+			return
+		}
+		if err := src.reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			src.s.logger.Warn("Failed to commit Kafka offset",
+				zap.String("topic", msg.Topic),
+				zap.Int("partition", msg.Partition),
+				zap.Int64("offset", msg.Offset),
+				zap.Error(err))
+		}
+	}
+}
+
+// queue pushes the event carried by msg, waiting out rate limits and a
+// full queue. Invalid messages are skipped. It fails only when ctx is done
+// or the processor stops accepting events.
+func (src *kafkaSource) queue(ctx context.Context, msg kafka.Message) error {
+	event, err := decodeKafkaMessage(msg)
+	if err == nil {
+		err = src.s.schemas.check(event)
+	}
+	if err != nil {
+		kafkaInvalid.WithLabelValues(msg.Topic).Inc()
+		src.s.logger.Warn("Skipping invalid Kafka message",
+			zap.String("topic", msg.Topic),
+			zap.Int("partition", msg.Partition),
+			zap.Int64("offset", msg.Offset),
+			zap.Error(err))
+		return nil
+	}
+
+	backoff := 10 * time.Millisecond
+	for {
+		var wait time.Duration
+		if src.s.ingestPaused.Load() {
+			wait = ingestPausedRetry
+		} else if allowed, retryAfter := src.s.allowSource(event.Source); !allowed {
+			wait = retryAfter
+		} else {
+			err := src.s.processor.Push(event)
+			switch {
+			case err == nil:
+				kafkaConsumed.WithLabelValues(msg.Topic).Inc()
+				eventsReceived.WithLabelValues(event.Type.String(), event.Source).Inc()
+				src.s.notifyPushed()
+				return nil
+			case errors.Is(err, eventlib.ErrPaused):
+				wait = ingestPausedRetry
+			case errors.Is(err, eventlib.ErrQueueFull):
+				wait = backoff
+				backoff = min(backoff*2, kafkaMaxBackoff)
+			default:
+				src.s.drops.record(event, err.Error())
+				return err
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// decodeKafkaMessage reads an event from a message whose value is a JSON
+// event, as posted to /api/v1/events. The source defaults to the message
+// key and the timestamp to the message time.
+func decodeKafkaMessage(msg kafka.Message) (eventlib.Event, error) {
+	var req EventRequest
+	if err := json.Unmarshal(msg.Value, &req); err != nil {
+		return eventlib.Event{}, err
+	}
+	if err := req.validate(); err != nil {
+		return eventlib.Event{}, err
+	}
+	if req.Source == "" {
+		req.Source = string(msg.Key)
+	}
+	if req.Timestamp == nil && !msg.Time.IsZero() {
+		req.Timestamp = &msg.Time
+	}
+	return req.toEvent(time.Time{}), nil
+}
+
+// stop stops consuming and waits for the consumer to exit, committing
+// what has been queued
+func (src *kafkaSource) stop() {
+	src.stopOnce.Do(func() {
+		src.cancel()
+		<-src.done
+		if err := src.reader.Close(); err != nil {
+			src.s.logger.Warn("Failed to close Kafka consumer", zap.Error(err))
+		}
+	})
+}
+
+// kafkaSink publishes processed events to an output topic, in the JSON
+// form streamed to WebSocket clients, keyed by source
+type kafkaSink struct {
+	writer *kafka.Writer
+	logger *zap.Logger
+
+	// err is the outcome of the most recent write, for the readiness check
+	err atomic.Pointer[error]
+}
+
+func newKafkaSink(config KafkaConfig, logger *zap.Logger) *kafkaSink {
+	sink := &kafkaSink{logger: logger}
+	sink.writer = &kafka.Writer{
+		Addr:        kafka.TCP(config.Brokers...),
+		Topic:       config.OutputTopic,
+		Balancer:    &kafka.Hash{},
+		ErrorLogger: kafka.LoggerFunc(logger.Sugar().Warnf),
+		// Handlers must not wait on the network
+		Async: true,
+		Completion: func(messages []kafka.Message, err error) {
+			sink.setError(err)
+			if err != nil {
+				kafkaPublishErrors.Add(float64(len(messages)))
+				logger.Warn("Failed to publish events to Kafka",
+					zap.Int("events", len(messages)),
+					zap.Error(err))
+				return
+			}
+			kafkaPublished.Add(float64(len(messages)))
+		},
+	}
+	return sink
+}
+
+func (sink *kafkaSink) publish(msg EventMessage) {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		kafkaPublishErrors.Inc()
+		return
+	}
+	// Errors from the write itself, such as an unreachable cluster, come
+	// back here rather than through Completion
+	err = sink.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(msg.Source),
+		Value: value,
+	})
+	if err != nil {
+		sink.setError(err)
+		kafkaPublishErrors.Inc()
+		sink.logger.Debug("Failed to publish event to Kafka", zap.Error(err))
+	}
+}
+
+func (sink *kafkaSink) setError(err error) {
+	sink.err.Store(&err)
+}
+
+// lastError returns the error from the most recent write, nil if it
+// succeeded or nothing was written yet
+func (sink *kafkaSink) lastError() error {
+	if err := sink.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// close flushes pending writes
+func (sink *kafkaSink) close() error {
+	return sink.writer.Close()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Environment variables of systemd socket activation; see sd_listen_fds(3)
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+
+	// systemdFirstFD is the first descriptor systemd passes
+	systemdFirstFD = 3
+)
+
+// listenerNames are the server's listeners, in the order they take
+// systemd sockets that are not named after one
+var listenerNames = []string{"api", "metrics"}
+
+func init() {
+	registerFeature("unix-socket")
+	registerFeature("socket-activation")
+}
+
+// parseListenAddr splits an -addr value into a network and address:
+// unix:///run/eventlib.sock or unix:run/eventlib.sock for a Unix socket,
+// and anything else for TCP
+func parseListenAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return "unix", path
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// listenAddr binds addr. A Unix socket is given mode, and one left behind
+// by an earlier run is replaced.
+func listenAddr(addr string, mode fs.FileMode) (net.Listener, error) {
+	network, address := parseListenAddr(addr)
+	if network == "tcp" {
+		return net.Listen(network, address)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("%q has no socket path", addr)
+	}
+
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+	// The socket file must outlive this process when an upgrade hands the
+	// listener to a new one; the next start removes it instead
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(address, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path unless a server is still
+// listening on it. Anything other than a socket is left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return os.Remove(path)
+}
+
+// parseSocketMode reads an octal -socket-mode value
+func parseSocketMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q: want octal permissions such as 0660", value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket
+// activation, by listener name. Sockets named "api" or "metrics" with
+// FileDescriptorName= go to that listener; unnamed ones are taken in
+// order. The variables are cleared so children, such as an upgrade, do
+// not mistake them for their own.
+func systemdListeners(logger *zap.Logger) map[string]*os.File {
+	pid, fds, names := os.Getenv(envListenPID), os.Getenv(envListenFDs), os.Getenv(envListenFDNames)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+	files := make(map[string]*os.File)
+	next := 0
+	for i := range n {
+		fd := systemdFirstFD + i
+		syscall.CloseOnExec(fd)
+
+		name := ""
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+		if name != "api" && name != "metrics" {
+			name = ""
+			for next < len(listenerNames) && files[listenerNames[next]] != nil {
+				next++
+			}
+			if next < len(listenerNames) {
+				name = listenerNames[next]
+			}
+		}
+		if name == "" || files[name] != nil {
+			logger.Warn("Ignoring extra systemd socket", zap.Int("fd", fd))
+			syscall.Close(fd)
+			continue
+		}
+		files[name] = os.NewFile(uintptr(fd), name)
+	}
+
+	logger.Info("Using sockets from systemd", zap.Int("count", len(files)))
+	return files
+}
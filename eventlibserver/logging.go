@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newLogger builds the server's logger from the -log-* flags. The returned
+// level changes what the logger emits while it runs.
+func newLogger() (*zap.Logger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(*logLevel)
+	if err != nil {
+		return nil, level, fmt.Errorf("invalid -log-level: %w", err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = level
+
+	switch *logEncoding {
+	case "json":
+	case "console":
+		cfg.Encoding = "console"
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	default:
+		return nil, level, fmt.Errorf("invalid -log-encoding %q: use json or console", *logEncoding)
+	}
+
+	if outputs := splitList(*logOutput); len(outputs) > 0 {
+		cfg.OutputPaths = outputs
+	}
+
+	switch {
+	case *logSampleInitial < 0 || *logSampleThereafter < 0:
+		return nil, level, fmt.Errorf("log sampling settings cannot be negative")
+	case *logSampleInitial == 0:
+		cfg.Sampling = nil
+	default:
+		cfg.Sampling = &zap.SamplingConfig{
+			Initial:    *logSampleInitial,
+			Thereafter: max(*logSampleThereafter, 1),
+		}
+	}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, level, fmt.Errorf("failed to build logger: %w", err)
+	}
+	return logger, level, nil
+}
+
+// logLevelHandler serves zap's level endpoint: GET reports the level and
+// PUT {"level": "debug"} changes it. Changes are logged at warn so they
+// show at any level up to it.
+func logLevelHandler(level zap.AtomicLevel, logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		old := level.Level()
+		level.ServeHTTP(w, r)
+		if now := level.Level(); now != old {
+			logger.Warn("Log level changed",
+				zap.Stringer("old_level", old),
+				zap.Stringer("level", now),
+				zap.String("remote", r.RemoteAddr))
+		}
+	})
+}
@@ -3,63 +3,636 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/sammyjroberts/eventlibgo/redisqueue"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 	"go.uber.org/zap"
 )
 
 var (
-	addr          = flag.String("addr", ":8080", "HTTP server address")
-	metricsAddr   = flag.String("metrics-addr", ":9090", "Metrics server address")
-	queueSize     = flag.Int("queue-size", 10000, "Maximum event queue size")
-	processorName = flag.String("name", "HTTPEventProcessor", "Processor name")
+	configPath       = flag.String("config", "", "YAML or TOML settings file keyed by flag name; EVENTLIB_* environment variables override it, command-line flags override both")
+	addr             = flag.String("addr", ":8080", "HTTP server address, or unix:///path/to/socket for a Unix socket")
+	metricsAddr      = flag.String("metrics-addr", ":9090", "Metrics server address, or unix:///path/to/socket for a Unix socket")
+	socketMode       = flag.String("socket-mode", "0660", "Octal permissions of Unix sockets created for -addr and -metrics-addr")
+	tlsCert          = flag.String("tls-cert", "", "TLS certificate file; serves HTTPS on both listeners with -tls-key, reloaded when it changes")
+	tlsKey           = flag.String("tls-key", "", "TLS private key file for -tls-cert")
+	clientCA         = flag.String("client-ca", "", "PEM file of CAs for client certificates; requires mutual TLS on both listeners")
+	queueSize        = flag.Int("queue-size", 10000, "Maximum event queue size")
+	highWatermark    = flag.Float64("queue-high-watermark", 0.9, "Fraction of -queue-size at which /readyz reports the queue as unhealthy")
+	lowWatermark     = flag.Float64("queue-low-watermark", 0.7, "Fraction of -queue-size at which the queue is healthy again")
+	processorName    = flag.String("name", "HTTPEventProcessor", "Processor name")
+	chaos            = flag.Bool("chaos", false, "Allow fault injection through /api/v1/admin/chaos, for testing clients against a failing server; never in production")
+	topics           = flag.String("topics", "", "Comma-separated named queues served under /api/v1/topics, each optionally with its own =<queue size>, e.g. orders=5000,audit")
+	backend          = flag.String("backend", "cgo", "Queue backend: cgo, redis, or redis-stream to share a Redis stream between servers that each process with cgo")
+	redisAddr        = flag.String("redis-addr", "localhost:6379", "Redis address for the redis and redis-stream backends")
+	redisKey         = flag.String("redis-key", "eventlib:queue", "Redis list holding the shared queue")
+	redisStream      = flag.String("redis-stream", "eventlib:stream", "Redis stream for the redis-stream backend")
+	redisGroup       = flag.String("redis-group", redisqueue.DefaultStreamGroup, "Consumer group shared by the servers reading -redis-stream")
+	redisConsumer    = flag.String("redis-consumer", "", "This server's consumer name in -redis-group, or processing list with -backend=redis; keep it stable across restarts (default <hostname>-<pid>)")
+	redisClaimIdle   = flag.Duration("redis-claim-idle", redisqueue.DefaultClaimIdle, "How long a stream entry may stay unacknowledged, or a -backend=redis server go without a heartbeat, before another server takes over its events")
+	processInterval  = flag.Duration("process-interval", 0, "Drain the queue automatically at this interval (0 = only on request)")
+	processThreshold = flag.Int("process-threshold", 0, "Drain the queue automatically once it holds this many events (0 = off)")
+	diagDir          = flag.String("diagnostics-dir", os.TempDir(), "Directory for diagnostics bundles (SIGQUIT or ?to=file)")
+	queueMode        = flag.String("queue-mode", "fifo", "Queue ordering: fifo, or priority to process high-priority events first (cgo backend)")
+	rateLimit        = flag.Float64("rate-limit", 0, "Events per second allowed per source (0 = unlimited)")
+	rateBurst        = flag.Int("rate-burst", 0, "Burst size for -rate-limit (default: one second's worth)")
+	ipRateLimit      = flag.Float64("ip-rate-limit", 0, "API requests per second allowed per client IP (0 = unlimited)")
+	ipRateBurst      = flag.Int("ip-rate-burst", 0, "Burst size for -ip-rate-limit (default: one second's worth)")
+	rateLimitConfig  = flag.String("rate-limit-config", "", "JSON file with rate limits, including per-source overrides; takes precedence over the -rate-limit flags")
+	authConfig       = flag.String("auth-config", "", "JSON file of API keys, JWT settings and roles; enables auth, reloaded on SIGHUP")
+	persistPath      = flag.String("persistence-path", "", "Directory for the write-ahead log that keeps queued events across restarts (cgo backend)")
+	persistSync      = flag.String("persistence-sync", "interval", "When to fsync the write-ahead log: interval, always or never")
+	restoreSnapshot  = flag.String("restore-snapshot", "", "Snapshot taken with POST /api/v1/admin/snapshot to load into the processor at startup (cgo backend)")
+	eventTypes       = flag.String("event-types", "", "Comma-separated custom event type names accepted in the \"type\" field, e.g. order.placed,order.shipped")
+	shards           = flag.Int("shards", 1, "Split the queue into this many processors, sharded by event source (cgo backend)")
+	asyncPush        = flag.Bool("async-push", false, "Buffer pushes in Go so requests do not wait on the C queue (cgo backend)")
+	asyncBuffer      = flag.Int("async-buffer", 1024, "Events buffered by -async-push")
+	asyncWorkers     = flag.Int("async-workers", 1, "Workers moving buffered events into the queue; more than one may reorder events")
+	asyncOverflow    = flag.String("async-overflow", "block", "What -async-push does when the buffer is full: block, drop-oldest or reject")
+	processWorkers   = flag.Int("process-workers", 1, "Goroutines processing the queue at once; more than one runs handlers concurrently and may reorder events (cgo backend)")
+	handlerTimeout   = flag.Duration("handler-timeout", 0, "Log a goroutine dump for event handlers running longer than this (0 = off, cgo backend)")
+	handlerAbandon   = flag.Bool("handler-abandon", false, "Fail events whose handlers overrun -handler-timeout and move on, leaving the handler running")
+	debugEndpoints   = flag.Bool("debug-endpoints", true, "Serve pprof, expvar and /debug/processor on the metrics listener")
+	retryAttempts    = flag.Int("retry-attempts", 0, "Handle a failing event up to this many times before dead-lettering it (0 = no retries)")
+	retryBackoff     = flag.Duration("retry-backoff", eventlib.DefaultRetryInitialBackoff, "Wait before the first retry; doubles on each retry")
+	retryMaxBackoff  = flag.Duration("retry-max-backoff", eventlib.DefaultRetryMaxBackoff, "Longest wait between retries")
+	retryJitter      = flag.Float64("retry-jitter", 0.2, "Fraction of each retry wait that is randomized, 0 to 1")
+	dedupWindow      = flag.Duration("dedup-window", 0, "Drop events pushed again within this long, matched by id or content (0 = off, cgo backend)")
+	dedupMaxKeys     = flag.Int("dedup-max-keys", eventlib.DefaultDedupMaxKeys, "Most events remembered by -dedup-window")
+	gunzipData       = flag.Bool("gunzip-data", false, "Decompress gzip payloads before queueing them (cgo backend)")
+	stripFields      = flag.String("strip-fields", "", "Comma-separated JSON fields removed from payloads before queueing, e.g. password,user.token (cgo backend)")
+	sourcePrefix     = flag.String("source-prefix", "", "Prefix added to every event source before queueing (cgo backend)")
+	maxEventSize     = flag.Int("max-event-size", 1<<20, "Largest event accepted, in bytes of data and metadata, after -gunzip-data (0 = unlimited, cgo backend)")
+	maxSourceLength  = flag.Int("max-source-length", 256, "Longest event source accepted (0 = unlimited, cgo backend)")
+	maxRequestSize   = flag.Int64("max-request-size", 16<<20, "Largest request body accepted by the event ingest endpoints, in bytes after any Content-Encoding is decoded (0 = unlimited)")
+	compressResponse = flag.Bool("compress-responses", true, "Compress API responses with gzip or deflate for clients that accept it")
+	enableHTTP2      = flag.Bool("http2", true, "Serve HTTP/2 on the API listener: over TLS, or as h2c with prior knowledge without it")
+	shutdownTimeout  = flag.Duration("shutdown-timeout", 30*time.Second, "How long shutdown waits for requests and streams to finish and the queue to drain")
+
+	corsOrigins     = flag.String("cors-origins", "", "Comma-separated origins whose pages may call the API, e.g. https://dash.example.com or https://*.example.com (* = any; default: none)")
+	corsMethods     = flag.String("cors-methods", "GET,POST,PUT,DELETE", "Comma-separated methods cross-origin pages may use")
+	corsHeaders     = flag.String("cors-headers", "Authorization,Content-Type,Content-Encoding,X-API-Key,Idempotency-Key,X-Correlation-ID,X-Deadline", "Comma-separated request headers cross-origin pages may send (* = any)")
+	corsExpose      = flag.String("cors-expose-headers", "Retry-After,Idempotent-Replayed", "Comma-separated response headers cross-origin pages may read")
+	corsCredentials = flag.Bool("cors-credentials", false, "Let cross-origin pages send cookies and credentials")
+	corsMaxAge      = flag.Duration("cors-max-age", 10*time.Minute, "How long browsers may cache a preflight answer")
+
+	kafkaBrokers        = flag.String("kafka-brokers", "", "Comma-separated Kafka brokers; enables the Kafka source and sink")
+	kafkaTopics         = flag.String("kafka-topics", "", "Comma-separated Kafka topics to consume events from")
+	kafkaGroup          = flag.String("kafka-group", "eventlib", "Kafka consumer group for -kafka-topics")
+	kafkaCommit         = flag.String("kafka-commit", KafkaCommitInterval, "When consumed offsets are committed: interval, or sync after every event")
+	kafkaCommitInterval = flag.Duration("kafka-commit-interval", time.Second, "How often offsets are committed with -kafka-commit=interval")
+	kafkaOutputTopic    = flag.String("kafka-output-topic", "", "Kafka topic processed events are published to")
+
+	natsURL           = flag.String("nats-url", "", "NATS server URL; consumes events from the JetStream stream -nats-stream")
+	natsStream        = flag.String("nats-stream", "", "JetStream stream to consume events from")
+	natsSubjects      = flag.String("nats-subjects", "", "Comma-separated subject filters within -nats-stream (default: all)")
+	natsConsumer      = flag.String("nats-consumer", "eventlib", "Durable JetStream consumer name")
+	natsSubjectPrefix = flag.String("nats-subject-prefix", "", "Prefix trimmed from subjects to give event sources, e.g. events.")
+	natsAck           = flag.String("nats-ack", NATSAckPush, "When messages are acked: push, once queued, or processed, once the queue is drained past them")
+	natsBatch         = flag.Int("nats-batch", 100, "JetStream messages fetched at once")
+
+	mqttBroker      = flag.String("mqtt-broker", "", "MQTT broker URL, e.g. tcp://localhost:1883; bridges -mqtt-topics into the queue")
+	mqttTopics      = flag.String("mqtt-topics", "", "Comma-separated MQTT topic filters to subscribe to")
+	mqttQoS         = flag.Int("mqtt-qos", 1, "MQTT subscription QoS: 0, 1 or 2")
+	mqttClientID    = flag.String("mqtt-client-id", "", "MQTT client ID (default: eventlibserver-<hostname>)")
+	mqttUsername    = flag.String("mqtt-username", "", "MQTT username")
+	mqttPassword    = flag.String("mqtt-password", "", "MQTT password; prefer EVENTLIB_MQTT_PASSWORD")
+	mqttTopicPrefix = flag.String("mqtt-topic-prefix", "", "Prefix trimmed from topics to give event sources, e.g. devices/")
+	mqttEventType   = flag.String("mqtt-event-type", "DATA", "Type of events made from MQTT messages")
+
+	historySize   = flag.Int("history-size", 10000, "Processed events kept in memory for /events/recent and the replay API (0 = off)")
+	statsSources  = flag.Int("stats-max-sources", 1000, "Sources counted separately by /api/v1/stats; later ones are counted together as (other)")
+	webhookConfig = flag.String("webhook-config", "", "JSON file of webhook destinations processed events are POSTed to")
+	pluginConfig  = flag.String("plugin-config", "", "JSON file of Go plugins and WASM modules that handle events by type")
+
+	archiveURL      = flag.String("archive-url", "", "Where processed events are archived: s3://bucket/prefix, gs://bucket/prefix, or a local directory")
+	archiveFormat   = flag.String("archive-format", ArchiveNDJSON, "Archive file format: ndjson (gzipped) or parquet")
+	archiveMaxSize  = flag.Int64("archive-max-size", archiveDefaultMaxSize, "Approximate uncompressed bytes of events per archive file")
+	archiveMaxAge   = flag.Duration("archive-max-age", archiveDefaultMaxAge, "Longest an archive file collects events before it is uploaded")
+	archiveEndpoint = flag.String("archive-endpoint", "", "S3-compatible endpoint for the archive, e.g. http://minio:9000 (default: AWS S3, or GCS for gs://)")
+	archiveRegion   = flag.String("archive-region", "", "Region of the archive bucket")
+
+	eventStoreDSN       = flag.String("event-store", "", "Database every processed event is written to for GET /api/v1/events: sqlite:<file> or a postgres:// URL")
+	eventStoreRetention = flag.Duration("event-store-retention", 0, "How long events are kept in the event store (0 = forever)")
+
+	streamAckTimeout        = flag.Duration("stream-ack-timeout", 30*time.Second, "How long an event sent to a WebSocket subscriber with ack=true may go unacked before it is sent again")
+	streamAckAttempts       = flag.Int("stream-ack-attempts", 5, "Times an event is sent to an acknowledging subscriber before it is given up")
+	streamAckMaxUnacked     = flag.Int("stream-ack-max-unacked", 1000, "Events an acknowledging subscriber may leave unacked before no more are sent")
+	streamAckMaxSubscribers = flag.Int("stream-ack-max-subscribers", 100, "Acknowledging subscriber names kept at once, connected or recently gone")
+
+	idempotencyTTL     = flag.Duration("idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are remembered (0 = ignore the header)")
+	idempotencyBackend = flag.String("idempotency-store", IdempotencyStoreMemory, "Where idempotency keys are kept: memory, or redis at -redis-addr to share them between servers")
+	idempotencyMaxKeys = flag.Int("idempotency-max-keys", 10000, "Most idempotency keys kept by the memory store")
+
+	schemaDir  = flag.String("schema-dir", "", "Directory of JSON Schemas named <type>.json, e.g. DATA.json; payloads of those types must match")
+	filterFile = flag.String("filter-file", "", "JSON file the filter rules are loaded from and saved to by PUT /api/v1/filters (default: rules kept in memory)")
+
+	samplingConfig  = flag.String("sampling-config", "", "JSON file of sampling rules that drop a share of events during storms; replaceable at runtime with PUT /api/v1/admin/sampling (cgo backend)")
+	alertConfig     = flag.String("alert-config", "", "JSON file of alert targets and rules that notify webhook, Slack or PagerDuty when sources send too many ERROR events")
+	aggregateConfig = flag.String("aggregate-config", "", "JSON file of rules summarizing processed events over tumbling or sliding windows, shown at /api/v1/aggregates")
+
+	autotune           = flag.Bool("autotune", false, "Process events automatically, auto-tuning the rate to hold queue depth")
+	autotuneTarget     = flag.Int("autotune-target", 1000, "Queue depth the auto-tuner aims to stay under")
+	autotuneInterval   = flag.Duration("autotune-interval", 100*time.Millisecond, "Auto-tuner control interval")
+	autotuneMinWorkers = flag.Int("autotune-min-workers", 1, "Minimum processing concurrency")
+	autotuneMaxWorkers = flag.Int("autotune-max-workers", 8, "Maximum processing concurrency")
+	autotuneMinBatch   = flag.Int("autotune-min-batch", 10, "Minimum events per worker per interval")
+	autotuneMaxBatch   = flag.Int("autotune-max-batch", 1000, "Maximum events per worker per interval")
+	autotuneHysteresis = flag.Float64("autotune-hysteresis", 0.2, "Fraction of the target within which capacity is left unchanged")
+
+	logLevel            = flag.String("log-level", "info", "Minimum log level: debug, info, warn, error, dpanic, panic or fatal; reloadable, or change it at runtime with PUT /loglevel on the metrics server")
+	logEncoding         = flag.String("log-encoding", "json", "Log format: json, or console for human-readable lines")
+	logOutput           = flag.String("log-output", "stderr", "Comma-separated log destinations: stdout, stderr or file paths")
+	logSampleInitial    = flag.Int("log-sample-initial", 100, "Entries with the same level and message logged each second before sampling starts (0 disables sampling)")
+	logSampleThereafter = flag.Int("log-sample-thereafter", 100, "Once sampling, log every Nth entry with the same level and message")
+
+	docsAssets = flag.String("docs-assets", "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14", "Base URL of the swagger-ui-dist files loaded by /docs; point it at a local copy where the CDN is unreachable")
+	dashboard  = flag.Bool("ui", true, "Serve the operator dashboard at /ui/")
 )
 
 func main() {
 	flag.Parse()
 
+	// Fill in flags not given on the command line from -config and the
+	// environment, before the logging flags are used
+	config := newFileConfig(*configPath)
+	configErr := config.apply()
+
 	// Initialize logger
-	logger, err := zap.NewProduction()
+	logger, level, err := newLogger()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 	defer logger.Sync()
 
+	if configErr != nil {
+		logger.Fatal("Invalid configuration", zap.Error(configErr))
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), logger)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+
+	for _, name := range splitList(*eventTypes) {
+		if _, err := eventlib.DefaultEventTypes.Register(name); err != nil {
+			logger.Fatal("Invalid -event-types", zap.Error(err))
+		}
+	}
+
+	factory, err := processorFactory(*backend)
+	if err != nil {
+		logger.Fatal("Invalid backend", zap.Error(err))
+	}
+
+	// Features of the cgo processor, which redis-stream feeds locally
+	localCgo := *backend == "cgo" || *backend == "redis-stream"
+
+	opts := Options{
+		Name:             *processorName,
+		QueueSize:        *queueSize,
+		MaxEventSize:     *maxEventSize,
+		MaxSourceLength:  *maxSourceLength,
+		MaxRequestSize:   *maxRequestSize,
+		HighWatermark:    *highWatermark,
+		LowWatermark:     *lowWatermark,
+		NewProcessor:     factory,
+		ProcessInterval:  *processInterval,
+		ProcessThreshold: *processThreshold,
+		DiagnosticsDir:   *diagDir,
+		PersistencePath:  *persistPath,
+		AuthConfig:       *authConfig,
+		HistorySize:      *historySize,
+		StatsMaxSources:  *statsSources,
+		FilterFile:       *filterFile,
+		SchemaDir:        *schemaDir,
+		LogLevel:         &level,
+		StreamAck: StreamAckConfig{
+			Timeout:        *streamAckTimeout,
+			MaxAttempts:    *streamAckAttempts,
+			MaxUnacked:     *streamAckMaxUnacked,
+			MaxSubscribers: *streamAckMaxSubscribers,
+		},
+	}
+
+	if opts.PersistenceSync, err = eventlib.ParseSyncPolicy(*persistSync); err != nil {
+		logger.Fatal("Invalid -persistence-sync", zap.Error(err))
+	}
+	if opts.QueueMode, err = parseQueueMode(*queueMode); err != nil {
+		logger.Fatal("Invalid -queue-mode", zap.String("queue_mode", *queueMode))
+	}
+	if opts.QueueMode == eventlib.QueuePriority && !localCgo {
+		logger.Fatal("-queue-mode=priority requires the cgo backend")
+	}
+	if *persistPath != "" && !localCgo {
+		logger.Fatal("-persistence-path requires the cgo backend")
+	}
+	if *restoreSnapshot != "" {
+		if *backend != "cgo" || *shards > 1 {
+			logger.Fatal("-restore-snapshot requires the cgo backend without -shards")
+		}
+		opts.RestoreSnapshot = *restoreSnapshot
+	}
+	if *shards > 1 {
+		if *backend != "cgo" {
+			logger.Fatal("-shards requires the cgo backend")
+		}
+		opts.NewProcessor = newPoolProcessor(*shards)
+	}
+	if *processWorkers > 1 {
+		if !localCgo {
+			logger.Fatal("-process-workers requires the cgo backend")
+		}
+		opts.ProcessWorkers = *processWorkers
+	}
+	if *handlerTimeout > 0 {
+		if !localCgo {
+			logger.Fatal("-handler-timeout requires the cgo backend")
+		}
+		opts.HandlerTimeout = *handlerTimeout
+		opts.AbandonStuckHandlers = *handlerAbandon
+	} else if *handlerAbandon {
+		logger.Fatal("-handler-abandon requires -handler-timeout")
+	}
+	if *asyncPush {
+		if !localCgo {
+			logger.Fatal("-async-push requires the cgo backend")
+		}
+		overflow, err := eventlib.ParseOverflowPolicy(*asyncOverflow)
+		if err != nil {
+			logger.Fatal("Invalid -async-overflow", zap.Error(err))
+		}
+		opts.AsyncPush = &eventlib.AsyncConfig{
+			BufferSize: *asyncBuffer,
+			Workers:    *asyncWorkers,
+			Overflow:   overflow,
+		}
+	}
+
+	if *retryAttempts > 1 {
+		opts.Retry = &eventlib.RetryPolicy{
+			MaxAttempts:    *retryAttempts,
+			InitialBackoff: *retryBackoff,
+			MaxBackoff:     *retryMaxBackoff,
+			Jitter:         *retryJitter,
+		}
+	}
+
+	if *dedupWindow > 0 {
+		if !localCgo {
+			logger.Fatal("-dedup-window requires the cgo backend")
+		}
+		opts.Dedup = &eventlib.DedupConfig{
+			Window:  *dedupWindow,
+			MaxKeys: *dedupMaxKeys,
+		}
+	}
+
+	// Decompress first so the later transformers see the JSON
+	if *gunzipData {
+		opts.Transformers = append(opts.Transformers, eventlib.GzipDecompress(0))
+	}
+	if fields := splitList(*stripFields); len(fields) > 0 {
+		opts.Transformers = append(opts.Transformers, eventlib.StripJSONFields(fields...))
+	}
+	if *sourcePrefix != "" {
+		opts.Transformers = append(opts.Transformers, eventlib.PrefixSource(*sourcePrefix))
+	}
+	if len(opts.Transformers) > 0 && !localCgo {
+		logger.Fatal("-gunzip-data, -strip-fields and -source-prefix require the cgo backend")
+	}
+
+	// Sampling is always on for the local backends, so rules can be set at
+	// runtime; with none, every event is kept
+	if localCgo {
+		opts.Sampling = &SamplingConfig{}
+		if *samplingConfig != "" {
+			sampling, err := loadSamplingConfig(*samplingConfig)
+			if err != nil {
+				logger.Fatal("Invalid sampling config", zap.Error(err))
+			}
+			opts.Sampling = &sampling
+		}
+	} else if *samplingConfig != "" {
+		logger.Fatal("-sampling-config requires the cgo backend")
+	}
+
+	if *kafkaBrokers != "" {
+		opts.Kafka = &KafkaConfig{
+			Brokers:        splitList(*kafkaBrokers),
+			Topics:         splitList(*kafkaTopics),
+			Group:          *kafkaGroup,
+			Commit:         *kafkaCommit,
+			CommitInterval: *kafkaCommitInterval,
+			OutputTopic:    *kafkaOutputTopic,
+		}
+	}
+
+	if *natsURL != "" {
+		opts.NATS = &NATSConfig{
+			URL:           *natsURL,
+			Stream:        *natsStream,
+			Subjects:      splitList(*natsSubjects),
+			Consumer:      *natsConsumer,
+			SubjectPrefix: *natsSubjectPrefix,
+			Ack:           *natsAck,
+			Batch:         *natsBatch,
+		}
+	}
+
+	if *mqttBroker != "" {
+		et, err := eventlib.ParseEventType(*mqttEventType)
+		if err != nil {
+			logger.Fatal("Invalid -mqtt-event-type", zap.Error(err))
+		}
+		opts.MQTT = &MQTTConfig{
+			Broker:      *mqttBroker,
+			Topics:      splitList(*mqttTopics),
+			QoS:         byte(*mqttQoS),
+			ClientID:    *mqttClientID,
+			Username:    *mqttUsername,
+			Password:    *mqttPassword,
+			TopicPrefix: *mqttTopicPrefix,
+			EventType:   et,
+		}
+	}
+
+	if *webhookConfig != "" {
+		webhooks, err := loadWebhookConfig(*webhookConfig)
+		if err != nil {
+			logger.Fatal("Invalid webhook config", zap.Error(err))
+		}
+		opts.Webhooks = &webhooks
+	}
+
+	if *archiveURL != "" {
+		opts.Archive = &ArchiveConfig{
+			URL:      *archiveURL,
+			Format:   *archiveFormat,
+			MaxSize:  *archiveMaxSize,
+			MaxAge:   *archiveMaxAge,
+			Endpoint: *archiveEndpoint,
+			Region:   *archiveRegion,
+		}
+	}
+
+	if *eventStoreDSN != "" {
+		opts.EventStore = &EventStoreConfig{
+			DSN:       *eventStoreDSN,
+			Retention: *eventStoreRetention,
+		}
+	}
+
+	if *corsOrigins != "" {
+		opts.CORS = &CORSConfig{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedMethods:   splitList(*corsMethods),
+			AllowedHeaders:   splitList(*corsHeaders),
+			ExposedHeaders:   splitList(*corsExpose),
+			AllowCredentials: *corsCredentials,
+			MaxAge:           *corsMaxAge,
+		}
+	}
+
+	opts.Chaos = *chaos
+	if *topics != "" {
+		opts.Topics, err = parseTopics(*topics)
+		if err != nil {
+			logger.Fatal("Invalid -topics", zap.Error(err))
+		}
+	}
+
+	if *alertConfig != "" {
+		alerts, err := loadAlertConfig(*alertConfig)
+		if err != nil {
+			logger.Fatal("Invalid alert config", zap.Error(err))
+		}
+		opts.Alerts = &alerts
+	}
+
+	if *aggregateConfig != "" {
+		aggregates, err := loadAggregateConfig(*aggregateConfig)
+		if err != nil {
+			logger.Fatal("Invalid aggregate config", zap.Error(err))
+		}
+		opts.Aggregates = &aggregates
+	}
+
+	if *pluginConfig != "" {
+		plugins, err := loadPluginConfig(*pluginConfig)
+		if err != nil {
+			logger.Fatal("Invalid plugin config", zap.Error(err))
+		}
+		opts.Plugins = &plugins
+	}
+
+	if *idempotencyTTL > 0 {
+		opts.Idempotency = &IdempotencyConfig{
+			Store:     *idempotencyBackend,
+			TTL:       *idempotencyTTL,
+			MaxKeys:   *idempotencyMaxKeys,
+			RedisAddr: *redisAddr,
+		}
+	}
+
+	limits, err := rateLimitsFromFlags()
+	if err != nil {
+		logger.Fatal("Invalid rate limit config", zap.Error(err))
+	}
+	if limits.PerSource.enabled() || limits.PerIP.enabled() || len(limits.Sources) > 0 {
+		opts.RateLimit = &limits
+	}
+
+	if *autotune {
+		if *processInterval > 0 || *processThreshold > 0 {
+			logger.Fatal("-autotune cannot be combined with -process-interval or -process-threshold")
+		}
+		opts.Autotune = &AutotuneConfig{
+			TargetDepth: *autotuneTarget,
+			Interval:    *autotuneInterval,
+			MinWorkers:  *autotuneMinWorkers,
+			MaxWorkers:  *autotuneMaxWorkers,
+			MinBatch:    *autotuneMinBatch,
+			MaxBatch:    *autotuneMaxBatch,
+			Hysteresis:  *autotuneHysteresis,
+		}
+	}
+
+	// Bind listeners, inheriting them from a parent process during upgrades
+	// or from systemd socket activation
+	mode, err := parseSocketMode(*socketMode)
+	if err != nil {
+		logger.Fatal("Invalid -socket-mode", zap.Error(err))
+	}
+	upg := newUpgrader(logger)
+
+	apiListener, err := upg.listen("api", *addr, mode)
+	if err != nil {
+		logger.Fatal("Failed to bind HTTP server", zap.Error(err))
+	}
+
+	metricsListener, err := upg.listen("metrics", *metricsAddr, mode)
+	if err != nil {
+		logger.Fatal("Failed to bind metrics server", zap.Error(err))
+	}
+
+	// The write-ahead log can only be open in one process, or both would
+	// replay the same events. During an upgrade, let the parent drain and
+	// close it first; connections wait in the listen backlog meanwhile.
+	if opts.PersistencePath != "" && upg.Upgrading() {
+		upg.Ready()
+		opts.PersistenceLockTimeout = *shutdownTimeout + upgradeTimeout
+	}
+
 	// Create server
-	srv, err := NewServer(*processorName, *queueSize, logger)
+	srv, err := NewServer(opts, logger)
 	if err != nil {
 		logger.Fatal("Failed to create server", zap.Error(err))
 	}
 	defer srv.Close()
 
+	var certs *certReloader
+	if *tlsCert != "" || *tlsKey != "" || *clientCA != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			logger.Fatal("-tls-cert and -tls-key must be set together, and -client-ca needs both")
+		}
+		if certs, err = newCertReloader(tlsFilesFromFlags(), logger); err != nil {
+			logger.Fatal("Invalid TLS configuration", zap.Error(err))
+		}
+		go certs.watch(srv.done)
+	}
+
+	// Settings first, so a reload that changes the certificate paths loads
+	// the new files
+	srv.onReload("config", srv.reloadConfig(config, certs))
+	if certs != nil {
+		srv.onReload("tls", func() error {
+			return certs.load(tlsFilesFromFlags())
+		})
+	}
+
 	// Setup routes
 	router := mux.NewRouter()
 
 	// API routes
 	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(otelmux.Middleware(serviceName))
 	api.Use(srv.loggingMiddleware)
 	api.Use(srv.metricsMiddleware)
+	api.Use(srv.corsMiddleware)
+	api.Use(srv.rateLimitMiddleware)
+	api.Use(srv.decompressMiddleware)
+	if *compressResponse {
+		api.Use(srv.compressMiddleware)
+	}
 
-	api.HandleFunc("/events", srv.handlePostEvent).Methods("POST")
-	api.HandleFunc("/events/batch", srv.handleBatchEvents).Methods("POST")
-	api.HandleFunc("/process", srv.handleProcess).Methods("POST")
-	api.HandleFunc("/process/all", srv.handleProcessAll).Methods("POST")
-	api.HandleFunc("/status", srv.handleStatus).Methods("GET")
+	api.HandleFunc("/events", srv.requireScope(scopeEventsWrite, srv.ingest(srv.idempotent(srv.handlePostEvent)))).Methods("POST")
+	api.HandleFunc("/events/batch", srv.requireScope(scopeEventsWrite, srv.ingest(srv.idempotent(srv.handleBatchEvents)))).Methods("POST")
+	api.HandleFunc("/events/ndjson", srv.requireScope(scopeEventsWrite, srv.ingestStream(srv.handleNDJSONEvents))).Methods("POST")
+	api.HandleFunc("/events", srv.requireScope(scopeEventsRead, srv.handleQueryEvents)).Methods("GET")
+	api.HandleFunc("/events/stream", srv.requireScope(scopeEventsRead, srv.handleStream)).Methods("GET")
+	api.HandleFunc("/events/sse", srv.requireScope(scopeEventsRead, srv.handleSSE)).Methods("GET")
+	api.HandleFunc("/events/backfill", srv.requireScope(scopeEventsWrite, srv.ingest(srv.handleBackfill))).Methods("POST")
+	api.HandleFunc("/events/scheduled", srv.requireScope(scopeEventsRead, srv.handleListScheduled)).Methods("GET")
+	api.HandleFunc("/events/scheduled/{id}", srv.requireScope(scopeEventsWrite, srv.handleCancelScheduled)).Methods("DELETE")
+	api.HandleFunc("/events/recent", srv.requireScope(scopeEventsRead, srv.handleRecent)).Methods("GET")
+	api.HandleFunc("/events/replay", srv.requireScope(scopeAdminProcess, srv.handleReplay)).Methods("POST")
+	api.HandleFunc("/process", srv.requireScope(scopeAdminProcess, srv.handleProcess)).Methods("POST")
+	api.HandleFunc("/process/all", srv.requireScope(scopeAdminProcess, srv.handleProcessAll)).Methods("POST")
+	api.HandleFunc("/status", srv.requireScope(scopeStatusRead, srv.handleStatus)).Methods("GET")
+	api.HandleFunc("/stats", srv.requireScope(scopeStatusRead, srv.handleStats)).Methods("GET")
+	api.HandleFunc("/aggregates", srv.requireScope(scopeStatusRead, srv.handleAggregates)).Methods("GET")
+	api.HandleFunc("/queue/peek", srv.requireScope(scopeAdminControl, srv.handlePeekQueue)).Methods("GET")
+	api.HandleFunc("/queue", srv.requireScope(scopeAdminControl, srv.handlePurgeQueue)).Methods("DELETE")
+	api.HandleFunc("/deadletters", srv.requireScope(scopeStatusRead, srv.handleDeadLetters)).Methods("GET")
 	api.HandleFunc("/health", srv.handleHealth).Methods("GET")
+	api.HandleFunc("/livez", srv.handleLivez).Methods("GET")
+	api.HandleFunc("/readyz", srv.handleReadyz).Methods("GET")
+	api.HandleFunc("/startupz", srv.handleStartupz).Methods("GET")
+	api.HandleFunc("/version", srv.requireScope(scopeStatusRead, srv.handleVersion)).Methods("GET")
+	api.HandleFunc("/admin/diagnostics", srv.requireScope(scopeAdminDiagnostics, srv.handleDiagnostics)).Methods("POST")
+	api.HandleFunc("/admin", srv.requireScope(scopeAdminControl, srv.handleAdminStatus)).Methods("GET")
+	api.HandleFunc("/admin/start", srv.requireScope(scopeAdminControl, srv.handleAdminStart)).Methods("POST")
+	api.HandleFunc("/admin/pause", srv.requireScope(scopeAdminControl, srv.handleAdminPause)).Methods("POST")
+	api.HandleFunc("/admin/stop", srv.requireScope(scopeAdminControl, srv.handleAdminStop)).Methods("POST")
+	api.HandleFunc("/admin/queue", srv.requireScope(scopeAdminControl, srv.handleAdminQueue)).Methods("PUT")
+	api.HandleFunc("/admin/logging", srv.requireScope(scopeAdminControl, srv.handleAdminLogging)).Methods("PUT")
+	api.HandleFunc("/admin/intake", srv.requireScope(scopeAdminControl, srv.handleAdminIntake)).Methods("PUT")
+	api.HandleFunc("/admin/snapshot", srv.requireScope(scopeAdminControl, srv.handleAdminSnapshot)).Methods("POST")
+	api.HandleFunc("/admin/restart", srv.requireScope(scopeAdminControl, srv.handleAdminRestart)).Methods("POST")
+	api.HandleFunc("/admin/sampling", srv.requireScope(scopeAdminControl, srv.handleGetSampling)).Methods("GET")
+	api.HandleFunc("/admin/sampling", srv.requireScope(scopeAdminControl, srv.handlePutSampling)).Methods("PUT")
+	api.HandleFunc("/admin/alerts", srv.requireScope(scopeAdminControl, srv.handleGetAlerts)).Methods("GET")
+	api.HandleFunc("/admin/alerts/{rule}", srv.requireScope(scopeAdminControl, srv.handlePutAlert)).Methods("PUT")
+	api.HandleFunc("/admin/alerts/{rule}", srv.requireScope(scopeAdminControl, srv.handleDeleteAlert)).Methods("DELETE")
+	api.HandleFunc("/admin/chaos", srv.requireScope(scopeAdminControl, srv.requireChaos(srv.handleGetChaos))).Methods("GET")
+	api.HandleFunc("/admin/chaos", srv.requireScope(scopeAdminControl, srv.requireChaos(srv.handlePutChaos))).Methods("PUT")
+	api.HandleFunc("/admin/chaos", srv.requireScope(scopeAdminControl, srv.requireChaos(srv.handleDeleteChaos))).Methods("DELETE")
+	api.HandleFunc("/admin/processing", srv.requireScope(scopeAdminControl, srv.handleAdminProcessing)).Methods("PUT")
+	api.HandleFunc("/admin/tenants", srv.requireScope(scopeAdminTenants, srv.handleListTenants)).Methods("GET")
+	api.HandleFunc("/admin/tenants", srv.requireScope(scopeAdminTenants, srv.handleCreateTenant)).Methods("POST")
+	api.HandleFunc("/admin/tenants/{tenant}", srv.requireScope(scopeAdminTenants, srv.handleDeleteTenant)).Methods("DELETE")
+	api.HandleFunc("/tenants/{tenant}", srv.withTenant(srv.handleTenantStatus)).Methods("GET")
+	api.HandleFunc("/tenants/{tenant}/events", srv.ingest(srv.withTenant(srv.handleTenantEvent))).Methods("POST")
+	api.HandleFunc("/tenants/{tenant}/process", srv.withTenant(srv.handleTenantProcess)).Methods("POST")
+	api.HandleFunc("/topics", srv.requireScope(scopeStatusRead, srv.handleListTopics)).Methods("GET")
+	api.HandleFunc("/topics/{topic}", srv.requireScope(scopeStatusRead, srv.withTopic(srv.handleTopicStatus))).Methods("GET")
+	api.HandleFunc("/topics/{topic}/events", srv.requireScope(scopeEventsWrite, srv.ingest(srv.withTopic(srv.handleTopicEvent)))).Methods("POST")
+	api.HandleFunc("/topics/{topic}/events/sse", srv.requireScope(scopeEventsRead, srv.withTopic(srv.handleTopicSSE))).Methods("GET")
+	api.HandleFunc("/topics/{topic}/process", srv.requireScope(scopeAdminProcess, srv.withTopic(srv.handleTopicProcess))).Methods("POST")
+	api.HandleFunc("/admin/topics/{topic}", srv.requireScope(scopeAdminControl, srv.handlePutTopic)).Methods("PUT")
+	api.HandleFunc("/admin/topics/{topic}", srv.requireScope(scopeAdminControl, srv.handleDeleteTopic)).Methods("DELETE")
+	api.HandleFunc("/filters", srv.requireScope(scopeStatusRead, srv.handleGetFilters)).Methods("GET")
+	api.HandleFunc("/filters", srv.requireScope(scopeAdminControl, srv.handlePutFilters)).Methods("PUT")
+	api.HandleFunc("/openapi.json", srv.handleOpenAPI).Methods("GET")
+	router.HandleFunc("/docs", docsHandler(*docsAssets)).Methods("GET")
+	if *dashboard {
+		registerUI(router)
+	}
+
+	// Documented last, so every route above is included
+	srv.openAPI, err = buildOpenAPI(api, logger)
+	if err != nil {
+		logger.Fatal("Failed to build OpenAPI document", zap.Error(err))
+	}
+
+	// OPTIONS for every path, added after the document since it is not an
+	// operation of its own. Matching a route runs the middleware, which a
+	// 405 from the router would skip.
+	api.PathPrefix("/").Methods(http.MethodOptions).HandlerFunc(srv.handleOptions(api))
 
 	// Metrics server
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics carries the exemplars; native histograms come with
+	// protobuf, which scrapers negotiate without it
+	metricsMux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	metricsMux.Handle("/loglevel", logLevelHandler(level, logger))
+	if *debugEndpoints {
+		srv.registerDebugHandlers(metricsMux)
+	}
 	metricsServer := &http.Server{
 		Addr:    *metricsAddr,
 		Handler: metricsMux,
 	}
+	if certs != nil {
+		metricsServer.TLSConfig = certs.config()
+	}
+
+	go upg.watch()
 
 	// Start server
 	httpServer := &http.Server{
@@ -69,38 +642,157 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	if certs != nil {
+		httpServer.TLSConfig = certs.config()
+	}
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	if *enableHTTP2 {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	httpServer.Protocols = &protocols
+	httpServer.RegisterOnShutdown(srv.streams.start)
 
 	// Graceful shutdown
 	done := make(chan struct{})
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
+
+		upgraded := false
+		select {
+		case <-sigChan:
+		case <-upg.Upgraded():
+			upgraded = true
+		}
 
 		logger.Info("Shutting down servers...")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 		defer cancel()
 
-		httpServer.Shutdown(ctx)
+		srv.shutdownHTTP(ctx, httpServer)
+		srv.stopConsumers()
+
+		// Ingestion has stopped, or moved to the new process during an
+		// upgrade; finish what we already accepted
+		srv.drain(ctx, upgraded)
+
 		metricsServer.Shutdown(ctx)
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("Failed to flush traces", zap.Error(err))
+		}
 		close(done)
 	}()
 
 	// Start metrics server
 	go func() {
-		logger.Info("Starting metrics server", zap.String("addr", *metricsAddr))
-		if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Info("Starting metrics server", zap.String("addr", metricsListener.Addr().String()))
+		serve := metricsServer.Serve
+		if certs != nil {
+			serve = func(l net.Listener) error { return metricsServer.ServeTLS(l, "", "") }
+		}
+		if err := serve(metricsListener); err != http.ErrServerClosed {
 			logger.Error("Metrics server error", zap.Error(err))
 		}
 	}()
 
 	// Start main server
-	logger.Info("Starting HTTP server", zap.String("addr", *addr))
-	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+	logger.Info("Starting HTTP server", zap.String("addr", apiListener.Addr().String()), zap.Bool("tls", certs != nil))
+	upg.Ready()
+	srv.markStarted()
+	serve := httpServer.Serve
+	if certs != nil {
+		serve = func(l net.Listener) error { return httpServer.ServeTLS(l, "", "") }
+	}
+	if err := serve(apiListener); err != http.ErrServerClosed {
 		logger.Fatal("HTTP server error", zap.Error(err))
 	}
 
 	<-done
 	logger.Info("Server stopped")
 }
+
+// parseQueueMode reads a -queue-mode value
+func parseQueueMode(mode string) (eventlib.QueueMode, error) {
+	switch mode {
+	case "fifo":
+		return eventlib.QueueFIFO, nil
+	case "priority":
+		return eventlib.QueuePriority, nil
+	default:
+		return 0, fmt.Errorf("unknown queue mode %q", mode)
+	}
+}
+
+// rateLimitsFromFlags builds the rate limits from the -rate-limit flags and
+// -rate-limit-config
+func rateLimitsFromFlags() (RateLimitConfig, error) {
+	limits := RateLimitConfig{
+		PerSource: RateLimit{Rate: *rateLimit, Burst: *rateBurst},
+		PerIP:     RateLimit{Rate: *ipRateLimit, Burst: *ipRateBurst},
+	}
+	if *rateLimitConfig != "" {
+		return loadRateLimitConfig(*rateLimitConfig, limits)
+	}
+	return limits, nil
+}
+
+// processorFactory maps the -backend flag to a processor constructor
+func processorFactory(name string) (ProcessorFactory, error) {
+	switch name {
+	case "cgo":
+		return newCgoProcessor, nil
+	case "redis":
+		return func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+			return redisqueue.New(&redisqueue.Config{
+				Name:         config.Name,
+				MaxQueueSize: config.MaxQueueSize,
+				Logger:       config.Logger,
+				Addr:         *redisAddr,
+				Key:          redisKeyFor(*redisKey, config.Name),
+				Consumer:     *redisConsumer,
+				ReclaimAfter: *redisClaimIdle,
+				Retry:        config.Retry,
+			}, handlers)
+		}, nil
+	case "redis-stream":
+		return func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+			return redisqueue.NewStream(&redisqueue.StreamConfig{
+				Logger:     config.Logger,
+				Addr:       *redisAddr,
+				Key:        redisKeyFor(*redisStream, config.Name),
+				Group:      *redisGroup,
+				Consumer:   *redisConsumer,
+				ClaimIdle:  *redisClaimIdle,
+				Registerer: config.Registerer,
+			}, func(handlers *eventlib.Handlers) (eventlib.Processor, error) {
+				return newCgoProcessor(config, handlers)
+			}, handlers)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+}
+
+// redisKeyFor returns the Redis key for the processor called name: key
+// itself for the server's own, and "<key>:<tenant>" for a tenant's, so
+// tenants do not share a queue
+func redisKeyFor(key, name string) string {
+	if tenant, ok := strings.CutPrefix(name, *processorName+"."); ok {
+		return key + ":" + tenant
+	}
+	return key
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
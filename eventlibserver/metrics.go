@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metric names follow one scheme, documented under Server Metrics in the
+// README: eventlibgo_processor_* for the library's own series,
+// eventlibgo_http_* for the server's, base units (_seconds, _bytes) and
+// _total on counters. Names already published outside it are kept.
+
+var (
+	requestDuration = promauto.NewHistogramVec(nativeHistogram(prometheus.HistogramOpts{
+		Name:    "eventlibgo_http_request_duration_seconds",
+		Help:    "Duration of API requests by route",
+		Buckets: prometheus.DefBuckets,
+	}), []string{"route", "method", "code"})
+
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_requests_total",
+		Help: "Total number of API requests by route",
+	}, []string{"route", "method", "code"})
+
+	pushFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_push_failures_total",
+		Help: "Total number of events the processor refused to queue",
+	}, []string{"reason"})
+
+	batchSize = promauto.NewHistogram(nativeHistogram(prometheus.HistogramOpts{
+		Name:    "eventlibgo_http_batch_size",
+		Help:    "Number of events pushed to the processor in one batch",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	}))
+)
+
+// nativeHistogram adds native (sparse) buckets to opts. Scrapers that
+// negotiate protobuf get the native histogram, the rest the classic
+// buckets.
+func nativeHistogram(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	opts.NativeHistogramBucketFactor = 1.1
+	opts.NativeHistogramMaxBucketNumber = 160
+	opts.NativeHistogramMinResetDuration = time.Hour
+	return opts
+}
+
+// observeTrace records v, with the sampled trace in ctx, if any, as its
+// exemplar, so a slow bucket links to a trace showing why
+func observeTrace(ctx context.Context, obs prometheus.Observer, v float64) {
+	sc := trace.SpanContextFromContext(ctx)
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && sc.IsSampled() {
+		eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String()})
+		return
+	}
+	obs.Observe(v)
+}
+
+// recordPushFailure counts an event the processor refused, by the status
+// batch results give it
+func recordPushFailure(err error) {
+	pushFailures.WithLabelValues(pushRefusal(err)).Inc()
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
 )
 
 var (
+	// Deprecated in favour of eventlibgo_http_request_duration_seconds and
+	// eventlibgo_http_requests_total, whose route label does not grow with
+	// every ID in a path
 	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    "http_request_duration_seconds",
 		Help:    "Duration of HTTP requests.",
@@ -54,9 +60,24 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 
 		httpDuration.WithLabelValues(r.URL.Path, r.Method, status).Observe(duration.Seconds())
 		httpRequests.WithLabelValues(r.URL.Path, r.Method, status).Inc()
+
+		route := routeTemplate(r)
+		observeTrace(r.Context(), requestDuration.WithLabelValues(route, r.Method, status), duration.Seconds())
+		requestsTotal.WithLabelValues(route, r.Method, status).Inc()
 	})
 }
 
+// routeTemplate is the path template of the route r matched, such as
+// /api/v1/topics/{topic}/events
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -66,3 +87,25 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Hijack lets WebSocket upgrades pass through the middleware chain
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Flush lets streaming responses pass through the middleware chain
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
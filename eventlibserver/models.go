@@ -1,17 +1,112 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
 
 // EventRequest represents a single event POST request
 type EventRequest struct {
-	Type   int    `json:"type"`
-	Source string `json:"source"`
-	Data   []byte `json:"data,omitempty"`
+	// Type is a number or a registered name, such as "DATA" or a type
+	// added with -event-types
+	Type   eventlib.EventType `json:"type"`
+	Source string             `json:"source"`
+	Data   []byte             `json:"data,omitempty"`
+
+	// DataJSON is a structured payload, an alternative to base64 Data;
+	// ContentType defaults to application/json
+	DataJSON json.RawMessage `json:"data_json,omitempty"`
+
+	// ContentType is the MIME type of the payload
+	ContentType string `json:"content_type,omitempty"`
+
+	// Deadline overrides the X-Deadline header for this event
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// Timestamp is when the event originally occurred; required for backfill
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Priority orders the event in priority queue mode; defaults by type
+	Priority *int `json:"priority,omitempty"`
+
+	// ID identifies the event to -dedup-window; a repeated ID within the
+	// window is dropped
+	ID string `json:"id,omitempty"`
+
+	// CorrelationID ties the event to others from the same workflow;
+	// defaults to the X-Correlation-ID header
+	CorrelationID string `json:"correlation_id,omitempty"`
+
+	// Metadata is free-form context carried through to handlers and
+	// outputs
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// DeliverAt or DelayMs holds the event back until then; see GET
+	// /events/scheduled
+	DeliverAt *time.Time `json:"deliver_at,omitempty"`
+	DelayMs   int64      `json:"delay_ms,omitempty"`
 }
 
 // BatchEventRequest represents multiple events
 type BatchEventRequest struct {
 	Events []EventRequest `json:"events"`
+
+	// Priority applies to events in the batch that don't set their own
+	Priority *int `json:"priority,omitempty"`
+}
+
+// BatchEventResponse reports the outcome of POST /events/batch, with a
+// result per event in request order
+type BatchEventResponse struct {
+	BatchCounts
+	Results []BatchEventResult `json:"results"`
+
+	// Error says why an atomic batch was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// BatchCounts totals the results of a batch. Queued includes scheduled
+// events, and Failed every other event, whatever its status.
+type BatchCounts struct {
+	Queued        int `json:"queued"`
+	Failed        int `json:"failed"`
+	RateLimited   int `json:"rate_limited"`
+	SchemaInvalid int `json:"schema_invalid"`
+	TooLarge      int `json:"too_large"`
+}
+
+// BatchEventResult is the outcome of one event of a batch. Status is
+// queued, scheduled, or why the event was not queued: invalid,
+// schema_invalid, rate_limited, too_large, paused, queue_full, failed,
+// or skipped for the valid events of a rejected atomic batch. EventID is
+// the event's ID, given or assigned, if it has one.
+type BatchEventResult struct {
+	Index   int    `json:"index"`
+	Status  string `json:"status"`
+	EventID string `json:"event_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NDJSONLineResult is streamed back by POST /events/ndjson for each
+// non-blank line, in order, with the statuses of BatchEventResult
+type NDJSONLineResult struct {
+	Line    int    `json:"line"`
+	Status  string `json:"status"`
+	EventID string `json:"event_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NDJSONSummary is the last line of a POST /events/ndjson response
+type NDJSONSummary struct {
+	Summary NDJSONCounts `json:"summary"`
+}
+
+// NDJSONCounts totals the results of an NDJSON ingest
+type NDJSONCounts struct {
+	Lines int `json:"lines"`
+	BatchCounts
 }
 
 // StatusResponse represents the processor status
@@ -20,6 +115,445 @@ type StatusResponse struct {
 	QueueSize       int       `json:"queue_size"`
 	EventsProcessed int       `json:"events_processed"`
 	Timestamp       time.Time `json:"timestamp"`
+
+	// Library is omitted for backends without C library counters
+	Library *LibraryStatsResponse `json:"library,omitempty"`
+}
+
+// StatsResponse exposes the processor's extended internal statistics
+type StatsResponse struct {
+	Name            string               `json:"name"`
+	State           string               `json:"state"`
+	UptimeSeconds   float64              `json:"uptime_seconds"`
+	QueueSize       int                  `json:"queue_size"`
+	Pushed          uint64               `json:"pushed"`
+	Processed       uint64               `json:"processed"`
+	Dropped         uint64               `json:"dropped"`
+	Filtered        uint64               `json:"filtered"`
+	Duplicates      uint64               `json:"duplicates"`
+	Expired         uint64               `json:"expired"`
+	Retried         uint64               `json:"retried"`
+	DeadLettered    uint64               `json:"dead_lettered"`
+	RetryPending    int                  `json:"retry_pending"`
+	Scheduled       int                  `json:"scheduled"`
+	ProcessedByType map[string]uint64    `json:"processed_by_type"`
+	CgoCalls        uint64               `json:"cgo_calls"`
+	Callbacks       uint64               `json:"callbacks"`
+	HandlerLatency  LatencyResponse      `json:"handler_latency"`
+	QueueLatency    LatencyResponse      `json:"queue_latency"`
+	Library         LibraryStatsResponse `json:"library"`
+	Timestamp       time.Time            `json:"timestamp"`
+
+	// ByType and BySource break down what the server's handler saw; the
+	// sources are the busiest first
+	ByType   map[string]TrafficStats `json:"by_type"`
+	BySource []SourceTrafficStats    `json:"by_source"`
+}
+
+// TrafficStats counts the outcomes of events of one type or source.
+// AvgQueueLatency is the mean time in seconds processed events waited in
+// the queue.
+type TrafficStats struct {
+	Processed       uint64    `json:"processed"`
+	Filtered        uint64    `json:"filtered"`
+	Failed          uint64    `json:"failed"`
+	Expired         uint64    `json:"expired"`
+	AvgQueueLatency float64   `json:"avg_queue_latency"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// SourceTrafficStats is TrafficStats for one source
+type SourceTrafficStats struct {
+	Source string `json:"source"`
+	TrafficStats
+}
+
+// TenantResponse describes a tenant processor
+type TenantResponse struct {
+	Name            string    `json:"name"`
+	Scope           string    `json:"scope"`
+	State           string    `json:"state"`
+	QueueSize       int       `json:"queue_size"`
+	MaxQueueSize    int       `json:"max_queue_size"`
+	EventsProcessed int       `json:"events_processed"`
+	Created         time.Time `json:"created"`
+}
+
+// TenantListResponse lists the tenant processors
+type TenantListResponse struct {
+	Tenants []TenantResponse `json:"tenants"`
+}
+
+// TopicResponse reports one topic's queue
+type TopicResponse struct {
+	Name            string `json:"name"`
+	State           string `json:"state"`
+	QueueSize       int    `json:"queue_size"`
+	MaxQueueSize    int    `json:"max_queue_size"`
+	EventsProcessed int    `json:"events_processed"`
+}
+
+// TopicListResponse lists the topics by name
+type TopicListResponse struct {
+	Topics []TopicResponse `json:"topics"`
+}
+
+// TopicRequest creates a topic or changes its queue limit; 0 takes the
+// server's -queue-size for a new topic and removes the limit of an
+// existing one
+type TopicRequest struct {
+	QueueSize int `json:"queue_size"`
+}
+
+// AdminStatusResponse reports the settings the admin API controls.
+// MaxQueueSize and Logging are omitted for backends that cannot change
+// them.
+type AdminStatusResponse struct {
+	State            string    `json:"state"`
+	IngestPaused     bool      `json:"ingest_paused"`
+	ProcessingMode   string    `json:"processing_mode"`
+	ProcessInterval  string    `json:"process_interval,omitempty"`
+	ProcessThreshold int       `json:"process_threshold"`
+	QueueSize        int       `json:"queue_size"`
+	MaxQueueSize     *int      `json:"max_queue_size,omitempty"`
+	Logging          *bool     `json:"logging,omitempty"`
+	IntakePaused     *bool     `json:"intake_paused,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// AdminQueueRequest sets the queue limit; 0 removes it
+type AdminQueueRequest struct {
+	MaxSize *int `json:"max_size"`
+}
+
+// AdminLoggingRequest turns the processor's logging on or off
+type AdminLoggingRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// AdminIntakeRequest pauses or resumes the processor's intake
+type AdminIntakeRequest struct {
+	Paused *bool `json:"paused"`
+}
+
+// AdminRestartRequest recreates the processor's C queue with new
+// settings; omitted ones keep their current values
+type AdminRestartRequest struct {
+	MaxQueueSize *int    `json:"max_queue_size,omitempty"`
+	Logging      *bool   `json:"logging,omitempty"`
+	QueueMode    *string `json:"queue_mode,omitempty"`
+}
+
+// AdminRestartResponse reports a restart and the settings now in use
+type AdminRestartResponse struct {
+	Requeued     int    `json:"requeued"`
+	MaxQueueSize int    `json:"max_queue_size"`
+	Logging      bool   `json:"logging"`
+	QueueMode    string `json:"queue_mode"`
+	Duration     string `json:"duration"`
+}
+
+// AdminProcessingRequest selects how the queue is drained. Interval and
+// Threshold apply to the "auto" mode and are kept when omitted.
+type AdminProcessingRequest struct {
+	Mode      string    `json:"mode"`
+	Interval  *duration `json:"interval,omitempty"`
+	Threshold *int      `json:"threshold,omitempty"`
+}
+
+// SyncEventResponse is the outcome of POST /events?sync=true
+type SyncEventResponse struct {
+	// Status is the outcome: handled, failed, expired, filtered,
+	// duplicate or dropped
+	Status   string `json:"status"`
+	EventID  string `json:"event_id"`
+	Attempts int    `json:"attempts"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SchemaErrorResponse lists why an event's payload failed its type's
+// JSON Schema
+type SchemaErrorResponse struct {
+	Error      string            `json:"error"`
+	Type       string            `json:"type"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+// SchemaViolation is one schema failure; Path is a JSON pointer into the
+// payload, empty for the payload as a whole
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// FiltersResponse lists the filter rules in evaluation order
+type FiltersResponse struct {
+	Default string             `json:"default"`
+	Rules   []FilterRuleStatus `json:"rules"`
+}
+
+// FilterRuleStatus is a filter rule and how many events it has matched
+type FilterRuleStatus struct {
+	FilterRule
+	Hits uint64 `json:"hits"`
+}
+
+// SamplingResponse lists the sampling rules in evaluation order
+type SamplingResponse struct {
+	Rules []SamplingRuleStatus `json:"rules"`
+}
+
+// SamplingRuleStatus is a sampling rule with how many events it has
+// matched and dropped
+type SamplingRuleStatus struct {
+	SamplingRule
+	Seen    uint64 `json:"seen"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// AggregatesResponse lists the window in progress for each aggregation
+// rule
+type AggregatesResponse struct {
+	Aggregates []AggregateStatus `json:"aggregates"`
+}
+
+// AggregateStatus is an aggregation rule's window so far
+type AggregateStatus struct {
+	Name        string                `json:"name"`
+	Op          string                `json:"op"`
+	Field       string                `json:"field,omitempty"`
+	Window      string                `json:"window"`
+	Slide       string                `json:"slide"`
+	WindowStart time.Time             `json:"window_start"`
+	WindowEnd   time.Time             `json:"window_end"`
+	Groups      []AggregateGroupValue `json:"groups"`
+}
+
+// AggregateGroupValue is one group's value; the group is "*" for a rule
+// without group_by
+type AggregateGroupValue struct {
+	Group string  `json:"group"`
+	Value float64 `json:"value"`
+}
+
+// AggregateSummary is the data of a summary event pushed as a window
+// closes
+type AggregateSummary struct {
+	Rule        string    `json:"rule"`
+	Op          string    `json:"op"`
+	Field       string    `json:"field,omitempty"`
+	Group       string    `json:"group"`
+	Value       float64   `json:"value"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+// ChaosResponse reports the fault rates and how many faults have been
+// injected since startup
+type ChaosResponse struct {
+	QueueFull        float64     `json:"queue_full"`
+	CallFailure      float64     `json:"call_failure"`
+	SlowHandler      float64     `json:"slow_handler"`
+	SlowHandlerDelay string      `json:"slow_handler_delay"`
+	HandlerPanic     float64     `json:"handler_panic"`
+	Injected         ChaosCounts `json:"injected"`
+}
+
+// ChaosCounts are how many faults of each kind have been injected
+type ChaosCounts struct {
+	QueueFull    uint64 `json:"queue_full"`
+	CallFailure  uint64 `json:"call_failure"`
+	SlowHandler  uint64 `json:"slow_handler"`
+	HandlerPanic uint64 `json:"handler_panic"`
+}
+
+// AlertsResponse lists the alert rules and the targets they can notify
+type AlertsResponse struct {
+	Rules   []AlertRuleStatus `json:"rules"`
+	Targets []string          `json:"targets"`
+}
+
+// AlertRuleStatus is an alert rule with the sources it is counting
+type AlertRuleStatus struct {
+	Name      string               `json:"name"`
+	Types     []eventlib.EventType `json:"types,omitempty"`
+	Sources   []string             `json:"sources,omitempty"`
+	Threshold int                  `json:"threshold"`
+	Window    string               `json:"window"`
+	Cooldown  string               `json:"cooldown"`
+	Notify    []string             `json:"notify"`
+
+	// Fired counts the rule's alerts since it was loaded
+	Fired  uint64              `json:"fired"`
+	Counts []AlertSourceStatus `json:"counts"`
+}
+
+// AlertSourceStatus is one source's matching events within the window.
+// Recent stops at one past the threshold, which is all a rule counts.
+type AlertSourceStatus struct {
+	Source    string     `json:"source"`
+	Recent    int        `json:"recent"`
+	LastFired *time.Time `json:"last_fired,omitempty"`
+}
+
+// Alert is the body sent to webhook targets when a rule fires, and the
+// custom details of PagerDuty events
+type Alert struct {
+	Rule      string       `json:"rule"`
+	Source    string       `json:"source"`
+	Summary   string       `json:"summary"`
+	Count     int          `json:"count"`
+	Threshold int          `json:"threshold"`
+	Window    string       `json:"window"`
+	FirstAt   time.Time    `json:"first_at"`
+	FiredAt   time.Time    `json:"fired_at"`
+	Event     EventMessage `json:"event"`
+}
+
+// RecentEvent is a processed event from the history
+type RecentEvent struct {
+	Event       EventMessage `json:"event"`
+	ProcessedAt time.Time    `json:"processed_at"`
+}
+
+// RecentEventsResponse lists recently processed events, newest first
+type RecentEventsResponse struct {
+	Events []RecentEvent `json:"events"`
+	Count  int           `json:"count"`
+}
+
+// StoredEventsResponse is a page of events from the event store, newest
+// first. NextCursor is set when there may be more.
+type StoredEventsResponse struct {
+	Events     []RecentEvent `json:"events"`
+	Count      int           `json:"count"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// ReplayRequest selects processed events to enqueue again. From is
+// inclusive and To exclusive; omitted fields match everything.
+type ReplayRequest struct {
+	From    *time.Time           `json:"from,omitempty"`
+	To      *time.Time           `json:"to,omitempty"`
+	Types   []eventlib.EventType `json:"types,omitempty"`
+	Sources []string             `json:"sources,omitempty"`
+
+	// Limit caps how many events are replayed, oldest first
+	Limit int `json:"limit,omitempty"`
+
+	// DryRun reports how many events match without replaying them
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// ReplayResponse reports the outcome of a replay
+type ReplayResponse struct {
+	Matched int  `json:"matched"`
+	Queued  int  `json:"queued"`
+	Failed  int  `json:"failed"`
+	DryRun  bool `json:"dry_run"`
+
+	// Oldest is the time of the oldest event still in the history; earlier
+	// events can no longer be replayed
+	Oldest *time.Time `json:"oldest,omitempty"`
+}
+
+// ScheduledEventResponse is an event held until its deliver time
+type ScheduledEventResponse struct {
+	Event     EventMessage `json:"event"`
+	DeliverAt time.Time    `json:"deliver_at"`
+}
+
+// ScheduledEventsResponse lists the scheduled events, soonest first
+type ScheduledEventsResponse struct {
+	Events []ScheduledEventResponse `json:"events"`
+	Count  int                      `json:"count"`
+}
+
+// QueuedEventsResponse lists events waiting in the queue, in the order
+// they will be processed
+type QueuedEventsResponse struct {
+	Events []EventMessage `json:"events"`
+	Count  int            `json:"count"`
+}
+
+// PurgeResponse reports how many queued events a purge removed
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// DeadLetterResponse is an event whose handlers failed on every attempt
+type DeadLetterResponse struct {
+	Event    EventMessage `json:"event"`
+	Error    string       `json:"error"`
+	Attempts int          `json:"attempts"`
+	Time     time.Time    `json:"time"`
+}
+
+// DebugProcessorResponse is the internal state served at /debug/processor
+type DebugProcessorResponse struct {
+	Name            string    `json:"name,omitempty"`
+	State           string    `json:"state"`
+	QueueSize       int       `json:"queue_size"`
+	Buffered        int       `json:"buffered"`
+	RetryPending    int       `json:"retry_pending"`
+	EventsProcessed int       `json:"events_processed"`
+	Shards          int       `json:"shards,omitempty"`
+	LiveHandles     int       `json:"live_handles"`
+	Leaked          int       `json:"leaked_processors"`
+	CgoCalls        uint64    `json:"cgo_calls"`
+	Callbacks       uint64    `json:"callbacks"`
+	RuntimeCgoCalls int64     `json:"runtime_cgo_calls"`
+	Goroutines      int       `json:"goroutines"`
+	StreamClients   int       `json:"stream_clients"`
+	HeapAlloc       uint64    `json:"heap_alloc"`
+	HeapObjects     uint64    `json:"heap_objects"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// LibraryStatsResponse reports the C library's own counters, with times
+// in seconds
+type LibraryStatsResponse struct {
+	Submitted         uint64  `json:"submitted"`
+	Filtered          uint64  `json:"filtered"`
+	Rejected          uint64  `json:"rejected"`
+	Cleared           uint64  `json:"cleared"`
+	QueueHighWater    int     `json:"queue_high_water"`
+	MaxQueueSize      int     `json:"max_queue_size"`
+	ProcessingTime    float64 `json:"processing_time"`
+	MaxProcessingTime float64 `json:"max_processing_time"`
+}
+
+// LatencyResponse summarizes latencies in seconds
+type LatencyResponse struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// VersionResponse identifies the exact build of a deployment
+type VersionResponse struct {
+	Version      string          `json:"version"`
+	GoVersion    string          `json:"go_version"`
+	OS           string          `json:"os"`
+	Arch         string          `json:"arch"`
+	Revision     string          `json:"vcs_revision,omitempty"`
+	RevisionTime string          `json:"vcs_time,omitempty"`
+	Modified     bool            `json:"vcs_modified"`
+	CgoEnabled   bool            `json:"cgo_enabled"`
+	BuildTags    string          `json:"build_tags,omitempty"`
+	Library      LibraryResponse `json:"library"`
+	Features     []string        `json:"features"`
+}
+
+// LibraryResponse describes the linked eventlib C library
+type LibraryResponse struct {
+	Version    string `json:"version"`
+	BuildFlags string `json:"build_flags"`
+	Linkage    string `json:"linkage"`
+	Error      string `json:"error,omitempty"`
 }
 
 // HealthResponse represents health check response
@@ -28,10 +562,34 @@ type HealthResponse struct {
 	Checks map[string]bool `json:"checks"`
 }
 
-// EventMessage for WebSocket streaming
+// ProbeResponse is the response from /livez, /readyz and /startupz
+type ProbeResponse struct {
+	Status string              `json:"status"`
+	Failed []string            `json:"failed,omitempty"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is one check's outcome, included with ?verbose=1
+type HealthCheckResult struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// EventMessage for WebSocket and SSE streaming
 type EventMessage struct {
-	Type      string    `json:"type"`
-	Source    string    `json:"source"`
-	Data      []byte    `json:"data,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	// eventType is the numeric type, for matching webhook filters
+	eventType eventlib.EventType
+
+	ID          uint64    `json:"id"`
+	EventID     string    `json:"event_id,omitempty"`
+	Type        string    `json:"type"`
+	Source      string    `json:"source"`
+	Data        []byte    `json:"data,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
 }
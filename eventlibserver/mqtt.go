@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// mqttStatusSource is the source of the CONNECT and DISCONNECT events the
+// bridge reports about its own connection
+const mqttStatusSource = "mqtt"
+
+const mqttDisconnectQuiesce = 250 // ms
+
+var (
+	mqttReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_mqtt_messages_received_total",
+		Help: "Total number of MQTT messages queued as events",
+	})
+
+	mqttDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_mqtt_messages_dropped_total",
+		Help: "Total number of MQTT messages that could not be queued",
+	}, []string{"reason"})
+
+	mqttConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "eventlibgo_mqtt_connected",
+		Help: "Whether the MQTT bridge is connected to its broker",
+	})
+)
+
+// MQTTConfig subscribes the server to an MQTT broker
+type MQTTConfig struct {
+	// Broker is a URL such as tcp://localhost:1883 or ssl://host:8883
+	Broker string
+
+	// Topics are topic filters, which may use + and # wildcards
+	Topics []string
+	QoS    byte
+
+	// ClientID defaults to eventlibserver-<hostname>. The session is kept
+	// across reconnects, so QoS 1 and 2 messages sent while the bridge was
+	// away are delivered when it returns.
+	ClientID string
+	Username string
+	Password string
+
+	// TopicPrefix is trimmed from a message's topic to give the event
+	// source, so "devices/sensor-1" becomes "sensor-1" with "devices/"
+	TopicPrefix string
+
+	// EventType is the type of events made from messages
+	EventType eventlib.EventType
+}
+
+func (c *MQTTConfig) validate() error {
+	if c.Broker == "" {
+		return errors.New("mqtt: no broker")
+	}
+	if len(c.Topics) == 0 {
+		return errors.New("mqtt: no topics")
+	}
+	if c.QoS > 2 {
+		return fmt.Errorf("mqtt: invalid QoS %d", c.QoS)
+	}
+	if c.ClientID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		c.ClientID = "eventlibserver-" + host
+	}
+	return nil
+}
+
+// mqttBridge pushes messages from subscribed MQTT topics into the
+// processor: the topic becomes the source and the payload the data. Its
+// own connection changes are pushed as CONNECT and DISCONNECT events.
+type mqttBridge struct {
+	s         *Server
+	config    MQTTConfig
+	client    mqtt.Client
+	connected atomic.Bool
+	stopOnce  sync.Once
+}
+
+func newMQTTBridge(config MQTTConfig, s *Server) *mqttBridge {
+	b := &mqttBridge{s: s, config: config}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		// Keep trying in the background if the broker is down at startup
+		SetConnectRetry(true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(b.onConnectionLost)
+
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// start connects once the processor is running
+func (b *mqttBridge) start() {
+	b.client.Connect()
+}
+
+// onConnect subscribes on every connect, since the broker may have lost
+// the session
+func (b *mqttBridge) onConnect(client mqtt.Client) {
+	filters := make(map[string]byte, len(b.config.Topics))
+	for _, topic := range b.config.Topics {
+		filters[topic] = b.config.QoS
+	}
+
+	token := client.SubscribeMultiple(filters, b.onMessage)
+	if token.Wait() && token.Error() != nil {
+		b.s.logger.Error("Failed to subscribe to MQTT topics",
+			zap.Strings("topics", b.config.Topics),
+			zap.Error(token.Error()))
+		b.pushStatus(eventlib.EventTypeError, "subscribe failed: "+token.Error().Error())
+		return
+	}
+
+	b.connected.Store(true)
+	mqttConnected.Set(1)
+	b.s.logger.Info("Connected to MQTT broker",
+		zap.String("broker", b.config.Broker),
+		zap.Strings("topics", b.config.Topics))
+	b.pushStatus(eventlib.EventTypeConnect, b.config.Broker)
+}
+
+func (b *mqttBridge) onConnectionLost(_ mqtt.Client, err error) {
+	b.connected.Store(false)
+	mqttConnected.Set(0)
+	b.s.logger.Warn("Lost connection to MQTT broker",
+		zap.String("broker", b.config.Broker),
+		zap.Error(err))
+	b.pushStatus(eventlib.EventTypeDisconnect, err.Error())
+}
+
+// onMessage queues a message. MQTT has no way to hand a message back, so
+// ones that cannot be queued now, including while ingestion is paused, are
+// dropped.
+func (b *mqttBridge) onMessage(_ mqtt.Client, msg mqtt.Message) {
+	event := eventlib.Event{
+		Type:      b.config.EventType,
+		Source:    strings.TrimPrefix(msg.Topic(), b.config.TopicPrefix),
+		Data:      msg.Payload(),
+		Timestamp: time.Now(),
+		Priority:  b.config.EventType.DefaultPriority(),
+	}
+
+	if b.s.ingestPaused.Load() {
+		mqttDropped.WithLabelValues("paused").Inc()
+		b.s.drops.record(event, "ingestion paused")
+		return
+	}
+	if allowed, _ := b.s.allowSource(event.Source); !allowed {
+		mqttDropped.WithLabelValues("rate_limited").Inc()
+		b.s.drops.record(event, "rate limited")
+		return
+	}
+	if err := b.s.processor.Push(event); err != nil {
+		reason := "error"
+		switch {
+		case errors.Is(err, eventlib.ErrQueueFull):
+			reason = "queue_full"
+		case errors.Is(err, eventlib.ErrPaused):
+			reason = "paused"
+		case errors.Is(err, eventlib.ErrEventTooLarge):
+			reason = "too_large"
+		}
+		mqttDropped.WithLabelValues(reason).Inc()
+		b.s.drops.record(event, err.Error())
+		return
+	}
+
+	mqttReceived.Inc()
+	eventsReceived.WithLabelValues(event.Type.String(), event.Source).Inc()
+	b.s.notifyPushed()
+}
+
+// pushStatus reports a change in the bridge's connection as an event
+func (b *mqttBridge) pushStatus(et eventlib.EventType, detail string) {
+	event := eventlib.Event{
+		Type:      et,
+		Source:    mqttStatusSource,
+		Data:      []byte(detail),
+		Timestamp: time.Now(),
+		Priority:  et.DefaultPriority(),
+	}
+	if err := b.s.processor.Push(event); err != nil {
+		b.s.drops.record(event, err.Error())
+		return
+	}
+	b.s.notifyPushed()
+}
+
+// isConnected reports whether the bridge is connected and subscribed
+func (b *mqttBridge) isConnected() bool {
+	return b.connected.Load()
+}
+
+// stop disconnects, giving in-flight messages a moment to finish
+func (b *mqttBridge) stop() {
+	b.stopOnce.Do(func() {
+		b.client.Disconnect(mqttDisconnectQuiesce)
+		b.connected.Store(false)
+		mqttConnected.Set(0)
+	})
+}
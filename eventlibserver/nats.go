@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// NATS acknowledgement modes
+const (
+	// NATSAckPush acks each message once its event is queued
+	NATSAckPush = "push"
+
+	// NATSAckProcessed acks a fetched batch once the queue has been
+	// drained past it, so a crash before processing redelivers it
+	NATSAckProcessed = "processed"
+)
+
+const (
+	natsDefaultBatch = 100
+	natsFetchWait    = time.Second
+	natsRetryDelay   = time.Second
+)
+
+var (
+	natsConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_nats_messages_consumed_total",
+		Help: "Total number of JetStream messages queued as events",
+	}, []string{"stream"})
+
+	natsInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_nats_messages_invalid_total",
+		Help: "Total number of JetStream messages terminated because they are not valid events",
+	}, []string{"stream"})
+
+	natsRedelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_nats_messages_nacked_total",
+		Help: "Total number of JetStream messages handed back for redelivery",
+	}, []string{"stream"})
+
+	natsPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_nats_consumer_pending",
+		Help: "Messages waiting in the stream for the consumer, as of the last message read",
+	}, []string{"stream"})
+)
+
+// NATSConfig subscribes the server to a JetStream stream
+type NATSConfig struct {
+	URL    string
+	Stream string
+
+	// Subjects filters the stream; empty consumes every subject
+	Subjects []string
+
+	// Consumer is the durable consumer name, shared by every server that
+	// should split the stream between them
+	Consumer string
+
+	// SubjectPrefix is trimmed from a message's subject to give the event
+	// source, so "events.billing" becomes "billing" with "events."
+	SubjectPrefix string
+
+	// Ack is NATSAckPush (the default) or NATSAckProcessed
+	Ack string
+
+	// Batch is how many messages are fetched at once; default 100
+	Batch int
+}
+
+func (c *NATSConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("nats: no server URL")
+	}
+	if c.Stream == "" {
+		return errors.New("nats: no stream")
+	}
+	if c.Consumer == "" {
+		return errors.New("nats: no consumer name")
+	}
+	switch c.Ack {
+	case "":
+		c.Ack = NATSAckPush
+	case NATSAckPush, NATSAckProcessed:
+	default:
+		return fmt.Errorf("nats: unknown ack mode %q", c.Ack)
+	}
+	if c.Batch < 0 {
+		return fmt.Errorf("nats: negative batch %d", c.Batch)
+	}
+	if c.Batch == 0 {
+		c.Batch = natsDefaultBatch
+	}
+	return nil
+}
+
+// natsSource pulls events from a durable JetStream consumer into the
+// processor. Messages are acked once queued, or once processed in
+// NATSAckProcessed mode; anything unacked is redelivered.
+type natsSource struct {
+	s        *Server
+	config   NATSConfig
+	conn     *nats.Conn
+	consumer jetstream.Consumer
+
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newNATSSource connects and creates the durable consumer if needed
+func newNATSSource(config NATSConfig, s *Server) (*natsSource, error) {
+	conn, err := nats.Connect(config.URL,
+		nats.Name("eventlibserver"),
+		nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	consumer, err := js.CreateOrUpdateConsumer(ctx, config.Stream, jetstream.ConsumerConfig{
+		Durable:        config.Consumer,
+		FilterSubjects: config.Subjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: failed to create consumer: %w", err)
+	}
+
+	return &natsSource{
+		s:        s,
+		config:   config,
+		conn:     conn,
+		consumer: consumer,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// start begins consuming once the processor is running
+func (src *natsSource) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	src.cancel = cancel
+	go src.run(ctx)
+}
+
+func (src *natsSource) run(ctx context.Context) {
+	defer close(src.done)
+
+	for ctx.Err() == nil {
+		batch, err := src.consumer.Fetch(src.config.Batch, jetstream.FetchMaxWait(natsFetchWait))
+		if err != nil {
+			src.s.logger.Warn("Failed to fetch from JetStream", zap.Error(err))
+			select {
+			case <-time.After(natsRetryDelay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		var queued []jetstream.Msg
+		for msg := range batch.Messages() {
+			if src.queue(ctx, msg) && src.config.Ack == NATSAckProcessed {
+				queued = append(queued, msg)
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) && ctx.Err() == nil {
+			src.s.logger.Warn("JetStream fetch ended early", zap.Error(err))
+		}
+
+		if len(queued) > 0 {
+			// The batch is acked only once every event in it has been
+			// handled
+			if err := src.s.processAll(ctx); err != nil {
+				src.nakAll(queued)
+				continue
+			}
+			for _, msg := range queued {
+				src.ack(msg)
+			}
+		}
+	}
+}
+
+// queue pushes the event carried by msg and reports whether it was queued.
+// In NATSAckPush mode a queued message is acked at once. Invalid messages
+// are terminated; ones that cannot be queued now are handed back.
+func (src *natsSource) queue(ctx context.Context, msg jetstream.Msg) bool {
+	if meta, err := msg.Metadata(); err == nil {
+		natsPending.WithLabelValues(src.config.Stream).Set(float64(meta.NumPending))
+	}
+
+	event, err := src.decode(msg)
+	if err == nil {
+		err = src.s.schemas.check(event)
+	}
+	if err != nil {
+		src.term(msg, err)
+		return false
+	}
+
+	if ctx.Err() != nil {
+		src.nak(msg, 0)
+		return false
+	}
+	if src.s.ingestPaused.Load() {
+		src.nak(msg, ingestPausedRetry)
+		return false
+	}
+	if allowed, retryAfter := src.s.allowSource(event.Source); !allowed {
+		src.nak(msg, retryAfter)
+		return false
+	}
+
+	if err := src.s.processor.Push(event); err != nil {
+		switch {
+		case errors.Is(err, eventlib.ErrPaused):
+			src.nak(msg, ingestPausedRetry)
+		case errors.Is(err, eventlib.ErrQueueFull):
+			src.nak(msg, natsRetryDelay)
+		case errors.Is(err, eventlib.ErrEventTooLarge):
+			src.s.drops.record(event, err.Error())
+			src.term(msg, err)
+		default:
+			src.s.drops.record(event, err.Error())
+			src.nak(msg, 0)
+		}
+		return false
+	}
+
+	natsConsumed.WithLabelValues(src.config.Stream).Inc()
+	eventsReceived.WithLabelValues(event.Type.String(), event.Source).Inc()
+	src.s.notifyPushed()
+
+	if src.config.Ack == NATSAckPush {
+		src.ack(msg)
+	}
+	return true
+}
+
+// decode reads an event from a message whose data is a JSON event, as
+// posted to /api/v1/events. The source defaults to the subject, less
+// SubjectPrefix.
+func (src *natsSource) decode(msg jetstream.Msg) (eventlib.Event, error) {
+	var req EventRequest
+	if err := json.Unmarshal(msg.Data(), &req); err != nil {
+		return eventlib.Event{}, err
+	}
+	if err := req.validate(); err != nil {
+		return eventlib.Event{}, err
+	}
+	if req.Source == "" {
+		req.Source = strings.TrimPrefix(msg.Subject(), src.config.SubjectPrefix)
+	}
+	return req.toEvent(time.Time{}), nil
+}
+
+func (src *natsSource) ack(msg jetstream.Msg) {
+	if err := msg.Ack(); err != nil {
+		src.s.logger.Warn("Failed to ack JetStream message",
+			zap.String("subject", msg.Subject()),
+			zap.Error(err))
+	}
+}
+
+// term stops redelivery of an invalid message, which would fail again
+func (src *natsSource) term(msg jetstream.Msg, err error) {
+	natsInvalid.WithLabelValues(src.config.Stream).Inc()
+	src.s.logger.Warn("Terminating invalid JetStream message",
+		zap.String("subject", msg.Subject()),
+		zap.Error(err))
+	if err := msg.Term(); err != nil {
+		src.s.logger.Warn("Failed to terminate JetStream message", zap.Error(err))
+	}
+}
+
+// nak hands msg back for redelivery after delay
+func (src *natsSource) nak(msg jetstream.Msg, delay time.Duration) {
+	natsRedelivered.WithLabelValues(src.config.Stream).Inc()
+	if err := msg.NakWithDelay(delay); err != nil {
+		src.s.logger.Warn("Failed to nak JetStream message",
+			zap.String("subject", msg.Subject()),
+			zap.Error(err))
+	}
+}
+
+func (src *natsSource) nakAll(msgs []jetstream.Msg) {
+	for _, msg := range msgs {
+		src.nak(msg, 0)
+	}
+}
+
+// stop stops fetching, waits for the current batch and closes the
+// connection once outstanding acks are sent
+func (src *natsSource) stop() {
+	src.stopOnce.Do(func() {
+		src.cancel()
+		<-src.done
+		if err := src.conn.FlushTimeout(5 * time.Second); err != nil {
+			src.s.logger.Warn("Failed to flush NATS acks", zap.Error(err))
+		}
+		src.conn.Close()
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+const (
+	// ndjsonChunk is the most lines read before their events are pushed
+	// and their results sent; fewer are pushed whenever the client pauses
+	ndjsonChunk = 256
+
+	// ndjsonIdleTimeout is how long an NDJSON stream may go without a
+	// chunk being pushed, in place of the server's read and write timeouts
+	ndjsonIdleTimeout = 15 * time.Second
+)
+
+// errLineTooLong is returned by readLine for a line over the limit
+var errLineTooLong = errors.New("line is too long")
+
+// ndjsonLine is a line read but not yet answered. Its event, if it was
+// not refused, is pushed with the rest of the chunk.
+type ndjsonLine struct {
+	result NDJSONLineResult
+	event  eventlib.Event
+}
+
+// handleNDJSONEvents ingests a stream of newline-delimited JSON events,
+// each in the POST /events format. Lines are read as they arrive and
+// pushed in chunks, and a result for each is streamed back in order,
+// followed by a summary. Nothing is held beyond the current chunk, so the
+// body may be far larger than a batch.
+func (s *Server) handleNDJSONEvents(w http.ResponseWriter, r *http.Request) {
+	deadline, err := parseDeadline(r.Header.Get("X-Deadline"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid X-Deadline header")
+		return
+	}
+
+	// Answer lines while the client is still sending; HTTP/2 always can
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		s.logger.Debug("Cannot enable full duplex for NDJSON", zap.Error(err))
+	}
+	extendDeadlines := func() {
+		next := time.Now().Add(ndjsonIdleTimeout)
+		rc.SetReadDeadline(next)
+		rc.SetWriteDeadline(next)
+	}
+	extendDeadlines()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	var counts NDJSONCounts
+
+	// send pushes the chunk's events and writes every line's result
+	chunk := make([]ndjsonLine, 0, ndjsonChunk)
+	send := func() error {
+		s.pushNDJSON(chunk)
+		for _, line := range chunk {
+			counts.Lines++
+			counts.add(line.result.Status)
+			if err := enc.Encode(line.result); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		extendDeadlines()
+		return rc.Flush()
+	}
+
+	in := bufio.NewReaderSize(r.Body, 64<<10)
+	lineNumber := 0
+	for {
+		data, err := readLine(in, s.maxRequestSize)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		lineNumber++
+
+		broken := false
+		switch {
+		case errors.Is(err, errLineTooLong):
+			chunk = append(chunk, ndjsonLine{result: NDJSONLineResult{
+				Line:   lineNumber,
+				Status: refusedTooLarge,
+				Error:  fmt.Sprintf("line is over the limit of %d bytes", s.maxRequestSize),
+			}})
+		case err != nil:
+			// The body broke off; answer what was read and stop
+			s.logger.Warn("Failed to read NDJSON body",
+				zap.Error(err),
+				zap.Int("line", lineNumber))
+			chunk = append(chunk, ndjsonLine{result: NDJSONLineResult{
+				Line:   lineNumber,
+				Status: refusedFailed,
+				Error:  "failed to read request body: " + err.Error(),
+			}})
+			broken = true
+		case len(data) > 0:
+			chunk = append(chunk, s.ndjsonLine(r, lineNumber, data, deadline))
+		}
+
+		// Push when the chunk is full or the client has paused, so results
+		// keep pace with a slow sender
+		if len(chunk) == ndjsonChunk || (len(chunk) > 0 && (broken || in.Buffered() == 0)) {
+			if err := send(); err != nil {
+				s.logger.Warn("Failed to write NDJSON results", zap.Error(err))
+				return
+			}
+		}
+		if broken {
+			break
+		}
+	}
+
+	if len(chunk) > 0 {
+		if err := send(); err != nil {
+			s.logger.Warn("Failed to write NDJSON results", zap.Error(err))
+			return
+		}
+	}
+	enc.Encode(NDJSONSummary{Summary: counts})
+	out.Flush()
+}
+
+// ndjsonLine decodes and checks one line of an NDJSON body
+func (s *Server) ndjsonLine(r *http.Request, number int, data []byte, deadline time.Time) ndjsonLine {
+	line := ndjsonLine{result: NDJSONLineResult{Line: number}}
+
+	var req EventRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		line.result.Status = refusedInvalid
+		line.result.Error = err.Error()
+		return line
+	}
+
+	event, refused, _, err := s.batchEvent(r, req, deadline, true)
+	line.event = event
+	line.result.Status = refused
+	line.result.EventID = event.ID
+	switch {
+	case refused == refusedRateLimit:
+		line.result.Error = "rate limited"
+	case err != nil:
+		line.result.Error = err.Error()
+	}
+	return line
+}
+
+// pushNDJSON pushes the events of the lines that were not refused, in one
+// batch, and fills in their results
+func (s *Server) pushNDJSON(chunk []ndjsonLine) {
+	events := make([]eventlib.Event, 0, len(chunk))
+	indexes := make([]int, 0, len(chunk))
+	for i, line := range chunk {
+		if line.result.Status == "" {
+			events = append(events, line.event)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	for j, err := range s.pushBatch(events) {
+		line := &chunk[indexes[j]]
+		if err == nil {
+			line.result.Status = pushedStatus(line.event)
+			eventsReceived.WithLabelValues(
+				line.event.Type.String(),
+				line.event.Source,
+			).Inc()
+			continue
+		}
+
+		s.drops.record(line.event, err.Error())
+		line.result.Status = pushRefusal(err)
+		line.result.Error = err.Error()
+	}
+	s.notifyPushed()
+}
+
+// readLine reads the next line, without its line ending. A line longer
+// than limit, if limit is set, is skipped and reported as errLineTooLong.
+// A last line without a newline is still returned; io.EOF follows it.
+func readLine(r *bufio.Reader, limit int64) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, chunk...)
+			if limit > 0 && int64(len(line)) > limit+1 {
+				line, tooLong = nil, true
+			}
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && (len(line) > 0 || tooLong):
+			// The last line has no newline
+		case err != nil:
+			return nil, err
+		}
+		if tooLong {
+			return nil, errLineTooLong
+		}
+		return bytes.TrimSpace(line), nil
+	}
+}
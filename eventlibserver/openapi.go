@@ -0,0 +1,819 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// routeDoc describes an API route for the OpenAPI document. Routes are
+// found by walking the router, so a route missing here is still listed,
+// just without its models.
+type routeDoc struct {
+	Summary string
+
+	// Scope is what callers need when authentication is enabled; empty for
+	// public routes
+	Scope string
+
+	// Request and Response are zero values of the JSON bodies, or a
+	// *schema for bodies without a model
+	Request  any
+	Response any
+
+	// Status is the success status; defaults to 200
+	Status int
+
+	// MediaType is the success response type, for routes not answering
+	// with JSON
+	MediaType string
+
+	// RequestMediaType is the request body type, for routes not taking a
+	// JSON document
+	RequestMediaType string
+
+	Params []paramDoc
+}
+
+// paramDoc is a query or header parameter
+type paramDoc struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+}
+
+func query(name, typ, description string) paramDoc {
+	return paramDoc{Name: name, In: "query", Type: typ, Description: description}
+}
+
+func header(name, description string) paramDoc {
+	return paramDoc{Name: name, In: "header", Type: "string", Description: description}
+}
+
+var (
+	eventHeaders = []paramDoc{
+		header("X-Deadline", "Time after which the event expires, RFC 3339 or a duration such as 30s"),
+		header("X-Correlation-ID", "Correlation ID for events that don't set one"),
+	}
+	idempotencyParam = header(idempotencyHeader, "Key under which the response is stored and replayed for retries")
+	streamParams     = []paramDoc{
+		query("type", "string", "Comma-separated event types to receive"),
+		query("source", "string", "Comma-separated sources to receive"),
+		query("format", "string", "cloudevents to receive CloudEvents"),
+		query("topic", "string", "Only events pushed to this topic"),
+	}
+	verboseParam = query("verbose", "boolean", "Include each check's outcome")
+)
+
+// routeDocs documents the API routes, keyed by method and path template
+var routeDocs = map[string]routeDoc{
+	"POST /api/v1/events": {
+		Summary:  "Submit an event",
+		Scope:    scopeEventsWrite,
+		Request:  EventRequest{},
+		Response: statusObject("status", "event_id", "deliver_at"),
+		Status:   http.StatusAccepted,
+		Params: append([]paramDoc{
+			query("sync", "boolean", "Wait for the event to be handled; responds with a SyncEventResponse"),
+			query("timeout", "string", "How long a sync request waits, such as 5s"),
+			query("type", "string", "Event type, for a raw payload body"),
+			query("source", "string", "Event source, for a raw payload body"),
+			query("priority", "integer", "Event priority, for a raw payload body"),
+			idempotencyParam,
+		}, eventHeaders...),
+	},
+	"POST /api/v1/events/batch": {
+		Summary:  "Submit events in a batch",
+		Scope:    scopeEventsWrite,
+		Request:  BatchEventRequest{},
+		Response: BatchEventResponse{},
+		Status:   http.StatusAccepted,
+		Params: append([]paramDoc{
+			query("atomic", "boolean", "Reject the whole batch with 422 if any event is invalid, fails its schema or is rate limited"),
+			idempotencyParam,
+		}, eventHeaders...),
+	},
+	"POST /api/v1/events/ndjson": {
+		Summary:          "Stream newline-delimited JSON events, answered line by line",
+		Scope:            scopeEventsWrite,
+		Request:          EventRequest{},
+		RequestMediaType: "application/x-ndjson",
+		Response:         NDJSONLineResult{},
+		MediaType:        "application/x-ndjson",
+		Params:           eventHeaders,
+	},
+	"GET /api/v1/events/stream": {
+		Summary:   "Stream processed events over a WebSocket",
+		Scope:     scopeEventsRead,
+		Response:  EventMessage{},
+		Status:    http.StatusSwitchingProtocols,
+		MediaType: "application/json",
+		Params: append([]paramDoc{
+			query("ack", "boolean", "Ack events with {\"ack\": [ids]} frames; unacked events are sent again"),
+			query("subscriber", "string", "Name of an acknowledging subscriber, which keeps its unacked events across reconnects"),
+		}, streamParams...),
+	},
+	"GET /api/v1/events/sse": {
+		Summary:   "Stream processed events as server-sent events",
+		Scope:     scopeEventsRead,
+		Response:  EventMessage{},
+		MediaType: "text/event-stream",
+		Params:    append([]paramDoc{header("Last-Event-ID", "Resume after this event")}, streamParams...),
+	},
+	"POST /api/v1/events/backfill": {
+		Summary:  "Submit historical events with their original timestamps",
+		Scope:    scopeEventsWrite,
+		Request:  BatchEventRequest{},
+		Response: countObject("queued", "failed", "rate_limited"),
+		Status:   http.StatusAccepted,
+	},
+	"GET /api/v1/events": {
+		Summary:  "Search processed events in the event store, newest first",
+		Scope:    scopeEventsRead,
+		Response: StoredEventsResponse{},
+		Params: []paramDoc{
+			query("type", "string", "Comma-separated event types"),
+			query("source", "string", "Comma-separated sources"),
+			query("since", "string", "Earliest event timestamp, RFC 3339 or a duration relative to now such as -1h"),
+			query("until", "string", "Event timestamps before this, RFC 3339 or a duration relative to now"),
+			query("limit", "integer", "Maximum events returned, at most 1000"),
+			query("cursor", "string", "next_cursor from the previous page"),
+		},
+	},
+	"GET /api/v1/events/scheduled": {
+		Summary:  "List events held for later delivery",
+		Scope:    scopeEventsRead,
+		Response: ScheduledEventsResponse{},
+	},
+	"DELETE /api/v1/events/scheduled/{id}": {
+		Summary:  "Cancel a scheduled event",
+		Scope:    scopeEventsWrite,
+		Response: statusObject("status", "event_id"),
+	},
+	"GET /api/v1/events/recent": {
+		Summary:  "List recently processed events",
+		Scope:    scopeEventsRead,
+		Response: RecentEventsResponse{},
+		Params:   []paramDoc{query("limit", "integer", "Maximum events returned")},
+	},
+	"POST /api/v1/events/replay": {
+		Summary:  "Enqueue processed events again",
+		Scope:    scopeAdminProcess,
+		Request:  ReplayRequest{},
+		Response: ReplayResponse{},
+		Status:   http.StatusAccepted,
+	},
+	"POST /api/v1/process": {
+		Summary:  "Process one batch of queued events",
+		Scope:    scopeAdminProcess,
+		Response: statusObject("status"),
+	},
+	"POST /api/v1/process/all": {
+		Summary:  "Process every queued event",
+		Scope:    scopeAdminProcess,
+		Response: processedObject(),
+	},
+	"GET /api/v1/status": {
+		Summary:  "Processor status",
+		Scope:    scopeStatusRead,
+		Response: StatusResponse{},
+	},
+	"GET /api/v1/aggregates": {
+		Summary:  "Values of the aggregation windows in progress",
+		Scope:    scopeStatusRead,
+		Response: AggregatesResponse{},
+	},
+	"GET /api/v1/stats": {
+		Summary:  "Processor statistics, with counts by event type and source",
+		Scope:    scopeStatusRead,
+		Response: StatsResponse{},
+		Params: []paramDoc{
+			query("top", "integer", "Only list this many sources, the busiest first"),
+		},
+	},
+	"GET /api/v1/queue/peek": {
+		Summary:  "List events waiting in the queue, next to be processed first",
+		Scope:    scopeAdminControl,
+		Response: QueuedEventsResponse{},
+		Params:   []paramDoc{query("limit", "integer", "Maximum events returned")},
+	},
+	"DELETE /api/v1/queue": {
+		Summary:  "Remove queued events without processing them",
+		Scope:    scopeAdminControl,
+		Response: PurgeResponse{},
+		Params: []paramDoc{
+			query("type", "string", "Comma-separated event types to remove"),
+			query("source", "string", "Comma-separated sources to remove"),
+			query("all", "boolean", "Remove every queued event; required when no type or source is given"),
+		},
+	},
+	"GET /api/v1/deadletters": {
+		Summary:  "List dead-lettered events",
+		Scope:    scopeStatusRead,
+		Response: []DeadLetterResponse{},
+	},
+	"GET /api/v1/health": {
+		Summary:  "Health checks",
+		Response: HealthResponse{},
+	},
+	"GET /api/v1/livez": {
+		Summary:  "Liveness probe",
+		Response: ProbeResponse{},
+		Params:   []paramDoc{verboseParam},
+	},
+	"GET /api/v1/readyz": {
+		Summary:  "Readiness probe",
+		Response: ProbeResponse{},
+		Params:   []paramDoc{verboseParam},
+	},
+	"GET /api/v1/startupz": {
+		Summary:  "Startup probe",
+		Response: ProbeResponse{},
+		Params:   []paramDoc{verboseParam},
+	},
+	"GET /api/v1/version": {
+		Summary:  "Build and library version",
+		Scope:    scopeStatusRead,
+		Response: VersionResponse{},
+	},
+	"GET /api/v1/openapi.json": {
+		Summary: "This OpenAPI document",
+	},
+	"POST /api/v1/admin/diagnostics": {
+		Summary:   "Download a diagnostics bundle",
+		Scope:     scopeAdminDiagnostics,
+		MediaType: "application/gzip",
+		Params:    []paramDoc{query("to", "string", "file to write the bundle on the server and return its path as JSON")},
+	},
+	"GET /api/v1/admin": {
+		Summary:  "Admin settings",
+		Scope:    scopeAdminControl,
+		Response: AdminStatusResponse{},
+	},
+	"POST /api/v1/admin/start": {
+		Summary:  "Resume processing and ingestion",
+		Scope:    scopeAdminControl,
+		Response: AdminStatusResponse{},
+	},
+	"POST /api/v1/admin/pause": {
+		Summary:  "Pause processing; events still queue",
+		Scope:    scopeAdminControl,
+		Response: AdminStatusResponse{},
+	},
+	"POST /api/v1/admin/stop": {
+		Summary:  "Stop processing and ingestion",
+		Scope:    scopeAdminControl,
+		Response: AdminStatusResponse{},
+	},
+	"PUT /api/v1/admin/queue": {
+		Summary:  "Set the queue limit",
+		Scope:    scopeAdminControl,
+		Request:  AdminQueueRequest{},
+		Response: AdminStatusResponse{},
+	},
+	"PUT /api/v1/admin/logging": {
+		Summary:  "Turn processor logging on or off",
+		Scope:    scopeAdminControl,
+		Request:  AdminLoggingRequest{},
+		Response: AdminStatusResponse{},
+	},
+	"PUT /api/v1/admin/intake": {
+		Summary:  "Pause or resume processor intake",
+		Scope:    scopeAdminControl,
+		Request:  AdminIntakeRequest{},
+		Response: AdminStatusResponse{},
+	},
+	"POST /api/v1/admin/snapshot": {
+		Summary:   "Download a processor snapshot",
+		Scope:     scopeAdminControl,
+		MediaType: "application/octet-stream",
+	},
+	"POST /api/v1/admin/restart": {
+		Summary:  "Recreate the processor's queue with new settings, keeping queued events",
+		Scope:    scopeAdminControl,
+		Request:  AdminRestartRequest{},
+		Response: AdminRestartResponse{},
+	},
+	"GET /api/v1/admin/sampling": {
+		Summary:  "Sampling rules with their counts",
+		Scope:    scopeAdminControl,
+		Response: SamplingResponse{},
+	},
+	"PUT /api/v1/admin/sampling": {
+		Summary:  "Replace the sampling rules",
+		Scope:    scopeAdminControl,
+		Request:  SamplingConfig{},
+		Response: SamplingResponse{},
+	},
+	"GET /api/v1/admin/alerts": {
+		Summary:  "Alert rules with the sources they are counting",
+		Scope:    scopeAdminControl,
+		Response: AlertsResponse{},
+	},
+	"PUT /api/v1/admin/alerts/{rule}": {
+		Summary:  "Add or replace an alert rule",
+		Scope:    scopeAdminControl,
+		Request:  AlertRule{},
+		Response: AlertRuleStatus{},
+	},
+	"DELETE /api/v1/admin/alerts/{rule}": {
+		Summary:  "Remove an alert rule",
+		Scope:    scopeAdminControl,
+		Response: statusObject("status", "rule"),
+	},
+	"GET /api/v1/admin/chaos": {
+		Summary:  "Fault injection rates and counts",
+		Scope:    scopeAdminControl,
+		Response: ChaosResponse{},
+	},
+	"PUT /api/v1/admin/chaos": {
+		Summary:  "Set the fault injection rates",
+		Scope:    scopeAdminControl,
+		Request:  ChaosConfig{},
+		Response: ChaosResponse{},
+	},
+	"DELETE /api/v1/admin/chaos": {
+		Summary:  "Stop injecting faults",
+		Scope:    scopeAdminControl,
+		Response: ChaosResponse{},
+	},
+	"PUT /api/v1/admin/processing": {
+		Summary:  "Set the processing mode",
+		Scope:    scopeAdminControl,
+		Request:  AdminProcessingRequest{},
+		Response: AdminStatusResponse{},
+	},
+	"GET /api/v1/admin/tenants": {
+		Summary:  "List tenants",
+		Scope:    scopeAdminTenants,
+		Response: TenantListResponse{},
+	},
+	"POST /api/v1/admin/tenants": {
+		Summary:  "Create a tenant",
+		Scope:    scopeAdminTenants,
+		Request:  TenantConfig{},
+		Response: TenantResponse{},
+		Status:   http.StatusCreated,
+	},
+	"DELETE /api/v1/admin/tenants/{tenant}": {
+		Summary:  "Delete a tenant",
+		Scope:    scopeAdminTenants,
+		Response: statusObject("status", "tenant"),
+	},
+	"GET /api/v1/tenants/{tenant}": {
+		Summary:  "Tenant status",
+		Scope:    "tenant:{tenant}",
+		Response: TenantResponse{},
+	},
+	"POST /api/v1/tenants/{tenant}/events": {
+		Summary:  "Submit an event to a tenant",
+		Scope:    "tenant:{tenant}",
+		Request:  EventRequest{},
+		Response: statusObject("status", "tenant"),
+		Status:   http.StatusAccepted,
+		Params:   eventHeaders,
+	},
+	"POST /api/v1/tenants/{tenant}/process": {
+		Summary:  "Process a tenant's queued events",
+		Scope:    "tenant:{tenant}",
+		Response: processedObject(),
+	},
+	"GET /api/v1/topics": {
+		Summary:  "List topics",
+		Scope:    scopeStatusRead,
+		Response: TopicListResponse{},
+	},
+	"GET /api/v1/topics/{topic}": {
+		Summary:  "Topic status",
+		Scope:    scopeStatusRead,
+		Response: TopicResponse{},
+	},
+	"POST /api/v1/topics/{topic}/events": {
+		Summary:  "Submit an event to a topic",
+		Scope:    scopeEventsWrite,
+		Request:  EventRequest{},
+		Response: statusObject("status", "topic"),
+		Status:   http.StatusAccepted,
+		Params:   eventHeaders,
+	},
+	"GET /api/v1/topics/{topic}/events/sse": {
+		Summary:   "Stream the events processed on a topic as server-sent events",
+		Scope:     scopeEventsRead,
+		Response:  EventMessage{},
+		MediaType: "text/event-stream",
+		Params:    append([]paramDoc{header("Last-Event-ID", "Resume after this event")}, streamParams[:3]...),
+	},
+	"POST /api/v1/topics/{topic}/process": {
+		Summary:  "Process a topic's queued events",
+		Scope:    scopeAdminProcess,
+		Response: processedObject(),
+	},
+	"PUT /api/v1/admin/topics/{topic}": {
+		Summary:  "Create a topic or change its queue limit",
+		Scope:    scopeAdminControl,
+		Request:  TopicRequest{},
+		Response: TopicResponse{},
+	},
+	"DELETE /api/v1/admin/topics/{topic}": {
+		Summary:  "Delete a topic and its queued events",
+		Scope:    scopeAdminControl,
+		Response: statusObject("status", "topic"),
+	},
+	"GET /api/v1/filters": {
+		Summary:  "List the filter rules",
+		Scope:    scopeStatusRead,
+		Response: FiltersResponse{},
+	},
+	"PUT /api/v1/filters": {
+		Summary:  "Replace the filter rules",
+		Scope:    scopeAdminControl,
+		Request:  FilterConfig{},
+		Response: FiltersResponse{},
+	},
+}
+
+// schema is a JSON Schema as used by OpenAPI 3.1
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+	Examples             []any              `json:"examples,omitempty"`
+}
+
+// statusObject is the schema of a response of string fields
+func statusObject(names ...string) *schema {
+	return objectOf("string", names...)
+}
+
+// countObject is the schema of a response of counts
+func countObject(names ...string) *schema {
+	return objectOf("integer", names...)
+}
+
+// processedObject is the schema of a process-all response
+func processedObject() *schema {
+	s := statusObject("status", "duration")
+	s.Properties["processed"] = &schema{Type: "integer"}
+	return s
+}
+
+func objectOf(typ string, names ...string) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	for _, name := range names {
+		s.Properties[name] = &schema{Type: typ}
+	}
+	return s
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	eventTypeType  = reflect.TypeOf(eventlib.EventType(0))
+	durationType   = reflect.TypeOf(duration(0))
+)
+
+const errorSchemaName = "ErrorResponse"
+
+// schemaGenerator builds schemas from Go types by their JSON encoding,
+// collecting named structs as components
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *schema {
+	switch t {
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &schema{Description: "Any JSON value"}
+	case eventTypeType:
+		return &schema{
+			Description: "A registered type name or its number",
+			OneOf:       []*schema{{Type: "string"}, {Type: "integer"}},
+			Examples:    []any{"DATA"},
+		}
+	case durationType:
+		return &schema{Type: "string", Format: "duration", Examples: []any{"2s"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem())
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.components[t.Name()]; !ok {
+			// Reserved first, so recursive types end
+			g.components[t.Name()] = nil
+			g.components[t.Name()] = g.structSchema(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &schema{}
+	}
+}
+
+// structSchema follows encoding/json: unexported and "-" fields are
+// skipped, and untagged embedded structs contribute their fields.
+// Fields without omitempty are required.
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := g.structSchema(field.Type)
+			for k, v := range embedded.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// bodySchema returns the schema of a routeDoc request or response
+func (g *schemaGenerator) bodySchema(v any) *schema {
+	if s, ok := v.(*schema); ok {
+		return s
+	}
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components openAPIComponents                `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema,omitempty"`
+}
+
+var pathVarPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// buildOpenAPI documents the routes registered on api. Routes without a
+// routeDocs entry, and entries without a route, are logged so the table
+// can be brought up to date.
+func buildOpenAPI(api *mux.Router, logger *zap.Logger) ([]byte, error) {
+	g := &schemaGenerator{components: make(map[string]*schema)}
+	doc := openAPIDocument{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:   serviceName,
+			Version: version,
+			Description: "Errors are returned as an ErrorResponse. When authentication is " +
+				"enabled, each operation lists the scope it requires.",
+		},
+		Paths: make(map[string]map[string]*operation),
+		Components: openAPIComponents{
+			Schemas: g.components,
+			SecuritySchemes: map[string]securityScheme{
+				"bearer": {Type: "http", Scheme: "bearer"},
+				"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+			},
+		},
+	}
+	g.components[errorSchemaName] = statusObject("error")
+	errorResponse := response{
+		Description: "Error",
+		Content:     map[string]mediaType{"application/json": {Schema: &schema{Ref: "#/components/schemas/" + errorSchemaName}}},
+	}
+
+	documented := make(map[string]bool)
+	err := api.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // Not an endpoint, such as the /api/v1 prefix
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := pathVarPattern.ReplaceAllString(tmpl, "{$1}")
+
+		for _, method := range methods {
+			key := method + " " + path
+			rd, ok := routeDocs[key]
+			if !ok {
+				logger.Warn("API route missing from the OpenAPI document", zap.String("route", key))
+			}
+			documented[key] = true
+
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = make(map[string]*operation)
+			}
+			op := rd.operation(g, path)
+			op.Responses["default"] = errorResponse
+			doc.Paths[path][strings.ToLower(method)] = op
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk routes: %w", err)
+	}
+
+	for key := range routeDocs {
+		if !documented[key] {
+			logger.Warn("OpenAPI document describes a route that is not registered", zap.String("route", key))
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// operation builds the OpenAPI operation for a route at path
+func (rd routeDoc) operation(g *schemaGenerator, path string) *operation {
+	op := &operation{
+		Summary:   rd.Summary,
+		Tags:      []string{routeTag(path)},
+		Responses: make(map[string]response),
+		Security:  []map[string][]string{},
+	}
+	if rd.Scope != "" {
+		op.Description = "Requires scope " + rd.Scope + "."
+		op.Security = []map[string][]string{
+			{"bearer": {rd.Scope}},
+			{"apiKey": {rd.Scope}},
+		}
+	}
+
+	for _, match := range pathVarPattern.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, parameter{
+			Name: match[1], In: "path", Required: true, Schema: &schema{Type: "string"},
+		})
+	}
+	for _, p := range rd.Params {
+		op.Parameters = append(op.Parameters, parameter{
+			Name: p.Name, In: p.In, Description: p.Description, Schema: &schema{Type: p.Type},
+		})
+	}
+
+	if rd.Request != nil {
+		mt := rd.RequestMediaType
+		if mt == "" {
+			mt = contentTypeJSON
+		}
+		op.RequestBody = &requestBody{
+			Required: true,
+			Content:  map[string]mediaType{mt: {Schema: g.bodySchema(rd.Request)}},
+		}
+	}
+
+	status := rd.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := response{Description: http.StatusText(status)}
+	switch {
+	case rd.Response != nil:
+		mt := rd.MediaType
+		if mt == "" {
+			mt = contentTypeJSON
+		}
+		resp.Content = map[string]mediaType{mt: {Schema: g.bodySchema(rd.Response)}}
+	case rd.MediaType != "":
+		resp.Content = map[string]mediaType{rd.MediaType: {Schema: &schema{Type: "string", Format: "binary"}}}
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	return op
+}
+
+// routeTag groups operations by the first path segment after /api/v1
+func routeTag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/v1/"), "/")
+	switch segment {
+	case "livez", "readyz", "startupz":
+		return "health"
+	case "admin":
+		if strings.HasPrefix(path, "/api/v1/admin/tenants") {
+			return "tenants"
+		}
+		if strings.HasPrefix(path, "/api/v1/admin/topics") {
+			return "topics"
+		}
+	}
+	return segment
+}
+
+// handleOpenAPI serves the document built from the registered routes
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(s.openAPI)
+}
+
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// docsHandler serves Swagger UI for /api/v1/openapi.json, loading its
+// scripts and styles from assets
+func docsHandler(assets string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsTemplate.Execute(w, struct{ Assets string }{strings.TrimSuffix(assets, "/")})
+	}
+}
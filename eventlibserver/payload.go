@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+const contentTypeJSON = "application/json"
+
+// isJSONContentType reports whether a MIME type carries JSON, including
+// structured suffixes such as application/cloudevents+json
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// mediaTypeOf returns the media type of a request body, without
+// parameters
+func mediaTypeOf(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// validate checks the payload fields of an event request
+func (req EventRequest) validate() error {
+	if req.DeliverAt != nil && req.DelayMs != 0 {
+		return errors.New("deliver_at and delay_ms are mutually exclusive")
+	}
+	if req.DelayMs < 0 {
+		return fmt.Errorf("delay_ms must not be negative, got %d", req.DelayMs)
+	}
+	if len(req.DataJSON) == 0 {
+		return nil
+	}
+	if len(req.Data) > 0 {
+		return errors.New("data and data_json are mutually exclusive")
+	}
+	if req.ContentType != "" && !isJSONContentType(req.ContentType) {
+		return fmt.Errorf("data_json cannot have content_type %q", req.ContentType)
+	}
+	return nil
+}
+
+// payload returns the event data and its content type
+func (req EventRequest) payload() ([]byte, string) {
+	if len(req.DataJSON) == 0 || bytes.Equal(req.DataJSON, []byte("null")) {
+		return req.Data, req.ContentType
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, req.DataJSON); err != nil {
+		// Already validated by the decoder; keep it verbatim
+		compact.Reset()
+		compact.Write(req.DataJSON)
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+	return compact.Bytes(), contentType
+}
+
+// readEventRequest negotiates the request body. A JSON body is an
+// EventRequest, a protobuf body an eventlib.v1.Event, and a CloudEvent is
+// recognised in either HTTP binding; any other Content-Type is taken as
+// the raw payload, with type, source and priority in the query string. A
+// missing or form Content-Type (curl's default for -d) is still read as
+// JSON, as it always has been.
+func readEventRequest(r *http.Request) (EventRequest, error) {
+	if isCloudEventRequest(r) {
+		return readCloudEvent(r)
+	}
+	if isProtobufRequest(r) {
+		return readProtoEvent(r)
+	}
+
+	var req EventRequest
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if contentType == "" || mediaType == "application/x-www-form-urlencoded" || isJSONContentType(contentType) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, err
+		}
+		return req, req.validate()
+	}
+
+	query := r.URL.Query()
+	if v := query.Get("type"); v != "" {
+		t, err := eventlib.ParseEventType(v)
+		if err != nil {
+			return req, err
+		}
+		req.Type = t
+	}
+	if v := query.Get("priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return req, fmt.Errorf("invalid priority %q", v)
+		}
+		req.Priority = &p
+	}
+	req.Source = query.Get("source")
+	req.ContentType = contentType
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+	req.Data = data
+	return req, nil
+}
+
+// readBatchRequest reads a batch as JSON, as an eventlib.v1.EventBatch
+// with a protobuf Content-Type, or as a CloudEvents JSON batch
+func readBatchRequest(r *http.Request) (BatchEventRequest, error) {
+	if mediaTypeOf(r) == contentTypeCloudEventBatch {
+		return readCloudEventBatch(r)
+	}
+	if isProtobufRequest(r) {
+		return readProtoBatch(r)
+	}
+
+	var req BatchEventRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// MarshalJSON renders JSON payloads inline as data_json rather than as
+// base64 data. Other encodings, such as CBOR, keep the raw bytes.
+func (m EventMessage) MarshalJSON() ([]byte, error) {
+	type plain EventMessage
+
+	if !isJSONContentType(m.ContentType) || !json.Valid(m.Data) {
+		return json.Marshal(plain(m))
+	}
+
+	inline := struct {
+		plain
+		DataJSON json.RawMessage `json:"data_json"`
+	}{plain: plain(m), DataJSON: m.Data}
+	inline.Data = nil
+	return json.Marshal(inline)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.uber.org/zap"
+)
+
+const (
+	// pluginDefaultFunction is the handler a plugin exports unless its
+	// config names another
+	pluginDefaultFunction = "OnEvent"
+
+	// pluginAllocFunction is exported by WASM modules to reserve memory
+	// for the event passed to the handler
+	pluginAllocFunction = "alloc"
+
+	pluginDefaultTimeout   = time.Second
+	pluginDefaultMaxMemory = 16 // MiB
+
+	// wasmPageSize is the size of a WebAssembly memory page
+	wasmPageSize = 64 << 10
+)
+
+var (
+	pluginCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_plugin_calls_total",
+		Help: "Total number of plugin handler calls by result: ok, error or timeout",
+	}, []string{"plugin", "result"})
+
+	pluginDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eventlibgo_plugin_duration_seconds",
+		Help:    "Time taken by each plugin handler call",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"plugin"})
+)
+
+func init() {
+	registerFeature("plugins")
+}
+
+// PluginConfig is the JSON file given with -plugin-config
+type PluginConfig struct {
+	Handlers []PluginHandler `json:"handlers"`
+}
+
+// PluginHandler is one plugin and the event types it handles
+type PluginHandler struct {
+	// Name labels the plugin's metrics and logs; defaults to the file name
+	Name string `json:"name"`
+
+	// Path is a Go plugin built with -buildmode=plugin (.so) or a
+	// WebAssembly module (.wasm)
+	Path string `json:"path"`
+
+	// Types are the event types routed to the plugin
+	Types []eventlib.EventType `json:"types"`
+
+	// Function is the handler the plugin exports; default OnEvent
+	Function string `json:"function,omitempty"`
+
+	// Timeout bounds each call into a WASM module; default 1s
+	Timeout duration `json:"timeout,omitempty"`
+
+	// MaxMemory bounds a WASM module's memory in MiB; default 16
+	MaxMemory int `json:"max_memory_mb,omitempty"`
+}
+
+// wasm reports whether h is a WebAssembly module rather than a Go plugin
+func (h PluginHandler) wasm() bool {
+	return strings.EqualFold(filepath.Ext(h.Path), ".wasm")
+}
+
+// loadPluginConfig reads and checks a plugin file
+func loadPluginConfig(file string) (PluginConfig, error) {
+	var config PluginConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Handlers {
+		h := &config.Handlers[i]
+		if h.Path == "" {
+			return config, fmt.Errorf("%s: handler %d has no path", file, i)
+		}
+		if h.Name == "" {
+			h.Name = strings.TrimSuffix(filepath.Base(h.Path), filepath.Ext(h.Path))
+		}
+		if names[h.Name] {
+			return config, fmt.Errorf("%s: duplicate handler %q", file, h.Name)
+		}
+		names[h.Name] = true
+		if ext := strings.ToLower(filepath.Ext(h.Path)); ext != ".so" && ext != ".wasm" {
+			return config, fmt.Errorf("%s: handler %q: path must end in .so or .wasm", file, h.Name)
+		}
+		if len(h.Types) == 0 {
+			return config, fmt.Errorf("%s: handler %q has no types", file, h.Name)
+		}
+		if h.Timeout < 0 || h.MaxMemory < 0 {
+			return config, fmt.Errorf("%s: handler %q: negative setting", file, h.Name)
+		}
+		if h.Function == "" {
+			h.Function = pluginDefaultFunction
+		}
+		if h.Timeout == 0 {
+			h.Timeout = duration(pluginDefaultTimeout)
+		}
+		if h.MaxMemory == 0 {
+			h.MaxMemory = pluginDefaultMaxMemory
+		}
+	}
+	return config, nil
+}
+
+// pluginHandlers holds the loaded plugins until the server closes
+type pluginHandlers struct {
+	logger   *zap.Logger
+	runtimes []wazero.Runtime
+}
+
+// loadPlugins loads every plugin in config and routes its event types to
+// it on processor
+func loadPlugins(config PluginConfig, processor eventlib.Routable, logger *zap.Logger) (*pluginHandlers, error) {
+	p := &pluginHandlers{logger: logger}
+	for _, h := range config.Handlers {
+		var handler eventlib.EventHandler
+		var err error
+		if h.wasm() {
+			handler, err = p.loadWASM(h)
+		} else {
+			handler, err = loadGoPlugin(h)
+		}
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("plugin %q: %w", h.Name, err)
+		}
+
+		handler = instrumentPlugin(h.Name, handler)
+		for _, et := range h.Types {
+			processor.Handle(et, handler)
+		}
+		logger.Info("Plugin loaded",
+			zap.String("plugin", h.Name),
+			zap.String("path", h.Path),
+			zap.Stringers("types", h.Types))
+	}
+	return p, nil
+}
+
+// close frees the WASM runtimes
+func (p *pluginHandlers) close() {
+	for _, r := range p.runtimes {
+		if err := r.Close(context.Background()); err != nil {
+			p.logger.Warn("Failed to close WASM runtime", zap.Error(err))
+		}
+	}
+	p.runtimes = nil
+}
+
+// loadGoPlugin opens a Go plugin and looks up its handler, which must be a
+// func(eventlib.Event) error. Go plugins run in the server's process, with
+// no isolation or timeout, and must be built with the same Go toolchain
+// and eventlibgo version as the server.
+func loadGoPlugin(h PluginHandler) (eventlib.EventHandler, error) {
+	p, err := plugin.Open(h.Path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(h.Function)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fn := sym.(type) {
+	case func(eventlib.Event) error:
+		return fn, nil
+	case *func(eventlib.Event) error:
+		return *fn, nil
+	case *eventlib.EventHandler:
+		return *fn, nil
+	default:
+		return nil, fmt.Errorf("%s is a %T, not a func(eventlib.Event) error", h.Function, sym)
+	}
+}
+
+// loadWASM compiles a WebAssembly module into its own runtime. Each event
+// gets a fresh instance of the module, so no state leaks between events,
+// with WASI but no filesystem, network or clock beyond what WASI gives.
+//
+// The module exports memory, alloc(size i32) i32, returning where to
+// write size bytes, and the handler, taking (ptr i32, len i32) and
+// returning 0 for success. The handler is given the event as the JSON
+// that streams carry.
+func (p *pluginHandlers) loadWASM(h PluginHandler) (eventlib.EventHandler, error) {
+	binary, err := os.ReadFile(h.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(h.MaxMemory<<20/wasmPageSize)))
+	p.runtimes = append(p.runtimes, r)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return nil, fmt.Errorf("failed to set up WASI: %w", err)
+	}
+	compiled, err := r.CompileModule(ctx, binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", h.Path, err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, name := range []string{pluginAllocFunction, h.Function} {
+		if _, ok := exports[name]; !ok {
+			return nil, fmt.Errorf("%s does not export %s", h.Path, name)
+		}
+	}
+
+	// Reactor modules initialize with _initialize; _start would run a
+	// command module's main and exit
+	moduleConfig := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	timeout := time.Duration(h.Timeout)
+
+	return func(event eventlib.Event) error {
+		payload, err := json.Marshal(newEventMessage(event))
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		mod, err := r.InstantiateModule(ctx, compiled, moduleConfig)
+		if err != nil {
+			return wasmError(ctx, "instantiate", err)
+		}
+		defer mod.Close(context.Background())
+
+		ptr, err := callWASM(ctx, mod, pluginAllocFunction, uint64(len(payload)))
+		if err != nil {
+			return err
+		}
+		if !mod.Memory().Write(uint32(ptr), payload) {
+			return fmt.Errorf("alloc returned %d, outside the module's memory", ptr)
+		}
+		status, err := callWASM(ctx, mod, h.Function, ptr, uint64(len(payload)))
+		if err != nil {
+			return err
+		}
+		if int32(status) != 0 {
+			return fmt.Errorf("%s returned %d", h.Function, int32(status))
+		}
+		return nil
+	}, nil
+}
+
+// callWASM calls an exported function that returns one value
+func callWASM(ctx context.Context, mod api.Module, name string, params ...uint64) (uint64, error) {
+	results, err := mod.ExportedFunction(name).Call(ctx, params...)
+	if err != nil {
+		return 0, wasmError(ctx, name, err)
+	}
+	if len(results) != 1 {
+		return 0, fmt.Errorf("%s returned %d values, want 1", name, len(results))
+	}
+	return results[0], nil
+}
+
+// wasmError reports a failed call, as a timeout if ctx ran out
+func wasmError(ctx context.Context, op string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s: %w", op, ctxErr)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// instrumentPlugin counts and times a plugin's calls
+func instrumentPlugin(name string, handler eventlib.EventHandler) eventlib.EventHandler {
+	return func(event eventlib.Event) error {
+		start := time.Now()
+		err := handler(event)
+		pluginDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+		result := "ok"
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			result = "timeout"
+		case err != nil:
+			result = "error"
+		}
+		pluginCalls.WithLabelValues(name, result).Inc()
+		return err
+	}
+}
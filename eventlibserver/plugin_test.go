@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// testWASM is a module with one page of memory and four exports:
+// alloc returns 1024 whatever the size, OnEvent returns 0, Fail returns 7
+// and Spin loops forever
+var testWASM = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+
+	// types: (i32) -> i32 and (i32, i32) -> i32
+	0x01, 0x0c, 0x02,
+	0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+
+	// functions: alloc, OnEvent, Fail, Spin
+	0x03, 0x05, 0x04, 0x00, 0x01, 0x01, 0x01,
+
+	// memory: one page
+	0x05, 0x03, 0x01, 0x00, 0x01,
+
+	// exports
+	0x07, 0x2a, 0x05,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x07, 'O', 'n', 'E', 'v', 'e', 'n', 't', 0x00, 0x01,
+	0x04, 'F', 'a', 'i', 'l', 0x00, 0x02,
+	0x04, 'S', 'p', 'i', 'n', 0x00, 0x03,
+
+	// code
+	0x0a, 0x1b, 0x04,
+	0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, // i32.const 1024
+	0x04, 0x00, 0x41, 0x00, 0x0b, // i32.const 0
+	0x04, 0x00, 0x41, 0x07, 0x0b, // i32.const 7
+	0x09, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x41, 0x00, 0x0b, // loop br 0 end
+}
+
+// writeFile writes data to name in a temporary directory and returns its
+// path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// routes records the handlers plugins are routed to
+type routes map[eventlib.EventType]eventlib.EventHandler
+
+func (r routes) Handle(et eventlib.EventType, handler eventlib.EventHandler) { r[et] = handler }
+func (r routes) HandleSource(string, eventlib.EventHandler)                  {}
+func (r routes) Use(...eventlib.Middleware)                                  {}
+
+func TestLoadPluginConfig(t *testing.T) {
+	file := writeFile(t, "plugins.json", []byte(`{"handlers": [
+		{"path": "/plugins/audit.wasm", "types": ["DATA"]},
+		{"name": "alerts", "path": "/plugins/alerts.so", "types": ["CONNECT", "DISCONNECT"],
+		 "function": "Alert", "timeout": "50ms", "max_memory_mb": 4}
+	]}`))
+
+	config, err := loadPluginConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Handlers) != 2 {
+		t.Fatalf("loaded %d handlers, want 2", len(config.Handlers))
+	}
+	audit, alerts := config.Handlers[0], config.Handlers[1]
+	if audit.Name != "audit" || audit.Function != pluginDefaultFunction ||
+		time.Duration(audit.Timeout) != pluginDefaultTimeout || audit.MaxMemory != pluginDefaultMaxMemory {
+		t.Errorf("defaults not applied: %+v", audit)
+	}
+	if !audit.wasm() || alerts.wasm() {
+		t.Error("wasm() does not follow the file extension")
+	}
+	if alerts.Function != "Alert" || time.Duration(alerts.Timeout) != 50*time.Millisecond || alerts.MaxMemory != 4 {
+		t.Errorf("settings not kept: %+v", alerts)
+	}
+}
+
+func TestLoadPluginConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"malformed", `{"handlers": [`, "failed to parse"},
+		{"no path", `{"handlers": [{"types": ["DATA"]}]}`, "has no path"},
+		{"duplicate", `{"handlers": [
+			{"path": "a/p.wasm", "types": ["DATA"]},
+			{"path": "b/p.so", "types": ["DATA"]}]}`, "duplicate handler"},
+		{"extension", `{"handlers": [{"path": "p.dll", "types": ["DATA"]}]}`, "must end in .so or .wasm"},
+		{"no types", `{"handlers": [{"path": "p.wasm"}]}`, "has no types"},
+		{"negative", `{"handlers": [{"path": "p.wasm", "types": ["DATA"], "max_memory_mb": -1}]}`, "negative setting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPluginConfig(writeFile(t, "plugins.json", []byte(tt.config)))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadPluginConfig(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file gave %v", err)
+	}
+}
+
+func TestLoadPluginsWASM(t *testing.T) {
+	path := writeFile(t, "test.wasm", testWASM)
+	plugin := func(name, function string, et eventlib.EventType) PluginHandler {
+		return PluginHandler{
+			Name:      name,
+			Path:      path,
+			Types:     []eventlib.EventType{et},
+			Function:  function,
+			Timeout:   duration(100 * time.Millisecond),
+			MaxMemory: 1,
+		}
+	}
+	config := PluginConfig{Handlers: []PluginHandler{
+		plugin("ok", "OnEvent", eventlib.EventTypeData),
+		plugin("fail", "Fail", eventlib.EventTypeConnect),
+		plugin("spin", "Spin", eventlib.EventTypeDisconnect),
+	}}
+
+	r := routes{}
+	p, err := loadPlugins(config, r, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	if len(r) != 3 {
+		t.Fatalf("routed %d event types, want 3", len(r))
+	}
+
+	event := eventlib.Event{Source: "s", Data: []byte("hello")}
+	if err := r[eventlib.EventTypeData](event); err != nil {
+		t.Errorf("OnEvent: %v", err)
+	}
+	if err := r[eventlib.EventTypeConnect](event); err == nil || !strings.Contains(err.Error(), "returned 7") {
+		t.Errorf("Fail gave %v, want its status", err)
+	}
+	if err := r[eventlib.EventTypeDisconnect](event); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Spin gave %v, want a timeout", err)
+	}
+}
+
+func TestLoadPluginsInvalid(t *testing.T) {
+	path := writeFile(t, "test.wasm", testWASM)
+
+	tests := []struct {
+		name    string
+		handler PluginHandler
+		want    string
+	}{
+		{"missing export", PluginHandler{Name: "p", Path: path, Function: "Missing"}, "does not export Missing"},
+		{"not wasm", PluginHandler{Name: "p", Path: writeFile(t, "bad.wasm", []byte("not wasm")), Function: "OnEvent"}, "failed to compile"},
+		{"missing Go plugin", PluginHandler{Name: "p", Path: filepath.Join(t.TempDir(), "p.so"), Function: "OnEvent"}, `plugin "p"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.handler.Types = []eventlib.EventType{eventlib.EventTypeData}
+			tt.handler.MaxMemory = 1
+			r := routes{}
+			_, err := loadPlugins(PluginConfig{Handlers: []PluginHandler{tt.handler}}, r, zap.NewNop())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.want)
+			}
+			if len(r) != 0 {
+				t.Fatalf("routed %d event types for a plugin that failed", len(r))
+			}
+		})
+	}
+}
+
+func TestServerPlugins(t *testing.T) {
+	path := writeFile(t, "test.wasm", testWASM)
+	s := newTestServer(t, Options{Plugins: &PluginConfig{Handlers: []PluginHandler{{
+		Name: "fail", Path: path, Types: []eventlib.EventType{eventlib.EventTypeData},
+		Function: "Fail", Timeout: duration(time.Second), MaxMemory: 1,
+	}}}})
+	if s.plugins == nil {
+		t.Fatal("plugins not loaded")
+	}
+
+	// A bad plugin stops the server from starting
+	_, err := NewServer(Options{Name: "bad", Plugins: &PluginConfig{Handlers: []PluginHandler{{
+		Name: "p", Path: path, Types: []eventlib.EventType{eventlib.EventTypeData},
+		Function: "Missing", MaxMemory: 1,
+	}}}}, zap.NewNop())
+	if err == nil {
+		t.Fatal("NewServer loaded a plugin without its handler")
+	}
+}
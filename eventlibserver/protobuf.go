@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// contentTypeProtobuf selects the protobuf wire format defined in
+// proto/eventlib.proto
+const contentTypeProtobuf = "application/x-protobuf"
+
+// isProtobufRequest reports whether a request body is protobuf
+func isProtobufRequest(r *http.Request) bool {
+	mediaType := mediaTypeOf(r)
+	return mediaType == contentTypeProtobuf || mediaType == "application/protobuf"
+}
+
+// readProtoEvent decodes an eventlib.v1.Event request body
+func readProtoEvent(r *http.Request) (EventRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return EventRequest{}, err
+	}
+	return unmarshalProtoEvent(body)
+}
+
+// readProtoBatch decodes an eventlib.v1.EventBatch request body
+func readProtoBatch(r *http.Request) (BatchEventRequest, error) {
+	var req BatchEventRequest
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return req, err
+	}
+
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return req, protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var msg []byte
+			msg, n = protowire.ConsumeBytes(body)
+			if n < 0 {
+				break
+			}
+			e, err := unmarshalProtoEvent(msg)
+			if err != nil {
+				return req, fmt.Errorf("event %d: %w", len(req.Events), err)
+			}
+			req.Events = append(req.Events, e)
+		case num == 2 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(body)
+			p := int(int32(v))
+			req.Priority = &p
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, body)
+		}
+		if n < 0 {
+			return req, protowire.ParseError(n)
+		}
+		body = body[n:]
+	}
+	return req, nil
+}
+
+// unmarshalProtoEvent decodes an eventlib.v1.Event. Data aliases b rather
+// than being copied. Unknown fields are skipped, so newer clients can talk
+// to older servers.
+func unmarshalProtoEvent(b []byte) (EventRequest, error) {
+	var req EventRequest
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return req, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType && (num == 1 || num == 6 || num == 7 || num == 8):
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			switch num {
+			case 1:
+				req.Type = eventlib.EventType(int32(v))
+			case 6:
+				if v != 0 {
+					t := time.UnixMilli(int64(v))
+					req.Timestamp = &t
+				}
+			case 7:
+				if v != 0 {
+					t := time.UnixMilli(int64(v))
+					req.Deadline = &t
+				}
+			case 8:
+				p := int(int32(v))
+				req.Priority = &p
+			}
+		case typ == protowire.BytesType && num >= 2 && num <= 5:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			switch num {
+			case 2:
+				req.Source = string(v)
+			case 3:
+				req.Data = v
+			case 4:
+				req.ContentType = string(v)
+			case 5:
+				req.ID = string(v)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return req, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return req, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// marshalProtoEvent encodes req as an eventlib.v1.Event, the inverse of
+// unmarshalProtoEvent
+func marshalProtoEvent(req EventRequest) []byte {
+	var b []byte
+	if req.Type != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int32(req.Type)))
+	}
+	for _, f := range []struct {
+		num protowire.Number
+		v   []byte
+	}{
+		{2, []byte(req.Source)},
+		{3, req.Data},
+		{4, []byte(req.ContentType)},
+		{5, []byte(req.ID)},
+	} {
+		if len(f.v) > 0 {
+			b = protowire.AppendTag(b, f.num, protowire.BytesType)
+			b = protowire.AppendBytes(b, f.v)
+		}
+	}
+	if req.Timestamp != nil {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(req.Timestamp.UnixMilli()))
+	}
+	if req.Deadline != nil {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(req.Deadline.UnixMilli()))
+	}
+	if req.Priority != nil {
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int32(*req.Priority)))
+	}
+	return b
+}
+
+// marshalProtoBatch encodes events as an eventlib.v1.EventBatch
+func marshalProtoBatch(events ...EventRequest) []byte {
+	var b []byte
+	for _, e := range events {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalProtoEvent(e))
+	}
+	return b
+}
+
+func newProtoRequest(body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/events", bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentTypeProtobuf)
+	return r
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	timestamp := time.UnixMilli(1700000000123)
+	deadline := timestamp.Add(time.Minute)
+	priority := -3
+	want := EventRequest{
+		Type:        eventlib.EventTypeData,
+		Source:      "sensor-1",
+		Data:        []byte{0x00, 0xff, 0x10},
+		ContentType: "application/octet-stream",
+		ID:          "id-1",
+		Timestamp:   &timestamp,
+		Deadline:    &deadline,
+		Priority:    &priority,
+	}
+
+	got, err := readEventRequest(newProtoRequest(marshalProtoEvent(want)))
+	if err != nil {
+		t.Fatalf("readEventRequest: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+
+	batch, err := readBatchRequest(newProtoRequest(marshalProtoBatch(want, EventRequest{Source: "b"})))
+	if err != nil {
+		t.Fatalf("readBatchRequest: %v", err)
+	}
+	if len(batch.Events) != 2 || !reflect.DeepEqual(batch.Events[0], want) || batch.Events[1].Source != "b" {
+		t.Fatalf("decoded batch %+v", batch.Events)
+	}
+}
+
+func TestProtobufUnknownFields(t *testing.T) {
+	body := marshalProtoEvent(EventRequest{Source: "sensor-1"})
+	body = protowire.AppendTag(body, 99, protowire.BytesType)
+	body = protowire.AppendBytes(body, []byte("from a newer client"))
+
+	got, err := readEventRequest(newProtoRequest(body))
+	if err != nil || got.Source != "sensor-1" {
+		t.Fatalf("readEventRequest = %+v, %v", got, err)
+	}
+}
+
+func TestProtobufTruncated(t *testing.T) {
+	body := marshalProtoEvent(EventRequest{Source: "sensor-1", Data: []byte("payload")})
+
+	if _, err := readEventRequest(newProtoRequest(body[:len(body)-2])); err == nil {
+		t.Fatal("readEventRequest accepted a truncated body")
+	}
+}
+
+// benchPayload is a binary payload of the size the protobuf format is
+// meant for
+var benchPayload = bytes.Repeat([]byte{0xab, 0x00, 0x7f, 0xff}, 1024)
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	body, err := json.Marshal(EventRequest{Type: eventlib.EventTypeData, Source: "sensor-1", Data: benchPayload})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(benchPayload)))
+	b.ReportAllocs()
+
+	for range b.N {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/events", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentTypeJSON)
+		if _, err := readEventRequest(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeProtobuf(b *testing.B) {
+	body := marshalProtoEvent(EventRequest{Type: eventlib.EventTypeData, Source: "sensor-1", Data: benchPayload})
+	b.SetBytes(int64(len(benchPayload)))
+	b.ReportAllocs()
+
+	for range b.N {
+		if _, err := readEventRequest(newProtoRequest(body)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
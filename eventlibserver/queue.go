@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// defaultPeekLimit is how many queued events /queue/peek returns unless
+// ?limit= says otherwise
+const defaultPeekLimit = 100
+
+func init() {
+	registerFeature("queue:inspect")
+}
+
+func (s *Server) handlePeekQueue(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := s.processor.(eventlib.QueueInspector)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Queue inspection not supported by this backend")
+		return
+	}
+
+	limit := defaultPeekLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			s.writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	events, err := inspector.Peek(limit)
+	if err != nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Failed to read queue: "+err.Error())
+		return
+	}
+
+	resp := QueuedEventsResponse{
+		Events: make([]EventMessage, len(events)),
+		Count:  len(events),
+	}
+	for i, event := range events {
+		resp.Events[i] = newEventMessage(event)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePurgeQueue removes the queued events matching ?type= and ?source=.
+// Emptying the whole queue takes an explicit ?all=true, so a request that
+// forgot its filter cannot do it by accident.
+func (s *Server) handlePurgeQueue(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := s.processor.(eventlib.QueueInspector)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Queue inspection not supported by this backend")
+		return
+	}
+
+	filter := parseStreamFilter(r)
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	if filter.types == nil && filter.sources == nil && !all {
+		s.writeError(w, http.StatusBadRequest, "Give a type or source to purge, or all=true to purge every queued event")
+		return
+	}
+
+	purged, err := inspector.Purge(func(event eventlib.Event) bool {
+		return filter.match(EventMessage{Type: event.Type.String(), Source: event.Source})
+	})
+	if err != nil {
+		s.writeError(w, http.StatusServiceUnavailable, "Failed to purge queue: "+err.Error())
+		return
+	}
+
+	s.logger.Info("Queue purged",
+		zap.Int("purged", purged),
+		zap.String("type", r.URL.Query().Get("type")),
+		zap.String("source", r.URL.Query().Get("source")))
+	s.writeJSON(w, http.StatusOK, PurgeResponse{Purged: purged})
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+// idleLimiterTTL is how long an unused per-key bucket is kept
+const idleLimiterTTL = 10 * time.Minute
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_rate_limited_total",
+	Help: "Total number of events or requests rejected by rate limiting",
+}, []string{"scope"})
+
+// RateLimit is a token bucket: Rate tokens per second, up to Burst at once.
+// A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// RateLimitConfig configures per-source and per-client-IP limits
+type RateLimitConfig struct {
+	// PerSource limits events by their source field
+	PerSource RateLimit `json:"per_source"`
+
+	// Sources overrides PerSource for specific sources
+	Sources map[string]RateLimit `json:"sources,omitempty"`
+
+	// PerIP limits API requests by client address
+	PerIP RateLimit `json:"per_ip"`
+}
+
+// loadRateLimitConfig reads a JSON rate limit file over the given defaults
+func loadRateLimitConfig(path string, defaults RateLimitConfig) (RateLimitConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return defaults, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	config := defaults
+	if err := json.Unmarshal(data, &config); err != nil {
+		return defaults, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// keyedLimiter keeps one token bucket per key, forgetting idle ones
+type keyedLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter() *keyedLimiter {
+	return &keyedLimiter{limiters: make(map[string]*limiterEntry)}
+}
+
+// allow takes a token for key, or reports how long until one is available
+func (k *keyedLimiter) allow(key string, limit RateLimit) (bool, time.Duration) {
+	now := time.Now()
+
+	// Held throughout, so allowAll sees no tokens taken between its check
+	// and its take
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	reservation := k.entry(key, limit, now).limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// allowAll takes counts[key] tokens for every key, or none at all. If
+// some keys are short it takes nothing, and returns them and how long
+// until they all have enough.
+func (k *keyedLimiter) allowAll(counts map[string]int, limits map[string]RateLimit) ([]string, time.Duration) {
+	now := time.Now()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var short []string
+	var retryAfter time.Duration
+	for key, n := range counts {
+		limiter := k.entry(key, limits[key], now).limiter
+		missing := float64(n) - limiter.TokensAt(now)
+		if missing <= 0 {
+			continue
+		}
+		short = append(short, key)
+		if n > limiter.Burst() {
+			// Never enough at once, as for allow
+			retryAfter = max(retryAfter, time.Second)
+			continue
+		}
+		retryAfter = max(retryAfter, time.Duration(missing/float64(limiter.Limit())*float64(time.Second)))
+	}
+	if len(short) > 0 {
+		return short, retryAfter
+	}
+
+	for key, n := range counts {
+		k.limiters[key].limiter.AllowN(now, n)
+	}
+	return nil, 0
+}
+
+// entry returns key's bucket, creating it with limit if needed. k.mu must
+// be held.
+func (k *keyedLimiter) entry(key string, limit RateLimit, now time.Time) *limiterEntry {
+	entry, ok := k.limiters[key]
+	if !ok {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = int(math.Ceil(limit.Rate))
+		}
+		entry = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(limit.Rate), burst)}
+		k.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry
+}
+
+// reset forgets every bucket, so new limits apply from a full burst
+func (k *keyedLimiter) reset() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clear(k.limiters)
+}
+
+// prune drops buckets that have not been used for idleLimiterTTL
+func (k *keyedLimiter) prune() {
+	cutoff := time.Now().Add(-idleLimiterTTL)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for key, entry := range k.limiters {
+		if entry.lastSeen.Before(cutoff) {
+			delete(k.limiters, key)
+		}
+	}
+}
+
+// rateLimiter enforces a RateLimitConfig, which can be swapped at runtime
+type rateLimiter struct {
+	config  atomic.Pointer[RateLimitConfig]
+	sources *keyedLimiter
+	ips     *keyedLimiter
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	rl := &rateLimiter{
+		sources: newKeyedLimiter(),
+		ips:     newKeyedLimiter(),
+	}
+	rl.config.Store(&config)
+	return rl
+}
+
+// update replaces the limits, starting every bucket afresh
+func (rl *rateLimiter) update(config RateLimitConfig) {
+	rl.config.Store(&config)
+	rl.sources.reset()
+	rl.ips.reset()
+}
+
+// allowSource takes a token for one event from source
+func (rl *rateLimiter) allowSource(source string) (bool, time.Duration) {
+	config := rl.config.Load()
+	limit, ok := config.Sources[source]
+	if !ok {
+		limit = config.PerSource
+	}
+	if !limit.enabled() {
+		return true, 0
+	}
+
+	allowed, retryAfter := rl.sources.allow(source, limit)
+	if !allowed {
+		rateLimited.WithLabelValues("source").Inc()
+	}
+	return allowed, retryAfter
+}
+
+// allowSources takes counts[source] tokens from each source, all or none,
+// for an atomic batch. It returns the sources over their limits, if any,
+// and how long until they are all under them.
+func (rl *rateLimiter) allowSources(counts map[string]int) ([]string, time.Duration) {
+	config := rl.config.Load()
+
+	limited := make(map[string]int, len(counts))
+	limits := make(map[string]RateLimit, len(counts))
+	for source, n := range counts {
+		limit, ok := config.Sources[source]
+		if !ok {
+			limit = config.PerSource
+		}
+		if limit.enabled() {
+			limited[source] = n
+			limits[source] = limit
+		}
+	}
+	if len(limited) == 0 {
+		return nil, 0
+	}
+
+	short, retryAfter := rl.sources.allowAll(limited, limits)
+	for _, source := range short {
+		rateLimited.WithLabelValues("source").Add(float64(counts[source]))
+	}
+	return short, retryAfter
+}
+
+// allowIP takes a token for one request from a client address
+func (rl *rateLimiter) allowIP(ip string) (bool, time.Duration) {
+	limit := rl.config.Load().PerIP
+	if !limit.enabled() {
+		return true, 0
+	}
+
+	allowed, retryAfter := rl.ips.allow(ip, limit)
+	if !allowed {
+		rateLimited.WithLabelValues("ip").Inc()
+	}
+	return allowed, retryAfter
+}
+
+// run prunes idle buckets until done is closed
+func (rl *rateLimiter) run(done <-chan struct{}) {
+	ticker := time.NewTicker(idleLimiterTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.sources.prune()
+			rl.ips.prune()
+		case <-done:
+			return
+		}
+	}
+}
+
+// allowSource applies the per-source limit
+func (s *Server) allowSource(source string) (bool, time.Duration) {
+	return s.limits.allowSource(source)
+}
+
+// allowSources applies the per-source limits to an atomic batch, which
+// counts[source] events from each source
+func (s *Server) allowSources(counts map[string]int) ([]string, time.Duration) {
+	return s.limits.allowSources(counts)
+}
+
+// rateLimitMiddleware rejects requests from client IPs over their limit
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed, retryAfter := s.limits.allowIP(clientIP(r)); !allowed {
+			s.writeRateLimited(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeRateLimited sends a 429 with a whole-second Retry-After
+func (s *Server) writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	s.writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+}
+
+// clientIP is the request's remote address without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// reloader is something that re-reads its configuration on SIGHUP
+type reloader struct {
+	name   string
+	reload func() error
+}
+
+// onReload registers fn to run on SIGHUP. A failed reload is logged and
+// leaves the previous configuration in place.
+func (s *Server) onReload(name string, fn func() error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.reloaders = append(s.reloaders, reloader{name: name, reload: fn})
+}
+
+// reload runs every registered reloader
+func (s *Server) reload() {
+	s.reloadMu.Lock()
+	reloaders := append([]reloader(nil), s.reloaders...)
+	s.reloadMu.Unlock()
+
+	for _, r := range reloaders {
+		if err := r.reload(); err != nil {
+			s.logger.Error("Reload failed", zap.String("component", r.name), zap.Error(err))
+			continue
+		}
+		s.logger.Info("Reloaded", zap.String("component", r.name))
+	}
+}
+
+// watchReloadSignal reloads configuration on SIGHUP
+func (s *Server) watchReloadSignal() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-sigChan:
+			s.reload()
+		case <-s.done:
+			return
+		}
+	}
+}
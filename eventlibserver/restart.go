@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+func init() {
+	registerFeature("processor-restart")
+}
+
+// errRestartUnsupported is returned for backends without a C queue to
+// recreate
+var errRestartUnsupported = errors.New("backend does not support restarting the processor")
+
+// restartProcessor recreates the processor's C queue with the settings
+// change makes from the current ones. Queued events are moved to the new
+// queue, so none are dropped.
+func (s *Server) restartProcessor(change func(*eventlib.EngineConfig)) (int, eventlib.EngineConfig, time.Duration, error) {
+	r, ok := s.processor.(eventlib.Restarter)
+	if !ok {
+		return 0, eventlib.EngineConfig{}, 0, errRestartUnsupported
+	}
+
+	config := r.EngineConfig()
+	change(&config)
+
+	start := time.Now()
+	requeued, err := r.Restart(config)
+	if err != nil {
+		return requeued, config, 0, err
+	}
+	return requeued, config, time.Since(start), nil
+}
+
+// handleAdminRestart recreates the processor's C queue, keeping the
+// events in it
+func (s *Server) handleAdminRestart(w http.ResponseWriter, r *http.Request) {
+	// An empty body restarts with the current settings
+	var req AdminRestartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.MaxQueueSize != nil && *req.MaxQueueSize < 0 {
+		s.writeError(w, http.StatusBadRequest, "Max queue size cannot be negative")
+		return
+	}
+	var mode eventlib.QueueMode
+	if req.QueueMode != nil {
+		var err error
+		if mode, err = parseQueueMode(*req.QueueMode); err != nil {
+			s.writeError(w, http.StatusBadRequest, "Queue mode must be fifo or priority")
+			return
+		}
+	}
+
+	requeued, config, took, err := s.restartProcessor(func(c *eventlib.EngineConfig) {
+		if req.MaxQueueSize != nil {
+			c.MaxQueueSize = *req.MaxQueueSize
+		}
+		if req.Logging != nil {
+			c.EnableLogging = *req.Logging
+		}
+		if req.QueueMode != nil {
+			c.QueueMode = mode
+		}
+	})
+	switch {
+	case errors.Is(err, errRestartUnsupported):
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support restarting the processor")
+		return
+	case errors.Is(err, eventlib.ErrQueueFull):
+		s.writeError(w, http.StatusConflict, "Queued events do not fit the new queue: "+err.Error())
+		return
+	case err != nil:
+		s.writeError(w, http.StatusConflict, "Failed to restart processor: "+err.Error())
+		return
+	}
+
+	s.auditAdmin(r, "restart",
+		zap.Int("requeued", requeued),
+		zap.Int("max_queue_size", config.MaxQueueSize),
+		zap.Bool("logging", config.EnableLogging),
+		zap.Stringer("queue_mode", config.QueueMode),
+		zap.Duration("duration", took))
+	s.writeJSON(w, http.StatusOK, AdminRestartResponse{
+		Requeued:     requeued,
+		MaxQueueSize: config.MaxQueueSize,
+		Logging:      config.EnableLogging,
+		QueueMode:    config.QueueMode.String(),
+		Duration:     took.String(),
+	})
+}
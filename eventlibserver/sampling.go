@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+var eventsSampled = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_events_sampled_out_total",
+	Help: "Total number of events dropped by each sampling rule",
+}, []string{"rule"})
+
+func init() {
+	registerFeature("sampling")
+}
+
+// SamplingConfig thins out events before they are queued, to keep the
+// queue manageable during event storms. The first matching rule decides
+// whether an event is kept; events no rule matches are kept. ERROR and
+// DISCONNECT events are never sampled.
+type SamplingConfig struct {
+	Rules []SamplingRule `json:"rules"`
+}
+
+// SamplingRule keeps a share of the events it matches, either one in
+// every Every or each with probability Rate
+type SamplingRule struct {
+	// Name identifies the rule in counts and logs
+	Name string `json:"name"`
+
+	// Types default to every type that can be sampled
+	Types []eventlib.EventType `json:"types,omitempty"`
+
+	// Sources are glob patterns, as for HandleSource
+	Sources []string `json:"sources,omitempty"`
+
+	// Every keeps the first of each run of Every matching events
+	Every int `json:"every,omitempty"`
+
+	// Rate keeps each matching event with this probability, above 0 and
+	// at most 1
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// sampledType reports whether events of type et may be dropped by
+// sampling. Errors and disconnects are rare and each one matters.
+func sampledType(et eventlib.EventType) bool {
+	return et != eventlib.EventTypeError && et != eventlib.EventTypeDisconnect
+}
+
+func (c *SamplingConfig) validate() error {
+	names := make(map[string]bool, len(c.Rules))
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		for _, et := range rule.Types {
+			if !sampledType(et) {
+				return fmt.Errorf("rule %q: %s events are never sampled", rule.Name, et)
+			}
+		}
+		for _, pattern := range rule.Sources {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %q: bad source pattern %q", rule.Name, pattern)
+			}
+		}
+		switch {
+		case (rule.Every != 0) == (rule.Rate != 0):
+			return fmt.Errorf("rule %q: set one of every and rate", rule.Name)
+		case rule.Every < 0:
+			return fmt.Errorf("rule %q: every must be positive", rule.Name)
+		case rule.Rate < 0 || rule.Rate > 1:
+			return fmt.Errorf("rule %q: rate must be above 0 and at most 1", rule.Name)
+		}
+	}
+	return nil
+}
+
+// loadSamplingConfig reads a sampling rule file
+func loadSamplingConfig(file string) (SamplingConfig, error) {
+	var config SamplingConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("%s: %w", file, err)
+	}
+	return config, nil
+}
+
+// samplingSet is a loaded SamplingConfig with its counts
+type samplingSet struct {
+	config  SamplingConfig
+	seen    []atomic.Uint64
+	dropped []atomic.Uint64
+}
+
+func newSamplingSet(config SamplingConfig) *samplingSet {
+	return &samplingSet{
+		config:  config,
+		seen:    make([]atomic.Uint64, len(config.Rules)),
+		dropped: make([]atomic.Uint64, len(config.Rules)),
+	}
+}
+
+// keep reports whether event survives sampling, and the rule that dropped
+// it if not
+func (ss *samplingSet) keep(event eventlib.Event) (bool, string) {
+	if !sampledType(event.Type) {
+		return true, ""
+	}
+	for i := range ss.config.Rules {
+		r := &ss.config.Rules[i]
+		if !r.matches(event) {
+			continue
+		}
+		n := ss.seen[i].Add(1)
+		var kept bool
+		if r.Every > 0 {
+			kept = (n-1)%uint64(r.Every) == 0
+		} else {
+			kept = rand.Float64() < r.Rate
+		}
+		if !kept {
+			ss.dropped[i].Add(1)
+		}
+		return kept, r.Name
+	}
+	return true, ""
+}
+
+func (r *SamplingRule) matches(event eventlib.Event) bool {
+	if len(r.Types) > 0 && !slices.Contains(r.Types, event.Type) {
+		return false
+	}
+	if len(r.Sources) == 0 {
+		return true
+	}
+	for _, pattern := range r.Sources {
+		if ok, _ := path.Match(pattern, event.Source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the rules with their counts
+func (ss *samplingSet) status() SamplingResponse {
+	resp := SamplingResponse{Rules: make([]SamplingRuleStatus, len(ss.config.Rules))}
+	for i, rule := range ss.config.Rules {
+		resp.Rules[i] = SamplingRuleStatus{
+			SamplingRule: rule,
+			Seen:         ss.seen[i].Load(),
+			Dropped:      ss.dropped[i].Load(),
+		}
+	}
+	return resp
+}
+
+// sampler holds the current samplingSet, which the admin API can replace
+// while events are being pushed
+type sampler struct {
+	current atomic.Pointer[samplingSet]
+	logger  *zap.Logger
+}
+
+func newSampler(config SamplingConfig, logger *zap.Logger) *sampler {
+	sm := &sampler{logger: logger}
+	sm.current.Store(newSamplingSet(config))
+	return sm
+}
+
+// transform is the processor Transformer that applies sampling. A dropped
+// event is counted as filtered by the processor, and a sync push reports
+// it as filtered.
+func (sm *sampler) transform(event eventlib.Event) (eventlib.Event, error) {
+	kept, rule := sm.current.Load().keep(event)
+	if kept {
+		return event, nil
+	}
+	eventsSampled.WithLabelValues(rule).Inc()
+	sm.logger.Debug("Event sampled out",
+		zap.String("source", event.Source),
+		zap.String("rule", rule))
+	return event, eventlib.ErrDropEvent
+}
+
+func (s *Server) handleGetSampling(w http.ResponseWriter, r *http.Request) {
+	if s.sampler == nil {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support sampling")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.sampler.current.Load().status())
+}
+
+// handlePutSampling replaces the sampling rules until the next restart.
+// Counts start again from zero.
+func (s *Server) handlePutSampling(w http.ResponseWriter, r *http.Request) {
+	if s.sampler == nil {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support sampling")
+		return
+	}
+
+	var config SamplingConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := config.validate(); err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid sampling rules: "+err.Error())
+		return
+	}
+
+	set := newSamplingSet(config)
+	s.sampler.current.Store(set)
+	s.auditAdmin(r, "sampling", zap.Int("rules", len(config.Rules)))
+	s.writeJSON(w, http.StatusOK, set.status())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// errSchedulingUnsupported refuses events with a future deliver time on
+// backends that cannot hold them
+var errSchedulingUnsupported = errors.New("scheduled delivery is not supported by this backend")
+
+// isScheduled reports whether event is to be delivered later
+func isScheduled(event eventlib.Event) bool {
+	return event.DeliverAt.After(time.Now())
+}
+
+// prepareScheduled checks the backend can hold event until its deliver
+// time and gives it an ID, so it can be listed and cancelled
+func (s *Server) prepareScheduled(event eventlib.Event) (eventlib.Event, error) {
+	if _, ok := s.processor.(eventlib.Scheduler); !ok {
+		return event, errSchedulingUnsupported
+	}
+	if event.ID == "" {
+		event.ID = randomEventID()
+	}
+	return event, nil
+}
+
+func (s *Server) handleListScheduled(w http.ResponseWriter, r *http.Request) {
+	scheduler, ok := s.processor.(eventlib.Scheduler)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Scheduled delivery not supported by this backend")
+		return
+	}
+
+	events := scheduler.Scheduled()
+	resp := ScheduledEventsResponse{
+		Events: make([]ScheduledEventResponse, 0, len(events)),
+		Count:  len(events),
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, ScheduledEventResponse{
+			Event:     newEventMessage(event),
+			DeliverAt: event.DeliverAt,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	scheduler, ok := s.processor.(eventlib.Scheduler)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Scheduled delivery not supported by this backend")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !scheduler.CancelScheduled(id) {
+		s.writeError(w, http.StatusNotFound, "No scheduled event with this ID")
+		return
+	}
+
+	s.logger.Info("Scheduled event cancelled", zap.String("event_id", id))
+	s.writeJSON(w, http.StatusOK, map[string]string{
+		"status":   "cancelled",
+		"event_id": id,
+	})
+}
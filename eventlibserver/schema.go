@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+var schemaFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "eventlibgo_http_schema_validation_failures_total",
+	Help: "Total number of events whose payload did not match their type's JSON Schema",
+}, []string{"type"})
+
+// schemaError reports a payload that does not match its type's schema
+type schemaError struct {
+	eventType  string
+	violations []SchemaViolation
+}
+
+func (e *schemaError) Error() string {
+	first := e.violations[0]
+	msg := fmt.Sprintf("payload does not match the %s schema: %s", e.eventType, first.Message)
+	if first.Path != "" {
+		msg = fmt.Sprintf("payload does not match the %s schema at %s: %s", e.eventType, first.Path, first.Message)
+	}
+	if n := len(e.violations) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// schemaRegistry holds a JSON Schema per event type, loaded from a
+// directory of <type>.json files such as DATA.json or 7.json
+type schemaRegistry struct {
+	dir     string
+	schemas atomic.Pointer[map[eventlib.EventType]*jsonschema.Schema]
+}
+
+func newSchemaRegistry(dir string) (*schemaRegistry, error) {
+	r := &schemaRegistry{dir: dir}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload recompiles every schema in the directory. On error the schemas
+// in use are kept.
+func (r *schemaRegistry) reload() error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read schema directory: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	schemas := make(map[eventlib.EventType]*jsonschema.Schema)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		eventType, err := eventlib.ParseEventType(name)
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", entry.Name(), err)
+		}
+		if _, ok := schemas[eventType]; ok {
+			return fmt.Errorf("schema %s: %s already has a schema", entry.Name(), eventType)
+		}
+
+		schema, err := compiler.Compile(filepath.Join(r.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", entry.Name(), err)
+		}
+		schemas[eventType] = schema
+	}
+
+	r.schemas.Store(&schemas)
+	return nil
+}
+
+// check validates event's payload against the schema for its type, if
+// there is one. A mismatch is returned as a *schemaError.
+func (r *schemaRegistry) check(event eventlib.Event) error {
+	if r == nil {
+		return nil
+	}
+	schema := (*r.schemas.Load())[event.Type]
+	if schema == nil {
+		return nil
+	}
+
+	violations := validatePayload(schema, event)
+	if len(violations) == 0 {
+		return nil
+	}
+	schemaFailures.WithLabelValues(event.Type.String()).Inc()
+	return &schemaError{eventType: event.Type.String(), violations: violations}
+}
+
+// validatePayload lists the ways event's payload fails schema
+func validatePayload(schema *jsonschema.Schema, event eventlib.Event) []SchemaViolation {
+	if event.ContentType != "" && !isJSONContentType(event.ContentType) {
+		return []SchemaViolation{{Message: fmt.Sprintf("content type %q is not JSON", event.ContentType)}}
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(event.Data))
+	if err != nil {
+		return []SchemaViolation{{Message: "payload is not valid JSON: " + err.Error()}}
+	}
+
+	err = schema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []SchemaViolation{{Message: err.Error()}}
+	}
+
+	// The basic output is a flat list; only its leaves carry messages
+	var violations []SchemaViolation
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		violations = append(violations, SchemaViolation{
+			Path:    unit.InstanceLocation,
+			Message: unit.Error.String(),
+		})
+	}
+	if len(violations) == 0 {
+		violations = append(violations, SchemaViolation{Message: verr.Error()})
+	}
+	return violations
+}
+
+// writeSchemaError answers a request whose payload failed its schema,
+// listing every violation
+func (s *Server) writeSchemaError(w http.ResponseWriter, err error) {
+	var serr *schemaError
+	if !errors.As(err, &serr) {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusUnprocessableEntity, SchemaErrorResponse{
+		Error:      "Payload does not match the schema for its type",
+		Type:       serr.eventType,
+		Violations: serr.violations,
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["user"],
+	"properties": {
+		"user": {
+			"type": "object",
+			"properties": {"id": {"type": "integer"}}
+		}
+	}
+}`
+
+func newTestSchemas(t *testing.T) *schemaRegistry {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "DATA.json"), []byte(testSchema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newSchemaRegistry(dir)
+	if err != nil {
+		t.Fatalf("newSchemaRegistry: %v", err)
+	}
+	return r
+}
+
+func TestSchemaViolationPath(t *testing.T) {
+	r := newTestSchemas(t)
+
+	tests := []struct {
+		name string
+		data string
+		path string
+	}{
+		{"nested", `{"user": {"id": "x"}}`, "/user/id"},
+		{"required", `{}`, ""},
+		{"not JSON", `{`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.check(eventlib.Event{Type: eventlib.EventTypeData, Data: []byte(tt.data)})
+			var serr *schemaError
+			if !errors.As(err, &serr) {
+				t.Fatalf("check = %v, want a *schemaError", err)
+			}
+			if len(serr.violations) != 1 {
+				t.Fatalf("violations = %+v, want one", serr.violations)
+			}
+			if v := serr.violations[0]; v.Path != tt.path || v.Message == "" {
+				t.Fatalf("violation = %+v, want path %q", v, tt.path)
+			}
+		})
+	}
+}
+
+func TestSchemaMatches(t *testing.T) {
+	r := newTestSchemas(t)
+
+	if err := r.check(eventlib.Event{Type: eventlib.EventTypeData, Data: []byte(`{"user": {"id": 7}}`)}); err != nil {
+		t.Fatalf("check matching payload: %v", err)
+	}
+	// Types without a schema are not checked
+	if err := r.check(eventlib.Event{Type: eventlib.EventTypeConnect, Data: []byte(`{`)}); err != nil {
+		t.Fatalf("check unschematized type: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// streamShutdownReason is sent to SSE and WebSocket subscribers as the
+// server drains
+const streamShutdownReason = "server shutting down"
+
+func init() {
+	registerFeature("http2")
+}
+
+// streamDrain tells long-lived streams to finish when the server shuts
+// down. http.Server.Shutdown waits for SSE handlers but not for hijacked
+// WebSocket connections, so both are tracked here.
+type streamDrain struct {
+	once     sync.Once
+	draining chan struct{}
+	streams  sync.WaitGroup
+}
+
+func newStreamDrain() *streamDrain {
+	return &streamDrain{draining: make(chan struct{})}
+}
+
+// start tells every stream to finish; later calls do nothing
+func (d *streamDrain) start() {
+	d.once.Do(func() { close(d.draining) })
+}
+
+// open registers a stream, returning false once draining has begun. A
+// stream that was let in calls done when it finishes.
+func (d *streamDrain) open() bool {
+	select {
+	case <-d.draining:
+		return false
+	default:
+	}
+	d.streams.Add(1)
+	return true
+}
+
+func (d *streamDrain) done() {
+	d.streams.Done()
+}
+
+// wait blocks until every stream has finished or ctx ends
+func (d *streamDrain) wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		d.streams.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refuseStream answers a stream request that arrives while the server is
+// draining, so the client reconnects to another instance
+func (s *Server) refuseStream(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	s.writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+}
+
+// shutdownHTTP stops accepting connections and new HTTP/2 streams, and
+// waits for in-flight requests and streams until ctx ends, when what is
+// left is closed. httpServer must call s.streams.start on shutdown.
+func (s *Server) shutdownHTTP(ctx context.Context, httpServer *http.Server) {
+	err := httpServer.Shutdown(ctx)
+	if err == nil {
+		err = s.streams.wait(ctx)
+	}
+	if err != nil {
+		s.logger.Warn("Closing connections still open at the shutdown timeout", zap.Error(err))
+		httpServer.Close()
+		return
+	}
+	s.logger.Info("Connections drained")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// restoreCgoProcessor builds the cgo processor from a snapshot file, for
+// starting where a stopped or migrated server left off
+func restoreCgoProcessor(path string) ProcessorFactory {
+	return func(config *eventlib.Config, handlers *eventlib.Handlers) (eventlib.Processor, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open snapshot: %w", err)
+		}
+		defer f.Close()
+		return eventlib.Restore(f, config, handlers)
+	}
+}
+
+// handleAdminSnapshot streams the processor's pending events and counters,
+// to be loaded by another server with -restore-snapshot. Processing is not
+// paused; stop the server first so nothing is handled twice.
+func (s *Server) handleAdminSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshotter, ok := s.processor.(eventlib.Snapshotter)
+	if !ok {
+		s.writeError(w, http.StatusNotImplemented, "Backend does not support snapshots")
+		return
+	}
+
+	// Buffered, so a failure can still be reported as an error response
+	var buf bytes.Buffer
+	if err := snapshotter.Snapshot(&buf); err != nil {
+		s.logger.Error("Snapshot failed", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, "Failed to take snapshot: "+err.Error())
+		return
+	}
+	s.auditAdmin(r, "snapshot", zap.Int("bytes", buf.Len()))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=eventlib-snapshot-%s.bin", time.Now().Format("20060102T150405")))
+	w.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(w); err != nil {
+		s.logger.Error("Snapshot stream failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+func init() {
+	registerFeature("stream:sse")
+}
+
+// streamFilter restricts a subscription to some event types and sources,
+// and optionally one topic
+type streamFilter struct {
+	types   map[string]bool
+	sources map[string]bool
+	topic   string
+}
+
+// parseStreamFilter reads comma-separated ?type= and ?source= parameters,
+// and the topic from a {topic} route or ?topic=
+func parseStreamFilter(r *http.Request) streamFilter {
+	split := func(key string) map[string]bool {
+		values := r.URL.Query()[key]
+		if len(values) == 0 {
+			return nil
+		}
+		set := make(map[string]bool)
+		for _, value := range values {
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					set[v] = true
+				}
+			}
+		}
+		return set
+	}
+
+	topic := mux.Vars(r)["topic"]
+	if topic == "" {
+		topic = r.URL.Query().Get("topic")
+	}
+
+	return streamFilter{
+		types:   split("type"),
+		sources: split("source"),
+		topic:   topic,
+	}
+}
+
+func (f streamFilter) match(msg EventMessage) bool {
+	if f.types != nil && !f.types[msg.Type] {
+		return false
+	}
+	if f.sources != nil && !f.sources[msg.Source] {
+		return false
+	}
+	if f.topic != "" && msg.Metadata[eventlib.TopicMetadataKey] != f.topic {
+		return false
+	}
+	return true
+}
+
+// handleSSE streams processed events as Server-Sent Events. Clients that
+// reconnect with Last-Event-ID receive the retained events they missed.
+// ?format=cloudevents sends each event as a structured CloudEvent.
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if !s.streams.open() {
+		s.refuseStream(w)
+		return
+	}
+	defer s.streams.done()
+
+	rc := http.NewResponseController(w)
+
+	// Streams outlive the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Debug("Cannot clear write deadline for SSE", zap.Error(err))
+	}
+
+	filter := parseStreamFilter(r)
+	cloudEvents := wantsCloudEvents(r)
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, "Invalid Last-Event-ID header")
+			return
+		}
+		lastID = id
+	}
+
+	// Subscribe before replaying so nothing published in between is lost
+	sub := s.broadcast.subscribe(subscriberSSE, streamBufferSize)
+	defer s.broadcast.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	s.logger.Info("SSE subscriber connected",
+		zap.String("remote", r.RemoteAddr),
+		zap.Uint64("last_event_id", lastID))
+
+	send := func(msg EventMessage) error {
+		if msg.ID <= lastID || !filter.match(msg) {
+			return nil
+		}
+		lastID = msg.ID
+
+		var v any = msg
+		if cloudEvents {
+			v = newCloudEvent(msg)
+		}
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Type, payload); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if lastID > 0 {
+		for _, msg := range s.broadcast.since(lastID) {
+			if err := send(msg); err != nil {
+				return
+			}
+		}
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case msg, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			s.logger.Info("SSE subscriber disconnected",
+				zap.String("remote", r.RemoteAddr))
+			return
+		case <-s.streams.draining:
+			// Clients reconnect, with Last-Event-ID, once the stream ends
+			fmt.Fprintf(w, "event: shutdown\ndata: {\"reason\":%q}\n\n", streamShutdownReason)
+			rc.Flush()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
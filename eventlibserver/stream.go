@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// Subprotocols negotiated on the streaming endpoint
+const (
+	subprotocolJSON = "eventlib.json"
+	subprotocolCBOR = "eventlib.cbor"
+)
+
+const (
+	streamBufferSize = 256
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+)
+
+var (
+	streamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_stream_clients",
+		Help: "Current number of streaming subscribers",
+	})
+
+	streamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "eventlibgo_http_stream_dropped_total",
+		Help: "Total number of events dropped for slow streaming subscribers",
+	})
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:    1024,
+	WriteBufferSize:   4096,
+	EnableCompression: true,
+	Subprotocols:      []string{subprotocolJSON, subprotocolCBOR},
+	CheckOrigin:       func(r *http.Request) bool { return true },
+}
+
+// newEventMessage converts a processed event for streaming, keeping the
+// original timestamp when the producer supplied one
+func newEventMessage(event eventlib.Event) EventMessage {
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return EventMessage{
+		eventType:   event.Type,
+		EventID:     event.ID,
+		Type:        event.Type.String(),
+		Source:      event.Source,
+		Data:        event.Data,
+		ContentType: event.ContentType,
+		Timestamp:   timestamp,
+
+		CorrelationID: event.CorrelationID,
+		Metadata:      event.Metadata,
+	}
+}
+
+// handleStream streams processed events over a WebSocket. Clients select
+// binary CBOR frames with the "eventlib.cbor" subprotocol or ?format=cbor;
+// JSON text frames are the default. ?format=cloudevents sends each event
+// as a structured CloudEvent. permessage-deflate is used whenever the
+// client offers it.
+//
+// With ?ack=true&subscriber=<name>, the client acks events by their id
+// with AckMessage frames, and events left unacked are sent again; see
+// StreamAckConfig.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !s.streams.open() {
+		s.refuseStream(w)
+		return
+	}
+	defer s.streams.done()
+
+	var acks *ackSubscriber
+	if r.URL.Query().Get("ack") == "true" {
+		name := r.URL.Query().Get("subscriber")
+		if name == "" || len(name) > maxSubscriberName {
+			s.writeError(w, http.StatusBadRequest,
+				fmt.Sprintf("ack=true needs a subscriber name of at most %d bytes", maxSubscriberName))
+			return
+		}
+		var err error
+		acks, err = s.acks.attach(name, s.broadcast.lastID())
+		if errors.Is(err, errTooManySubscribers) {
+			s.writeError(w, http.StatusServiceUnavailable, "Too many acknowledging subscribers; try again later")
+			return
+		}
+		if err != nil {
+			s.writeError(w, http.StatusConflict, fmt.Sprintf("Subscriber %q is already connected", name))
+			return
+		}
+		defer s.acks.detach(acks)
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Warn("WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	binary := conn.Subprotocol() == subprotocolCBOR ||
+		(conn.Subprotocol() == "" && r.URL.Query().Get("format") == "cbor")
+	cloudEvents := wantsCloudEvents(r)
+
+	conn.EnableWriteCompression(true)
+
+	filter := parseStreamFilter(r)
+
+	sub := s.broadcast.subscribe(subscriberWebSocket, streamBufferSize)
+	defer s.broadcast.unsubscribe(sub)
+
+	logger := s.logger.With(zap.String("remote", r.RemoteAddr))
+	if acks != nil {
+		logger = logger.With(zap.String("subscriber", acks.name))
+	}
+	logger.Info("Stream subscriber connected", zap.Bool("binary", binary))
+
+	// Read pump: handles pongs and acks, and notices when the client goes
+	// away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		if acks != nil {
+			conn.SetReadLimit(ackReadLimit)
+		}
+		conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		})
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if acks == nil {
+				continue
+			}
+			var ack AckMessage
+			if kind == websocket.BinaryMessage {
+				err = cbor.Unmarshal(data, &ack)
+			} else {
+				err = json.Unmarshal(data, &ack)
+			}
+			if err != nil {
+				logger.Debug("Invalid ack frame", zap.Error(err))
+				continue
+			}
+			acks.ack(ack.Ack)
+		}
+	}()
+
+	send := func(msg EventMessage) error {
+		var v any = msg
+		if cloudEvents {
+			ce := newCloudEvent(msg)
+			if acks != nil {
+				ce.Sequence = msg.ID
+			}
+			v = ce
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+		return writeStreamMessage(conn, v, binary)
+	}
+
+	// resend sends unacked events again: all of them on reconnecting, and
+	// afterwards those whose ack is overdue
+	resend := func(all bool) error {
+		msgs, expired := acks.due(all)
+		if expired > 0 {
+			logger.Warn("Gave up on unacked events", zap.Int("events", expired))
+		}
+		for _, msg := range msgs {
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// deliver sends msg if it passes the filter, recording it for an ack
+	deliver := func(msg EventMessage) error {
+		if !filter.match(msg) {
+			if acks != nil {
+				acks.advance(msg.ID)
+			}
+			return nil
+		}
+		if acks != nil {
+			acks.sent(msg)
+		}
+		return send(msg)
+	}
+
+	// catchUp delivers the events after the last one delivered from the
+	// replay buffer, for as long as there is room for more unacked events.
+	// Events that have already left the buffer are reported lost.
+	catchUp := func() error {
+		delivered := acks.deliveredID()
+		msgs := s.broadcast.since(delivered)
+		if len(msgs) > 0 && msgs[0].ID > delivered+1 {
+			lost := msgs[0].ID - delivered - 1
+			logger.Warn("Events left the replay buffer before they could be sent",
+				zap.Uint64("from", delivered+1), zap.Uint64("to", msgs[0].ID-1))
+			streamLost.Add(float64(lost))
+			acks.advance(msgs[0].ID - 1)
+		}
+		for _, msg := range msgs {
+			if acks.full() {
+				return nil
+			}
+			if err := deliver(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var ackCheck <-chan time.Time
+	var acked <-chan struct{}
+	if acks != nil {
+		// Catch up on what was sent last time and not acked, then on what
+		// was published while the subscriber was away
+		if err := resend(true); err != nil {
+			logger.Debug("Stream write failed", zap.Error(err))
+			return
+		}
+		if err := catchUp(); err != nil {
+			logger.Debug("Stream write failed", zap.Error(err))
+			return
+		}
+
+		ticker := time.NewTicker(ackCheckInterval)
+		defer ticker.Stop()
+		ackCheck = ticker.C
+		acked = acks.acked
+	}
+
+	ticker := time.NewTicker(streamPingPeriod)
+	defer ticker.Stop()
+
+	held := false
+	for {
+		// Hold back new events while too many await an ack. The stream
+		// queue may drop some meanwhile, so once there is room again they
+		// are sent from the replay buffer.
+		events := sub.ch
+		if acks != nil {
+			if acks.full() {
+				events = nil
+				held = true
+			} else if held {
+				held = false
+				if err := catchUp(); err != nil {
+					logger.Debug("Stream write failed", zap.Error(err))
+					return
+				}
+				continue
+			}
+		}
+
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				return
+			}
+			if acks != nil {
+				delivered := acks.deliveredID()
+				if msg.ID <= delivered {
+					continue
+				}
+				if msg.ID > delivered+1 {
+					// The stream queue dropped the events in between;
+					// msg is sent along with them
+					if err := catchUp(); err != nil {
+						logger.Debug("Stream write failed", zap.Error(err))
+						return
+					}
+					continue
+				}
+			}
+			if err := deliver(msg); err != nil {
+				logger.Debug("Stream write failed", zap.Error(err))
+				return
+			}
+		case <-acked:
+		case <-ackCheck:
+			if err := resend(false); err != nil {
+				logger.Debug("Stream write failed", zap.Error(err))
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			logger.Info("Stream subscriber disconnected")
+			return
+		case <-s.streams.draining:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, streamShutdownReason),
+				time.Now().Add(streamWriteWait))
+			return
+		}
+	}
+}
+
+// writeStreamMessage writes msg as a CBOR binary frame or a JSON text frame
+func writeStreamMessage(conn *websocket.Conn, msg any, binary bool) error {
+	if !binary {
+		return conn.WriteJSON(msg)
+	}
+
+	payload, err := cbor.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, payload)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"github.com/sammyjroberts/eventlibgo/zaplog"
+	"go.uber.org/zap"
+)
+
+// scopeAdminTenants guards creating and deleting tenants
+const scopeAdminTenants = "admin:tenants"
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+var (
+	errTenantExists   = errors.New("tenant already exists")
+	errTenantNotFound = errors.New("tenant not found")
+)
+
+var (
+	tenantEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_tenant_events_received_total",
+		Help: "Total number of events received for tenant processors",
+	}, []string{"tenant", "type"})
+
+	tenantEventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_tenant_events_processed_total",
+		Help: "Total number of events processed by tenant processors",
+	}, []string{"tenant", "type"})
+
+	tenantEventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_tenant_events_dropped_total",
+		Help: "Total number of tenant events expired or dead-lettered",
+	}, []string{"tenant", "reason"})
+
+	tenantQueueSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_tenant_queue_size",
+		Help: "Current event queue size of each tenant processor",
+	}, []string{"tenant"})
+)
+
+// TenantConfig describes a tenant created through the admin API
+type TenantConfig struct {
+	Name string `json:"name"`
+
+	// QueueSize is the tenant's queue capacity; defaults to the server's
+	QueueSize int `json:"queue_size,omitempty"`
+
+	// Scope is what callers need to reach the tenant's routes; defaults
+	// to "tenant:<name>"
+	Scope string `json:"scope,omitempty"`
+}
+
+func (c *TenantConfig) validate() error {
+	if !tenantNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("tenant name %q must be lowercase letters, digits, '-' or '_'", c.Name)
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("tenant queue_size must not be negative, got %d", c.QueueSize)
+	}
+	return nil
+}
+
+// tenant is a named processor hosted next to the server's own
+type tenant struct {
+	config    TenantConfig
+	processor eventlib.Processor
+	created   time.Time
+
+	// stop ends the tenant's processing loop; done is closed once it has
+	stop chan struct{}
+	done chan struct{}
+}
+
+// tenantRegistry holds the tenants and what is needed to build new ones:
+// a copy of the server's processor settings and backend
+type tenantRegistry struct {
+	mu      sync.RWMutex
+	tenants map[string]*tenant
+	closed  bool
+
+	base         eventlib.Config
+	newProcessor ProcessorFactory
+	interval     time.Duration
+}
+
+func newTenantRegistry(base eventlib.Config, newProcessor ProcessorFactory, interval time.Duration) *tenantRegistry {
+	// Journals and async buffers belong to the server's own processor;
+	// tenants are created at runtime and last until restart
+	base.PersistencePath = ""
+	base.AsyncPush = nil
+
+	return &tenantRegistry{
+		tenants:      make(map[string]*tenant),
+		base:         base,
+		newProcessor: newProcessor,
+		interval:     interval,
+	}
+}
+
+// get returns the tenant called name, or nil
+func (tr *tenantRegistry) get(name string) *tenant {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	return tr.tenants[name]
+}
+
+// list returns the tenants sorted by name
+func (tr *tenantRegistry) list() []*tenant {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+
+	out := make([]*tenant, 0, len(tr.tenants))
+	for _, t := range tr.tenants {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].config.Name < out[j].config.Name })
+	return out
+}
+
+// createTenant builds and starts a processor for a new tenant
+func (s *Server) createTenant(config TenantConfig) (*tenant, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Scope == "" {
+		config.Scope = "tenant:" + config.Name
+	}
+
+	tr := s.tenants
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.closed {
+		return nil, eventlib.ErrClosed
+	}
+	if _, ok := tr.tenants[config.Name]; ok {
+		return nil, errTenantExists
+	}
+
+	processorConfig := tr.base
+	processorConfig.Name = tr.base.Name + "." + config.Name
+	if config.QueueSize > 0 {
+		processorConfig.MaxQueueSize = config.QueueSize
+	}
+	config.QueueSize = processorConfig.MaxQueueSize
+	processorConfig.Logger = zaplog.New(s.logger.With(zap.String("tenant", config.Name)))
+
+	processor, err := tr.newProcessor(&processorConfig, s.tenantHandlers(config.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processor: %w", err)
+	}
+	if err := processor.Start(); err != nil {
+		processor.Close()
+		return nil, fmt.Errorf("failed to start processor: %w", err)
+	}
+
+	t := &tenant{
+		config:    config,
+		processor: processor,
+		created:   time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go t.run(tr.interval)
+	tr.tenants[config.Name] = t
+	return t, nil
+}
+
+// deleteTenant stops a tenant's processor, dropping whatever it still has
+// queued
+func (s *Server) deleteTenant(name string) error {
+	tr := s.tenants
+	tr.mu.Lock()
+	t, ok := tr.tenants[name]
+	delete(tr.tenants, name)
+	tr.mu.Unlock()
+	if !ok {
+		return errTenantNotFound
+	}
+
+	err := t.close()
+	tenantQueueSize.DeleteLabelValues(name)
+	return err
+}
+
+// close stops every tenant and refuses new ones
+func (tr *tenantRegistry) close() {
+	tr.mu.Lock()
+	tr.closed = true
+	tenants := tr.tenants
+	tr.tenants = make(map[string]*tenant)
+	tr.mu.Unlock()
+
+	for _, t := range tenants {
+		t.close()
+	}
+}
+
+// updateMetrics refreshes the per-tenant queue gauges
+func (tr *tenantRegistry) updateMetrics() {
+	for _, t := range tr.list() {
+		tenantQueueSize.WithLabelValues(t.config.Name).Set(float64(t.processor.QueueSize()))
+	}
+}
+
+// run drains the tenant's queue every interval; without an interval the
+// tenant is processed only through its /process route
+func (t *tenant) run(interval time.Duration) {
+	defer close(t.done)
+	if interval <= 0 {
+		<-t.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if t.processor.QueueSize() > 0 {
+				t.processor.ProcessAll()
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *tenant) close() error {
+	close(t.stop)
+	<-t.done
+	return t.processor.Close()
+}
+
+func (t *tenant) status() TenantResponse {
+	return TenantResponse{
+		Name:            t.config.Name,
+		Scope:           t.config.Scope,
+		State:           t.processor.State(),
+		QueueSize:       t.processor.QueueSize(),
+		MaxQueueSize:    t.config.QueueSize,
+		EventsProcessed: t.processor.EventsProcessed(),
+		Created:         t.created,
+	}
+}
+
+// tenantHandlers returns the handlers for a tenant's processor. Tenant
+// events are counted and logged but kept off the server's streams, Kafka
+// output and webhooks.
+func (s *Server) tenantHandlers(name string) *eventlib.Handlers {
+	logger := s.logger.With(zap.String("tenant", name))
+	return &eventlib.Handlers{
+		OnEvent: func(event eventlib.Event) error {
+			tenantEventsProcessed.WithLabelValues(name, event.Type.String()).Inc()
+			fields := []zap.Field{
+				zap.String("type", event.Type.String()),
+				zap.String("source", event.Source),
+				zap.Int("data_len", len(event.Data)),
+			}
+			if event.CorrelationID != "" {
+				fields = append(fields, zap.String("correlation_id", event.CorrelationID))
+			}
+			logger.Info("Event processed", fields...)
+			return nil
+		},
+		OnExpired: func(event eventlib.Event) {
+			tenantEventsDropped.WithLabelValues(name, "expired").Inc()
+			logger.Warn("Event expired before processing",
+				zap.String("type", event.Type.String()),
+				zap.String("source", event.Source))
+		},
+		OnDeadLetter: func(letter eventlib.DeadLetter) {
+			tenantEventsDropped.WithLabelValues(name, "dead-lettered").Inc()
+		},
+	}
+}
+
+// withTenant resolves the {tenant} route variable and checks the caller
+// holds the tenant's scope
+func (s *Server) withTenant(next func(w http.ResponseWriter, r *http.Request, t *tenant)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := s.tenants.get(mux.Vars(r)["tenant"])
+		if t == nil {
+			s.writeError(w, http.StatusNotFound, "Tenant not found")
+			return
+		}
+		s.requireScope(t.config.Scope, func(w http.ResponseWriter, r *http.Request) {
+			next(w, r, t)
+		})(w, r)
+	}
+}
+
+func (s *Server) handleTenantEvent(w http.ResponseWriter, r *http.Request, t *tenant) {
+	deadline, err := parseDeadline(r.Header.Get("X-Deadline"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid X-Deadline header")
+		return
+	}
+
+	req, err := readEventRequest(r)
+	if err != nil {
+		s.writeBodyError(w, err, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+
+	event := req.toEvent(deadline)
+	if err := s.schemas.check(event); err != nil {
+		s.writeSchemaError(w, err)
+		return
+	}
+	event = eventlib.WithTrace(r.Context(), event)
+
+	if err := t.processor.Push(event); err != nil {
+		s.writePushError(w, err)
+		return
+	}
+
+	tenantEventsReceived.WithLabelValues(t.config.Name, event.Type.String()).Inc()
+	s.writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "queued",
+		"tenant": t.config.Name,
+	})
+}
+
+func (s *Server) handleTenantProcess(w http.ResponseWriter, r *http.Request, t *tenant) {
+	start := time.Now()
+	before := t.processor.EventsProcessed()
+	t.processor.ProcessAll()
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "processed",
+		"processed": t.processor.EventsProcessed() - before,
+		"duration":  time.Since(start).String(),
+	})
+}
+
+func (s *Server) handleTenantStatus(w http.ResponseWriter, r *http.Request, t *tenant) {
+	s.writeJSON(w, http.StatusOK, t.status())
+}
+
+func (s *Server) handleListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants := s.tenants.list()
+	resp := TenantListResponse{Tenants: make([]TenantResponse, 0, len(tenants))}
+	for _, t := range tenants {
+		resp.Tenants = append(resp.Tenants, t.status())
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCreateTenant(w http.ResponseWriter, r *http.Request) {
+	var config TenantConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	t, err := s.createTenant(config)
+	switch {
+	case errors.Is(err, errTenantExists):
+		s.writeError(w, http.StatusConflict, "Tenant already exists")
+		return
+	case errors.Is(err, eventlib.ErrClosed):
+		s.writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+		return
+	case err != nil:
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.auditAdmin(r, "create tenant",
+		zap.String("tenant", t.config.Name),
+		zap.Int("queue_size", t.config.QueueSize))
+	s.writeJSON(w, http.StatusCreated, t.status())
+}
+
+func (s *Server) handleDeleteTenant(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["tenant"]
+	if err := s.deleteTenant(name); err != nil {
+		if errors.Is(err, errTenantNotFound) {
+			s.writeError(w, http.StatusNotFound, "Tenant not found")
+			return
+		}
+		s.logger.Warn("Failed to close tenant processor",
+			zap.String("tenant", name), zap.Error(err))
+	}
+
+	s.auditAdmin(r, "delete tenant", zap.String("tenant", name))
+	s.writeJSON(w, http.StatusOK, map[string]string{
+		"status": "deleted",
+		"tenant": name,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// serveTenant runs handler as the router would for a /tenants/{tenant}
+// route
+func serveTenant(handler http.HandlerFunc, method, target, tenant, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", contentTypeJSON)
+	}
+	r = mux.SetURLVars(r, map[string]string{"tenant": tenant})
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestTenantLifecycle(t *testing.T) {
+	s := newTestServer(t, Options{})
+
+	w := serve(s.handleCreateTenant, http.MethodPost, "/admin/tenants",
+		`{"name": "acme", "queue_size": 10}`)
+	checkStatus(t, w, http.StatusCreated)
+	var created TenantResponse
+	decodeBody(t, w, &created)
+	if created.Name != "acme" || created.Scope != "tenant:acme" || created.MaxQueueSize != 10 {
+		t.Fatalf("created %+v", created)
+	}
+
+	w = serveTenant(s.withTenant(s.handleTenantEvent), http.MethodPost,
+		"/tenants/acme/events", "acme", `{"source": "s", "data": "aGVsbG8="}`)
+	checkStatus(t, w, http.StatusAccepted)
+
+	w = serveTenant(s.withTenant(s.handleTenantProcess), http.MethodPost,
+		"/tenants/acme/process", "acme", "")
+	checkStatus(t, w, http.StatusOK)
+	var processed struct {
+		Processed int `json:"processed"`
+	}
+	decodeBody(t, w, &processed)
+	if processed.Processed != 1 {
+		t.Fatalf("processed %d events, want 1", processed.Processed)
+	}
+
+	// The tenant's events stay out of the server's own processor
+	if n := s.processor.EventsProcessed(); n != 0 {
+		t.Fatalf("server processed %d tenant events", n)
+	}
+
+	w = serve(s.handleListTenants, http.MethodGet, "/admin/tenants", "")
+	checkStatus(t, w, http.StatusOK)
+	var list TenantListResponse
+	decodeBody(t, w, &list)
+	if len(list.Tenants) != 1 || list.Tenants[0].EventsProcessed != 1 {
+		t.Fatalf("tenants %+v", list.Tenants)
+	}
+
+	w = serveTenant(s.handleDeleteTenant, http.MethodDelete, "/admin/tenants/acme", "acme", "")
+	checkStatus(t, w, http.StatusOK)
+	w = serveTenant(s.withTenant(s.handleTenantStatus), http.MethodGet, "/tenants/acme", "acme", "")
+	checkStatus(t, w, http.StatusNotFound)
+}
+
+func TestCreateTenantErrors(t *testing.T) {
+	s := newTestServer(t, Options{})
+
+	w := serve(s.handleCreateTenant, http.MethodPost, "/admin/tenants", `{"name": "acme"}`)
+	checkStatus(t, w, http.StatusCreated)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"duplicate", `{"name": "acme"}`, http.StatusConflict},
+		{"invalid name", `{"name": "Acme Corp"}`, http.StatusBadRequest},
+		{"negative queue size", `{"name": "other", "queue_size": -1}`, http.StatusBadRequest},
+		{"malformed", `{"name":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s.handleCreateTenant, http.MethodPost, "/admin/tenants", tt.body)
+			checkStatus(t, w, tt.want)
+		})
+	}
+
+	w = serveTenant(s.handleDeleteTenant, http.MethodDelete, "/admin/tenants/missing", "missing", "")
+	checkStatus(t, w, http.StatusNotFound)
+}
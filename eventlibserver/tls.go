@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tlsFileCheckInterval is how often certificate files are checked for
+// changes on disk
+const tlsFileCheckInterval = 10 * time.Second
+
+// tlsFiles names the files behind a TLS configuration
+type tlsFiles struct {
+	cert, key, clientCA string
+}
+
+// tlsFilesFromFlags reads the -tls-* and -client-ca flags
+func tlsFilesFromFlags() tlsFiles {
+	return tlsFiles{cert: *tlsCert, key: *tlsKey, clientCA: *clientCA}
+}
+
+// tlsState is a loaded certificate and, for mutual TLS, the pool of CAs
+// client certificates must chain to
+type tlsState struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// certReloader serves a TLS certificate and client CA pool that can be
+// replaced while the server runs, so certificates rotate without a restart
+type certReloader struct {
+	state  atomic.Pointer[tlsState]
+	logger *zap.Logger
+
+	mu       sync.Mutex // Serializes loads
+	files    tlsFiles
+	modTimes map[string]time.Time
+}
+
+func newCertReloader(files tlsFiles, logger *zap.Logger) (*certReloader, error) {
+	c := &certReloader{logger: logger}
+	if err := c.load(files); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the files, keeping the current state on error
+func (c *certReloader) load(files tlsFiles) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{files.cert, files.key, files.clientCA} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS file: %w", err)
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(files.cert, files.key)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	state := &tlsState{cert: &cert}
+
+	if files.clientCA != "" {
+		pem, err := os.ReadFile(files.clientCA)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		state.clientCAs = x509.NewCertPool()
+		if !state.clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA %s", files.clientCA)
+		}
+	}
+
+	c.state.Store(state)
+	c.files = files
+	c.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether any file was modified since the last load
+func (c *certReloader) changed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, modTime := range c.modTimes {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Mid-rotation, perhaps; check again next time
+			continue
+		}
+		if !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the files whenever they change on disk, until done is
+// closed
+func (c *certReloader) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(tlsFileCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+			c.mu.Lock()
+			files := c.files
+			c.mu.Unlock()
+			if err := c.load(files); err != nil {
+				c.logger.Error("Failed to reload TLS files", zap.Error(err))
+				continue
+			}
+			c.logger.Info("Reloaded TLS files after change on disk")
+		case <-done:
+			return
+		}
+	}
+}
+
+// config returns a server TLS config that picks up reloaded state on every
+// handshake. Client certificates are required once a client CA is set.
+func (c *certReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			state := c.state.Load()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*state.cert},
+			}
+			if state.clientCAs != nil {
+				config.ClientCAs = state.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
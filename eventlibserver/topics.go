@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+var (
+	topicEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_http_topic_events_received_total",
+		Help: "Total number of events received for each topic",
+	}, []string{"topic", "type"})
+
+	topicQueueSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_http_topic_queue_size",
+		Help: "Current event queue size of each topic",
+	}, []string{"topic"})
+)
+
+func init() {
+	registerFeature("topics")
+}
+
+// parseTopics reads a -topics value: comma-separated topic names, each
+// optionally followed by =<queue size>, as in orders=5000,audit
+func parseTopics(value string) (map[string]eventlib.TopicConfig, error) {
+	topics := make(map[string]eventlib.TopicConfig)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, size, hasSize := strings.Cut(item, "=")
+		var config eventlib.TopicConfig
+		if hasSize {
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid queue size %q for topic %s", size, name)
+			}
+			config.MaxQueueSize = n
+		}
+		if _, ok := topics[name]; ok {
+			return nil, fmt.Errorf("topic %s is listed twice", name)
+		}
+		topics[name] = config
+	}
+	return topics, nil
+}
+
+// runTopics drains every topic each interval until the server closes;
+// without an interval topics are processed only through their /process
+// route
+func (s *Server) runTopics(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.topics.QueueSize() > 0 {
+				s.topics.ProcessAllTopics()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// updateTopicMetrics refreshes the per-topic queue gauges
+func (s *Server) updateTopicMetrics() {
+	for _, name := range s.topics.Topics() {
+		if ep, err := s.topics.Topic(name); err == nil {
+			topicQueueSize.WithLabelValues(name).Set(float64(ep.QueueSize()))
+		}
+	}
+}
+
+func topicStatus(name string, ep *eventlib.EventProcessor) TopicResponse {
+	return TopicResponse{
+		Name:            name,
+		State:           ep.State(),
+		QueueSize:       ep.QueueSize(),
+		MaxQueueSize:    ep.MaxQueueSize(),
+		EventsProcessed: ep.EventsProcessed(),
+	}
+}
+
+// withTopic resolves the {topic} route variable
+func (s *Server) withTopic(next func(w http.ResponseWriter, r *http.Request, name string, ep *eventlib.EventProcessor)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["topic"]
+		ep, err := s.topics.Topic(name)
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, "Topic not found")
+			return
+		}
+		next(w, r, name, ep)
+	}
+}
+
+func (s *Server) handleListTopics(w http.ResponseWriter, r *http.Request) {
+	resp := TopicListResponse{Topics: []TopicResponse{}}
+	for _, name := range s.topics.Topics() {
+		if ep, err := s.topics.Topic(name); err == nil {
+			resp.Topics = append(resp.Topics, topicStatus(name, ep))
+		}
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleTopicStatus(w http.ResponseWriter, r *http.Request, name string, ep *eventlib.EventProcessor) {
+	s.writeJSON(w, http.StatusOK, topicStatus(name, ep))
+}
+
+// handleTopicSSE streams the events processed on one topic, as
+// /events/sse?topic=
+func (s *Server) handleTopicSSE(w http.ResponseWriter, r *http.Request, name string, ep *eventlib.EventProcessor) {
+	s.handleSSE(w, r)
+}
+
+// handleTopicEvent queues an event on one topic. Topic events go through
+// the same filters, handlers and outputs as the rest, with the topic in
+// their metadata.
+func (s *Server) handleTopicEvent(w http.ResponseWriter, r *http.Request, name string, ep *eventlib.EventProcessor) {
+	deadline, err := parseDeadline(r.Header.Get("X-Deadline"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "Invalid X-Deadline header")
+		return
+	}
+
+	req, err := readEventRequest(r)
+	if err != nil {
+		s.writeBodyError(w, err, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.CorrelationID == "" {
+		req.CorrelationID = r.Header.Get("X-Correlation-ID")
+	}
+
+	event := req.toEvent(deadline)
+	if err := s.schemas.check(event); err != nil {
+		s.drops.record(event, err.Error())
+		s.writeSchemaError(w, err)
+		return
+	}
+	if allowed, retryAfter := s.allowSource(event.Source); !allowed {
+		s.drops.record(event, "rate limited")
+		s.writeRateLimited(w, retryAfter)
+		return
+	}
+	event = eventlib.WithTrace(r.Context(), event)
+
+	if err := s.topics.PushContext(r.Context(), name, event); err != nil {
+		if errors.Is(err, eventlib.ErrUnknownTopic) {
+			s.writeError(w, http.StatusNotFound, "Topic not found")
+			return
+		}
+		s.drops.record(event, err.Error())
+		s.writePushError(w, err)
+		return
+	}
+
+	topicEventsReceived.WithLabelValues(name, event.Type.String()).Inc()
+	s.writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "queued",
+		"topic":  name,
+	})
+}
+
+func (s *Server) handleTopicProcess(w http.ResponseWriter, r *http.Request, name string, ep *eventlib.EventProcessor) {
+	start := time.Now()
+	before := ep.EventsProcessed()
+	if err := ep.ProcessAllContext(r.Context()); err != nil {
+		s.logger.Warn("Processing cancelled", zap.String("topic", name), zap.Error(err))
+	}
+	observeTrace(r.Context(), processingDuration, time.Since(start).Seconds())
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":    "processed",
+		"topic":     name,
+		"processed": ep.EventsProcessed() - before,
+		"duration":  time.Since(start).String(),
+	})
+}
+
+// handlePutTopic creates a topic, or changes the queue limit of one that
+// exists
+func (s *Server) handlePutTopic(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["topic"]
+	var req TopicRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.QueueSize < 0 {
+		s.writeError(w, http.StatusBadRequest, "queue_size must not be negative")
+		return
+	}
+
+	status := http.StatusOK
+	if ep, err := s.topics.Topic(name); err == nil {
+		if err := ep.SetMaxQueueSize(req.QueueSize); err != nil {
+			s.writeError(w, http.StatusBadRequest, "Failed to set queue size: "+err.Error())
+			return
+		}
+	} else {
+		err := s.topics.AddTopic(name, eventlib.TopicConfig{MaxQueueSize: req.QueueSize})
+		switch {
+		case errors.Is(err, eventlib.ErrClosed):
+			s.writeError(w, http.StatusServiceUnavailable, "Server is shutting down")
+			return
+		case err != nil:
+			s.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status = http.StatusCreated
+	}
+
+	ep, err := s.topics.Topic(name)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, "Topic not found")
+		return
+	}
+	s.auditAdmin(r, "set topic", zap.String("topic", name), zap.Int("queue_size", req.QueueSize))
+	s.writeJSON(w, status, topicStatus(name, ep))
+}
+
+// handleDeleteTopic removes a topic, dropping whatever it still has queued
+func (s *Server) handleDeleteTopic(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["topic"]
+	if err := s.topics.RemoveTopic(name); err != nil {
+		if errors.Is(err, eventlib.ErrUnknownTopic) {
+			s.writeError(w, http.StatusNotFound, "Topic not found")
+			return
+		}
+		s.logger.Warn("Failed to close topic", zap.String("topic", name), zap.Error(err))
+	}
+	topicQueueSize.DeleteLabelValues(name)
+
+	s.auditAdmin(r, "delete topic", zap.String("topic", name))
+	s.writeJSON(w, http.StatusOK, map[string]string{
+		"status": "deleted",
+		"topic":  name,
+	})
+}
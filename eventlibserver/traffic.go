@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+const (
+	// defaultStatsMaxSources is how many sources /stats counts separately
+	// unless -stats-max-sources says otherwise
+	defaultStatsMaxSources = 1000
+
+	// trafficOtherSource collects the sources seen after the limit is
+	// reached
+	trafficOtherSource = "(other)"
+)
+
+// trafficCounts is the running tally behind one TrafficStats entry
+type trafficCounts struct {
+	processed uint64
+	filtered  uint64
+	failed    uint64
+	expired   uint64
+
+	latencyTotal time.Duration
+	latencyCount uint64
+	lastSeen     time.Time
+}
+
+// trafficStats breaks processing counts down by event type and by source.
+// Sources beyond maxSources share one entry, so a producer that invents
+// sources cannot grow the map without bound.
+type trafficStats struct {
+	maxSources int
+
+	mu       sync.Mutex
+	byType   map[string]*trafficCounts
+	bySource map[string]*trafficCounts
+}
+
+func newTrafficStats(maxSources int) *trafficStats {
+	return &trafficStats{
+		maxSources: maxSources,
+		byType:     make(map[string]*trafficCounts),
+		bySource:   make(map[string]*trafficCounts),
+	}
+}
+
+// Outcomes recorded by trafficStats
+const (
+	trafficProcessed = iota
+	trafficFiltered
+	trafficFailed
+	trafficExpired
+)
+
+// record counts one outcome for event
+func (t *trafficStats) record(event eventlib.Event, outcome int) {
+	now := time.Now()
+	latency := time.Duration(0)
+	if outcome == trafficProcessed {
+		latency = event.QueueLatency()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	source := event.Source
+	if _, ok := t.bySource[source]; !ok && len(t.bySource) >= t.maxSources {
+		source = trafficOtherSource
+	}
+	for _, c := range []*trafficCounts{
+		trafficEntry(t.byType, event.Type.String()),
+		trafficEntry(t.bySource, source),
+	} {
+		switch outcome {
+		case trafficProcessed:
+			c.processed++
+			if latency > 0 {
+				c.latencyTotal += latency
+				c.latencyCount++
+			}
+		case trafficFiltered:
+			c.filtered++
+		case trafficFailed:
+			c.failed++
+		case trafficExpired:
+			c.expired++
+		}
+		c.lastSeen = now
+	}
+}
+
+// trafficEntry returns the counts for key, adding them if need be
+func trafficEntry(m map[string]*trafficCounts, key string) *trafficCounts {
+	c, ok := m[key]
+	if !ok {
+		c = &trafficCounts{}
+		m[key] = c
+	}
+	return c
+}
+
+// snapshot returns the counts by type and the top sources by events
+// processed, all of them if top is 0
+func (t *trafficStats) snapshot(top int) (map[string]TrafficStats, []SourceTrafficStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byType := make(map[string]TrafficStats, len(t.byType))
+	for name, c := range t.byType {
+		byType[name] = c.stats()
+	}
+
+	sources := make([]SourceTrafficStats, 0, len(t.bySource))
+	for name, c := range t.bySource {
+		sources = append(sources, SourceTrafficStats{Source: name, TrafficStats: c.stats()})
+	}
+	slices.SortFunc(sources, func(a, b SourceTrafficStats) int {
+		if c := cmp.Compare(b.Processed, a.Processed); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Source, b.Source)
+	})
+	if top > 0 && len(sources) > top {
+		sources = sources[:top]
+	}
+	return byType, sources
+}
+
+func (c *trafficCounts) stats() TrafficStats {
+	stats := TrafficStats{
+		Processed: c.processed,
+		Filtered:  c.filtered,
+		Failed:    c.failed,
+		Expired:   c.expired,
+		LastSeen:  c.lastSeen,
+	}
+	if c.latencyCount > 0 {
+		stats.AvgQueueLatency = (c.latencyTotal / time.Duration(c.latencyCount)).Seconds()
+	}
+	return stats
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+func TestStatsByTypeAndSource(t *testing.T) {
+	s := newTestServer(t, Options{StatsMaxSources: 2})
+	processEvents(t, s,
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "a"},
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "b"},
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "b"},
+		eventlib.Event{Type: eventlib.EventTypeConnect, Source: "b"},
+		eventlib.Event{Type: eventlib.EventTypeData, Source: "c"})
+
+	w := serve(s.handleStats, http.MethodGet, "/api/v1/stats", "")
+	checkStatus(t, w, http.StatusOK)
+	var stats StatsResponse
+	decodeBody(t, w, &stats)
+
+	if data, connect := stats.ByType["DATA"], stats.ByType["CONNECT"]; data.Processed != 4 || connect.Processed != 1 {
+		t.Fatalf("by type %+v", stats.ByType)
+	}
+	if data := stats.ByType["DATA"]; data.LastSeen.IsZero() {
+		t.Fatal("DATA has no last seen time")
+	}
+
+	// Busiest first; c came after the limit of two sources
+	var got []string
+	for _, source := range stats.BySource {
+		got = append(got, source.Source)
+	}
+	if len(stats.BySource) != 3 || stats.BySource[0].Source != "b" || stats.BySource[0].Processed != 3 {
+		t.Fatalf("by source %q: %+v", got, stats.BySource)
+	}
+	for _, source := range stats.BySource[1:] {
+		if source.Source != "a" && source.Source != trafficOtherSource || source.Processed != 1 {
+			t.Fatalf("by source %q: %+v", got, stats.BySource)
+		}
+	}
+
+	w = serve(s.handleStats, http.MethodGet, "/api/v1/stats?top=1", "")
+	checkStatus(t, w, http.StatusOK)
+	stats = StatsResponse{}
+	decodeBody(t, w, &stats)
+	if len(stats.BySource) != 1 || stats.BySource[0].Source != "b" {
+		t.Fatalf("top=1 gave %+v, want only b", stats.BySource)
+	}
+}
+
+func TestStatsInvalidTop(t *testing.T) {
+	s := newTestServer(t, Options{})
+
+	for _, top := range []string{"-1", "many"} {
+		w := serve(s.handleStats, http.MethodGet, "/api/v1/stats?top="+top, "")
+		checkStatus(t, w, http.StatusBadRequest)
+	}
+}
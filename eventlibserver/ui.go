@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// uiFiles is the dashboard served at /ui/. It only calls the public API,
+// so it sees what its token's scopes allow.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func init() {
+	registerFeature("ui")
+}
+
+// registerUI serves the dashboard under /ui/
+func registerUI(router *mux.Router) {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	router.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently)).Methods("GET")
+	router.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", uiHandler(http.FileServerFS(files)))).Methods("GET")
+}
+
+// uiHandler keeps browsers from caching the dashboard across upgrades and
+// from framing it
+func uiHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Content-Security-Policy", "default-src 'self'; connect-src 'self'; frame-ancestors 'none'")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,300 @@
+// Dashboard for eventlib-server. It polls /api/v1/stats (or /status on
+// backends without stats) and /filters, and follows /events/sse with fetch
+// rather than EventSource so requests can carry the API token.
+"use strict";
+
+const API = "/api/v1";
+const POLL_MS = 2000;
+const HISTORY = 150; // Chart samples, five minutes at POLL_MS
+const MAX_EVENTS = 100;
+const MAX_STATES = 20;
+const TYPE_NAMES = { 0: "DATA", 1: "CONNECT", 2: "DISCONNECT", 3: "ERROR" };
+
+const $ = (id) => document.getElementById(id);
+
+let token = sessionStorage.getItem("eventlib-token") || "";
+let lastState = null;
+let lastSample = null;
+let samples = [];
+let stream = null;
+
+function headers() {
+  return token ? { Authorization: "Bearer " + token } : {};
+}
+
+function showError(message) {
+  const el = $("error");
+  el.textContent = message;
+  el.hidden = !message;
+}
+
+async function getJSON(path) {
+  const res = await fetch(API + path, { headers: headers() });
+  if (!res.ok) {
+    let message = res.status + " " + res.statusText;
+    try {
+      const body = await res.json();
+      if (body.error) message = body.error;
+    } catch (e) {}
+    const err = new Error(path + ": " + message);
+    err.status = res.status;
+    throw err;
+  }
+  return res.json();
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) td.className = className;
+  return td;
+}
+
+function formatNumber(n) {
+  return n === undefined || n === null ? "–" : Number(n).toLocaleString();
+}
+
+function formatUptime(seconds) {
+  if (seconds === undefined) return "–";
+  const s = Math.floor(seconds);
+  const d = Math.floor(s / 86400), h = Math.floor(s / 3600) % 24, m = Math.floor(s / 60) % 60;
+  if (d) return d + "d " + h + "h";
+  if (h) return h + "h " + m + "m";
+  return m + "m " + (s % 60) + "s";
+}
+
+function formatTime(t) {
+  return new Date(t).toLocaleTimeString();
+}
+
+// Status
+
+let statsSupported = true;
+
+async function pollStatus() {
+  let status;
+  try {
+    if (statsSupported) {
+      try {
+        status = await getJSON("/stats");
+      } catch (err) {
+        if (err.status !== 501) throw err;
+        statsSupported = false;
+      }
+    }
+    if (!statsSupported) {
+      status = await getJSON("/status");
+      status.processed = status.events_processed;
+    }
+  } catch (err) {
+    setState("unreachable");
+    showError(err.message);
+    return;
+  }
+  showError("");
+  setState(status.state);
+
+  const now = Date.now();
+  let rate = null;
+  if (lastSample && now > lastSample.time) {
+    rate = Math.max(0, (status.processed - lastSample.processed) * 1000 / (now - lastSample.time));
+  }
+  lastSample = { time: now, processed: status.processed };
+
+  $("queue").textContent = formatNumber(status.queue_size);
+  $("rate").textContent = rate === null ? "–" : rate.toFixed(1);
+  $("processed").textContent = formatNumber(status.processed);
+  $("dropped").textContent = formatNumber(status.dropped);
+  $("filtered").textContent = formatNumber(status.filtered);
+  $("uptime").textContent = formatUptime(status.uptime_seconds);
+
+  samples.push({ queue: status.queue_size, rate: rate || 0 });
+  if (samples.length > HISTORY) samples.shift();
+  drawChart();
+}
+
+// setState shows the processor state and records when it changes. Changes
+// are seen at the polling interval, so a brief stop and start can be missed.
+function setState(state) {
+  const el = $("state");
+  el.textContent = state;
+  el.className = "state " + state;
+  if (lastState !== null && state !== lastState) {
+    const row = $("states").insertRow(0);
+    cell(row, new Date().toLocaleTimeString());
+    cell(row, lastState);
+    cell(row, state);
+    while ($("states").rows.length > MAX_STATES) $("states").deleteRow(-1);
+  }
+  lastState = state;
+}
+
+function drawChart() {
+  const canvas = $("chart");
+  const ratio = window.devicePixelRatio || 1;
+  const width = canvas.clientWidth, height = canvas.clientHeight;
+  canvas.width = width * ratio;
+  canvas.height = height * ratio;
+  const ctx = canvas.getContext("2d");
+  ctx.scale(ratio, ratio);
+  ctx.clearRect(0, 0, width, height);
+
+  const style = getComputedStyle(document.documentElement);
+  const series = [
+    { key: "queue", label: "queue depth", color: style.getPropertyValue("--warn") },
+    { key: "rate", label: "processed/s", color: style.getPropertyValue("--accent") },
+  ];
+  const step = width / (HISTORY - 1);
+  const offset = HISTORY - samples.length;
+
+  series.forEach((s, i) => {
+    const max = Math.max(1, ...samples.map((p) => p[s.key]));
+    ctx.strokeStyle = s.color;
+    ctx.lineWidth = 2;
+    ctx.beginPath();
+    samples.forEach((p, j) => {
+      const x = (offset + j) * step;
+      const y = height - 4 - (p[s.key] / max) * (height - 24);
+      j ? ctx.lineTo(x, y) : ctx.moveTo(x, y);
+    });
+    ctx.stroke();
+
+    ctx.fillStyle = s.color;
+    ctx.font = "12px system-ui, sans-serif";
+    ctx.fillText(s.label + " (max " + formatNumber(Math.round(max * 10) / 10) + ")", 8 + i * 200, 14);
+  });
+}
+
+// Filters
+
+async function pollFilters() {
+  let filters;
+  try {
+    filters = await getJSON("/filters");
+  } catch (err) {
+    return; // Shown by pollStatus if the server is unreachable
+  }
+  $("filter-default").textContent = "default: " + filters.default;
+  const body = $("filters");
+  body.replaceChildren();
+  for (const rule of filters.rules || []) {
+    const row = body.insertRow();
+    cell(row, rule.name);
+    cell(row, rule.action);
+    cell(row, describeRule(rule));
+    cell(row, formatNumber(rule.hits));
+  }
+  if (!body.rows.length) {
+    cell(body.insertRow(), "No rules").colSpan = 4;
+  }
+}
+
+function describeRule(rule) {
+  const parts = [];
+  if (rule.types) parts.push("type " + rule.types.map((t) => TYPE_NAMES[t] || t).join("|"));
+  if (rule.sources) parts.push("source " + rule.sources.join("|"));
+  if (rule.min_data_size !== undefined) parts.push("size ≥ " + rule.min_data_size);
+  if (rule.max_data_size !== undefined) parts.push("size ≤ " + rule.max_data_size);
+  if (rule.expr) parts.push(rule.expr);
+  if (rule.rate) parts.push("over " + rule.rate.rate + "/s");
+  return parts.join(", ") || "any event";
+}
+
+// Events
+
+function decodeData(data) {
+  if (!data) return "";
+  try {
+    return atob(data);
+  } catch (e) {
+    return data;
+  }
+}
+
+function addEvent(msg) {
+  const row = $("events").insertRow(0);
+  row.className = msg.type;
+  cell(row, msg.id);
+  cell(row, formatTime(msg.timestamp));
+  cell(row, msg.type);
+  cell(row, msg.source);
+  const data = decodeData(msg.data);
+  cell(row, data, "data").title = data;
+  while ($("events").rows.length > MAX_EVENTS) $("events").deleteRow(-1);
+}
+
+// followEvents reads the SSE stream until it ends, then reconnects from
+// the last event seen
+async function followEvents() {
+  let lastID = "";
+  for (;;) {
+    const controller = new AbortController();
+    stream = controller;
+    const status = $("stream-status");
+    try {
+      const h = headers();
+      if (lastID) h["Last-Event-ID"] = lastID;
+      const res = await fetch(API + "/events/sse", { headers: h, signal: controller.signal });
+      if (!res.ok) throw new Error(res.status + " " + res.statusText);
+      status.textContent = "live";
+
+      const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = "";
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf("\n\n")) >= 0) {
+          const frame = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          const msg = parseFrame(frame);
+          if (msg.id) lastID = msg.id;
+          if (msg.event === "shutdown") break;
+          if (msg.data) addEvent(JSON.parse(msg.data));
+        }
+      }
+    } catch (err) {
+      if (controller.signal.aborted && stream !== controller) return;
+      status.textContent = "disconnected: " + err.message;
+    }
+    if (stream !== controller) return;
+    status.textContent = "reconnecting";
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+  }
+}
+
+function parseFrame(frame) {
+  const msg = {};
+  for (const line of frame.split("\n")) {
+    const colon = line.indexOf(":");
+    if (colon <= 0) continue;
+    const field = line.slice(0, colon);
+    const value = line.slice(colon + 1).replace(/^ /, "");
+    msg[field] = field === "data" && msg.data ? msg.data + "\n" + value : value;
+  }
+  return msg;
+}
+
+// Startup
+
+function connect() {
+  if (stream) stream.abort();
+  stream = null;
+  $("events").replaceChildren();
+  pollStatus();
+  pollFilters();
+  followEvents();
+}
+
+$("token").value = token;
+$("auth").addEventListener("submit", (e) => {
+  e.preventDefault();
+  token = $("token").value.trim();
+  sessionStorage.setItem("eventlib-token", token);
+  connect();
+});
+
+setInterval(pollStatus, POLL_MS);
+setInterval(pollFilters, POLL_MS * 5);
+connect();
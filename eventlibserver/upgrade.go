@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Zero-downtime upgrades: on SIGUSR2 the running server re-executes its own
+// binary and hands the listening sockets to the child as inherited file
+// descriptors. Once the child reports that it is serving, the parent stops
+// accepting requests, drains its queue and exits, so no connection is refused
+// and no queued event is dropped during a routine binary swap. With a
+// write-ahead log the child reports ready before opening it instead, and
+// waits for the parent to drain and close it.
+
+const (
+	// envInheritedFDs lists the names of the inherited listeners, in fd order
+	envInheritedFDs = "EVENTLIB_INHERITED_FDS"
+
+	// readyFDName names the pipe the child uses to signal readiness
+	readyFDName = "ready"
+
+	// upgradeTimeout bounds how long the parent waits for the child
+	upgradeTimeout = 30 * time.Second
+)
+
+type fileListener interface {
+	net.Listener
+	File() (*os.File, error)
+}
+
+// upgrader manages listener inheritance across binary upgrades
+type upgrader struct {
+	logger    *zap.Logger
+	inherited map[string]*os.File
+	names     []string
+	listeners map[string]fileListener
+	ready     *os.File
+	upgraded  chan struct{}
+}
+
+// newUpgrader picks up any listeners passed down by a parent process
+func newUpgrader(logger *zap.Logger) *upgrader {
+	u := &upgrader{
+		logger:    logger,
+		inherited: make(map[string]*os.File),
+		listeners: make(map[string]fileListener),
+		upgraded:  make(chan struct{}),
+	}
+
+	names := os.Getenv(envInheritedFDs)
+	if names == "" {
+		for name, f := range systemdListeners(logger) {
+			u.inherited[name] = f
+		}
+		return u
+	}
+	os.Unsetenv(envInheritedFDs)
+
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		if name == readyFDName {
+			u.ready = f
+			continue
+		}
+		u.inherited[name] = f
+	}
+
+	logger.Info("Inherited listeners from parent process",
+		zap.Int("count", len(u.inherited)))
+
+	return u
+}
+
+// listen returns the inherited listener for name, or binds a new one on
+// addr; see listenAddr
+func (u *upgrader) listen(name, addr string, socketMode fs.FileMode) (net.Listener, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+
+	if f, ok := u.inherited[name]; ok {
+		ln, err = net.FileListener(f)
+		f.Close()
+		delete(u.inherited, name)
+	} else {
+		ln, err = listenAddr(addr, socketMode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for %s: %w", name, err)
+	}
+
+	fl, ok := ln.(fileListener)
+	if !ok {
+		ln.Close()
+		return nil, fmt.Errorf("listener for %s cannot be inherited", name)
+	}
+
+	u.names = append(u.names, name)
+	u.listeners[name] = fl
+	return fl, nil
+}
+
+// Upgrading reports whether this process was started by a parent handing
+// over to it, and has not yet told it to stand down
+func (u *upgrader) Upgrading() bool {
+	return u.ready != nil
+}
+
+// Ready tells the parent process, if any, that this process is serving
+func (u *upgrader) Ready() {
+	if u.ready == nil {
+		return
+	}
+	u.ready.Write([]byte{1})
+	u.ready.Close()
+	u.ready = nil
+}
+
+// Upgraded is closed once a child process has taken over the listeners
+func (u *upgrader) Upgraded() <-chan struct{} {
+	return u.upgraded
+}
+
+// watch upgrades the process whenever SIGUSR2 is received
+func (u *upgrader) watch() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR2)
+
+	for range sigChan {
+		u.logger.Info("Upgrade requested, starting new process")
+
+		if err := u.upgrade(); err != nil {
+			u.logger.Error("Upgrade failed", zap.Error(err))
+			continue
+		}
+
+		u.logger.Info("New process is serving, handing off")
+		signal.Stop(sigChan)
+		close(u.upgraded)
+		return
+	}
+}
+
+// upgrade starts a copy of the current binary and waits until it is ready
+func (u *upgrader) upgrade() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, name := range u.names {
+		f, err := u.listeners[name].File()
+		if err != nil {
+			return fmt.Errorf("failed to duplicate %s listener: %w", name, err)
+		}
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	names := append(append([]string{}, u.names...), readyFDName)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envInheritedFDs+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = files
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	// Only the child should hold the write end, so that a crashing child
+	// unblocks the read below with EOF
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := r.Read(buf); err != nil {
+			ready <- fmt.Errorf("new process exited before becoming ready: %w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Wait()
+			return err
+		}
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("timed out waiting for new process")
+	}
+
+	u.logger.Info("New process ready", zap.Int("pid", cmd.Process.Pid))
+
+	// The child outlives us, so there is nothing left to wait for
+	cmd.Process.Release()
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	eventlib "github.com/sammyjroberts/eventlibgo"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var (
+	featuresMu sync.Mutex
+	features   []string
+)
+
+// registerFeature records an optional capability compiled into the binary,
+// so /version shows exactly what a deployment can do
+func registerFeature(name string) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+
+	features = append(features, name)
+}
+
+func init() {
+	registerFeature("backend:cgo")
+	registerFeature("backend:redis")
+	registerFeature("stream:websocket")
+	registerFeature("autotune")
+}
+
+// versionInfo describes this build of the server and its C library
+func versionInfo() VersionResponse {
+	resp := VersionResponse{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				resp.Revision = setting.Value
+			case "vcs.time":
+				resp.RevisionTime = setting.Value
+			case "vcs.modified":
+				resp.Modified = setting.Value == "true"
+			case "CGO_ENABLED":
+				resp.CgoEnabled = setting.Value == "1"
+			case "-tags":
+				resp.BuildTags = setting.Value
+			}
+		}
+	}
+
+	lib, err := eventlib.Library()
+	resp.Library = LibraryResponse{
+		Version:    lib.Version,
+		BuildFlags: lib.BuildFlags,
+		Linkage:    lib.Linkage,
+	}
+	if err != nil {
+		resp.Library.Error = err.Error()
+	}
+
+	featuresMu.Lock()
+	resp.Features = append([]string(nil), features...)
+	featuresMu.Unlock()
+	sort.Strings(resp.Features)
+
+	return resp
+}
+
+// buildInfo reports the module versions and VCS settings of this binary
+func buildInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info["main"] = bi.Main.Path + "@" + bi.Main.Version
+
+	settings := make(map[string]string)
+	for _, setting := range bi.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	info["settings"] = settings
+
+	deps := make(map[string]string)
+	for _, dep := range bi.Deps {
+		deps[dep.Path] = dep.Version
+	}
+	info["deps"] = deps
+
+	return info
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, versionInfo())
+}
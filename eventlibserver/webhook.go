@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	eventlib "github.com/sammyjroberts/eventlibgo"
+	"go.uber.org/zap"
+)
+
+// Headers set on every webhook delivery
+const (
+	webhookHeaderEvent     = "X-Eventlib-Event"
+	webhookHeaderSource    = "X-Eventlib-Source"
+	webhookHeaderTimestamp = "X-Eventlib-Timestamp"
+	webhookHeaderSignature = "X-Eventlib-Signature"
+)
+
+const (
+	webhookDefaultQueueSize = 1000
+	webhookDefaultTimeout   = 10 * time.Second
+	webhookCloseTimeout     = 10 * time.Second
+)
+
+var (
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_webhook_deliveries_total",
+		Help: "Total number of webhook deliveries by outcome: delivered, failed, short_circuited or dropped",
+	}, []string{"destination", "result"})
+
+	webhookRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_webhook_retries_total",
+		Help: "Total number of webhook delivery retries",
+	}, []string{"destination"})
+
+	webhookDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eventlibgo_webhook_request_duration_seconds",
+		Help:    "Time taken by each webhook request",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"destination"})
+
+	webhookQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_webhook_queue_size",
+		Help: "Events waiting to be delivered to each webhook",
+	}, []string{"destination"})
+
+	webhookBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eventlibgo_webhook_circuit_state",
+		Help: "State of each webhook's circuit breaker: 0 closed, 1 open, 2 half-open",
+	}, []string{"destination"})
+
+	webhookBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "eventlibgo_webhook_circuit_transitions_total",
+		Help: "Total number of webhook circuit breaker state changes, by the state entered",
+	}, []string{"destination", "state"})
+)
+
+// duration reads a JSON string such as "500ms" or "2s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// WebhookConfig is the JSON file given with -webhook-config
+type WebhookConfig struct {
+	Destinations []WebhookDestination `json:"destinations"`
+}
+
+// WebhookDestination is one endpoint processed events are POSTed to
+type WebhookDestination struct {
+	// Name labels the destination's metrics and logs; defaults to the URL
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Types and Sources filter the events sent; empty sends everything.
+	// Sources are glob patterns, as for HandleSource.
+	Types   []eventlib.EventType `json:"types,omitempty"`
+	Sources []string             `json:"sources,omitempty"`
+
+	// Secret, if set, signs each request with HMAC-SHA256
+	Secret string `json:"secret,omitempty"`
+
+	// Headers are added to every request, e.g. for authorization
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Timeout bounds each request; default 10s
+	Timeout duration `json:"timeout,omitempty"`
+
+	// MaxAttempts, InitialBackoff and MaxBackoff control retries of
+	// failed requests; they default as for the processor's retries
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	InitialBackoff duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     duration `json:"max_backoff,omitempty"`
+
+	// QueueSize is how many events may wait for delivery before new ones
+	// are dropped; default 1000
+	QueueSize int `json:"queue_size,omitempty"`
+
+	// Format is empty for the event JSON streams carry, or "cloudevents"
+	// for structured CloudEvents
+	Format string `json:"format,omitempty"`
+
+	// CircuitBreaker, if set, stops sending to a destination that keeps
+	// failing; its deliveries fail at once while the breaker is open
+	CircuitBreaker *WebhookBreaker `json:"circuit_breaker,omitempty"`
+}
+
+// WebhookBreaker configures a destination's circuit breaker. Zero fields
+// take the eventlib.BreakerConfig defaults.
+type WebhookBreaker struct {
+	FailureRate    float64  `json:"failure_rate,omitempty"`
+	MinRequests    int      `json:"min_requests,omitempty"`
+	Window         duration `json:"window,omitempty"`
+	OpenTimeout    duration `json:"open_timeout,omitempty"`
+	HalfOpenProbes int      `json:"half_open_probes,omitempty"`
+}
+
+// breakerConfig returns the eventlib form of b for the named destination
+func (b WebhookBreaker) breakerConfig(name string) eventlib.BreakerConfig {
+	return eventlib.BreakerConfig{
+		Name:           name,
+		FailureRate:    b.FailureRate,
+		MinRequests:    b.MinRequests,
+		Window:         time.Duration(b.Window),
+		OpenTimeout:    time.Duration(b.OpenTimeout),
+		HalfOpenProbes: b.HalfOpenProbes,
+	}
+}
+
+// loadWebhookConfig reads and checks a webhook file
+func loadWebhookConfig(file string) (WebhookConfig, error) {
+	var config WebhookConfig
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Destinations {
+		d := &config.Destinations[i]
+		if d.URL == "" {
+			return config, fmt.Errorf("%s: destination %d has no url", file, i)
+		}
+		if d.Name == "" {
+			d.Name = d.URL
+		}
+		if names[d.Name] {
+			return config, fmt.Errorf("%s: duplicate destination %q", file, d.Name)
+		}
+		names[d.Name] = true
+		for _, pattern := range d.Sources {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return config, fmt.Errorf("%s: destination %q: bad source pattern %q", file, d.Name, pattern)
+			}
+		}
+		if d.MaxAttempts < 0 || d.QueueSize < 0 || d.Timeout < 0 {
+			return config, fmt.Errorf("%s: destination %q: negative setting", file, d.Name)
+		}
+		if d.Format != "" && d.Format != formatCloudEvents {
+			return config, fmt.Errorf("%s: destination %q: unknown format %q", file, d.Name, d.Format)
+		}
+		if d.CircuitBreaker != nil {
+			if _, err := eventlib.NewCircuitBreaker(d.CircuitBreaker.breakerConfig(d.Name)); err != nil {
+				return config, fmt.Errorf("%s: destination %q: %w", file, d.Name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// webhookDelivery is an event waiting to be sent
+type webhookDelivery struct {
+	event EventMessage
+	body  []byte
+}
+
+// webhook delivers events to one destination from its own queue, so a
+// slow endpoint holds up neither the processor nor other destinations
+type webhook struct {
+	dest   WebhookDestination
+	retry  eventlib.RetryPolicy
+	client *http.Client
+	logger *zap.Logger
+
+	// breaker, nil without a circuit breaker
+	breaker *eventlib.CircuitBreaker
+
+	queue chan webhookDelivery
+	done  chan struct{}
+}
+
+func newWebhook(ctx context.Context, dest WebhookDestination, logger *zap.Logger) *webhook {
+	timeout := time.Duration(dest.Timeout)
+	if timeout == 0 {
+		timeout = webhookDefaultTimeout
+	}
+	queueSize := dest.QueueSize
+	if queueSize == 0 {
+		queueSize = webhookDefaultQueueSize
+	}
+
+	w := &webhook{
+		dest: dest,
+		retry: eventlib.RetryPolicy{
+			MaxAttempts:    dest.MaxAttempts,
+			InitialBackoff: time.Duration(dest.InitialBackoff),
+			MaxBackoff:     time.Duration(dest.MaxBackoff),
+			Jitter:         0.2,
+		},
+		client: &http.Client{Timeout: timeout},
+		logger: logger.With(zap.String("webhook", dest.Name)),
+		queue:  make(chan webhookDelivery, queueSize),
+		done:   make(chan struct{}),
+	}
+	if dest.CircuitBreaker != nil {
+		config := dest.CircuitBreaker.breakerConfig(dest.Name)
+		config.OnStateChange = w.onBreakerChange
+		// The config was checked by loadWebhookConfig
+		w.breaker, _ = eventlib.NewCircuitBreaker(config)
+		webhookBreakerState.WithLabelValues(dest.Name).Set(float64(eventlib.BreakerClosed))
+	}
+	go w.run(ctx)
+	return w
+}
+
+// matches reports whether the destination wants event
+func (w *webhook) matches(msg EventMessage) bool {
+	if len(w.dest.Types) > 0 && !slices.Contains(w.dest.Types, msg.eventType) {
+		return false
+	}
+	if len(w.dest.Sources) == 0 {
+		return true
+	}
+	for _, pattern := range w.dest.Sources {
+		if ok, _ := path.Match(pattern, msg.Source); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues a delivery without blocking, dropping it if the queue
+// is full
+func (w *webhook) enqueue(d webhookDelivery) {
+	select {
+	case w.queue <- d:
+		webhookQueued.WithLabelValues(w.dest.Name).Inc()
+	default:
+		webhookDeliveries.WithLabelValues(w.dest.Name, "dropped").Inc()
+		w.logger.Warn("Webhook queue full, dropping event",
+			zap.String("type", d.event.Type),
+			zap.String("source", d.event.Source))
+	}
+}
+
+func (w *webhook) run(ctx context.Context) {
+	defer close(w.done)
+
+	for d := range w.queue {
+		webhookQueued.WithLabelValues(w.dest.Name).Dec()
+		err := w.deliver(ctx, d)
+		if errors.Is(err, eventlib.ErrCircuitOpen) {
+			webhookDeliveries.WithLabelValues(w.dest.Name, "short_circuited").Inc()
+			continue
+		}
+		if err != nil {
+			webhookDeliveries.WithLabelValues(w.dest.Name, "failed").Inc()
+			w.logger.Warn("Webhook delivery failed",
+				zap.String("type", d.event.Type),
+				zap.String("source", d.event.Source),
+				zap.Error(err))
+			continue
+		}
+		webhookDeliveries.WithLabelValues(w.dest.Name, "delivered").Inc()
+	}
+}
+
+// deliver sends d, retrying network errors, timeouts, 429s and 5xxs with
+// backoff. Once the circuit breaker opens, it gives up with an error
+// wrapping ErrCircuitOpen.
+func (w *webhook) deliver(ctx context.Context, d webhookDelivery) error {
+	attempts := w.retry.Attempts()
+	for attempt := 1; ; attempt++ {
+		if w.breaker != nil {
+			if err := w.breaker.Allow(); err != nil {
+				return err
+			}
+		}
+		retryable, err := w.send(ctx, d)
+		if w.breaker != nil {
+			// Only failures of the destination count against it, not
+			// requests it refuses as bad
+			if retryable {
+				w.breaker.Record(err)
+			} else {
+				w.breaker.Record(nil)
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= attempts {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+
+		webhookRetries.WithLabelValues(w.dest.Name).Inc()
+		select {
+		case <-time.After(w.retry.Backoff(attempt)):
+		case <-ctx.Done():
+			return fmt.Errorf("gave up retrying at shutdown: %w", err)
+		}
+	}
+}
+
+// send makes one request, reporting whether a failure is worth retrying
+func (w *webhook) send(ctx context.Context, d webhookDelivery) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.dest.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentTypeJSON)
+	if w.dest.Format == formatCloudEvents {
+		req.Header.Set("Content-Type", contentTypeCloudEvent)
+	}
+	req.Header.Set(webhookHeaderEvent, d.event.Type)
+	req.Header.Set(webhookHeaderSource, d.event.Source)
+	req.Header.Set(webhookHeaderTimestamp, timestamp)
+	if w.dest.Secret != "" {
+		req.Header.Set(webhookHeaderSignature, "sha256="+signWebhook(w.dest.Secret, timestamp, d.body))
+	}
+	for k, v := range w.dest.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	webhookDuration.WithLabelValues(w.dest.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+// onBreakerChange records and logs a circuit breaker transition
+func (w *webhook) onBreakerChange(_ string, from, to eventlib.BreakerState) {
+	webhookBreakerState.WithLabelValues(w.dest.Name).Set(float64(to))
+	webhookBreakerTransitions.WithLabelValues(w.dest.Name, to.String()).Inc()
+	if to == eventlib.BreakerOpen {
+		w.logger.Warn("Webhook circuit breaker opened",
+			zap.Stringer("from", from))
+		return
+	}
+	w.logger.Info("Webhook circuit breaker changed state",
+		zap.Stringer("from", from),
+		zap.Stringer("to", to))
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>". Signing
+// the timestamp lets receivers reject replayed requests.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSink fans processed events out to every matching destination
+type webhookSink struct {
+	hooks  []*webhook
+	cancel context.CancelFunc
+	logger *zap.Logger
+}
+
+func newWebhookSink(config WebhookConfig, logger *zap.Logger) *webhookSink {
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &webhookSink{cancel: cancel, logger: logger}
+	for _, dest := range config.Destinations {
+		sink.hooks = append(sink.hooks, newWebhook(ctx, dest, logger))
+	}
+	return sink
+}
+
+// publish queues event for every destination whose filters match. Each
+// format is encoded once, however many destinations use it.
+func (sink *webhookSink) publish(msg EventMessage) {
+	bodies := make(map[string][]byte, 1)
+	for _, w := range sink.hooks {
+		if !w.matches(msg) {
+			continue
+		}
+		body, ok := bodies[w.dest.Format]
+		if !ok {
+			var v any = msg
+			if w.dest.Format == formatCloudEvents {
+				v = newCloudEvent(msg)
+			}
+			var err error
+			if body, err = json.Marshal(v); err != nil {
+				sink.logger.Error("Failed to encode webhook event", zap.Error(err))
+				return
+			}
+			bodies[w.dest.Format] = body
+		}
+		w.enqueue(webhookDelivery{event: msg, body: body})
+	}
+}
+
+// backlogged returns an error naming the destinations whose queues are
+// full, and so dropping events
+func (sink *webhookSink) backlogged() error {
+	var full []string
+	for _, w := range sink.hooks {
+		if len(w.queue) == cap(w.queue) {
+			full = append(full, w.dest.Name)
+		}
+	}
+	if len(full) > 0 {
+		return fmt.Errorf("delivery queue full for %s", strings.Join(full, ", "))
+	}
+	return nil
+}
+
+// close delivers what is queued. Once the close timeout passes, requests
+// in flight are cancelled and the rest fail without being sent.
+func (sink *webhookSink) close() {
+	for _, w := range sink.hooks {
+		close(w.queue)
+	}
+
+	timeout := time.AfterFunc(webhookCloseTimeout, sink.cancel)
+	defer timeout.Stop()
+	defer sink.cancel()
+
+	for _, w := range sink.hooks {
+		<-w.done
+	}
+}